@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/aggregator.html
+
+// This source file contains implementation of cluster list read from
+// insights-results-aggregator admin endpoint. The aggregator decides which
+// clusters are orphaned, so the cleaner and the aggregator always agree on
+// the list of clusters to cleanup.
+//
+// The endpoint is configured in [aggregator] section of the configuration
+// file, cluster list is read from the endpoint when -clusters-from-aggregator
+// command line option is specified. The endpoint is expected to return JSON
+// object with list of cluster IDs in "clusters" attribute and "ok" in
+// "status" attribute.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultOrphanedClustersEndpoint is the aggregator endpoint used when no
+// endpoint is specified in configuration
+const defaultOrphanedClustersEndpoint = "/api/v1/admin/clusters/orphaned"
+
+// statusOK is the status returned by aggregator for successful requests
+const statusOK = "ok"
+
+// orphanedClustersResponse represents response of aggregator endpoint with
+// list of orphaned clusters
+type orphanedClustersResponse struct {
+	Clusters []string `json:"clusters"`
+	Status   string   `json:"status"`
+}
+
+// aggregatorEndpointURL function constructs URL of aggregator endpoint with
+// list of orphaned clusters
+func aggregatorEndpointURL(aggregatorConfig AggregatorConfiguration) string {
+	endpoint := aggregatorConfig.Endpoint
+	if endpoint == "" {
+		endpoint = defaultOrphanedClustersEndpoint
+	}
+	return strings.TrimSuffix(aggregatorConfig.URL, "/") + "/" + strings.TrimPrefix(endpoint, "/")
+}
+
+// readClusterListFromAggregator function reads list of orphaned clusters
+// from insights-results-aggregator admin endpoint
+func readClusterListFromAggregator(aggregatorConfig AggregatorConfiguration) (ClusterList, int, error) {
+	log.Debug().Msg("Cluster list read from aggregator endpoint")
+
+	improperClusterCounter := 0
+	var clusterList = make([]ClusterName, 0)
+
+	if aggregatorConfig.URL == "" {
+		return clusterList, improperClusterCounter, errors.New("aggregator URL is not specified in configuration")
+	}
+
+	// timeout has been checked together with the whole configuration
+	timeout, err := requestTimeout(aggregatorConfig.Timeout)
+	if err != nil {
+		return clusterList, improperClusterCounter, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, aggregatorEndpointURL(aggregatorConfig), http.NoBody)
+	if err != nil {
+		return clusterList, improperClusterCounter, err
+	}
+	request.Header.Set("Accept", "application/json")
+	switch {
+	case aggregatorConfig.Username != "":
+		request.SetBasicAuth(aggregatorConfig.Username, aggregatorConfig.Password)
+	case aggregatorConfig.Token != "":
+		request.Header.Set("Authorization", "Bearer "+aggregatorConfig.Token)
+	}
+
+	var response orphanedClustersResponse
+	err = getJSON(&http.Client{Timeout: timeout}, request, &response)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to read orphaned clusters from aggregator")
+		return clusterList, improperClusterCounter, err
+	}
+	if response.Status != statusOK {
+		return clusterList, improperClusterCounter,
+			fmt.Errorf("aggregator returned status '%s'", response.Status)
+	}
+
+	for _, cluster := range response.Clusters {
+		cluster = strings.TrimSpace(cluster)
+		// check if the response contains proper cluster ID (as UUID)
+		if IsValidUUID(cluster) {
+			clusterList = append(clusterList, ClusterName(cluster))
+			log.Info().Str(inputWithClusterID, cluster).Msg(properClusterID)
+		} else {
+			log.Error().Str(inputWithClusterID, cluster).Msg(notProperClusterID)
+			improperClusterCounter++
+		}
+	}
+	log.Info().Int(numberOfClustersToDelete, len(clusterList)).Msg(clusterListFinished)
+	log.Info().Int(improperClusterEntries, improperClusterCounter).Msg(clusterListFinished)
+
+	return clusterList, improperClusterCounter, nil
+}
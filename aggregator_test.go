@@ -0,0 +1,111 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/aggregator_test.html
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestAggregatorEndpointURL checks construction of aggregator endpoint URL
+func TestAggregatorEndpointURL(t *testing.T) {
+	assert.Equal(t, "http://aggregator:8080/api/v1/admin/clusters/orphaned",
+		cleaner.AggregatorEndpointURL(cleaner.AggregatorConfiguration{URL: "http://aggregator:8080/"}))
+	assert.Equal(t, "http://aggregator:8080/orphans",
+		cleaner.AggregatorEndpointURL(cleaner.AggregatorConfiguration{
+			URL:      "http://aggregator:8080",
+			Endpoint: "orphans",
+		}))
+}
+
+// TestReadClusterListFromAggregator checks that orphaned clusters are read
+// from aggregator endpoint
+func TestReadClusterListFromAggregator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/clusters/orphaned", r.URL.Path)
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", username)
+		assert.Equal(t, "secret", password)
+
+		_, err := fmt.Fprintf(w, `{"clusters": ["%s", "foobar", "%s"], "status": "ok"}`,
+			cluster1ID, cluster2ID)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	clusterList, improper, err := cleaner.ReadClusterListFromAggregator(cleaner.AggregatorConfiguration{
+		URL:      server.URL,
+		Username: "admin",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, improper)
+}
+
+// TestReadClusterListFromAggregatorToken checks that bearer token is sent to
+// aggregator endpoint and that status of the response is checked
+func TestReadClusterListFromAggregatorToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		_, err := w.Write([]byte(`{"status": "internal error"}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	_, _, err := cleaner.ReadClusterListFromAggregator(cleaner.AggregatorConfiguration{
+		URL:   server.URL,
+		Token: "token",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "internal error")
+}
+
+// TestReadClusterListFromAggregatorOnError checks that errors are reported
+// when aggregator endpoint can not be used
+func TestReadClusterListFromAggregatorOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	// URL is not configured
+	_, _, err := cleaner.ReadClusterListFromAggregator(cleaner.AggregatorConfiguration{})
+	assert.Error(t, err)
+
+	_, _, err = cleaner.ReadClusterListFromAggregator(cleaner.AggregatorConfiguration{URL: server.URL})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	// incorrect timeout
+	_, _, err = cleaner.ReadClusterListFromAggregator(cleaner.AggregatorConfiguration{
+		URL:     server.URL,
+		Timeout: "soon",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/analyze.html
+
+// This source file contains implementation of automatic ANALYZE of tables
+// after large deletions. When more than configured fraction of table rows
+// has been deleted, planner statistics of the table would be stale until the
+// next autovacuum run, so aggregator queries might use bad plans. Such tables
+// are analyzed right after the cleanup and they are listed in the summary.
+//
+// The fraction is configured by analyze_threshold option in the [cleaner]
+// section of the configuration file. Tables are never analyzed when it is
+// zero.
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// analyzedTableStatement function constructs ANALYZE statement for table
+// identified by its qualified name in the form schema.table
+func analyzedTableStatement(qualifiedName string) string {
+	schemaName, relationName, found := strings.Cut(qualifiedName, ".")
+	if !found {
+		return "ANALYZE " + pq.QuoteIdentifier(qualifiedName) + ";"
+	}
+	return "ANALYZE " + pq.QuoteIdentifier(schemaName) + "." + pq.QuoteIdentifier(relationName) + ";"
+}
+
+// deletedFraction function returns fraction of table rows that has been
+// deleted. Number of remaining rows is the estimate taken from statistics.
+func deletedFraction(deleted int, remaining int64) float64 {
+	if deleted <= 0 {
+		return 0
+	}
+	return float64(deleted) / (float64(deleted) + float64(remaining))
+}
+
+// analyzeTablesAfterDeletion function runs ANALYZE on all tables where more
+// than given fraction of rows has been deleted. Names of analyzed tables are
+// returned. Nothing is analyzed when the threshold is not positive.
+func analyzeTablesAfterDeletion(connection *sql.DB, schema string,
+	deletionsForTable map[string]int, threshold float64) ([]string, error) {
+	var analyzed []string
+
+	if threshold <= 0 {
+		return analyzed, nil
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return analyzed, ErrNoConnection
+	}
+
+	// remaining rows are estimated from statistics
+	sizes, err := readTableSizes(connection, schema)
+	if err != nil {
+		return analyzed, err
+	}
+
+	var errs []error
+	for _, size := range sizes {
+		table := size.TableName
+		if index := strings.LastIndex(table, "."); index >= 0 {
+			table = table[index+1:]
+		}
+
+		fraction := deletedFraction(deletionsForTable[table], size.RowCount)
+		if fraction == 0 || fraction < threshold {
+			continue
+		}
+
+		_, err := execStatement(connection, analyzedTableStatement(size.TableName))
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, size.TableName).
+				Msg("Unable to analyze table")
+			errs = append(errs, queryFailed(table, err))
+			continue
+		}
+		log.Info().
+			Str(tableName, size.TableName).
+			Float64("deleted fraction", fraction).
+			Msg("Table analyzed after deletion")
+		analyzed = append(analyzed, table)
+	}
+	return analyzed, errors.Join(errs...)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/analyze_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// query used to read estimated number of rows in tables
+const expectedTableSizesQuery = "SELECT schemaname \\|\\| '.' \\|\\| relname, pg_total_relation_size\\(relid\\), n_live_tup FROM pg_stat_user_tables"
+
+// TestDeletedFraction checks computation of fraction of deleted rows
+func TestDeletedFraction(t *testing.T) {
+	assert.Equal(t, 0.0, cleaner.DeletedFraction(0, 100))
+	assert.Equal(t, 0.5, cleaner.DeletedFraction(100, 100))
+	assert.Equal(t, 1.0, cleaner.DeletedFraction(100, 0))
+	assert.Equal(t, 0.2, cleaner.DeletedFraction(20, 80))
+}
+
+// TestAnalyzedTableStatement checks that table names are quoted in ANALYZE
+// statement
+func TestAnalyzedTableStatement(t *testing.T) {
+	assert.Equal(t, `ANALYZE "public"."report";`, cleaner.AnalyzedTableStatement("public.report"))
+	assert.Equal(t, `ANALYZE "report";`, cleaner.AnalyzedTableStatement("report"))
+}
+
+// TestAnalyzeTablesAfterDeletion checks that only tables where large
+// fraction of rows has been deleted are analyzed
+func TestAnalyzeTablesAfterDeletion(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table", "size", "rows"})
+	rows.AddRow("public.report", 8192, 50)
+	rows.AddRow("public.rule_hit", 8192, 1000)
+	rows.AddRow("public.recommendation", 8192, 0)
+	mock.ExpectQuery(expectedTableSizesQuery).WithArgs("public").WillReturnRows(rows)
+	mock.ExpectExec(`ANALYZE "public"."report"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	deletionsForTable := map[string]int{
+		"report":   50,
+		"rule_hit": 10,
+	}
+	analyzed, err := cleaner.AnalyzeTablesAfterDeletion(connection, cleaner.DBSchemaOCPRecommendations,
+		deletionsForTable, 0.1)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, []string{"report"}, analyzed)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestAnalyzeTablesAfterDeletionDisabled checks that nothing is analyzed
+// when threshold is not set
+func TestAnalyzeTablesAfterDeletionDisabled(t *testing.T) {
+	analyzed, err := cleaner.AnalyzeTablesAfterDeletion(nil, cleaner.DBSchemaOCPRecommendations,
+		map[string]int{"report": 50}, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, analyzed)
+
+	_, err = cleaner.AnalyzeTablesAfterDeletion(nil, cleaner.DBSchemaOCPRecommendations,
+		map[string]int{"report": 50}, 0.5)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+}
+
+// TestAnalyzeTablesAfterDeletionOnError checks that all tables are analyzed
+// even when ANALYZE of some table fails
+func TestAnalyzeTablesAfterDeletionOnError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table", "size", "rows"})
+	rows.AddRow("dvo.dvo_report", 8192, 0)
+	rows.AddRow("dvo.other", 8192, 0)
+	mock.ExpectQuery(expectedTableSizesQuery).WithArgs("dvo").WillReturnRows(rows)
+	mock.ExpectExec(`ANALYZE "dvo"."dvo_report"`).WillReturnError(mockedError)
+	mock.ExpectExec(`ANALYZE "dvo"."other"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	deletionsForTable := map[string]int{
+		"dvo_report": 1,
+		"other":      1,
+	}
+	analyzed, err := cleaner.AnalyzeTablesAfterDeletion(connection, cleaner.DBSchemaDVORecommendations,
+		deletionsForTable, 1)
+	assert.ErrorIs(t, err, mockedError)
+	assert.Equal(t, []string{"other"}, analyzed)
+
+	var queryErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "dvo_report", queryErr.Table)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPrintSummaryTableAnalyzedTables checks that analyzed tables are
+// displayed below the summary table
+func TestPrintSummaryTableAnalyzedTables(t *testing.T) {
+	output, err := capture.StandardOutput(func() {
+		cleaner.PrintSummaryTable(cleaner.Summary{
+			DeletionsForTable: map[string]int{"report": 10},
+			AnalyzedTables:    []string{"report", "rule_hit"},
+		})
+	})
+	checkCapture(t, err)
+	assert.Contains(t, output, "Analyzed tables: report, rule_hit")
+}
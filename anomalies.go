@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/anomalies.html
+
+// This source file contains implementation of timestamp anomalies
+// detection. Clusters where reported_at and last_checked_at timestamps are
+// too far from each other (for example report is recent, but it has not been
+// checked for months, or vice versa) usually indicate problem in the
+// pipeline. Such clusters are logged and exported into output file together
+// with their organization IDs.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Directions of timestamp anomaly
+const (
+	lastCheckedBehind = "last_checked_at behind"
+	reportedBehind    = "reported_at behind"
+)
+
+// Statements used to select clusters with timestamp anomalies. The gap is
+// specified in seconds.
+const (
+	selectOCPTimestampAnomalies = `
+	    SELECT org_id, cluster, reported_at, last_checked_at
+	      FROM report
+	     WHERE last_checked_at < reported_at - $1 * INTERVAL '1 second'
+	        OR reported_at < last_checked_at - $1 * INTERVAL '1 second'
+	     ORDER BY org_id, cluster`
+
+	// DVO reports are stored for each namespace, the newest timestamps
+	// are used for the whole cluster
+	selectDVOTimestampAnomalies = `
+	    SELECT org_id, cluster_id, MAX(reported_at), MAX(last_checked_at)
+	      FROM dvo_report
+	     GROUP BY org_id, cluster_id
+	    HAVING MAX(last_checked_at) < MAX(reported_at) - $1 * INTERVAL '1 second'
+	        OR MAX(reported_at) < MAX(last_checked_at) - $1 * INTERVAL '1 second'
+	     ORDER BY org_id, cluster_id`
+)
+
+// TimestampAnomaly represents cluster with reported_at and last_checked_at
+// timestamps too far from each other
+type TimestampAnomaly struct {
+	OrgID       int
+	ClusterName string
+	Reported    time.Time
+	LastChecked time.Time
+}
+
+// Gap method returns absolute difference between both timestamps
+func (anomaly TimestampAnomaly) Gap() time.Duration {
+	gap := anomaly.Reported.Sub(anomaly.LastChecked)
+	if gap < 0 {
+		return -gap
+	}
+	return gap
+}
+
+// Direction method returns which timestamp is behind the other one
+func (anomaly TimestampAnomaly) Direction() string {
+	if anomaly.LastChecked.Before(anomaly.Reported) {
+		return lastCheckedBehind
+	}
+	return reportedBehind
+}
+
+// readTimestampAnomalies function reads all clusters where the gap between
+// reported_at and last_checked_at timestamps is larger than given gap
+func readTimestampAnomalies(connection *sql.DB, schema string, gap time.Duration) ([]TimestampAnomaly, error) {
+	anomalies := []TimestampAnomaly{}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return anomalies, ErrNoConnection
+	}
+
+	var query, table string
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		query, table = selectOCPTimestampAnomalies, "report"
+	case DBSchemaDVORecommendations:
+		query, table = selectDVOTimestampAnomalies, dvoReportTable
+	default:
+		return anomalies, invalidSchema(schema)
+	}
+
+	seconds := int64(gap / time.Second)
+	err := queryRows(connection, query, []interface{}{seconds}, func(rows *sql.Rows) error {
+		var anomaly TimestampAnomaly
+		if err := rows.Scan(&anomaly.OrgID, &anomaly.ClusterName,
+			&anomaly.Reported, &anomaly.LastChecked); err != nil {
+			return err
+		}
+		anomalies = append(anomalies, anomaly)
+		return nil
+	})
+	if err != nil {
+		return anomalies, queryFailed(table, err)
+	}
+	return anomalies, nil
+}
+
+// displayTimestampAnomaly function displays one timestamp anomaly and writes
+// it into output sink
+func displayTimestampAnomaly(anomaly TimestampAnomaly, sink OutputSink, outputConfig OutputConfiguration) error {
+	reportedF := formatTimestamp(anomaly.Reported, outputConfig)
+	lastCheckedF := formatTimestamp(anomaly.LastChecked, outputConfig)
+
+	event := log.Warn().
+		Int(orgIDMsg, anomaly.OrgID).
+		Str(clusterNameMsg, anomaly.ClusterName).
+		Str(reportedMsg, reportedF).
+		Str(lastCheckedMsg, lastCheckedF).
+		Str("direction", anomaly.Direction())
+	logAge(event, "gap", anomaly.Gap(), outputConfig).
+		Msg("Timestamp anomaly")
+
+	return sink.WriteRecord(OutputRecord{
+		{"org_id", anomaly.OrgID},
+		{clusterField, anomaly.ClusterName},
+		{"reported_at", reportedF},
+		{"last_checked_at", lastCheckedF},
+		{"gap", formatAge(anomaly.Gap(), outputConfig)},
+		{"direction", anomaly.Direction()},
+	})
+}
+
+// exportTimestampAnomalies function displays all timestamp anomalies and
+// exports them into output
+func exportTimestampAnomalies(anomalies []TimestampAnomaly, output string,
+	outputConfig OutputConfiguration) (err error) {
+	sink, err := createOutputSink(output, outputConfig)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// output is committed only when all records have been exported
+		err = errors.Join(err, closeOutputSink(sink, err == nil))
+	}()
+
+	for _, anomaly := range anomalies {
+		err = displayTimestampAnomaly(anomaly, sink, outputConfig)
+		if err != nil {
+			log.Error().Err(err).Msg(writeToFileMsg)
+			return err
+		}
+	}
+	return nil
+}
+
+// detectTimestampAnomalies function lists clusters with reported_at and
+// last_checked_at timestamps too far from each other
+func detectTimestampAnomalies(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	gap, err := parseAge(cliFlags.TimestampAnomalies)
+	if err == nil && gap <= 0 {
+		err = errors.New("gap between timestamps needs to be positive")
+	}
+	if err != nil {
+		err = fmt.Errorf("improper timestamp anomalies gap: %w", err)
+		log.Err(err).Msg("Read timestamp anomalies gap")
+		return ExitStatusStorageError, err
+	}
+
+	anomalies, err := readTimestampAnomalies(connection, schema, gap)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+
+	err = exportTimestampAnomalies(anomalies, cliFlags.Output, configuration.Output)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	log.Info().
+		Str("gap", cliFlags.TimestampAnomalies).
+		Int("clusters", len(anomalies)).
+		Msg("Timestamp anomalies detected")
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/anomalies_test.html
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// anomalyColumns contains columns returned by timestamp anomalies query
+var anomalyColumns = []string{"org_id", "cluster", "reported_at", "last_checked_at"}
+
+// TestTimestampAnomalyGapAndDirection checks gap and direction computed for
+// both kinds of anomalies
+func TestTimestampAnomalyGapAndDirection(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+
+	anomaly := cleaner.TimestampAnomaly{Reported: newer, LastChecked: older}
+	assert.Equal(t, 100*24*time.Hour, anomaly.Gap())
+	assert.Equal(t, "last_checked_at behind", anomaly.Direction())
+
+	anomaly = cleaner.TimestampAnomaly{Reported: older, LastChecked: newer}
+	assert.Equal(t, 100*24*time.Hour, anomaly.Gap())
+	assert.Equal(t, "reported_at behind", anomaly.Direction())
+}
+
+// TestReadTimestampAnomalies checks that gap is passed to both queries in
+// seconds
+func TestReadTimestampAnomalies(t *testing.T) {
+	reported := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+	lastChecked := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	queries := map[string]string{
+		cleaner.DBSchemaOCPRecommendations: "SELECT org_id, cluster, reported_at, last_checked_at FROM report",
+		cleaner.DBSchemaDVORecommendations: "SELECT org_id, cluster_id, MAX\\(reported_at\\), MAX\\(last_checked_at\\) FROM dvo_report",
+	}
+
+	for schema, query := range queries {
+		t.Run(schema, func(t *testing.T) {
+			// prepare new mocked connection to database
+			connection, mock, err := sqlmock.New()
+			assert.NoError(t, err, "error creating SQL mock")
+
+			mock.ExpectQuery(query).
+				WithArgs(int64(90 * 24 * 60 * 60)).
+				WillReturnRows(sqlmock.NewRows(anomalyColumns).
+					AddRow(defaultOrgID, cluster1ID, reported, lastChecked))
+			mock.ExpectClose()
+
+			anomalies, err := cleaner.ReadTimestampAnomalies(connection, schema, 90*24*time.Hour)
+			assert.NoError(t, err)
+			assert.Equal(t, []cleaner.TimestampAnomaly{
+				{OrgID: defaultOrgID, ClusterName: cluster1ID, Reported: reported, LastChecked: lastChecked},
+			}, anomalies)
+
+			// check if DB can be closed successfully
+			checkConnectionClose(t, connection)
+
+			// check all DB expectactions happened correctly
+			checkAllExpectations(t, mock)
+		})
+	}
+}
+
+// TestReadTimestampAnomaliesOnError checks that errors are reported properly
+func TestReadTimestampAnomaliesOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT org_id, cluster, reported_at, last_checked_at FROM report").
+		WillReturnError(errors.New("query error"))
+
+	_, err = cleaner.ReadTimestampAnomalies(connection, cleaner.DBSchemaOCPRecommendations, time.Hour)
+	assert.Error(t, err)
+
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.ReadTimestampAnomalies(connection, "unknown", time.Hour)
+	assert.True(t, errors.As(err, &schemaErr))
+
+	_, err = cleaner.ReadTimestampAnomalies(nil, cleaner.DBSchemaOCPRecommendations, time.Hour)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDetectTimestampAnomaliesCSVExport checks that anomalies are exported
+// into CSV file together with organization IDs
+func TestDetectTimestampAnomaliesCSVExport(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "anomalies.csv")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT org_id, cluster, reported_at, last_checked_at FROM report").
+		WithArgs(int64(90 * 24 * 60 * 60)).
+		WillReturnRows(sqlmock.NewRows(anomalyColumns).
+			AddRow(defaultOrgID, cluster1ID,
+				time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectClose()
+
+	cliFlags := cleaner.CliFlags{
+		TimestampAnomalies: "90 days",
+		Output:             outFile,
+	}
+	status, err := cleaner.DetectTimestampAnomalies(&cleaner.ConfigStruct{}, connection, cliFlags,
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	content, err := os.ReadFile(outFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "42,"+cluster1ID+",2024-04-10T00:00:00Z,2024-01-01T00:00:00Z,100,last_checked_at behind\n",
+		string(content))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDetectTimestampAnomaliesImproperGap checks that improper gap is
+// refused before database is queried
+func TestDetectTimestampAnomaliesImproperGap(t *testing.T) {
+	for _, gap := range []string{"ninety days", "0 days"} {
+		status, err := cleaner.DetectTimestampAnomalies(&cleaner.ConfigStruct{}, nil,
+			cleaner.CliFlags{TimestampAnomalies: gap}, cleaner.DBSchemaOCPRecommendations)
+		assert.Error(t, err)
+		assert.Equal(t, cleaner.ExitStatusStorageError, status)
+	}
+}
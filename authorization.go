@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/authorization.html
+
+// This source file contains implementation of per-operation authorization.
+// When authorization is enabled in [authorization] section of configuration
+// file, service account the cleaner runs under (see identity.go) needs to be
+// mapped to category of selected operation by one of [[authorization.rules]]:
+//
+// list     listings, statistics, reports, and dry runs
+// cleanup  operations that delete or rewrite data
+// vacuum   VACUUM and VACUUM FULL
+// admin    installation of in-database schedule, fill-in by test data, and
+//          import of cleaner state
+//
+// Informational operations (version, authors, configuration, and queries)
+// are always allowed. The authorization is checked before the selected
+// operation is dispatched.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Categories of operations that can be allowed by authorization rules
+const (
+	OperationCategoryList    = "list"
+	OperationCategoryCleanup = "cleanup"
+	OperationCategoryVacuum  = "vacuum"
+	OperationCategoryAdmin   = "admin"
+
+	// anyValue matches any identity or any category of operation
+	anyValue = "*"
+)
+
+// operationCategories contains all known categories of operations
+var operationCategories = map[string]struct{}{
+	OperationCategoryList:    {},
+	OperationCategoryCleanup: {},
+	OperationCategoryVacuum:  {},
+	OperationCategoryAdmin:   {},
+	anyValue:                 {},
+}
+
+// ErrNoServiceAccount is returned when authorization is enabled and service
+// account token is not available
+var ErrNoServiceAccount = errors.New(
+	"service account token is not available, it is needed when authorization is enabled")
+
+// ErrNotAuthorized is returned when identity is not allowed to perform
+// selected operation
+type ErrNotAuthorized struct {
+	Identity string
+	Category string
+}
+
+// Error method returns error message
+func (e *ErrNotAuthorized) Error() string {
+	return fmt.Sprintf("identity '%s' is not allowed to perform %s operations", e.Identity, e.Category)
+}
+
+// operationCategory function returns category of selected operation. Empty
+// string is returned for informational operations.
+func operationCategory(cliFlags CliFlags) string {
+	return classifyOperation(cliFlags).category
+}
+
+// checkAuthorizationConfiguration function checks if all authorization
+// rules are specified properly
+func checkAuthorizationConfiguration(authorizationCfg AuthorizationConfiguration) error {
+	for _, rule := range authorizationCfg.Rules {
+		if strings.TrimSpace(rule.Identity) == "" {
+			return fmt.Errorf("Identity is not specified in authorization rule")
+		}
+		for _, operation := range rule.Operations {
+			if _, found := operationCategories[operation]; !found {
+				return fmt.Errorf("Incorrect operation category found in authorization rule for '%s': %s",
+					rule.Identity, operation)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAuthorization function checks if service account the cleaner runs
+// under is allowed to perform selected operation. Everything is allowed when
+// authorization is not enabled.
+func checkAuthorization(authorizationCfg AuthorizationConfiguration, cliFlags CliFlags) error {
+	if !authorizationCfg.Enabled {
+		return nil
+	}
+
+	category := operationCategory(cliFlags)
+	if category == "" {
+		return nil
+	}
+
+	// identity specified by command line option or environment variable
+	// could be used to impersonate anyone, so it is just recorded
+	identity := cliFlags.ServiceAccount
+	if identity == "" {
+		log.Error().
+			Str(requestedByAttribute, cliFlags.RequestedBy).
+			Msg("Service account token is not available")
+		return ErrNoServiceAccount
+	}
+
+	for _, rule := range authorizationCfg.Rules {
+		if rule.Identity != identity && rule.Identity != anyValue {
+			continue
+		}
+		for _, operation := range rule.Operations {
+			if operation == category || operation == anyValue {
+				log.Debug().
+					Str(requestedByAttribute, identity).
+					Str("category", category).
+					Msg("Operation authorized")
+				return nil
+			}
+		}
+	}
+
+	return &ErrNotAuthorized{
+		Identity: identity,
+		Category: category,
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/authorization_test.html
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// identities used by authorization tests
+const (
+	cleanerIdentity = "system:serviceaccount:ccx:cleaner"
+	supportIdentity = "support-engineer"
+)
+
+// authorizationConfiguration returns configuration used by authorization
+// tests
+func authorizationConfiguration() cleaner.AuthorizationConfiguration {
+	return cleaner.AuthorizationConfiguration{
+		Enabled: true,
+		Rules: []cleaner.AuthorizationRule{
+			{
+				Identity:   cleanerIdentity,
+				Operations: []string{cleaner.OperationCategoryList, cleaner.OperationCategoryCleanup},
+			},
+			{
+				Identity:   supportIdentity,
+				Operations: []string{cleaner.OperationCategoryList},
+			},
+		},
+	}
+}
+
+// TestOperationCategory checks categories of selected operations
+func TestOperationCategory(t *testing.T) {
+	assert.Equal(t, "", cleaner.OperationCategory(cleaner.CliFlags{ShowVersion: true}))
+	assert.Equal(t, cleaner.OperationCategoryList, cleaner.OperationCategory(cleaner.CliFlags{}))
+	assert.Equal(t, cleaner.OperationCategoryList,
+		cleaner.OperationCategory(cleaner.CliFlags{PerformCleanupAll: true, DryRun: true}))
+	assert.Equal(t, cleaner.OperationCategoryCleanup,
+		cleaner.OperationCategory(cleaner.CliFlags{PerformCleanup: true}))
+	assert.Equal(t, cleaner.OperationCategoryVacuum,
+		cleaner.OperationCategory(cleaner.CliFlags{VacuumFull: "report"}))
+	assert.Equal(t, cleaner.OperationCategoryVacuum,
+		cleaner.OperationCategory(cleaner.CliFlags{VacuumDatabase: true}))
+	assert.Equal(t, cleaner.OperationCategoryAdmin,
+		cleaner.OperationCategory(cleaner.CliFlags{InstallDBSchedule: "0 3 * * *"}))
+	assert.Equal(t, cleaner.OperationCategoryAdmin,
+		cleaner.OperationCategory(cleaner.CliFlags{FillInDatabase: true}))
+	assert.Equal(t, cleaner.OperationCategoryAdmin,
+		cleaner.OperationCategory(cleaner.CliFlags{ImportState: "state.json"}))
+	assert.Equal(t, cleaner.OperationCategoryList,
+		cleaner.OperationCategory(cleaner.CliFlags{ExportState: "state.json"}))
+}
+
+// TestCheckAuthorization checks that identities are allowed to perform only
+// operations specified in authorization rules
+func TestCheckAuthorization(t *testing.T) {
+	authorizationCfg := authorizationConfiguration()
+
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: cleanerIdentity, PerformCleanup: true}))
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: supportIdentity, CountOnly: true}))
+
+	// cleanup is not allowed for support engineer
+	err := cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: supportIdentity, PerformCleanup: true})
+	var notAuthorizedErr *cleaner.ErrNotAuthorized
+	assert.ErrorAs(t, err, &notAuthorizedErr)
+	assert.Equal(t, supportIdentity, notAuthorizedErr.Identity)
+	assert.Equal(t, cleaner.OperationCategoryCleanup, notAuthorizedErr.Category)
+
+	// vacuum is not allowed for anyone
+	err = cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: cleanerIdentity, VacuumDatabase: true})
+	assert.ErrorAs(t, err, &notAuthorizedErr)
+
+	// unknown identity
+	err = cleaner.CheckAuthorization(authorizationCfg, cleaner.CliFlags{ServiceAccount: "unknown"})
+	assert.ErrorAs(t, err, &notAuthorizedErr)
+
+	// identity specified by command line option or environment variable
+	// is not used when service account is not available
+	err = cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{RequestedBy: cleanerIdentity, CountOnly: true})
+	assert.ErrorIs(t, err, cleaner.ErrNoServiceAccount)
+
+	// service account is used even when other identity is recorded
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{RequestedBy: "operator", ServiceAccount: cleanerIdentity, PerformCleanup: true}))
+	err = cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{RequestedBy: cleanerIdentity, ServiceAccount: supportIdentity, PerformCleanup: true})
+	assert.ErrorAs(t, err, &notAuthorizedErr)
+
+	// informational operations are always allowed
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{RequestedBy: "unknown", ShowVersion: true}))
+}
+
+// TestCheckAuthorizationWildcards checks rules matching any identity or any
+// operation
+func TestCheckAuthorizationWildcards(t *testing.T) {
+	authorizationCfg := cleaner.AuthorizationConfiguration{
+		Enabled: true,
+		Rules: []cleaner.AuthorizationRule{
+			{Identity: "*", Operations: []string{cleaner.OperationCategoryList}},
+			{Identity: "admin", Operations: []string{"*"}},
+		},
+	}
+
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg, cleaner.CliFlags{ServiceAccount: "anyone"}))
+	assert.Error(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: "anyone", VacuumDatabase: true}))
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{ServiceAccount: "admin", VacuumDatabase: true}))
+}
+
+// TestCheckAuthorizationDisabled checks that everything is allowed when
+// authorization is not enabled
+func TestCheckAuthorizationDisabled(t *testing.T) {
+	authorizationCfg := authorizationConfiguration()
+	authorizationCfg.Enabled = false
+
+	assert.NoError(t, cleaner.CheckAuthorization(authorizationCfg,
+		cleaner.CliFlags{RequestedBy: "unknown", PerformCleanup: true}))
+}
+
+// TestDoSelectedOperationNotAuthorized checks that operation is not started
+// when identity is not allowed to perform it
+func TestDoSelectedOperationNotAuthorized(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Authorization: authorizationConfiguration(),
+	}
+
+	status, err := cleaner.DoSelectedOperation(&configuration, nil,
+		cleaner.CliFlags{ServiceAccount: supportIdentity, PerformCleanup: true})
+	var notAuthorizedErr *cleaner.ErrNotAuthorized
+	assert.ErrorAs(t, err, &notAuthorizedErr)
+	assert.Equal(t, cleaner.ExitStatusNotAuthorized, status)
+}
+
+// TestDoSelectedOperationNoServiceAccount checks that operation is not
+// started when service account token is not available
+func TestDoSelectedOperationNoServiceAccount(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Authorization: authorizationConfiguration(),
+	}
+
+	status, err := cleaner.DoSelectedOperation(&configuration, nil,
+		cleaner.CliFlags{RequestedBy: cleanerIdentity, PerformCleanup: true})
+	assert.ErrorIs(t, err, cleaner.ErrNoServiceAccount)
+	assert.Equal(t, cleaner.ExitStatusNotAuthorized, status)
+}
+
+// TestCheckConfigurationAuthorization checks validation of authorization
+// rules
+func TestCheckConfigurationAuthorization(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Authorization: authorizationConfiguration(),
+	}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.Authorization.Rules[0].Operations = []string{"delete-everything"}
+	assert.Error(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.Authorization.Rules[0] = cleaner.AuthorizationRule{Operations: []string{"list"}}
+	assert.Error(t, cleaner.CheckConfiguration(&configuration))
+}
@@ -0,0 +1,230 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/bloat.html
+
+// This source file contains implementation of bloat report. The report
+// displays ratio of dead tuples for all tables managed by the cleaner (read
+// from pg_stat_user_tables) together with density of leaf pages of their
+// B-tree indexes (read by pgstatindex function from pgstattuple extension,
+// when the extension is installed). Based on these values it recommends
+// whether VACUUM, VACUUM FULL, or REINDEX is warranted.
+//
+// Bloat report is selected by -bloat-report command line option.
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// SQL statements used by bloat report
+const (
+	selectDeadTuples = `
+	    SELECT relname, n_live_tup, n_dead_tup
+	      FROM pg_stat_user_tables
+	     WHERE schemaname = $1
+	       AND relname = ANY($2)
+	     ORDER BY relname`
+
+	selectPgstattupleInstalled = `
+	    SELECT COUNT(*)
+	      FROM pg_extension
+	     WHERE extname = 'pgstattuple'`
+
+	selectIndexLeafDensity = `
+	    SELECT i.relname, i.indexrelname, s.avg_leaf_density
+	      FROM pg_stat_user_indexes i
+	      JOIN pg_class c ON c.oid = i.indexrelid
+	      JOIN pg_am am ON am.oid = c.relam
+	     CROSS JOIN LATERAL pgstatindex(i.indexrelid) s
+	     WHERE i.schemaname = $1
+	       AND i.relname = ANY($2)
+	       AND am.amname = 'btree'
+	     ORDER BY i.relname, i.indexrelname`
+)
+
+// Thresholds used to recommend maintenance operation
+const (
+	// ratio of dead tuples when VACUUM is recommended
+	vacuumDeadTupleRatio = 0.2
+	// ratio of dead tuples when VACUUM FULL is recommended
+	vacuumFullDeadTupleRatio = 0.5
+	// density of index leaf pages (in percents) when REINDEX is
+	// recommended
+	reindexLeafDensity = 50.0
+)
+
+// Recommended maintenance operations
+const (
+	recommendNothing    = "none"
+	recommendVacuum     = "VACUUM"
+	recommendVacuumFull = "VACUUM FULL"
+	recommendReindex    = "REINDEX"
+)
+
+// unknownLeafDensity is displayed when index leaf density is not known
+const unknownLeafDensity = "n/a"
+
+// managedTables function returns sorted names of all tables managed by the
+// cleaner in given DB schema
+func managedTables(schema string) ([]string, error) {
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make(StringSet)
+	for _, tableAndKey := range tablesAndKeys {
+		tables[tableAndKey.TableName] = struct{}{}
+	}
+	for _, tableToDelete := range tablesToDelete {
+		tables[tableToDelete.TableName] = struct{}{}
+	}
+
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readTableBloat function reads number of dead tuples and density of index
+// leaf pages for all tables managed by the cleaner. Index leaf density is
+// read only when pgstattuple extension is installed.
+func readTableBloat(connection *sql.DB, schema string) ([]TableBloat, error) {
+	var bloats []TableBloat
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return bloats, ErrNoConnection
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return bloats, err
+	}
+
+	tables, err := managedTables(schema)
+	if err != nil {
+		return bloats, err
+	}
+
+	args := []interface{}{databaseSchema, pq.Array(tables)}
+	err = queryRows(connection, selectDeadTuples, args, func(rows *sql.Rows) error {
+		var bloat TableBloat
+		if err := rows.Scan(&bloat.TableName, &bloat.LiveTuples, &bloat.DeadTuples); err != nil {
+			return err
+		}
+		bloats = append(bloats, bloat)
+		return nil
+	})
+	if err != nil {
+		return bloats, queryFailed(statisticsTable, err)
+	}
+
+	// index bloat can be measured only by pgstattuple extension
+	var installed int
+	err = queryRowStatement(connection, selectPgstattupleInstalled).Scan(&installed)
+	if err != nil {
+		return bloats, queryFailed("pg_extension", err)
+	}
+	if installed == 0 {
+		log.Warn().Msg("Extension pgstattuple is not installed, index bloat is not measured")
+		return bloats, nil
+	}
+
+	// the lowest density of leaf pages is remembered for each table
+	err = queryRows(connection, selectIndexLeafDensity, args, func(rows *sql.Rows) error {
+		var table, index string
+		var density float64
+		if err := rows.Scan(&table, &index, &density); err != nil {
+			return err
+		}
+		log.Info().
+			Str(tableName, table).
+			Str("index", index).
+			Float64("leaf density", density).
+			Msg("Index leaf density")
+		for i := range bloats {
+			current := bloats[i].IndexLeafDensity
+			if bloats[i].TableName == table && (!current.Valid || density < current.Float64) {
+				bloats[i].IndexLeafDensity = sql.NullFloat64{Float64: density, Valid: true}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return bloats, queryFailed("pg_stat_user_indexes", err)
+	}
+
+	return bloats, nil
+}
+
+// PrintBloatReport function displays a table with dead tuple ratio, index
+// leaf density, and recommended maintenance operation for each table
+func PrintBloatReport(bloats []TableBloat) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", "Live tuples", "Dead tuples", "Dead ratio",
+		"Index leaf density", "Recommendation"})
+
+	for _, bloat := range bloats {
+		density := unknownLeafDensity
+		if bloat.IndexLeafDensity.Valid {
+			density = fmt.Sprintf("%.1f%%", bloat.IndexLeafDensity.Float64)
+		}
+		table.Append([]string{bloat.TableName,
+			strconv.FormatInt(bloat.LiveTuples, 10),
+			strconv.FormatInt(bloat.DeadTuples, 10),
+			fmt.Sprintf("%.1f%%", 100*bloat.DeadTupleRatio()),
+			density,
+			bloat.Recommendation()})
+	}
+
+	// display the whole table
+	table.Render()
+}
+
+// bloatReport function displays dead tuples and index bloat of tables
+// managed by the cleaner together with recommended maintenance operations
+func bloatReport(connection *sql.DB, schema string) (ExitStatus, error) {
+	bloats, err := readTableBloat(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Reading table bloat")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	PrintBloatReport(bloats)
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/bloat_test.html
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// queries expected by bloat report tests
+const (
+	expectedDeadTuplesQuery       = "SELECT relname, n_live_tup, n_dead_tup FROM pg_stat_user_tables"
+	expectedPgstattupleQuery      = "SELECT COUNT\\(\\*\\) FROM pg_extension WHERE extname = 'pgstattuple'"
+	expectedIndexLeafDensityQuery = "SELECT i.relname, i.indexrelname, s.avg_leaf_density FROM pg_stat_user_indexes"
+)
+
+// TestTableBloatRecommendation checks recommended maintenance operations
+func TestTableBloatRecommendation(t *testing.T) {
+	density := func(value float64) sql.NullFloat64 {
+		return sql.NullFloat64{Float64: value, Valid: true}
+	}
+
+	testCases := []struct {
+		bloat          cleaner.TableBloat
+		ratio          float64
+		recommendation string
+	}{
+		{cleaner.TableBloat{LiveTuples: 0, DeadTuples: 0}, 0, "none"},
+		{cleaner.TableBloat{LiveTuples: 90, DeadTuples: 10}, 0.1, "none"},
+		{cleaner.TableBloat{LiveTuples: 75, DeadTuples: 25}, 0.25, "VACUUM"},
+		{cleaner.TableBloat{LiveTuples: 40, DeadTuples: 60}, 0.6, "VACUUM FULL"},
+		{cleaner.TableBloat{LiveTuples: 90, DeadTuples: 10, IndexLeafDensity: density(30)}, 0.1, "REINDEX"},
+		{cleaner.TableBloat{LiveTuples: 90, DeadTuples: 10, IndexLeafDensity: density(90)}, 0.1, "none"},
+		{cleaner.TableBloat{LiveTuples: 40, DeadTuples: 60, IndexLeafDensity: density(30)}, 0.6, "VACUUM FULL"},
+	}
+
+	for _, testCase := range testCases {
+		assert.InDelta(t, testCase.ratio, testCase.bloat.DeadTupleRatio(), 0.0001)
+		assert.Equal(t, testCase.recommendation, testCase.bloat.Recommendation())
+	}
+}
+
+// TestReadTableBloat checks that dead tuples and index leaf density are read
+// for tables managed by the cleaner
+func TestReadTableBloat(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	tuples := sqlmock.NewRows([]string{"relname", "n_live_tup", "n_dead_tup"})
+	tuples.AddRow("report", 100, 50)
+	tuples.AddRow("rule_hit", 100, 0)
+	mock.ExpectQuery(expectedDeadTuplesQuery).WithArgs("public", sqlmock.AnyArg()).WillReturnRows(tuples)
+	mock.ExpectQuery(expectedPgstattupleQuery).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	densities := sqlmock.NewRows([]string{"relname", "indexrelname", "avg_leaf_density"})
+	densities.AddRow("report", "report_pkey", 80.0)
+	densities.AddRow("rule_hit", "rule_hit_pkey", 90.0)
+	densities.AddRow("rule_hit", "rule_hit_cluster_idx", 20.0)
+	mock.ExpectQuery(expectedIndexLeafDensityQuery).WithArgs("public", sqlmock.AnyArg()).WillReturnRows(densities)
+	mock.ExpectClose()
+
+	bloats, err := cleaner.ReadTableBloat(connection, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, []cleaner.TableBloat{
+		{TableName: "report", LiveTuples: 100, DeadTuples: 50, IndexLeafDensity: sql.NullFloat64{Float64: 80, Valid: true}},
+		{TableName: "rule_hit", LiveTuples: 100, DeadTuples: 0, IndexLeafDensity: sql.NullFloat64{Float64: 20, Valid: true}},
+	}, bloats)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableBloatWithoutPgstattuple checks that index bloat is not
+// measured when pgstattuple extension is not installed
+func TestReadTableBloatWithoutPgstattuple(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	tuples := sqlmock.NewRows([]string{"relname", "n_live_tup", "n_dead_tup"})
+	tuples.AddRow("dvo_report", 10, 30)
+	mock.ExpectQuery(expectedDeadTuplesQuery).WithArgs("dvo", sqlmock.AnyArg()).WillReturnRows(tuples)
+	mock.ExpectQuery(expectedPgstattupleQuery).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectClose()
+
+	output, err := capture.StandardOutput(func() {
+		status, err := cleaner.BloatReport(connection, cleaner.DBSchemaDVORecommendations)
+		assert.NoError(t, err, "error not expected while calling tested function")
+		assert.Equal(t, cleaner.ExitStatusOK, status)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "dvo_report")
+	assert.Contains(t, output, "75.0%")
+	assert.Contains(t, output, "n/a")
+	assert.Contains(t, output, "VACUUM FULL")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableBloatOnError checks error handling in readTableBloat
+func TestReadTableBloatOnError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	_, err := cleaner.ReadTableBloat(nil, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	status, err := cleaner.BloatReport(nil, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	_, err = cleaner.ReadTableBloat(connection, "unknown")
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &invalidSchemaErr)
+
+	mock.ExpectQuery(expectedDeadTuplesQuery).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	_, err = cleaner.ReadTableBloat(connection, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, mockedError)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
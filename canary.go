@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/canary.html
+
+// This source file contains implementation of canary cleanup. Before the
+// whole cluster list is processed, the first cluster from the list is cleaned
+// up alone as a canary. The rest of the list is processed only when the
+// canary passes, ie. when some rows have been deleted for the canary cluster
+// and its cleanup did not fail (because of foreign key violation, for
+// example). Canary cleanup can be skipped by -skip-canary command line
+// option.
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrCanaryFailed is returned when canary cleanup of the first cluster from
+// cluster list fails or when it does not delete any row
+var ErrCanaryFailed = errors.New("canary cleanup of the first cluster did not pass")
+
+// checkCanaryCleanup function checks results of canary cleanup of given
+// cluster. Error returned by the cleanup is wrapped when the cluster can not
+// be cleaned up.
+func checkCanaryCleanup(cluster ClusterName, deletionsForTable map[string]int,
+	failedClusters ClusterList, err error) error {
+	if len(failedClusters) > 0 {
+		return fmt.Errorf("%w: cluster %s: %w", ErrCanaryFailed, cluster, err)
+	}
+	if deletedRowsCount(deletionsForTable) == 0 {
+		return fmt.Errorf("%w: no row deleted for cluster %s", ErrCanaryFailed, cluster)
+	}
+	return nil
+}
+
+// logCanaryCleanup function logs result of canary cleanup of given cluster
+func logCanaryCleanup(cluster ClusterName, deletionsForTable map[string]int, err error) {
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str(clusterNameMsg, string(cluster)).
+			Msg("Canary cleanup failed, rest of cluster list is not processed")
+		return
+	}
+	log.Info().
+		Str(clusterNameMsg, string(cluster)).
+		Int("deleted", deletedRowsCount(deletionsForTable)).
+		Msg("Canary cleanup passed")
+}
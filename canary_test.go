@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/canary_test.html
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// expectDVOClusterDeletionWithoutRows function prepares expectations for
+// deletion of cluster that does not have any row in DVO tables
+func expectDVOClusterDeletionWithoutRows(mock sqlmock.Sqlmock, cluster string) {
+	for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+		mock.ExpectExec(fmt.Sprintf("DELETE FROM %v WHERE %v = \\$",
+			tableAndKey.TableName, tableAndKey.KeyName)).
+			WithArgs(cluster).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
+// canaryCliFlags function returns command line flags used to clean up two
+// clusters
+func canaryCliFlags() cleaner.CliFlags {
+	return cleaner.CliFlags{
+		PerformCleanup: true,
+		Clusters:       cluster1ID + "," + cluster2ID,
+	}
+}
+
+// TestCleanupCanaryPassed checks that the rest of cluster list is cleaned up
+// when canary cleanup of the first cluster passes
+func TestCleanupCanaryPassed(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, nil)
+	// clusters that are not canary might be absent
+	expectDVOClusterDeletionWithoutRows(mock, cluster2ID)
+	mock.ExpectClose()
+
+	status, err := cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, canaryCliFlags(),
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupCanaryNoDeletions checks that the rest of cluster list is not
+// cleaned up when no row is deleted for canary cluster
+func TestCleanupCanaryNoDeletions(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// the second cluster must not be touched
+	expectDVOClusterDeletionWithoutRows(mock, cluster1ID)
+	mock.ExpectClose()
+
+	status, err := cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, canaryCliFlags(),
+		cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrCanaryFailed)
+	assert.ErrorContains(t, err, "no row deleted for cluster "+cluster1ID)
+	assert.Equal(t, cleaner.ExitStatusCanaryFailed, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupCanaryFailed checks that the rest of cluster list is not
+// cleaned up when canary cluster can not be cleaned up
+func TestCleanupCanaryFailed(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, errors.New("foreign key violation"))
+	mock.ExpectClose()
+
+	status, err := cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, canaryCliFlags(),
+		cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrCanaryFailed)
+	assert.ErrorContains(t, err, "foreign key violation")
+	assert.Equal(t, cleaner.ExitStatusCanaryFailed, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupSkipCanary checks that all clusters are cleaned up when canary
+// is skipped
+func TestCleanupSkipCanary(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletionWithoutRows(mock, cluster1ID)
+	expectDVOClusterDeletionWithoutRows(mock, cluster2ID)
+	mock.ExpectClose()
+
+	cliFlags := canaryCliFlags()
+	cliFlags.SkipCanary = true
+	status, err := cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
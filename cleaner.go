@@ -48,9 +48,12 @@ import (
 	"github.com/google/uuid"
 	"github.com/olekukonko/tablewriter"
 	"github.com/rs/zerolog/log"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Messages
@@ -60,40 +63,28 @@ const (
 	properClusterID              = "Proper cluster ID"
 	notProperClusterID           = "Not a proper cluster ID"
 	improperClusterEntries       = "improper cluster entries"
+	normalizedClusterEntries     = "normalized cluster entries"
 	numberOfClustersToDelete     = "number of clusters to delete"
 	clusterListFinished          = "Cluster list finished"
 	inputWithClusterID           = "input"
 	selectingRecordsFromDatabase = "Selecting records from database"
 	connectionToDBNotEstablished = "Connection to database was not established"
-)
-
-// Exit codes
-const (
-	// ExitStatusOK means that the tool finished with success
-	ExitStatusOK = iota
-
-	// ExitStatusStorageError is returned in case of any storage-related
-	// error
-	ExitStatusStorageError
-
-	// ExitStatusFillInStorageError is returned in case the fill-in DB
-	// operation failed
-	ExitStatusFillInStorageError
-
-	// ExitStatusPerformCleanupError is returned when DB cleanup operation
-	// failed for any reason
-	ExitStatusPerformCleanupError
-
-	// ExitStatusPerformVacuumError is returned when DB vacuuming operation
-	// have failed for any reason
-	ExitStatusPerformVacuumError
+	maxAgeNotConfirmed           = "max age needs to be specified by -max-age and repeated by -confirm-max-age when cleanup-all is not run in dry-run mode"
+	vacuumFullNotConfirmed       = "tables selected by -vacuum-full need to be repeated by -confirm-vacuum-full"
+	unknownRowCount              = "?"
 )
 
 const (
 	configFileEnvVariableName = "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"
 	defaultConfigFileName     = "config"
+	runIDAttribute            = "run_id"
 )
 
+// runID is a correlation ID generated at startup. It is attached to every log
+// event (including events sent to Sentry and Kafka) and to the summary, so
+// multi-pod log aggregation can reconstruct a single run end-to-end.
+var runID = uuid.New().String()
+
 // showVersion function displays version information.
 func showVersion() {
 	fmt.Println(versionMessage)
@@ -110,17 +101,93 @@ func IsValidUUID(input string) bool {
 	return err == nil
 }
 
-// readClusterList function reads list of clusters from provided text file or
-// from CLI argument.
-func readClusterList(filename, clusters string) (ClusterList, int, error) {
-	// if clusters are not specified on command line, read list of clusters
-	// from file
-	if clusters == "" {
-		return readClusterListFromFile(filename)
+// ageUnits contains durations of units that can be used to specify age of
+// records in command line options
+var ageUnits = map[string]time.Duration{
+	"hour":  time.Hour,
+	"hours": time.Hour,
+	"day":   24 * time.Hour,
+	"days":  24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"weeks": 7 * 24 * time.Hour,
+}
+
+// parseAge function parses age of records specified in format similar to
+// max-age, ie. "180 days" or "12 hours". Go duration format (like "36h") is
+// accepted as well. Empty string means that no age is specified.
+func parseAge(input string) (time.Duration, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return 0, nil
+	}
+
+	// Go duration format
+	if duration, err := time.ParseDuration(input); err == nil {
+		return duration, nil
+	}
+
+	fields := strings.Fields(input)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid age specification: '%s'", input)
+	}
+
+	value, err := strconv.Atoi(fields[0])
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid age value: '%s'", fields[0])
+	}
+
+	unit, found := ageUnits[strings.ToLower(fields[1])]
+	if !found {
+		return 0, fmt.Errorf("invalid age unit: '%s'", fields[1])
+	}
+
+	return time.Duration(value) * unit, nil
+}
+
+// readListingFilter function constructs filter for listing operations from
+// command line flags
+func readListingFilter(cliFlags CliFlags) (ListingFilter, error) {
+	var filter ListingFilter
+
+	olderThan, err := parseAge(cliFlags.OlderThan)
+	if err != nil {
+		return filter, err
+	}
+
+	newerThan, err := parseAge(cliFlags.NewerThan)
+	if err != nil {
+		return filter, err
+	}
+
+	if cliFlags.Limit < 0 {
+		return filter, fmt.Errorf("limit can not be negative: %d", cliFlags.Limit)
+	}
+
+	// threshold is checked after listing, but it needs to be found out
+	// that it is incorrect before records are read
+	_, err = readListingThreshold(cliFlags)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.OlderThan = olderThan
+	filter.NewerThan = newerThan
+	filter.Limit = cliFlags.Limit
+	return filter, nil
+}
+
+// parseRuleSelector function parses rule selector in format
+// "rule.fqdn|ERROR_KEY". Error key is optional, all error keys of given rule
+// are selected when it is not specified.
+func parseRuleSelector(input string) (string, string, error) {
+	ruleFQDN, errorKey, _ := strings.Cut(strings.TrimSpace(input), "|")
+	ruleFQDN = strings.TrimSpace(ruleFQDN)
+	errorKey = strings.TrimSpace(errorKey)
+
+	if ruleFQDN == "" {
+		return "", "", fmt.Errorf("rule FQDN is not specified in '%s'", input)
 	}
-	// apparently list of clusters is specified on command line, so let's
-	// use it properly
-	return readClusterListFromCLIArgument(clusters)
+	return ruleFQDN, errorKey, nil
 }
 
 // showConfiguration function displays actual configuration.
@@ -160,7 +227,7 @@ func readClusterListFromCLIArgument(clusters string) (ClusterList, int, error) {
 	v := strings.Split(clusters, ",")
 
 	for _, cluster := range v {
-		cluster := strings.Trim(cluster, " ")
+		cluster := strings.TrimSpace(cluster)
 		// check if line contains proper cluster ID (as UUID)
 		if IsValidUUID(cluster) {
 			clusterList = append(clusterList, ClusterName(cluster))
@@ -181,29 +248,47 @@ func readClusterListFromCLIArgument(clusters string) (ClusterList, int, error) {
 func readClusterListFromFile(filename string) (ClusterList, int, error) {
 	log.Debug().Msg("Cluster list read from file")
 
-	improperClusterCounter := 0
-
-	var clusterList = make([]ClusterName, 0)
-
 	// disable "G304 (CWE-22): Potential file inclusion via variable"
 	file, err := os.Open(filename) // #nosec G304
 	if err != nil {
-		return nil, improperClusterCounter, err
+		return nil, 0, err
 	}
 
-	// start reading from the file with a reader
-	reader := bufio.NewReader(file)
-	var line string
+	clusterList, improperClusterCounter, _ := readClusterListFromReader(file)
+
+	// close file and catch any I/O error
+	err = file.Close()
+	if err != nil {
+		// if error is detected during file close, we need to inform
+		// caller about it
+		log.Err(err).Msg("File close failed")
+		return clusterList, improperClusterCounter, err
+	}
+
+	return clusterList, improperClusterCounter, nil
+}
+
+// readClusterListFromReader function reads list of clusters from provided
+// reader, one cluster ID per line.
+func readClusterListFromReader(input io.Reader) (ClusterList, int, error) {
+	improperClusterCounter := 0
+
+	var clusterList = make([]ClusterName, 0)
+
+	// start reading with a buffered reader
+	reader := bufio.NewReader(input)
 	for {
-		line, err = reader.ReadString('\n')
+		line, err := reader.ReadString('\n')
 		if err != nil {
 			break
 		}
-		line = strings.Trim(line, "\n")
+		// whitespaces (including CR from files with Windows line endings)
+		// are not part of cluster ID
+		line = strings.TrimSpace(line)
 		// check if line contains proper cluster ID (as UUID)
 		if IsValidUUID(line) {
 			clusterList = append(clusterList, ClusterName(line))
-			log.Info().Str(inputWithClusterID, line).Msg(properClusterID)
+			log.Debug().Str(inputWithClusterID, line).Msg(properClusterID)
 		} else {
 			log.Error().Str(inputWithClusterID, line).Msg(notProperClusterID)
 			improperClusterCounter++
@@ -212,116 +297,762 @@ func readClusterListFromFile(filename string) (ClusterList, int, error) {
 	log.Info().Int(numberOfClustersToDelete, len(clusterList)).Msg(clusterListFinished)
 	log.Info().Int(improperClusterEntries, improperClusterCounter).Msg(clusterListFinished)
 
-	// close file and catch any I/O error
-	err = file.Close()
-	if err != nil {
-		// if error is detected during file close, we need to inform
-		// caller about it
-		log.Err(err).Msg("File close failed")
-		return clusterList, improperClusterCounter, err
+	return clusterList, improperClusterCounter, nil
+}
+
+// summaryTableNames function returns names of tables with deletions in the
+// order in which they are displayed in summary table, so the table is the
+// same for the same deletions. Tables are sorted by their names or by number
+// of deletions (the highest first, ties are sorted by names).
+func summaryTableNames(deletionsForTable map[string]int, byDeletions bool) []string {
+	tableNames := make([]string, 0, len(deletionsForTable))
+	for tableName := range deletionsForTable {
+		tableNames = append(tableNames, tableName)
 	}
+	sort.Slice(tableNames, func(i, j int) bool {
+		first, second := tableNames[i], tableNames[j]
+		if byDeletions && deletionsForTable[first] != deletionsForTable[second] {
+			return deletionsForTable[first] > deletionsForTable[second]
+		}
+		return first < second
+	})
+	return tableNames
+}
 
-	return clusterList, improperClusterCounter, nil
+// newSummary function prepares summary of cleanup operation with fields
+// common to all operations filled in
+func newSummary(configuration *ConfigStruct, cliFlags CliFlags) Summary {
+	return Summary{
+		Target:          configuration.Storage.Name,
+		RunID:           runID,
+		RequestedBy:     cliFlags.RequestedBy,
+		SortByDeletions: cliFlags.SummaryByDeletions,
+	}
 }
 
 // PrintSummaryTable function displays a table with summary information about
 // cleanup step.
 func PrintSummaryTable(summary Summary) {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetColWidth(60)
+	table.SetColWidth(summaryColWidth)
+
+	// number of rows before cleanup is displayed next to deletions when
+	// it is known
+	withRowCounts := summary.RowsBeforeCleanup != nil
+	appendRow := func(colors tablewriter.Colors, label, count, rowsBefore string) {
+		row := []string{label, count}
+		if withRowCounts {
+			row = append(row, rowsBefore)
+		}
+		// colors are used only when standard output is a terminal
+		if summaryColors {
+			table.Rich(row, rowColors(colors, len(row)))
+		} else {
+			table.Append(row)
+		}
+	}
 
 	// table header
-	table.SetHeader([]string{"Summary", "Count"})
+	if withRowCounts {
+		table.SetHeader([]string{"Summary", "Count", "Rows before cleanup"})
+	} else {
+		table.SetHeader([]string{"Summary", "Count"})
+	}
 
-	table.Append([]string{"Proper cluster entries",
-		strconv.Itoa(summary.ProperClusterEntries)})
-	table.Append([]string{"Improper cluster entries",
-		strconv.Itoa(summary.ImproperClusterEntries)})
-	table.Append([]string{"", ""})
+	appendRow(normalColors, "Proper cluster entries",
+		strconv.Itoa(summary.ProperClusterEntries), "")
+	appendRow(normalColors, "Improper cluster entries",
+		strconv.Itoa(summary.ImproperClusterEntries), "")
+	// cluster IDs that were not in lowercase canonical form
+	if summary.NormalizedClusterEntries > 0 {
+		appendRow(normalColors, "Normalized cluster entries",
+			strconv.Itoa(summary.NormalizedClusterEntries), "")
+	}
+	// clusters that could not be cleaned up even after retries
+	if summary.FailedClusterEntries > 0 {
+		appendRow(errorColors, "Failed cluster entries",
+			strconv.Itoa(summary.FailedClusterEntries), "")
+	}
+	appendRow(normalColors, "", "", "")
 
 	totalDeletions := 0
+	var totalRowsBefore int64
 
 	// prepare rows with info about deletions
-	for tableName, deletions := range summary.DeletionsForTable {
+	for _, tableName := range summaryTableNames(summary.DeletionsForTable, summary.SortByDeletions) {
+		deletions := summary.DeletionsForTable[tableName]
 		totalDeletions += deletions
-		table.Append([]string{"Deletions from table '" + tableName + "'",
-			strconv.Itoa(deletions)})
+		rowsBefore := unknownRowCount
+		if rowCount, found := summary.RowsBeforeCleanup[tableName]; found {
+			totalRowsBefore += rowCount
+			rowsBefore = strconv.FormatInt(rowCount, 10)
+		}
+		// tables without deletions are not so interesting
+		colors := normalColors
+		if deletions == 0 {
+			colors = dimmedColors
+		}
+		appendRow(colors, "Deletions from table '"+tableName+"'",
+			strconv.Itoa(deletions), rowsBefore)
 	}
 
 	// table footer
-	table.SetFooter([]string{"Total deletions",
-		strconv.Itoa(totalDeletions)})
+	if withRowCounts {
+		table.SetFooter([]string{"Total deletions",
+			strconv.Itoa(totalDeletions), strconv.FormatInt(totalRowsBefore, 10)})
+	} else {
+		table.SetFooter([]string{"Total deletions",
+			strconv.Itoa(totalDeletions)})
+	}
 
 	// display the whole table
 	table.Render()
+
+	// target, correlation ID, and identity are displayed below the table
+	if summary.Target != "" {
+		fmt.Println("Target: " + summary.Target)
+	}
+	if summary.RunID != "" {
+		fmt.Println("Run ID: " + summary.RunID)
+	}
+	if summary.RequestedBy != "" {
+		fmt.Println("Requested by: " + summary.RequestedBy)
+	}
+	// tables analyzed after large deletions
+	if len(summary.AnalyzedTables) > 0 {
+		fmt.Println("Analyzed tables: " + strings.Join(summary.AnalyzedTables, ", "))
+	}
+	// requested-versus-deleted reconciliation (cleanup of selected clusters only)
+	if summary.Reconciliation != nil {
+		PrintReconciliation(*summary.Reconciliation)
+	}
+	// rows found after cleanup (cleanup of selected clusters only)
+	if summary.Verification != nil {
+		PrintDeletionVerification(*summary.Verification)
+	}
+}
+
+// readRowCountsForSummary function reads estimated number of rows in each
+// table before cleanup, so deletions can be compared with table sizes in the
+// summary table. Estimates are taken from statistics, so they are cheap to
+// read. Nothing is read when summary table is not displayed and failure to
+// read the estimates does not fail the operation.
+func readRowCountsForSummary(connection *sql.DB, schema string, cliFlags CliFlags) map[string]int64 {
+	if !cliFlags.PrintSummaryTable {
+		return nil
+	}
+
+	sizes, err := readTableSizes(connection, schema)
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to read number of rows before cleanup")
+		return nil
+	}
+
+	rowCounts := make(map[string]int64, len(sizes))
+	for _, size := range sizes {
+		table := size.TableName
+		if index := strings.LastIndex(table, "."); index >= 0 {
+			table = table[index+1:]
+		}
+		rowCounts[table] = size.RowCount
+	}
+	return rowCounts
+}
+
+// analyzeAfterDeletion function runs ANALYZE on tables where large fraction
+// of rows has been deleted. Failure to analyze tables does not fail the whole
+// operation, because records have been deleted already.
+func analyzeAfterDeletion(configuration *ConfigStruct, connection *sql.DB, schema string,
+	deletionsForTable map[string]int) []string {
+	analyzed, err := analyzeTablesAfterDeletion(connection, schema, deletionsForTable,
+		configuration.Cleaner.AnalyzeThreshold)
+	if err != nil {
+		log.Err(err).Msg("Analyzing tables after deletion")
+	}
+	return analyzed
 }
 
 // vacuumDB function starts the database vacuuming operation
-func vacuumDB(connection *sql.DB) (int, error) {
+func vacuumDB(connection *sql.DB) (ExitStatus, error) {
 	// connection might be nil when DB init does not finish correctly
 	if connection == nil {
 		log.Error().Msg(connectionToDBNotEstablished)
-		return ExitStatusPerformVacuumError, errors.New(connectionToDBNotEstablished)
+		return ExitStatusPerformVacuumError, ErrNoConnection
 	}
 
 	err := performVacuumDB(connection)
 	if err != nil {
 		log.Err(err).Msg("Performing vacuuming database")
+		return exitStatusForError(err, ExitStatusPerformVacuumError), err
+	}
+	return ExitStatusOK, nil
+}
+
+// parseTableList function parses comma separated list of table names
+func parseTableList(tableList string) []string {
+	var tables []string
+	for _, table := range strings.Split(tableList, ",") {
+		table = strings.TrimSpace(table)
+		if table != "" {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// checkConfirmedVacuumFull function checks if tables selected for VACUUM FULL
+// have been repeated by -confirm-vacuum-full, so the tables are not locked by
+// accident
+func checkConfirmedVacuumFull(cliFlags CliFlags) error {
+	tables := parseTableList(cliFlags.VacuumFull)
+	confirmedTables := parseTableList(cliFlags.ConfirmVacuumFull)
+
+	if len(tables) == 0 || len(confirmedTables) == 0 {
+		return errors.New(vacuumFullNotConfirmed)
+	}
+	if strings.Join(tables, ",") != strings.Join(confirmedTables, ",") {
+		return fmt.Errorf("tables '%s' do not match confirmed tables '%s'",
+			strings.Join(tables, ","), strings.Join(confirmedTables, ","))
+	}
+	return nil
+}
+
+// vacuumFull function starts VACUUM FULL of selected tables. Tables are
+// rewritten by pg_repack instead when it is enabled in configuration.
+func vacuumFull(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// tables are locked during VACUUM FULL, so the operation needs to be
+	// confirmed
+	err := checkConfirmedVacuumFull(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Confirm VACUUM FULL")
 		return ExitStatusPerformVacuumError, err
 	}
+
+	tables := parseTableList(cliFlags.VacuumFull)
+	method := reclaimedByVacuumFull
+	var reclaimed []string
+	repackConfiguration := GetRepackConfiguration(configuration)
+	if repackConfiguration.Enabled {
+		method = reclaimedByRepack
+		reclaimed, err = performRepackInDB(connection, &configuration.Storage,
+			repackConfiguration, tables, schema)
+	} else {
+		reclaimed, err = performVacuumFullInDB(connection, tables, schema)
+	}
+
+	// run report contains method used to reclaim space in each table
+	log.Info().
+		Str("method", method).
+		Strs("reclaimed tables", reclaimed).
+		Msg("Space reclamation report")
+	if cliFlags.PrintSummaryTable && len(reclaimed) > 0 {
+		fmt.Printf("Space reclaimed by %s: %s\n", method, strings.Join(reclaimed, ", "))
+	}
+
+	if err != nil {
+		log.Err(err).Msg("Performing " + method)
+		return exitStatusForError(err, ExitStatusPerformVacuumError), err
+	}
+	return ExitStatusOK, nil
+}
+
+// installDBSchedule function installs age-based delete statements as pg_cron
+// jobs run with given schedule
+func installDBSchedule(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// scheduled jobs delete records periodically, so max age needs to be
+	// confirmed as for cleanup-all
+	err := checkConfirmedMaxAge(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Confirm max age")
+		return ExitStatusStorageError, err
+	}
+
+	job, err := installCronJobsInDB(connection, strings.TrimSpace(cliFlags.InstallDBSchedule),
+		strings.TrimSpace(cliFlags.MaxAge), schema)
+	if err != nil {
+		log.Err(err).Msg("Installing pg_cron job")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+	log.Info().Str("job", job).Msg("DB schedule installed")
+	return ExitStatusOK, nil
+}
+
+// uninstallDBSchedule function uninstalls all pg_cron jobs installed by the
+// cleaner
+func uninstallDBSchedule(connection *sql.DB, schema string) (ExitStatus, error) {
+	_, err := uninstallCronJobsInDB(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Uninstalling pg_cron jobs")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+	return ExitStatusOK, nil
+}
+
+// showDBSchedule function displays all pg_cron jobs installed by the cleaner
+func showDBSchedule(connection *sql.DB, schema string) (ExitStatus, error) {
+	jobs, err := readCronJobs(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Reading pg_cron jobs")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	PrintCronJobs(jobs)
 	return ExitStatusOK, nil
 }
 
 // cleanup function starts the cleanup operation
-func cleanup(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (int, error) {
+func cleanup(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
 	// cleanup operation
-	clusterList, improperClusterCounter, err := readClusterList(
-		configuration.Cleaner.ClusterListFile,
-		cliFlags.Clusters)
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	started := time.Now()
+	// the first cluster is cleaned up as canary before the rest of the list
+	results := newClusterListCleanup(!cliFlags.SkipCanary)
+	cleanupStarted := false
+	diagnostics, err := processClusterList(configuration, connection, cliFlags, func(chunk ClusterList) error {
+		cleanupStarted = true
+		return results.cleanupChunk(configuration, connection, cliFlags, schema, chunk)
+	})
 	if err != nil {
-		log.Err(err).Msg("Read cluster list")
-		return ExitStatusPerformCleanupError, err
+		switch {
+		case !cleanupStarted:
+			log.Err(err).Msg("Read cluster list")
+			return ExitStatusPerformCleanupError, err
+		case results.chunks == 0:
+			// nothing has been cleaned up
+			exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+			if errors.Is(err, ErrCanaryFailed) {
+				exitStatus = ExitStatusCanaryFailed
+			}
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, "cleanup", started, exitStatus, nil, nil))
+			return exitStatus, err
+		default:
+			// some chunks have been cleaned up already
+			log.Err(err).Msg("Cleanup of cluster list interrupted")
+			results.errs = append(results.errs, err)
+		}
+	}
+	// records of cleaned up clusters are counted again (if requested)
+	var verification *DeletionVerification
+	var verificationErr error
+	if cliFlags.VerifyDeletion || cliFlags.RedeleteStragglers {
+		var result DeletionVerification
+		result, verificationErr = results.verifyDeletion(configuration, connection, cliFlags, schema)
+		verification = &result
+	}
+	clusterList := results.clusterList
+	failedClusters := results.failedClusters
+	deletionsForTable := results.deletionsForTable
+	deletionsForCluster := results.deletionsForCluster
+	cleanupErr := results.err()
+	recordDeletedRows(deletionsForTable)
+
+	reconciliation := reconcileCleanup(clusterList, deletionsForCluster, failedClusters,
+		results.protectedClusters)
+	logReconciliation(reconciliation)
+	warnAboutAbsentClusters(reconciliation, configuration.Storage.Name, schema)
+
+	exitStatus := ExitStatusOK
+	switch {
+	case errors.Is(cleanupErr, ErrCanaryFailed):
+		exitStatus = ExitStatusCanaryFailed
+	case cleanupErr != nil:
+		exitStatus = ExitStatusPerformCleanupError
+	case errors.Is(verificationErr, ErrStragglersFound):
+		exitStatus = ExitStatusStragglersFound
+	case verificationErr != nil:
+		exitStatus = ExitStatusStorageError
+	}
+	cleanupErr = errors.Join(cleanupErr, verificationErr)
+	recordRunHistoryOrWarn(configuration, connection,
+		newRunHistoryEntry(configuration, cliFlags, "cleanup", started, exitStatus, deletionsForTable,
+			countClustersForOrg(reconciliation.Deleted, results.clusterOrgs)))
+
+	summary := newSummary(configuration, cliFlags)
+	summary.ProperClusterEntries = len(clusterList)
+	summary.ImproperClusterEntries = diagnostics.ImproperEntries
+	summary.NormalizedClusterEntries = diagnostics.NormalizedEntries
+	summary.FailedClusterEntries = len(failedClusters)
+	summary.DeletionsForTable = deletionsForTable
+	summary.RowsBeforeCleanup = rowsBeforeCleanup
+	summary.AnalyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	summary.Reconciliation = &reconciliation
+	summary.Verification = verification
+	if cliFlags.PrintSummaryTable {
+		reportSummary(summary)
+	}
+
+	// signed evidence about deleted data (if configured)
+	evidenceConfig := GetEvidenceConfiguration(configuration)
+	if evidenceConfig.File != "" {
+		evidence, err := newDeletionEvidence(configuration, summary, schema, started, time.Now())
+		if err == nil {
+			err = writeDeletionEvidence(evidenceConfig, evidence)
+		}
+		if err != nil {
+			return ExitStatusEvidenceError, errors.Join(cleanupErr, err)
+		}
+	}
+
+	// rows deleted for each cluster from each table (if requested)
+	if cliFlags.DeletionMatrix != "" {
+		err := writeDeletionMatrix(cliFlags.DeletionMatrix, cliFlags.Checksum, deletionsForCluster,
+			clusterList, schema)
+		if err != nil {
+			log.Err(err).Msg("Write deletion matrix")
+			return ExitStatusStorageError, errors.Join(cleanupErr, err)
+		}
+	}
+
+	// some clusters were not cleaned up, canary did not pass, or some rows
+	// remained after cleanup
+	if cleanupErr != nil {
+		return exitStatus, cleanupErr
 	}
-	deletionsForTable, err := performCleanupInDB(connection, clusterList, schema)
+	return ExitStatusOK, nil
+}
+
+// checkConfirmedMaxAge function checks if max age used by destructive
+// cleanup-all has been specified explicitly on command line and repeated by
+// -confirm-max-age, so max age read from configuration file can not be used
+// by accident
+func checkConfirmedMaxAge(cliFlags CliFlags) error {
+	maxAge := strings.TrimSpace(cliFlags.MaxAge)
+	confirmedMaxAge := strings.TrimSpace(cliFlags.ConfirmMaxAge)
+
+	if maxAge == "" || confirmedMaxAge == "" {
+		return errors.New(maxAgeNotConfirmed)
+	}
+	if maxAge != confirmedMaxAge {
+		return fmt.Errorf("max age '%s' does not match confirmed max age '%s'", maxAge, confirmedMaxAge)
+	}
+	return nil
+}
+
+// cleanup function starts the cleanup-all operation
+func cleanupAll(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	maxAge := configuration.Cleaner.MaxAge
+
+	// records are really deleted only when max age is confirmed
+	if !cliFlags.DryRun {
+		err := checkConfirmedMaxAge(cliFlags)
+		if err != nil {
+			log.Err(err).Msg("Confirm max age")
+			return ExitStatusPerformCleanupError, err
+		}
+		maxAge = strings.TrimSpace(cliFlags.MaxAge)
+	}
+
+	// candidate rows are deleted from their copies in scratch schema first
+	if cliFlags.SimulateInSchema != "" {
+		results, err := simulateCleanupAllInDB(connection, maxAge, schema, cliFlags.SimulateInSchema)
+		PrintSimulationResults(results)
+		if err != nil {
+			log.Err(err).Msg("Simulating cleanup-all")
+			return exitStatusForError(err, ExitStatusPerformCleanupError), err
+		}
+	}
+
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	started := time.Now()
+	deletionsForTable, err := performCleanupAllInDB(connection, maxAge, schema, cliFlags.DryRun)
 	if err != nil {
-		log.Err(err).Msg("Performing cleanup")
+		log.Err(err).Msg("Performing cleanup-all")
+		exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+		if !cliFlags.DryRun {
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, "cleanup-all", started, exitStatus, nil, nil))
+		}
+		return exitStatus, err
+	}
+	// rows are not really deleted in dry run mode
+	var analyzedTables []string
+	if !cliFlags.DryRun {
+		recordDeletedRows(deletionsForTable)
+		recordRunHistoryOrWarn(configuration, connection,
+			newRunHistoryEntry(configuration, cliFlags, "cleanup-all", started, ExitStatusOK, deletionsForTable, nil))
+		analyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	}
+	if cliFlags.PrintSummaryTable {
+		summary := newSummary(configuration, cliFlags)
+		summary.DeletionsForTable = deletionsForTable
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		summary.AnalyzedTables = analyzedTables
+		reportSummary(summary)
+	}
+	return ExitStatusOK, nil
+}
+
+// compareMaxAge function displays number of records that would be deleted by
+// cleanup-all for max age from configuration and for the other max age
+// specified on command line
+func compareMaxAge(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	maxAge := configuration.Cleaner.MaxAge
+	otherMaxAge := strings.TrimSpace(cliFlags.CompareMaxAge)
+
+	comparisons, err := performMaxAgeComparisonInDB(connection, maxAge, otherMaxAge, schema)
+	if err != nil {
+		log.Err(err).Msg("Comparing max age")
+		return exitStatusForError(err, ExitStatusPerformCleanupError), err
+	}
+
+	PrintMaxAgeComparison(comparisons, maxAge, otherMaxAge)
+	return ExitStatusOK, nil
+}
+
+// PrintMaxAgeComparison function displays a table with number of records
+// that would be deleted from each table for two different max age values.
+func PrintMaxAgeComparison(comparisons []MaxAgeComparison, maxAge, otherMaxAge string) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", maxAge, otherMaxAge, "Delta"})
+
+	total := MaxAgeComparison{}
+	for _, comparison := range comparisons {
+		total.Deletions += comparison.Deletions
+		total.OtherDeletions += comparison.OtherDeletions
+		table.Append([]string{comparison.Table,
+			strconv.Itoa(comparison.Deletions),
+			strconv.Itoa(comparison.OtherDeletions),
+			fmt.Sprintf("%+d", comparison.Delta())})
+	}
+
+	// table footer
+	table.SetFooter([]string{"Total",
+		strconv.Itoa(total.Deletions),
+		strconv.Itoa(total.OtherDeletions),
+		fmt.Sprintf("%+d", total.Delta())})
+
+	// display the whole table
+	table.Render()
+}
+
+// cleanupRule function starts cleanup of all records referencing retired
+// rule
+func cleanupRule(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	ruleFQDN, errorKey, err := parseRuleSelector(cliFlags.CleanupRule)
+	if err != nil {
+		log.Err(err).Msg("Read rule selector")
 		return ExitStatusPerformCleanupError, err
 	}
+	started := time.Now()
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	deletionsForTable, err := performRuleCleanupInDB(connection, ruleFQDN, errorKey, schema, cliFlags.DryRun)
+	if err != nil {
+		log.Err(err).Msg("Performing rule cleanup")
+		exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+		if !cliFlags.DryRun {
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, "cleanup-rule", started, exitStatus, nil, nil))
+		}
+		return exitStatus, err
+	}
+	// rows are not really deleted in dry run mode
+	var analyzedTables []string
+	if !cliFlags.DryRun {
+		recordDeletedRows(deletionsForTable)
+		recordRunHistoryOrWarn(configuration, connection,
+			newRunHistoryEntry(configuration, cliFlags, "cleanup-rule", started, ExitStatusOK, deletionsForTable, nil))
+		analyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	}
 	if cliFlags.PrintSummaryTable {
-		var summary Summary
-		summary.ProperClusterEntries = len(clusterList)
-		summary.ImproperClusterEntries = improperClusterCounter
+		summary := newSummary(configuration, cliFlags)
 		summary.DeletionsForTable = deletionsForTable
-		PrintSummaryTable(summary)
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		summary.AnalyzedTables = analyzedTables
+		reportSummary(summary)
 	}
 	return ExitStatusOK, nil
 }
 
-// cleanup function starts the cleanup-all operation
-func cleanupAll(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (int, error) {
-	deletionsForTable, err := performCleanupAllInDB(connection, configuration.Cleaner.MaxAge, cliFlags.DryRun)
+// cleanupKafkaOffsets function starts cleanup of reports stored from Kafka
+// messages with offsets in selected range. Records are really deleted only
+// when -force is specified.
+func cleanupKafkaOffsets(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	offsetRange, err := parseKafkaOffsetRange(cliFlags.CleanupKafkaOffsets)
 	if err != nil {
-		log.Err(err).Msg("Performing cleanup-all")
+		log.Err(err).Msg("Read Kafka offset range")
 		return ExitStatusPerformCleanupError, err
 	}
+	if !cliFlags.DryRun && !cliFlags.Force {
+		log.Err(ErrForceRequired).Msg("Confirm cleanup by Kafka offsets")
+		return ExitStatusPerformCleanupError, ErrForceRequired
+	}
+	started := time.Now()
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	deletionsForTable, err := performKafkaOffsetsCleanupInDB(connection, offsetRange, schema, cliFlags.DryRun)
+	if err != nil {
+		log.Err(err).Msg("Performing cleanup by Kafka offsets")
+		exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+		if !cliFlags.DryRun {
+			recordDeletedRows(deletionsForTable)
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, "cleanup-kafka-offsets", started, exitStatus, deletionsForTable, nil))
+		}
+		return exitStatus, err
+	}
+	// rows are not really deleted in dry run mode
+	var analyzedTables []string
+	if !cliFlags.DryRun {
+		recordDeletedRows(deletionsForTable)
+		recordRunHistoryOrWarn(configuration, connection,
+			newRunHistoryEntry(configuration, cliFlags, "cleanup-kafka-offsets", started, ExitStatusOK, deletionsForTable, nil))
+		analyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	}
+	if cliFlags.PrintSummaryTable {
+		summary := newSummary(configuration, cliFlags)
+		summary.DeletionsForTable = deletionsForTable
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		summary.AnalyzedTables = analyzedTables
+		reportSummary(summary)
+	}
+	return ExitStatusOK, nil
+}
+
+// cleanupRatings function starts cleanup of Advisor ratings for selected
+// organization and/or rule
+func cleanupRatings(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	var ruleFQDN, errorKey string
+	if cliFlags.Rule != "" {
+		var err error
+		ruleFQDN, errorKey, err = parseRuleSelector(cliFlags.Rule)
+		if err != nil {
+			log.Err(err).Msg("Read rule selector")
+			return ExitStatusPerformCleanupError, err
+		}
+	}
+	started := time.Now()
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	deletionsForTable, err := performRatingsCleanupInDB(connection, cliFlags.OrgID, ruleFQDN, errorKey, schema, cliFlags.DryRun)
+	if err != nil {
+		log.Err(err).Msg("Performing Advisor ratings cleanup")
+		exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+		if !cliFlags.DryRun {
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, "cleanup-ratings", started, exitStatus, nil, nil))
+		}
+		return exitStatus, err
+	}
+	// rows are not really deleted in dry run mode
+	var analyzedTables []string
+	if !cliFlags.DryRun {
+		recordDeletedRows(deletionsForTable)
+		recordRunHistoryOrWarn(configuration, connection,
+			newRunHistoryEntry(configuration, cliFlags, "cleanup-ratings", started, ExitStatusOK, deletionsForTable, nil))
+		analyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	}
 	if cliFlags.PrintSummaryTable {
-		var summary Summary
+		summary := newSummary(configuration, cliFlags)
 		summary.DeletionsForTable = deletionsForTable
-		PrintSummaryTable(summary)
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		summary.AnalyzedTables = analyzedTables
+		reportSummary(summary)
 	}
 	return ExitStatusOK, nil
 }
 
+// compactPayloads function starts compaction of payload stored in old
+// records
+func compactPayloads(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	_, err := performPayloadCompactionInDB(connection, configuration.Cleaner.MaxAge, schema, cliFlags.OrgID, cliFlags.DryRun)
+	if err != nil {
+		log.Err(err).Msg("Performing payload compaction")
+		return exitStatusForError(err, ExitStatusPerformCleanupError), err
+	}
+	return ExitStatusOK, nil
+}
+
+// validatePayloads function reports records with empty or invalid JSON
+// payload and deletes them when dry run mode is disabled
+func validatePayloads(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	invalidPayloads, err := readInvalidPayloads(connection, configuration.Cleaner.MaxAge, schema)
+	if err != nil {
+		log.Err(err).Msg("Validating payloads")
+		return exitStatusForError(err, ExitStatusPerformCleanupError), err
+	}
+	PrintInvalidPayloads(invalidPayloads)
+
+	// rows are not deleted in dry run mode
+	if cliFlags.DryRun || len(invalidPayloads) == 0 {
+		return ExitStatusOK, nil
+	}
+
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	started := time.Now()
+	deletionsForTable, err := deleteInvalidPayloads(connection, invalidPayloads)
+	recordDeletedRows(deletionsForTable)
+	exitStatus := ExitStatusOK
+	if err != nil {
+		log.Err(err).Msg("Deleting records with invalid payload")
+		exitStatus = exitStatusForError(err, ExitStatusPerformCleanupError)
+	}
+	recordRunHistoryOrWarn(configuration, connection,
+		newRunHistoryEntry(configuration, cliFlags, "validate-payloads", started, exitStatus, deletionsForTable, nil))
+	if cliFlags.PrintSummaryTable {
+		summary := newSummary(configuration, cliFlags)
+		summary.DeletionsForTable = deletionsForTable
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		reportSummary(summary)
+	}
+	return exitStatus, err
+}
+
+// sizeSnapshot function appends actual sizes of all tables into CSV file
+func sizeSnapshot(connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	sizes, err := readTableSizes(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Reading table sizes")
+		return ExitStatusStorageError, err
+	}
+
+	err = appendSizeSnapshot(cliFlags.SizeSnapshot, time.Now(), sizes)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+	log.Info().
+		Str(filenameAttribute, cliFlags.SizeSnapshot).
+		Int("tables", len(sizes)).
+		Msg("Size snapshot written")
+	return ExitStatusOK, nil
+}
+
 // detectMultipleRuleDisable function detects clusters that have the same
 // rule(s) disabled by different users
-func detectMultipleRuleDisable(connection *sql.DB, cliFlags CliFlags) (int, error) {
+func detectMultipleRuleDisable(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	// connection might be nil when DB init does not finish correctly
+	if connection == nil {
+		log.Error().Msg(connectionToDBNotEstablished)
+		return ExitStatusStorageError, ErrNoConnection
+	}
+
+	err := displayMultipleRuleDisable(connection, cliFlags.Output, configuration.Output)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+	// everything seems to be fine
+	return ExitStatusOK, nil
+}
+
+// dvoNamespaceStatistics function displays statistics about DVO reports
+// grouped by namespaces
+func dvoNamespaceStatistics(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
 	// connection might be nil when DB init does not finish correctly
 	if connection == nil {
 		log.Error().Msg(connectionToDBNotEstablished)
-		return ExitStatusStorageError, errors.New(connectionToDBNotEstablished)
+		return ExitStatusStorageError, ErrNoConnection
+	}
+
+	// namespaces are stored in DVO database only
+	if schema != DBSchemaDVORecommendations {
+		err := fmt.Errorf("DVO namespace statistics are not available for schema '%s'", schema)
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
 	}
 
-	err := displayMultipleRuleDisable(connection, cliFlags.Output)
+	err := displayDVONamespaceStatistics(connection, cliFlags.Output, configuration.Output)
 	if err != nil {
 		log.Err(err).Msg(selectingRecordsFromDatabase)
 		return ExitStatusStorageError, err
@@ -330,38 +1061,481 @@ func detectMultipleRuleDisable(connection *sql.DB, cliFlags CliFlags) (int, erro
 	return ExitStatusOK, nil
 }
 
+// checkConsumerErrorOffsets function displays spread of Kafka offsets stored in
+// consumer_error table. Consumer errors with offsets lower than low
+// watermarks (if specified) are deleted.
+func checkConsumerErrorOffsets(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// connection might be nil when DB init does not finish correctly
+	if connection == nil {
+		log.Error().Msg(connectionToDBNotEstablished)
+		return ExitStatusStorageError, ErrNoConnection
+	}
+
+	// consumer errors are stored in OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		err := invalidSchema(schema)
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+
+	watermarks, err := parseKafkaLowWatermarks(cliFlags.KafkaLowWatermarks)
+	if err != nil {
+		log.Err(err).Msg("Read low watermarks")
+		return ExitStatusPerformCleanupError, err
+	}
+
+	err = displayConsumerErrorOffsets(connection, cliFlags.Output, configuration.Output)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+
+	// nothing to delete
+	if len(watermarks) == 0 {
+		return ExitStatusOK, nil
+	}
+
+	return deleteConsumerErrors(configuration, connection, cliFlags, schema, "consumer-error-offsets",
+		func(dryRun bool) (int, error) {
+			return deleteConsumerErrorsBeforeWatermarks(connection, watermarks, dryRun)
+		})
+}
+
+// exportConsumerErrors function exports consumer errors for reprocessing and
+// optionally deletes exported records
+func exportConsumerErrors(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// connection might be nil when DB init does not finish correctly
+	if connection == nil {
+		log.Error().Msg(connectionToDBNotEstablished)
+		return ExitStatusStorageError, ErrNoConnection
+	}
+
+	// consumer errors are stored in OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		err := invalidSchema(schema)
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+
+	exported, err := exportConsumerErrorsForReplay(connection, cliFlags.Output, configuration.Output)
+	if err != nil {
+		log.Err(err).Msg("Exporting consumer errors")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	// exported records are kept in database unless deletion is requested
+	if !cliFlags.DeleteExported {
+		return ExitStatusOK, nil
+	}
+
+	return deleteConsumerErrors(configuration, connection, cliFlags, schema, "export-consumer-errors",
+		func(dryRun bool) (int, error) {
+			return deleteExportedConsumerErrors(connection, exported, dryRun)
+		})
+}
+
+// deleteConsumerErrors function deletes consumer errors by given function,
+// records the deletion into run history under given operation name and
+// reports summary table when requested
+func deleteConsumerErrors(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema, operation string,
+	deleteRecords func(dryRun bool) (int, error)) (ExitStatus, error) {
+	started := time.Now()
+	rowsBeforeCleanup := readRowCountsForSummary(connection, schema, cliFlags)
+	deleted, err := deleteRecords(cliFlags.DryRun)
+	deletionsForTable := map[string]int{consumerErrorTable: deleted}
+	if err != nil {
+		log.Err(err).Msg("Performing consumer errors cleanup")
+		exitStatus := exitStatusForError(err, ExitStatusPerformCleanupError)
+		if !cliFlags.DryRun {
+			recordDeletedRows(deletionsForTable)
+			recordRunHistoryOrWarn(configuration, connection,
+				newRunHistoryEntry(configuration, cliFlags, operation, started, exitStatus, deletionsForTable, nil))
+		}
+		return exitStatus, err
+	}
+
+	// rows are not really deleted in dry run mode
+	var analyzedTables []string
+	if !cliFlags.DryRun {
+		recordDeletedRows(deletionsForTable)
+		recordRunHistoryOrWarn(configuration, connection,
+			newRunHistoryEntry(configuration, cliFlags, operation, started, ExitStatusOK, deletionsForTable, nil))
+		analyzedTables = analyzeAfterDeletion(configuration, connection, schema, deletionsForTable)
+	}
+	if cliFlags.PrintSummaryTable {
+		summary := newSummary(configuration, cliFlags)
+		summary.DeletionsForTable = deletionsForTable
+		summary.RowsBeforeCleanup = rowsBeforeCleanup
+		summary.AnalyzedTables = analyzedTables
+		reportSummary(summary)
+	}
+	return ExitStatusOK, nil
+}
+
+// digest function writes weekly digest of runs recorded in history table
+func digest(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	err := produceDigest(connection, GetHistoryConfiguration(configuration).Table,
+		cliFlags.Output, cliFlags.DigestFormat, time.Now())
+	if err != nil {
+		log.Err(err).Msg("Produce digest")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+	return ExitStatusOK, nil
+}
+
 // fillInDatabase function fills-in database by test data
-func fillInDatabase(connection *sql.DB, schema string) (int, error) {
+func fillInDatabase(connection *sql.DB, schema string) (ExitStatus, error) {
 	// connection might be nil when DB init does not finish correctly
 	if connection == nil {
 		log.Error().Msg(connectionToDBNotEstablished)
-		return ExitStatusFillInStorageError, errors.New(connectionToDBNotEstablished)
+		return ExitStatusFillInStorageError, ErrNoConnection
 	}
 
 	err := fillInDatabaseByTestData(connection, schema)
 	if err != nil {
 		log.Err(err).Msg("Fill-in database by test data")
-		return ExitStatusFillInStorageError, err
+		return exitStatusForError(err, ExitStatusFillInStorageError), err
+	}
+	// everything seems to be fine
+	return ExitStatusOK, nil
+}
+
+// bulkFillInDatabase function fills-in database by selected number of
+// synthetic clusters
+func bulkFillInDatabase(connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// connection might be nil when DB init does not finish correctly
+	if connection == nil {
+		log.Error().Msg(connectionToDBNotEstablished)
+		return ExitStatusFillInStorageError, ErrNoConnection
+	}
+
+	err := bulkFillInDatabaseByTestData(connection, schema, cliFlags.FillInClusters, cliFlags.FillInBatchSize)
+	if err != nil {
+		log.Err(err).Msg("Bulk fill-in database by test data")
+		return exitStatusForError(err, ExitStatusFillInStorageError), err
 	}
 	// everything seems to be fine
 	return ExitStatusOK, nil
 }
 
 // displayOldRecords function displays old records in database
-func displayOldRecords(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (int, error) {
-	err := displayAllOldRecords(connection,
-		configuration.Cleaner.MaxAge, cliFlags.Output, schema)
+func displayOldRecords(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// usage errors are reported before any record is read
+	filter, err := readListingFilter(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Read listing filter")
+		return ExitStatusConfigError, err
+	}
+
+	total, err := listAllOldRecords(connection,
+		configuration.Cleaner.MaxAge, cliFlags.Output, schema,
+		configuration.Output, filter)
 	if err != nil {
 		log.Err(err).Msg(selectingRecordsFromDatabase)
 		return ExitStatusStorageError, err
 	}
-	// everything seems to be fine
+	// everything seems to be fine, but monitoring might want to be alerted
+	return checkListingThresholds(total, cliFlags)
+}
+
+// readListingThreshold function reads max number of old records specified by
+// -fail-if-more-than, -1 is returned when no threshold is specified
+func readListingThreshold(cliFlags CliFlags) (int, error) {
+	if cliFlags.FailIfMoreThan == "" {
+		return -1, nil
+	}
+	threshold, err := strconv.Atoi(cliFlags.FailIfMoreThan)
+	if err != nil {
+		return -1, fmt.Errorf("incorrect number of records '%s': %w", cliFlags.FailIfMoreThan, err)
+	}
+	if threshold < 0 {
+		return -1, fmt.Errorf("number of records can not be negative: %d", threshold)
+	}
+	return threshold, nil
+}
+
+// checkListingThresholds function returns dedicated exit status when number
+// of old records found by listing is above threshold specified by
+// -fail-if-more-than or when no old record is found and -fail-if-none is
+// specified
+func checkListingThresholds(total int, cliFlags CliFlags) (ExitStatus, error) {
+	threshold, err := readListingThreshold(cliFlags)
+	if err != nil {
+		return ExitStatusConfigError, err
+	}
+	if threshold >= 0 && total > threshold {
+		log.Warn().Int("records", total).Int("threshold", threshold).Msg("Too many old records")
+		return ExitStatusTooManyRecords, fmt.Errorf("%d old records found, more than %d allowed", total, threshold)
+	}
+	if cliFlags.FailIfNone && total == 0 {
+		log.Warn().Msg("No old records found")
+		return ExitStatusNoRecords, errors.New("no old records found")
+	}
+	return ExitStatusOK, nil
+}
+
+// displayOldRecordsCounts function displays just number of old records in
+// all tables, without listing the records themselves
+func displayOldRecordsCounts(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// usage errors are reported before any record is read
+	filter, err := readListingFilter(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Read listing filter")
+		return ExitStatusConfigError, err
+	}
+
+	counts, err := countOldRecords(connection, configuration.Cleaner.MaxAge, schema, filter)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+
+	// records with timestamp in the future are displayed as separate
+	// categories
+	futureCounts, err := countFutureDatedRecords(connection, schema)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return ExitStatusStorageError, err
+	}
+	PrintOldRecordsCounts(counts, futureCounts)
+	// records with timestamp in the future are not old records
+	return checkListingThresholds(totalOldRecordsCount(counts), cliFlags)
+}
+
+// displayOldRecordsEstimates function displays estimated number of old
+// records in each table, exact number of records is displayed when requested
+func displayOldRecordsEstimates(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	// usage errors are reported before any record is read
+	filter, err := readListingFilter(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Read listing filter")
+		return ExitStatusConfigError, err
+	}
+
+	estimates, err := estimateOldRecords(connection, configuration.Cleaner.MaxAge, schema,
+		filter, cliFlags.ExactCount)
+	if err != nil {
+		log.Err(err).Msg(selectingRecordsFromDatabase)
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	PrintOldRecordsEstimates(estimates, cliFlags.ExactCount)
 	return ExitStatusOK, nil
 }
 
+// totalOldRecordsCount function sums number of records in all categories
+func totalOldRecordsCount(counts []OldRecordsCount) int {
+	total := 0
+	for _, count := range counts {
+		total += count.Count
+	}
+	return total
+}
+
+// PrintOldRecordsCounts function displays a table with number of old
+// records in each category followed by number of future-dated records.
+// Future-dated records are not old records, so they are not included in the
+// total displayed in footer.
+func PrintOldRecordsCounts(counts, futureCounts []OldRecordsCount) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Category", "Table", "Count"})
+
+	for _, count := range append(counts, futureCounts...) {
+		table.Append([]string{count.Category, count.Table,
+			strconv.Itoa(count.Count)})
+	}
+
+	// table footer
+	table.SetFooter([]string{"Total old records", "", strconv.Itoa(totalOldRecordsCount(counts))})
+
+	// display the whole table
+	table.Render()
+}
+
+// isInformationalOperation function checks if selected operation just
+// displays information about the tool itself, ie. it does not need database
+func isInformationalOperation(cliFlags CliFlags) bool {
+	return cliFlags.ShowVersion || cliFlags.ShowAuthors || cliFlags.ShowConfiguration ||
+		cliFlags.ListQueries || cliFlags.ListExitCodes
+}
+
+// operationClass describes how selected operation treats data stored in
+// database
+type operationClass struct {
+	// category is used by authorization rules, it is empty for
+	// informational operations
+	category string
+	// modifiesData is set when the operation changes anything in database
+	modifiesData bool
+	// destructive is set when the operation deletes or rewrites data or
+	// installs something that does so
+	destructive bool
+	// dryRunVariant is set when the operation has variant that does not
+	// change anything in dry-run mode
+	dryRunVariant bool
+}
+
+// classifyOperation function returns class of selected operation. It is the
+// only place where operations are classified, so read-only mode,
+// authorization, confirmation, and maintenance window can not disagree.
+// Operations that are run together with others (like -init-schema) are
+// checked first.
+func classifyOperation(cliFlags CliFlags) operationClass {
+	switch {
+	case isInformationalOperation(cliFlags):
+		return operationClass{}
+	case cliFlags.VacuumFull != "":
+		return operationClass{category: OperationCategoryVacuum, modifiesData: true, destructive: true}
+	case cliFlags.InstallDBSchedule != "" || cliFlags.UninstallDBSchedule || cliFlags.FillInDatabase ||
+		cliFlags.InitSchema || cliFlags.ImportState != "":
+		return operationClass{category: OperationCategoryAdmin, modifiesData: true, destructive: true}
+	case cliFlags.VacuumDatabase:
+		return operationClass{category: OperationCategoryVacuum, modifiesData: true}
+	case cliFlags.PerformCleanup || cliFlags.OrgBatch != "" || cliFlags.Watch != "" ||
+		cliFlags.SimulateInSchema != "":
+		return operationClass{category: OperationCategoryCleanup, modifiesData: true, destructive: true}
+	case cliFlags.PerformCleanupAll ||
+		cliFlags.CleanupRule != "" ||
+		cliFlags.CleanupKafkaOffsets != "" ||
+		cliFlags.CleanupRatings ||
+		cliFlags.CompactPayloads ||
+		cliFlags.ValidatePayloads ||
+		cliFlags.Cluster != "" ||
+		(cliFlags.ConsumerErrorOffsets && cliFlags.KafkaLowWatermarks != "") ||
+		(cliFlags.ExportConsumerErrors && cliFlags.DeleteExported):
+		// nothing is changed in dry-run mode
+		if cliFlags.DryRun {
+			return operationClass{category: OperationCategoryList, dryRunVariant: true}
+		}
+		return operationClass{category: OperationCategoryCleanup, modifiesData: true, destructive: true,
+			dryRunVariant: true}
+	default:
+		return operationClass{category: OperationCategoryList}
+	}
+}
+
+// readOnlyOperation function converts selected operation into its
+// non-destructive variant when read-only mode is enabled. Operations that do
+// not have such variant are refused.
+func readOnlyOperation(cliFlags CliFlags) (CliFlags, error) {
+	if !cliFlags.ReadOnly || isInformationalOperation(cliFlags) {
+		return cliFlags, nil
+	}
+
+	class := classifyOperation(cliFlags)
+	if class.modifiesData && !class.dryRunVariant {
+		return cliFlags, ErrReadOnly
+	}
+
+	// all other operations have dry-run variant
+	if !cliFlags.DryRun {
+		log.Warn().Msg("Dry run mode enforced by read-only mode")
+		cliFlags.DryRun = true
+	}
+	return cliFlags, nil
+}
+
 // doSelectedOperation function performs selected operation: check data
 // retention, cleanup selected data, or fill-id database by test data
-func doSelectedOperation(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (int, error) {
+func doSelectedOperation(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	// operations are converted into their non-destructive variants
+	cliFlags, err := readOnlyOperation(cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Read-only mode")
+		return ExitStatusReadOnlyViolation, err
+	}
+
+	// identity that triggered the run needs to be allowed to perform the
+	// operation
+	err = checkAuthorization(GetAuthorizationConfiguration(configuration), cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Check authorization")
+		return ExitStatusNotAuthorized, err
+	}
+
+	// destructive operations are allowed in maintenance window only
+	err = checkMaintenanceWindow(GetMaintenanceWindowConfiguration(configuration), cliFlags, time.Now())
+	if err != nil {
+		log.Err(err).Msg("Check maintenance window")
+		return ExitStatusOutsideWindow, err
+	}
+
+	// destructive operations need to be performed against the right
+	// database
+	err = checkConfirmation(&configuration.Storage, cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Check confirmation")
+		return ExitStatusNotConfirmed, err
+	}
+
+	// connection is opened lazily, so it is needed to check if database
+	// is reachable before the operation is started; status is written even
+	// when database is not reachable
+	if connection != nil && !isInformationalOperation(cliFlags) && !cliFlags.Status {
+		err = pingDatabase(connection, &configuration.Storage)
+		if err != nil {
+			return ExitStatusStorageError, err
+		}
+		// make sure that the right database is used
+		err = verifyDatabaseFingerprint(connection, &configuration.Storage)
+		if err != nil {
+			var mismatchErr *ErrFingerprintMismatch
+			if errors.As(err, &mismatchErr) {
+				return ExitStatusFingerprintMismatch, err
+			}
+			return ExitStatusStorageError, err
+		}
+		// delete statements need to match layout of aggregator tables
+		err = checkMigrationVersion(connection, &configuration.Storage)
+		if err != nil {
+			var migrationErr *ErrUnsupportedMigration
+			if errors.As(err, &migrationErr) {
+				return ExitStatusUnsupportedMigration, err
+			}
+			return ExitStatusStorageError, err
+		}
+		// destructive operation must not fail halfway through because
+		// of missing grants; tables do not exist before -init-schema
+		if !cliFlags.InitSchema {
+			err = checkPrivileges(connection, &configuration.Storage, cliFlags,
+				GetCleanerConfiguration(configuration).RequireRLSBypass)
+			if err != nil {
+				log.Err(err).Msg("Check privileges")
+				var privilegesErr *ErrMissingPrivileges
+				var rowSecurityErr *ErrRowLevelSecurity
+				if errors.As(err, &privilegesErr) || errors.As(err, &rowSecurityErr) {
+					return ExitStatusInsufficientPrivilege, err
+				}
+				return exitStatusForError(err, ExitStatusStorageError), err
+			}
+		}
+	}
+
+	// tables owned by the cleaner are migrated to the version the cleaner
+	// has been built with; nothing can be changed in read-only mode and
+	// by status probe
+	if connection != nil && !isInformationalOperation(cliFlags) && !cliFlags.ReadOnly && !cliFlags.Status {
+		err = migrateCleanerTables(connection, GetHistoryConfiguration(configuration).Table)
+		if err != nil {
+			log.Err(err).Msg(migrateCleanerTablesFailed)
+			return exitStatusForError(err, ExitStatusStorageError), err
+		}
+	}
+
+	// tables are created before the selected operation, so the whole flow
+	// can be performed against in-memory database in one run
+	if cliFlags.InitSchema && !isInformationalOperation(cliFlags) {
+		status, err := prepareDatabase(connection, cliFlags, configuration.Storage.Schema)
+		if err != nil {
+			return status, err
+		}
+		// test data have been inserted already
+		cliFlags.FillInDatabase = false
+	}
+
 	switch {
 	case cliFlags.ShowVersion:
 		showVersion()
@@ -372,54 +1546,233 @@ func doSelectedOperation(configuration *ConfigStruct, connection *sql.DB, cliFla
 	case cliFlags.ShowConfiguration:
 		showConfiguration(configuration)
 		return ExitStatusOK, nil
+	case cliFlags.ListQueries:
+		return listQueries(configuration.Storage.Schema)
+	case cliFlags.ListExitCodes:
+		return listExitCodes()
+	case cliFlags.VacuumFull != "":
+		return vacuumFull(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.InstallDBSchedule != "":
+		return installDBSchedule(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.UninstallDBSchedule:
+		return uninstallDBSchedule(connection, configuration.Storage.Schema)
+	case cliFlags.ShowDBSchedule:
+		return showDBSchedule(connection, configuration.Storage.Schema)
 	case cliFlags.VacuumDatabase:
 		return vacuumDB(connection)
 	case cliFlags.PerformCleanupAll:
-		return cleanupAll(configuration, connection, cliFlags)
+		return cleanupAll(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.OrgBatch != "":
+		return orgBatch(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.Cluster != "":
+		return cleanupSingleCluster(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.Watch != "":
+		return watchRequests(configuration, connection, cliFlags, configuration.Storage.Schema)
 	case cliFlags.PerformCleanup:
 		return cleanup(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.CleanupRule != "":
+		return cleanupRule(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.CleanupKafkaOffsets != "":
+		return cleanupKafkaOffsets(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.CleanupRatings:
+		return cleanupRatings(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.CompactPayloads:
+		return compactPayloads(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.ValidatePayloads:
+		return validatePayloads(configuration, connection, cliFlags, configuration.Storage.Schema)
 	case cliFlags.DetectMultipleRuleDisable:
-		return detectMultipleRuleDisable(connection, cliFlags)
+		return detectMultipleRuleDisable(configuration, connection, cliFlags)
+	case cliFlags.SizeSnapshot != "":
+		return sizeSnapshot(connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.TimestampAnomalies != "":
+		return detectTimestampAnomalies(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.DVONamespaceStatistics:
+		return dvoNamespaceStatistics(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.BloatReport:
+		return bloatReport(connection, configuration.Storage.Schema)
+	case cliFlags.Coverage:
+		return coverageReport(connection, configuration.Storage.Schema)
+	case cliFlags.DiscoverTables != "":
+		return discoverTables(connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.ConsumerErrorOffsets:
+		return checkConsumerErrorOffsets(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.ExportConsumerErrors:
+		return exportConsumerErrors(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.FillInDatabase && cliFlags.FillInClusters > 0:
+		return bulkFillInDatabase(connection, cliFlags, configuration.Storage.Schema)
 	case cliFlags.FillInDatabase:
 		return fillInDatabase(connection, configuration.Storage.Schema)
+	case cliFlags.Digest:
+		return digest(configuration, connection, cliFlags)
+	case cliFlags.Status:
+		return showStatus(configuration, connection, cliFlags)
+	case cliFlags.ExportState != "":
+		return exportState(configuration, connection, cliFlags)
+	case cliFlags.ImportState != "":
+		return importState(configuration, connection, cliFlags)
+	case cliFlags.CompareMaxAge != "":
+		return compareMaxAge(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.Estimate:
+		return displayOldRecordsEstimates(configuration, connection, cliFlags, configuration.Storage.Schema)
+	case cliFlags.CountOnly:
+		return displayOldRecordsCounts(configuration, connection, cliFlags, configuration.Storage.Schema)
 	default:
 		return displayOldRecords(configuration, connection, cliFlags, configuration.Storage.Schema)
 	}
 	// we should not end there
 }
 
+// loadAndCheckConfiguration function loads configuration and checks it
+// before any operation is started, so invalid configuration is not found
+// deep inside operations
+func loadAndCheckConfiguration(configFileEnvVariableName, defaultConfigFile string) (ConfigStruct, error) {
+	config, err := LoadConfiguration(configFileEnvVariableName, defaultConfigFile)
+	if err != nil {
+		log.Err(err).Msg("Load configuration")
+		return config, err
+	}
+	err = CheckConfiguration(&config)
+	if err != nil {
+		log.Err(err).Msg("Check configuration")
+		return config, err
+	}
+	return config, nil
+}
+
+// runForStorage function performs selected operation against storage
+// specified in configuration file. Connection to database is closed when the
+// operation finishes.
+func runForStorage(configuration *ConfigStruct, cliFlags CliFlags) (ExitStatus, error) {
+	// initialize connection to database
+	connection, err := initDatabaseConnection(&configuration.Storage)
+	if err != nil {
+		log.Err(err).Msg("Connection to database not established")
+	}
+	defer closeDatabaseConnection(connection)
+
+	return doSelectedOperation(configuration, connection, cliFlags)
+}
+
 func main() {
+	// used to compute duration of the whole run
+	started := time.Now()
 
 	// command line flags
 	var cliFlags CliFlags
 
 	// define and parse all command line options
+	flag.StringVar(&cliFlags.ConfigFile, "config", "", "path to configuration file, takes precedence over "+configFileEnvVariableName)
 	flag.BoolVar(&cliFlags.PerformCleanup, "cleanup", false, "perform database cleanup")
 	flag.BoolVar(&cliFlags.PerformCleanupAll, "cleanup-all", false, "perform database cleanup for all old clusters")
-	flag.BoolVar(&cliFlags.DryRun, "dry-run", true, "if true, the cleanup-all method won't delete any row, just print how many are affected")
+	flag.StringVar(&cliFlags.SimulateInSchema, "simulate-in-schema", "", "copy records selected by cleanup-all into given scratch schema and verify their deletion there before live tables are cleaned up")
+	flag.StringVar(&cliFlags.CleanupRule, "cleanup-rule", "", "delete all records referencing retired rule specified as 'rule.fqdn|ERROR_KEY' (error key is optional)")
+	flag.StringVar(&cliFlags.CleanupKafkaOffsets, "cleanup-kafka-offsets", "", "delete reports stored from Kafka messages with offsets in range specified as FROM-TO (inclusive), -force is required when not run in dry-run mode")
+	flag.BoolVar(&cliFlags.CleanupRatings, "cleanup-ratings", false, "delete Advisor ratings for organization selected by -org-id and/or rule selected by -rule")
+	flag.StringVar(&cliFlags.Rule, "rule", "", "rule specified as 'rule.fqdn|ERROR_KEY' used to select Advisor ratings to cleanup (error key is optional)")
+	flag.BoolVar(&cliFlags.CompactPayloads, "compact-payloads", false, "drop payload from records older than max age while keeping the records")
+	flag.BoolVar(&cliFlags.ValidatePayloads, "validate-payloads", false, "report old records with empty or invalid JSON payload and delete them when dry run is disabled")
+	flag.BoolVar(&cliFlags.DryRun, "dry-run", true, "if true, the cleanup-all, cleanup-rule, cleanup-kafka-offsets, cleanup-ratings, compact-payloads, validate-payloads, and cluster methods won't change any row, just print how many are affected")
+	flag.BoolVar(&cliFlags.ReadOnly, "read-only", false, "convert all operations into their non-destructive variants and refuse any statement that modifies data")
+	flag.BoolVar(&cliFlags.SafeMode, "safe-mode", false, "require destructive operations to be confirmed by -database and -schema")
+	flag.StringVar(&cliFlags.Database, "database", "", "name of database from configuration, used to confirm destructive operation")
+	flag.StringVar(&cliFlags.Schema, "schema", "", "name of DB schema from configuration, used to confirm destructive operation")
 	flag.BoolVar(&cliFlags.PrintSummaryTable, "summary", false, "print summary table after cleanup")
+	flag.BoolVar(&cliFlags.NoColor, "no-color", false, "do not use colors in summary table even when standard output is a terminal")
+	flag.BoolVar(&cliFlags.SummaryByDeletions, "summary-by-deletions", false, "sort tables in summary table by number of deletions instead of their names")
 	flag.BoolVar(&cliFlags.DetectMultipleRuleDisable, "multiple-rule-disable", false, "list clusters with the same rule(s) disabled by different users")
+	flag.StringVar(&cliFlags.SizeSnapshot, "size-snapshot", "", "append sizes and row counts of all tables into given CSV file")
+	flag.BoolVar(&cliFlags.DVONamespaceStatistics, "dvo-namespace-stats", false, "display statistics about DVO reports grouped by namespaces")
+	flag.StringVar(&cliFlags.TimestampAnomalies, "timestamp-anomalies", "", "list clusters where reported_at and last_checked_at differ by more than given age, for example '90 days'")
+	flag.BoolVar(&cliFlags.BloatReport, "bloat-report", false, "display dead tuples and index bloat of cleaned tables with recommended maintenance operations")
+	flag.BoolVar(&cliFlags.Coverage, "coverage", false, "compare tables present in database with tables the cleaner knows how to prune")
+	flag.StringVar(&cliFlags.DiscoverTables, "discover-tables", "", "write candidate retention entries for tables not covered by cleanup into given file, use - for standard output")
+	flag.BoolVar(&cliFlags.ConsumerErrorOffsets, "consumer-error-offsets", false, "display spread of Kafka offsets stored in consumer_error table")
+	flag.StringVar(&cliFlags.KafkaLowWatermarks, "kafka-low-watermarks", "", "delete consumer errors with offsets lower than given low watermarks, specified as topic:partition:offset list")
+	flag.BoolVar(&cliFlags.ExportConsumerErrors, "export-consumer-errors", false, "export consumer errors into output file in JSON format accepted by replay tooling")
+	flag.BoolVar(&cliFlags.DeleteExported, "delete-exported", false, "delete consumer errors that have been exported successfully")
+	flag.BoolVar(&cliFlags.Digest, "digest", false, "write weekly digest of cleanup runs recorded in history table")
+	flag.StringVar(&cliFlags.DigestFormat, "digest-format", "", "format of digest: markdown (default) or html")
+	flag.BoolVar(&cliFlags.Status, "status", false, "write compact JSON with health of database and cleaner for probes and external schedulers")
+	flag.StringVar(&cliFlags.ExportState, "export-state", "", "export state of the cleaner (run history) into given JSON file, use - for standard output")
+	flag.StringVar(&cliFlags.ImportState, "import-state", "", "import state of the cleaner (run history) from given JSON file")
 	flag.BoolVar(&cliFlags.FillInDatabase, "fill-in-db", false, "fill-in database by test data")
+	flag.BoolVar(&cliFlags.InitSchema, "init-schema", false, "create tables of selected DB schema before the selected operation, fill-in-db is performed before the operation too")
+	flag.IntVar(&cliFlags.FillInClusters, "fill-in-clusters", 0, "number of synthetic clusters inserted by fill-in-db using COPY protocol")
+	flag.IntVar(&cliFlags.FillInBatchSize, "fill-in-batch-size", defaultFillInBatchSize, "number of synthetic clusters inserted in one batch")
+	flag.BoolVar(&cliFlags.Force, "force", false, "allow destructive operation outside maintenance window and confirm cleanup-kafka-offsets and deletion of cluster selected by -cluster")
 	flag.BoolVar(&cliFlags.ShowConfiguration, "show-configuration", false, "show configuration")
 	flag.BoolVar(&cliFlags.ShowVersion, "version", false, "show cleaner version")
 	flag.BoolVar(&cliFlags.ShowAuthors, "authors", false, "show authors")
+	flag.BoolVar(&cliFlags.ListQueries, "list-queries", false, "display all SQL statements used for selected DB schema")
+	flag.BoolVar(&cliFlags.ListExitCodes, "list-exit-codes", false, "display all exit statuses with their names and descriptions")
 	flag.BoolVar(&cliFlags.VacuumDatabase, "vacuum", false, "vacuum database")
+	flag.StringVar(&cliFlags.VacuumFull, "vacuum-full", "", "comma separated list of tables to be rewritten by VACUUM FULL")
+	flag.StringVar(&cliFlags.ConfirmVacuumFull, "confirm-vacuum-full", "", "tables selected by -vacuum-full repeated to confirm the operation")
+	flag.StringVar(&cliFlags.InstallDBSchedule, "install-db-schedule", "", "install age-based delete statements as pg_cron jobs run with given cron schedule, for example '0 3 * * *'")
+	flag.BoolVar(&cliFlags.UninstallDBSchedule, "uninstall-db-schedule", false, "uninstall pg_cron jobs installed by -install-db-schedule")
+	flag.BoolVar(&cliFlags.ShowDBSchedule, "show-db-schedule", false, "display pg_cron jobs installed by -install-db-schedule")
 	flag.StringVar(&cliFlags.MaxAge, "max-age", "", "max age for displaying old records")
+	flag.StringVar(&cliFlags.CompareMaxAge, "compare-max-age", "", "display number of records that would be deleted by cleanup-all for max age and for this value")
+	flag.StringVar(&cliFlags.ConfirmMaxAge, "confirm-max-age", "", "max age repeated to confirm cleanup-all that is not run in dry-run mode")
+	flag.StringVar(&cliFlags.OlderThan, "older-than", "", "display only old records older than given age, for example '180 days'")
+	flag.StringVar(&cliFlags.NewerThan, "newer-than", "", "display only old records newer than given age, for example '365 days'")
+	flag.BoolVar(&cliFlags.CountOnly, "count-only", false, "display just number of old records in each table instead of listing them")
+	flag.BoolVar(&cliFlags.Estimate, "estimate", false, "estimate number of old records in each table from planner statistics instead of counting them")
+	flag.BoolVar(&cliFlags.ExactCount, "exact-count", false, "display exact number of old records together with estimate selected by -estimate")
+	flag.StringVar(&cliFlags.FailIfMoreThan, "fail-if-more-than", "", "exit with dedicated status when listing finds more old records than given number")
+	flag.BoolVar(&cliFlags.FailIfNone, "fail-if-none", false, "exit with dedicated status when listing does not find any old record")
+	flag.IntVar(&cliFlags.Limit, "limit", 0, "max number of old records displayed from each table, the oldest records are displayed first")
 	flag.StringVar(&cliFlags.Clusters, "clusters", "", "list of clusters to cleanup. Ignored when cleanup-all is selected")
-	flag.StringVar(&cliFlags.Output, "output", "", "filename for old cluster listing")
+	flag.StringVar(&cliFlags.Cluster, "cluster", "", "inspect, list, archive, and delete (when dry-run is disabled) records of one cluster")
+	flag.StringVar(&cliFlags.Watch, "watch", "", "clean up clusters from deletion requests dropped into given directory or S3 prefix (s3://bucket/prefix)")
+	flag.BoolVar(&cliFlags.ClustersFromInventory, "clusters-from-inventory", false, "read list of clusters to cleanup from inventory API instead of cluster list file")
+	flag.BoolVar(&cliFlags.ClustersFromAggregator, "clusters-from-aggregator", false, "read list of orphaned clusters to cleanup from aggregator endpoint instead of cluster list file")
+	flag.StringVar(&cliFlags.OrgBatch, "org-batch", "", "erase all records of organizations listed in given file (one organization ID per line)")
+	flag.BoolVar(&cliFlags.Transactional, "transactional", false, "delete records of each cluster in one transaction during cleanup")
+	flag.BoolVar(&cliFlags.VerifyDeletion, "verify-deletion", false, "count records of each cleaned up cluster in all tables after cleanup and report rows that remained")
+	flag.BoolVar(&cliFlags.RedeleteStragglers, "redelete-stragglers", false, "delete rows found after cleanup in second pass, implies -verify-deletion")
+	flag.BoolVar(&cliFlags.SkipCanary, "skip-canary", false, "do not clean up the first cluster from cluster list as canary before the rest of the list")
+	flag.StringVar(&cliFlags.DeletionMatrix, "deletion-matrix", "", "write number of rows deleted for each cluster from each table into given file (JSON for .json suffix, CSV otherwise)")
+	flag.BoolVar(&cliFlags.LogSQL, "log-sql", false, "log all executed SQL statements with their redacted parameters, duration, and number of affected rows")
+	flag.IntVar(&cliFlags.OrgID, "org-id", 0, "organization ID used to select DVO records, Advisor ratings, and payloads to cleanup")
+	flag.StringVar(&cliFlags.RequestedBy, "requested-by", "", "identity of operator who triggered the run")
+	flag.StringVar(&cliFlags.Output, "output", "", "filename for old cluster listing, use - for standard output")
+	flag.BoolVar(&cliFlags.Checksum, "checksum", false, "write SHA-256 checksum of output file into file with .sha256 suffix")
+	flag.StringVar(&cliFlags.OutputFormat, "output-format", "", "format of exported records: csv, json, or log")
+	flag.BoolVar(&cliFlags.HashClusterIDs, "hash-cluster-ids", false, "export salted SHA-256 hashes instead of cluster IDs")
+	flag.StringVar(&cliFlags.Columns, "columns", "", "comma separated list of exported columns, for example cluster,org_id,age")
+
+	// exit statuses are described in help too
+	flag.Usage = usage
 
 	// parse all command line flags
 	flag.Parse()
 
-	// config has exactly the same structure as *.toml file
-	config, err := LoadConfiguration(configFileEnvVariableName, defaultConfigFileName)
+	// configuration file specified on command line takes precedence
+	err := selectConfigurationFile(configFileEnvVariableName, cliFlags.ConfigFile)
 	if err != nil {
-		log.Err(err).Msg("Load configuration")
+		log.Err(err).Msg("Select configuration file")
+		os.Exit(int(ExitStatusConfigError))
 	}
-	err = CheckConfiguration(&config)
+
+	// config has exactly the same structure as *.toml file
+	config, err := loadAndCheckConfiguration(configFileEnvVariableName, defaultConfigFileName)
 	if err != nil {
-		log.Err(err).Msg("Check configuration")
-		return
+		os.Exit(int(ExitStatusConfigError))
+	}
+	// plug-in schemas can be selected in the same way as built-in ones
+	registerPluginSchemas(GetSchemasConfiguration(&config))
+	// references to OCP tables are qualified by schema name when it is set
+	registerOCPTableSchema(GetCleanerConfiguration(&config).OCPTableSchema)
+	// statements that take too long are reported
+	registerSlowStatementThreshold(GetCleanerConfiguration(&config).SlowStatementThreshold)
+	// statements are translated when they are executed against SQLite
+	registerStatementDialect(GetStorageConfiguration(&config).Driver)
+	// cluster IDs can be stored in database with uppercase letters
+	caseInsensitiveMatch = GetCleanerConfiguration(&config).CaseInsensitiveMatch
+	// records written to standard output must not be mixed with logs
+	if isStandardOutput(cliFlags.Output) || isStandardOutput(cliFlags.DiscoverTables) {
+		config.Logging.UseStderr = true
 	}
 	err = logger.InitZerolog(
 		GetLoggingConfiguration(&config),
@@ -430,28 +1783,74 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// find out who triggered the run
+	cliFlags.ServiceAccount = resolveServiceAccount()
+	cliFlags.RequestedBy = resolveRequestedBy(cliFlags.RequestedBy, cliFlags.ServiceAccount)
+
+	// attach correlation ID and identity to all log events
+	log.Logger = log.With().
+		Str(runIDAttribute, runID).
+		Str(requestedByAttribute, cliFlags.RequestedBy).
+		Logger()
 	log.Debug().Msg("Started")
+	// row-level events can be sampled to limit volume of logs
+	registerLogSampling(GetLogSamplingConfiguration(&config).RowEvents)
+	// statement logging is meant for diagnostic purposes
+	logStatements = cliFlags.LogSQL
+	// summary table is adjusted to terminal
+	configureTerminalOutput(cliFlags.NoColor)
+	// read-only mode can be enabled from command line as well
+	cliFlags.ReadOnly = cliFlags.ReadOnly || GetCleanerConfiguration(&config).ReadOnly
+	readOnlyMode = cliFlags.ReadOnly
+	// memory and other session settings can differ between operations
+	registerSessionSettings(GetSessionSettingsConfiguration(&config), operationName(cliFlags))
+	// safe mode can be enabled from command line as well
+	cliFlags.SafeMode = cliFlags.SafeMode || GetCleanerConfiguration(&config).SafeMode
 	// override default value read from configuration file
 	if cliFlags.MaxAge != "" {
 		config.Cleaner.MaxAge = cliFlags.MaxAge
 	}
-	// initialize connection to database
-	connection, err := initDatabaseConnection(&config.Storage)
-	if err != nil {
-		log.Err(err).Msg("Connection to database not established")
+	// checksum can be enabled from command line as well
+	if cliFlags.Checksum {
+		config.Output.Checksum = true
+	}
+	// override output format read from configuration file
+	if cliFlags.OutputFormat != "" {
+		config.Output.Format = cliFlags.OutputFormat
+	}
+	// override exported columns read from configuration file
+	if cliFlags.Columns != "" {
+		config.Output.Columns = cliFlags.Columns
+	}
+	// hashing of cluster IDs can be enabled from command line as well
+	if cliFlags.HashClusterIDs {
+		config.Output.HashClusterIDs = true
 	}
-
 	// perform selected operation
-	exitStatus, err := doSelectedOperation(&config, connection, cliFlags)
+	var exitStatus ExitStatus
+	if len(GetTargetsConfiguration(&config)) > 0 && !isInformationalOperation(cliFlags) {
+		// operation is performed against all storage targets
+		exitStatus, err = runForAllTargets(&config, cliFlags)
+	} else {
+		exitStatus, err = runForStorage(&config, cliFlags)
+	}
+
+	// export metrics for node-exporter textfile collector (if enabled)
+	recordRunFinished(started, exitStatus)
+	_ = writeMetricsTextfile(config.Metrics.TextfilePath)
+
+	// one event summarizing the run is used by log-based alerts
+	logRunSummary(cliFlags, started, exitStatus, err)
+
 	if err != nil {
-		log.Err(err).Msg("Operation failed")
+		logOperationFailed(err)
 		logger.CloseZerolog()
-		os.Exit(exitStatus)
+		os.Exit(int(exitStatus))
 		return
 	}
 	// finito
 
 	log.Debug().Msg("Finished")
 	logger.CloseZerolog()
-	os.Exit(ExitStatusOK)
+	os.Exit(int(ExitStatusOK))
 }
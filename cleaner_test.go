@@ -22,6 +22,7 @@ package main_test
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -46,6 +47,20 @@ func checkCapture(t *testing.T, err error) {
 	}
 }
 
+// properClusterEntries is number of proper cluster names in cluster_list.txt
+const properClusterEntries = 5
+
+// expectOCPClusterCleanup function prepares expectations for cleanup of
+// given number of clusters in OCP database
+func expectOCPClusterCleanup(mock sqlmock.Sqlmock, clusters int) {
+	for i := 0; i < clusters; i++ {
+		for _, tableAndKey := range main.TablesAndKeysInOCPDatabase {
+			mock.ExpectExec("DELETE FROM " + tableAndKey.TableName + " WHERE").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+}
+
 // TestShowVersion checks the function showVersion
 func TestShowVersion(t *testing.T) {
 	const expected = "Insights Results Aggregator Cleaner version 1.0\n"
@@ -143,6 +158,78 @@ func TestIsValidUUID(t *testing.T) {
 	}
 }
 
+// TestParseAge checks the function parseAge
+func TestParseAge(t *testing.T) {
+	const day = 24 * time.Hour
+
+	type testCase struct {
+		input    string
+		expected time.Duration
+		isError  bool
+	}
+
+	testCases := []testCase{
+		{"", 0, false},
+		{"180 days", 180 * day, false},
+		{"1 day", day, false},
+		{" 12 hours ", 12 * time.Hour, false},
+		{"2 Weeks", 14 * day, false},
+		{"36h", 36 * time.Hour, false},
+		{"180", 0, true},
+		{"x days", 0, true},
+		{"-1 days", 0, true},
+		{"10 fortnights", 0, true},
+		{"1 2 days", 0, true},
+	}
+
+	for _, tc := range testCases {
+		age, err := main.ParseAge(tc.input)
+		if tc.isError {
+			assert.Error(t, err, tc.input)
+		} else {
+			assert.NoError(t, err, tc.input)
+			assert.Equal(t, tc.expected, age, tc.input)
+		}
+	}
+}
+
+// TestReadListingFilter checks the function readListingFilter
+func TestReadListingFilter(t *testing.T) {
+	filter, err := main.ReadListingFilter(main.CliFlags{
+		OlderThan: "180 days",
+		NewerThan: "365 days",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 180*24*time.Hour, filter.OlderThan)
+	assert.Equal(t, 365*24*time.Hour, filter.NewerThan)
+
+	_, err = main.ReadListingFilter(main.CliFlags{OlderThan: "foo"})
+	assert.Error(t, err)
+
+	_, err = main.ReadListingFilter(main.CliFlags{NewerThan: "foo"})
+	assert.Error(t, err)
+
+	filter, err = main.ReadListingFilter(main.CliFlags{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, filter.Limit)
+
+	_, err = main.ReadListingFilter(main.CliFlags{Limit: -1})
+	assert.Error(t, err)
+}
+
+// TestDisplayOldRecordsWrongFilter checks the function displayOldRecords
+// when improper listing filter is specified
+func TestDisplayOldRecordsWrongFilter(t *testing.T) {
+	configuration := main.ConfigStruct{}
+	cliFlags := main.CliFlags{
+		OlderThan: "foo",
+	}
+
+	exitCode, err := main.DisplayOldRecords(&configuration, nil, cliFlags, main.DBSchemaOCPRecommendations)
+	assert.Error(t, err, "error is expected while calling tested function")
+	assert.Equal(t, main.ExitStatusConfigError, exitCode)
+}
+
 // TestDoSelectedOperationShowVersion checks the function showVersion called
 // via doSelectedOperation function
 func TestDoSelectedOperationShowVersion(t *testing.T) {
@@ -287,6 +374,177 @@ func TestDoSelectedOperationPerformCleanup(t *testing.T) {
 	assert.Equal(t, code, main.ExitStatusPerformCleanupError)
 }
 
+// TestDoSelectedOperationDatabaseUnreachable checks that doSelectedOperation
+// function does not start any operation when database is not reachable
+func TestDoSelectedOperationDatabaseUnreachable(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no other statement is expected
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectClose()
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{}
+
+	cliFlags := main.CliFlags{
+		PerformCleanupAll: true,
+	}
+
+	// call tested function
+	code, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+
+	// error is expected
+	assert.ErrorIs(t, err, main.ErrDatabaseUnreachable)
+
+	// check the status
+	assert.Equal(t, main.ExitStatusStorageError, code)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadOnlyOperation checks that operations are converted into their
+// non-destructive variants in read-only mode
+func TestReadOnlyOperation(t *testing.T) {
+	// read-only mode is not enabled
+	cliFlags, err := main.ReadOnlyOperation(main.CliFlags{PerformCleanupAll: true})
+	assert.NoError(t, err)
+	assert.False(t, cliFlags.DryRun)
+
+	// dry run is enforced, so the operation is not destructive anymore
+	cliFlags, err = main.ReadOnlyOperation(main.CliFlags{ReadOnly: true, PerformCleanupAll: true})
+	assert.NoError(t, err)
+	assert.True(t, cliFlags.DryRun)
+	assert.False(t, main.IsDestructiveOperation(cliFlags))
+	assert.Equal(t, main.OperationCategoryList, main.OperationCategory(cliFlags))
+
+	// listing is allowed
+	_, err = main.ReadOnlyOperation(main.CliFlags{ReadOnly: true, CountOnly: true})
+	assert.NoError(t, err)
+
+	// operations without non-destructive variant are refused
+	refused := []main.CliFlags{
+		{ReadOnly: true, PerformCleanup: true},
+		{ReadOnly: true, OrgBatch: "orgs.txt"},
+		{ReadOnly: true, VacuumDatabase: true},
+		{ReadOnly: true, VacuumFull: "report"},
+		{ReadOnly: true, InstallDBSchedule: "0 3 * * *"},
+		{ReadOnly: true, UninstallDBSchedule: true},
+		{ReadOnly: true, FillInDatabase: true},
+		{ReadOnly: true, InitSchema: true},
+		{ReadOnly: true, ImportState: "state.json"},
+		{ReadOnly: true, PerformCleanupAll: true, SimulateInSchema: "scratch"},
+	}
+	for _, flags := range refused {
+		_, err = main.ReadOnlyOperation(flags)
+		assert.ErrorIs(t, err, main.ErrReadOnly, flags)
+	}
+}
+
+// TestDoSelectedOperationReadOnly checks that cleanup is refused in
+// read-only mode
+func TestDoSelectedOperationReadOnly(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	cliFlags := main.CliFlags{
+		ReadOnly:       true,
+		PerformCleanup: true,
+	}
+
+	code, err := main.DoSelectedOperation(&configuration, nil, cliFlags)
+	assert.ErrorIs(t, err, main.ErrReadOnly)
+	assert.Equal(t, main.ExitStatusReadOnlyViolation, code)
+}
+
+// TestDoSelectedOperationFingerprintMismatch checks that doSelectedOperation
+// function does not start any operation when database fingerprint does not
+// match
+func TestDoSelectedOperationFingerprintMismatch(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no other statement is expected
+	mock.ExpectPing()
+	mock.ExpectQuery(`SELECT current_database\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"current_database"}).AddRow("aggregator_prod"))
+	mock.ExpectClose()
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Fingerprint: "aggregator_stage",
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		PerformCleanup: true,
+	}
+
+	// call tested function
+	code, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+
+	// error is expected
+	var mismatchErr *main.ErrFingerprintMismatch
+	assert.ErrorAs(t, err, &mismatchErr)
+
+	// check the status
+	assert.Equal(t, main.ExitStatusFingerprintMismatch, code)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDoSelectedOperationUnsupportedMigration checks that no operation is
+// performed against database migrated by newer version of aggregator
+func TestDoSelectedOperationUnsupportedMigration(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no other statement is expected
+	mock.ExpectPing()
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM migration_info`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1000))
+	mock.ExpectClose()
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Schema:         main.DBSchemaOCPRecommendations,
+			MigrationCheck: main.MigrationCheckRefuse,
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		PerformCleanup: true,
+	}
+
+	// call tested function
+	code, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+
+	// error is expected
+	var migrationErr *main.ErrUnsupportedMigration
+	assert.ErrorAs(t, err, &migrationErr)
+
+	// check the status
+	assert.Equal(t, main.ExitStatusUnsupportedMigration, code)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
 // TestDoSelectedOperationDetectMultipleRuleDisable checks the function
 // detectMultipleRuleDisable called via doSelectedOperation function
 func TestDoSelectedOperationDetectMultipleRuleDisable(t *testing.T) {
@@ -365,13 +623,28 @@ func TestDoSelectedOperationDefaultOperation(t *testing.T) {
 	assert.Equal(t, code, main.ExitStatusStorageError)
 }
 
+// readClusterList function reads cluster list from file or from CLI argument
+// via cluster list provider selected by configuration and CLI flags
+func readClusterList(filename, clusters string) (main.ClusterList, int, error) {
+	configuration := main.ConfigStruct{
+		Cleaner: main.CleanerConfiguration{
+			ClusterListFile: filename,
+		},
+	}
+	cliFlags := main.CliFlags{
+		Clusters: clusters,
+	}
+	clusterList, diagnostics, err := main.ReadClusterList(&configuration, nil, cliFlags)
+	return clusterList, diagnostics.ImproperEntries, err
+}
+
 // TestReadClusterList checks the function readClusterList from
-// cleaner.go using correct cluster list file
+// cluster_list.go using correct cluster list file
 func TestReadClusterList(t *testing.T) {
 	// cluster list file with 8 clusters in total:
 	// 5 correct cluster names
 	// 3 incorrect cluster names
-	clusterList, improperClusterCount, err := main.ReadClusterList("tests/cluster_list.txt", "")
+	clusterList, improperClusterCount, err := readClusterList("tests/cluster_list.txt", "")
 
 	// file is correct - no errors should be thrown
 	assert.NoError(t, err)
@@ -402,7 +675,7 @@ func TestReadClusterListNoFile(t *testing.T) {
 func TestReadClusterListCLICase1(t *testing.T) {
 	// just one cluster name is specified on CLI
 	input := "5d5892d4-1f74-4ccf-91af-548dfc9767aa"
-	clusterList, improperClusterCount, err := main.ReadClusterList("tests/cluster_list.txt", input)
+	clusterList, improperClusterCount, err := readClusterList("tests/cluster_list.txt", input)
 
 	// input is correct - no errors should be thrown
 	assert.NoError(t, err)
@@ -422,7 +695,7 @@ func TestReadClusterListCLICase2(t *testing.T) {
 	input := "5d5892d4-1f74-4ccf-91af-548dfc9767aa,ffffffff-1f74-4ccf-91af-548dfc9767aa"
 
 	// input is correct - no errors should be thrown
-	clusterList, improperClusterCount, err := main.ReadClusterList("tests/cluster_list.txt", input)
+	clusterList, improperClusterCount, err := readClusterList("tests/cluster_list.txt", input)
 
 	// both cluster names are correct
 	assert.NoError(t, err)
@@ -440,7 +713,7 @@ func TestReadClusterListCLICase2(t *testing.T) {
 // cleaner.go using provided CLI arguments
 func TestReadClusterListCLICase3(t *testing.T) {
 	input := "5d5892d4-1f74-4ccf-91af-548dfc9767aa,this-is-not-correct"
-	clusterList, improperClusterCount, err := main.ReadClusterList("tests/cluster_list.txt", input)
+	clusterList, improperClusterCount, err := readClusterList("tests/cluster_list.txt", input)
 
 	// just the first cluster name is correct
 	assert.NoError(t, err)
@@ -457,7 +730,7 @@ func TestReadClusterListCLICase3(t *testing.T) {
 // cleaner.go using provided CLI arguments
 func TestReadClusterListCLICase4(t *testing.T) {
 	input := "this-is-not-correct,this-also-is-not-correct"
-	clusterList, improperClusterCount, err := main.ReadClusterList("tests/cluster_list.txt", input)
+	clusterList, improperClusterCount, err := readClusterList("tests/cluster_list.txt", input)
 
 	// both cluster names are incorrect, but the whole algorithm does not throw an error
 	assert.NoError(t, err)
@@ -640,6 +913,137 @@ func TestPrintSummaryTableBasicCase(t *testing.T) {
 	assert.Contains(t, output, expected)
 }
 
+// TestPrintSummaryTableFailedClusterEntries check the behaviour of function
+// PrintSummaryTable for summary with clusters that could not be cleaned up.
+func TestPrintSummaryTableFailedClusterEntries(t *testing.T) {
+	const expected = `+--------------------------+-------+
+|         SUMMARY          | COUNT |
++--------------------------+-------+
+| Proper cluster entries   |    42 |
+| Improper cluster entries |     0 |
+| Failed cluster entries   |     2 |
+|                          |       |
++--------------------------+-------+
+|     TOTAL DELETIONS      |   0   |
++--------------------------+-------+
+`
+
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		summary := main.Summary{
+			ProperClusterEntries:   42,
+			ImproperClusterEntries: 0,
+			FailedClusterEntries:   2,
+			DeletionsForTable:      make(map[string]int),
+		}
+		main.PrintSummaryTable(summary)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text contains expected summary table
+	assert.Contains(t, output, expected)
+}
+
+// TestPrintSummaryTableNormalizedClusterEntries check the behaviour of
+// function PrintSummaryTable for summary with normalized cluster entries.
+func TestPrintSummaryTableNormalizedClusterEntries(t *testing.T) {
+	const expected = `+----------------------------+-------+
+|          SUMMARY           | COUNT |
++----------------------------+-------+
+| Proper cluster entries     |    42 |
+| Improper cluster entries   |     0 |
+| Normalized cluster entries |     3 |
+|                            |       |
++----------------------------+-------+
+|      TOTAL DELETIONS       |   0   |
++----------------------------+-------+
+`
+
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		summary := main.Summary{
+			ProperClusterEntries:     42,
+			ImproperClusterEntries:   0,
+			NormalizedClusterEntries: 3,
+			DeletionsForTable:        make(map[string]int),
+		}
+		main.PrintSummaryTable(summary)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text contains expected summary table
+	assert.Contains(t, output, expected)
+}
+
+// TestPrintSummaryTableRowsBeforeCleanup check the behaviour of function
+// PrintSummaryTable for summary with number of rows before cleanup.
+func TestPrintSummaryTableRowsBeforeCleanup(t *testing.T) {
+	const expected = `+-------------------------------+-------+---------------------+
+|            SUMMARY            | COUNT | ROWS BEFORE CLEANUP |
++-------------------------------+-------+---------------------+
+| Proper cluster entries        |     1 |                     |
+| Improper cluster entries      |     0 |                     |
+|                               |       |                     |
+| Deletions from table 'report' |     2 |             1400000 |
++-------------------------------+-------+---------------------+
+|        TOTAL DELETIONS        |   2   |       1400000       |
++-------------------------------+-------+---------------------+
+`
+
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		summary := main.Summary{
+			ProperClusterEntries: 1,
+			DeletionsForTable:    map[string]int{"report": 2},
+			RowsBeforeCleanup:    map[string]int64{"report": 1400000},
+		}
+		main.PrintSummaryTable(summary)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text contains expected summary table
+	assert.Contains(t, output, expected)
+}
+
+// TestReadRowCountsForSummary check the function readRowCountsForSummary
+func TestReadRowCountsForSummary(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table", "size", "rows"})
+	rows.AddRow("public.report", 8192, 1400000)
+	rows.AddRow("public.rule_hit", 8192, 2)
+	mock.ExpectQuery("SELECT schemaname").WithArgs("public").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT schemaname").WithArgs("public").WillReturnError(errors.New("query error"))
+	mock.ExpectClose()
+
+	// row counts are read only when summary table is displayed
+	cliFlags := main.CliFlags{PrintSummaryTable: true}
+	rowCounts := main.ReadRowCountsForSummary(connection, main.DBSchemaOCPRecommendations, cliFlags)
+	assert.Equal(t, map[string]int64{"report": 1400000, "rule_hit": 2}, rowCounts)
+
+	// failure is not fatal
+	rowCounts = main.ReadRowCountsForSummary(connection, main.DBSchemaOCPRecommendations, cliFlags)
+	assert.Nil(t, rowCounts)
+
+	// no query is expected
+	rowCounts = main.ReadRowCountsForSummary(connection, main.DBSchemaOCPRecommendations, main.CliFlags{})
+	assert.Nil(t, rowCounts)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
 // TestPrintSummaryTableProperClusterEntries check the behaviour of function
 // PrintSummaryTable for summary with non zero changes made in database.
 func TestPrintSummaryTableProperClusterEntries(t *testing.T) {
@@ -740,8 +1144,8 @@ func TestPrintSummaryTableOneTableDeletion(t *testing.T) {
 // TestPrintSummaryTableTwoTablesDeletions check the behaviour of function
 // PrintSummaryTable for summary with multiple deletions in two tables.
 func TestPrintSummaryTableTwoTablesDeletions(t *testing.T) {
-	// we work with map and there is no guarantees which order will be choosen in runtime
-	const expected1 = `+--------------------------------+-------+
+	// tables are sorted by their names
+	const expected = `+--------------------------------+-------+
 |            SUMMARY             | COUNT |
 +--------------------------------+-------+
 | Proper cluster entries         |     0 |
@@ -753,29 +1157,56 @@ func TestPrintSummaryTableTwoTablesDeletions(t *testing.T) {
 |        TOTAL DELETIONS         |   3   |
 +--------------------------------+-------+
 `
-	const expected2 = `+--------------------------------+-------+
+
+	deletions := map[string]int{
+		"TABLE_X": 1,
+		"TABLE_Y": 2,
+	}
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		summary := main.Summary{
+			ProperClusterEntries:   0,
+			ImproperClusterEntries: 0,
+			DeletionsForTable:      deletions,
+		}
+		main.PrintSummaryTable(summary)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text is the expected summary table
+	assert.Equal(t, expected, output)
+}
+
+// TestPrintSummaryTableSortedByDeletions check the behaviour of function
+// PrintSummaryTable for summary with tables sorted by number of deletions.
+func TestPrintSummaryTableSortedByDeletions(t *testing.T) {
+	// the highest number of deletions first, ties sorted by names
+	const expected = `+--------------------------------+-------+
 |            SUMMARY             | COUNT |
 +--------------------------------+-------+
 | Proper cluster entries         |     0 |
 | Improper cluster entries       |     0 |
 |                                |       |
 | Deletions from table 'TABLE_Y' |     2 |
+| Deletions from table 'TABLE_W' |     1 |
 | Deletions from table 'TABLE_X' |     1 |
 +--------------------------------+-------+
-|        TOTAL DELETIONS         |   3   |
+|        TOTAL DELETIONS         |   4   |
 +--------------------------------+-------+
 `
 
 	deletions := map[string]int{
 		"TABLE_X": 1,
 		"TABLE_Y": 2,
+		"TABLE_W": 1,
 	}
 	// try to call the tested function and capture its output
 	output, err := capture.StandardOutput(func() {
 		summary := main.Summary{
-			ProperClusterEntries:   0,
-			ImproperClusterEntries: 0,
-			DeletionsForTable:      deletions,
+			DeletionsForTable: deletions,
+			SortByDeletions:   true,
 		}
 		main.PrintSummaryTable(summary)
 	})
@@ -783,12 +1214,51 @@ func TestPrintSummaryTableTwoTablesDeletions(t *testing.T) {
 	// check the captured text
 	checkCapture(t, err)
 
-	// check if captured text contains expected summary table
-	// again: we work with map and there is no guarantees which order will
-	// be choosen in runtime
-	if output != expected1 && output != expected2 {
-		t.Error("Unexpected output", output)
+	// check if captured text is the expected summary table
+	assert.Equal(t, expected, output)
+}
+
+// TestPrintSummaryTableRunID check the behaviour of function
+// PrintSummaryTable for summary with correlation ID.
+func TestPrintSummaryTableRunID(t *testing.T) {
+	const runID = "5d5892d4-1f74-4ccf-91af-548dfc9767aa"
+
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		summary := main.Summary{
+			RunID:             runID,
+			DeletionsForTable: make(map[string]int),
+		}
+		main.PrintSummaryTable(summary)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text contains run ID
+	assert.Contains(t, output, "Run ID: "+runID)
+}
+
+// TestNewSummary check that fields common to all cleanup operations are
+// filled in by newSummary function
+func TestNewSummary(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Name: "ocp",
+		},
+	}
+	cliFlags := main.CliFlags{
+		RequestedBy:        "operator",
+		SummaryByDeletions: true,
 	}
+
+	summary := main.NewSummary(&configuration, cliFlags)
+
+	assert.Equal(t, "ocp", summary.Target)
+	assert.Equal(t, *main.RunID, summary.RunID)
+	assert.Equal(t, "operator", summary.RequestedBy)
+	assert.True(t, summary.SortByDeletions)
+	assert.Nil(t, summary.DeletionsForTable)
 }
 
 // TestVacuumDBPositiveCase check the function vacuumDB when the DB
@@ -881,10 +1351,10 @@ func TestCleanupNoConnection(t *testing.T) {
 	status, err := main.Cleanup(&configuration, nil, cliFlags, main.DBSchemaOCPRecommendations)
 
 	// error is expected
-	assert.Error(t, err, "error is expected while calling main.cleanup")
+	assert.ErrorIs(t, err, main.ErrNoConnection)
 
-	// check the status
-	assert.Equal(t, status, main.ExitStatusPerformCleanupError)
+	// missing connection is reported as storage error
+	assert.Equal(t, status, main.ExitStatusStorageError)
 }
 
 // TestCleanupOnReadClusterListError check the function cleanup when
@@ -918,8 +1388,9 @@ func TestCleanupOnReadClusterListError(t *testing.T) {
 // summary table should not be printed
 func TestCleanup(t *testing.T) {
 	// prepare new mocked connection to database
-	connection, _, err := sqlmock.New()
+	connection, mock, err := sqlmock.New()
 	assert.NoError(t, err, "error creating SQL mock")
+	expectOCPClusterCleanup(mock, properClusterEntries)
 
 	// stub for structures needed to call the tested function
 	configuration := main.ConfigStruct{}
@@ -934,6 +1405,7 @@ func TestCleanup(t *testing.T) {
 		ShowAuthors:       false,
 		ShowConfiguration: false,
 		PrintSummaryTable: false,
+		SkipCanary:        true,
 	}
 
 	// call the tested function
@@ -946,12 +1418,86 @@ func TestCleanup(t *testing.T) {
 	assert.Equal(t, status, main.ExitStatusOK)
 }
 
+// TestCleanupFailedClusters check the function cleanup when some clusters
+// can not be cleaned up
+func TestCleanupFailedClusters(t *testing.T) {
+	// prepare new mocked connection to database (no deletion is expected)
+	connection, _, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge:          "3 days",
+		ClusterListFile: "cluster_list.txt",
+	}
+
+	cliFlags := main.CliFlags{
+		PrintSummaryTable: true,
+		SkipCanary:        true,
+	}
+
+	var status main.ExitStatus
+	var cleanupErr error
+
+	// call the tested function
+	output, err := capture.StandardOutput(func() {
+		status, cleanupErr = main.Cleanup(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+	})
+	checkCapture(t, err)
+
+	// error is expected
+	assert.Error(t, cleanupErr, "error is expected while calling main.cleanup")
+
+	// summary is printed even when some clusters failed
+	assert.Contains(t, output, "Failed cluster entries")
+
+	// check the status
+	assert.Equal(t, main.ExitStatusPerformCleanupError, status)
+}
+
+// TestCleanupEvidenceError check the function cleanup when deletion
+// evidence can not be signed
+func TestCleanupEvidenceError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, _, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge:          "3 days",
+		ClusterListFile: "cluster_list.txt",
+	}
+
+	// private key does not exist
+	directory := t.TempDir()
+	configuration.Evidence = main.EvidenceConfiguration{
+		File:       directory + "/evidence.json",
+		PrivateKey: directory + "/key.pem",
+	}
+
+	cliFlags := main.CliFlags{}
+
+	// call the tested function
+	status, err := main.Cleanup(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.cleanup")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusEvidenceError)
+}
+
 // TestCleanupPrintSummaryTable check the function cleanup when
 // summary table should be printed
 func TestCleanupPrintSummaryTable(t *testing.T) {
 	// prepare new mocked connection to database
-	connection, _, err := sqlmock.New()
+	connection, mock, err := sqlmock.New()
 	assert.NoError(t, err, "error creating SQL mock")
+	expectOCPClusterCleanup(mock, properClusterEntries)
 
 	// stub for structures needed to call the tested function
 	configuration := main.ConfigStruct{}
@@ -966,6 +1512,7 @@ func TestCleanupPrintSummaryTable(t *testing.T) {
 		ShowAuthors:       false,
 		ShowConfiguration: false,
 		PrintSummaryTable: true,
+		SkipCanary:        true,
 	}
 
 	// call the tested function
@@ -1000,79 +1547,565 @@ func TestCleanupCheckSummaryTableContent(t *testing.T) {
 		"+-----------------------------------------------------------+-------+",
 	}
 
-	// prepare new mocked connection to database
-	connection, _, err := sqlmock.New()
-	assert.NoError(t, err, "error creating SQL mock")
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+	expectOCPClusterCleanup(mock, properClusterEntries)
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge:          "3 days",
+		ClusterListFile: "cluster_list.txt",
+	}
+
+	cliFlags := main.CliFlags{
+		ShowVersion:       false,
+		ShowAuthors:       false,
+		ShowConfiguration: false,
+		PrintSummaryTable: true,
+		SkipCanary:        true,
+	}
+
+	var status main.ExitStatus
+
+	// call the tested function
+	output, err := capture.StandardOutput(func() {
+		status, _ = main.Cleanup(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	// check if captured text contains expected summary table
+	for _, expectedLine := range expectedOutputLines {
+		assert.Contains(t, output, expectedLine)
+	}
+
+	// correlation ID must be part of the summary
+	assert.Contains(t, output, "Run ID: "+*main.RunID)
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
+}
+
+// TestCleanupAll check the function cleanupAll when
+// summary table should not be printed
+func TestCleanupAll(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: "3 days",
+	}
+
+	cliFlags := main.CliFlags{
+		ShowVersion:       false,
+		ShowAuthors:       false,
+		ShowConfiguration: false,
+		PrintSummaryTable: false,
+		MaxAge:            "3 days",
+		ConfirmMaxAge:     "3 days",
+	}
+
+	for range cleaner.TablesToDeleteOCP {
+		mock.ExpectExec("DELETE*").WithArgs(configuration.Cleaner.MaxAge).
+			WillReturnResult(sqlmock.NewResult(1, 2))
+	}
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.CleanupAll(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is not expected
+	assert.NoError(t, err, "error is not expected while calling main.cleanupAll")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
+}
+
+// TestCheckConfirmedMaxAge checks the function checkConfirmedMaxAge
+func TestCheckConfirmedMaxAge(t *testing.T) {
+	type testCase struct {
+		maxAge          string
+		confirmedMaxAge string
+		expectedError   bool
+	}
+
+	testCases := []testCase{
+		{"90 days", "90 days", false},
+		{" 90 days", "90 days ", false},
+		{"", "", true},
+		{"90 days", "", true},
+		{"", "90 days", true},
+		{"90 days", "9 days", true},
+	}
+
+	for _, tc := range testCases {
+		err := main.CheckConfirmedMaxAge(main.CliFlags{
+			MaxAge:        tc.maxAge,
+			ConfirmMaxAge: tc.confirmedMaxAge,
+		})
+		if tc.expectedError {
+			assert.Error(t, err, tc)
+		} else {
+			assert.NoError(t, err, tc)
+		}
+	}
+}
+
+// TestCheckConfirmedVacuumFull checks the function checkConfirmedVacuumFull
+func TestCheckConfirmedVacuumFull(t *testing.T) {
+	type testCase struct {
+		tables          string
+		confirmedTables string
+		expectedError   bool
+	}
+
+	testCases := []testCase{
+		{"report", "report", false},
+		{"report, rule_hit", "report,rule_hit ", false},
+		{"", "", true},
+		{"report", "", true},
+		{"", "report", true},
+		{"report,rule_hit", "report", true},
+		{"report,rule_hit", "rule_hit,report", true},
+	}
+
+	for _, tc := range testCases {
+		err := main.CheckConfirmedVacuumFull(main.CliFlags{
+			VacuumFull:        tc.tables,
+			ConfirmVacuumFull: tc.confirmedTables,
+		})
+		if tc.expectedError {
+			assert.Error(t, err, tc)
+		} else {
+			assert.NoError(t, err, tc)
+		}
+	}
+}
+
+// TestVacuumFull checks that the function vacuumFull vacuums only confirmed
+// tables
+func TestVacuumFull(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec(`VACUUM \(FULL, VERBOSE\) "dvo"."dvo_report"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	// not confirmed
+	configuration := main.ConfigStruct{}
+
+	status, err := main.VacuumFull(&configuration, connection, main.CliFlags{VacuumFull: "dvo_report"},
+		main.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusPerformVacuumError, status)
+
+	// confirmed
+	status, err = main.VacuumFull(&configuration, connection, main.CliFlags{VacuumFull: "dvo_report", ConfirmVacuumFull: "dvo_report"},
+		main.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupAllMaxAgeNotConfirmed check that the function cleanupAll
+// refuses to delete records when max age is not confirmed
+func TestCleanupAllMaxAgeNotConfirmed(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	mock.ExpectClose()
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: "3 days",
+	}
+
+	// max age from configuration file is not confirmed
+	cliFlags := main.CliFlags{
+		ConfirmMaxAge: "3 days",
+	}
+
+	// call the tested function
+	status, err := main.CleanupAll(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+	assert.EqualError(t, err, main.MaxAgeNotConfirmed)
+	assert.Equal(t, main.ExitStatusPerformCleanupError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupAllConfirmedMaxAge check that the function cleanupAll uses max
+// age specified on command line
+func TestCleanupAllConfirmedMaxAge(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: "3 days",
+	}
+
+	cliFlags := main.CliFlags{
+		MaxAge:        "5 days",
+		ConfirmMaxAge: "5 days",
+	}
+
+	for range cleaner.TablesToDeleteOCP {
+		mock.ExpectExec("DELETE*").WithArgs("5 days").
+			WillReturnResult(sqlmock.NewResult(1, 2))
+	}
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.CleanupAll(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+	assert.NoError(t, err, "error is not expected while calling main.cleanupAll")
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCompareMaxAge checks that number of would-be deletions is displayed
+// for both max age values via doSelectedOperation function
+func TestCompareMaxAge(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: "90 days",
+	}
+	configuration.Storage = main.StorageConfiguration{
+		Schema: main.DBSchemaDVORecommendations,
+	}
+
+	cliFlags := main.CliFlags{
+		CompareMaxAge: "120 days",
+	}
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM dvo_report").WithArgs("90 days").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM dvo_report").WithArgs("120 days").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectClose()
+
+	// call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		status, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+		assert.NoError(t, err, "error is not expected while calling tested function")
+		assert.Equal(t, main.ExitStatusOK, status)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "90 DAYS")
+	assert.Contains(t, output, "120 DAYS")
+	assert.Regexp(t, `dvo_report\s+\|\s+10\s+\|\s+4\s+\|\s+-6`, output)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCompareMaxAgeNoConnection checks the function compareMaxAge when
+// connection is not established
+func TestCompareMaxAgeNoConnection(t *testing.T) {
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: "90 days",
+	}
+
+	status, err := main.CompareMaxAge(&configuration, nil, main.CliFlags{CompareMaxAge: "120 days"}, main.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, main.ErrNoConnection)
+	assert.Equal(t, main.ExitStatusStorageError, status)
+}
+
+// TestParseRuleSelector checks parsing of rule selector
+func TestParseRuleSelector(t *testing.T) {
+	type testCase struct {
+		input            string
+		expectedRuleFQDN string
+		expectedErrorKey string
+		expectedError    bool
+	}
+
+	testCases := []testCase{
+		{"rule.test|KEY", "rule.test", "KEY", false},
+		{" rule.test | KEY ", "rule.test", "KEY", false},
+		{"rule.test", "rule.test", "", false},
+		{"rule.test|", "rule.test", "", false},
+		{"|KEY", "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			ruleFQDN, errorKey, err := main.ParseRuleSelector(tc.input)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRuleFQDN, ruleFQDN)
+			assert.Equal(t, tc.expectedErrorKey, errorKey)
+		})
+	}
+}
+
+// TestCleanupRule check the function cleanupRule in dry run mode
+func TestCleanupRule(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	cliFlags := main.CliFlags{
+		CleanupRule:       "rule.test|KEY",
+		DryRun:            true,
+		PrintSummaryTable: true,
+	}
+
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT COUNT").WithArgs("rule.test", "KEY").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	}
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.CleanupRule(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is not expected
+	assert.NoError(t, err, "error is not expected while calling main.cleanupRule")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupRuleWrongSelector check the function cleanupRule when rule is
+// not specified properly
+func TestCleanupRuleWrongSelector(t *testing.T) {
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	cliFlags := main.CliFlags{
+		CleanupRule: "|KEY",
+	}
+
+	// call the tested function
+	status, err := main.CleanupRule(&configuration, nil, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.cleanupRule")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusPerformCleanupError)
+}
+
+// TestCleanupRatings check the function cleanupRatings for selected
+// organization
+func TestCleanupRatings(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	cliFlags := main.CliFlags{
+		CleanupRatings:    true,
+		OrgID:             42,
+		Rule:              "rule.test",
+		DryRun:            false,
+		PrintSummaryTable: true,
+	}
+
+	mock.ExpectQuery("SELECT COUNT").WithArgs(42, "rule.test").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec("DELETE FROM advisor_ratings").WithArgs(42, "rule.test").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.CleanupRatings(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is not expected
+	assert.NoError(t, err, "error is not expected while calling main.cleanupRatings")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupRatingsWrongCriteria check the function cleanupRatings when
+// criteria are not specified properly
+func TestCleanupRatingsWrongCriteria(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, _, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	for _, cliFlags := range []main.CliFlags{
+		{CleanupRatings: true},
+		{CleanupRatings: true, Rule: "|KEY"},
+	} {
+		// call the tested function
+		status, err := main.CleanupRatings(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+		// error is expected
+		assert.Error(t, err, "error is expected while calling main.cleanupRatings")
+
+		// check the status
+		assert.Equal(t, status, main.ExitStatusPerformCleanupError)
+	}
+}
+
+// TestCompactPayloads check the function compactPayloads
+func TestCompactPayloads(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner.MaxAge = "3 days"
+
+	cliFlags := main.CliFlags{
+		CompactPayloads: true,
+		DryRun:          true,
+	}
+
+	mock.ExpectQuery("SELECT COUNT").WithArgs("3 days").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.CompactPayloads(&configuration, connection, cliFlags, main.DBSchemaDVORecommendations)
+
+	// error is not expected
+	assert.NoError(t, err, "error is not expected while calling main.compactPayloads")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
 
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCompactPayloadsMissingMaxAge check the function compactPayloads when
+// max age is not specified
+func TestCompactPayloadsMissingMaxAge(t *testing.T) {
 	// stub for structures needed to call the tested function
 	configuration := main.ConfigStruct{}
 
-	configuration.Cleaner = main.CleanerConfiguration{
-		MaxAge:          "3 days",
-		ClusterListFile: "cluster_list.txt",
-	}
-
 	cliFlags := main.CliFlags{
-		ShowVersion:       false,
-		ShowAuthors:       false,
-		ShowConfiguration: false,
-		PrintSummaryTable: true,
+		CompactPayloads: true,
 	}
 
-	var status int
-
 	// call the tested function
-	output, err := capture.StandardOutput(func() {
-		status, _ = main.Cleanup(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
-	})
+	status, err := main.CompactPayloads(&configuration, nil, cliFlags, main.DBSchemaOCPRecommendations)
 
-	// check the captured text
-	checkCapture(t, err)
-
-	// check if captured text contains expected summary table
-	for _, expectedLine := range expectedOutputLines {
-		assert.Contains(t, output, expectedLine)
-	}
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.compactPayloads")
 
 	// check the status
-	assert.Equal(t, status, main.ExitStatusOK)
+	assert.Equal(t, status, main.ExitStatusPerformCleanupError)
 }
 
-// TestCleanupAll check the function cleanupAll when
-// summary table should not be printed
-func TestCleanupAll(t *testing.T) {
+// TestSizeSnapshot check the function sizeSnapshot
+func TestSizeSnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sizes.csv")
+
 	// prepare new mocked connection to database
 	connection, mock, err := sqlmock.New()
 	assert.NoError(t, err, "error creating SQL mock")
 
-	// stub for structures needed to call the tested function
-	configuration := main.ConfigStruct{}
-
-	configuration.Cleaner = main.CleanerConfiguration{
-		MaxAge: "3 days",
-	}
-
 	cliFlags := main.CliFlags{
-		ShowVersion:       false,
-		ShowAuthors:       false,
-		ShowConfiguration: false,
-		PrintSummaryTable: false,
+		SizeSnapshot: filename,
 	}
 
-	for range cleaner.AllTablesToDelete {
-		mock.ExpectExec("DELETE*").WithArgs(configuration.Cleaner.MaxAge).
-			WillReturnResult(sqlmock.NewResult(1, 2))
-	}
+	rows := sqlmock.NewRows([]string{"table", "size", "rows"})
+	rows.AddRow("public.report", 8192, 10)
+	mock.ExpectQuery("SELECT schemaname").WithArgs("public").WillReturnRows(rows)
 	mock.ExpectClose()
 
 	// call the tested function
-	status, err := main.CleanupAll(&configuration, connection, cliFlags)
+	status, err := main.SizeSnapshot(connection, cliFlags, main.DBSchemaOCPRecommendations)
 
 	// error is not expected
-	assert.NoError(t, err, "error is not expected while calling main.cleanupAll")
+	assert.NoError(t, err, "error is not expected while calling main.sizeSnapshot")
 
 	// check the status
 	assert.Equal(t, status, main.ExitStatusOK)
+	assert.FileExists(t, filename)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestSizeSnapshotNoConnection check the function sizeSnapshot when the
+// connection to DB is not established
+func TestSizeSnapshotNoConnection(t *testing.T) {
+	cliFlags := main.CliFlags{
+		SizeSnapshot: filepath.Join(t.TempDir(), "sizes.csv"),
+	}
+
+	// call the tested function
+	status, err := main.SizeSnapshot(nil, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.sizeSnapshot")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusStorageError)
 }
 
 // TestCleanupAllMissingMaxAge check the function cleanup fails if no MaxAge
@@ -1094,10 +2127,11 @@ func TestCleanupAllMissingMaxAge(t *testing.T) {
 		ShowAuthors:       false,
 		ShowConfiguration: false,
 		PrintSummaryTable: false,
+		DryRun:            true,
 	}
 
 	// call the tested function
-	status, err := main.CleanupAll(&configuration, connection, cliFlags)
+	status, err := main.CleanupAll(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
 
 	// error is not expected
 	assert.EqualError(t, err, main.MaxAgeMissing)
@@ -1112,8 +2146,11 @@ func TestDetectMultipleRuleDisable(t *testing.T) {
 	// stub for CLI flags needed to call the tested function
 	cliFlags := main.CliFlags{}
 
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
 	// call the tested function with null connection
-	status, err := main.DetectMultipleRuleDisable(nil, cliFlags)
+	status, err := main.DetectMultipleRuleDisable(&configuration, nil, cliFlags)
 
 	// error is expected
 	assert.Error(t, err, "error is expected while calling main.cleanup")
@@ -1136,11 +2173,13 @@ func TestFillInDatabase(t *testing.T) {
 	}
 
 	for _, clusterName := range clusterNames {
+		mock.ExpectBegin()
 		mock.ExpectExec("INSERT INTO report").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_rule_toggle").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_rule_user_feedback").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_user_rule_disable_feedback").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO rule_hit").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 	}
 
 	mock.ExpectClose()
@@ -1173,11 +2212,13 @@ func TestFillInDatabaseOnError(t *testing.T) {
 	}
 
 	for _, clusterName := range clusterNames {
+		mock.ExpectBegin()
 		mock.ExpectExec("INSERT INTO report").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_rule_toggle").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_rule_user_feedback").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO cluster_user_rule_disable_feedback").WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec("INSERT INTO rule_hit").WithArgs(clusterName).WillReturnError(mockedError)
+		mock.ExpectRollback()
 	}
 
 	mock.ExpectClose()
@@ -1185,7 +2226,7 @@ func TestFillInDatabaseOnError(t *testing.T) {
 	exitCode, err := main.FillInDatabase(connection, main.DBSchemaOCPRecommendations)
 	assert.Error(t, err, "error is expected while calling tested function")
 	assert.Equal(t, exitCode, main.ExitStatusFillInStorageError)
-	assert.Equal(t, err, mockedError)
+	assert.ErrorIs(t, err, mockedError)
 
 	// check if DB can be closed successfully
 	checkConnectionClose(t, connection)
@@ -1230,8 +2271,7 @@ func TestDisplayOldRecordsNoConnection(t *testing.T) {
 // displayOldRecords function when connection is established.
 func TestDisplayOldRecordsProperConnection(t *testing.T) {
 	// prepare new mocked connection to database
-	connection, mock, err := sqlmock.New()
-	assert.NoError(t, err, "error creating SQL mock")
+	connection, mock := newConcurrentListingMock(t)
 
 	// fill in configuration structure
 	configuration := main.ConfigStruct{}
@@ -1253,11 +2293,13 @@ func TestDisplayOldRecordsProperConnection(t *testing.T) {
 	mock.ExpectQuery(expectedQuery1).WillReturnRows(rows)
 
 	expectedQuery2 := "SELECT org_id, rule_fqdn, error_key, rule_id, rating, last_updated_at FROM advisor_ratings WHERE last_updated_at < NOW\\(\\) - \\$1::INTERVAL ORDER BY last_updated_at"
-	mock.ExpectQuery(expectedQuery2).WillReturnRows(rows)
+	mock.ExpectQuery(expectedQuery2).WillReturnRows(sqlmock.NewRows([]string{}))
 
 	expectedQuery3 := "SELECT topic, partition, topic_offset, key, consumed_at, message FROM consumer_error WHERE consumed_at < NOW\\(\\) - \\$1::INTERVAL ORDER BY consumed_at"
-	mock.ExpectQuery(expectedQuery3).WillReturnRows(rows)
+	mock.ExpectQuery(expectedQuery3).WillReturnRows(sqlmock.NewRows([]string{}))
 
+	expectOldRecommendationsAndReportInfo(mock)
+	expectFutureDatedCounts(mock, "report", "advisor_ratings", "consumer_error", "recommendation", "report_info")
 	mock.ExpectClose()
 
 	// call the tested function
@@ -1268,14 +2310,219 @@ func TestDisplayOldRecordsProperConnection(t *testing.T) {
 	assert.Equal(t, main.ExitStatusOK, exitCode)
 }
 
+// TestDisplayOldRecordsCountsNoConnection checks the basic behaviour of
+// displayOldRecordsCounts function when connection is not established.
+func TestDisplayOldRecordsCountsNoConnection(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	exitCode, err := main.DisplayOldRecordsCounts(&configuration, nil, main.CliFlags{}, main.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, main.ErrNoConnection)
+	assert.Equal(t, main.ExitStatusStorageError, exitCode)
+}
+
+// TestCheckListingThresholds checks exit statuses selected by
+// -fail-if-more-than and -fail-if-none options
+func TestCheckListingThresholds(t *testing.T) {
+	testCases := []struct {
+		name           string
+		total          int
+		cliFlags       main.CliFlags
+		expectedStatus main.ExitStatus
+		expectedError  bool
+	}{
+		{"no thresholds", 0, main.CliFlags{}, main.ExitStatusOK, false},
+		{"below threshold", 10, main.CliFlags{FailIfMoreThan: "10"}, main.ExitStatusOK, false},
+		{"above threshold", 11, main.CliFlags{FailIfMoreThan: "10"}, main.ExitStatusTooManyRecords, true},
+		{"zero threshold", 1, main.CliFlags{FailIfMoreThan: "0"}, main.ExitStatusTooManyRecords, true},
+		{"some records", 1, main.CliFlags{FailIfNone: true}, main.ExitStatusOK, false},
+		{"no records", 0, main.CliFlags{FailIfNone: true}, main.ExitStatusNoRecords, true},
+		{"incorrect threshold", 0, main.CliFlags{FailIfMoreThan: "ten"}, main.ExitStatusConfigError, true},
+		{"negative threshold", 0, main.CliFlags{FailIfMoreThan: "-1"}, main.ExitStatusConfigError, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, err := main.CheckListingThresholds(tc.total, tc.cliFlags)
+			assert.Equal(t, tc.expectedStatus, status)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestDisplayOldRecordsCountsAboveThreshold checks that dedicated exit
+// status is returned when more old records are found than allowed
+func TestDisplayOldRecordsCountsAboveThreshold(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: maxAge,
+	}
+	configuration.Storage = main.StorageConfiguration{
+		Schema: main.DBSchemaDVORecommendations,
+	}
+
+	// command line flags
+	cliFlags := main.CliFlags{
+		CountOnly:      true,
+		FailIfMoreThan: "5",
+	}
+
+	// expected queries performed by tested function
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM dvo_report WHERE").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	expectFutureDatedCounts(mock, "dvo_report")
+	mock.ExpectClose()
+
+	// call the tested function and capture its output
+	_, err = capture.StandardOutput(func() {
+		exitCode, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+		assert.Error(t, err, "error is expected while calling tested function")
+		assert.Equal(t, main.ExitStatusTooManyRecords, exitCode)
+	})
+	checkCapture(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDisplayOldRecordsCountsIncorrectThreshold checks that incorrect
+// threshold is reported as configuration error before any record is read
+func TestDisplayOldRecordsCountsIncorrectThreshold(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: maxAge,
+	}
+
+	// command line flags
+	cliFlags := main.CliFlags{
+		CountOnly:      true,
+		FailIfMoreThan: "ten",
+	}
+
+	// no query is expected
+	mock.ExpectClose()
+
+	exitCode, err := main.DisplayOldRecordsCounts(&configuration, connection, cliFlags,
+		main.DBSchemaDVORecommendations)
+	assert.Error(t, err, "error is expected while calling tested function")
+	assert.Equal(t, main.ExitStatusConfigError, exitCode)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPrintOldRecordsCountsFutureDated checks that future-dated records are
+// displayed, but they are not included in total number of old records
+func TestPrintOldRecordsCountsFutureDated(t *testing.T) {
+	counts := []main.OldRecordsCount{
+		{Category: "Old DVO reports", Table: "dvo_report", Count: 10},
+	}
+	futureCounts := []main.OldRecordsCount{
+		{Category: "Future-dated DVO reports", Table: "dvo_report", Count: 5},
+	}
+
+	output, err := capture.StandardOutput(func() {
+		main.PrintOldRecordsCounts(counts, futureCounts)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Future-dated DVO reports")
+	assert.Regexp(t, `TOTAL OLD RECORDS\s+\|\s+10\s`, output)
+}
+
+// TestDisplayOldRecordsCountsProperConnection checks that number of old
+// records is displayed for each table via doSelectedOperation function
+func TestDisplayOldRecordsCountsProperConnection(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// fill in configuration structure
+	configuration := main.ConfigStruct{}
+	configuration.Cleaner = main.CleanerConfiguration{
+		MaxAge: maxAge,
+	}
+	configuration.Storage = main.StorageConfiguration{
+		Schema: main.DBSchemaOCPRecommendations,
+	}
+
+	// command line flags
+	cliFlags := main.CliFlags{
+		CountOnly: true,
+	}
+
+	// expected queries performed by tested function
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM report WHERE").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM advisor_ratings WHERE").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(20))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM consumer_error WHERE").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(30))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM recommendation WHERE").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(40))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM report_info JOIN report ON .+ WHERE report.reported_at < NOW").
+		WithArgs(maxAge).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	expectFutureDatedCounts(mock, "report", "advisor_ratings", "consumer_error", "recommendation", "report_info")
+	mock.ExpectClose()
+
+	// call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		exitCode, err := main.DoSelectedOperation(&configuration, connection, cliFlags)
+		assert.NoError(t, err, "error is not expected while calling tested function")
+		assert.Equal(t, main.ExitStatusOK, exitCode)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Old OCP reports")
+	assert.Contains(t, output, "Old Advisor ratings")
+	assert.Contains(t, output, "Old consumer errors")
+	assert.Contains(t, output, "Old recommendations")
+	assert.Contains(t, output, "Old report info")
+	assert.Contains(t, output, "110")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
 // TestDetectMultipleRuleDisablesNoConnection check the function
 // detectMultipleRuleDisable when the connection to DB is not established
 func TestDetectMultipleRuleDisablesNoConnection(t *testing.T) {
 	// command line flags
 	cliFlags := main.CliFlags{}
 
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
 	// call the tested function
-	status, err := main.DetectMultipleRuleDisable(nil, cliFlags)
+	status, err := main.DetectMultipleRuleDisable(&configuration, nil, cliFlags)
 
 	// error is expected
 	assert.Error(t, err, "error is expected while calling main.vacuumDB")
@@ -1294,6 +2541,9 @@ func TestDetectMultipleRuleDisablesProperConnection(t *testing.T) {
 	// command line flags
 	cliFlags := main.CliFlags{}
 
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
 	// prepare mocked result for SQL query
 	rows := sqlmock.NewRows([]string{})
 
@@ -1305,7 +2555,7 @@ func TestDetectMultipleRuleDisablesProperConnection(t *testing.T) {
 	mock.ExpectClose()
 
 	// call the tested function
-	status, err := main.DetectMultipleRuleDisable(connection, cliFlags)
+	status, err := main.DetectMultipleRuleDisable(&configuration, connection, cliFlags)
 
 	// error is not expected
 	assert.NoError(t, err, "error is not expected while calling main.detectMultipleRuleDisable")
@@ -1314,6 +2564,87 @@ func TestDetectMultipleRuleDisablesProperConnection(t *testing.T) {
 	assert.Equal(t, status, main.ExitStatusOK)
 }
 
+// TestDVONamespaceStatisticsNoConnection check the function
+// dvoNamespaceStatistics when the connection to DB is not established
+func TestDVONamespaceStatisticsNoConnection(t *testing.T) {
+	// command line flags
+	cliFlags := main.CliFlags{}
+
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	// call the tested function
+	status, err := main.DVONamespaceStatistics(&configuration, nil, cliFlags, main.DBSchemaDVORecommendations)
+
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.dvoNamespaceStatistics")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusStorageError)
+}
+
+// TestDVONamespaceStatisticsWrongSchema check the function
+// dvoNamespaceStatistics when OCP database is used
+func TestDVONamespaceStatisticsWrongSchema(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// command line flags
+	cliFlags := main.CliFlags{}
+
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	// call the tested function, no query is expected
+	status, err := main.DVONamespaceStatistics(&configuration, connection, cliFlags, main.DBSchemaOCPRecommendations)
+
+	// error is expected
+	assert.Error(t, err, "error is expected while calling main.dvoNamespaceStatistics")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusStorageError)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDVONamespaceStatisticsProperConnection check the function
+// dvoNamespaceStatistics when the connection to DB is established
+func TestDVONamespaceStatisticsProperConnection(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// command line flags
+	cliFlags := main.CliFlags{}
+
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{})
+
+	// expected query performed by tested function
+	mock.ExpectQuery("SELECT namespace_id, namespace_name, COUNT\\(\\*\\)").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// call the tested function
+	status, err := main.DVONamespaceStatistics(&configuration, connection, cliFlags, main.DBSchemaDVORecommendations)
+
+	// error is not expected
+	assert.NoError(t, err, "error is not expected while calling main.dvoNamespaceStatistics")
+
+	// check the status
+	assert.Equal(t, status, main.ExitStatusOK)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
 // TestDetectMultipleRuleDisablesOnError1 check the function
 // detectMultipleRuleDisable when DB error is thrown
 func TestDetectMultipleRuleDisablesOnError1(t *testing.T) {
@@ -1327,6 +2658,9 @@ func TestDetectMultipleRuleDisablesOnError1(t *testing.T) {
 	// command line flags
 	cliFlags := main.CliFlags{}
 
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
 	// prepare mocked result for SQL query
 	rows := sqlmock.NewRows([]string{})
 
@@ -1338,11 +2672,11 @@ func TestDetectMultipleRuleDisablesOnError1(t *testing.T) {
 	mock.ExpectClose()
 
 	// call the tested function
-	status, err := main.DetectMultipleRuleDisable(connection, cliFlags)
+	status, err := main.DetectMultipleRuleDisable(&configuration, connection, cliFlags)
 
 	// error is expected
 	assert.Error(t, err, "error is expected while calling main.detectMultipleRuleDisable")
-	assert.Equal(t, err, mockedError)
+	assert.ErrorIs(t, err, mockedError)
 
 	// check the status
 	assert.Equal(t, status, main.ExitStatusStorageError)
@@ -1361,18 +2695,50 @@ func TestDetectMultipleRuleDisablesOnError2(t *testing.T) {
 	// command line flags
 	cliFlags := main.CliFlags{}
 
+	// stub for configuration needed to call the tested function
+	configuration := main.ConfigStruct{}
+
 	// expected queries performed by tested function
 	expectedQuery := "select cluster_id, rule_id, count\\(\\*\\) as cnt from cluster_rule_toggle group by cluster_id, rule_id having count\\(\\*\\)>1 order by cnt desc;"
 	mock.ExpectQuery(expectedQuery).WillReturnError(mockedError)
 	mock.ExpectClose()
 
 	// call the tested function
-	status, err := main.DetectMultipleRuleDisable(connection, cliFlags)
+	status, err := main.DetectMultipleRuleDisable(&configuration, connection, cliFlags)
 
 	// error is expected
 	assert.Error(t, err, "error is expected while calling main.detectMultipleRuleDisable")
-	assert.Equal(t, err, mockedError)
+	assert.ErrorIs(t, err, mockedError)
 
 	// check the status
 	assert.Equal(t, status, main.ExitStatusStorageError)
 }
+
+// TestLoadConfigurationGate checks that invalid configuration is refused
+// before any operation is started
+func TestLoadConfigurationGate(t *testing.T) {
+	os.Clearenv()
+	envVar := "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"
+
+	// valid configuration
+	assert.NoError(t, os.Setenv(envVar, "tests/config2"))
+	config, err := main.LoadAndCheckConfiguration(envVar, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite3", config.Storage.Driver)
+
+	// configuration that can not be parsed
+	assert.NoError(t, os.Setenv(envVar, "tests/config3"))
+	_, err = main.LoadAndCheckConfiguration(envVar, "")
+	assert.Error(t, err)
+
+	// configuration with unknown DB schema
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	err = os.WriteFile(configFile, []byte("[storage]\ndb_driver = \"sqlite3\"\nschema = \"unknown\"\n"), 0o600)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Setenv(envVar, configFile))
+	_, err = main.LoadAndCheckConfiguration(envVar, "")
+	var configErr *main.ErrInvalidConfiguration
+	assert.ErrorAs(t, err, &configErr)
+	assert.Equal(t, "storage.schema", configErr.Key)
+	assert.ErrorContains(t, err, "configuration option 'storage.schema': Incorrect database schema found in configuration: unknown")
+}
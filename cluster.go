@@ -0,0 +1,361 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster.html
+
+// This source file contains implementation of end-to-end cleanup of one
+// cluster selected by -cluster command line option. It covers the most
+// common support workflow without crafting cluster list files:
+//
+// 1. records of the cluster are counted in all tables (inspection)
+// 2. number of records in each table is displayed (listing)
+// 3. all records of the cluster are written into JSON document (archive),
+//    the document is written into file specified by -output or into
+//    cluster-<cluster ID>.json file by default
+// 4. records are deleted when dry run mode is disabled and deletion is
+//    confirmed interactively by typing the cluster ID (or by -force)
+//
+// Nothing is deleted when the archive can not be written.
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// clusterArchiveFilePattern is used to construct name of archive file when
+// it is not specified by -output
+const clusterArchiveFilePattern = "cluster-%s.json"
+
+// confirmationInput is used to read interactive confirmation of deletion
+var confirmationInput io.Reader = os.Stdin
+
+// confirmationPrompt is used to ask for interactive confirmation of deletion
+var confirmationPrompt io.Writer = os.Stderr
+
+// ErrClusterNotConfirmed is returned when deletion of cluster records is not
+// confirmed interactively
+type ErrClusterNotConfirmed struct {
+	Cluster ClusterName
+}
+
+// Error method returns error message
+func (e *ErrClusterNotConfirmed) Error() string {
+	return fmt.Sprintf("deletion of records of cluster %s has not been confirmed", e.Cluster)
+}
+
+// ClusterRecordsCount represents number of records of one cluster stored in
+// one table
+type ClusterRecordsCount struct {
+	Table string
+	Key   string
+	Count int
+}
+
+// ClusterArchive represents all records of one cluster archived before they
+// are deleted. Records are stored as maps from column names to values.
+type ClusterArchive struct {
+	Cluster    ClusterName                         `json:"cluster"`
+	OrgID      int                                 `json:"org_id,omitempty"`
+	Source     string                              `json:"source"`
+	Schema     string                              `json:"schema"`
+	ArchivedAt time.Time                           `json:"archived_at"`
+	Tables     map[string][]map[string]interface{} `json:"tables"`
+}
+
+// clusterRecordsCondition function constructs condition that selects
+// records of given cluster from given table. Records are selected by
+// organization too when it is specified and the table contains it.
+func clusterRecordsCondition(tableAndKey TableAndKey, cluster ClusterName, orgID int) (
+	string, []interface{}) {
+	condition := " WHERE " + clusterKeyCondition(tableAndKey.KeyName)
+	args := []interface{}{cluster}
+	if orgID != 0 && tableAndKey.OrgKeyName != "" {
+		condition += " AND " + tableAndKey.OrgKeyName + " = $2"
+		args = append(args, orgID)
+	}
+	return condition, args
+}
+
+// inspectCluster function counts records of given cluster in all tables
+func inspectCluster(connection *sql.DB, tablesAndKeys []TableAndKey, cluster ClusterName, orgID int) (
+	[]ClusterRecordsCount, error) {
+	counts := make([]ClusterRecordsCount, 0, len(tablesAndKeys))
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return counts, ErrNoConnection
+	}
+
+	for _, tableAndKey := range tablesAndKeys {
+		condition, args := clusterRecordsCondition(tableAndKey, cluster, orgID)
+		count, err := countRecordsInTable(connection, tableAndKey.TableName, condition, args)
+		if err != nil {
+			return counts, err
+		}
+		counts = append(counts, ClusterRecordsCount{
+			Table: tableAndKey.TableName,
+			Key:   tableAndKey.KeyName,
+			Count: count,
+		})
+	}
+	return counts, nil
+}
+
+// totalClusterRecords function returns number of records of cluster in all
+// tables
+func totalClusterRecords(counts []ClusterRecordsCount) int {
+	total := 0
+	for _, count := range counts {
+		total += count.Count
+	}
+	return total
+}
+
+// archiveValue function converts value read from database into value that
+// can be stored in JSON document
+func archiveValue(value interface{}) interface{} {
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return value
+}
+
+// readClusterRecords function reads all records of given cluster from one
+// table
+func readClusterRecords(connection *sql.DB, tableAndKey TableAndKey, cluster ClusterName, orgID int) (
+	[]map[string]interface{}, error) {
+	records := []map[string]interface{}{}
+
+	condition, args := clusterRecordsCondition(tableAndKey, cluster, orgID)
+	// it is not possible to use parameter for table name
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	query := "SELECT * FROM " + tableAndKey.TableName + condition
+	err := queryRows(connection, query, args, func(rows *sql.Rows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			record[column] = archiveValue(values[i])
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return records, queryFailed(tableAndKey.TableName, err)
+	}
+	return records, nil
+}
+
+// archiveCluster function reads all records of given cluster from all
+// tables
+func archiveCluster(connection *sql.DB, tablesAndKeys []TableAndKey, cluster ClusterName, orgID int) (
+	map[string][]map[string]interface{}, error) {
+	tables := make(map[string][]map[string]interface{}, len(tablesAndKeys))
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return tables, ErrNoConnection
+	}
+
+	for _, tableAndKey := range tablesAndKeys {
+		records, err := readClusterRecords(connection, tableAndKey, cluster, orgID)
+		if err != nil {
+			return tables, err
+		}
+		tables[tableAndKey.TableName] = records
+	}
+	return tables, nil
+}
+
+// writeClusterArchive function writes archived records of cluster as JSON
+// document
+func writeClusterArchive(writer io.Writer, archive ClusterArchive) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// confirmClusterDeletion function asks for interactive confirmation of
+// deletion of cluster records. The deletion is confirmed by typing the
+// cluster ID.
+func confirmClusterDeletion(input io.Reader, prompt io.Writer, cluster ClusterName, records int) error {
+	fmt.Fprintf(prompt, "%d records of cluster %s will be deleted, type the cluster ID to confirm: ",
+		records, cluster)
+
+	answer, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if ClusterName(strings.TrimSpace(answer)) != cluster {
+		return &ErrClusterNotConfirmed{Cluster: cluster}
+	}
+	return nil
+}
+
+// PrintClusterRecordsCounts function displays a table with number of records
+// of cluster stored in each table
+func PrintClusterRecordsCounts(counts []ClusterRecordsCount) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", "Key", "Records"})
+
+	for _, count := range counts {
+		table.Append([]string{count.Table, count.Key, strconv.Itoa(count.Count)})
+	}
+	table.SetFooter([]string{"", "Total", strconv.Itoa(totalClusterRecords(counts))})
+
+	// display the whole table
+	table.Render()
+}
+
+// cleanupSingleCluster function inspects, lists, archives, and deletes
+// records of one cluster
+func cleanupSingleCluster(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (
+	ExitStatus, error) {
+	cluster, err := normalizeClusterID(ClusterName(strings.TrimSpace(cliFlags.Cluster)),
+		configuration.Cleaner.RequireUUIDv4)
+	if err != nil {
+		log.Err(err).Str(inputWithClusterID, cliFlags.Cluster).Msg(notProperClusterID)
+		return ExitStatusPerformCleanupError, fmt.Errorf("%s: %w", notProperClusterID, err)
+	}
+
+	_, protected := excludeProtectedClusters(ClusterList{cluster}, configuration.Cleaner.ProtectedClusters)
+	if len(protected) > 0 {
+		err := fmt.Errorf("cluster %s is protected by configuration", cluster)
+		log.Err(err).Msg("Cleanup of cluster")
+		return ExitStatusPerformCleanupError, err
+	}
+
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	// inspection and listing
+	counts, err := inspectCluster(connection, tablesAndKeys, cluster, cliFlags.OrgID)
+	if err != nil {
+		log.Err(err).Msg("Inspect cluster")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+	PrintClusterRecordsCounts(counts)
+
+	total := totalClusterRecords(counts)
+	if total == 0 {
+		log.Info().Str(clusterNameMsg, string(cluster)).Msg("No records found for cluster")
+		return ExitStatusOK, nil
+	}
+
+	// archive
+	records, err := archiveCluster(connection, tablesAndKeys, cluster, cliFlags.OrgID)
+	if err != nil {
+		log.Err(err).Msg("Archive cluster")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+	archive := ClusterArchive{
+		Cluster:    cluster,
+		OrgID:      cliFlags.OrgID,
+		Source:     configuration.Storage.Name,
+		Schema:     schema,
+		ArchivedAt: time.Now().UTC(),
+		Tables:     records,
+	}
+	filename := cliFlags.Output
+	if filename == "" {
+		filename = fmt.Sprintf(clusterArchiveFilePattern, cluster)
+	}
+	out, err := createOutputFile(filename, cliFlags.Checksum)
+	if err == nil {
+		err = writeClusterArchive(out.Writer(), archive)
+		err = errors.Join(err, out.Close(err == nil))
+	}
+	if err != nil {
+		log.Err(err).Msg("Write cluster archive")
+		return ExitStatusStorageError, err
+	}
+	log.Info().
+		Str(clusterNameMsg, string(cluster)).
+		Str(filenameAttribute, filename).
+		Int("records", total).
+		Msg("Cluster archived")
+
+	if cliFlags.DryRun {
+		log.Info().Msg("Dry run, no records deleted")
+		return ExitStatusOK, nil
+	}
+
+	// deletion
+	if !cliFlags.Force {
+		err = confirmClusterDeletion(confirmationInput, confirmationPrompt, cluster, total)
+		if err != nil {
+			log.Err(err).Msg("Confirm cluster deletion")
+			return ExitStatusNotConfirmed, err
+		}
+	}
+
+	clusterOrgs := readClusterOrgs(configuration, connection, ClusterList{cluster}, cliFlags.OrgID, schema)
+	started := time.Now()
+	deletionsForTable, _, failedClusters, err := performClustersCleanupInDB(connection, ClusterList{cluster},
+		schema, cliFlags.OrgID, configuration.Cleaner.ClusterRetries, cliFlags.Transactional)
+	exitStatus := ExitStatusOK
+	var deleted ClusterList
+	if err != nil {
+		log.Err(err).Msg("Performing cleanup of cluster")
+		exitStatus = exitStatusForError(err, ExitStatusPerformCleanupError)
+	}
+	if len(failedClusters) == 0 {
+		deleted = ClusterList{cluster}
+	}
+	recordDeletedRows(deletionsForTable)
+	recordRunHistoryOrWarn(configuration, connection,
+		newRunHistoryEntry(configuration, cliFlags, "cluster", started, exitStatus, deletionsForTable,
+			countClustersForOrg(deleted, clusterOrgs)))
+	if err != nil {
+		return exitStatus, err
+	}
+
+	log.Info().
+		Str(clusterNameMsg, string(cluster)).
+		Int("deleted", deletedRowsCount(deletionsForTable)).
+		Msg("Cluster cleaned up")
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,324 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster_chunks.html
+
+// This source file contains implementation of chunked processing of cluster
+// lists. Lists used to offboard organizations can contain hundreds of
+// thousands of cluster IDs. When chunk_size option in [cluster_list] section
+// is set, cluster list read from file (or files matching glob pattern) or
+// from standard input is streamed line by line, cluster IDs are validated and
+// normalized lazily, and they are cleaned up in chunks of given size. Proper
+// entries are logged on debug level only, one event is logged for each chunk
+// instead.
+//
+// Results of all chunks are accumulated, so summary, reconciliation, run
+// history, and deletion matrix are the same as when the whole list is cleaned
+// up at once. Only normalized cluster IDs are held in memory for this purpose.
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// clusterListInput represents one named stream with cluster list
+type clusterListInput struct {
+	name   string
+	reader io.ReadCloser
+}
+
+// clusterListStreamer is implemented by cluster list providers that are able
+// to return cluster list as one or more streams with one cluster ID per line
+type clusterListStreamer interface {
+	// openClusterList method opens streams with cluster list
+	openClusterList() ([]clusterListInput, error)
+}
+
+// openClusterList method opens text file with cluster list or all text
+// files matching glob pattern
+func (provider fileClusterListProvider) openClusterList() ([]clusterListInput, error) {
+	filenames, err := clusterListFiles(provider.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]clusterListInput, 0, len(filenames))
+	for _, filename := range filenames {
+		// disable "G304 (CWE-22): Potential file inclusion via variable"
+		file, err := os.Open(filename) // #nosec G304
+		if err != nil {
+			return nil, errors.Join(err, closeClusterListInputs(inputs))
+		}
+		inputs = append(inputs, clusterListInput{name: filename, reader: file})
+	}
+	return inputs, nil
+}
+
+// openClusterList method returns standard input with cluster list, it is
+// never closed by the cleaner
+func (provider stdinClusterListProvider) openClusterList() ([]clusterListInput, error) {
+	return []clusterListInput{{name: "-", reader: io.NopCloser(provider.reader)}}, nil
+}
+
+// closeClusterListInputs function closes all given streams
+func closeClusterListInputs(inputs []clusterListInput) error {
+	var errs []error
+	for _, input := range inputs {
+		errs = append(errs, input.reader.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// readClusterListInChunks function reads cluster IDs from given streams line
+// by line and passes them to process function in chunks of given size.
+// Improper entries are counted (and logged for each stream) and skipped,
+// clusters that are listed more times are processed just once. Reading stops
+// on the first error returned by process function. Process function is
+// called at least once, even for empty list.
+func readClusterListInChunks(inputs []clusterListInput, chunkSize int, version4Only bool,
+	process func(chunk ClusterList) error) (ClusterListDiagnostics, error) {
+	var diagnostics ClusterListDiagnostics
+	started := time.Now()
+
+	seen := make(map[ClusterName]struct{})
+	chunk := make(ClusterList, 0, chunkSize)
+	chunks := 0
+
+	// pass the current chunk to process function
+	flush := func() error {
+		chunks++
+		log.Info().
+			Int("chunk", chunks).
+			Int(numberOfClustersToDelete, len(chunk)).
+			Int("clusters read", diagnostics.ProperEntries).
+			Msg("Cluster list chunk read")
+		err := process(chunk)
+		chunk = make(ClusterList, 0, chunkSize)
+		return err
+	}
+
+	for _, input := range inputs {
+		properEntries := 0
+		improperEntries := 0
+
+		scanner := bufio.NewScanner(input.reader)
+		for scanner.Scan() {
+			// whitespaces (including CR from files with Windows line
+			// endings) are not part of cluster ID
+			line := strings.TrimSpace(scanner.Text())
+			normalized, err := normalizeClusterID(ClusterName(line), version4Only)
+			if err != nil {
+				log.Error().Str(inputWithClusterID, line).Msg(notProperClusterID)
+				improperEntries++
+				diagnostics.ImproperEntries++
+				continue
+			}
+			properEntries++
+			if string(normalized) != line {
+				diagnostics.NormalizedEntries++
+			}
+			if _, found := seen[normalized]; found {
+				log.Debug().Str(clusterNameMsg, string(normalized)).Msg("Duplicate cluster ID")
+				continue
+			}
+			seen[normalized] = struct{}{}
+			log.Debug().Str(inputWithClusterID, line).Msg(properClusterID)
+
+			diagnostics.ProperEntries++
+			chunk = append(chunk, normalized)
+			if len(chunk) >= chunkSize {
+				if err := flush(); err != nil {
+					return diagnostics, err
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return diagnostics, err
+		}
+		logClusterListFile(input.name, properEntries, improperEntries)
+	}
+
+	// the last (possibly empty) chunk
+	if len(chunk) > 0 || chunks == 0 {
+		if err := flush(); err != nil {
+			return diagnostics, err
+		}
+	}
+
+	diagnostics.Duration = time.Since(started)
+	return diagnostics, nil
+}
+
+// processClusterList function reads list of clusters from provider selected
+// by command line options and configuration and passes it to process
+// function. The list is streamed in chunks when chunk size is configured and
+// the provider is able to stream the list, otherwise the whole list is
+// passed at once.
+func processClusterList(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags,
+	process func(chunk ClusterList) error) (ClusterListDiagnostics, error) {
+	chunkSize := GetClusterListConfiguration(configuration).ChunkSize
+
+	provider, err := newClusterListProvider(configuration, connection, cliFlags)
+	if err != nil {
+		return ClusterListDiagnostics{}, err
+	}
+
+	streamer, streamed := provider.(clusterListStreamer)
+	if chunkSize <= 0 || !streamed {
+		clusterList, diagnostics, err := readClusterListFromProvider(provider,
+			configuration.Cleaner.RequireUUIDv4)
+		if err != nil {
+			return diagnostics, err
+		}
+		return diagnostics, process(clusterList)
+	}
+
+	inputs, err := streamer.openClusterList()
+	if err != nil {
+		return ClusterListDiagnostics{Source: provider.Name()}, err
+	}
+	diagnostics, err := readClusterListInChunks(inputs, chunkSize,
+		configuration.Cleaner.RequireUUIDv4, process)
+	diagnostics.Source = provider.Name()
+	err = errors.Join(err, closeClusterListInputs(inputs))
+
+	log.Info().
+		Str("source", diagnostics.Source).
+		Int("chunk size", chunkSize).
+		Int(numberOfClustersToDelete, diagnostics.ProperEntries).
+		Int(improperClusterEntries, diagnostics.ImproperEntries).
+		Int(normalizedClusterEntries, diagnostics.NormalizedEntries).
+		Dur(durationAttribute, diagnostics.Duration).
+		Msg("Cluster list read")
+	return diagnostics, err
+}
+
+// clusterListCleanup accumulates results of cleanup of cluster list that is
+// processed in one or more chunks
+type clusterListCleanup struct {
+	clusterList         ClusterList
+	protectedClusters   ClusterList
+	clusterOrgs         map[ClusterName]int
+	deletionsForTable   map[string]int
+	deletionsForCluster DeletionMatrix
+	failedClusters      ClusterList
+	chunks              int
+	errs                []error
+	// canaryPending is set until the first cluster is cleaned up as canary
+	canaryPending bool
+}
+
+// newClusterListCleanup function prepares empty results of cleanup. The first
+// cluster is cleaned up as canary when canary is set.
+func newClusterListCleanup(canary bool) *clusterListCleanup {
+	return &clusterListCleanup{
+		clusterOrgs:         make(map[ClusterName]int),
+		deletionsForTable:   make(map[string]int),
+		deletionsForCluster: make(DeletionMatrix),
+		canaryPending:       canary,
+	}
+}
+
+// cleanupChunk method cleans up one chunk of clusters and accumulates its
+// results. Clusters that can not be cleaned up are accumulated as failed,
+// error is returned only when cleanup of the chunk could not be started at
+// all or when canary cleanup of the first cluster did not pass.
+func (cleanup *clusterListCleanup) cleanupChunk(configuration *ConfigStruct, connection *sql.DB,
+	cliFlags CliFlags, schema string, chunk ClusterList) error {
+	chunk, protectedClusters := excludeProtectedClusters(chunk, configuration.Cleaner.ProtectedClusters)
+
+	if cleanup.canaryPending && len(chunk) > 0 {
+		cleanup.canaryPending = false
+		canary := chunk[0]
+		deletionsForTable, failedClusters, err := cleanup.cleanupClusters(configuration, connection,
+			cliFlags, schema, ClusterList{canary}, protectedClusters)
+		if err != nil && len(failedClusters) == 0 {
+			log.Err(err).Msg("Performing cleanup")
+			// nothing has been cleaned up
+			return err
+		}
+		err = checkCanaryCleanup(canary, deletionsForTable, failedClusters, err)
+		logCanaryCleanup(canary, deletionsForTable, err)
+		if err != nil {
+			return err
+		}
+		chunk, protectedClusters = chunk[1:], nil
+		if len(chunk) == 0 {
+			return nil
+		}
+	}
+
+	_, failedClusters, err := cleanup.cleanupClusters(configuration, connection,
+		cliFlags, schema, chunk, protectedClusters)
+	if err != nil {
+		log.Err(err).Msg("Performing cleanup")
+		// nothing has been cleaned up
+		if len(failedClusters) == 0 {
+			return err
+		}
+		cleanup.errs = append(cleanup.errs, err)
+	}
+	return nil
+}
+
+// cleanupClusters method cleans up given clusters and accumulates results
+// when the cleanup has been started. Rows deleted from each table and
+// clusters that can not be cleaned up are returned together with error.
+func (cleanup *clusterListCleanup) cleanupClusters(configuration *ConfigStruct, connection *sql.DB,
+	cliFlags CliFlags, schema string, clusters, protectedClusters ClusterList) (
+	map[string]int, ClusterList, error) {
+	// organizations need to be known before their clusters are deleted
+	clusterOrgs := readClusterOrgs(configuration, connection, clusters, cliFlags.OrgID, schema)
+
+	deletionsForTable, deletionsForCluster, failedClusters, err := performClustersCleanupInDB(
+		connection, clusters, schema,
+		cliFlags.OrgID, configuration.Cleaner.ClusterRetries, cliFlags.Transactional)
+	// nothing has been cleaned up
+	if err != nil && len(failedClusters) == 0 {
+		return deletionsForTable, failedClusters, err
+	}
+
+	cleanup.chunks++
+	cleanup.clusterList = append(cleanup.clusterList, clusters...)
+	cleanup.protectedClusters = append(cleanup.protectedClusters, protectedClusters...)
+	cleanup.failedClusters = append(cleanup.failedClusters, failedClusters...)
+	for cluster, orgID := range clusterOrgs {
+		cleanup.clusterOrgs[cluster] = orgID
+	}
+	for table, deleted := range deletionsForTable {
+		cleanup.deletionsForTable[table] += deleted
+	}
+	for cluster, deletions := range deletionsForCluster {
+		cleanup.deletionsForCluster.add(cluster, deletions)
+	}
+	return deletionsForTable, failedClusters, err
+}
+
+// err method returns aggregated errors of clusters that have not been cleaned
+// up
+func (cleanup *clusterListCleanup) err() error {
+	return errors.Join(cleanup.errs...)
+}
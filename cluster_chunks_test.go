@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster_chunks_test.html
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// chunkedClusterList contains five proper cluster IDs (one of them in
+// uppercase), one duplicate, and one improper entry. The last line is not
+// terminated by new line.
+const chunkedClusterList = `5d5892d3-1f74-4ccf-91af-548dfc9767aa
+5D5892D3-1F74-4CCF-91AF-548DFC9767AB
+not-a-cluster
+5d5892d3-1f74-4ccf-91af-548dfc9767aa
+5d5892d3-1f74-4ccf-91af-548dfc9767ac
+5d5892d3-1f74-4ccf-91af-548dfc9767ad
+5d5892d3-1f74-4ccf-91af-548dfc9767ae`
+
+// processClusterListInChunks function writes given cluster lists into
+// files and processes them in chunks of two clusters
+func processClusterListInChunks(t *testing.T, clusterLists []string,
+	process func(chunk cleaner.ClusterList) error) (cleaner.ClusterListDiagnostics, error) {
+	directory := t.TempDir()
+	for i, clusterList := range clusterLists {
+		filename := filepath.Join(directory, fmt.Sprintf("request_%d.txt", i))
+		assert.NoError(t, os.WriteFile(filename, []byte(clusterList), 0o600))
+	}
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner:     cleaner.CleanerConfiguration{ClusterListFile: filepath.Join(directory, "*.txt")},
+		ClusterList: cleaner.ClusterListConfiguration{ChunkSize: 2},
+	}
+	return cleaner.ProcessClusterList(&configuration, nil, cleaner.CliFlags{}, process)
+}
+
+// TestReadClusterListInChunks checks that cluster IDs are normalized and
+// passed in chunks of given size
+func TestReadClusterListInChunks(t *testing.T) {
+	var chunks []cleaner.ClusterList
+
+	diagnostics, err := processClusterListInChunks(t, []string{chunkedClusterList},
+		func(chunk cleaner.ClusterList) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.ClusterList{
+		{"5d5892d3-1f74-4ccf-91af-548dfc9767aa", "5d5892d3-1f74-4ccf-91af-548dfc9767ab"},
+		{"5d5892d3-1f74-4ccf-91af-548dfc9767ac", "5d5892d3-1f74-4ccf-91af-548dfc9767ad"},
+		{"5d5892d3-1f74-4ccf-91af-548dfc9767ae"},
+	}, chunks)
+	assert.Equal(t, "file", diagnostics.Source)
+	assert.Equal(t, 5, diagnostics.ProperEntries)
+	assert.Equal(t, 1, diagnostics.ImproperEntries)
+	assert.Equal(t, 1, diagnostics.NormalizedEntries)
+}
+
+// TestReadClusterListInChunksMoreFiles checks that entries from all files
+// matching the pattern are merged and deduplicated
+func TestReadClusterListInChunksMoreFiles(t *testing.T) {
+	var clusters cleaner.ClusterList
+
+	// the first file is not terminated by new line
+	diagnostics, err := processClusterListInChunks(t, []string{
+		"5d5892d3-1f74-4ccf-91af-548dfc9767aa",
+		"5d5892d3-1f74-4ccf-91af-548dfc9767ab\n5d5892d3-1f74-4ccf-91af-548dfc9767aa\nimproper\n",
+	}, func(chunk cleaner.ClusterList) error {
+		clusters = append(clusters, chunk...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{
+		"5d5892d3-1f74-4ccf-91af-548dfc9767aa", "5d5892d3-1f74-4ccf-91af-548dfc9767ab",
+	}, clusters)
+	assert.Equal(t, 2, diagnostics.ProperEntries)
+	assert.Equal(t, 1, diagnostics.ImproperEntries)
+}
+
+// TestReadClusterListInChunksEmpty checks that process function is called
+// once even for empty cluster list
+func TestReadClusterListInChunksEmpty(t *testing.T) {
+	calls := 0
+
+	_, err := processClusterListInChunks(t, []string{""},
+		func(chunk cleaner.ClusterList) error {
+			assert.Empty(t, chunk)
+			calls++
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+// TestReadClusterListInChunksStop checks that reading stops on the first
+// error returned by process function
+func TestReadClusterListInChunksStop(t *testing.T) {
+	processErr := errors.New("process error")
+	calls := 0
+
+	_, err := processClusterListInChunks(t, []string{chunkedClusterList},
+		func(chunk cleaner.ClusterList) error {
+			calls++
+			return processErr
+		})
+	assert.ErrorIs(t, err, processErr)
+	assert.Equal(t, 1, calls)
+}
+
+// chunkedCleanupConfiguration function prepares configuration with cluster
+// list file cleaned up in chunks of two clusters
+func chunkedCleanupConfiguration(t *testing.T) cleaner.ConfigStruct {
+	filename := filepath.Join(t.TempDir(), "cluster_list.txt")
+	assert.NoError(t, os.WriteFile(filename, []byte(chunkedClusterList), 0o600))
+
+	return cleaner.ConfigStruct{
+		Cleaner:     cleaner.CleanerConfiguration{ClusterListFile: filename},
+		ClusterList: cleaner.ClusterListConfiguration{ChunkSize: 2},
+	}
+}
+
+// TestCleanupInChunks checks that results of all chunks are accumulated
+func TestCleanupInChunks(t *testing.T) {
+	configuration := chunkedCleanupConfiguration(t)
+	matrix := filepath.Join(t.TempDir(), "matrix.csv")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	for _, suffix := range []string{"aa", "ab", "ac", "ad", "ae"} {
+		for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+			expectedExec := fmt.Sprintf("DELETE FROM %v WHERE %v = \\$", tableAndKey.TableName, tableAndKey.KeyName)
+			mock.ExpectExec(expectedExec).
+				WithArgs("5d5892d3-1f74-4ccf-91af-548dfc9767" + suffix).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+	}
+	mock.ExpectClose()
+
+	status, err := cleaner.Cleanup(&configuration, connection, cleaner.CliFlags{DeletionMatrix: matrix},
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	content, err := os.ReadFile(matrix)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 6)
+	assert.Contains(t, string(content), "5d5892d3-1f74-4ccf-91af-548dfc9767ae,1,1\n")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupInChunksNoConnection checks that cleanup stops after the first
+// chunk when nothing can be cleaned up
+func TestCleanupInChunksNoConnection(t *testing.T) {
+	configuration := chunkedCleanupConfiguration(t)
+
+	status, err := cleaner.Cleanup(&configuration, nil, cleaner.CliFlags{},
+		cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+}
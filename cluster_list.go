@@ -0,0 +1,412 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster_list.html
+
+// This source file contains cluster list providers. List of clusters to
+// cleanup can be read from several sources, each source is represented by
+// one implementation of ClusterListProvider interface:
+//
+// file provider reads cluster list from text file (the default one)
+// CLI provider reads cluster list from -clusters command line option
+// stdin provider reads cluster list from standard input
+// SQL provider reads cluster list by SQL query
+// inventory provider reads cluster list from inventory API
+// aggregator provider reads cluster list from aggregator admin endpoint
+// Kafka provider reads cluster list from Kafka topic
+//
+// The provider is selected by provider option in [cluster_list] section of
+// configuration file. Command line options -clusters,
+// -clusters-from-inventory, and -clusters-from-aggregator take precedence
+// over the configuration.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of cluster list providers that can be selected in configuration
+const (
+	ClusterListProviderFile       = "file"
+	ClusterListProviderCLI        = "cli"
+	ClusterListProviderStdin      = "stdin"
+	ClusterListProviderSQL        = "sql"
+	ClusterListProviderInventory  = "inventory"
+	ClusterListProviderAggregator = "aggregator"
+	ClusterListProviderKafka      = "kafka"
+)
+
+// ClusterListDiagnostics contains information about reading cluster list
+// from selected source
+type ClusterListDiagnostics struct {
+	Source          string
+	ProperEntries   int
+	ImproperEntries int
+	// NormalizedEntries is number of cluster IDs that were not in
+	// lowercase canonical form
+	NormalizedEntries int
+	Duration          time.Duration
+}
+
+// ClusterListProvider is an interface implemented by all sources of cluster
+// list
+type ClusterListProvider interface {
+	// Name method returns name of the provider
+	Name() string
+	// ReadClusterList method reads list of clusters together with number
+	// of improper entries
+	ReadClusterList() (ClusterList, int, error)
+}
+
+// fileClusterListProvider reads cluster list from text file
+type fileClusterListProvider struct {
+	filename string
+}
+
+// Name method returns name of the provider
+func (provider fileClusterListProvider) Name() string {
+	return ClusterListProviderFile
+}
+
+// ReadClusterList method reads list of clusters from text file or from all
+// text files matching glob pattern
+func (provider fileClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromFiles(provider.filename)
+}
+
+// cliClusterListProvider reads cluster list from command line argument
+type cliClusterListProvider struct {
+	clusters string
+}
+
+// Name method returns name of the provider
+func (provider cliClusterListProvider) Name() string {
+	return ClusterListProviderCLI
+}
+
+// ReadClusterList method reads list of clusters from command line argument
+func (provider cliClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromCLIArgument(provider.clusters)
+}
+
+// stdinClusterListProvider reads cluster list from standard input (or any
+// other reader)
+type stdinClusterListProvider struct {
+	reader io.Reader
+}
+
+// Name method returns name of the provider
+func (provider stdinClusterListProvider) Name() string {
+	return ClusterListProviderStdin
+}
+
+// ReadClusterList method reads list of clusters from standard input
+func (provider stdinClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromReader(provider.reader)
+}
+
+// sqlClusterListProvider reads cluster list by SQL query
+type sqlClusterListProvider struct {
+	connection *sql.DB
+	query      string
+}
+
+// Name method returns name of the provider
+func (provider sqlClusterListProvider) Name() string {
+	return ClusterListProviderSQL
+}
+
+// ReadClusterList method reads list of clusters by SQL query
+func (provider sqlClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromQuery(provider.connection, provider.query)
+}
+
+// inventoryClusterListProvider reads cluster list from inventory API
+type inventoryClusterListProvider struct {
+	configuration InventoryConfiguration
+}
+
+// Name method returns name of the provider
+func (provider inventoryClusterListProvider) Name() string {
+	return ClusterListProviderInventory
+}
+
+// ReadClusterList method reads list of clusters from inventory API
+func (provider inventoryClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromInventory(provider.configuration, time.Now())
+}
+
+// aggregatorClusterListProvider reads cluster list from aggregator admin
+// endpoint
+type aggregatorClusterListProvider struct {
+	configuration AggregatorConfiguration
+}
+
+// Name method returns name of the provider
+func (provider aggregatorClusterListProvider) Name() string {
+	return ClusterListProviderAggregator
+}
+
+// ReadClusterList method reads list of clusters from aggregator admin
+// endpoint
+func (provider aggregatorClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromAggregator(provider.configuration)
+}
+
+// kafkaClusterListProvider reads cluster list from Kafka topic
+type kafkaClusterListProvider struct {
+	configuration KafkaConfiguration
+}
+
+// Name method returns name of the provider
+func (provider kafkaClusterListProvider) Name() string {
+	return ClusterListProviderKafka
+}
+
+// ReadClusterList method reads list of clusters from Kafka topic
+func (provider kafkaClusterListProvider) ReadClusterList() (ClusterList, int, error) {
+	return readClusterListFromKafka(provider.configuration)
+}
+
+// newClusterListProvider function selects cluster list provider according
+// to command line options and configuration
+func newClusterListProvider(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (
+	ClusterListProvider, error) {
+	// command line options take precedence
+	switch {
+	case cliFlags.Clusters != "":
+		return cliClusterListProvider{clusters: cliFlags.Clusters}, nil
+	case cliFlags.ClustersFromInventory:
+		return inventoryClusterListProvider{configuration: GetInventoryConfiguration(configuration)}, nil
+	case cliFlags.ClustersFromAggregator:
+		return aggregatorClusterListProvider{configuration: GetAggregatorConfiguration(configuration)}, nil
+	}
+
+	clusterListConfig := GetClusterListConfiguration(configuration)
+	switch clusterListConfig.Provider {
+	case "", ClusterListProviderFile:
+		return fileClusterListProvider{filename: configuration.Cleaner.ClusterListFile}, nil
+	case ClusterListProviderStdin:
+		return stdinClusterListProvider{reader: os.Stdin}, nil
+	case ClusterListProviderSQL:
+		return sqlClusterListProvider{connection: connection, query: clusterListConfig.Query}, nil
+	case ClusterListProviderInventory:
+		return inventoryClusterListProvider{configuration: GetInventoryConfiguration(configuration)}, nil
+	case ClusterListProviderAggregator:
+		return aggregatorClusterListProvider{configuration: GetAggregatorConfiguration(configuration)}, nil
+	case ClusterListProviderKafka:
+		return kafkaClusterListProvider{configuration: clusterListConfig.Kafka}, nil
+	default:
+		return nil, fmt.Errorf("unknown cluster list provider '%s'", clusterListConfig.Provider)
+	}
+}
+
+// readClusterList function reads list of clusters from provider selected by
+// command line options and configuration
+func readClusterList(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (
+	ClusterList, ClusterListDiagnostics, error) {
+	provider, err := newClusterListProvider(configuration, connection, cliFlags)
+	if err != nil {
+		return nil, ClusterListDiagnostics{}, err
+	}
+
+	return readClusterListFromProvider(provider, configuration.Cleaner.RequireUUIDv4)
+}
+
+// readClusterListFromProvider function reads the whole list of clusters from
+// given provider and normalizes it
+func readClusterListFromProvider(provider ClusterListProvider, version4Only bool) (
+	ClusterList, ClusterListDiagnostics, error) {
+	started := time.Now()
+	clusterList, improperClusterCounter, err := provider.ReadClusterList()
+	clusterList, normalized, rejected := normalizeClusterList(clusterList, version4Only)
+	diagnostics := ClusterListDiagnostics{
+		Source:            provider.Name(),
+		ProperEntries:     len(clusterList),
+		ImproperEntries:   improperClusterCounter + rejected,
+		NormalizedEntries: normalized,
+		Duration:          time.Since(started),
+	}
+
+	log.Info().
+		Str("source", diagnostics.Source).
+		Int(numberOfClustersToDelete, diagnostics.ProperEntries).
+		Int(improperClusterEntries, diagnostics.ImproperEntries).
+		Int(normalizedClusterEntries, diagnostics.NormalizedEntries).
+		Dur(durationAttribute, diagnostics.Duration).
+		Msg("Cluster list read")
+	return clusterList, diagnostics, err
+}
+
+// normalizeClusterID function converts cluster ID into lowercase canonical
+// UUID form (uuid.Parse accepts also uppercase letters, braces, URN prefix,
+// and form without dashes). When version4Only is set, only version 4 UUIDs
+// are accepted.
+func normalizeClusterID(cluster ClusterName, version4Only bool) (ClusterName, error) {
+	parsed, err := uuid.Parse(string(cluster))
+	if err != nil {
+		return cluster, err
+	}
+	if version4Only && parsed.Version() != 4 {
+		return cluster, fmt.Errorf("cluster ID %s is UUID version %d, version 4 is required",
+			cluster, parsed.Version())
+	}
+	return ClusterName(parsed.String()), nil
+}
+
+// normalizeClusterList function converts all cluster IDs into lowercase
+// canonical form so they match cluster IDs stored in database. Clusters that
+// are listed more times (possibly in different forms) are cleaned up just
+// once. Number of normalized entries and number of rejected entries are
+// returned together with normalized list.
+func normalizeClusterList(clusterList ClusterList, version4Only bool) (ClusterList, int, int) {
+	normalizedCounter := 0
+	rejectedCounter := 0
+
+	var normalizedList = make([]ClusterName, 0, len(clusterList))
+	seen := make(map[ClusterName]struct{}, len(clusterList))
+
+	for _, cluster := range clusterList {
+		normalized, err := normalizeClusterID(cluster, version4Only)
+		if err != nil {
+			log.Error().Err(err).Str(inputWithClusterID, string(cluster)).Msg(notProperClusterID)
+			rejectedCounter++
+			continue
+		}
+		if normalized != cluster {
+			log.Info().
+				Str(inputWithClusterID, string(cluster)).
+				Str(clusterNameMsg, string(normalized)).
+				Msg("Cluster ID normalized")
+			normalizedCounter++
+		}
+		if _, found := seen[normalized]; found {
+			log.Warn().Str(clusterNameMsg, string(normalized)).Msg("Duplicate cluster ID")
+			continue
+		}
+		seen[normalized] = struct{}{}
+		normalizedList = append(normalizedList, normalized)
+	}
+
+	return normalizedList, normalizedCounter, rejectedCounter
+}
+
+// clusterListFiles function returns names of cluster list files. Cluster list
+// file name can be a glob pattern (for example cluster_lists/*.txt), in this
+// case all matching files are returned sorted by their names and at least
+// one file needs to match the pattern.
+func clusterListFiles(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("improper cluster list file pattern '%s': %w", pattern, err)
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no cluster list file matches pattern '%s'", pattern)
+	}
+	return filenames, nil
+}
+
+// readClusterListFromFiles function reads list of clusters from all files
+// matching given pattern. Entries from all files are merged together, they
+// are deduplicated later during normalization. Number of improper entries is
+// logged for each file.
+func readClusterListFromFiles(pattern string) (ClusterList, int, error) {
+	filenames, err := clusterListFiles(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clusterList := make(ClusterList, 0)
+	improperClusterCounter := 0
+	for _, filename := range filenames {
+		fileClusterList, fileImproperCounter, err := readClusterListFromFile(filename)
+		clusterList = append(clusterList, fileClusterList...)
+		improperClusterCounter += fileImproperCounter
+		if err != nil {
+			return clusterList, improperClusterCounter, err
+		}
+		logClusterListFile(filename, len(fileClusterList), fileImproperCounter)
+	}
+	return clusterList, improperClusterCounter, nil
+}
+
+// logClusterListFile function logs number of proper and improper entries
+// read from one cluster list file
+func logClusterListFile(filename string, properEntries, improperEntries int) {
+	log.Info().
+		Str(filenameAttribute, filename).
+		Int(numberOfClustersToDelete, properEntries).
+		Int(improperClusterEntries, improperEntries).
+		Msg("Cluster list file read")
+}
+
+// readClusterListFromQuery function reads list of clusters by SQL query that
+// returns cluster IDs in its first and only column
+func readClusterListFromQuery(connection *sql.DB, query string) (ClusterList, int, error) {
+	log.Debug().Msg("Cluster list read by SQL query")
+
+	improperClusterCounter := 0
+	var clusterList = make([]ClusterName, 0)
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return clusterList, improperClusterCounter, ErrNoConnection
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return clusterList, improperClusterCounter, errors.New("query used to read cluster list is not specified in configuration")
+	}
+
+	err := queryRows(connection, query, nil, func(rows *sql.Rows) error {
+		var cluster string
+		if err := rows.Scan(&cluster); err != nil {
+			return err
+		}
+		cluster = strings.TrimSpace(cluster)
+		// check if the row contains proper cluster ID (as UUID)
+		if IsValidUUID(cluster) {
+			clusterList = append(clusterList, ClusterName(cluster))
+			log.Info().Str(inputWithClusterID, cluster).Msg(properClusterID)
+		} else {
+			log.Error().Str(inputWithClusterID, cluster).Msg(notProperClusterID)
+			improperClusterCounter++
+		}
+		return nil
+	})
+	if err != nil {
+		return clusterList, improperClusterCounter, err
+	}
+	log.Info().Int(numberOfClustersToDelete, len(clusterList)).Msg(clusterListFinished)
+	log.Info().Int(improperClusterEntries, improperClusterCounter).Msg(clusterListFinished)
+
+	return clusterList, improperClusterCounter, nil
+}
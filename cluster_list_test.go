@@ -0,0 +1,295 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster_list_test.html
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestNewClusterListProvider checks that cluster list provider is selected
+// by CLI flags first and then by configuration
+func TestNewClusterListProvider(t *testing.T) {
+	testCases := []struct {
+		name     string
+		provider string
+		cliFlags cleaner.CliFlags
+		expected string
+	}{
+		{"default", "", cleaner.CliFlags{}, cleaner.ClusterListProviderFile},
+		{"file", "file", cleaner.CliFlags{}, cleaner.ClusterListProviderFile},
+		{"stdin", "stdin", cleaner.CliFlags{}, cleaner.ClusterListProviderStdin},
+		{"sql", "sql", cleaner.CliFlags{}, cleaner.ClusterListProviderSQL},
+		{"inventory", "inventory", cleaner.CliFlags{}, cleaner.ClusterListProviderInventory},
+		{"aggregator", "aggregator", cleaner.CliFlags{}, cleaner.ClusterListProviderAggregator},
+		{"kafka", "kafka", cleaner.CliFlags{}, cleaner.ClusterListProviderKafka},
+		{"CLI clusters", "sql", cleaner.CliFlags{Clusters: cluster1ID}, cleaner.ClusterListProviderCLI},
+		{"CLI inventory", "kafka", cleaner.CliFlags{ClustersFromInventory: true}, cleaner.ClusterListProviderInventory},
+		{"CLI aggregator", "stdin", cleaner.CliFlags{ClustersFromAggregator: true}, cleaner.ClusterListProviderAggregator},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configuration := cleaner.ConfigStruct{
+				ClusterList: cleaner.ClusterListConfiguration{Provider: tc.provider},
+			}
+			provider, err := cleaner.NewClusterListProvider(&configuration, nil, tc.cliFlags)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, provider.Name())
+		})
+	}
+}
+
+// TestNewClusterListProviderUnknown checks that unknown cluster list
+// provider is reported
+func TestNewClusterListProviderUnknown(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		ClusterList: cleaner.ClusterListConfiguration{Provider: "carrier-pigeon"},
+	}
+	_, err := cleaner.NewClusterListProvider(&configuration, nil, cleaner.CliFlags{})
+	assert.Error(t, err)
+
+	_, _, err = cleaner.ReadClusterList(&configuration, nil, cleaner.CliFlags{})
+	assert.Error(t, err)
+}
+
+// TestReadClusterListDiagnostics checks diagnostics returned together with
+// cluster list
+func TestReadClusterListDiagnostics(t *testing.T) {
+	configuration := cleaner.ConfigStruct{}
+	cliFlags := cleaner.CliFlags{Clusters: cluster1ID + ",foobar," + cluster2ID}
+
+	clusterList, diagnostics, err := cleaner.ReadClusterList(&configuration, nil, cliFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, cleaner.ClusterListProviderCLI, diagnostics.Source)
+	assert.Equal(t, 2, diagnostics.ProperEntries)
+	assert.Equal(t, 1, diagnostics.ImproperEntries)
+}
+
+// TestReadClusterListFromGlob checks that entries from all cluster list
+// files matching glob pattern are merged and deduplicated
+func TestReadClusterListFromGlob(t *testing.T) {
+	directory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "ticket_1.txt"),
+		[]byte(cluster1ID+"\nfoobar\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "ticket_2.txt"),
+		[]byte(cluster2ID+"\n"+cluster1ID+"\n"), 0o600))
+	// file not matching the pattern
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "ticket_3.csv"),
+		[]byte(cluster3ID+"\n"), 0o600))
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{ClusterListFile: filepath.Join(directory, "*.txt")},
+	}
+	clusterList, diagnostics, err := cleaner.ReadClusterList(&configuration, nil, cleaner.CliFlags{})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 2, diagnostics.ProperEntries)
+	assert.Equal(t, 1, diagnostics.ImproperEntries)
+
+	// at least one file needs to match the pattern
+	configuration.Cleaner.ClusterListFile = filepath.Join(directory, "*.json")
+	_, _, err = cleaner.ReadClusterList(&configuration, nil, cleaner.CliFlags{})
+	assert.Error(t, err)
+
+	// improper pattern
+	configuration.Cleaner.ClusterListFile = filepath.Join(directory, "[.txt")
+	_, _, err = cleaner.ReadClusterList(&configuration, nil, cleaner.CliFlags{})
+	assert.Error(t, err)
+}
+
+// TestReadClusterListFromReader checks reading cluster list from any reader
+// (used for standard input)
+func TestReadClusterListFromReader(t *testing.T) {
+	input := strings.NewReader(cluster1ID + "\nfoobar\n" + cluster2ID + "\n")
+
+	clusterList, improper, err := cleaner.ReadClusterListFromReader(input)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, improper)
+}
+
+// TestReadClusterListFromQuery checks reading cluster list by SQL query
+func TestReadClusterListFromQuery(t *testing.T) {
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"cluster_id"}).
+		AddRow(cluster1ID).
+		AddRow("foobar").
+		AddRow(cluster2ID)
+	mock.ExpectQuery("SELECT cluster FROM orphaned_clusters").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	clusterList, improper, err := cleaner.ReadClusterListFromQuery(connection, "SELECT cluster FROM orphaned_clusters")
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, improper)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadClusterListFromQueryOnError checks that errors are reported when
+// cluster list can not be read by SQL query
+func TestReadClusterListFromQueryOnError(t *testing.T) {
+	// no connection
+	_, _, err := cleaner.ReadClusterListFromQuery(nil, "SELECT cluster FROM orphaned_clusters")
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no query
+	_, _, err = cleaner.ReadClusterListFromQuery(connection, " ")
+	assert.Error(t, err)
+
+	mock.ExpectQuery("SELECT cluster FROM orphaned_clusters").WillReturnError(errors.New("query error"))
+	mock.ExpectClose()
+
+	_, _, err = cleaner.ReadClusterListFromQuery(connection, "SELECT cluster FROM orphaned_clusters")
+	assert.Error(t, err)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckClusterListConfiguration checks validation of cluster list
+// provider configuration
+func TestCheckClusterListConfiguration(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+	}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.ClusterList.Provider = "carrier-pigeon"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "unknown provider")
+
+	configuration.ClusterList.Provider = "sql"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "query is missing")
+
+	configuration.ClusterList.Query = "SELECT cluster FROM orphaned_clusters"
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.ClusterList.Provider = "kafka"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "brokers are missing")
+
+	configuration.ClusterList.Kafka = cleaner.KafkaConfiguration{
+		Brokers: []string{"localhost:9092"},
+		Topic:   "clusters",
+	}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.ClusterList.Kafka.Timeout = "soon"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "timeout is incorrect")
+
+	configuration.ClusterList = cleaner.ClusterListConfiguration{ChunkSize: 1000}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.ClusterList.ChunkSize = -1
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "chunk size is negative")
+}
+
+// TestNormalizeClusterID checks conversion of cluster IDs into canonical form
+func TestNormalizeClusterID(t *testing.T) {
+	testCases := []struct {
+		input    cleaner.ClusterName
+		expected cleaner.ClusterName
+	}{
+		{cluster1ID, cluster1ID},
+		{"123E4567-E89B-12D3-A456-426614173998", cluster1ID},
+		{"{123e4567-e89b-12d3-a456-426614173998}", cluster1ID},
+		{"urn:uuid:123e4567-e89b-12d3-a456-426614173998", cluster1ID},
+		{"123e4567e89b12d3a456426614173998", cluster1ID},
+	}
+
+	for _, tc := range testCases {
+		normalized, err := cleaner.NormalizeClusterID(tc.input, false)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, normalized)
+	}
+
+	_, err := cleaner.NormalizeClusterID("foobar", false)
+	assert.Error(t, err)
+
+	// cluster1ID is version 1 UUID
+	_, err = cleaner.NormalizeClusterID(cluster1ID, true)
+	assert.Error(t, err)
+
+	_, err = cleaner.NormalizeClusterID("5d5892d4-1f74-4ccf-91af-548dfc9767aa", true)
+	assert.NoError(t, err)
+}
+
+// TestNormalizeClusterList checks that cluster list is normalized and
+// deduplicated and that normalized and rejected entries are counted
+func TestNormalizeClusterList(t *testing.T) {
+	clusterList := cleaner.ClusterList{
+		"123E4567-E89B-12D3-A456-426614173998",
+		cluster1ID,
+		"5d5892d4-1f74-4ccf-91af-548dfc9767aa",
+	}
+
+	normalized, normalizedCount, rejected := cleaner.NormalizeClusterList(clusterList, false)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, "5d5892d4-1f74-4ccf-91af-548dfc9767aa"}, normalized)
+	assert.Equal(t, 1, normalizedCount)
+	assert.Equal(t, 0, rejected)
+
+	// only version 4 UUIDs are accepted
+	normalized, normalizedCount, rejected = cleaner.NormalizeClusterList(clusterList, true)
+	assert.Equal(t, cleaner.ClusterList{"5d5892d4-1f74-4ccf-91af-548dfc9767aa"}, normalized)
+	assert.Equal(t, 0, normalizedCount)
+	assert.Equal(t, 2, rejected)
+}
+
+// TestReadClusterListNormalized checks that normalized entries are reported
+// in diagnostics
+func TestReadClusterListNormalized(t *testing.T) {
+	configuration := cleaner.ConfigStruct{}
+	cliFlags := cleaner.CliFlags{Clusters: "123E4567-E89B-12D3-A456-426614173998," + cluster2ID}
+
+	clusterList, diagnostics, err := cleaner.ReadClusterList(&configuration, nil, cliFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, diagnostics.NormalizedEntries)
+	assert.Equal(t, 0, diagnostics.ImproperEntries)
+}
+
+// TestReadClusterListFromReaderWhitespaces checks that whitespaces around
+// cluster IDs (including CR from Windows line endings) are ignored
+func TestReadClusterListFromReaderWhitespaces(t *testing.T) {
+	input := strings.NewReader("  " + cluster1ID + "\r\n\t123E4567-E89B-12D3-A456-426614173777 \r\n")
+
+	clusterList, improper, err := cleaner.ReadClusterListFromReader(input)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, "123E4567-E89B-12D3-A456-426614173777"}, clusterList)
+	assert.Equal(t, 0, improper)
+}
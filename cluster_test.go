@@ -0,0 +1,255 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/cluster_test.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// Statements expected by cleanup of one cluster
+const (
+	countDVOClusterRecords  = "SELECT COUNT\\(\\*\\) FROM dvo_report WHERE cluster_id = \\$1;"
+	selectDVOClusterRecords = "SELECT \\* FROM dvo_report WHERE cluster_id = \\$1"
+	deleteDVOClusterRecords = "DELETE FROM dvo_report WHERE cluster_id = \\$1;"
+	singleCluster           = "5d5892d3-1f74-4ccf-91af-548dfc9767aa"
+)
+
+// clusterConfiguration is configuration used by tests of cleanup of one
+// cluster
+var clusterConfiguration = cleaner.ConfigStruct{
+	Storage: cleaner.StorageConfiguration{Name: "primary"},
+}
+
+// setConfirmation function replaces input and prompt used to confirm
+// deletion of cluster records
+func setConfirmation(t *testing.T, answer string) *bytes.Buffer {
+	var prompt bytes.Buffer
+
+	originalInput := *cleaner.ConfirmationInput
+	originalPrompt := *cleaner.ConfirmationPrompt
+	*cleaner.ConfirmationInput = strings.NewReader(answer)
+	*cleaner.ConfirmationPrompt = &prompt
+	t.Cleanup(func() {
+		*cleaner.ConfirmationInput = originalInput
+		*cleaner.ConfirmationPrompt = originalPrompt
+	})
+	return &prompt
+}
+
+// expectClusterInspection function registers expected counting and
+// archiving of records of one cluster
+func expectClusterInspection(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(countDVOClusterRecords).
+		WithArgs(singleCluster).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(selectDVOClusterRecords).
+		WithArgs(singleCluster).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "cluster_id", "report"}).
+			AddRow(1, singleCluster, []byte(`{"workloads":[]}`)))
+}
+
+// TestConfirmClusterDeletion checks interactive confirmation of deletion
+func TestConfirmClusterDeletion(t *testing.T) {
+	var prompt bytes.Buffer
+
+	err := cleaner.ConfirmClusterDeletion(strings.NewReader(singleCluster+"\n"), &prompt, singleCluster, 10)
+	assert.NoError(t, err)
+	assert.Contains(t, prompt.String(), "10 records of cluster "+singleCluster)
+
+	// answer without new line is accepted too
+	err = cleaner.ConfirmClusterDeletion(strings.NewReader(" "+singleCluster), io.Discard, singleCluster, 10)
+	assert.NoError(t, err)
+
+	for _, answer := range []string{"", "\n", "yes\n", "5d5892d3\n"} {
+		err = cleaner.ConfirmClusterDeletion(strings.NewReader(answer), io.Discard, singleCluster, 10)
+		var confirmationErr *cleaner.ErrClusterNotConfirmed
+		assert.ErrorAs(t, err, &confirmationErr, answer)
+	}
+}
+
+// TestCleanupSingleClusterDryRun checks that records of cluster are archived
+// but not deleted in dry run mode
+func TestCleanupSingleClusterDryRun(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "archive.json")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectClusterInspection(mock)
+	mock.ExpectClose()
+
+	// cluster ID is normalized
+	cliFlags := cleaner.CliFlags{
+		Cluster: strings.ToUpper(singleCluster),
+		Output:  filename,
+		DryRun:  true,
+	}
+	status, err := cleaner.CleanupSingleCluster(&clusterConfiguration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	var archive cleaner.ClusterArchive
+	assert.NoError(t, json.Unmarshal(content, &archive))
+	assert.Equal(t, cleaner.ClusterName(singleCluster), archive.Cluster)
+	assert.Equal(t, "primary", archive.Source)
+	assert.Equal(t, cleaner.DBSchemaDVORecommendations, archive.Schema)
+	assert.Len(t, archive.Tables["dvo_report"], 1)
+	assert.Equal(t, `{"workloads":[]}`, archive.Tables["dvo_report"][0]["report"])
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupSingleClusterConfirmed checks that records of cluster are
+// deleted when deletion is confirmed
+func TestCleanupSingleClusterConfirmed(t *testing.T) {
+	prompt := setConfirmation(t, singleCluster+"\n")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectClusterInspection(mock)
+	mock.ExpectExec(deleteDVOClusterRecords).
+		WithArgs(singleCluster).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	cliFlags := cleaner.CliFlags{
+		Cluster: singleCluster,
+		Output:  filepath.Join(t.TempDir(), "archive.json"),
+		DryRun:  false,
+	}
+	status, err := cleaner.CleanupSingleCluster(&clusterConfiguration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.Contains(t, prompt.String(), "type the cluster ID to confirm")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupSingleClusterNotConfirmed checks that records of cluster are not
+// deleted when deletion is not confirmed
+func TestCleanupSingleClusterNotConfirmed(t *testing.T) {
+	setConfirmation(t, "no\n")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no delete statement is expected
+	expectClusterInspection(mock)
+	mock.ExpectClose()
+
+	cliFlags := cleaner.CliFlags{
+		Cluster: singleCluster,
+		Output:  filepath.Join(t.TempDir(), "archive.json"),
+		DryRun:  false,
+	}
+	status, err := cleaner.CleanupSingleCluster(&clusterConfiguration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	var confirmationErr *cleaner.ErrClusterNotConfirmed
+	assert.ErrorAs(t, err, &confirmationErr)
+	assert.Equal(t, cleaner.ExitStatusNotConfirmed, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupSingleClusterNoRecords checks that nothing is archived when
+// cluster does not have any records
+func TestCleanupSingleClusterNoRecords(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "archive.json")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(countDVOClusterRecords).
+		WithArgs(singleCluster).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectClose()
+
+	cliFlags := cleaner.CliFlags{
+		Cluster: singleCluster,
+		Output:  filename,
+		DryRun:  false,
+		Force:   true,
+	}
+	status, err := cleaner.CleanupSingleCluster(&clusterConfiguration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.NoFileExists(t, filename)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupSingleClusterRefused checks that improper and protected
+// clusters are refused before database is accessed
+func TestCleanupSingleClusterRefused(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{ProtectedClusters: []string{singleCluster}},
+	}
+	for _, cluster := range []string{"not-a-cluster", singleCluster} {
+		status, err := cleaner.CleanupSingleCluster(&configuration, connection,
+			cleaner.CliFlags{Cluster: cluster, Force: true}, cleaner.DBSchemaDVORecommendations)
+		assert.Error(t, err, cluster)
+		assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+	}
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
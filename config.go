@@ -33,6 +33,8 @@ package main
 
 // Default name of configuration file is config.toml
 // It can be changed via environment variable INSIGHTS_RESULTS_CLEANER_CONFIG_FILE
+// or by -config command line option. Configuration files in YAML and JSON
+// formats are supported too, format is detected by file extension.
 
 // An example of configuration file that can be used in devel environment:
 //
@@ -45,14 +47,126 @@ package main
 // pg_db_name = "aggregator"
 // pg_params = "sslmode=disable"
 // schema = "ocp_recommendations"
+// ping_retries = 3
+// ping_backoff = "1s"
+// application_name = "insights-results-aggregator-cleaner"
+// search_path = ""
+// fingerprint = "aggregator"
+// fingerprint_query = ""
+// migration_check = ""
 //
 // [logging]
 // debug = true
 // log_level = ""
 //
+// [log_sampling]
+// row_events = 0
+//
 // [cleaner]
 // max_age = "90 days"
 // cluster_list_file = "cluster_list.txt"
+// cluster_retries = 3
+// targets_concurrency = 1
+// ocp_table_schema = ""
+// slow_statement_threshold = "5s"
+// analyze_threshold = 0.1
+// protected_clusters = []
+// require_uuid_v4 = false
+// case_insensitive_match = false
+// read_only = false
+// safe_mode = false
+// require_rls_bypass = false
+//
+// [session_settings.default]
+// work_mem = "16MB"
+//
+// [session_settings.operations.vacuum]
+// maintenance_work_mem = "1GB"
+//
+// [output]
+// checksum = false
+// time_format = "RFC3339"
+// utc = false
+// age_unit = "days"
+// format = "csv"
+// columns = ""
+// hash_cluster_ids = false
+// cluster_id_salt = ""
+//
+// [output.s3]
+// endpoint = ""
+// region = "us-east-1"
+//
+// [metrics]
+// textfile_path = "/var/lib/node_exporter/textfile_collector/cleaner.prom"
+//
+// [evidence]
+// file = "deletion_evidence.json"
+// private_key = "evidence_key.pem"
+//
+// [inventory]
+// url = "https://api.openshift.com"
+// token = ""
+// min_age = "30 days"
+// statuses = ["Deprovisioned", "Archived", "Disconnected"]
+// page_size = 100
+// timeout = "30s"
+//
+// [aggregator]
+// url = "http://insights-results-aggregator:8080"
+// endpoint = "/api/v1/admin/clusters/orphaned"
+// token = ""
+// username = ""
+// password = ""
+// timeout = "30s"
+//
+// [cluster_list]
+// provider = "file"
+// query = ""
+// chunk_size = 0
+//
+// [cluster_list.kafka]
+// brokers = ["localhost:9092"]
+// topic = "ccx.cleaner.clusters"
+// timeout = "10s"
+//
+// [history]
+// table = ""
+//
+// [watch]
+// poll_interval = ""
+// pattern = "*.txt"
+//
+// [maintenance_window]
+// start = "01:00"
+// end = "05:00"
+// days = ["weekdays"]
+// timezone = "UTC"
+//
+// [authorization]
+// enabled = false
+//
+// [[authorization.rules]]
+// identity = "system:serviceaccount:ccx-data-pipeline:cleaner"
+// operations = ["list", "cleanup"]
+//
+// [repack]
+// enabled = false
+// command = "pg_repack"
+// wait_timeout = 60
+//
+// [[schemas]]
+// name = "notifications"
+// database_schema = "public"
+//
+// [[schemas.tables]]
+// table = "reported"
+// key = "cluster"
+// delete_statement = "DELETE FROM reported WHERE updated_at < NOW() - $1::INTERVAL"
+//
+// Storage targets can be declared in [[targets]] sections that have the same
+// structure as [storage] section plus target name. When they are declared,
+// the selected operation is performed against each of them.
 //
 //
 // Environment variables that can be used to override configuration file settings:
@@ -64,16 +178,77 @@ package main
 // INSIGHTS_RESULTS_CLEANER__STORAGE__PG_DB_NAME
 // INSIGHTS_RESULTS_CLEANER__STORAGE__PG_PARAMS
 // INSIGHTS_RESULTS_CLEANER__STORAGE__SCHEMA
+// INSIGHTS_RESULTS_CLEANER__STORAGE__PING_RETRIES
+// INSIGHTS_RESULTS_CLEANER__STORAGE__PING_BACKOFF
+// INSIGHTS_RESULTS_CLEANER__STORAGE__APPLICATION_NAME
+// INSIGHTS_RESULTS_CLEANER__STORAGE__SEARCH_PATH
+// INSIGHTS_RESULTS_CLEANER__STORAGE__FINGERPRINT
+// INSIGHTS_RESULTS_CLEANER__STORAGE__FINGERPRINT_QUERY
+// INSIGHTS_RESULTS_CLEANER__STORAGE__MIGRATION_CHECK
 // INSIGHTS_RESULTS_CLEANER__LOGGING__DEBUG
 // INSIGHTS_RESULTS_CLEANER__LOGGING__LOG_DEVEL
+// INSIGHTS_RESULTS_CLEANER__LOG_SAMPLING__ROW_EVENTS
 // INSIGHTS_RESULTS_CLEANER__CLEANER__MAX_AGE
+// INSIGHTS_RESULTS_CLEANER__CLEANER__CLUSTER_RETRIES
+// INSIGHTS_RESULTS_CLEANER__CLEANER__TARGETS_CONCURRENCY
+// INSIGHTS_RESULTS_CLEANER__CLEANER__OCP_TABLE_SCHEMA
+// INSIGHTS_RESULTS_CLEANER__CLEANER__SLOW_STATEMENT_THRESHOLD
+// INSIGHTS_RESULTS_CLEANER__CLEANER__ANALYZE_THRESHOLD
+// INSIGHTS_RESULTS_CLEANER__CLEANER__REQUIRE_UUID_V4
+// INSIGHTS_RESULTS_CLEANER__CLEANER__CASE_INSENSITIVE_MATCH
+// INSIGHTS_RESULTS_CLEANER__CLEANER__READ_ONLY
+// INSIGHTS_RESULTS_CLEANER__CLEANER__REQUIRE_RLS_BYPASS
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__CHECKSUM
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__TIME_FORMAT
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__UTC
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__AGE_UNIT
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__FORMAT
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__COLUMNS
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__HASH_CLUSTER_IDS
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__CLUSTER_ID_SALT
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__S3__ENDPOINT
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__S3__REGION
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__S3__ACCESS_KEY_ID
+// INSIGHTS_RESULTS_CLEANER__OUTPUT__S3__SECRET_ACCESS_KEY
+// INSIGHTS_RESULTS_CLEANER__METRICS__TEXTFILE_PATH
+// INSIGHTS_RESULTS_CLEANER__EVIDENCE__FILE
+// INSIGHTS_RESULTS_CLEANER__EVIDENCE__PRIVATE_KEY
+// INSIGHTS_RESULTS_CLEANER__INVENTORY__URL
+// INSIGHTS_RESULTS_CLEANER__INVENTORY__TOKEN
+// INSIGHTS_RESULTS_CLEANER__INVENTORY__MIN_AGE
+// INSIGHTS_RESULTS_CLEANER__INVENTORY__PAGE_SIZE
+// INSIGHTS_RESULTS_CLEANER__INVENTORY__TIMEOUT
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__URL
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__ENDPOINT
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__TOKEN
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__USERNAME
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__PASSWORD
+// INSIGHTS_RESULTS_CLEANER__AGGREGATOR__TIMEOUT
+// INSIGHTS_RESULTS_CLEANER__CLUSTER_LIST__PROVIDER
+// INSIGHTS_RESULTS_CLEANER__CLUSTER_LIST__QUERY
+// INSIGHTS_RESULTS_CLEANER__CLUSTER_LIST__CHUNK_SIZE
+// INSIGHTS_RESULTS_CLEANER__CLUSTER_LIST__KAFKA__TOPIC
+// INSIGHTS_RESULTS_CLEANER__CLUSTER_LIST__KAFKA__TIMEOUT
+// INSIGHTS_RESULTS_CLEANER__HISTORY__TABLE
+// INSIGHTS_RESULTS_CLEANER__WATCH__POLL_INTERVAL
+// INSIGHTS_RESULTS_CLEANER__WATCH__PATTERN
+// INSIGHTS_RESULTS_CLEANER__MAINTENANCE_WINDOW__START
+// INSIGHTS_RESULTS_CLEANER__MAINTENANCE_WINDOW__END
+// INSIGHTS_RESULTS_CLEANER__MAINTENANCE_WINDOW__TIMEZONE
+// INSIGHTS_RESULTS_CLEANER__AUTHORIZATION__ENABLED
+// INSIGHTS_RESULTS_CLEANER__REPACK__ENABLED
+// INSIGHTS_RESULTS_CLEANER__REPACK__COMMAND
+// INSIGHTS_RESULTS_CLEANER__REPACK__WAIT_TIMEOUT
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/RedHatInsights/insights-operator-utils/logger"
@@ -90,22 +265,313 @@ const (
 
 // ConfigStruct is a structure holding the whole service configuration
 type ConfigStruct struct {
-	Storage StorageConfiguration              `mapstructure:"storage" toml:"storage"`
-	Logging logger.LoggingConfiguration       `mapstructure:"logging" toml:"logging"`
-	Cleaner CleanerConfiguration              `mapstructure:"cleaner" toml:"cleaner"`
-	Sentry  logger.SentryLoggingConfiguration `mapstructure:"sentry" toml:"sentry"`
+	Storage       StorageConfiguration              `mapstructure:"storage" toml:"storage"`
+	Logging       logger.LoggingConfiguration       `mapstructure:"logging" toml:"logging"`
+	LogSampling   LogSamplingConfiguration          `mapstructure:"log_sampling" toml:"log_sampling"`
+	Cleaner       CleanerConfiguration              `mapstructure:"cleaner" toml:"cleaner"`
+	Session       SessionSettingsConfiguration      `mapstructure:"session_settings" toml:"session_settings"`
+	Output        OutputConfiguration               `mapstructure:"output" toml:"output"`
+	Metrics       MetricsConfiguration              `mapstructure:"metrics" toml:"metrics"`
+	Evidence      EvidenceConfiguration             `mapstructure:"evidence" toml:"evidence"`
+	Repack        RepackConfiguration               `mapstructure:"repack" toml:"repack"`
+	Inventory     InventoryConfiguration            `mapstructure:"inventory" toml:"inventory"`
+	Aggregator    AggregatorConfiguration           `mapstructure:"aggregator" toml:"aggregator"`
+	ClusterList   ClusterListConfiguration          `mapstructure:"cluster_list" toml:"cluster_list"`
+	History       HistoryConfiguration              `mapstructure:"history" toml:"history"`
+	Watch         WatchConfiguration                `mapstructure:"watch" toml:"watch"`
+	Window        MaintenanceWindowConfiguration    `mapstructure:"maintenance_window" toml:"maintenance_window"`
+	Authorization AuthorizationConfiguration        `mapstructure:"authorization" toml:"authorization"`
+	Sentry        logger.SentryLoggingConfiguration `mapstructure:"sentry" toml:"sentry"`
+	Schemas       []SchemaConfiguration             `mapstructure:"schemas" toml:"schemas"`
+	Targets       []StorageConfiguration            `mapstructure:"targets" toml:"targets"`
 }
 
 // CleanerConfiguration represents configuration for the main cleaner
 type CleanerConfiguration struct {
 	// MaxAge is specification of max age for records to be cleaned
 	MaxAge string `mapstructure:"max_age" toml:"max_age"`
-	// ClusterListFile contains file name with list of clusters to delete
+	// ClusterListFile contains file name with list of clusters to delete,
+	// it can be a glob pattern matching more files
 	ClusterListFile string `mapstructure:"cluster_list_file" toml:"cluster_list_file"`
+	// ClusterRetries is number of retries for clusters that can not be
+	// cleaned up (because of deadlock, timeout etc.). Such clusters are
+	// requeued and retried at the end of the run
+	ClusterRetries int `mapstructure:"cluster_retries" toml:"cluster_retries"`
+	// TargetsConcurrency is max number of storage targets processed at
+	// the same time (targets are processed sequentially by default)
+	TargetsConcurrency int `mapstructure:"targets_concurrency" toml:"targets_concurrency"`
+	// OCPTableSchema is name of schema where OCP tables are stored (the
+	// default schema is used when it is empty)
+	OCPTableSchema string `mapstructure:"ocp_table_schema" toml:"ocp_table_schema"`
+	// SlowStatementThreshold is duration of SQL statement execution that
+	// is considered slow, for example "5s" (slow statements are not
+	// detected when it is empty)
+	SlowStatementThreshold string `mapstructure:"slow_statement_threshold" toml:"slow_statement_threshold"`
+	// AnalyzeThreshold is fraction of table rows (from 0 to 1) that needs
+	// to be deleted to run ANALYZE on the table after cleanup (tables are
+	// not analyzed when it is zero)
+	AnalyzeThreshold float64 `mapstructure:"analyze_threshold" toml:"analyze_threshold"`
+	// ProtectedClusters contains IDs of clusters that are never cleaned
+	// up even when they are part of cluster list
+	ProtectedClusters []string `mapstructure:"protected_clusters" toml:"protected_clusters"`
+	// RequireUUIDv4 is set when only version 4 UUIDs are accepted as
+	// cluster IDs
+	RequireUUIDv4 bool `mapstructure:"require_uuid_v4" toml:"require_uuid_v4"`
+	// CaseInsensitiveMatch is set when cluster IDs stored in database
+	// should be matched regardless of letter case
+	CaseInsensitiveMatch bool `mapstructure:"case_insensitive_match" toml:"case_insensitive_match"`
+	// ReadOnly is set when the tool should never modify data, all
+	// operations are converted into their non-destructive variants
+	ReadOnly bool `mapstructure:"read_only" toml:"read_only"`
+	// SafeMode is set when destructive operations need to be confirmed
+	// by names of database and DB schema
+	SafeMode bool `mapstructure:"safe_mode" toml:"safe_mode"`
+	// RequireRLSBypass is set when destructive operations need to be
+	// refused for tables where row-level security applies to the
+	// database user (just a warning is logged otherwise)
+	RequireRLSBypass bool `mapstructure:"require_rls_bypass" toml:"require_rls_bypass"`
+}
+
+// OutputConfiguration represents configuration of files with exported
+// listings
+type OutputConfiguration struct {
+	// Checksum enables writing SHA-256 checksum of exported file into
+	// file with .sha256 suffix
+	Checksum bool `mapstructure:"checksum" toml:"checksum"`
+	// TimeFormat is a layout used to format timestamps (RFC3339 by
+	// default). Go time layout or one of the names RFC3339, RFC3339Nano,
+	// RFC1123, RFC1123Z, DateTime, and DateOnly can be used. The layout
+	// needs to contain date, it is checked at startup
+	TimeFormat string `mapstructure:"time_format" toml:"time_format"`
+	// UTC enables normalization of all exported timestamps to UTC
+	UTC bool `mapstructure:"utc" toml:"utc"`
+	// AgeUnit specifies units used to express age of records: "days"
+	// (default), "hours", or "duration" for exact duration string
+	AgeUnit string `mapstructure:"age_unit" toml:"age_unit"`
+	// Format specifies format of exported records: "csv" (default),
+	// "json" for JSON lines, or "log" to write records into log
+	Format string `mapstructure:"format" toml:"format"`
+	// Columns is comma separated list of exported fields, all fields
+	// are exported when it is empty
+	Columns string `mapstructure:"columns" toml:"columns"`
+	// HashClusterIDs enables replacing cluster IDs in exported records
+	// by their SHA-256 hashes salted by ClusterIDSalt
+	HashClusterIDs bool   `mapstructure:"hash_cluster_ids" toml:"hash_cluster_ids"`
+	ClusterIDSalt  string `mapstructure:"cluster_id_salt" toml:"cluster_id_salt"`
+	// S3 contains configuration of S3 bucket used when output name
+	// starts with s3:// prefix
+	S3 S3Configuration `mapstructure:"s3" toml:"s3"`
+}
+
+// S3Configuration represents configuration of S3 (or S3 compatible)
+// storage where exported records can be uploaded
+type S3Configuration struct {
+	// Endpoint needs to be set for S3 compatible storages only
+	Endpoint string `mapstructure:"endpoint" toml:"endpoint"`
+	Region   string `mapstructure:"region" toml:"region"`
+	// AccessKeyID and SecretAccessKey are optional, default AWS
+	// credential chain is used when they are not set
+	AccessKeyID     string `mapstructure:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key" toml:"secret_access_key"`
+}
+
+// MetricsConfiguration represents configuration of metrics export
+type MetricsConfiguration struct {
+	// TextfilePath contains name of file where metrics are written in
+	// format compatible with node-exporter textfile collector. Metrics are
+	// not written when it is empty
+	TextfilePath string `mapstructure:"textfile_path" toml:"textfile_path"`
+}
+
+// EvidenceConfiguration represents configuration of deletion evidence report
+type EvidenceConfiguration struct {
+	// File contains name of file where the evidence is written after
+	// cleanup. Evidence is not written when it is empty
+	File string `mapstructure:"file" toml:"file"`
+	// PrivateKey contains name of file with private key (in PEM format)
+	// used to sign the evidence
+	PrivateKey string `mapstructure:"private_key" toml:"private_key"`
+}
+
+// LogSamplingConfiguration represents configuration of sampling of
+// row-level log events
+type LogSamplingConfiguration struct {
+	// RowEvents contains N when just one of every N row-level events
+	// (ie. events logged for each old record) is to be logged. All
+	// events are logged when it is zero or one
+	RowEvents int `mapstructure:"row_events" toml:"row_events"`
+}
+
+// SessionSettingsConfiguration represents configuration of PostgreSQL
+// session settings (GUCs) applied to connections opened by the cleaner
+type SessionSettingsConfiguration struct {
+	// Default contains settings applied for all operations, for example
+	// work_mem = "16MB"
+	Default map[string]string `mapstructure:"default" toml:"default"`
+	// Operations contains settings applied for one operation only, keyed
+	// by operation name ("vacuum", "cleanup-all" etc.). They take
+	// precedence over default settings
+	Operations map[string]map[string]string `mapstructure:"operations" toml:"operations"`
+}
+
+// HistoryConfiguration represents configuration of run history used to
+// produce weekly digest
+type HistoryConfiguration struct {
+	// Table contains name of table where each cleanup run is recorded.
+	// Runs are not recorded when it is empty
+	Table string `mapstructure:"table" toml:"table"`
+}
+
+// WatchConfiguration represents configuration of watch mode that processes
+// deletion requests dropped into directory or S3 prefix
+type WatchConfiguration struct {
+	// PollInterval contains how often the watched location is checked
+	// for new requests, for example "1m". Pending requests are processed
+	// just once when it is empty
+	PollInterval string `mapstructure:"poll_interval" toml:"poll_interval"`
+	// Pattern contains glob pattern of request file names, "*.txt" is
+	// used when it is empty
+	Pattern string `mapstructure:"pattern" toml:"pattern"`
+}
+
+// MaintenanceWindowConfiguration represents configuration of time window
+// when destructive operations are allowed
+type MaintenanceWindowConfiguration struct {
+	// Start and End contain time of day specified as HH:MM. Destructive
+	// operations are allowed anytime when both are empty
+	Start string `mapstructure:"start" toml:"start"`
+	End   string `mapstructure:"end" toml:"end"`
+	// Days contains days when the window is open ("Mon" to "Sun",
+	// "weekdays", or "weekend"), all days are allowed when it is empty
+	Days []string `mapstructure:"days" toml:"days"`
+	// Timezone contains name of time zone used to evaluate the window,
+	// UTC is used when it is empty
+	Timezone string `mapstructure:"timezone" toml:"timezone"`
+}
+
+// AuthorizationConfiguration represents configuration of per-operation
+// authorization
+type AuthorizationConfiguration struct {
+	// Enabled is set when identity that triggered the run needs to be
+	// allowed to perform selected operation
+	Enabled bool                `mapstructure:"enabled" toml:"enabled"`
+	Rules   []AuthorizationRule `mapstructure:"rules" toml:"rules"`
+}
+
+// AuthorizationRule maps identity (service account, operator) to allowed
+// categories of operations ("list", "cleanup", "vacuum", "admin", or "*")
+type AuthorizationRule struct {
+	Identity   string   `mapstructure:"identity" toml:"identity"`
+	Operations []string `mapstructure:"operations" toml:"operations"`
+}
+
+// InventoryConfiguration represents configuration of inventory API used to
+// read list of clusters to cleanup
+type InventoryConfiguration struct {
+	// URL contains base URL of inventory API
+	URL string `mapstructure:"url" toml:"url"`
+	// Token contains bearer token used to authenticate requests
+	Token string `mapstructure:"token" toml:"token"`
+	// MinAge contains how long the cluster needs to be inactive to be
+	// cleaned up, for example "30 days"
+	MinAge string `mapstructure:"min_age" toml:"min_age"`
+	// Statuses contains statuses of subscriptions of inactive clusters
+	Statuses []string `mapstructure:"statuses" toml:"statuses"`
+	// PageSize contains number of subscriptions read in one request
+	PageSize int `mapstructure:"page_size" toml:"page_size"`
+	// Timeout contains timeout of one request, for example "30s"
+	Timeout string `mapstructure:"timeout" toml:"timeout"`
+}
+
+// AggregatorConfiguration represents configuration of insights-results-aggregator
+// admin endpoint used to read list of orphaned clusters
+type AggregatorConfiguration struct {
+	// URL contains base URL of the aggregator
+	URL string `mapstructure:"url" toml:"url"`
+	// Endpoint contains path to endpoint with list of orphaned clusters
+	Endpoint string `mapstructure:"endpoint" toml:"endpoint"`
+	// Token contains bearer token used to authenticate requests
+	Token string `mapstructure:"token" toml:"token"`
+	// Username contains user name used for basic authentication, token
+	// is not used when it is set
+	Username string `mapstructure:"username" toml:"username"`
+	// Password contains password used for basic authentication
+	Password string `mapstructure:"password" toml:"password"`
+	// Timeout contains timeout of the request, for example "30s"
+	Timeout string `mapstructure:"timeout" toml:"timeout"`
+}
+
+// ClusterListConfiguration represents configuration of source of cluster
+// list
+type ClusterListConfiguration struct {
+	// Provider contains name of cluster list provider: "file" (default),
+	// "stdin", "sql", "inventory", "aggregator", or "kafka"
+	Provider string `mapstructure:"provider" toml:"provider"`
+	// Query contains SQL query used by "sql" provider, the query needs to
+	// return cluster IDs in its only column
+	Query string `mapstructure:"query" toml:"query"`
+	// ChunkSize contains number of clusters cleaned up in one chunk when
+	// cluster list is streamed from file or from standard input, zero
+	// means that the whole list is read at once
+	ChunkSize int `mapstructure:"chunk_size" toml:"chunk_size"`
+	// Kafka contains configuration of "kafka" provider
+	Kafka KafkaConfiguration `mapstructure:"kafka" toml:"kafka"`
+}
+
+// KafkaConfiguration represents configuration of Kafka topic with list of
+// clusters to cleanup
+type KafkaConfiguration struct {
+	// Brokers contains addresses of Kafka brokers
+	Brokers []string `mapstructure:"brokers" toml:"brokers"`
+	// Topic contains name of topic with cluster IDs
+	Topic string `mapstructure:"topic" toml:"topic"`
+	// Timeout contains how long to wait for next message, for example "10s"
+	Timeout string `mapstructure:"timeout" toml:"timeout"`
+}
+
+// RepackConfiguration represents configuration of pg_repack integration
+type RepackConfiguration struct {
+	// Enabled is set when tables selected for VACUUM FULL should be
+	// rewritten by pg_repack instead
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Command contains name or path of pg_repack command
+	Command string `mapstructure:"command" toml:"command"`
+	// WaitTimeout contains number of seconds pg_repack waits for
+	// conflicting locks before other queries are cancelled, default
+	// pg_repack timeout is used when it is zero
+	WaitTimeout int `mapstructure:"wait_timeout" toml:"wait_timeout"`
+}
+
+// SchemaConfiguration represents plug-in DB schema declared in configuration
+// file. It can be selected in storage configuration in the same way as
+// built-in schemas
+type SchemaConfiguration struct {
+	// Name is name of logical schema used in storage configuration
+	Name string `mapstructure:"name" toml:"name"`
+	// DatabaseSchema is name of PostgreSQL schema where tables are
+	// stored ("public" by default)
+	DatabaseSchema string `mapstructure:"database_schema" toml:"database_schema"`
+	// Tables contains list of tables to be cleaned up
+	Tables []SchemaTableConfiguration `mapstructure:"tables" toml:"tables"`
+}
+
+// SchemaTableConfiguration represents one table from plug-in DB schema
+type SchemaTableConfiguration struct {
+	// TableName is name of table
+	TableName string `mapstructure:"table" toml:"table"`
+	// KeyName is name of column with cluster name used by cleanup of
+	// selected clusters
+	KeyName string `mapstructure:"key" toml:"key"`
+	// OrgKeyName is name of column with organization ID (optional)
+	OrgKeyName string `mapstructure:"org_key" toml:"org_key"`
+	// DeleteStatement is statement used to delete old records, max age
+	// is passed as its only parameter
+	DeleteStatement string `mapstructure:"delete_statement" toml:"delete_statement"`
 }
 
 // StorageConfiguration represents configuration of data storage
 type StorageConfiguration struct {
+	Name             string `mapstructure:"name" toml:"name"`
 	Driver           string `mapstructure:"db_driver" toml:"db_driver"`
 	SQLiteDataSource string `mapstructure:"sqlite_datasource" toml:"sqlite_datasource"`
 	PGUsername       string `mapstructure:"pg_username" toml:"pg_username"`
@@ -115,6 +581,55 @@ type StorageConfiguration struct {
 	PGDBName         string `mapstructure:"pg_db_name" toml:"pg_db_name"`
 	PGParams         string `mapstructure:"pg_params" toml:"pg_params"`
 	Schema           string `mapstructure:"schema" toml:"schema"`
+	PingRetries      int    `mapstructure:"ping_retries" toml:"ping_retries"`
+	PingBackoff      string `mapstructure:"ping_backoff" toml:"ping_backoff"`
+	ApplicationName  string `mapstructure:"application_name" toml:"application_name"`
+	SearchPath       string `mapstructure:"search_path" toml:"search_path"`
+	// Fingerprint is value expected to be returned by FingerprintQuery.
+	// It is used to verify that the right database is used, fingerprint
+	// is not verified when it is empty
+	Fingerprint string `mapstructure:"fingerprint" toml:"fingerprint"`
+	// FingerprintQuery is query that returns fingerprint of database,
+	// name of current database is used when it is empty
+	FingerprintQuery string `mapstructure:"fingerprint_query" toml:"fingerprint_query"`
+	// MigrationCheck selects what happens when database has been migrated
+	// by newer version of aggregator: "warn" or "refuse", migration
+	// version is not checked when it is empty
+	MigrationCheck string `mapstructure:"migration_check" toml:"migration_check"`
+}
+
+// configFileFormats contains supported formats of configuration file
+// detected by its extension
+var configFileFormats = map[string]string{
+	".toml": "toml",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".json": "json",
+}
+
+// configFileFormat function detects format of configuration file by its
+// extension. Empty string is returned for file without extension.
+func configFileFormat(configFile string) (string, error) {
+	extension := filepath.Ext(configFile)
+	if extension == "" {
+		return "", nil
+	}
+	format, found := configFileFormats[strings.ToLower(extension)]
+	if !found {
+		return "", fmt.Errorf("unsupported format of configuration file %s, use TOML, YAML, or JSON", configFile)
+	}
+	return format, nil
+}
+
+// selectConfigurationFile function selects configuration file specified by
+// -config command line option. The file is passed to LoadConfiguration via
+// environment variable, so it takes precedence over the file set in that
+// variable. Nothing is changed when no file is specified.
+func selectConfigurationFile(configFileEnvVariableName, configFile string) error {
+	if configFile == "" {
+		return nil
+	}
+	return os.Setenv(configFileEnvVariableName, configFile)
 }
 
 // LoadConfiguration function loads configuration from defaultConfigFile, file
@@ -122,17 +637,30 @@ type StorageConfiguration struct {
 func LoadConfiguration(configFileEnvVariableName, defaultConfigFile string) (ConfigStruct, error) {
 	var config ConfigStruct
 
+	// configuration can be loaded repeatedly, so file name and format used
+	// by previous load must be forgotten
+	viper.Reset()
+
 	// env. variable holding name of configuration file
 	configFile, specified := os.LookupEnv(configFileEnvVariableName)
 	if specified {
 		log.Info().Str(filenameAttribute, configFile).Msg(parsingConfigurationFileMessage)
-		// we need to separate the directory name and filename without
-		// extension
-		directory, basename := filepath.Split(configFile)
-		file := strings.TrimSuffix(basename, filepath.Ext(basename))
-		// parse the configuration
-		viper.SetConfigName(file)
-		viper.AddConfigPath(directory)
+		format, err := configFileFormat(configFile)
+		if err != nil {
+			return config, err
+		}
+		if format != "" {
+			// format is detected by file extension
+			viper.SetConfigFile(configFile)
+			viper.SetConfigType(format)
+		} else {
+			// we need to separate the directory name and filename,
+			// all supported extensions are tried then
+			directory, file := filepath.Split(configFile)
+			// parse the configuration
+			viper.SetConfigName(file)
+			viper.AddConfigPath(directory)
+		}
 	} else {
 		log.Info().Str(filenameAttribute, defaultConfigFile).Msg(parsingConfigurationFileMessage)
 		// parse the configuration
@@ -186,7 +714,7 @@ func LoadConfiguration(configFileEnvVariableName, defaultConfigFile string) (Con
 
 	// updated configuration by introducing Clowder-related things
 	if err := updateConfigFromClowder(&config); err != nil {
-		fmt.Println("Error loading clowder configuration")
+		fmt.Fprintln(os.Stderr, "Error loading clowder configuration")
 		return config, err
 	}
 	return config, err
@@ -202,6 +730,11 @@ func GetLoggingConfiguration(config *ConfigStruct) logger.LoggingConfiguration {
 	return config.Logging
 }
 
+// GetLogSamplingConfiguration returns configuration of log sampling
+func GetLogSamplingConfiguration(config *ConfigStruct) LogSamplingConfiguration {
+	return config.LogSampling
+}
+
 // GetSentryConfiguration function returns sentry configuration
 func GetSentryConfiguration(config *ConfigStruct) logger.SentryLoggingConfiguration {
 	return config.Sentry
@@ -212,28 +745,162 @@ func GetCleanerConfiguration(config *ConfigStruct) CleanerConfiguration {
 	return config.Cleaner
 }
 
+// GetSessionSettingsConfiguration returns session settings configuration
+func GetSessionSettingsConfiguration(config *ConfigStruct) SessionSettingsConfiguration {
+	return config.Session
+}
+
+// GetMetricsConfiguration returns metrics configuration
+func GetMetricsConfiguration(config *ConfigStruct) MetricsConfiguration {
+	return config.Metrics
+}
+
+// GetEvidenceConfiguration returns deletion evidence configuration
+func GetEvidenceConfiguration(config *ConfigStruct) EvidenceConfiguration {
+	return config.Evidence
+}
+
+// GetRepackConfiguration returns pg_repack integration configuration
+func GetRepackConfiguration(config *ConfigStruct) RepackConfiguration {
+	return config.Repack
+}
+
+// GetClusterListConfiguration returns configuration of cluster list source
+func GetClusterListConfiguration(config *ConfigStruct) ClusterListConfiguration {
+	return config.ClusterList
+}
+
+// GetHistoryConfiguration returns run history configuration
+func GetHistoryConfiguration(config *ConfigStruct) HistoryConfiguration {
+	return config.History
+}
+
+// GetWatchConfiguration returns configuration of watch mode
+func GetWatchConfiguration(config *ConfigStruct) WatchConfiguration {
+	return config.Watch
+}
+
+// GetMaintenanceWindowConfiguration returns maintenance window configuration
+func GetMaintenanceWindowConfiguration(config *ConfigStruct) MaintenanceWindowConfiguration {
+	return config.Window
+}
+
+// GetAuthorizationConfiguration returns authorization configuration
+func GetAuthorizationConfiguration(config *ConfigStruct) AuthorizationConfiguration {
+	return config.Authorization
+}
+
+// GetInventoryConfiguration returns inventory API configuration
+func GetInventoryConfiguration(config *ConfigStruct) InventoryConfiguration {
+	return config.Inventory
+}
+
+// GetAggregatorConfiguration returns configuration of aggregator endpoint
+func GetAggregatorConfiguration(config *ConfigStruct) AggregatorConfiguration {
+	return config.Aggregator
+}
+
+// GetSchemasConfiguration returns configuration of plug-in DB schemas
+func GetSchemasConfiguration(config *ConfigStruct) []SchemaConfiguration {
+	return config.Schemas
+}
+
+// GetTargetsConfiguration returns configuration of all storage targets
+func GetTargetsConfiguration(config *ConfigStruct) []StorageConfiguration {
+	return config.Targets
+}
+
+// GetOutputConfiguration returns output configuration
+func GetOutputConfiguration(config *ConfigStruct) OutputConfiguration {
+	return config.Output
+}
+
 // updateConfigFromClowder function updates the current config with the values
 // defined in clowder
 func updateConfigFromClowder(c *ConfigStruct) error {
 	if !clowder.IsClowderEnabled() || clowder.LoadedConfig == nil {
 		// can not use Zerolog at this moment!
-		// we have to use standard output
-		fmt.Println("Clowder is disabled")
+		// we have to use standard error output as standard output
+		// might be used to export records
+		fmt.Fprintln(os.Stderr, "Clowder is disabled")
 		return nil
 	}
 
-	fmt.Println("Clowder is enabled")
+	fmt.Fprintln(os.Stderr, "Clowder is enabled")
 
 	// get DB configuration from clowder
-	c.Storage.PGDBName = clowder.LoadedConfig.Database.Name
-	c.Storage.PGHost = clowder.LoadedConfig.Database.Hostname
-	c.Storage.PGPort = clowder.LoadedConfig.Database.Port
-	c.Storage.PGUsername = clowder.LoadedConfig.Database.Username
-	c.Storage.PGPassword = clowder.LoadedConfig.Database.Password
+	overridden, err := applyClowderDatabaseConfiguration(&c.Storage, clowder.LoadedConfig.Database)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Storage options overridden by Clowder:", strings.Join(overridden, ", "))
 
 	return nil
 }
 
+// applyClowderDatabaseConfiguration function validates database
+// configuration provided by Clowder and copies it into storage
+// configuration. Database name and credentials are copied only when they are
+// provided. When RDS CA is provided, it is written into temporary file that
+// is used as SSL root certificate. Names of overridden storage options are
+// returned.
+func applyClowderDatabaseConfiguration(storageCfg *StorageConfiguration, dbCfg *clowder.DatabaseConfig) (
+	[]string, error) {
+	if dbCfg == nil {
+		return nil, errors.New("database configuration is not provided by Clowder")
+	}
+	if dbCfg.Hostname == "" {
+		return nil, errors.New("database host name provided by Clowder is empty")
+	}
+	if dbCfg.Port <= 0 || dbCfg.Port > 65535 {
+		return nil, fmt.Errorf("database port provided by Clowder is not valid: %d", dbCfg.Port)
+	}
+
+	storageCfg.PGHost = dbCfg.Hostname
+	storageCfg.PGPort = dbCfg.Port
+	overridden := []string{"pg_host", "pg_port"}
+
+	if dbCfg.Name != "" {
+		storageCfg.PGDBName = dbCfg.Name
+		overridden = append(overridden, "pg_db_name")
+	}
+	if dbCfg.Username != "" {
+		storageCfg.PGUsername = dbCfg.Username
+		overridden = append(overridden, "pg_username")
+	}
+	if dbCfg.Password != "" {
+		storageCfg.PGPassword = dbCfg.Password
+		overridden = append(overridden, "pg_password")
+	}
+
+	// TLS-required managed databases need SSL mode and CA to be set
+	if dbCfg.SslMode == "" && dbCfg.RdsCa == nil {
+		return overridden, nil
+	}
+	params, err := url.ParseQuery(storageCfg.PGParams)
+	if err != nil {
+		return overridden, fmt.Errorf("improper pg_params: %w", err)
+	}
+	if dbCfg.SslMode != "" {
+		params.Set("sslmode", dbCfg.SslMode)
+	}
+	if dbCfg.RdsCa != nil {
+		rdsCaFile, err := clowder.AppConfig{Database: dbCfg}.RdsCa()
+		if err != nil {
+			return overridden, fmt.Errorf("unable to write RDS CA provided by Clowder: %w", err)
+		}
+		params.Set("sslrootcert", rdsCaFile)
+		// CA is not used to verify server certificate in weaker modes
+		if dbCfg.SslMode == "" {
+			params.Set("sslmode", "verify-full")
+		}
+	}
+	storageCfg.PGParams = params.Encode()
+	overridden = append(overridden, "pg_params")
+
+	return overridden, nil
+}
+
 // StringSet type is a poor man's implementation of set of strings
 type StringSet map[string]struct{}
 
@@ -255,33 +922,307 @@ func allSupportedSchemas() StringSet {
 	return schemas
 }
 
+// allSupportedAgeUnits constructs set with names of all units that can be
+// used to express age of records
+func allSupportedAgeUnits() StringSet {
+	var units = make(StringSet)
+	units[""] = struct{}{}
+	units[AgeUnitDays] = struct{}{}
+	units[AgeUnitHours] = struct{}{}
+	units[AgeUnitDuration] = struct{}{}
+	return units
+}
+
 // CheckConfiguration function checks if loaded configuration contains expected
 // items
 func CheckConfiguration(config *ConfigStruct) error {
 	drivers := allSupportedDrivers()
 	schemas := allSupportedSchemas()
+	ageUnits := allSupportedAgeUnits()
+
+	// plug-in schemas can be selected as well
+	pluginSchemasCfg := GetSchemasConfiguration(config)
+	err := checkPluginSchemas(pluginSchemasCfg)
+	if err != nil {
+		return invalidConfiguration("schemas", err)
+	}
+	for _, pluginSchema := range pluginSchemasCfg {
+		schemas[pluginSchema.Name] = struct{}{}
+	}
+
+	// operation is performed against storage targets when they are
+	// declared, otherwise against the storage itself
+	targetsCfg := GetTargetsConfiguration(config)
+	if len(targetsCfg) == 0 {
+		err = checkStorageConfiguration("storage", GetStorageConfiguration(config), drivers, schemas)
+		if err != nil {
+			return err
+		}
+	}
+
+	targetNames := make(StringSet)
+	for _, target := range targetsCfg {
+		if target.Name == "" {
+			return invalidConfiguration("targets.name",
+				fmt.Errorf("Storage target name is not specified in configuration"))
+		}
+		if _, found := targetNames[target.Name]; found {
+			return invalidConfiguration("targets.name",
+				fmt.Errorf("Storage target declared more than once: %s", target.Name))
+		}
+		targetNames[target.Name] = struct{}{}
+
+		err = checkStorageConfiguration("targets", target, drivers, schemas)
+		if err != nil {
+			return fmt.Errorf("%w (storage target %s)", err, target.Name)
+		}
+	}
+
+	err = checkOCPTableSchema(GetCleanerConfiguration(config).OCPTableSchema)
+	if err != nil {
+		return invalidConfiguration("cleaner.ocp_table_schema", err)
+	}
+
+	slowStatementThreshold := GetCleanerConfiguration(config).SlowStatementThreshold
+	if slowStatementThreshold != "" {
+		threshold, err := time.ParseDuration(slowStatementThreshold)
+		if err != nil || threshold < 0 {
+			return invalidConfiguration("cleaner.slow_statement_threshold",
+				fmt.Errorf("Incorrect slow statement threshold found in configuration: %s", slowStatementThreshold))
+		}
+	}
+
+	analyzeThreshold := GetCleanerConfiguration(config).AnalyzeThreshold
+	if analyzeThreshold < 0 || analyzeThreshold > 1 {
+		return invalidConfiguration("cleaner.analyze_threshold",
+			fmt.Errorf("Incorrect analyze threshold found in configuration: %g", analyzeThreshold))
+	}
+
+	rowEvents := GetLogSamplingConfiguration(config).RowEvents
+	if rowEvents < 0 {
+		return invalidConfiguration("log_sampling.row_events",
+			fmt.Errorf("Negative log sampling found in configuration: %d", rowEvents))
+	}
+
+	ageUnit := GetOutputConfiguration(config).AgeUnit
+	_, found := ageUnits[ageUnit]
+	if !found {
+		return invalidConfiguration("output.age_unit",
+			fmt.Errorf("Incorrect age unit found in configuration: %s", ageUnit))
+	}
+
+	err = checkTimeFormat(GetOutputConfiguration(config).TimeFormat)
+	if err != nil {
+		return invalidConfiguration("output.time_format", err)
+	}
+
+	err = checkOutputFormat(GetOutputConfiguration(config).Format)
+	if err != nil {
+		return invalidConfiguration("output.format", err)
+	}
+
+	evidenceCfg := GetEvidenceConfiguration(config)
+	if evidenceCfg.File != "" && evidenceCfg.PrivateKey == "" {
+		return invalidConfiguration("evidence.private_key",
+			fmt.Errorf("Private key to sign deletion evidence is not specified in configuration"))
+	}
+
+	err = checkInventoryConfiguration(GetInventoryConfiguration(config))
+	if err != nil {
+		return err
+	}
+
+	aggregatorCfg := GetAggregatorConfiguration(config)
+	if aggregatorCfg.URL != "" && aggregatorCfg.Timeout != "" {
+		_, err := time.ParseDuration(aggregatorCfg.Timeout)
+		if err != nil {
+			return invalidConfiguration("aggregator.timeout",
+				fmt.Errorf("Incorrect aggregator timeout found in configuration: %w", err))
+		}
+	}
+
+	err = checkClusterListConfiguration(GetClusterListConfiguration(config))
+	if err != nil {
+		return err
+	}
+
+	// standard input can be read just once, so the cluster list would be
+	// read by the first storage target only
+	if GetClusterListConfiguration(config).Provider == ClusterListProviderStdin && len(targetsCfg) > 1 {
+		return invalidConfiguration("cluster_list.provider",
+			fmt.Errorf("Cluster list can not be read from standard input for multiple storage targets"))
+	}
+
+	historyTable := GetHistoryConfiguration(config).Table
+	if historyTable != "" && !schemaNamePattern.MatchString(historyTable) {
+		return invalidConfiguration("history.table",
+			fmt.Errorf("Incorrect history table name found in configuration: %s", historyTable))
+	}
+
+	err = checkSessionSettingsConfiguration(GetSessionSettingsConfiguration(config))
+	if err != nil {
+		return err
+	}
+
+	err = checkWatchConfiguration(GetWatchConfiguration(config))
+	if err != nil {
+		return err
+	}
+
+	_, err = parseMaintenanceWindow(GetMaintenanceWindowConfiguration(config))
+	if err != nil {
+		return invalidConfiguration("maintenance_window", err)
+	}
+
+	err = checkAuthorizationConfiguration(GetAuthorizationConfiguration(config))
+	if err != nil {
+		return invalidConfiguration("authorization", err)
+	}
+
+	if GetRepackConfiguration(config).WaitTimeout < 0 {
+		return invalidConfiguration("repack.wait_timeout",
+			fmt.Errorf("Incorrect pg_repack wait timeout found in configuration: %d",
+				GetRepackConfiguration(config).WaitTimeout))
+	}
+
+	return nil
+}
+
+// checkInventoryConfiguration function checks if inventory API is configured
+// properly. Nothing is checked when inventory API is not used.
+func checkInventoryConfiguration(inventoryCfg InventoryConfiguration) error {
+	if inventoryCfg.URL == "" {
+		return nil
+	}
+
+	if strings.TrimSpace(inventoryCfg.MinAge) == "" {
+		return invalidConfiguration("inventory.min_age",
+			fmt.Errorf("Min age of inactive clusters is not specified in inventory configuration"))
+	}
+	_, err := parseAge(inventoryCfg.MinAge)
+	if err != nil {
+		return invalidConfiguration("inventory.min_age",
+			fmt.Errorf("Incorrect min age found in inventory configuration: %w", err))
+	}
 
-	storageCfg := GetStorageConfiguration(config)
+	if inventoryCfg.Timeout != "" {
+		_, err := time.ParseDuration(inventoryCfg.Timeout)
+		if err != nil {
+			return invalidConfiguration("inventory.timeout",
+				fmt.Errorf("Incorrect inventory API timeout found in configuration: %w", err))
+		}
+	}
+	return nil
+}
+
+// checkStorageConfiguration function checks if database driver and schema
+// are specified and supported. Keys of offending options are prefixed by
+// name of configuration section.
+func checkStorageConfiguration(section string, storageCfg StorageConfiguration, drivers, schemas StringSet) error {
 	driver := storageCfg.Driver
 	schema := storageCfg.Schema
 
 	if driver == "" {
-		return fmt.Errorf("Database driver is not specified in configuration")
+		return invalidConfiguration(section+".db_driver",
+			fmt.Errorf("Database driver is not specified in configuration"))
 	}
 
 	if schema == "" {
-		return fmt.Errorf("Database schema is not specified in configuration")
+		return invalidConfiguration(section+".schema",
+			fmt.Errorf("Database schema is not specified in configuration"))
 	}
 
 	_, found := drivers[driver]
 	if !found {
-		return fmt.Errorf("Incorrect database driver found in configuration: %s", driver)
+		return invalidConfiguration(section+".db_driver",
+			fmt.Errorf("Incorrect database driver found in configuration: %s", driver))
 	}
 
 	_, found = schemas[schema]
 	if !found {
-		return fmt.Errorf("Incorrect database schema found in configuration: %s", schema)
+		return invalidConfiguration(section+".schema",
+			fmt.Errorf("Incorrect database schema found in configuration: %s", schema))
+	}
+
+	if storageCfg.PingRetries < 0 {
+		return invalidConfiguration(section+".ping_retries",
+			fmt.Errorf("Number of ping retries can not be negative: %d", storageCfg.PingRetries))
+	}
+
+	if storageCfg.PingBackoff != "" {
+		_, err := time.ParseDuration(storageCfg.PingBackoff)
+		if err != nil {
+			return invalidConfiguration(section+".ping_backoff",
+				fmt.Errorf("Incorrect ping backoff found in configuration: %s", storageCfg.PingBackoff))
+		}
+	}
+
+	// SQLite does not provide name of current database
+	if storageCfg.Fingerprint != "" && driver == "sqlite3" && strings.TrimSpace(storageCfg.FingerprintQuery) == "" {
+		return invalidConfiguration(section+".fingerprint_query",
+			fmt.Errorf("Query to read database fingerprint needs to be specified for sqlite3 driver"))
+	}
+
+	if err := checkMigrationCheckMode(storageCfg.MigrationCheck); err != nil {
+		return invalidConfiguration(section+".migration_check", err)
 	}
 
 	return nil
 }
+
+// checkWatchConfiguration function checks if poll interval and pattern of
+// request file names used by watch mode are correct
+func checkWatchConfiguration(watchCfg WatchConfiguration) error {
+	if watchCfg.PollInterval != "" {
+		interval, err := time.ParseDuration(watchCfg.PollInterval)
+		if err == nil && interval <= 0 {
+			err = fmt.Errorf("poll interval needs to be positive: %s", watchCfg.PollInterval)
+		}
+		if err != nil {
+			return invalidConfiguration("watch.poll_interval", err)
+		}
+	}
+
+	if _, err := filepath.Match(watchCfg.Pattern, ""); err != nil {
+		return invalidConfiguration("watch.pattern", err)
+	}
+	return nil
+}
+
+// checkClusterListConfiguration function checks if cluster list provider is
+// known and configured properly
+func checkClusterListConfiguration(clusterListCfg ClusterListConfiguration) error {
+	if clusterListCfg.ChunkSize < 0 {
+		return invalidConfiguration("cluster_list.chunk_size",
+			fmt.Errorf("Negative chunk size found in configuration: %d", clusterListCfg.ChunkSize))
+	}
+
+	switch clusterListCfg.Provider {
+	case "", ClusterListProviderFile, ClusterListProviderStdin,
+		ClusterListProviderInventory, ClusterListProviderAggregator:
+		return nil
+	case ClusterListProviderSQL:
+		if strings.TrimSpace(clusterListCfg.Query) == "" {
+			return invalidConfiguration("cluster_list.query",
+				fmt.Errorf("Query to read cluster list is not specified in configuration"))
+		}
+		return nil
+	case ClusterListProviderKafka:
+		kafkaCfg := clusterListCfg.Kafka
+		if len(kafkaCfg.Brokers) == 0 || kafkaCfg.Topic == "" {
+			return invalidConfiguration("cluster_list.kafka",
+				fmt.Errorf("Kafka brokers or topic with cluster list are not specified in configuration"))
+		}
+		if kafkaCfg.Timeout != "" {
+			_, err := time.ParseDuration(kafkaCfg.Timeout)
+			if err != nil {
+				return invalidConfiguration("cluster_list.kafka.timeout",
+					fmt.Errorf("Incorrect Kafka timeout found in configuration: %w", err))
+			}
+		}
+		return nil
+	default:
+		return invalidConfiguration("cluster_list.provider",
+			fmt.Errorf("Unknown cluster list provider found in configuration: %s", clusterListCfg.Provider))
+	}
+}
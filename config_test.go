@@ -20,7 +20,9 @@ package main_test
 // https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/config_test.html
 
 import (
+	"net/url"
 	"os"
+	"path/filepath"
 
 	"testing"
 
@@ -83,6 +85,29 @@ func TestLoadConfigurationBadConfigFile(t *testing.T) {
 	assert.Contains(t, err.Error(), `fatal error config file: While parsing config:`)
 }
 
+// TestLoadConfigurationFromCommandLine tests that configuration file
+// specified on command line takes precedence over environment variable
+func TestLoadConfigurationFromCommandLine(t *testing.T) {
+	os.Clearenv()
+
+	mustSetEnv(t, "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "non existing file")
+	err := main.SelectConfigurationFile("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "tests/config2")
+	assert.NoError(t, err)
+	assert.Equal(t, "tests/config2", os.Getenv("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"))
+	mustLoadConfiguration("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE")
+
+	// nothing is changed when no file is specified on command line
+	err = main.SelectConfigurationFile("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "tests/config2", os.Getenv("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"))
+
+	// configuration file specified on command line must exist
+	err = main.SelectConfigurationFile("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "non existing file")
+	assert.NoError(t, err)
+	_, err = main.LoadConfiguration("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "tests/config1")
+	assert.Error(t, err)
+}
+
 // TestLoadingConfigurationEnvVariableBadValueNoDefaultConfig tests loading a
 // non-existent configuration file set in environment
 func TestLoadingConfigurationEnvVariableBadValueNoDefaultConfig(t *testing.T) {
@@ -105,6 +130,36 @@ func TestLoadingConfigurationEnvVariableBadValueDefaultConfigFailure(t *testing.
 	assert.Contains(t, err.Error(), `fatal error config file: Config File "non existing file" Not Found in`)
 }
 
+// TestLoadConfigurationInOtherFormats tests that configuration files in YAML
+// and JSON formats are loaded the same way as TOML file
+func TestLoadConfigurationInOtherFormats(t *testing.T) {
+	os.Clearenv()
+	envVar := "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"
+
+	mustSetEnv(t, envVar, "tests/config2.toml")
+	expected, err := main.LoadConfiguration(envVar, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite3", expected.Storage.Driver)
+
+	for _, configFile := range []string{"tests/config5.yaml", "tests/config6.json"} {
+		mustSetEnv(t, envVar, configFile)
+		config, err := main.LoadConfiguration(envVar, "")
+		assert.NoError(t, err, configFile)
+		assert.Equal(t, expected, config, configFile)
+	}
+}
+
+// TestLoadConfigurationUnsupportedFormat tests that configuration file with
+// unknown extension is refused
+func TestLoadConfigurationUnsupportedFormat(t *testing.T) {
+	os.Clearenv()
+	envVar := "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"
+
+	mustSetEnv(t, envVar, "tests/cluster_list.txt")
+	_, err := main.LoadConfiguration(envVar, "")
+	assert.ErrorContains(t, err, "unsupported format of configuration file")
+}
+
 // TestLoadCleanerConfiguration tests loading the cleaner configuration
 // sub-tree
 func TestLoadCleanerConfiguration(t *testing.T) {
@@ -163,7 +218,9 @@ func TestLoadConfigurationFromEnvVariableClowderEnabled(t *testing.T) {
 
 	clowder.LoadedConfig = &clowder.AppConfig{
 		Database: &clowder.DatabaseConfig{
-			Name: testDB,
+			Name:     testDB,
+			Hostname: "db.example.com",
+			Port:     5433,
 		},
 	}
 	mustSetEnv(t, "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "tests/config2")
@@ -174,6 +231,94 @@ func TestLoadConfigurationFromEnvVariableClowderEnabled(t *testing.T) {
 	// check loaded configuration
 	dbCfg := main.GetStorageConfiguration(&config)
 	assert.Equal(t, testDB, dbCfg.PGDBName)
+	assert.Equal(t, "db.example.com", dbCfg.PGHost)
+	assert.Equal(t, 5433, dbCfg.PGPort)
+	// credentials not provided by Clowder are not overridden
+	assert.Equal(t, "user", dbCfg.PGUsername)
+	assert.Equal(t, "password", dbCfg.PGPassword)
+
+	// improper database configuration is refused
+	t.Cleanup(func() {
+		clowder.LoadedConfig = nil
+	})
+	clowder.LoadedConfig.Database.Hostname = ""
+	_, err = main.LoadConfiguration("INSIGHTS_RESULTS_CLEANER_CONFIG_FILE", "tests/config1")
+	assert.Error(t, err)
+}
+
+// TestApplyClowderDatabaseConfiguration tests validation of database
+// configuration provided by Clowder
+func TestApplyClowderDatabaseConfiguration(t *testing.T) {
+	storageCfg := main.StorageConfiguration{PGHost: "localhost", PGPort: 5432}
+
+	_, err := main.ApplyClowderDatabaseConfiguration(&storageCfg, nil)
+	assert.Error(t, err)
+
+	for _, dbCfg := range []clowder.DatabaseConfig{
+		{Hostname: "", Port: 5432},
+		{Hostname: "db.example.com", Port: 0},
+		{Hostname: "db.example.com", Port: 70000},
+	} {
+		_, err = main.ApplyClowderDatabaseConfiguration(&storageCfg, &dbCfg)
+		assert.Error(t, err, dbCfg)
+	}
+	// nothing is overridden by improper configuration
+	assert.Equal(t, main.StorageConfiguration{PGHost: "localhost", PGPort: 5432}, storageCfg)
+
+	overridden, err := main.ApplyClowderDatabaseConfiguration(&storageCfg, &clowder.DatabaseConfig{
+		Hostname: "db.example.com",
+		Port:     5433,
+		Name:     "aggregator",
+		Username: "cleaner",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pg_host", "pg_port", "pg_db_name", "pg_username", "pg_password"}, overridden)
+	assert.Equal(t, main.StorageConfiguration{
+		PGHost:     "db.example.com",
+		PGPort:     5433,
+		PGDBName:   "aggregator",
+		PGUsername: "cleaner",
+		PGPassword: "secret",
+	}, storageCfg)
+}
+
+// TestApplyClowderDatabaseConfigurationRdsCa tests that RDS CA provided by
+// Clowder is used as SSL root certificate
+func TestApplyClowderDatabaseConfigurationRdsCa(t *testing.T) {
+	rdsCa := "-----BEGIN CERTIFICATE-----\nRDS CA\n-----END CERTIFICATE-----\n"
+	storageCfg := main.StorageConfiguration{PGParams: "connect_timeout=10"}
+
+	overridden, err := main.ApplyClowderDatabaseConfiguration(&storageCfg, &clowder.DatabaseConfig{
+		Hostname: "db.example.com",
+		Port:     5432,
+		RdsCa:    &rdsCa,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pg_host", "pg_port", "pg_params"}, overridden)
+
+	params, err := url.ParseQuery(storageCfg.PGParams)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", params.Get("connect_timeout"))
+	assert.Equal(t, "verify-full", params.Get("sslmode"))
+
+	// CA needs to be written into file
+	rdsCaFile := params.Get("sslrootcert")
+	defer os.RemoveAll(filepath.Dir(rdsCaFile))
+	content, err := os.ReadFile(rdsCaFile)
+	assert.NoError(t, err)
+	assert.Equal(t, rdsCa, string(content))
+
+	// SSL mode provided by Clowder is used as is
+	_, err = main.ApplyClowderDatabaseConfiguration(&storageCfg, &clowder.DatabaseConfig{
+		Hostname: "db.example.com",
+		Port:     5432,
+		SslMode:  "require",
+	})
+	assert.NoError(t, err)
+	params, err = url.ParseQuery(storageCfg.PGParams)
+	assert.NoError(t, err)
+	assert.Equal(t, "require", params.Get("sslmode"))
 }
 
 // TestCheckConfigurationEmptyConfig tests the function to check loaded configuration
@@ -241,4 +386,285 @@ func TestCheckConfigurationNegativeTestCases(t *testing.T) {
 	}
 	err = main.CheckConfiguration(&config4)
 	assert.Error(t, err, "Error should be thrown for empty/missing database schema")
+
+	config5 := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Output: main.OutputConfiguration{
+			AgeUnit: "fortnights",
+		},
+	}
+	err = main.CheckConfiguration(&config5)
+	assert.Error(t, err, "Error should be thrown for unknown age unit")
+
+	config5.Output = main.OutputConfiguration{
+		Format: "xml",
+	}
+	err = main.CheckConfiguration(&config5)
+	assert.Error(t, err, "Error should be thrown for unknown output format")
+
+	config5.Output = main.OutputConfiguration{
+		TimeFormat: "RFC3339x",
+	}
+	err = main.CheckConfiguration(&config5)
+	assert.Error(t, err, "Error should be thrown for incorrect time format")
+
+	config6 := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Evidence: main.EvidenceConfiguration{
+			File: "evidence.json",
+		},
+	}
+	err = main.CheckConfiguration(&config6)
+	assert.Error(t, err, "Error should be thrown for missing private key")
+
+	config7 := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Repack: main.RepackConfiguration{
+			Enabled:     true,
+			WaitTimeout: -1,
+		},
+	}
+	err = main.CheckConfiguration(&config7)
+	assert.Error(t, err, "Error should be thrown for negative pg_repack wait timeout")
+
+	config8 := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		History: main.HistoryConfiguration{
+			Table: "run history; DROP TABLE report",
+		},
+	}
+	err = main.CheckConfiguration(&config8)
+	assert.Error(t, err, "Error should be thrown for incorrect history table name")
+}
+
+// TestLoadSchemasConfiguration tests loading the plug-in schemas
+// configuration
+func TestLoadSchemasConfiguration(t *testing.T) {
+	envVar := "INSIGHTS_RESULTS_CLEANER_CONFIG_FILE"
+	mustSetEnv(t, envVar, "tests/config4")
+	config, err := main.LoadConfiguration(envVar, "")
+	assert.Nil(t, err, "Failed loading configuration file from env var!")
+
+	schemasCfg := main.GetSchemasConfiguration(&config)
+	assert.Len(t, schemasCfg, 1)
+	assert.Equal(t, "notifications", schemasCfg[0].Name)
+	assert.Equal(t, "public", schemasCfg[0].DatabaseSchema)
+	assert.Len(t, schemasCfg[0].Tables, 2)
+	assert.Equal(t, "reported", schemasCfg[0].Tables[0].TableName)
+	assert.Equal(t, "cluster", schemasCfg[0].Tables[0].KeyName)
+	assert.Equal(t, "org_id", schemasCfg[0].Tables[0].OrgKeyName)
+	assert.Equal(t, "read_errors", schemasCfg[0].Tables[1].TableName)
+
+	// plug-in schema can be selected in storage configuration
+	assert.NoError(t, main.CheckConfiguration(&config))
+}
+
+// TestCheckConfigurationWrongPluginSchema tests the function to check loaded
+// configuration with incorrect plug-in schema
+func TestCheckConfigurationWrongPluginSchema(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "postgres",
+			Schema: "notifications",
+		},
+		Schemas: []main.SchemaConfiguration{
+			{
+				Name: "notifications",
+			},
+		},
+	}
+	err := main.CheckConfiguration(&config)
+	assert.Error(t, err, "Error should be thrown for plug-in schema without tables")
+}
+
+// TestCheckConfigurationWrongPing tests the function to check loaded
+// configuration with wrong ping settings
+func TestCheckConfigurationWrongPing(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:      "postgres",
+			Schema:      "ocp_recommendations",
+			PingRetries: -1,
+		},
+	}
+	err := main.CheckConfiguration(&config)
+	assert.Error(t, err, "Error should be thrown for negative number of ping retries")
+
+	config.Storage.PingRetries = 3
+	config.Storage.PingBackoff = "one second"
+	err = main.CheckConfiguration(&config)
+	assert.Error(t, err, "Error should be thrown for incorrect ping backoff")
+
+	config.Storage.PingBackoff = "500ms"
+	err = main.CheckConfiguration(&config)
+	assert.NoError(t, err, "Ping settings should be accepted")
+}
+
+// TestCheckConfigurationWrongMigrationCheck tests the function to check
+// loaded configuration with wrong migration check mode
+func TestCheckConfigurationWrongMigrationCheck(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:         "postgres",
+			Schema:         "ocp_recommendations",
+			MigrationCheck: "ignore",
+		},
+	}
+	err := main.CheckConfiguration(&config)
+	var configErr *main.ErrInvalidConfiguration
+	assert.ErrorAs(t, err, &configErr)
+	assert.Equal(t, "storage.migration_check", configErr.Key)
+
+	for _, mode := range []string{"", main.MigrationCheckWarn, main.MigrationCheckRefuse} {
+		config.Storage.MigrationCheck = mode
+		err = main.CheckConfiguration(&config)
+		assert.NoError(t, err, "Migration check mode should be accepted")
+	}
+}
+
+// TestCheckConfigurationWrongOCPTableSchema tests the function to check
+// loaded configuration with schema where OCP tables are stored
+func TestCheckConfigurationWrongOCPTableSchema(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "postgres",
+			Schema: "ocp_recommendations",
+		},
+		Cleaner: main.CleanerConfiguration{
+			OCPTableSchema: "aggregator; DROP TABLE report",
+		},
+	}
+	err := main.CheckConfiguration(&config)
+	assert.Error(t, err, "Error should be thrown for incorrect OCP table schema")
+
+	config.Cleaner.OCPTableSchema = "aggregator"
+	err = main.CheckConfiguration(&config)
+	assert.NoError(t, err, "OCP table schema should be accepted")
+}
+
+// TestCheckConfigurationWrongSlowStatementThreshold tests the function to
+// check loaded configuration with wrong slow statement threshold
+func TestCheckConfigurationWrongSlowStatementThreshold(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "postgres",
+			Schema: "ocp_recommendations",
+		},
+	}
+	for _, threshold := range []string{"5", "five seconds", "-1s"} {
+		config.Cleaner.SlowStatementThreshold = threshold
+		err := main.CheckConfiguration(&config)
+		assert.Error(t, err, "Error should be thrown for incorrect slow statement threshold "+threshold)
+	}
+
+	config.Cleaner.SlowStatementThreshold = "500ms"
+	err := main.CheckConfiguration(&config)
+	assert.NoError(t, err, "Slow statement threshold should be accepted")
+}
+
+// TestCheckConfigurationWrongAnalyzeThreshold tests the function to check
+// loaded configuration with wrong analyze threshold
+func TestCheckConfigurationWrongAnalyzeThreshold(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "postgres",
+			Schema: "ocp_recommendations",
+		},
+	}
+	for _, threshold := range []float64{-0.1, 1.5} {
+		config.Cleaner.AnalyzeThreshold = threshold
+		err := main.CheckConfiguration(&config)
+		assert.Error(t, err, "Error should be thrown for incorrect analyze threshold")
+	}
+
+	config.Cleaner.AnalyzeThreshold = 0.25
+	err := main.CheckConfiguration(&config)
+	assert.NoError(t, err, "Analyze threshold should be accepted")
+}
+
+// TestCheckConfigurationWrongLogSampling tests the function to check
+// loaded configuration with negative log sampling
+func TestCheckConfigurationWrongLogSampling(t *testing.T) {
+	config := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "postgres",
+			Schema: "ocp_recommendations",
+		},
+		LogSampling: main.LogSamplingConfiguration{RowEvents: -1},
+	}
+	err := main.CheckConfiguration(&config)
+	var configErr *main.ErrInvalidConfiguration
+	assert.ErrorAs(t, err, &configErr)
+	assert.Equal(t, "log_sampling.row_events", configErr.Key)
+
+	config.LogSampling.RowEvents = 1000
+	err = main.CheckConfiguration(&config)
+	assert.NoError(t, err, "Log sampling should be accepted")
+}
+
+// TestCheckConfigurationTargets tests the function to check loaded
+// configuration with storage targets
+func TestCheckConfigurationTargets(t *testing.T) {
+	ocpTarget := main.StorageConfiguration{
+		Name:   "ocp",
+		Driver: "postgres",
+		Schema: "ocp_recommendations",
+	}
+	dvoTarget := main.StorageConfiguration{
+		Name:   "dvo",
+		Driver: "postgres",
+		Schema: "dvo_recommendations",
+	}
+
+	// storage section is not needed when targets are declared
+	config1 := main.ConfigStruct{
+		Targets: []main.StorageConfiguration{ocpTarget, dvoTarget},
+	}
+	err := main.CheckConfiguration(&config1)
+	assert.NoError(t, err, "Error should not be thrown")
+
+	config2 := main.ConfigStruct{
+		Targets: []main.StorageConfiguration{ocpTarget, ocpTarget},
+	}
+	err = main.CheckConfiguration(&config2)
+	assert.Error(t, err, "Error should be thrown for duplicate target")
+
+	config3 := main.ConfigStruct{
+		Targets: []main.StorageConfiguration{{Driver: "postgres", Schema: "ocp_recommendations"}},
+	}
+	err = main.CheckConfiguration(&config3)
+	assert.Error(t, err, "Error should be thrown for target without name")
+
+	config4 := main.ConfigStruct{
+		Targets: []main.StorageConfiguration{{Name: "ocp", Driver: "postgres", Schema: "unknown"}},
+	}
+	err = main.CheckConfiguration(&config4)
+	assert.Error(t, err, "Error should be thrown for unknown database schema")
+	assert.Contains(t, err.Error(), "storage target ocp")
+
+	// standard input can not be shared by more targets
+	config5 := main.ConfigStruct{
+		Targets: []main.StorageConfiguration{ocpTarget, dvoTarget},
+		ClusterList: main.ClusterListConfiguration{
+			Provider: main.ClusterListProviderStdin,
+		},
+	}
+	err = main.CheckConfiguration(&config5)
+	assert.Error(t, err, "Error should be thrown for stdin provider with multiple targets")
+
+	config5.Targets = []main.StorageConfiguration{ocpTarget}
+	err = main.CheckConfiguration(&config5)
+	assert.NoError(t, err, "Error should not be thrown for stdin provider with one target")
 }
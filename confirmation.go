@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/confirmation.html
+
+// This source file contains implementation of explicit confirmation of
+// database and DB schema used by destructive operations. It prevents running
+// the right command against the wrong configuration file: name of database
+// and name of DB schema need to be passed by -database and -schema command
+// line options and they need to match storage configuration. The options are
+// required in safe mode only (enabled by -safe-mode or safe_mode option in
+// [cleaner] section), but when they are specified they are always checked.
+//
+// More comma separated names can be specified, it is useful when operation
+// is performed against more storage targets.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of command line options used to confirm destructive operation
+const (
+	databaseOption = "-database"
+	schemaOption   = "-schema"
+)
+
+// ErrNotConfirmed is returned when destructive operation is not confirmed
+// by name of database or DB schema, or when the confirmed name does not
+// match storage configuration
+type ErrNotConfirmed struct {
+	Option    string
+	Confirmed string
+	Actual    string
+}
+
+// Error method returns error message
+func (e *ErrNotConfirmed) Error() string {
+	if e.Confirmed == "" {
+		return fmt.Sprintf("destructive operation needs to be confirmed by %s '%s' in safe mode",
+			e.Option, e.Actual)
+	}
+	return fmt.Sprintf("%s '%s' does not match '%s' from configuration",
+		e.Option, e.Confirmed, e.Actual)
+}
+
+// resolvedDatabaseName function returns name of database used by storage
+func resolvedDatabaseName(storageCfg *StorageConfiguration) string {
+	if storageCfg.Driver == "sqlite3" {
+		return storageCfg.SQLiteDataSource
+	}
+	return storageCfg.PGDBName
+}
+
+// confirmedName function checks if name is one of comma separated names
+// used to confirm the operation
+func confirmedName(confirmed, name string) bool {
+	for _, item := range strings.Split(confirmed, ",") {
+		if strings.TrimSpace(item) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfirmation function checks if destructive operation is confirmed
+// by names of database and DB schema from storage configuration
+func checkConfirmation(storageCfg *StorageConfiguration, cliFlags CliFlags) error {
+	if !isDestructiveOperation(cliFlags) {
+		return nil
+	}
+
+	confirmations := []ErrNotConfirmed{
+		{Option: databaseOption, Confirmed: cliFlags.Database, Actual: resolvedDatabaseName(storageCfg)},
+		{Option: schemaOption, Confirmed: cliFlags.Schema, Actual: storageCfg.Schema},
+	}
+	for i := range confirmations {
+		confirmation := &confirmations[i]
+		if confirmation.Confirmed == "" && !cliFlags.SafeMode {
+			continue
+		}
+		if confirmation.Confirmed == "" || !confirmedName(confirmation.Confirmed, confirmation.Actual) {
+			return confirmation
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/confirmation_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// confirmedStorage is storage configuration used by confirmation tests
+var confirmedStorage = cleaner.StorageConfiguration{
+	Driver:   "postgres",
+	PGDBName: "aggregator",
+	Schema:   cleaner.DBSchemaOCPRecommendations,
+}
+
+// TestCheckConfirmationSafeMode checks that destructive operation needs to
+// be confirmed in safe mode
+func TestCheckConfirmationSafeMode(t *testing.T) {
+	var confirmationErr *cleaner.ErrNotConfirmed
+
+	// nothing is confirmed
+	err := cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, SafeMode: true})
+	assert.True(t, errors.As(err, &confirmationErr))
+	assert.Equal(t, "-database", confirmationErr.Option)
+	assert.Contains(t, err.Error(), "needs to be confirmed")
+
+	// schema is not confirmed
+	err = cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, SafeMode: true, Database: "aggregator"})
+	assert.True(t, errors.As(err, &confirmationErr))
+	assert.Equal(t, "-schema", confirmationErr.Option)
+
+	// both names are confirmed
+	assert.NoError(t, cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, SafeMode: true, Database: "aggregator",
+			Schema: cleaner.DBSchemaOCPRecommendations}))
+
+	// more names can be specified for more targets
+	assert.NoError(t, cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, SafeMode: true, Database: "other, aggregator",
+			Schema: cleaner.DBSchemaOCPRecommendations}))
+
+	// administrative operations modify database too
+	err = cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{InstallDBSchedule: "0 3 * * *", SafeMode: true})
+	assert.True(t, errors.As(err, &confirmationErr))
+	err = cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{ImportState: "state.json", SafeMode: true})
+	assert.True(t, errors.As(err, &confirmationErr))
+
+	// listing and dry runs do not need to be confirmed
+	assert.NoError(t, cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{SafeMode: true}))
+	assert.NoError(t, cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanupAll: true, DryRun: true, SafeMode: true}))
+}
+
+// TestCheckConfirmationMismatch checks that confirmed names are checked even
+// when safe mode is not enabled
+func TestCheckConfirmationMismatch(t *testing.T) {
+	var confirmationErr *cleaner.ErrNotConfirmed
+
+	// safe mode is not enabled
+	assert.NoError(t, cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true}))
+
+	err := cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, Database: "aggregator-stage"})
+	assert.True(t, errors.As(err, &confirmationErr))
+	assert.Equal(t, "-database 'aggregator-stage' does not match 'aggregator' from configuration", err.Error())
+
+	err = cleaner.CheckConfirmation(&confirmedStorage,
+		cleaner.CliFlags{PerformCleanup: true, Schema: cleaner.DBSchemaDVORecommendations})
+	assert.True(t, errors.As(err, &confirmationErr))
+	assert.Equal(t, "-schema", confirmationErr.Option)
+
+	// name of SQLite database is its data source
+	sqliteStorage := cleaner.StorageConfiguration{Driver: "sqlite3", SQLiteDataSource: "test.db"}
+	assert.NoError(t, cleaner.CheckConfirmation(&sqliteStorage,
+		cleaner.CliFlags{PerformCleanup: true, Database: "test.db"}))
+}
+
+// TestDoSelectedOperationNotConfirmed checks that destructive operation is
+// not started when it is not confirmed
+func TestDoSelectedOperationNotConfirmed(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: confirmedStorage,
+	}
+
+	status, err := cleaner.DoSelectedOperation(&configuration, nil,
+		cleaner.CliFlags{PerformCleanup: true, SafeMode: true})
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusNotConfirmed, status)
+}
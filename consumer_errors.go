@@ -0,0 +1,398 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/consumer_errors.html
+
+// This source file contains implementation of operations with consumer_error
+// table. Spread of Kafka offsets stored in the table is displayed for each
+// topic partition. Optionally, records with offsets lower than the low
+// watermark (ie. the earliest offset still retained by Kafka broker) are
+// deleted, because such messages can not be reprocessed anymore.
+//
+// Low watermarks are specified as comma separated list of topic:partition:offset
+// triples, for example:
+//
+// ccx.ocp.results:0:123456,ccx.ocp.results:1:123000
+//
+// They can be retrieved from the broker by kafka-get-offsets tool or by
+// kcat -Q.
+//
+// Consumer errors can also be exported for reprocessing (dead-letter queue
+// replay). Each record is exported as one JSON object with topic, partition,
+// offset, key, and message attributes. Exported records can be deleted
+// afterwards, but only when the export has been committed successfully.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Table with consumer errors and related messages
+const (
+	consumerErrorTable = "consumer_error"
+	topicMsg           = "topic"
+	partitionMsg       = "partition"
+	offsetMsg          = "offset"
+)
+
+// SQL statements used to work with consumer errors
+const (
+	selectConsumerErrorOffsets = `
+	    SELECT topic, partition, COUNT(*),
+	           MIN(topic_offset), MAX(topic_offset),
+	           MIN(consumed_at), MAX(consumed_at)
+	      FROM consumer_error
+	     GROUP BY topic, partition
+	     ORDER BY topic, partition`
+
+	countConsumerErrorsBeforeOffset = `
+	    SELECT COUNT(*)
+	      FROM consumer_error
+	     WHERE topic = $1 AND partition = $2 AND topic_offset < $3`
+
+	deleteConsumerErrorsBeforeOffset = `
+	    DELETE FROM consumer_error
+	     WHERE topic = $1 AND partition = $2 AND topic_offset < $3`
+
+	selectConsumerErrorsForReplay = `
+	    SELECT topic, partition, topic_offset, key, message
+	      FROM consumer_error
+	     ORDER BY topic, partition, topic_offset`
+
+	deleteConsumerError = `
+	    DELETE FROM consumer_error
+	     WHERE topic = $1 AND partition = $2 AND topic_offset = $3`
+)
+
+// ConsumerErrorOffsets represents spread of Kafka offsets of consumer errors
+// stored for one topic partition
+type ConsumerErrorOffsets struct {
+	Topic            string
+	Partition        int
+	Errors           int
+	MinOffset        int
+	MaxOffset        int
+	OldestConsumedAt time.Time
+	NewestConsumedAt time.Time
+}
+
+// KafkaLowWatermark represents the earliest offset still retained by Kafka
+// broker for one topic partition
+type KafkaLowWatermark struct {
+	Topic     string
+	Partition int
+	Offset    int
+}
+
+// ConsumerErrorMessage represents one message stored in consumer_error table
+// that can be replayed
+type ConsumerErrorMessage struct {
+	Topic     string
+	Partition int
+	Offset    int
+	Key       sql.NullString
+	Message   sql.NullString
+}
+
+// parseKafkaLowWatermarks function parses comma separated list of low
+// watermarks in topic:partition:offset format
+func parseKafkaLowWatermarks(input string) ([]KafkaLowWatermark, error) {
+	watermarks := []KafkaLowWatermark{}
+
+	for _, item := range strings.Split(input, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 || fields[0] == "" {
+			return nil, fmt.Errorf("low watermark must be specified as topic:partition:offset: '%s'", item)
+		}
+
+		partition, err := strconv.Atoi(fields[1])
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition in low watermark '%s'", item)
+		}
+
+		offset, err := strconv.Atoi(fields[2])
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset in low watermark '%s'", item)
+		}
+
+		watermarks = append(watermarks, KafkaLowWatermark{
+			Topic:     fields[0],
+			Partition: partition,
+			Offset:    offset,
+		})
+	}
+
+	return watermarks, nil
+}
+
+// readConsumerErrorOffsets function reads spread of Kafka offsets stored in
+// consumer_error table for each topic partition
+func readConsumerErrorOffsets(connection *sql.DB) ([]ConsumerErrorOffsets, error) {
+	offsets := []ConsumerErrorOffsets{}
+
+	err := queryRows(connection, selectConsumerErrorOffsets, nil, func(rows *sql.Rows) error {
+		var partitionOffsets ConsumerErrorOffsets
+
+		err := rows.Scan(&partitionOffsets.Topic, &partitionOffsets.Partition,
+			&partitionOffsets.Errors,
+			&partitionOffsets.MinOffset, &partitionOffsets.MaxOffset,
+			&partitionOffsets.OldestConsumedAt, &partitionOffsets.NewestConsumedAt)
+		if err != nil {
+			return err
+		}
+
+		offsets = append(offsets, partitionOffsets)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Read consumer error offsets")
+		return nil, queryFailed(consumerErrorTable, err)
+	}
+
+	return offsets, nil
+}
+
+// displayConsumerErrorOffsets function displays spread of Kafka offsets
+// stored in consumer_error table and exports it into selected output
+func displayConsumerErrorOffsets(connection *sql.DB, output string, outputConfig OutputConfiguration) (err error) {
+	offsets, err := readConsumerErrorOffsets(connection)
+	if err != nil {
+		return err
+	}
+
+	sink, err := createOutputSink(output, outputConfig)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// output is committed only when all records have been exported
+		err = errors.Join(err, closeOutputSink(sink, err == nil))
+	}()
+
+	for _, partitionOffsets := range offsets {
+		oldestConsumedF := formatTimestamp(partitionOffsets.OldestConsumedAt, outputConfig)
+		newestConsumedF := formatTimestamp(partitionOffsets.NewestConsumedAt, outputConfig)
+
+		// just print the offsets
+		log.Info().
+			Str(topicMsg, partitionOffsets.Topic).
+			Int(partitionMsg, partitionOffsets.Partition).
+			Int("errors count", partitionOffsets.Errors).
+			Int("min offset", partitionOffsets.MinOffset).
+			Int("max offset", partitionOffsets.MaxOffset).
+			Str("oldest consumed", oldestConsumedF).
+			Str("newest consumed", newestConsumedF).
+			Msg("Consumer error offsets")
+
+		// export to output (if enabled)
+		err = sink.WriteRecord(OutputRecord{
+			{"topic", partitionOffsets.Topic},
+			{"partition", partitionOffsets.Partition},
+			{"errors", partitionOffsets.Errors},
+			{"min_offset", partitionOffsets.MinOffset},
+			{"max_offset", partitionOffsets.MaxOffset},
+			{"oldest_consumed_at", oldestConsumedF},
+			{"newest_consumed_at", newestConsumedF},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg(writeToFileMsg)
+			return err
+		}
+	}
+
+	log.Info().Int("partitions count", len(offsets)).Msg("Consumer error offsets end")
+	return nil
+}
+
+// deleteConsumerErrorsBeforeWatermarks function deletes consumer errors with
+// offsets lower than low watermark of their topic partition. Records are just
+// counted in dry-run mode.
+func deleteConsumerErrorsBeforeWatermarks(connection *sql.DB, watermarks []KafkaLowWatermark, dryRun bool) (int, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return 0, ErrNoConnection
+	}
+
+	deleted := 0
+	for _, watermark := range watermarks {
+		var affected int
+
+		if dryRun {
+			err := queryRowStatement(connection, countConsumerErrorsBeforeOffset,
+				watermark.Topic, watermark.Partition, watermark.Offset).Scan(&affected)
+			if err != nil {
+				return deleted, queryFailed(consumerErrorTable, err)
+			}
+		} else {
+			result, err := execStatement(connection, deleteConsumerErrorsBeforeOffset,
+				watermark.Topic, watermark.Partition, watermark.Offset)
+			if err != nil {
+				return deleted, queryFailed(consumerErrorTable, err)
+			}
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return deleted, queryFailed(consumerErrorTable, err)
+			}
+			affected = int(rowsAffected)
+		}
+
+		log.Info().
+			Str(topicMsg, watermark.Topic).
+			Int(partitionMsg, watermark.Partition).
+			Int(offsetMsg, watermark.Offset).
+			Int(affectedMsg, affected).
+			Bool("Dry run", dryRun).
+			Msg("Delete consumer errors before low watermark")
+		deleted += affected
+	}
+
+	return deleted, nil
+}
+
+// nullableString function converts nullable string read from database into
+// value that is exported as JSON null when not set
+func nullableString(value sql.NullString) interface{} {
+	if !value.Valid {
+		return nil
+	}
+	return value.String
+}
+
+// exportConsumerErrorsForReplay function exports all consumer errors into
+// given output in JSON format accepted by replay tooling. Exported messages
+// are returned only when the output has been committed successfully.
+func exportConsumerErrorsForReplay(connection *sql.DB, output string, outputConfig OutputConfiguration) (exported []ConsumerErrorMessage, err error) {
+	if output == "" || outputConfig.Format == OutputFormatLog {
+		return nil, errors.New("output file needs to be specified to export consumer errors")
+	}
+
+	// replay tooling requires all attributes in JSON format
+	replayConfig := outputConfig
+	replayConfig.Format = OutputFormatJSON
+	replayConfig.Columns = ""
+	replayConfig.HashClusterIDs = false
+
+	sink, err := createOutputSink(output, replayConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(connection, selectConsumerErrorsForReplay, nil, func(rows *sql.Rows) error {
+		var message ConsumerErrorMessage
+
+		err := rows.Scan(&message.Topic, &message.Partition, &message.Offset,
+			&message.Key, &message.Message)
+		if err != nil {
+			return err
+		}
+
+		err = sink.WriteRecord(OutputRecord{
+			{"topic", message.Topic},
+			{"partition", message.Partition},
+			{"offset", message.Offset},
+			{"key", nullableString(message.Key)},
+			{"message", nullableString(message.Message)},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg(writeToFileMsg)
+			return err
+		}
+
+		exported = append(exported, message)
+		return nil
+	})
+	if err != nil {
+		// output is discarded, problems with removing it are just logged
+		_ = closeOutputSink(sink, false)
+		log.Error().Err(err).Msg("Export consumer errors")
+		return nil, queryFailed(consumerErrorTable, err)
+	}
+
+	// exported messages can be deleted only when the output is committed
+	err = sink.Close(true)
+	if err != nil {
+		log.Error().Err(err).Msg(closeOutputSinkMsg)
+		return nil, err
+	}
+
+	log.Info().Int("exported", len(exported)).Msg("Consumer errors exported for replay")
+	return exported, nil
+}
+
+// deleteExportedConsumerErrors function deletes exported consumer errors in
+// one transaction, so either all exported records are deleted or none of
+// them. Records are just counted in dry-run mode.
+func deleteExportedConsumerErrors(connection *sql.DB, exported []ConsumerErrorMessage, dryRun bool) (int, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return 0, ErrNoConnection
+	}
+
+	if dryRun || len(exported) == 0 {
+		log.Info().
+			Int(affectedMsg, len(exported)).
+			Bool("Dry run", dryRun).
+			Msg("Delete exported consumer errors")
+		return len(exported), nil
+	}
+
+	tx, err := connection.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, message := range exported {
+		result, err := execStatement(tx, deleteConsumerError, message.Topic, message.Partition, message.Offset)
+		if err == nil {
+			var affected int64
+			affected, err = result.RowsAffected()
+			deleted += int(affected)
+		}
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+			}
+			return 0, queryFailed(consumerErrorTable, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, queryFailed(consumerErrorTable, err)
+	}
+
+	log.Info().
+		Int(affectedMsg, deleted).
+		Bool("Dry run", dryRun).
+		Msg("Delete exported consumer errors")
+	return deleted, nil
+}
@@ -0,0 +1,503 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/consumer_errors_test.html
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// SQL statements expected by tests
+const (
+	expectedSelectConsumerErrorOffsets = "SELECT topic, partition, COUNT\\(\\*\\), MIN\\(topic_offset\\), MAX\\(topic_offset\\)"
+	expectedCountConsumerErrors        = "SELECT COUNT\\(\\*\\) FROM consumer_error WHERE topic = \\$1 AND partition = \\$2 AND topic_offset < \\$3"
+	expectedDeleteConsumerErrors       = "DELETE FROM consumer_error WHERE topic = \\$1 AND partition = \\$2 AND topic_offset < \\$3"
+)
+
+// consumerErrorOffsetsRows function prepares mocked result for query that
+// reads spread of offsets
+func consumerErrorOffsetsRows(consumedAt time.Time) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"topic", "partition", "count", "min", "max", "oldest", "newest"})
+	rows.AddRow("topic", 0, 10, 100, 200, consumedAt, consumedAt)
+	rows.AddRow("topic", 1, 5, 150, 180, consumedAt, consumedAt)
+	return rows
+}
+
+// TestParseKafkaLowWatermarks checks parsing of low watermarks
+func TestParseKafkaLowWatermarks(t *testing.T) {
+	watermarks, err := cleaner.ParseKafkaLowWatermarks(" ccx.ocp.results:0:123456, ccx.ocp.results:1:123000,")
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.KafkaLowWatermark{
+		{Topic: "ccx.ocp.results", Partition: 0, Offset: 123456},
+		{Topic: "ccx.ocp.results", Partition: 1, Offset: 123000},
+	}, watermarks)
+
+	watermarks, err = cleaner.ParseKafkaLowWatermarks("")
+	assert.NoError(t, err)
+	assert.Empty(t, watermarks)
+
+	for _, input := range []string{"topic", "topic:0", ":0:10", "topic:x:10", "topic:-1:10", "topic:0:x", "topic:0:-10", "topic:0:1:2"} {
+		_, err := cleaner.ParseKafkaLowWatermarks(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// TestReadConsumerErrorOffsets checks reading spread of offsets for each
+// topic partition
+func TestReadConsumerErrorOffsets(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	consumedAt := time.Now()
+	mock.ExpectQuery(expectedSelectConsumerErrorOffsets).WillReturnRows(consumerErrorOffsetsRows(consumedAt))
+	mock.ExpectClose()
+
+	// call the tested function
+	offsets, err := cleaner.ReadConsumerErrorOffsets(connection)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, []cleaner.ConsumerErrorOffsets{
+		{Topic: "topic", Partition: 0, Errors: 10, MinOffset: 100, MaxOffset: 200, OldestConsumedAt: consumedAt, NewestConsumedAt: consumedAt},
+		{Topic: "topic", Partition: 1, Errors: 5, MinOffset: 150, MaxOffset: 180, OldestConsumedAt: consumedAt, NewestConsumedAt: consumedAt},
+	}, offsets)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDisplayConsumerErrorOffsetsExport checks that spread of offsets is
+// exported into output
+func TestDisplayConsumerErrorOffsetsExport(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	consumedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(expectedSelectConsumerErrorOffsets).WillReturnRows(consumerErrorOffsetsRows(consumedAt))
+	mock.ExpectClose()
+
+	outputConfig := cleaner.OutputConfiguration{
+		Format:  cleaner.OutputFormatJSON,
+		Columns: "topic,partition,min_offset,max_offset",
+	}
+	outFile := t.TempDir() + "/offsets.json"
+	err = cleaner.DisplayConsumerErrorOffsets(connection, outFile, outputConfig)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	content, err := os.ReadFile(outFile)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"topic":"topic","partition":0,"min_offset":100,"max_offset":200}`+"\n"+
+			`{"topic":"topic","partition":1,"min_offset":150,"max_offset":180}`+"\n", string(content))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDisplayConsumerErrorOffsetsError checks that error returned by
+// database is propagated
+func TestDisplayConsumerErrorOffsetsError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+	mock.ExpectQuery(expectedSelectConsumerErrorOffsets).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	// call the tested function
+	err = cleaner.DisplayConsumerErrorOffsets(connection, "", cleaner.OutputConfiguration{})
+	assert.ErrorIs(t, err, mockedError)
+
+	var queryErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "consumer_error", queryErr.Table)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDeleteConsumerErrorsBeforeWatermarks checks that consumer errors with
+// offsets lower than low watermarks are deleted
+func TestDeleteConsumerErrorsBeforeWatermarks(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec(expectedDeleteConsumerErrors).WithArgs("topic", 0, 150).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(expectedDeleteConsumerErrors).WithArgs("topic", 1, 160).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectClose()
+
+	watermarks := []cleaner.KafkaLowWatermark{
+		{Topic: "topic", Partition: 0, Offset: 150},
+		{Topic: "topic", Partition: 1, Offset: 160},
+	}
+
+	// call the tested function
+	deleted, err := cleaner.DeleteConsumerErrorsBeforeWatermarks(connection, watermarks, false)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, 5, deleted)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDeleteConsumerErrorsBeforeWatermarksDryRun checks that consumer errors
+// are just counted in dry-run mode
+func TestDeleteConsumerErrorsBeforeWatermarksDryRun(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedCountConsumerErrors).WithArgs("topic", 0, 150).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectClose()
+
+	watermarks := []cleaner.KafkaLowWatermark{
+		{Topic: "topic", Partition: 0, Offset: 150},
+	}
+
+	// call the tested function
+	deleted, err := cleaner.DeleteConsumerErrorsBeforeWatermarks(connection, watermarks, true)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, 3, deleted)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDeleteConsumerErrorsBeforeWatermarksError checks that error returned
+// by database is propagated
+func TestDeleteConsumerErrorsBeforeWatermarksError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+	mock.ExpectExec(expectedDeleteConsumerErrors).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	watermarks := []cleaner.KafkaLowWatermark{
+		{Topic: "topic", Partition: 0, Offset: 150},
+	}
+
+	// call the tested function
+	_, err = cleaner.DeleteConsumerErrorsBeforeWatermarks(connection, watermarks, false)
+	assert.ErrorIs(t, err, mockedError)
+
+	// connection is required
+	_, err = cleaner.DeleteConsumerErrorsBeforeWatermarks(nil, watermarks, false)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckConsumerErrorOffsets checks the whole operation, including
+// cleanup of consumer errors and summary table
+func TestCheckConsumerErrorOffsets(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedSelectConsumerErrorOffsets).WillReturnRows(consumerErrorOffsetsRows(time.Now()))
+	mock.ExpectExec(expectedDeleteConsumerErrors).WithArgs("topic", 0, 150).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{}
+	cliFlags := cleaner.CliFlags{
+		ConsumerErrorOffsets: true,
+		KafkaLowWatermarks:   "topic:0:150",
+	}
+
+	// call the tested function
+	status, err := cleaner.CheckConsumerErrorOffsets(&configuration, connection, cliFlags, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckConsumerErrorOffsetsErrors checks error handling of the whole
+// operation
+func TestCheckConsumerErrorOffsetsErrors(t *testing.T) {
+	configuration := cleaner.ConfigStruct{}
+
+	// no connection
+	status, err := cleaner.CheckConsumerErrorOffsets(&configuration, nil, cleaner.CliFlags{}, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+	mock.ExpectClose()
+
+	// consumer errors are not stored in DVO database
+	status, err = cleaner.CheckConsumerErrorOffsets(&configuration, connection, cleaner.CliFlags{}, cleaner.DBSchemaDVORecommendations)
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &invalidSchemaErr)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// improper low watermarks
+	status, err = cleaner.CheckConsumerErrorOffsets(&configuration, connection,
+		cleaner.CliFlags{KafkaLowWatermarks: "topic"}, cleaner.DBSchemaOCPRecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// expectedSelectConsumerErrorsForReplay is SQL statement used to read
+// consumer errors that are exported for replay
+const expectedSelectConsumerErrorsForReplay = "SELECT topic, partition, topic_offset, key, message FROM consumer_error"
+
+// expectedDeleteConsumerError is SQL statement used to delete one exported
+// consumer error
+const expectedDeleteConsumerError = "DELETE FROM consumer_error WHERE topic = \\$1 AND partition = \\$2 AND topic_offset = \\$3"
+
+// consumerErrorsForReplayRows function prepares mocked result for query that
+// reads consumer errors to be exported
+func consumerErrorsForReplayRows() *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"topic", "partition", "topic_offset", "key", "message"})
+	rows.AddRow("topic", 0, 100, "key", `{"report":{}}`)
+	rows.AddRow("topic", 1, 150, nil, nil)
+	return rows
+}
+
+// TestExportConsumerErrorsForReplay checks that consumer errors are exported
+// in JSON format regardless of selected output format and columns
+func TestExportConsumerErrorsForReplay(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedSelectConsumerErrorsForReplay).WillReturnRows(consumerErrorsForReplayRows())
+	mock.ExpectClose()
+
+	outputConfig := cleaner.OutputConfiguration{
+		Format:  cleaner.OutputFormatCSV,
+		Columns: "topic",
+	}
+	outFile := t.TempDir() + "/replay.json"
+
+	// call the tested function
+	exported, err := cleaner.ExportConsumerErrorsForReplay(connection, outFile, outputConfig)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Len(t, exported, 2)
+	assert.Equal(t, "topic", exported[1].Topic)
+	assert.Equal(t, 1, exported[1].Partition)
+	assert.Equal(t, 150, exported[1].Offset)
+
+	content, err := os.ReadFile(outFile)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"topic":"topic","partition":0,"offset":100,"key":"key","message":"{\"report\":{}}"}`+"\n"+
+			`{"topic":"topic","partition":1,"offset":150,"key":null,"message":null}`+"\n", string(content))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestExportConsumerErrorsForReplayError checks that nothing is exported when
+// consumer errors can not be read
+func TestExportConsumerErrorsForReplayError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+	mock.ExpectQuery(expectedSelectConsumerErrorsForReplay).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	outFile := t.TempDir() + "/replay.json"
+
+	// output file is required
+	_, err = cleaner.ExportConsumerErrorsForReplay(connection, "", cleaner.OutputConfiguration{})
+	assert.Error(t, err)
+
+	// call the tested function
+	exported, err := cleaner.ExportConsumerErrorsForReplay(connection, outFile, cleaner.OutputConfiguration{})
+	assert.ErrorIs(t, err, mockedError)
+	assert.Empty(t, exported)
+
+	// partially written export must not be committed
+	assert.NoFileExists(t, outFile)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDeleteExportedConsumerErrors checks that exported consumer errors are
+// deleted in one transaction
+func TestDeleteExportedConsumerErrors(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 0, 100).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 1, 150).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	exported := []cleaner.ConsumerErrorMessage{
+		{Topic: "topic", Partition: 0, Offset: 100},
+		{Topic: "topic", Partition: 1, Offset: 150},
+	}
+
+	// nothing is deleted in dry-run mode
+	deleted, err := cleaner.DeleteExportedConsumerErrors(connection, exported, true)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, 2, deleted)
+
+	// call the tested function
+	deleted, err = cleaner.DeleteExportedConsumerErrors(connection, exported, false)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, 2, deleted)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDeleteExportedConsumerErrorsRollback checks that transaction is rolled
+// back when any exported consumer error can not be deleted
+func TestDeleteExportedConsumerErrorsRollback(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 0, 100).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 1, 150).
+		WillReturnError(mockedError)
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	exported := []cleaner.ConsumerErrorMessage{
+		{Topic: "topic", Partition: 0, Offset: 100},
+		{Topic: "topic", Partition: 1, Offset: 150},
+	}
+
+	// call the tested function
+	deleted, err := cleaner.DeleteExportedConsumerErrors(connection, exported, false)
+	assert.ErrorIs(t, err, mockedError)
+	assert.Equal(t, 0, deleted)
+
+	// connection is required
+	_, err = cleaner.DeleteExportedConsumerErrors(nil, exported, false)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestExportConsumerErrors checks the whole operation, including deletion of
+// exported consumer errors
+func TestExportConsumerErrors(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedSelectConsumerErrorsForReplay).WillReturnRows(consumerErrorsForReplayRows())
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 0, 100).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(expectedDeleteConsumerError).WithArgs("topic", 1, 150).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{}
+	cliFlags := cleaner.CliFlags{
+		ExportConsumerErrors: true,
+		DeleteExported:       true,
+		Output:               t.TempDir() + "/replay.json",
+	}
+
+	// call the tested function
+	status, err := cleaner.ExportConsumerErrors(&configuration, connection, cliFlags, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.FileExists(t, cliFlags.Output)
+
+	// consumer errors are not stored in DVO database
+	status, err = cleaner.ExportConsumerErrors(&configuration, connection, cliFlags, cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,457 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/coverage.html
+
+// This source file contains implementation of cleanup coverage report. The
+// report compares tables present in database with tables the cleaner knows
+// how to prune. Tables with timestamp columns that are not cleaned up at all
+// are reported as not covered, so it is easy to catch tables newly added to
+// aggregator database. Tables known to the cleaner that do not exist in
+// database are reported as well.
+//
+// Coverage report is selected by -coverage command line option.
+//
+// Tables that are not covered can be discovered automatically as well. For
+// each such table candidate retention entry is generated in the format of
+// plug-in schema tables (see schemas.go), so operator can review it and copy
+// it into configuration file. Discovery is selected by -discover-tables
+// command line option.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lib/pq"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// selectTablesWithTimestamps is used to read all tables from given schema
+// together with their timestamp columns
+const selectTablesWithTimestamps = `
+	SELECT t.table_name,
+	       COALESCE(string_agg(c.column_name::TEXT, ',' ORDER BY c.ordinal_position), '')
+	  FROM information_schema.tables t
+	  LEFT JOIN information_schema.columns c
+	    ON c.table_schema = t.table_schema
+	   AND c.table_name = t.table_name
+	   AND c.data_type IN ('timestamp without time zone', 'timestamp with time zone', 'date')
+	 WHERE t.table_schema = $1
+	   AND t.table_type = 'BASE TABLE'
+	 GROUP BY t.table_name
+	 ORDER BY t.table_name`
+
+// selectKeyColumns is used to read columns that can be used as keys by
+// cleanup of selected clusters
+const selectKeyColumns = `
+	SELECT table_name, column_name
+	  FROM information_schema.columns
+	 WHERE table_schema = $1
+	   AND column_name = ANY($2)
+	 ORDER BY table_name, ordinal_position`
+
+// Retention policies of tables
+const (
+	retentionCleanupAll = "cleanup-all"
+	retentionCleanup    = "cleanup"
+	retentionNone       = "none"
+)
+
+// Coverage statuses of tables
+const (
+	coverageCovered     = "covered"
+	coverageNotCovered  = "NOT COVERED"
+	coverageNoTimestamp = "no timestamp"
+	coverageMissing     = "missing in database"
+)
+
+// preferredTimestampColumns contains names of timestamp columns used by
+// aggregator tables, in order of preference. The first timestamp column is
+// used for tables without any of them.
+var preferredTimestampColumns = []string{
+	"reported_at", "last_checked_at", "updated_at", "last_updated_at", "consumed_at", "created_at",
+}
+
+// Names of columns used as keys in generated retention entries
+var (
+	clusterKeyColumns = []string{"cluster", "cluster_id"}
+	orgKeyColumn      = "org_id"
+)
+
+// plainIdentifierPattern matches identifiers that do not need to be quoted
+var plainIdentifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// discoveredTables is used to generate retention entries in the same format
+// as tables of plug-in schema are declared in configuration file. Optional
+// attributes are omitted.
+type discoveredTables struct {
+	Schemas []discoveredSchema `toml:"schemas"`
+}
+
+// discoveredSchema represents plug-in schema with generated retention entries
+type discoveredSchema struct {
+	Name           string                     `toml:"name"`
+	DatabaseSchema string                     `toml:"database_schema"`
+	Tables         []discoveredTableRetention `toml:"tables"`
+}
+
+// discoveredTableRetention represents generated retention entry for one
+// table
+type discoveredTableRetention struct {
+	TableName       string `toml:"table"`
+	KeyName         string `toml:"key,omitempty"`
+	OrgKeyName      string `toml:"org_key,omitempty"`
+	DeleteStatement string `toml:"delete_statement"`
+}
+
+// TableCoverage represents coverage of one table by cleanup
+type TableCoverage struct {
+	TableName        string
+	TimestampColumns []string
+	Retention        string
+	Present          bool
+}
+
+// Status method returns coverage status of the table
+func (coverage TableCoverage) Status() string {
+	switch {
+	case !coverage.Present:
+		return coverageMissing
+	case coverage.Retention != retentionNone:
+		return coverageCovered
+	case len(coverage.TimestampColumns) == 0:
+		return coverageNoTimestamp
+	default:
+		return coverageNotCovered
+	}
+}
+
+// Uncovered method checks if the table contains timestamp columns, but no
+// retention policy is configured for it
+func (coverage TableCoverage) Uncovered() bool {
+	return coverage.Status() == coverageNotCovered
+}
+
+// retentionPolicies function returns retention policy for all tables the
+// cleaner knows how to prune in given DB schema. Age-based cleanup takes
+// precedence over cleanup of selected clusters.
+func retentionPolicies(schema string) (map[string]string, error) {
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(map[string]string)
+	for _, tableAndKey := range tablesAndKeys {
+		policies[tableAndKey.TableName] = retentionCleanup
+	}
+	for _, tableToDelete := range tablesToDelete {
+		policies[tableToDelete.TableName] = retentionCleanupAll
+	}
+	return policies, nil
+}
+
+// readTableCoverage function reads all tables from database and compares
+// them with tables the cleaner knows how to prune
+func readTableCoverage(connection *sql.DB, schema string) ([]TableCoverage, error) {
+	var coverages []TableCoverage
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return coverages, ErrNoConnection
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return coverages, err
+	}
+
+	policies, err := retentionPolicies(schema)
+	if err != nil {
+		return coverages, err
+	}
+
+	present := make(StringSet)
+	args := []interface{}{databaseSchema}
+	err = queryRows(connection, selectTablesWithTimestamps, args, func(rows *sql.Rows) error {
+		var table, columns string
+		if err := rows.Scan(&table, &columns); err != nil {
+			return err
+		}
+
+		coverage := TableCoverage{
+			TableName:        table,
+			TimestampColumns: []string{},
+			Retention:        retentionNone,
+			Present:          true,
+		}
+		if columns != "" {
+			coverage.TimestampColumns = strings.Split(columns, ",")
+		}
+		if policy, found := policies[table]; found {
+			coverage.Retention = policy
+		}
+		present[table] = struct{}{}
+		coverages = append(coverages, coverage)
+		return nil
+	})
+	if err != nil {
+		return coverages, queryFailed("information_schema.tables", err)
+	}
+
+	// tables known to the cleaner might have been renamed or dropped
+	known, err := managedTables(schema)
+	if err != nil {
+		return coverages, err
+	}
+	for _, table := range known {
+		if _, found := present[table]; !found {
+			coverages = append(coverages, TableCoverage{
+				TableName:        table,
+				TimestampColumns: []string{},
+				Retention:        policies[table],
+			})
+		}
+	}
+
+	return coverages, nil
+}
+
+// PrintCoverageReport function displays a table with timestamp columns,
+// retention policy, and coverage status of each table
+func PrintCoverageReport(coverages []TableCoverage) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", "Timestamp columns", "Retention", "Status"})
+
+	for _, coverage := range coverages {
+		table.Append([]string{coverage.TableName,
+			strings.Join(coverage.TimestampColumns, ", "),
+			coverage.Retention,
+			coverage.Status()})
+	}
+
+	// display the whole table
+	table.Render()
+}
+
+// coverageReport function displays coverage of database tables by cleanup
+func coverageReport(connection *sql.DB, schema string) (ExitStatus, error) {
+	coverages, err := readTableCoverage(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Reading table coverage")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	uncovered := 0
+	for _, coverage := range coverages {
+		if coverage.Uncovered() {
+			uncovered++
+			log.Warn().
+				Str(tableName, coverage.TableName).
+				Strs("timestamp columns", coverage.TimestampColumns).
+				Msg("Table is not covered by cleanup")
+		}
+		if !coverage.Present {
+			log.Warn().
+				Str(tableName, coverage.TableName).
+				Msg("Table known to the cleaner does not exist in database")
+		}
+	}
+	log.Info().
+		Int("tables", len(coverages)).
+		Int("not covered", uncovered).
+		Msg("Coverage report")
+
+	PrintCoverageReport(coverages)
+	return ExitStatusOK, nil
+}
+
+// retentionTimestampColumn function selects timestamp column used to delete
+// old records from table
+func retentionTimestampColumn(columns []string) string {
+	for _, preferred := range preferredTimestampColumns {
+		for _, column := range columns {
+			if column == preferred {
+				return column
+			}
+		}
+	}
+	return columns[0]
+}
+
+// retentionIdentifier function quotes identifier used in generated delete
+// statement, but only when it is needed, so the statement remains readable
+func retentionIdentifier(name string) string {
+	if plainIdentifierPattern.MatchString(name) {
+		return name
+	}
+	return pq.QuoteIdentifier(name)
+}
+
+// retentionDeleteStatement function constructs statement that deletes old
+// records by given timestamp column. Future-dated records are never deleted,
+// the same as by built-in delete statements.
+func retentionDeleteStatement(table, column string) string {
+	table = retentionIdentifier(table)
+	column = retentionIdentifier(column)
+	return fmt.Sprintf("DELETE FROM %s WHERE %s < NOW() - $1::INTERVAL AND %s <= NOW()",
+		table, column, column)
+}
+
+// readKeyColumns function reads columns that can be used as keys by cleanup
+// of selected clusters for all tables from given PostgreSQL schema
+func readKeyColumns(connection *sql.DB, databaseSchema string) (map[string]StringSet, error) {
+	keys := make(map[string]StringSet)
+
+	candidates := append([]string{orgKeyColumn}, clusterKeyColumns...)
+	args := []interface{}{databaseSchema, pq.Array(candidates)}
+	err := queryRows(connection, selectKeyColumns, args, func(rows *sql.Rows) error {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		if keys[table] == nil {
+			keys[table] = make(StringSet)
+		}
+		keys[table][column] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return keys, queryFailed("information_schema.columns", err)
+	}
+	return keys, nil
+}
+
+// discoverPrunableTables function generates candidate retention entries for
+// all tables with timestamp columns that are not covered by cleanup
+func discoverPrunableTables(connection *sql.DB, schema string) ([]SchemaTableConfiguration, error) {
+	var tables []SchemaTableConfiguration
+
+	coverages, err := readTableCoverage(connection, schema)
+	if err != nil {
+		return tables, err
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return tables, err
+	}
+
+	keys, err := readKeyColumns(connection, databaseSchema)
+	if err != nil {
+		return tables, err
+	}
+
+	for _, coverage := range coverages {
+		if !coverage.Uncovered() {
+			continue
+		}
+
+		column := retentionTimestampColumn(coverage.TimestampColumns)
+		table := SchemaTableConfiguration{
+			TableName:       coverage.TableName,
+			DeleteStatement: retentionDeleteStatement(coverage.TableName, column),
+		}
+		// records can be deleted for selected clusters as well
+		for _, key := range clusterKeyColumns {
+			if _, found := keys[coverage.TableName][key]; found {
+				table.KeyName = key
+				break
+			}
+		}
+		if _, found := keys[coverage.TableName][orgKeyColumn]; found && table.KeyName != "" {
+			table.OrgKeyName = orgKeyColumn
+		}
+
+		log.Info().
+			Str(tableName, table.TableName).
+			Str("timestamp column", column).
+			Str("key", table.KeyName).
+			Msg("Prunable table discovered")
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// writeRetentionEntries function writes generated retention entries in the
+// format of plug-in schema declared in configuration file
+func writeRetentionEntries(writer io.Writer, schema, databaseSchema string, tables []SchemaTableConfiguration) error {
+	discovered := discoveredSchema{
+		Name:           schema,
+		DatabaseSchema: databaseSchema,
+		Tables:         make([]discoveredTableRetention, len(tables)),
+	}
+	for i, table := range tables {
+		discovered.Tables[i] = discoveredTableRetention(table)
+	}
+
+	_, err := fmt.Fprintln(writer, "# candidate retention entries generated by -discover-tables, review them before use")
+	if err != nil {
+		return err
+	}
+	return toml.NewEncoder(writer).Encode(discoveredTables{
+		Schemas: []discoveredSchema{discovered},
+	})
+}
+
+// discoverTables function writes candidate retention entries for tables
+// that are not covered by cleanup into output file
+func discoverTables(connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	tables, err := discoverPrunableTables(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Discovering prunable tables")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	out, err := createOutputFile(cliFlags.DiscoverTables, cliFlags.Checksum)
+	if err == nil {
+		err = writeRetentionEntries(out.Writer(), schema, databaseSchema, tables)
+		err = errors.Join(err, out.Close(err == nil))
+	}
+	if err != nil {
+		log.Err(err).Msg("Write retention entries")
+		return ExitStatusStorageError, err
+	}
+
+	log.Info().
+		Str(filenameAttribute, cliFlags.DiscoverTables).
+		Int("tables", len(tables)).
+		Msg("Prunable tables discovered")
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/coverage_test.html
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// expectedTablesWithTimestampsQuery is query expected by coverage report
+// tests
+const expectedTablesWithTimestampsQuery = "SELECT t.table_name, COALESCE\\(string_agg\\(c.column_name::TEXT"
+
+// expectedKeyColumnsQuery is query expected by discovery of prunable tables
+const expectedKeyColumnsQuery = "SELECT table_name, column_name FROM information_schema.columns"
+
+// TestTableCoverageStatus checks coverage statuses of tables
+func TestTableCoverageStatus(t *testing.T) {
+	testCases := []struct {
+		coverage  cleaner.TableCoverage
+		status    string
+		uncovered bool
+	}{
+		{cleaner.TableCoverage{TimestampColumns: []string{"reported_at"}, Retention: "cleanup-all", Present: true}, "covered", false},
+		{cleaner.TableCoverage{TimestampColumns: []string{}, Retention: "cleanup", Present: true}, "covered", false},
+		{cleaner.TableCoverage{TimestampColumns: []string{}, Retention: "none", Present: true}, "no timestamp", false},
+		{cleaner.TableCoverage{TimestampColumns: []string{"created_at"}, Retention: "none", Present: true}, "NOT COVERED", true},
+		{cleaner.TableCoverage{TimestampColumns: []string{}, Retention: "cleanup", Present: false}, "missing in database", false},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.status, testCase.coverage.Status())
+		assert.Equal(t, testCase.uncovered, testCase.coverage.Uncovered())
+	}
+}
+
+// TestRetentionPolicies checks that age-based cleanup takes precedence over
+// cleanup of selected clusters
+func TestRetentionPolicies(t *testing.T) {
+	policies, err := cleaner.RetentionPolicies(cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, "cleanup-all", policies["report"])
+	assert.Equal(t, "cleanup-all", policies["recommendation"])
+	assert.Equal(t, "cleanup", policies["report_info"])
+	assert.NotContains(t, policies, "advisor_ratings")
+
+	_, err = cleaner.RetentionPolicies("unknown")
+	assert.Error(t, err)
+}
+
+// TestReadTableCoverage checks that tables present in database are compared
+// with tables known to the cleaner
+func TestReadTableCoverage(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table_name", "columns"})
+	rows.AddRow("dvo_report", "reported_at,last_checked_at")
+	rows.AddRow("dvo_audit", "created_at")
+	rows.AddRow("migration_info", "")
+	mock.ExpectQuery(expectedTablesWithTimestampsQuery).WithArgs("dvo").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	coverages, err := cleaner.ReadTableCoverage(connection, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TableCoverage{
+		{TableName: "dvo_report", TimestampColumns: []string{"reported_at", "last_checked_at"}, Retention: "cleanup-all", Present: true},
+		{TableName: "dvo_audit", TimestampColumns: []string{"created_at"}, Retention: "none", Present: true},
+		{TableName: "migration_info", TimestampColumns: []string{}, Retention: "none", Present: true},
+	}, coverages)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableCoverageMissingTable checks that tables known to the cleaner
+// that do not exist in database are reported
+func TestReadTableCoverageMissingTable(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedTablesWithTimestampsQuery).WithArgs("dvo").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "columns"}))
+	mock.ExpectClose()
+
+	coverages, err := cleaner.ReadTableCoverage(connection, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TableCoverage{
+		{TableName: "dvo_report", TimestampColumns: []string{}, Retention: "cleanup-all", Present: false},
+	}, coverages)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableCoverageOnError checks that query error is returned
+func TestReadTableCoverageOnError(t *testing.T) {
+	// error to be thrown
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedTablesWithTimestampsQuery).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	_, err = cleaner.ReadTableCoverage(connection, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, mockedError)
+
+	// no connection
+	_, err = cleaner.ReadTableCoverage(nil, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCoverageReport checks that uncovered tables are displayed
+func TestCoverageReport(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table_name", "columns"})
+	rows.AddRow("dvo_report", "reported_at,last_checked_at")
+	rows.AddRow("dvo_audit", "created_at")
+	mock.ExpectQuery(expectedTablesWithTimestampsQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	output, err := capture.StandardOutput(func() {
+		status, err := cleaner.CoverageReport(connection, cleaner.DBSchemaDVORecommendations)
+		assert.NoError(t, err)
+		assert.Equal(t, cleaner.ExitStatusOK, status)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "dvo_audit")
+	assert.Contains(t, output, "NOT COVERED")
+	assert.Contains(t, output, "cleanup-all")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestRetentionTimestampColumn checks that well known timestamp columns are
+// preferred
+func TestRetentionTimestampColumn(t *testing.T) {
+	assert.Equal(t, "reported_at", cleaner.RetentionTimestampColumn([]string{"created_at", "reported_at"}))
+	assert.Equal(t, "consumed_at", cleaner.RetentionTimestampColumn([]string{"consumed_at"}))
+	assert.Equal(t, "archived_at", cleaner.RetentionTimestampColumn([]string{"archived_at", "deleted_at"}))
+}
+
+// TestRetentionDeleteStatement checks that generated delete statement does
+// not delete future-dated records
+func TestRetentionDeleteStatement(t *testing.T) {
+	assert.Equal(t,
+		"DELETE FROM dvo_audit WHERE created_at < NOW() - $1::INTERVAL AND created_at <= NOW()",
+		cleaner.RetentionDeleteStatement("dvo_audit", "created_at"))
+
+	// identifiers are quoted only when needed
+	assert.Equal(t,
+		`DELETE FROM "Audit" WHERE "created at" < NOW() - $1::INTERVAL AND "created at" <= NOW()`,
+		cleaner.RetentionDeleteStatement("Audit", "created at"))
+}
+
+// expectDiscoveryQueries function mocks queries performed by discovery of
+// prunable tables in DVO database
+func expectDiscoveryQueries(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"table_name", "columns"})
+	rows.AddRow("dvo_report", "reported_at,last_checked_at")
+	rows.AddRow("dvo_audit", "created_at,updated_at")
+	rows.AddRow("dvo_log", "logged_at")
+	rows.AddRow("migration_info", "")
+	mock.ExpectQuery(expectedTablesWithTimestampsQuery).WithArgs("dvo").WillReturnRows(rows)
+
+	keys := sqlmock.NewRows([]string{"table_name", "column_name"})
+	keys.AddRow("dvo_audit", "org_id")
+	keys.AddRow("dvo_audit", "cluster_id")
+	keys.AddRow("dvo_log", "org_id")
+	keys.AddRow("dvo_report", "org_id")
+	keys.AddRow("dvo_report", "cluster_id")
+	mock.ExpectQuery(expectedKeyColumnsQuery).WithArgs("dvo", sqlmock.AnyArg()).WillReturnRows(keys)
+}
+
+// TestDiscoverPrunableTables checks that retention entries are generated for
+// uncovered tables only
+func TestDiscoverPrunableTables(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDiscoveryQueries(mock)
+	mock.ExpectClose()
+
+	tables, err := cleaner.DiscoverPrunableTables(connection, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.SchemaTableConfiguration{
+		{
+			TableName:       "dvo_audit",
+			KeyName:         "cluster_id",
+			OrgKeyName:      "org_id",
+			DeleteStatement: cleaner.RetentionDeleteStatement("dvo_audit", "updated_at"),
+		},
+		{
+			TableName:       "dvo_log",
+			DeleteStatement: cleaner.RetentionDeleteStatement("dvo_log", "logged_at"),
+		},
+	}, tables)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteRetentionEntries checks that retention entries can be read as
+// plug-in schema
+func TestWriteRetentionEntries(t *testing.T) {
+	tables := []cleaner.SchemaTableConfiguration{
+		{
+			TableName:       "dvo_audit",
+			KeyName:         "cluster_id",
+			DeleteStatement: cleaner.RetentionDeleteStatement("dvo_audit", "updated_at"),
+		},
+	}
+
+	var buffer bytes.Buffer
+	err := cleaner.WriteRetentionEntries(&buffer, "dvo_recommendations", "dvo", tables)
+	assert.NoError(t, err)
+
+	output := buffer.String()
+	assert.Contains(t, output, "[[schemas]]")
+	assert.Contains(t, output, "[[schemas.tables]]")
+	assert.Contains(t, output, `table = "dvo_audit"`)
+	assert.Contains(t, output, `key = "cluster_id"`)
+	assert.NotContains(t, output, "org_key")
+}
+
+// TestDiscoverTables checks that retention entries are written into output
+// file
+func TestDiscoverTables(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "retention.toml")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDiscoveryQueries(mock)
+	mock.ExpectClose()
+
+	status, err := cleaner.DiscoverTables(connection, cleaner.CliFlags{DiscoverTables: filename},
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `table = "dvo_log"`)
+	assert.NotContains(t, string(content), `table = "dvo_report"`)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/deletion_matrix.html
+
+// This source file contains implementation of deletion matrix, ie. number of
+// rows deleted for each cluster from each table. The summary aggregates
+// deletions per table only, but when a deletion request covers many clusters,
+// the requester usually wants confirmation for each cluster separately.
+//
+// The matrix is written into file specified by -deletion-matrix command line
+// option. JSON document is written when the file name has .json suffix, CSV
+// file with one line per cluster is written otherwise.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Names of CSV columns with cluster name and with rows deleted from all
+// tables, other columns are named by tables
+const (
+	clusterColumn = "cluster"
+	totalColumn   = "total"
+)
+
+// DeletionMatrix contains number of rows deleted for each cluster from each
+// table
+type DeletionMatrix map[ClusterName]map[string]int
+
+// ClusterDeletions represents one row of deletion matrix as it is written
+// into JSON document
+type ClusterDeletions struct {
+	Cluster ClusterName    `json:"cluster"`
+	Tables  map[string]int `json:"tables"`
+	Total   int            `json:"total"`
+}
+
+// add method adds rows deleted from tables for given cluster
+func (matrix DeletionMatrix) add(cluster ClusterName, deletionsForTable map[string]int) {
+	deletions, found := matrix[cluster]
+	if !found {
+		deletions = make(map[string]int, len(deletionsForTable))
+		matrix[cluster] = deletions
+	}
+	for table, deleted := range deletionsForTable {
+		deletions[table] += deleted
+	}
+}
+
+// Deleted method returns number of rows deleted for given cluster from all
+// tables
+func (matrix DeletionMatrix) Deleted(cluster ClusterName) int {
+	return deletedRowsCount(matrix[cluster])
+}
+
+// Rows method returns rows of deletion matrix for given clusters. All
+// tables are included for each cluster, even when no row has been deleted.
+func (matrix DeletionMatrix) Rows(clusterList ClusterList, tables []string) []ClusterDeletions {
+	rows := make([]ClusterDeletions, len(clusterList))
+	for i, cluster := range clusterList {
+		rows[i] = ClusterDeletions{
+			Cluster: cluster,
+			Tables:  make(map[string]int, len(tables)),
+			Total:   matrix.Deleted(cluster),
+		}
+		for _, table := range tables {
+			rows[i].Tables[table] = matrix[cluster][table]
+		}
+	}
+	return rows
+}
+
+// writeDeletionMatrixCSV function writes deletion matrix as CSV with one
+// column per table
+func writeDeletionMatrixCSV(writer io.Writer, rows []ClusterDeletions, tables []string) error {
+	csvWriter := csv.NewWriter(writer)
+
+	header := append(append([]string{clusterColumn}, tables...), totalColumn)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := []string{string(row.Cluster)}
+		for _, table := range tables {
+			record = append(record, strconv.Itoa(row.Tables[table]))
+		}
+		record = append(record, strconv.Itoa(row.Total))
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// writeDeletionMatrixJSON function writes deletion matrix as JSON document
+func writeDeletionMatrixJSON(writer io.Writer, rows []ClusterDeletions) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// writeDeletionMatrix function writes deletion matrix for given clusters
+// into file, format is selected by suffix of the file name. Columns are
+// ordered the same way as tables in given DB schema.
+func writeDeletionMatrix(filename string, checksum bool, matrix DeletionMatrix,
+	clusterList ClusterList, schema string) error {
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return err
+	}
+	tables := make([]string, len(tablesAndKeys))
+	for i, tableAndKey := range tablesAndKeys {
+		tables[i] = tableAndKey.TableName
+	}
+	rows := matrix.Rows(clusterList, tables)
+
+	out, err := createOutputFile(filename, checksum)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = writeDeletionMatrixJSON(out.Writer(), rows)
+	} else {
+		err = writeDeletionMatrixCSV(out.Writer(), rows, tables)
+	}
+	err = errors.Join(err, out.Close(err == nil))
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str(filenameAttribute, filename).
+		Int("clusters", len(rows)).
+		Msg("Deletion matrix written")
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/deletion_matrix_test.html
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// deletionMatrix is deletion matrix used by tests, the third cluster does not
+// have any rows in database
+var deletionMatrix = cleaner.DeletionMatrix{
+	cluster1ID: {"dvo_report": 3},
+	cluster2ID: {"dvo_report": 1},
+}
+
+// TestDeletionMatrixRows checks that all requested clusters and all tables
+// are included in rows of deletion matrix
+func TestDeletionMatrixRows(t *testing.T) {
+	rows := deletionMatrix.Rows(cleaner.ClusterList{cluster2ID, cluster1ID, cluster3ID},
+		[]string{"dvo_report", "other"})
+
+	assert.Equal(t, []cleaner.ClusterDeletions{
+		{Cluster: cluster2ID, Tables: map[string]int{"dvo_report": 1, "other": 0}, Total: 1},
+		{Cluster: cluster1ID, Tables: map[string]int{"dvo_report": 3, "other": 0}, Total: 3},
+		{Cluster: cluster3ID, Tables: map[string]int{"dvo_report": 0, "other": 0}, Total: 0},
+	}, rows)
+	assert.Equal(t, 0, deletionMatrix.Deleted(cluster3ID))
+}
+
+// TestWriteDeletionMatrixCSV checks deletion matrix written as CSV
+func TestWriteDeletionMatrixCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "matrix.csv")
+
+	err := cleaner.WriteDeletionMatrix(filename, false, deletionMatrix,
+		cleaner.ClusterList{cluster1ID, cluster3ID}, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster,dvo_report,total\n"+
+		cluster1ID+",3,3\n"+
+		cluster3ID+",0,0\n", string(content))
+}
+
+// TestWriteDeletionMatrixJSON checks deletion matrix written as JSON
+// document together with its checksum
+func TestWriteDeletionMatrixJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "matrix.json")
+
+	err := cleaner.WriteDeletionMatrix(filename, true, deletionMatrix,
+		cleaner.ClusterList{cluster1ID, cluster2ID}, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	var rows []cleaner.ClusterDeletions
+	assert.NoError(t, json.Unmarshal(content, &rows))
+	assert.Len(t, rows, 2)
+	assert.Equal(t, cleaner.ClusterName(cluster2ID), rows[1].Cluster)
+	assert.Equal(t, map[string]int{"dvo_report": 1}, rows[1].Tables)
+	assert.Equal(t, 1, rows[1].Total)
+	assert.FileExists(t, filename+".sha256")
+}
+
+// TestWriteDeletionMatrixUnknownSchema checks that nothing is written for
+// unknown DB schema
+func TestWriteDeletionMatrixUnknownSchema(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "matrix.csv")
+
+	err := cleaner.WriteDeletionMatrix(filename, false, deletionMatrix,
+		cleaner.ClusterList{cluster1ID}, "unknown")
+	var schemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &schemaErr)
+	assert.NoFileExists(t, filename)
+}
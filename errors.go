@@ -0,0 +1,225 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/errors.html
+
+// This source file contains definition of errors returned by storage layer.
+// Callers can use errors.Is and errors.As to find out what happened and map
+// the error to the right exit status.
+//
+// Common PostgreSQL errors (identified by their SQLSTATE codes) are
+// translated into messages that tell the operator what to check, and they are
+// reported with specific exit statuses.
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// SQLSTATE codes of database errors translated into actionable messages
+const (
+	undefinedTableCode        = "42P01"
+	insufficientPrivilegeCode = "42501"
+	foreignKeyViolationCode   = "23503"
+	lockNotAvailableCode      = "55P03"
+)
+
+// hintAttribute is name of log attribute with actionable message for
+// database error
+const hintAttribute = "hint"
+
+// databaseErrorHint represents actionable message and exit status for
+// database error with given SQLSTATE code
+type databaseErrorHint struct {
+	message    string
+	exitStatus ExitStatus
+}
+
+// databaseErrorHints contains actionable messages and exit statuses for
+// common database errors
+var databaseErrorHints = map[pq.ErrorCode]databaseErrorHint{
+	undefinedTableCode: {
+		"table does not exist, check that DB schema and search path in configuration match the database",
+		ExitStatusUndefinedTable,
+	},
+	insufficientPrivilegeCode: {
+		"database user is not allowed to access the table, grant the needed privileges to the user",
+		ExitStatusInsufficientPrivilege,
+	},
+	foreignKeyViolationCode: {
+		"records are still referenced from another table, check foreign keys with -simulate-in-schema",
+		ExitStatusForeignKeyViolation,
+	},
+	lockNotAvailableCode: {
+		"table is locked by another session, retry when the lock is released",
+		ExitStatusLockNotAvailable,
+	},
+}
+
+// ErrNoConnection is returned when connection to database was not
+// established
+var ErrNoConnection = errors.New(connectionNotEstablished)
+
+// ErrDatabaseUnreachable is returned when database did not respond to ping,
+// even after all retries
+var ErrDatabaseUnreachable = errors.New(databaseUnreachable)
+
+// ErrReadOnly is returned when statement that modifies data is about to be
+// executed or destructive operation is selected in read-only mode
+var ErrReadOnly = errors.New("data can not be modified in read-only mode")
+
+// ErrInvalidSchema is returned when selected DB schema is not supported by
+// the operation
+type ErrInvalidSchema struct {
+	Schema string
+}
+
+// Error method returns error message
+func (e *ErrInvalidSchema) Error() string {
+	return fmt.Sprintf(invalidSchemaMsg, e.Schema)
+}
+
+// ErrQueryFailed is returned when query or statement performed against given
+// table failed. The original error returned by database driver is wrapped.
+type ErrQueryFailed struct {
+	Table string
+	Err   error
+}
+
+// Error method returns error message, actionable message is included for
+// common database errors
+func (e *ErrQueryFailed) Error() string {
+	if hint, found := databaseErrorHintFor(e.Err); found {
+		return fmt.Sprintf("query to table '%s' failed: %s: %v", e.Table, hint.message, e.Err)
+	}
+	return fmt.Sprintf("query to table '%s' failed: %v", e.Table, e.Err)
+}
+
+// Unwrap method returns the original error
+func (e *ErrQueryFailed) Unwrap() error {
+	return e.Err
+}
+
+// ErrFingerprintMismatch is returned when fingerprint read from database
+// does not match fingerprint specified in configuration
+type ErrFingerprintMismatch struct {
+	Expected string
+	Actual   string
+}
+
+// Error method returns error message
+func (e *ErrFingerprintMismatch) Error() string {
+	return fmt.Sprintf("database fingerprint '%s' does not match expected fingerprint '%s'",
+		e.Actual, e.Expected)
+}
+
+// ErrUnsupportedMigration is returned when database has been migrated by
+// newer version of aggregator than the cleaner has been built against
+type ErrUnsupportedMigration struct {
+	Schema    string
+	Version   int
+	Supported int
+}
+
+// Error method returns error message
+func (e *ErrUnsupportedMigration) Error() string {
+	return fmt.Sprintf("database schema %s has migration version %d, the newest supported version is %d",
+		e.Schema, e.Version, e.Supported)
+}
+
+// ErrInvalidConfiguration is returned when configuration option is not
+// valid. Key of the offending option is stored together with the original
+// error.
+type ErrInvalidConfiguration struct {
+	Key string
+	Err error
+}
+
+// Error method returns error message
+func (e *ErrInvalidConfiguration) Error() string {
+	return fmt.Sprintf("configuration option '%s': %v", e.Key, e.Err)
+}
+
+// Unwrap method returns the original error
+func (e *ErrInvalidConfiguration) Unwrap() error {
+	return e.Err
+}
+
+// invalidSchema function constructs error for given DB schema
+func invalidSchema(schema string) error {
+	return &ErrInvalidSchema{Schema: schema}
+}
+
+// queryFailed function wraps error returned by database driver for query or
+// statement performed against given table
+func queryFailed(table string, err error) error {
+	return &ErrQueryFailed{Table: table, Err: err}
+}
+
+// invalidConfiguration function wraps error found in configuration option
+// with given key
+func invalidConfiguration(key string, err error) error {
+	return &ErrInvalidConfiguration{Key: key, Err: err}
+}
+
+// databaseErrorHintFor function returns actionable message and exit status
+// for database error wrapped in given error. Nothing is found for errors not
+// returned by PostgreSQL or for uncommon errors.
+func databaseErrorHintFor(err error) (databaseErrorHint, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return databaseErrorHint{}, false
+	}
+	hint, found := databaseErrorHints[pqErr.Code]
+	return hint, found
+}
+
+// exitStatusForError function returns exit status for error returned by
+// storage layer. Errors caused by missing or unreachable connection or by
+// unsupported DB schema are reported as storage errors, common database
+// errors are reported with their specific exit statuses, all other errors are
+// reported with exit status of the operation itself.
+func exitStatusForError(err error, operationStatus ExitStatus) ExitStatus {
+	if errors.Is(err, ErrReadOnly) {
+		return ExitStatusReadOnlyViolation
+	}
+	var invalidSchemaErr *ErrInvalidSchema
+	if errors.Is(err, ErrNoConnection) || errors.Is(err, ErrDatabaseUnreachable) ||
+		errors.As(err, &invalidSchemaErr) {
+		return ExitStatusStorageError
+	}
+	if hint, found := databaseErrorHintFor(err); found {
+		return hint.exitStatus
+	}
+	return operationStatus
+}
+
+// logOperationFailed function logs error that caused the whole operation to
+// fail. Actionable message is attached for common database errors, so it is
+// not needed to look it up by SQLSTATE code.
+func logOperationFailed(err error) {
+	event := log.Err(err)
+	if hint, found := databaseErrorHintFor(err); found {
+		event = event.Str(hintAttribute, hint.message)
+	}
+	event.Msg("Operation failed")
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/errors_test.html
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestErrInvalidSchema checks the error returned for unsupported DB schema
+func TestErrInvalidSchema(t *testing.T) {
+	var err error = &cleaner.ErrInvalidSchema{Schema: "foobar"}
+
+	assert.Equal(t, "Invalid DB schema: 'foobar'", err.Error())
+
+	// error type can be checked by errors.As even when wrapped
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &invalidSchemaErr))
+	assert.Equal(t, "foobar", invalidSchemaErr.Schema)
+}
+
+// TestErrQueryFailed checks the error returned when query to table failed
+func TestErrQueryFailed(t *testing.T) {
+	mockedError := errors.New("mocked error")
+	var err error = &cleaner.ErrQueryFailed{Table: "report", Err: mockedError}
+
+	assert.Equal(t, "query to table 'report' failed: mocked error", err.Error())
+
+	// the original error is wrapped
+	assert.ErrorIs(t, err, mockedError)
+
+	var queryFailedErr *cleaner.ErrQueryFailed
+	assert.True(t, errors.As(err, &queryFailedErr))
+	assert.Equal(t, "report", queryFailedErr.Table)
+}
+
+// TestErrQueryFailedHint checks that actionable message is included for
+// common database errors
+func TestErrQueryFailedHint(t *testing.T) {
+	pqErr := &pq.Error{Code: "42501", Message: "permission denied for table report"}
+	var err error = &cleaner.ErrQueryFailed{Table: "report", Err: pqErr}
+
+	assert.Equal(t, "query to table 'report' failed: database user is not allowed to access the table, "+
+		"grant the needed privileges to the user: pq: permission denied for table report", err.Error())
+	assert.ErrorIs(t, err, pqErr)
+
+	// uncommon database errors are reported as they are
+	err = &cleaner.ErrQueryFailed{Table: "report", Err: &pq.Error{Code: "22012", Message: "division by zero"}}
+	assert.Equal(t, "query to table 'report' failed: pq: division by zero", err.Error())
+}
+
+// TestStorageErrorsAreTyped checks that storage layer returns typed errors
+func TestStorageErrorsAreTyped(t *testing.T) {
+	// connection is not established
+	_, err := cleaner.PerformCleanupAllInDB(nil, maxAge, cleaner.DBSchemaOCPRecommendations, false)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// unsupported DB schema
+	_, err = cleaner.PerformCleanupAllInDB(connection, maxAge, "foobar", false)
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &invalidSchemaErr)
+	assert.Equal(t, "foobar", invalidSchemaErr.Schema)
+
+	// query failed
+	mockedError := errors.New("mocked error")
+	mock.ExpectExec("DELETE FROM rule_hit").WillReturnError(mockedError)
+	mock.ExpectClose()
+	_, err = cleaner.PerformCleanupAllInDB(connection, maxAge, cleaner.DBSchemaOCPRecommendations, false)
+	var queryFailedErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryFailedErr)
+	assert.Equal(t, "rule_hit", queryFailedErr.Table)
+	assert.ErrorIs(t, err, mockedError)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestExitStatusForError checks mapping of storage errors to exit statuses
+func TestExitStatusForError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	assert.Equal(t, cleaner.ExitStatusStorageError,
+		cleaner.ExitStatusForError(cleaner.ErrNoConnection, cleaner.ExitStatusPerformCleanupError))
+	assert.Equal(t, cleaner.ExitStatusStorageError,
+		cleaner.ExitStatusForError(&cleaner.ErrInvalidSchema{Schema: "foobar"}, cleaner.ExitStatusPerformCleanupError))
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError,
+		cleaner.ExitStatusForError(&cleaner.ErrQueryFailed{Table: "report", Err: mockedError},
+			cleaner.ExitStatusPerformCleanupError))
+	assert.Equal(t, cleaner.ExitStatusFillInStorageError,
+		cleaner.ExitStatusForError(mockedError, cleaner.ExitStatusFillInStorageError))
+	assert.Equal(t, cleaner.ExitStatusReadOnlyViolation,
+		cleaner.ExitStatusForError(&cleaner.ErrQueryFailed{Table: "report", Err: cleaner.ErrReadOnly},
+			cleaner.ExitStatusPerformCleanupError))
+
+	// common database errors are reported with specific exit statuses
+	for code, exitStatus := range map[pq.ErrorCode]cleaner.ExitStatus{
+		"42P01": cleaner.ExitStatusUndefinedTable,
+		"42501": cleaner.ExitStatusInsufficientPrivilege,
+		"23503": cleaner.ExitStatusForeignKeyViolation,
+		"55P03": cleaner.ExitStatusLockNotAvailable,
+		"22012": cleaner.ExitStatusPerformCleanupError,
+	} {
+		err := fmt.Errorf("wrapped: %w", &cleaner.ErrQueryFailed{Table: "report", Err: &pq.Error{Code: code}})
+		assert.Equal(t, exitStatus, cleaner.ExitStatusForError(err, cleaner.ExitStatusPerformCleanupError), code)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/estimate.html
+
+// This source file contains implementation of fast estimate of number of old
+// records. COUNT(*) queries need to scan huge tables, so they might take a
+// long time. The estimate is computed from planner statistics instead: number
+// of rows is read from pg_class.reltuples and fraction of old rows is derived
+// from histogram bounds of timestamp column stored in pg_stats. Exact number
+// of old records can be displayed together with the estimate.
+//
+// Estimate is selected by -estimate command line option, exact count is
+// added by -exact-count command line option.
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// estimateOldRecordsQueryTemplate is a template of query that reads planner
+// statistics for one table. Histogram bounds of timestamp column that satisfy
+// condition selecting old records are counted, the condition is inserted
+// into the template.
+const estimateOldRecordsQueryTemplate = `
+    SELECT c.reltuples::BIGINT,
+           COALESCE(s.null_frac, 0),
+           COALESCE(h.selected, 0),
+           COALESCE(h.total, 0)
+      FROM pg_class c
+      JOIN pg_namespace n ON n.oid = c.relnamespace
+      LEFT JOIN pg_stats s
+        ON s.schemaname = n.nspname
+       AND s.tablename = c.relname
+       AND s.attname = $3
+      LEFT JOIN LATERAL (
+           SELECT COUNT(*) FILTER (WHERE %s) AS selected, COUNT(*) AS total
+             FROM unnest(s.histogram_bounds::TEXT::TIMESTAMPTZ[]) AS bound
+      ) h ON TRUE
+     WHERE n.nspname = $1
+       AND c.relname = $2`
+
+// histogramBoundColumn is name of column with histogram bound used in
+// condition selecting old records
+const histogramBoundColumn = "bound"
+
+// unknownEstimate is displayed when estimate or count is not known
+const unknownEstimate = "n/a"
+
+// estimateOldRecordsQuery function constructs query that reads planner
+// statistics needed to estimate number of old records in given table
+func estimateOldRecordsQuery(databaseSchema string, table oldRecordsTable, maxAge string,
+	filter ListingFilter) (string, []interface{}) {
+	relation, column := table.statistics()
+	args := []interface{}{databaseSchema, relation, column}
+	condition, args := oldRecordsCondition(histogramBoundColumn, args, maxAge, filter)
+	return fmt.Sprintf(estimateOldRecordsQueryTemplate, condition), args
+}
+
+// histogramFraction function estimates fraction of rows selected by a
+// condition from number of histogram bounds that satisfy the condition.
+// Histogram bounds divide non-null values into buckets with the same number
+// of rows, the selected range is expected to end in the middle of a bucket.
+func histogramFraction(selected, total int64) float64 {
+	buckets := total - 1
+	if buckets < 1 || selected <= 0 {
+		return 0
+	}
+	if selected >= total {
+		return 1
+	}
+	return (float64(selected) - 0.5) / float64(buckets)
+}
+
+// estimateRows function estimates number of rows selected by condition from
+// planner statistics. The estimate is not valid when the table has not been
+// analyzed yet or when histogram of the column is not available.
+func estimateRows(reltuples int64, nullFraction float64, selected, total int64) sql.NullInt64 {
+	if reltuples < 0 || total < 2 {
+		return sql.NullInt64{}
+	}
+	fraction := histogramFraction(selected, total)
+	estimate := math.Round(float64(reltuples) * (1 - nullFraction) * fraction)
+	return sql.NullInt64{Int64: int64(estimate), Valid: true}
+}
+
+// estimateOldRecords function estimates number of old records in all tables
+// from given DB schema using planner statistics. When exact flag is set, old
+// records are counted by COUNT(*) queries as well.
+func estimateOldRecords(connection *sql.DB, maxAge string, schema string, filter ListingFilter,
+	exact bool) ([]OldRecordsEstimate, error) {
+	// check if connection has been initialized
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return nil, ErrNoConnection
+	}
+
+	tables, err := oldRecordsTablesForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make([]OldRecordsEstimate, 0, len(tables))
+	for _, table := range tables {
+		estimate := OldRecordsEstimate{
+			Category: table.category,
+			Table:    table.table,
+		}
+
+		var reltuples, selected, total int64
+		var nullFraction float64
+		query, args := estimateOldRecordsQuery(databaseSchema, table, maxAge, filter)
+		err := queryRowStatement(connection, query, args...).Scan(&reltuples, &nullFraction, &selected, &total)
+		if err != nil {
+			log.Error().Err(err).Str(tableName, table.table).Msg("Estimate old records")
+			return nil, queryFailed(table.table, err)
+		}
+		estimate.Estimate = estimateRows(reltuples, nullFraction, selected, total)
+
+		if exact {
+			query, args := countOldRecordsQuery(table, maxAge, filter)
+
+			var count int64
+			err := queryRowStatement(connection, query, args...).Scan(&count)
+			if err != nil {
+				log.Error().Err(err).Str(tableName, table.table).Msg("Count old records")
+				return nil, queryFailed(table.table, err)
+			}
+			estimate.Count = sql.NullInt64{Int64: count, Valid: true}
+			recordOldRecords(table.table, int(count))
+		}
+
+		log.Info().
+			Str(tableName, table.table).
+			Int64("estimate", estimate.Estimate.Int64).
+			Bool("estimate valid", estimate.Estimate.Valid).
+			Msg(table.category)
+		estimates = append(estimates, estimate)
+	}
+	return estimates, nil
+}
+
+// formatEstimate function formats estimate or count that might not be known
+func formatEstimate(value sql.NullInt64) string {
+	if !value.Valid {
+		return unknownEstimate
+	}
+	return strconv.FormatInt(value.Int64, 10)
+}
+
+// PrintOldRecordsEstimates function displays a table with estimated number
+// of old records in each category. Exact number of records is displayed when
+// exact flag is set.
+func PrintOldRecordsEstimates(estimates []OldRecordsEstimate, exact bool) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	header := []string{"Category", "Table", "Estimate"}
+	if exact {
+		header = append(header, "Count")
+	}
+	table.SetHeader(header)
+
+	for _, estimate := range estimates {
+		row := []string{estimate.Category, estimate.Table, formatEstimate(estimate.Estimate)}
+		if exact {
+			row = append(row, formatEstimate(estimate.Count))
+		}
+		table.Append(row)
+	}
+
+	// display the whole table
+	table.Render()
+}
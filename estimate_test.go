@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/estimate_test.html
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// queries expected by estimate tests
+const (
+	expectedEstimateQuery = "SELECT c.reltuples::BIGINT"
+	expectedCountQuery    = "SELECT COUNT\\(\\*\\) FROM dvo_report WHERE reported_at < NOW\\(\\) - \\$1::INTERVAL"
+)
+
+// statisticsColumns contains columns returned by estimate query
+var statisticsColumns = []string{"reltuples", "null_frac", "selected", "total"}
+
+// TestHistogramFraction checks estimate of fraction of selected rows from
+// histogram bounds
+func TestHistogramFraction(t *testing.T) {
+	assert.Equal(t, 0.0, cleaner.HistogramFraction(0, 0))
+	assert.Equal(t, 0.0, cleaner.HistogramFraction(1, 1))
+	assert.Equal(t, 0.0, cleaner.HistogramFraction(0, 101))
+	assert.Equal(t, 0.005, cleaner.HistogramFraction(1, 101))
+	assert.Equal(t, 0.495, cleaner.HistogramFraction(50, 101))
+	assert.Equal(t, 1.0, cleaner.HistogramFraction(101, 101))
+}
+
+// TestEstimateRows checks estimate of number of selected rows
+func TestEstimateRows(t *testing.T) {
+	// table has not been analyzed yet
+	assert.Equal(t, sql.NullInt64{}, cleaner.EstimateRows(-1, 0, 10, 101))
+
+	// histogram is not available
+	assert.Equal(t, sql.NullInt64{}, cleaner.EstimateRows(1000, 0, 0, 0))
+
+	assert.Equal(t, sql.NullInt64{Int64: 495, Valid: true}, cleaner.EstimateRows(1000, 0, 50, 101))
+	assert.Equal(t, sql.NullInt64{Int64: 500, Valid: true}, cleaner.EstimateRows(1000, 0.5, 101, 101))
+	assert.Equal(t, sql.NullInt64{Int64: 0, Valid: true}, cleaner.EstimateRows(1000, 0, 0, 101))
+}
+
+// TestEstimateOldRecords checks that old records are estimated from planner
+// statistics and counted when requested
+func TestEstimateOldRecords(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedEstimateQuery).
+		WithArgs("dvo", "dvo_report", "reported_at", "90 days").
+		WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(1000, 0, 50, 101))
+	mock.ExpectQuery(expectedEstimateQuery).
+		WithArgs("dvo", "dvo_report", "reported_at", "90 days").
+		WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(-1, 0, 0, 0))
+	mock.ExpectQuery(expectedCountQuery).
+		WithArgs("90 days").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(510))
+	mock.ExpectClose()
+
+	// estimate only
+	estimates, err := cleaner.EstimateOldRecords(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, cleaner.ListingFilter{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, estimates, 1)
+	assert.Equal(t, "dvo_report", estimates[0].Table)
+	assert.Equal(t, sql.NullInt64{Int64: 495, Valid: true}, estimates[0].Estimate)
+	assert.False(t, estimates[0].Count.Valid)
+
+	// estimate is not available, but exact count is
+	estimates, err = cleaner.EstimateOldRecords(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, cleaner.ListingFilter{}, true)
+	assert.NoError(t, err)
+	assert.Len(t, estimates, 1)
+	assert.False(t, estimates[0].Estimate.Valid)
+	assert.Equal(t, sql.NullInt64{Int64: 510, Valid: true}, estimates[0].Count)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestEstimateOldRecordsReportInfo checks that report info without own
+// timestamp is estimated from statistics of reports it belongs to
+func TestEstimateOldRecordsReportInfo(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	for _, table := range []string{"report", "advisor_ratings", "consumer_error", "recommendation"} {
+		mock.ExpectQuery(expectedEstimateQuery).
+			WithArgs("public", table, sqlmock.AnyArg(), "90 days").
+			WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(-1, 0, 0, 0))
+	}
+	mock.ExpectQuery(expectedEstimateQuery).
+		WithArgs("public", "report", "reported_at", "90 days").
+		WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(1000, 0, 50, 101))
+	mock.ExpectClose()
+
+	estimates, err := cleaner.EstimateOldRecords(connection, "90 days",
+		cleaner.DBSchemaOCPRecommendations, cleaner.ListingFilter{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, estimates, 5)
+	assert.Equal(t, "Old report info", estimates[4].Category)
+	assert.Equal(t, "report_info", estimates[4].Table)
+	assert.Equal(t, sql.NullInt64{Int64: 495, Valid: true}, estimates[4].Estimate)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestEstimateOldRecordsOnError checks that errors are reported by
+// estimateOldRecords function
+func TestEstimateOldRecordsOnError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedEstimateQuery).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	_, err = cleaner.EstimateOldRecords(nil, "90 days",
+		cleaner.DBSchemaDVORecommendations, cleaner.ListingFilter{}, false)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	_, err = cleaner.EstimateOldRecords(connection, "90 days", "unknown", cleaner.ListingFilter{}, false)
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &invalidSchemaErr)
+
+	_, err = cleaner.EstimateOldRecords(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, cleaner.ListingFilter{}, false)
+	assert.ErrorIs(t, err, mockedError)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDisplayOldRecordsEstimates checks that both estimate and exact count
+// are displayed
+func TestDisplayOldRecordsEstimates(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery(expectedEstimateQuery).
+		WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(1000, 0, 50, 101))
+	mock.ExpectQuery(expectedCountQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(510))
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{}
+	configuration.Cleaner.MaxAge = "90 days"
+	cliFlags := cleaner.CliFlags{Estimate: true, ExactCount: true}
+
+	output, err := capture.StandardOutput(func() {
+		status, err := cleaner.DisplayOldRecordsEstimates(&configuration, connection, cliFlags,
+			cleaner.DBSchemaDVORecommendations)
+		assert.NoError(t, err)
+		assert.Equal(t, cleaner.ExitStatusOK, status)
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, output, "ESTIMATE")
+	assert.Contains(t, output, "495")
+	assert.Contains(t, output, "510")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
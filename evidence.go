@@ -0,0 +1,260 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/evidence.html
+
+// This source file contains implementation of deletion evidence report. After
+// cleanup of selected clusters (account erasure) a JSON document with list of
+// tables, number of deleted rows, timestamps, and hash of configuration is
+// written into a file. The document is signed by private key specified in
+// configuration and the detached signature (encoded by Base64) is written
+// into a file with .sig suffix, so downstream compliance tooling can verify
+// that the evidence was not altered.
+//
+// Ed25519 keys are used to sign the document directly, RSA and ECDSA keys are
+// used to sign its SHA-256 digest (RSA keys use PKCS #1 v1.5 padding).
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Constants used by deletion evidence report
+const (
+	signatureSuffix        = ".sig"
+	writeEvidenceMsg       = "Write deletion evidence"
+	evidenceWrittenMsg     = "Deletion evidence written"
+	signatureAlgorithmAttr = "algorithm"
+)
+
+// Signature algorithms that can be used to sign the evidence
+const (
+	signatureEd25519     = "Ed25519"
+	signatureRSASHA256   = "RSA-PKCS1v15-SHA256"
+	signatureECDSASHA256 = "ECDSA-SHA256"
+)
+
+// TableEvidence represents number of rows deleted from one table
+type TableEvidence struct {
+	Table       string `json:"table"`
+	DeletedRows int    `json:"deleted_rows"`
+}
+
+// DeletionEvidence represents the evidence document written after cleanup
+type DeletionEvidence struct {
+	RunID              string          `json:"run_id"`
+	RequestedBy        string          `json:"requested_by"`
+	Schema             string          `json:"schema"`
+	Clusters           int             `json:"clusters"`
+	FailedClusters     int             `json:"failed_clusters,omitempty"`
+	StartedAt          string          `json:"started_at"`
+	FinishedAt         string          `json:"finished_at"`
+	ConfigHash         string          `json:"config_hash"`
+	SignatureAlgorithm string          `json:"signature_algorithm"`
+	Tables             []TableEvidence `json:"tables"`
+	Reconciliation     *Reconciliation `json:"reconciliation,omitempty"`
+}
+
+// newDeletionEvidence function prepares evidence document from results of
+// cleanup operation. Tables are sorted by name so the document is stable.
+func newDeletionEvidence(configuration *ConfigStruct, summary Summary, schema string,
+	started, finished time.Time) (DeletionEvidence, error) {
+	configHash, err := configurationHash(configuration)
+	if err != nil {
+		return DeletionEvidence{}, err
+	}
+
+	evidence := DeletionEvidence{
+		RunID:          summary.RunID,
+		RequestedBy:    summary.RequestedBy,
+		Schema:         schema,
+		Clusters:       summary.ProperClusterEntries,
+		FailedClusters: summary.FailedClusterEntries,
+		StartedAt:      started.UTC().Format(time.RFC3339Nano),
+		FinishedAt:     finished.UTC().Format(time.RFC3339Nano),
+		ConfigHash:     configHash,
+		Tables:         []TableEvidence{},
+		Reconciliation: summary.Reconciliation,
+	}
+
+	for table, deletions := range summary.DeletionsForTable {
+		evidence.Tables = append(evidence.Tables, TableEvidence{
+			Table:       table,
+			DeletedRows: deletions,
+		})
+	}
+	sort.Slice(evidence.Tables, func(i, j int) bool {
+		return evidence.Tables[i].Table < evidence.Tables[j].Table
+	})
+
+	return evidence, nil
+}
+
+// configurationHash function computes SHA-256 hash of configuration. The
+// database password, S3 secret key, and cluster ID salt are not part of the
+// hash.
+func configurationHash(configuration *ConfigStruct) (string, error) {
+	config := *configuration
+	config.Storage.PGPassword = ""
+	config.Output.S3.SecretAccessKey = ""
+	config.Output.ClusterIDSalt = ""
+
+	serialized, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(serialized)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// readPrivateKey function reads private key in PEM format from given file.
+// PKCS #8, PKCS #1 (RSA), and SEC 1 (EC) encodings are supported.
+func readPrivateKey(filename string) (crypto.Signer, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	content, err := os.ReadFile(filename) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("private key is not in PEM format")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// signatureAlgorithm function returns name of algorithm used to sign data
+// by given key
+func signatureAlgorithm(key crypto.Signer) (string, error) {
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		return signatureEd25519, nil
+	case *rsa.PrivateKey:
+		return signatureRSASHA256, nil
+	case *ecdsa.PrivateKey:
+		return signatureECDSASHA256, nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// signData function signs given data by private key
+func signData(key crypto.Signer, data []byte) ([]byte, error) {
+	// Ed25519 signs the whole message
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// writeDeletionEvidence function writes evidence document into file
+// specified in configuration together with its detached signature
+func writeDeletionEvidence(evidenceConfig EvidenceConfiguration, evidence DeletionEvidence) error {
+	key, err := readPrivateKey(evidenceConfig.PrivateKey)
+	if err != nil {
+		log.Error().Err(err).Msg(writeEvidenceMsg)
+		return err
+	}
+
+	evidence.SignatureAlgorithm, err = signatureAlgorithm(key)
+	if err != nil {
+		log.Error().Err(err).Msg(writeEvidenceMsg)
+		return err
+	}
+
+	document, err := json.MarshalIndent(evidence, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg(writeEvidenceMsg)
+		return err
+	}
+	document = append(document, '\n')
+
+	signature, err := signData(key, document)
+	if err != nil {
+		log.Error().Err(err).Msg(writeEvidenceMsg)
+		return err
+	}
+
+	// evidence is written atomically the same way as listings
+	out, err := createOutputFile(evidenceConfig.File, false)
+	if err == nil {
+		_, err = out.Writer().Write(document)
+		if err == nil {
+			err = out.Writer().Flush()
+		}
+		closeErr := out.Close(err == nil)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, evidenceConfig.File).Msg(writeEvidenceMsg)
+		return err
+	}
+
+	// disable G306 (CWE-276): Expect WriteFile permissions to be 0600 or less
+	err = os.WriteFile(evidenceConfig.File+signatureSuffix,
+		[]byte(base64.StdEncoding.EncodeToString(signature)+"\n"), 0644) // #nosec G306
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, evidenceConfig.File).Msg(writeEvidenceMsg)
+		return err
+	}
+
+	log.Info().
+		Str(filenameAttribute, evidenceConfig.File).
+		Str(signatureAlgorithmAttr, evidence.SignatureAlgorithm).
+		Msg(evidenceWrittenMsg)
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/evidence_test.html
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// writePrivateKey function writes private key in PEM format into temporary
+// file
+func writePrivateKey(t *testing.T, blockType string, der []byte) string {
+	filename := filepath.Join(t.TempDir(), "key.pem")
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+
+	err := os.WriteFile(filename, content, 0600)
+	assert.NoError(t, err)
+
+	return filename
+}
+
+// testEvidence function prepares evidence document used by tests
+func testEvidence(t *testing.T) cleaner.DeletionEvidence {
+	configuration := cleaner.ConfigStruct{}
+	summary := cleaner.Summary{
+		RunID:                "run",
+		RequestedBy:          "operator",
+		ProperClusterEntries: 2,
+		DeletionsForTable: map[string]int{
+			"report":        2,
+			"cluster_rules": 0,
+		},
+	}
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	evidence, err := cleaner.NewDeletionEvidence(&configuration, summary,
+		cleaner.DBSchemaOCPRecommendations, started, started.Add(time.Second))
+	assert.NoError(t, err)
+
+	return evidence
+}
+
+// TestNewDeletionEvidence checks content of evidence document
+func TestNewDeletionEvidence(t *testing.T) {
+	evidence := testEvidence(t)
+
+	assert.Equal(t, "run", evidence.RunID)
+	assert.Equal(t, "operator", evidence.RequestedBy)
+	assert.Equal(t, cleaner.DBSchemaOCPRecommendations, evidence.Schema)
+	assert.Equal(t, 2, evidence.Clusters)
+	assert.Equal(t, "2024-01-02T03:04:05Z", evidence.StartedAt)
+	assert.Equal(t, "2024-01-02T03:04:06Z", evidence.FinishedAt)
+	assert.NotEmpty(t, evidence.ConfigHash)
+
+	// tables need to be sorted
+	expected := []cleaner.TableEvidence{
+		{Table: "cluster_rules", DeletedRows: 0},
+		{Table: "report", DeletedRows: 2},
+	}
+	assert.Equal(t, expected, evidence.Tables)
+}
+
+// TestConfigurationHashIgnoresPassword checks that database password is not
+// part of configuration hash
+func TestConfigurationHashIgnoresPassword(t *testing.T) {
+	config1 := cleaner.ConfigStruct{}
+	config1.Storage.PGPassword = "foo"
+	config2 := cleaner.ConfigStruct{}
+	config2.Storage.PGPassword = "bar"
+	config3 := cleaner.ConfigStruct{}
+	config3.Storage.PGUsername = "bar"
+
+	hash1, err := cleaner.ConfigurationHash(&config1)
+	assert.NoError(t, err)
+	hash2, err := cleaner.ConfigurationHash(&config2)
+	assert.NoError(t, err)
+	hash3, err := cleaner.ConfigurationHash(&config3)
+	assert.NoError(t, err)
+
+	assert.Equal(t, hash1, hash2)
+	assert.NotEqual(t, hash1, hash3)
+
+	// password must not be changed in the original configuration
+	assert.Equal(t, "foo", config1.Storage.PGPassword)
+}
+
+// readEvidence function reads evidence document and its signature
+func readEvidence(t *testing.T, filename string) ([]byte, []byte) {
+	document, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	encoded, err := os.ReadFile(filename + ".sig")
+	assert.NoError(t, err)
+
+	signature, err := base64.StdEncoding.DecodeString(string(encoded))
+	assert.NoError(t, err)
+
+	return document, signature
+}
+
+// TestWriteDeletionEvidenceEd25519 checks evidence signed by Ed25519 key
+func TestWriteDeletionEvidenceEd25519(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	evidenceConfig := cleaner.EvidenceConfiguration{
+		File:       filepath.Join(t.TempDir(), "evidence.json"),
+		PrivateKey: writePrivateKey(t, "PRIVATE KEY", der),
+	}
+
+	err = cleaner.WriteDeletionEvidence(evidenceConfig, testEvidence(t))
+	assert.NoError(t, err)
+
+	document, signature := readEvidence(t, evidenceConfig.File)
+	assert.True(t, ed25519.Verify(publicKey, document, signature))
+
+	var evidence cleaner.DeletionEvidence
+	err = json.Unmarshal(document, &evidence)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ed25519", evidence.SignatureAlgorithm)
+	assert.Equal(t, "run", evidence.RunID)
+}
+
+// TestWriteDeletionEvidenceRSA checks evidence signed by RSA key
+func TestWriteDeletionEvidenceRSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	evidenceConfig := cleaner.EvidenceConfiguration{
+		File:       filepath.Join(t.TempDir(), "evidence.json"),
+		PrivateKey: writePrivateKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey)),
+	}
+
+	err = cleaner.WriteDeletionEvidence(evidenceConfig, testEvidence(t))
+	assert.NoError(t, err)
+
+	document, signature := readEvidence(t, evidenceConfig.File)
+	digest := sha256.Sum256(document)
+	err = rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature)
+	assert.NoError(t, err)
+}
+
+// TestWriteDeletionEvidenceWrongKey checks error handling when private key
+// can not be read
+func TestWriteDeletionEvidenceWrongKey(t *testing.T) {
+	directory := t.TempDir()
+	evidenceConfig := cleaner.EvidenceConfiguration{
+		File:       filepath.Join(directory, "evidence.json"),
+		PrivateKey: filepath.Join(directory, "key.pem"),
+	}
+
+	// key does not exist
+	err := cleaner.WriteDeletionEvidence(evidenceConfig, testEvidence(t))
+	assert.Error(t, err)
+
+	// key is not in PEM format
+	err = os.WriteFile(evidenceConfig.PrivateKey, []byte("foo"), 0600)
+	assert.NoError(t, err)
+	err = cleaner.WriteDeletionEvidence(evidenceConfig, testEvidence(t))
+	assert.Error(t, err)
+
+	// evidence must not be written
+	assert.NoFileExists(t, evidenceConfig.File)
+	assert.NoFileExists(t, evidenceConfig.File+".sig")
+}
+
+// TestReadPrivateKeyWrongContent checks that improper private key is
+// rejected
+func TestReadPrivateKeyWrongContent(t *testing.T) {
+	_, err := cleaner.ReadPrivateKey(writePrivateKey(t, "PRIVATE KEY", []byte("foo")))
+	assert.Error(t, err)
+}
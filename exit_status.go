@@ -0,0 +1,217 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/exit_status.html
+
+// This source file contains definition of exit statuses of the tool. Each
+// exit status has its name and description, so the whole catalog can be
+// displayed by -list-exit-codes command line option and it is printed
+// together with help as well. Wrapper scripts can therefore find out meaning
+// of exit status without hard-coding numbers.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ExitStatus represents exit status of the tool. All operations return one
+// of exit statuses defined below.
+type ExitStatus int
+
+// Exit statuses of the tool
+const (
+	// ExitStatusOK means that the tool finished with success
+	ExitStatusOK ExitStatus = iota
+
+	// ExitStatusStorageError is returned in case of any storage-related
+	// error
+	ExitStatusStorageError
+
+	// ExitStatusFillInStorageError is returned in case the fill-in DB
+	// operation failed
+	ExitStatusFillInStorageError
+
+	// ExitStatusPerformCleanupError is returned when DB cleanup operation
+	// failed for any reason
+	ExitStatusPerformCleanupError
+
+	// ExitStatusPerformVacuumError is returned when DB vacuuming operation
+	// have failed for any reason
+	ExitStatusPerformVacuumError
+
+	// ExitStatusEvidenceError is returned when deletion evidence could not
+	// be written or signed
+	ExitStatusEvidenceError
+
+	// ExitStatusTooManyRecords is returned when listing finds more old
+	// records than specified by -fail-if-more-than
+	ExitStatusTooManyRecords
+
+	// ExitStatusNoRecords is returned when listing does not find any old
+	// record and -fail-if-none is specified
+	ExitStatusNoRecords
+
+	// ExitStatusOutsideWindow is returned when destructive operation is
+	// started outside maintenance window and -force is not specified
+	ExitStatusOutsideWindow
+
+	// ExitStatusFingerprintMismatch is returned when database fingerprint
+	// does not match fingerprint specified in configuration
+	ExitStatusFingerprintMismatch
+
+	// ExitStatusReadOnlyViolation is returned when operation tries to
+	// modify data in read-only mode
+	ExitStatusReadOnlyViolation
+
+	// ExitStatusNotAuthorized is returned when identity that triggered
+	// the run is not allowed to perform selected operation
+	ExitStatusNotAuthorized
+
+	// ExitStatusNotConfirmed is returned when destructive operation is not
+	// confirmed by names of database and DB schema from configuration or
+	// when deletion of one cluster records is not confirmed interactively
+	ExitStatusNotConfirmed
+
+	// ExitStatusConfigError is returned when configuration can not be
+	// loaded or when it is not valid
+	ExitStatusConfigError
+
+	// ExitStatusUnsupportedMigration is returned when database has been
+	// migrated by newer version of aggregator than the cleaner supports
+	ExitStatusUnsupportedMigration
+
+	// ExitStatusUndefinedTable is returned when table accessed by the
+	// operation does not exist in database
+	ExitStatusUndefinedTable
+
+	// ExitStatusInsufficientPrivilege is returned when database user is not
+	// allowed to perform statement needed by the operation or when
+	// privilege preflight check finds missing grants
+	ExitStatusInsufficientPrivilege
+
+	// ExitStatusForeignKeyViolation is returned when records can not be
+	// deleted because they are still referenced from another table
+	ExitStatusForeignKeyViolation
+
+	// ExitStatusLockNotAvailable is returned when table or rows needed by
+	// the operation are locked by another session
+	ExitStatusLockNotAvailable
+
+	// ExitStatusCanaryFailed is returned when canary cleanup of the first
+	// cluster from cluster list fails or does not delete any row
+	ExitStatusCanaryFailed
+
+	// ExitStatusStragglersFound is returned when rows of cleaned up
+	// clusters remained in database after cleanup and deletion
+	// verification
+	ExitStatusStragglersFound
+)
+
+// exitStatusDescription represents name and description of exit status
+type exitStatusDescription struct {
+	name        string
+	description string
+}
+
+// exitStatusDescriptions contains name and description for each exit status,
+// indexed by the exit status
+var exitStatusDescriptions = []exitStatusDescription{
+	ExitStatusOK:                    {"ok", "the tool finished with success"},
+	ExitStatusStorageError:          {"storage-error", "storage-related error"},
+	ExitStatusFillInStorageError:    {"fill-in-storage-error", "fill-in DB operation failed"},
+	ExitStatusPerformCleanupError:   {"perform-cleanup-error", "DB cleanup operation failed for any reason"},
+	ExitStatusPerformVacuumError:    {"perform-vacuum-error", "DB vacuuming operation failed for any reason"},
+	ExitStatusEvidenceError:         {"evidence-error", "deletion evidence could not be written or signed"},
+	ExitStatusTooManyRecords:        {"too-many-records", "listing found more old records than allowed by -fail-if-more-than"},
+	ExitStatusNoRecords:             {"no-records", "listing did not find any old record and -fail-if-none is used"},
+	ExitStatusOutsideWindow:         {"outside-window", "destructive operation started outside maintenance window without -force"},
+	ExitStatusFingerprintMismatch:   {"fingerprint-mismatch", "database fingerprint does not match fingerprint specified in configuration"},
+	ExitStatusReadOnlyViolation:     {"read-only-violation", "operation tried to modify data in read-only mode"},
+	ExitStatusNotAuthorized:         {"not-authorized", "identity is not allowed to perform selected operation"},
+	ExitStatusNotConfirmed:          {"not-confirmed", "destructive operation or deletion of cluster selected by -cluster is not confirmed"},
+	ExitStatusConfigError:           {"config-error", "configuration can not be loaded or it is not valid"},
+	ExitStatusUnsupportedMigration:  {"unsupported-migration", "database has been migrated by newer version of aggregator"},
+	ExitStatusUndefinedTable:        {"undefined-table", "table accessed by the operation does not exist"},
+	ExitStatusInsufficientPrivilege: {"insufficient-privilege", "database user is not allowed to perform statement needed by the operation"},
+	ExitStatusForeignKeyViolation:   {"foreign-key-violation", "records are still referenced from another table"},
+	ExitStatusLockNotAvailable:      {"lock-not-available", "table or rows needed by the operation are locked by another session"},
+	ExitStatusCanaryFailed:          {"canary-failed", "canary cleanup of the first cluster failed or did not delete any row"},
+	ExitStatusStragglersFound:       {"stragglers-found", "rows of cleaned up clusters remained in database after deletion verification"},
+}
+
+// String method returns name of exit status
+func (s ExitStatus) String() string {
+	if s < 0 || int(s) >= len(exitStatusDescriptions) {
+		return fmt.Sprintf("exit-status-%d", int(s))
+	}
+	return exitStatusDescriptions[s].name
+}
+
+// Describe method returns human readable description of exit status
+func (s ExitStatus) Describe() string {
+	if s < 0 || int(s) >= len(exitStatusDescriptions) {
+		return "unknown exit status"
+	}
+	return exitStatusDescriptions[s].description
+}
+
+// exitStatuses function returns all defined exit statuses
+func exitStatuses() []ExitStatus {
+	statuses := make([]ExitStatus, len(exitStatusDescriptions))
+	for i := range statuses {
+		statuses[i] = ExitStatus(i)
+	}
+	return statuses
+}
+
+// writeExitStatuses function writes catalog of all exit statuses, one exit
+// status per line
+func writeExitStatuses(writer io.Writer) error {
+	for _, status := range exitStatuses() {
+		_, err := fmt.Fprintf(writer, "%3d  %-23s %s\n", int(status), status, status.Describe())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listExitCodes function displays catalog of all exit statuses
+func listExitCodes() (ExitStatus, error) {
+	err := writeExitStatuses(os.Stdout)
+	if err != nil {
+		log.Err(err).Msg("List exit codes")
+		return ExitStatusStorageError, err
+	}
+	return ExitStatusOK, nil
+}
+
+// usage function displays help for all command line options followed by
+// catalog of all exit statuses
+func usage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintln(output, "\nExit statuses:")
+	_ = writeExitStatuses(output)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/exit_status_test.html
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestExitStatusNames checks names and descriptions of exit statuses
+func TestExitStatusNames(t *testing.T) {
+	assert.Equal(t, "ok", cleaner.ExitStatusOK.String())
+	assert.Equal(t, "storage-error", cleaner.ExitStatusStorageError.String())
+	assert.Equal(t, "lock-not-available", cleaner.ExitStatusLockNotAvailable.String())
+	assert.Equal(t, "the tool finished with success", cleaner.ExitStatusOK.Describe())
+
+	// unknown exit statuses
+	assert.Equal(t, "exit-status-42", cleaner.ExitStatus(42).String())
+	assert.Equal(t, "exit-status--1", cleaner.ExitStatus(-1).String())
+	assert.Equal(t, "unknown exit status", cleaner.ExitStatus(42).Describe())
+}
+
+// TestWriteExitStatuses checks that catalog contains all exit statuses, each
+// of them with unique name
+func TestWriteExitStatuses(t *testing.T) {
+	var buffer bytes.Buffer
+	assert.NoError(t, cleaner.WriteExitStatuses(&buffer))
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	assert.Len(t, lines, int(cleaner.ExitStatusStragglersFound)+1)
+	assert.Equal(t, "  0  ok                      the tool finished with success", lines[0])
+
+	names := make(map[string]struct{})
+	for status := cleaner.ExitStatusOK; status <= cleaner.ExitStatusStragglersFound; status++ {
+		assert.NotContains(t, status.String(), "exit-status-")
+		assert.NotEqual(t, "unknown exit status", status.Describe())
+		names[status.String()] = struct{}{}
+	}
+	assert.Len(t, names, len(lines), "names of exit statuses need to be unique")
+}
+
+// TestListExitCodes checks that catalog of exit statuses is displayed by
+// -list-exit-codes
+func TestListExitCodes(t *testing.T) {
+	var status cleaner.ExitStatus
+	var listErr error
+	output, err := capture.StandardOutput(func() {
+		status, listErr = cleaner.DoSelectedOperation(&cleaner.ConfigStruct{}, nil,
+			cleaner.CliFlags{ListExitCodes: true})
+	})
+	checkCapture(t, err)
+
+	assert.NoError(t, listErr)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.Contains(t, output, " 13  config-error")
+}
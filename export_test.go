@@ -34,39 +34,354 @@ var (
 
 	// functions from the storage.go source file
 	ReadOrgID                         = readOrgID
+	QueryRows                         = queryRows
 	DisplayMultipleRuleDisable        = displayMultipleRuleDisable
 	DisplayAllOldRecords              = displayAllOldRecords
 	PerformDisplayMultipleRuleDisable = performDisplayMultipleRuleDisable
+	DisplayDVONamespaceStatistics     = displayDVONamespaceStatistics
+	ReadTableSizes                    = readTableSizes
+	CountOldRecords                   = countOldRecords
+	CountFutureDatedRecords           = countFutureDatedRecords
 	PerformListOfOldOCPReports        = performListOfOldOCPReports
 	PerformListOfOldDVOReports        = performListOfOldDVOReports
 	PerformListOfOldRatings           = performListOfOldRatings
 	PerformListOfOldConsumerErrors    = performListOfOldConsumerErrors
+	PerformListOfOldRecommendations   = performListOfOldRecommendations
+	PerformListOfOldReportInfo        = performListOfOldReportInfo
 	DeleteRecordFromTable             = deleteRecordFromTable
+	DeleteRecordFromTableForOrg       = deleteRecordFromTableForOrg
+	PerformClustersCleanupInDB        = performClustersCleanupInDB
 	PerformCleanupInDB                = performCleanupInDB
 	PerformCleanupAllInDB             = performCleanupAllInDB
+	PerformMaxAgeComparisonInDB       = performMaxAgeComparisonInDB
+	PerformRuleCleanupInDB            = performRuleCleanupInDB
+	PerformRatingsCleanupInDB         = performRatingsCleanupInDB
+	PerformPayloadCompactionInDB      = performPayloadCompactionInDB
 	PerformVacuumDB                   = performVacuumDB
+	PerformVacuumFullInDB             = performVacuumFullInDB
 	FillInDatabaseByTestData          = fillInDatabaseByTestData
 	InitDatabaseConnection            = initDatabaseConnection
+	PostgresDataSource                = postgresDataSource
+	PingDatabase                      = pingDatabase
+	VerifyDatabaseFingerprint         = verifyDatabaseFingerprint
+	CloseDatabaseConnection           = closeDatabaseConnection
+
+	// functions from the aggregator.go source file
+	AggregatorEndpointURL         = aggregatorEndpointURL
+	ReadClusterListFromAggregator = readClusterListFromAggregator
+
+	// functions from the analyze.go source file
+	AnalyzedTableStatement     = analyzedTableStatement
+	DeletedFraction            = deletedFraction
+	AnalyzeTablesAfterDeletion = analyzeTablesAfterDeletion
+
+	// functions from the bloat.go source file
+	ReadTableBloat = readTableBloat
+	BloatReport    = bloatReport
+
+	// functions from the confirmation.go source file
+	CheckConfirmation = checkConfirmation
+
+	// functions from the coverage.go source file
+	RetentionPolicies        = retentionPolicies
+	ReadTableCoverage        = readTableCoverage
+	CoverageReport           = coverageReport
+	RetentionTimestampColumn = retentionTimestampColumn
+	RetentionDeleteStatement = retentionDeleteStatement
+	DiscoverPrunableTables   = discoverPrunableTables
+	WriteRetentionEntries    = writeRetentionEntries
+	DiscoverTables           = discoverTables
+
+	// functions from the cluster_list.go source file
+	NewClusterListProvider    = newClusterListProvider
+	NormalizeClusterID        = normalizeClusterID
+	NormalizeClusterList      = normalizeClusterList
+	ReadClusterList           = readClusterList
+	ReadClusterListFromQuery  = readClusterListFromQuery
+	ReadClusterListFromReader = readClusterListFromReader
 
 	// functions from the cleaner.go source file
 	ShowVersion                    = showVersion
 	ShowAuthors                    = showAuthors
 	ShowConfiguration              = showConfiguration
 	DoSelectedOperation            = doSelectedOperation
-	ReadClusterList                = readClusterList
+	ReadOnlyOperation              = readOnlyOperation
+	ReadRowCountsForSummary        = readRowCountsForSummary
+	NewSummary                     = newSummary
 	ReadClusterListFromFile        = readClusterListFromFile
 	ReadClusterListFromCLIArgument = readClusterListFromCLIArgument
 	VacuumDB                       = vacuumDB
 	Cleanup                        = cleanup
 	CleanupAll                     = cleanupAll
+	CleanupRule                    = cleanupRule
+	CleanupRatings                 = cleanupRatings
+	CompactPayloads                = compactPayloads
+	SizeSnapshot                   = sizeSnapshot
+	ParseRuleSelector              = parseRuleSelector
+	DVONamespaceStatistics         = dvoNamespaceStatistics
 	FillInDatabase                 = fillInDatabase
+	BulkFillInDatabase             = bulkFillInDatabase
 	DisplayOldRecords              = displayOldRecords
 	DetectMultipleRuleDisable      = detectMultipleRuleDisable
+	ParseAge                       = parseAge
+	ReadListingFilter              = readListingFilter
+	CheckListingThresholds         = checkListingThresholds
+	CheckConfirmedMaxAge           = checkConfirmedMaxAge
+	CheckConfirmedVacuumFull       = checkConfirmedVacuumFull
+	VacuumFull                     = vacuumFull
+	CompareMaxAge                  = compareMaxAge
+	ExportConsumerErrorsForReplay  = exportConsumerErrorsForReplay
+	DeleteExportedConsumerErrors   = deleteExportedConsumerErrors
+	ExportConsumerErrors           = exportConsumerErrors
+	CheckConsumerErrorOffsets      = checkConsumerErrorOffsets
+	DisplayOldRecordsCounts        = displayOldRecordsCounts
+	InstallDBSchedule              = installDBSchedule
+	DisplayOldRecordsEstimates     = displayOldRecordsEstimates
+	ShowDBSchedule                 = showDBSchedule
+	LoadAndCheckConfiguration      = loadAndCheckConfiguration
+
+	// functions from the output.go source file
+	CreateOutputFile        = createOutputFile
+	FormatTimestamp         = formatTimestamp
+	CheckTimeFormat         = checkTimeFormat
+	FormatAge               = formatAge
+	AppendSizeSnapshot      = appendSizeSnapshot
+	DisplayOldOCPReport     = displayOldOCPReport
+	DisplayOldDVOReport     = displayOldDVOReport
+	DisplayOldRating        = displayOldRating
+	DisplayOldConsumerError = displayOldConsumerError
+
+	// functions from the estimate.go source file
+	HistogramFraction  = histogramFraction
+	EstimateRows       = estimateRows
+	EstimateOldRecords = estimateOldRecords
+
+	// functions from the evidence.go source file
+	NewDeletionEvidence   = newDeletionEvidence
+	ConfigurationHash     = configurationHash
+	ReadPrivateKey        = readPrivateKey
+	WriteDeletionEvidence = writeDeletionEvidence
+
+	// functions from the consumer_errors.go source file
+	ParseKafkaLowWatermarks              = parseKafkaLowWatermarks
+	ReadConsumerErrorOffsets             = readConsumerErrorOffsets
+	DisplayConsumerErrorOffsets          = displayConsumerErrorOffsets
+	DeleteConsumerErrorsBeforeWatermarks = deleteConsumerErrorsBeforeWatermarks
+
+	// functions from the fill_in.go source file
+	BulkFillInDatabaseByTestData = bulkFillInDatabaseByTestData
+	SyntheticClusterName         = syntheticClusterName
+
+	// functions from the queries.go source file
+	RegisteredQueries          = registeredQueries
+	LookupQuery                = lookupQuery
+	WriteQueries               = writeQueries
+	ListQueries                = listQueries
+	DeleteByKeyStatement       = deleteByKeyStatement
+	DeleteByKeyAndOrgStatement = deleteByKeyAndOrgStatement
+
+	// functions from the reconciliation.go source file
+	ExcludeProtectedClusters = excludeProtectedClusters
+	ReconcileCleanup         = reconcileCleanup
+	WarnAboutAbsentClusters  = warnAboutAbsentClusters
+
+	// functions from the repack.go source file
+	RepackCommandArguments = repackCommandArguments
+	PerformRepackInDB      = performRepackInDB
+
+	// functions from the schedule.go source file
+	CronJobCommand        = cronJobCommand
+	CronJobNamePattern    = cronJobNamePattern
+	InstallCronJobsInDB   = installCronJobsInDB
+	UninstallCronJobsInDB = uninstallCronJobsInDB
+	ReadCronJobs          = readCronJobs
+
+	// functions from the schemas.go source file
+	CheckPluginSchemas      = checkPluginSchemas
+	RegisterPluginSchemas   = registerPluginSchemas
+	TablesAndKeysForSchema  = tablesAndKeysForSchema
+	TablesToDeleteForSchema = tablesToDeleteForSchema
+	DatabaseSchemaForSchema = databaseSchemaForSchema
+	CheckOCPTableSchema     = checkOCPTableSchema
+	RegisterOCPTableSchema  = registerOCPTableSchema
+	QualifyTableNames       = qualifyTableNames
+
+	// functions from the session_settings.go source file
+	SessionSettingsForOperation = sessionSettingsForOperation
+	RegisterSessionSettings     = registerSessionSettings
+
+	// functions from the statements.go source file
+	ExecStatement                  = execStatement
+	RedactParameters               = redactParameters
+	StatementTable                 = statementTable
+	StatementOperation             = statementOperation
+	RegisterSlowStatementThreshold = registerSlowStatementThreshold
+	RegisterStatementDialect       = registerStatementDialect
+	DialectStatement               = dialectStatement
+
+	// functions from the targets.go source file
+	TargetFileName      = targetFileName
+	TargetConfiguration = targetConfiguration
+	RunForAllTargets    = runForAllTargets
+
+	// functions from the transactions.go source file
+	IsRetryableTransactionError = isRetryableTransactionError
+	TransactionRetryDelay       = transactionRetryDelay
+
+	// functions from the errors.go source file
+	ExitStatusForError = exitStatusForError
+
+	// functions from the history.go source file
+	RecordRunHistory    = recordRunHistory
+	ReadRunHistory      = readRunHistory
+	ComputeDigest       = computeDigest
+	RenderDigest        = renderDigest
+	ProduceDigest       = produceDigest
+	ReadClusterOrgs     = readClusterOrgs
+	CountClustersForOrg = countClustersForOrg
+
+	// functions from the offset_cleanup.go source file
+	ParseKafkaOffsetRange          = parseKafkaOffsetRange
+	PerformKafkaOffsetsCleanupInDB = performKafkaOffsetsCleanupInDB
+	CleanupKafkaOffsets            = cleanupKafkaOffsets
+
+	// functions from the org_batch.go source file
+	ReadOrgIDsFromReader   = readOrgIDsFromReader
+	ReadOrgCheckpoint      = readOrgCheckpoint
+	AppendOrgCheckpoint    = appendOrgCheckpoint
+	OrgCheckpointFile      = orgCheckpointFile
+	ReadClustersForOrg     = readClustersForOrg
+	PerformOrgBatchErasure = performOrgBatchErasure
+	OrgBatch               = orgBatch
+
+	// functions from the payload_validation.go source file
+	PayloadValidity       = payloadValidity
+	ReadInvalidPayloads   = readInvalidPayloads
+	DeleteInvalidPayloads = deleteInvalidPayloads
+	ValidatePayloads      = validatePayloads
+
+	// functions from the identity.go source file
+	ResolveRequestedBy         = resolveRequestedBy
+	ReadServiceAccountIdentity = readServiceAccountIdentity
+
+	// functions from the kafka.go source file
+	ClusterFromKafkaMessage  = clusterFromKafkaMessage
+	ReadClusterListFromKafka = readClusterListFromKafka
+
+	// functions from the inventory.go source file
+	InventorySearch              = inventorySearch
+	ReadClusterListFromInventory = readClusterListFromInventory
+
+	// functions from the metrics.go source file
+	RecordDeletedRows    = recordDeletedRows
+	RecordOldRecords     = recordOldRecords
+	RecordRunFinished    = recordRunFinished
+	WriteMetricsTextfile = writeMetricsTextfile
+
+	// functions from the run_summary.go source file
+	OperationName = operationName
+	ErrorCount    = errorCount
+	LogRunSummary = logRunSummary
+
+	// functions from the anomalies.go source file
+	ReadTimestampAnomalies   = readTimestampAnomalies
+	DetectTimestampAnomalies = detectTimestampAnomalies
+
+	// functions from the authorization.go source file
+	OperationCategory  = operationCategory
+	CheckAuthorization = checkAuthorization
+
+	// functions from the window.go source file
+	ParseMaintenanceWindow = parseMaintenanceWindow
+	IsDestructiveOperation = isDestructiveOperation
+	CheckMaintenanceWindow = checkMaintenanceWindow
+
+	// functions from the state.go source file
+	ReadCleanerState         = readCleanerState
+	WriteCleanerState        = writeCleanerState
+	ReadCleanerStateDocument = readCleanerStateDocument
+	ImportRunHistory         = importRunHistory
+	ExportState              = exportState
+	ImportState              = importState
+
+	// functions from the simulation.go source file
+	CandidateRowsQuery     = candidateRowsQuery
+	ScratchTableReferences = scratchTableReferences
+	ScratchStatement       = scratchStatement
+	SimulateCleanupAllInDB = simulateCleanupAllInDB
+
+	// functions from the terminal.go source file
+	ColWidthForTerminal     = colWidthForTerminal
+	ConfigureTerminalOutput = configureTerminalOutput
+
+	// functions from the sink.go source file
+	CreateOutputSink = createOutputSink
+	ParseS3Output    = parseS3Output
+
+	// functions from the init_schema.go source file
+	InitDatabaseSchema = initDatabaseSchema
+	PrepareDatabase    = prepareDatabase
+
+	// functions from the config.go source file
+	ApplyClowderDatabaseConfiguration = applyClowderDatabaseConfiguration
+	SelectConfigurationFile           = selectConfigurationFile
+
+	// functions from the migrations.go source file
+	MigrateCleanerTables = migrateCleanerTables
+	ReadMigrations       = readMigrations
+
+	// functions from the migration_check.go source file
+	CheckMigrationVersion = checkMigrationVersion
+
+	// functions from the status.go source file
+	ReadCleanerStatus = readCleanerStatus
+
+	// functions from the cluster_chunks.go source file
+	ProcessClusterList = processClusterList
+
+	// functions from the cluster.go source file
+	CleanupSingleCluster   = cleanupSingleCluster
+	ConfirmClusterDeletion = confirmClusterDeletion
+
+	// functions from the deletion_matrix.go source file
+	WriteDeletionMatrix = writeDeletionMatrix
+
+	// functions from the watch.go source file
+	WatchRequests   = watchRequests
+	ParseS3Location = parseS3Location
+
+	// functions from the log_sampling.go source file
+	RegisterLogSampling = registerLogSampling
+
+	// functions from the privileges.go source file
+	RequiredPrivileges = requiredPrivileges
+	CheckPrivileges    = checkPrivileges
+
+	// functions from the exit_status.go source file
+	WriteExitStatuses = writeExitStatuses
+
+	// variables
+	DiscardSink             OutputSink = discardSink{}
+	RunID                              = &runID
+	TransactionRetryBackoff            = &transactionRetryBackoff
+	LogStatements                      = &logStatements
+	SummaryColors                      = &summaryColors
+	SummaryColWidth                    = &summaryColWidth
+	RunCommand                         = &runCommand
+	CaseInsensitiveMatch               = &caseInsensitiveMatch
+	NewKafkaConsumer                   = &newKafkaConsumer
+	ReadOnlyMode                       = &readOnlyMode
+	MigrationFiles                     = migrationFiles
+	ConfirmationInput                  = &confirmationInput
+	ConfirmationPrompt                 = &confirmationPrompt
+	RowSecurityTables                  = &rowSecurityTables
 
 	// constants
-	MaxAgeMissing     = maxAgeMissing
-	TablesToDeleteOCP = tablesToDeleteOCP
-	TablesToDeleteDVO = tablesToDeleteDVO
-	AllTablesToDelete = allTablesToDelete
-	EmptyJSON         = emptyJSON
+	MaxAgeNotConfirmed = maxAgeNotConfirmed
+	MaxAgeMissing      = maxAgeMissing
+	TablesToDeleteOCP  = tablesToDeleteOCP
+	TablesToDeleteDVO  = tablesToDeleteDVO
+	TablesToCompactOCP = tablesToCompactOCP
+	TablesToCompactDVO = tablesToCompactDVO
+	EmptyJSON          = emptyJSON
 )
@@ -0,0 +1,240 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/fill_in.html
+
+// This source file contains implementation of bulk fill-in of database by
+// synthetic data. It is meant to be used to prepare databases for benchmarks,
+// where millions of rows are needed and per-row INSERT statements would be too
+// slow. Rows are inserted by PostgreSQL COPY protocol in batches, each batch
+// is inserted in its own transaction. Progress is logged after each batch.
+//
+// Bulk fill-in is selected by -fill-in-clusters command line option used
+// together with -fill-in-db. Number of clusters inserted in one batch can be
+// changed by -fill-in-batch-size.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultFillInBatchSize is number of clusters inserted in one batch when
+// batch size is not specified
+const defaultFillInBatchSize = 10000
+
+// Number of organizations and spread of ages of synthetic records
+const (
+	syntheticOrganizations = 100
+	syntheticAgeDays       = 365
+)
+
+// copyTable represents one table filled-in by COPY protocol together with
+// function that returns values for given synthetic cluster
+type copyTable struct {
+	name    string
+	columns []string
+	values  func(cluster int, now time.Time) []interface{}
+}
+
+// syntheticClusterName function returns name of synthetic cluster with given
+// index. Names are stable, so repeated fill-in produces the same clusters.
+func syntheticClusterName(cluster int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012x", cluster)
+}
+
+// syntheticOrgID function returns organization ID for synthetic cluster
+func syntheticOrgID(cluster int) int {
+	return 1 + cluster%syntheticOrganizations
+}
+
+// syntheticTimestamp function returns timestamp of synthetic record. Ages of
+// records are spread over one year, so cleanup by max age can be benchmarked.
+func syntheticTimestamp(cluster int, now time.Time) time.Time {
+	return now.AddDate(0, 0, -(cluster % syntheticAgeDays))
+}
+
+// copyTablesOCP contains tables in OCP database filled-in by synthetic data
+var copyTablesOCP = []copyTable{
+	{
+		name:    "report",
+		columns: []string{"org_id", "cluster", "report", "reported_at", "last_checked_at", "kafka_offset"},
+		values: func(cluster int, now time.Time) []interface{} {
+			timestamp := syntheticTimestamp(cluster, now)
+			return []interface{}{syntheticOrgID(cluster), syntheticClusterName(cluster), "", timestamp, timestamp, cluster}
+		},
+	},
+	{
+		name:    "rule_hit",
+		columns: []string{"org_id", "cluster_id", "rule_fqdn", "error_key", "template_data"},
+		values: func(cluster int, _ time.Time) []interface{} {
+			return []interface{}{syntheticOrgID(cluster), syntheticClusterName(cluster), "foo", "bar", ""}
+		},
+	},
+}
+
+// copyTablesDVO contains tables in DVO database filled-in by synthetic data
+var copyTablesDVO = []copyTable{
+	{
+		name: dvoReportTable,
+		columns: []string{"org_id", "cluster_id", "namespace_id", "namespace_name", "report",
+			"recommendations", "objects", "reported_at", "last_checked_at", "rule_hits_count"},
+		values: func(cluster int, now time.Time) []interface{} {
+			timestamp := syntheticTimestamp(cluster, now)
+			return []interface{}{syntheticOrgID(cluster), syntheticClusterName(cluster),
+				syntheticClusterName(cluster), "synthetic", "", 1, 1, timestamp, timestamp, string(emptyJSON)}
+		},
+	},
+}
+
+// copyTablesForSchema function returns list of tables filled-in by
+// synthetic data for given DB schema
+func copyTablesForSchema(schema string) ([]copyTable, error) {
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		return copyTablesOCP, nil
+	case DBSchemaDVORecommendations:
+		return copyTablesDVO, nil
+	default:
+		return nil, invalidSchema(schema)
+	}
+}
+
+// copyInStatement function returns COPY statement for given table. OCP
+// tables are qualified by schema name when it is set. COPY protocol is not
+// supported by SQLite, so INSERT statement is returned for SQLite database.
+func copyInStatement(schema string, table copyTable) string {
+	if sqliteDialect {
+		placeholders := make([]string, len(table.columns))
+		for i := range table.columns {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.name,
+			strings.Join(table.columns, ", "), strings.Join(placeholders, ", "))
+	}
+	if schema == DBSchemaOCPRecommendations && ocpTableSchema != "" {
+		return pq.CopyInSchema(ocpTableSchema, table.name, table.columns...)
+	}
+	return pq.CopyIn(table.name, table.columns...)
+}
+
+// bulkFillInDatabaseByTestData function fills-in database by given number of
+// synthetic clusters (not to be used against production database). Clusters
+// are inserted in batches by COPY protocol, each batch in one transaction.
+func bulkFillInDatabaseByTestData(connection *sql.DB, schema string, clusters, batchSize int) error {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	if clusters <= 0 {
+		return errors.New("number of clusters to fill-in must be positive")
+	}
+
+	if batchSize <= 0 {
+		batchSize = defaultFillInBatchSize
+	}
+
+	tables, err := copyTablesForSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Int("clusters", clusters).
+		Int("batch size", batchSize).
+		Msg("Bulk fill-in database started")
+
+	started := time.Now()
+	for first := 0; first < clusters; first += batchSize {
+		last := first + batchSize
+		if last > clusters {
+			last = clusters
+		}
+
+		err := copyBatch(connection, schema, tables, first, last, started)
+		if err != nil {
+			log.Err(err).Int("first cluster", first).Msg("Bulk fill-in batch failed")
+			return err
+		}
+
+		log.Info().
+			Int("inserted clusters", last).
+			Int("clusters", clusters).
+			Str("progress", fmt.Sprintf("%.1f%%", 100*float64(last)/float64(clusters))).
+			Dur("elapsed", time.Since(started)).
+			Msg("Bulk fill-in progress")
+	}
+
+	log.Info().Dur("duration", time.Since(started)).Msg("Bulk fill-in database finished")
+	return nil
+}
+
+// copyBatch function inserts synthetic clusters with indexes in given range
+// into all given tables in one transaction
+func copyBatch(connection *sql.DB, schema string, tables []copyTable, first, last int, now time.Time) error {
+	tx, err := connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		err = copyRows(tx, schema, table, first, last, now)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+			}
+			return queryFailed(table.name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// copyRows function inserts synthetic clusters with indexes in given range
+// into one table by COPY protocol
+func copyRows(tx *sql.Tx, schema string, table copyTable, first, last int, now time.Time) error {
+	statement, err := tx.Prepare(copyInStatement(schema, table))
+	if err != nil {
+		return err
+	}
+
+	for cluster := first; cluster < last; cluster++ {
+		_, err = statement.Exec(table.values(cluster, now)...)
+		if err != nil {
+			_ = statement.Close()
+			return err
+		}
+	}
+
+	// all buffered rows are flushed by Exec without arguments
+	if !sqliteDialect {
+		_, err = statement.Exec()
+		if err != nil {
+			_ = statement.Close()
+			return err
+		}
+	}
+	return statement.Close()
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/fill_in_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// COPY statements expected by tests
+const (
+	expectedCopyReport    = `COPY "report" \("org_id", "cluster", "report", "reported_at", "last_checked_at", "kafka_offset"\) FROM STDIN`
+	expectedCopyRuleHit   = `COPY "rule_hit" \("org_id", "cluster_id", "rule_fqdn", "error_key", "template_data"\) FROM STDIN`
+	expectedCopyDVOReport = `COPY "dvo_report"`
+)
+
+// expectCopy function prepares expectations for COPY of synthetic clusters
+// with indexes in given range into one table
+func expectCopy(mock sqlmock.Sqlmock, statement string, first, last int) {
+	prepared := mock.ExpectPrepare(statement)
+	for cluster := first; cluster < last; cluster++ {
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	prepared.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, int64(last-first)))
+	prepared.WillBeClosed()
+}
+
+// TestSyntheticClusterName checks that synthetic cluster names are unique
+// and stable
+func TestSyntheticClusterName(t *testing.T) {
+	assert.Equal(t, "00000000-0000-4000-8000-000000000000", cleaner.SyntheticClusterName(0))
+	assert.Equal(t, "00000000-0000-4000-8000-0000000f4240", cleaner.SyntheticClusterName(1000000))
+	assert.NotEqual(t, cleaner.SyntheticClusterName(1), cleaner.SyntheticClusterName(2))
+}
+
+// TestBulkFillInOCPDatabase checks that synthetic clusters are inserted into
+// OCP database in batches, each batch in one transaction
+func TestBulkFillInOCPDatabase(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// the first batch
+	mock.ExpectBegin()
+	expectCopy(mock, expectedCopyReport, 0, 2)
+	expectCopy(mock, expectedCopyRuleHit, 0, 2)
+	mock.ExpectCommit()
+
+	// the second (shorter) batch
+	mock.ExpectBegin()
+	expectCopy(mock, expectedCopyReport, 2, 3)
+	expectCopy(mock, expectedCopyRuleHit, 2, 3)
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	// call the tested function
+	err = cleaner.BulkFillInDatabaseByTestData(connection, cleaner.DBSchemaOCPRecommendations, 3, 2)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestBulkFillInDVODatabase checks that synthetic clusters are inserted into
+// DVO database with qualified table names not affected by OCP table schema
+func TestBulkFillInDVODatabase(t *testing.T) {
+	registerOCPTableSchema(t, "aggregator")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectBegin()
+	expectCopy(mock, expectedCopyDVOReport, 0, 2)
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	// call the tested function (default batch size is used)
+	err = cleaner.BulkFillInDatabaseByTestData(connection, cleaner.DBSchemaDVORecommendations, 2, 0)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestBulkFillInOCPDatabaseWithTableSchema checks that OCP tables are
+// qualified by schema name in COPY statements
+func TestBulkFillInOCPDatabaseWithTableSchema(t *testing.T) {
+	registerOCPTableSchema(t, "aggregator")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectBegin()
+	expectCopy(mock, `COPY "aggregator"."report"`, 0, 1)
+	expectCopy(mock, `COPY "aggregator"."rule_hit"`, 0, 1)
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	// call the tested function
+	err = cleaner.BulkFillInDatabaseByTestData(connection, cleaner.DBSchemaOCPRecommendations, 1, 10)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestBulkFillInDatabaseRollback checks that batch is rolled back when COPY
+// fails
+func TestBulkFillInDatabaseRollback(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectBegin()
+	expectCopy(mock, expectedCopyReport, 0, 1)
+	mock.ExpectPrepare(expectedCopyRuleHit).WillReturnError(mockedError)
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	// call the tested function
+	err = cleaner.BulkFillInDatabaseByTestData(connection, cleaner.DBSchemaOCPRecommendations, 1, 1)
+	assert.ErrorIs(t, err, mockedError)
+
+	var queryErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "rule_hit", queryErr.Table)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestBulkFillInDatabaseErrors checks error handling of bulk fill-in
+func TestBulkFillInDatabaseErrors(t *testing.T) {
+	err := cleaner.BulkFillInDatabaseByTestData(nil, cleaner.DBSchemaOCPRecommendations, 1, 1)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+	mock.ExpectClose()
+
+	err = cleaner.BulkFillInDatabaseByTestData(connection, cleaner.DBSchemaOCPRecommendations, 0, 1)
+	assert.Error(t, err)
+
+	err = cleaner.BulkFillInDatabaseByTestData(connection, "unknown", 1, 1)
+	assert.Error(t, err)
+
+	status, err := cleaner.BulkFillInDatabase(nil, cleaner.CliFlags{FillInClusters: 1}, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+	assert.Equal(t, cleaner.ExitStatusFillInStorageError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,442 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/history.html
+
+// This source file contains implementation of run history and weekly digest.
+// When table option in [history] section of configuration file is set, each
+// cleanup run is recorded into that table (the table is created and migrated
+// at startup, see migrations.go). The -digest operation reads runs recorded during last week and
+// produces a roll-up with total number of rows deleted from each table, error
+// rate, and organizations with the most clusters cleaned up. The roll-up is
+// rendered in Markdown (default) or HTML so it can be posted to team channel.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// Constants used by run history and digest
+const (
+	// digestPeriod is length of period covered by digest
+	digestPeriod = 7 * 24 * time.Hour
+
+	// digestTopOrgs is max number of organizations displayed in digest
+	digestTopOrgs = 10
+
+	// DigestFormatMarkdown selects digest rendered in Markdown
+	DigestFormatMarkdown = "markdown"
+
+	// DigestFormatHTML selects digest rendered in HTML
+	DigestFormatHTML = "html"
+
+	recordRunHistoryMsg = "Record run history"
+)
+
+// SQL statements used by run history. Name of history table is inserted
+// into the statements as quoted identifier. The table itself is created by
+// migrations (see migrations.go).
+const (
+	insertHistoryStatement = `
+	    INSERT INTO %s (run_id, operation, target, started_at, finished_at,
+	                    exit_status, deletions, org_clusters, requested_by)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	selectHistoryStatement = `
+	    SELECT run_id, operation, target, started_at, finished_at,
+	           exit_status, deletions, org_clusters, requested_by
+	      FROM %s
+	     WHERE finished_at >= $1
+	     ORDER BY finished_at`
+)
+
+// RunHistoryEntry represents one cleanup run recorded in history table
+type RunHistoryEntry struct {
+	RunID     string `json:"run_id"`
+	Operation string `json:"operation"`
+	Target    string `json:"target"`
+	// RequestedBy is identity of operator or service that triggered the
+	// run (empty when it is not known)
+	RequestedBy string     `json:"requested_by"`
+	Started     time.Time  `json:"started_at"`
+	Finished    time.Time  `json:"finished_at"`
+	ExitStatus  ExitStatus `json:"exit_status"`
+	// DeletionsForTable contains number of rows deleted from each table
+	DeletionsForTable map[string]int `json:"deletions"`
+	// ClustersForOrg contains number of cleaned up clusters for each
+	// organization (when organization is known)
+	ClustersForOrg map[int]int `json:"org_clusters"`
+}
+
+// OrgDigest represents number of clusters cleaned up for one organization
+type OrgDigest struct {
+	OrgID    int
+	Clusters int
+}
+
+// TableDigest represents number of rows deleted from one table
+type TableDigest struct {
+	Table       string
+	DeletedRows int
+}
+
+// RunDigest represents roll-up of runs recorded in history table
+type RunDigest struct {
+	From       time.Time
+	To         time.Time
+	Runs       int
+	FailedRuns int
+	Tables     []TableDigest
+	Orgs       []OrgDigest
+}
+
+// ErrorRate method returns percentage of runs that did not finish with
+// success
+func (digest RunDigest) ErrorRate() float64 {
+	if digest.Runs == 0 {
+		return 0
+	}
+	return 100 * float64(digest.FailedRuns) / float64(digest.Runs)
+}
+
+// TotalDeletedRows method returns number of rows deleted from all tables
+func (digest RunDigest) TotalDeletedRows() int {
+	total := 0
+	for _, table := range digest.Tables {
+		total += table.DeletedRows
+	}
+	return total
+}
+
+// historyTableStatement function inserts quoted name of history table into
+// given statement
+func historyTableStatement(statement, table string) string {
+	return fmt.Sprintf(statement, pq.QuoteIdentifier(table))
+}
+
+// recordRunHistory function records cleanup run into history table. Nothing
+// is recorded when history table is not configured.
+func recordRunHistory(configuration *ConfigStruct, connection *sql.DB, entry RunHistoryEntry) error {
+	table := GetHistoryConfiguration(configuration).Table
+	if table == "" {
+		return nil
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	deletions, err := json.Marshal(entry.DeletionsForTable)
+	if err != nil {
+		return err
+	}
+	orgClusters, err := json.Marshal(entry.ClustersForOrg)
+	if err != nil {
+		return err
+	}
+
+	_, err = execStatement(connection, historyTableStatement(insertHistoryStatement, table),
+		entry.RunID, entry.Operation, entry.Target, entry.Started.UTC(), entry.Finished.UTC(),
+		entry.ExitStatus, string(deletions), string(orgClusters), entry.RequestedBy)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("table", table).
+		Str("operation", entry.Operation).
+		Int("exit status", int(entry.ExitStatus)).
+		Msg("Run recorded into history")
+	return nil
+}
+
+// recordRunHistoryOrWarn function records cleanup run into history table.
+// Problems with history are just logged, because they should not affect
+// result of cleanup that has been already performed.
+func recordRunHistoryOrWarn(configuration *ConfigStruct, connection *sql.DB, entry RunHistoryEntry) {
+	err := recordRunHistory(configuration, connection, entry)
+	if err != nil {
+		log.Warn().Err(err).Msg(recordRunHistoryMsg)
+	}
+}
+
+// readRunHistory function reads all runs recorded in history table that
+// finished after given time
+func readRunHistory(connection *sql.DB, table string, since time.Time) ([]RunHistoryEntry, error) {
+	var entries []RunHistoryEntry
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return entries, ErrNoConnection
+	}
+
+	query := historyTableStatement(selectHistoryStatement, table)
+	err := queryRows(connection, query, []interface{}{since.UTC()}, func(rows *sql.Rows) error {
+		var (
+			entry       RunHistoryEntry
+			deletions   string
+			orgClusters string
+		)
+		if err := rows.Scan(&entry.RunID, &entry.Operation, &entry.Target,
+			&entry.Started, &entry.Finished, &entry.ExitStatus,
+			&deletions, &orgClusters, &entry.RequestedBy); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(deletions), &entry.DeletionsForTable); err != nil {
+			return fmt.Errorf("improper deletions recorded for run %s: %w", entry.RunID, err)
+		}
+		if err := json.Unmarshal([]byte(orgClusters), &entry.ClustersForOrg); err != nil {
+			return fmt.Errorf("improper organizations recorded for run %s: %w", entry.RunID, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// computeDigest function rolls up given runs into digest. Organizations are
+// sorted by number of cleaned up clusters and only topOrgs of them are kept.
+func computeDigest(entries []RunHistoryEntry, from, to time.Time, topOrgs int) RunDigest {
+	digest := RunDigest{
+		From:   from,
+		To:     to,
+		Tables: []TableDigest{},
+		Orgs:   []OrgDigest{},
+	}
+
+	deletionsForTable := make(map[string]int)
+	clustersForOrg := make(map[int]int)
+
+	for _, entry := range entries {
+		digest.Runs++
+		if entry.ExitStatus != ExitStatusOK {
+			digest.FailedRuns++
+		}
+		for table, deleted := range entry.DeletionsForTable {
+			deletionsForTable[table] += deleted
+		}
+		for orgID, clusters := range entry.ClustersForOrg {
+			clustersForOrg[orgID] += clusters
+		}
+	}
+
+	for table, deleted := range deletionsForTable {
+		digest.Tables = append(digest.Tables, TableDigest{Table: table, DeletedRows: deleted})
+	}
+	sort.Slice(digest.Tables, func(i, j int) bool {
+		return digest.Tables[i].Table < digest.Tables[j].Table
+	})
+
+	for orgID, clusters := range clustersForOrg {
+		digest.Orgs = append(digest.Orgs, OrgDigest{OrgID: orgID, Clusters: clusters})
+	}
+	sort.Slice(digest.Orgs, func(i, j int) bool {
+		if digest.Orgs[i].Clusters != digest.Orgs[j].Clusters {
+			return digest.Orgs[i].Clusters > digest.Orgs[j].Clusters
+		}
+		return digest.Orgs[i].OrgID < digest.Orgs[j].OrgID
+	})
+	if len(digest.Orgs) > topOrgs {
+		digest.Orgs = digest.Orgs[:topOrgs]
+	}
+
+	return digest
+}
+
+// template functions shared by Markdown and HTML digests
+var digestFunctions = map[string]interface{}{
+	"date": func(t time.Time) string {
+		return t.UTC().Format(time.DateOnly)
+	},
+	"percent": func(value float64) string {
+		return fmt.Sprintf("%.1f %%", value)
+	},
+}
+
+// digestMarkdownTemplate is used to render digest in Markdown
+var digestMarkdownTemplate = template.Must(template.New("digest").Funcs(digestFunctions).Parse(
+	`## Cleaner weekly digest {{date .From}} – {{date .To}}
+
+* Runs: {{.Runs}}
+* Failed runs: {{.FailedRuns}}
+* Error rate: {{percent .ErrorRate}}
+* Deleted rows: {{.TotalDeletedRows}}
+
+### Rows deleted per table
+
+| Table | Deleted rows |
+|-------|-------------:|
+{{range .Tables}}| {{.Table}} | {{.DeletedRows}} |
+{{end}}
+### Largest organizations cleaned
+
+| Organization | Clusters |
+|--------------|---------:|
+{{range .Orgs}}| {{.OrgID}} | {{.Clusters}} |
+{{end}}`))
+
+// digestHTMLTemplate is used to render digest in HTML
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(digestFunctions).Parse(
+	`<h2>Cleaner weekly digest {{date .From}} – {{date .To}}</h2>
+<ul>
+<li>Runs: {{.Runs}}</li>
+<li>Failed runs: {{.FailedRuns}}</li>
+<li>Error rate: {{percent .ErrorRate}}</li>
+<li>Deleted rows: {{.TotalDeletedRows}}</li>
+</ul>
+<h3>Rows deleted per table</h3>
+<table>
+<tr><th>Table</th><th>Deleted rows</th></tr>
+{{range .Tables}}<tr><td>{{.Table}}</td><td>{{.DeletedRows}}</td></tr>
+{{end}}</table>
+<h3>Largest organizations cleaned</h3>
+<table>
+<tr><th>Organization</th><th>Clusters</th></tr>
+{{range .Orgs}}<tr><td>{{.OrgID}}</td><td>{{.Clusters}}</td></tr>
+{{end}}</table>
+`))
+
+// checkDigestFormat function checks if digest format is supported
+func checkDigestFormat(format string) error {
+	switch format {
+	case "", DigestFormatMarkdown, DigestFormatHTML:
+		return nil
+	default:
+		return fmt.Errorf("unknown digest format '%s', use %s or %s",
+			format, DigestFormatMarkdown, DigestFormatHTML)
+	}
+}
+
+// renderDigest function writes digest into given writer in selected format
+func renderDigest(writer io.Writer, digest RunDigest, format string) error {
+	switch strings.TrimSpace(format) {
+	case "", DigestFormatMarkdown:
+		return digestMarkdownTemplate.Execute(writer, digest)
+	case DigestFormatHTML:
+		return digestHTMLTemplate.Execute(writer, digest)
+	default:
+		return checkDigestFormat(format)
+	}
+}
+
+// produceDigest function reads runs recorded during last week and writes
+// digest into given output (standard output is used when it is empty)
+func produceDigest(connection *sql.DB, table, output, format string, now time.Time) error {
+	if table == "" {
+		return errors.New("history table is not specified in configuration")
+	}
+	if err := checkDigestFormat(strings.TrimSpace(format)); err != nil {
+		return err
+	}
+
+	from := now.Add(-digestPeriod)
+	entries, err := readRunHistory(connection, table, from)
+	if err != nil {
+		return err
+	}
+	digest := computeDigest(entries, from, now, digestTopOrgs)
+
+	if output == "" {
+		output = "-"
+	}
+	out, err := createOutputFile(output, false)
+	if err != nil {
+		return err
+	}
+	err = renderDigest(out.Writer(), digest, format)
+	return errors.Join(err, out.Close(err == nil))
+}
+
+// readClusterOrgs function reads organization ID of each cluster before the
+// cluster is cleaned up, so the digest can display organizations with the
+// most clusters cleaned. Organization IDs are read only when history table
+// is configured. Organization selected on command line is used for all
+// clusters, otherwise organization IDs are read from report table (OCP
+// schema only). Clusters with unknown organization are skipped.
+func readClusterOrgs(configuration *ConfigStruct, connection *sql.DB, clusterList ClusterList,
+	orgID int, schema string) map[ClusterName]int {
+	orgs := make(map[ClusterName]int)
+	if GetHistoryConfiguration(configuration).Table == "" {
+		return orgs
+	}
+
+	for _, cluster := range clusterList {
+		switch {
+		case orgID > 0:
+			orgs[cluster] = orgID
+		case schema == DBSchemaOCPRecommendations && connection != nil:
+			clusterOrgID, err := readOrgID(connection, string(cluster))
+			if err != nil {
+				log.Warn().Err(err).Str(clusterNameMsg, string(cluster)).Msg("Read organization ID for run history")
+				continue
+			}
+			if clusterOrgID >= 0 {
+				orgs[cluster] = clusterOrgID
+			}
+		}
+	}
+	return orgs
+}
+
+// countClustersForOrg function counts cleaned up clusters for each
+// organization
+func countClustersForOrg(deleted ClusterList, orgs map[ClusterName]int) map[int]int {
+	clustersForOrg := make(map[int]int)
+	for _, cluster := range deleted {
+		if orgID, found := orgs[cluster]; found {
+			clustersForOrg[orgID]++
+		}
+	}
+	return clustersForOrg
+}
+
+// newRunHistoryEntry function prepares entry to be recorded into history
+// table for operation that has been started at given time
+func newRunHistoryEntry(configuration *ConfigStruct, cliFlags CliFlags, operation string, started time.Time,
+	exitStatus ExitStatus, deletionsForTable map[string]int, clustersForOrg map[int]int) RunHistoryEntry {
+	if deletionsForTable == nil {
+		deletionsForTable = map[string]int{}
+	}
+	if clustersForOrg == nil {
+		clustersForOrg = map[int]int{}
+	}
+	return RunHistoryEntry{
+		RunID:             runID,
+		Operation:         operation,
+		Target:            configuration.Storage.Name,
+		RequestedBy:       cliFlags.RequestedBy,
+		Started:           started,
+		Finished:          time.Now(),
+		ExitStatus:        exitStatus,
+		DeletionsForTable: deletionsForTable,
+		ClustersForOrg:    clustersForOrg,
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/history_test.html
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// columns returned by query that reads run history
+var historyColumns = []string{"run_id", "operation", "target", "started_at",
+	"finished_at", "exit_status", "deletions", "org_clusters", "requested_by"}
+
+// historyEntries returns runs used by digest tests
+func historyEntries() []cleaner.RunHistoryEntry {
+	return []cleaner.RunHistoryEntry{
+		{
+			RunID:             "run-1",
+			ExitStatus:        cleaner.ExitStatusOK,
+			DeletionsForTable: map[string]int{"report": 10, "rule_hit": 30},
+			ClustersForOrg:    map[int]int{1: 2, 2: 5},
+		},
+		{
+			RunID:             "run-2",
+			ExitStatus:        cleaner.ExitStatusPerformCleanupError,
+			DeletionsForTable: map[string]int{"report": 5},
+			ClustersForOrg:    map[int]int{1: 4, 3: 1},
+		},
+	}
+}
+
+// TestComputeDigest checks roll-up of recorded runs
+func TestComputeDigest(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(7 * 24 * time.Hour)
+
+	digest := cleaner.ComputeDigest(historyEntries(), from, to, 2)
+
+	assert.Equal(t, 2, digest.Runs)
+	assert.Equal(t, 1, digest.FailedRuns)
+	assert.Equal(t, 50.0, digest.ErrorRate())
+	assert.Equal(t, 45, digest.TotalDeletedRows())
+	assert.Equal(t, []cleaner.TableDigest{
+		{Table: "report", DeletedRows: 15},
+		{Table: "rule_hit", DeletedRows: 30},
+	}, digest.Tables)
+	// only the largest organizations are kept
+	assert.Equal(t, []cleaner.OrgDigest{
+		{OrgID: 1, Clusters: 6},
+		{OrgID: 2, Clusters: 5},
+	}, digest.Orgs)
+}
+
+// TestComputeDigestNoRuns checks digest computed when no run is recorded
+func TestComputeDigestNoRuns(t *testing.T) {
+	digest := cleaner.ComputeDigest(nil, time.Now(), time.Now(), 10)
+
+	assert.Equal(t, 0, digest.Runs)
+	assert.Equal(t, 0.0, digest.ErrorRate())
+	assert.Empty(t, digest.Tables)
+	assert.Empty(t, digest.Orgs)
+}
+
+// TestRenderDigest checks digest rendered in Markdown and HTML
+func TestRenderDigest(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(7 * 24 * time.Hour)
+	digest := cleaner.ComputeDigest(historyEntries(), from, to, 10)
+
+	var markdown bytes.Buffer
+	err := cleaner.RenderDigest(&markdown, digest, "")
+	assert.NoError(t, err)
+	assert.Contains(t, markdown.String(), "## Cleaner weekly digest 2024-01-01 – 2024-01-08")
+	assert.Contains(t, markdown.String(), "* Error rate: 50.0 %")
+	assert.Contains(t, markdown.String(), "| rule_hit | 30 |")
+	assert.Contains(t, markdown.String(), "| 2 | 5 |")
+
+	var html bytes.Buffer
+	err = cleaner.RenderDigest(&html, digest, cleaner.DigestFormatHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, html.String(), "<li>Deleted rows: 45</li>")
+	assert.Contains(t, html.String(), "<tr><td>report</td><td>15</td></tr>")
+	assert.Contains(t, html.String(), "<tr><td>3</td><td>1</td></tr>")
+
+	err = cleaner.RenderDigest(&html, digest, "pdf")
+	assert.Error(t, err)
+}
+
+// TestRecordRunHistoryDisabled checks that nothing is recorded when history
+// table is not configured
+func TestRecordRunHistoryDisabled(t *testing.T) {
+	err := cleaner.RecordRunHistory(&cleaner.ConfigStruct{}, nil, cleaner.RunHistoryEntry{})
+	assert.NoError(t, err)
+}
+
+// TestRecordRunHistory checks that run is recorded into history table
+func TestRecordRunHistory(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	started := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+
+	mock.ExpectExec(`INSERT INTO "run_history"`).
+		WithArgs("run-1", "cleanup", "primary", started, finished, cleaner.ExitStatusOK,
+			`{"report":10}`, `{"1":2}`, "operator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		History: cleaner.HistoryConfiguration{Table: "run_history"},
+	}
+	err = cleaner.RecordRunHistory(&configuration, connection, cleaner.RunHistoryEntry{
+		RunID:             "run-1",
+		Operation:         "cleanup",
+		Target:            "primary",
+		RequestedBy:       "operator",
+		Started:           started,
+		Finished:          finished,
+		ExitStatus:        cleaner.ExitStatusOK,
+		DeletionsForTable: map[string]int{"report": 10},
+		ClustersForOrg:    map[int]int{1: 2},
+	})
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestRecordRunHistoryOnError checks that error during recording is returned
+func TestRecordRunHistoryOnError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec(`INSERT INTO "run_history"`).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		History: cleaner.HistoryConfiguration{Table: "run_history"},
+	}
+	err = cleaner.RecordRunHistory(&configuration, connection, cleaner.RunHistoryEntry{})
+	assert.ErrorIs(t, err, mockedError)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadRunHistory checks reading runs from history table
+func TestReadRunHistory(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(historyColumns)
+	rows.AddRow("run-1", "cleanup", "primary", since, since, 0, `{"report":10}`, `{"1":2}`, "operator")
+	rows.AddRow("run-2", "cleanup-all", "primary", since, since, 3, `{}`, `{}`, "")
+	mock.ExpectQuery(`SELECT run_id, operation, target, started_at, finished_at`).
+		WithArgs(since).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	entries, err := cleaner.ReadRunHistory(connection, "run_history", since)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]int{"report": 10}, entries[0].DeletionsForTable)
+	assert.Equal(t, map[int]int{1: 2}, entries[0].ClustersForOrg)
+	assert.Equal(t, "operator", entries[0].RequestedBy)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, entries[1].ExitStatus)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadRunHistoryImproperRecord checks that improper record in history
+// table is reported
+func TestReadRunHistoryImproperRecord(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(historyColumns)
+	rows.AddRow("run-1", "cleanup", "primary", since, since, 0, `not JSON`, `{}`, "operator")
+	mock.ExpectQuery(`SELECT run_id`).WithArgs(since).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	_, err = cleaner.ReadRunHistory(connection, "run_history", since)
+	assert.Error(t, err, "error is expected while calling tested function")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadRunHistoryNoConnection checks that missing connection is reported
+func TestReadRunHistoryNoConnection(t *testing.T) {
+	_, err := cleaner.ReadRunHistory(nil, "run_history", time.Now())
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+}
+
+// TestProduceDigest checks that digest is written into output file
+func TestProduceDigest(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	now := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(historyColumns)
+	rows.AddRow("run-1", "cleanup", "primary", since, since, 0, `{"report":10}`, `{"1":2}`, "operator")
+	mock.ExpectQuery(`SELECT run_id`).WithArgs(since).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	output := filepath.Join(t.TempDir(), "digest.html")
+	err = cleaner.ProduceDigest(connection, "run_history", output, cleaner.DigestFormatHTML, now)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	content, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "<tr><td>report</td><td>10</td></tr>")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestProduceDigestImproperSetup checks that digest is not produced without
+// history table or with unknown format
+func TestProduceDigestImproperSetup(t *testing.T) {
+	err := cleaner.ProduceDigest(nil, "", "", "", time.Now())
+	assert.Error(t, err)
+
+	err = cleaner.ProduceDigest(nil, "run_history", "", "pdf", time.Now())
+	assert.Error(t, err)
+}
+
+// TestReadClusterOrgs checks that organizations of clusters are read only
+// when history is enabled
+func TestReadClusterOrgs(t *testing.T) {
+	clusterList := cleaner.ClusterList{cluster1ID, cluster2ID}
+
+	// history is not enabled
+	orgs := cleaner.ReadClusterOrgs(&cleaner.ConfigStruct{}, nil, clusterList, 1, cleaner.DBSchemaOCPRecommendations)
+	assert.Empty(t, orgs)
+
+	configuration := cleaner.ConfigStruct{
+		History: cleaner.HistoryConfiguration{Table: "run_history"},
+	}
+
+	// organization selected on command line
+	orgs = cleaner.ReadClusterOrgs(&configuration, nil, clusterList, 42, cleaner.DBSchemaDVORecommendations)
+	assert.Equal(t, map[cleaner.ClusterName]int{cluster1ID: 42, cluster2ID: 42}, orgs)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("select org_id from report where cluster = \\$1").
+		WithArgs(cluster1ID).WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(7))
+	mock.ExpectQuery("select org_id from report where cluster = \\$1").
+		WithArgs(cluster2ID).WillReturnRows(sqlmock.NewRows([]string{"org_id"}))
+	mock.ExpectClose()
+
+	// organizations read from database, unknown organization is skipped
+	orgs = cleaner.ReadClusterOrgs(&configuration, connection, clusterList, 0, cleaner.DBSchemaOCPRecommendations)
+	assert.Equal(t, map[cleaner.ClusterName]int{cluster1ID: 7}, orgs)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+
+	clustersForOrg := cleaner.CountClustersForOrg(clusterList, orgs)
+	assert.Equal(t, map[int]int{7: 1}, clustersForOrg)
+}
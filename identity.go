@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/identity.html
+
+// This source file contains functions used to find out who triggered the run.
+// The identity is taken from (in this order):
+//
+// 1. --requested-by command line flag
+// 2. INSIGHTS_RESULTS_CLEANER_REQUESTED_BY environment variable
+// 3. OpenShift service account the cleaner runs under
+//
+// The identity is recorded in logs and in the summary as required by data
+// handling compliance process.
+//
+// Service account the cleaner runs under is read separately, because it is
+// the only identity used by per-operation authorization: the token is mounted
+// into pod by the platform while the command line option and environment
+// variable can be set by anyone who runs the tool. Signature of the token is
+// not checked, so the service account is trusted as much as file system of
+// the pod is.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Constants used to find out the identity
+const (
+	requestedByEnvVariableName = "INSIGHTS_RESULTS_CLEANER_REQUESTED_BY"
+	serviceAccountTokenFile    = "/var/run/secrets/kubernetes.io/serviceaccount/token" // #nosec G101
+	unknownIdentity            = "unknown"
+	requestedByAttribute       = "requested_by"
+)
+
+// resolveRequestedBy function returns identity of operator or service that
+// triggered the run
+func resolveRequestedBy(flagValue, serviceAccount string) string {
+	// identity specified on command line has the highest priority
+	if identity := strings.TrimSpace(flagValue); identity != "" {
+		return identity
+	}
+
+	// then environment variable is checked
+	if identity := strings.TrimSpace(os.Getenv(requestedByEnvVariableName)); identity != "" {
+		return identity
+	}
+
+	// and finally service account (when running in OpenShift)
+	if serviceAccount != "" {
+		return serviceAccount
+	}
+
+	return unknownIdentity
+}
+
+// resolveServiceAccount function returns identity of service account the
+// cleaner runs under, empty string is returned when the cleaner does not run
+// in pod with service account token
+func resolveServiceAccount() string {
+	identity, err := readServiceAccountIdentity(serviceAccountTokenFile)
+	if err != nil {
+		log.Debug().Err(err).Msg("Service account identity is not available")
+		return ""
+	}
+	return identity
+}
+
+// readServiceAccountIdentity function reads identity of service account from
+// the subject of token mounted into pod, for example
+// "system:serviceaccount:namespace:name"
+func readServiceAccountIdentity(tokenFile string) (string, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	token, err := os.ReadFile(tokenFile) // #nosec G304
+	if err != nil {
+		return "", err
+	}
+
+	// token is JWT, ie. header.payload.signature
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return "", errors.New("service account token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Subject == "" {
+		return "", errors.New("service account token does not contain subject")
+	}
+
+	return claims.Subject, nil
+}
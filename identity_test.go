@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/identity_test.html
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// writeToken function writes JWT-like token with given payload into
+// temporary file
+func writeToken(t *testing.T, payload string) string {
+	filename := filepath.Join(t.TempDir(), "token")
+	token := "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+
+	err := os.WriteFile(filename, []byte(token), 0600)
+	assert.NoError(t, err)
+
+	return filename
+}
+
+// TestResolveRequestedByFlag checks that identity specified on command line
+// has the highest priority
+func TestResolveRequestedByFlag(t *testing.T) {
+	t.Setenv("INSIGHTS_RESULTS_CLEANER_REQUESTED_BY", "env-user")
+
+	assert.Equal(t, "flag-user", cleaner.ResolveRequestedBy(" flag-user ", "system:serviceaccount:ccx:cleaner"))
+}
+
+// TestResolveRequestedByEnvVariable checks that identity is read from
+// environment variable when not specified on command line
+func TestResolveRequestedByEnvVariable(t *testing.T) {
+	t.Setenv("INSIGHTS_RESULTS_CLEANER_REQUESTED_BY", "env-user")
+
+	assert.Equal(t, "env-user", cleaner.ResolveRequestedBy("", "system:serviceaccount:ccx:cleaner"))
+}
+
+// TestResolveRequestedByServiceAccount checks that service account is used
+// when no other identity is specified
+func TestResolveRequestedByServiceAccount(t *testing.T) {
+	t.Setenv("INSIGHTS_RESULTS_CLEANER_REQUESTED_BY", "")
+
+	assert.Equal(t, "system:serviceaccount:ccx:cleaner",
+		cleaner.ResolveRequestedBy("", "system:serviceaccount:ccx:cleaner"))
+}
+
+// TestResolveRequestedByUnknown checks the identity when no source is
+// available
+func TestResolveRequestedByUnknown(t *testing.T) {
+	t.Setenv("INSIGHTS_RESULTS_CLEANER_REQUESTED_BY", "")
+
+	assert.Equal(t, "unknown", cleaner.ResolveRequestedBy("", ""))
+}
+
+// TestReadServiceAccountIdentity checks reading identity from service
+// account token
+func TestReadServiceAccountIdentity(t *testing.T) {
+	filename := writeToken(t, `{"sub":"system:serviceaccount:ccx:cleaner"}`)
+
+	identity, err := cleaner.ReadServiceAccountIdentity(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:ccx:cleaner", identity)
+}
+
+// TestReadServiceAccountIdentityErrors checks error handling during reading
+// identity from service account token
+func TestReadServiceAccountIdentityErrors(t *testing.T) {
+	// non existing file
+	_, err := cleaner.ReadServiceAccountIdentity(filepath.Join(t.TempDir(), "token"))
+	assert.Error(t, err)
+
+	// missing subject
+	_, err = cleaner.ReadServiceAccountIdentity(writeToken(t, `{"iss":"kubernetes"}`))
+	assert.Error(t, err)
+
+	// not a JSON
+	_, err = cleaner.ReadServiceAccountIdentity(writeToken(t, `foo`))
+	assert.Error(t, err)
+
+	// not a JWT
+	filename := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(filename, []byte("foo"), 0600))
+	_, err = cleaner.ReadServiceAccountIdentity(filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/init_schema.html
+
+// This source file contains implementation of database schema initialization.
+// Tables of the selected DB schema are created when they do not exist, so the
+// cleaner can be tried against empty database (SQLite in-memory database for
+// example) without any external dependency. Initialization is selected by
+// -init-schema command line option and it is performed before the selected
+// operation, optionally followed by fill-in by test data. Not to be used
+// against production database.
+
+import (
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tableDefinition represents statement that creates one table
+type tableDefinition struct {
+	table     string
+	statement string
+}
+
+// tableDefinitionsOCP contains definitions of all tables in OCP database,
+// referenced tables are created first
+var tableDefinitionsOCP = []tableDefinition{
+	{"report", `
+	    CREATE TABLE IF NOT EXISTS report (
+	        org_id          INTEGER NOT NULL,
+	        cluster         VARCHAR NOT NULL UNIQUE,
+	        report          VARCHAR NOT NULL,
+	        reported_at     TIMESTAMP,
+	        last_checked_at TIMESTAMP,
+	        kafka_offset    BIGINT NOT NULL DEFAULT 0,
+	        PRIMARY KEY(org_id, cluster))`},
+	{"rule_hit", `
+	    CREATE TABLE IF NOT EXISTS rule_hit (
+	        org_id        INTEGER NOT NULL,
+	        cluster_id    VARCHAR NOT NULL,
+	        rule_fqdn     VARCHAR NOT NULL,
+	        error_key     VARCHAR NOT NULL,
+	        template_data VARCHAR NOT NULL,
+	        PRIMARY KEY(cluster_id, org_id, rule_fqdn, error_key))`},
+	{"cluster_rule_toggle", `
+	    CREATE TABLE IF NOT EXISTS cluster_rule_toggle (
+	        cluster_id  VARCHAR NOT NULL,
+	        rule_id     VARCHAR NOT NULL,
+	        user_id     VARCHAR NOT NULL,
+	        disabled    SMALLINT NOT NULL CHECK (disabled >= 0 AND disabled <= 1),
+	        disabled_at TIMESTAMP,
+	        enabled_at  TIMESTAMP,
+	        updated_at  TIMESTAMP NOT NULL,
+	        PRIMARY KEY(cluster_id, rule_id, user_id))`},
+	{"cluster_rule_user_feedback", `
+	    CREATE TABLE IF NOT EXISTS cluster_rule_user_feedback (
+	        cluster_id VARCHAR NOT NULL REFERENCES report(cluster) ON DELETE CASCADE,
+	        rule_id    VARCHAR NOT NULL,
+	        user_id    VARCHAR NOT NULL,
+	        message    VARCHAR NOT NULL,
+	        user_vote  SMALLINT NOT NULL,
+	        added_at   TIMESTAMP NOT NULL,
+	        updated_at TIMESTAMP NOT NULL,
+	        PRIMARY KEY(cluster_id, rule_id, user_id))`},
+	{"cluster_user_rule_disable_feedback", `
+	    CREATE TABLE IF NOT EXISTS cluster_user_rule_disable_feedback (
+	        cluster_id VARCHAR NOT NULL,
+	        user_id    VARCHAR NOT NULL,
+	        rule_id    VARCHAR NOT NULL,
+	        message    VARCHAR NOT NULL,
+	        added_at   TIMESTAMP NOT NULL,
+	        updated_at TIMESTAMP NOT NULL,
+	        PRIMARY KEY(cluster_id, user_id, rule_id))`},
+	{"consumer_error", `
+	    CREATE TABLE IF NOT EXISTS consumer_error (
+	        topic        VARCHAR NOT NULL,
+	        partition    INTEGER NOT NULL,
+	        topic_offset INTEGER NOT NULL,
+	        key          VARCHAR,
+	        produced_at  TIMESTAMP NOT NULL,
+	        consumed_at  TIMESTAMP NOT NULL,
+	        message      VARCHAR,
+	        error        VARCHAR NOT NULL,
+	        PRIMARY KEY(topic, partition, topic_offset))`},
+	{"migration_info", `
+	    CREATE TABLE IF NOT EXISTS migration_info (
+	        version INTEGER NOT NULL)`},
+	{"recommendation", `
+	    CREATE TABLE IF NOT EXISTS recommendation (
+	        org_id     INTEGER NOT NULL,
+	        cluster_id VARCHAR NOT NULL,
+	        rule_fqdn  TEXT NOT NULL,
+	        error_key  VARCHAR NOT NULL,
+	        rule_id    VARCHAR NOT NULL DEFAULT '.',
+	        created_at TIMESTAMP,
+	        PRIMARY KEY(org_id, cluster_id, rule_fqdn, error_key))`},
+	{"report_info", `
+	    CREATE TABLE IF NOT EXISTS report_info (
+	        org_id       INTEGER NOT NULL,
+	        cluster_id   VARCHAR NOT NULL,
+	        version_info VARCHAR NOT NULL DEFAULT '',
+	        PRIMARY KEY(org_id, cluster_id))`},
+	{"advisor_ratings", `
+	    CREATE TABLE IF NOT EXISTS advisor_ratings (
+	        org_id          INTEGER NOT NULL,
+	        rule_fqdn       VARCHAR NOT NULL,
+	        error_key       VARCHAR NOT NULL,
+	        rule_id         VARCHAR NOT NULL,
+	        rating          SMALLINT NOT NULL,
+	        last_updated_at TIMESTAMP NOT NULL,
+	        PRIMARY KEY(org_id, rule_id))`},
+}
+
+// tableDefinitionsDVO contains definitions of all tables in DVO database
+var tableDefinitionsDVO = []tableDefinition{
+	{dvoReportTable, `
+	    CREATE TABLE IF NOT EXISTS dvo_report (
+	        org_id          INTEGER NOT NULL,
+	        cluster_id      VARCHAR NOT NULL,
+	        namespace_id    VARCHAR NOT NULL,
+	        namespace_name  VARCHAR,
+	        report          TEXT,
+	        recommendations INTEGER NOT NULL,
+	        objects         INTEGER NOT NULL,
+	        reported_at     TIMESTAMP,
+	        last_checked_at TIMESTAMP,
+	        rule_hits_count TEXT,
+	        PRIMARY KEY(org_id, cluster_id, namespace_id))`},
+}
+
+// tableDefinitionsForSchema function returns definitions of all tables in
+// given DB schema
+func tableDefinitionsForSchema(schema string) ([]tableDefinition, error) {
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		return tableDefinitionsOCP, nil
+	case DBSchemaDVORecommendations:
+		return tableDefinitionsDVO, nil
+	default:
+		return nil, invalidSchema(schema)
+	}
+}
+
+// initDatabaseSchema function creates all tables of given DB schema that do
+// not exist yet. Existing tables are not changed.
+func initDatabaseSchema(connection *sql.DB, schema string) error {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	definitions, err := tableDefinitionsForSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	for _, definition := range definitions {
+		_, err := execStatement(connection, definition.statement)
+		if err != nil {
+			return queryFailed(definition.table, err)
+		}
+		log.Info().Str(tableName, definition.table).Msg("Table initialized")
+	}
+
+	log.Info().Str("schema", schema).Msg("Database schema initialized")
+	return nil
+}
+
+// prepareDatabase function initializes database schema and fills-in the
+// database by test data when it is selected too. It is performed before the
+// selected operation, so the whole flow can be run against in-memory
+// database in one run.
+func prepareDatabase(connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	err := initDatabaseSchema(connection, schema)
+	if err != nil {
+		log.Err(err).Msg("Initialize database schema")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	switch {
+	case cliFlags.FillInDatabase && cliFlags.FillInClusters > 0:
+		return bulkFillInDatabase(connection, cliFlags, schema)
+	case cliFlags.FillInDatabase:
+		return fillInDatabase(connection, schema)
+	default:
+		return ExitStatusOK, nil
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/init_schema_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// ocpTablesInCreateOrder contains OCP tables in order they are created
+var ocpTablesInCreateOrder = []string{
+	"report", "rule_hit", "cluster_rule_toggle", "cluster_rule_user_feedback",
+	"cluster_user_rule_disable_feedback", "consumer_error", "migration_info",
+	"recommendation", "report_info", "advisor_ratings",
+}
+
+// TestInitDatabaseSchema checks that all tables are created when they do
+// not exist
+func TestInitDatabaseSchema(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	for _, table := range ocpTablesInCreateOrder {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS " + table + " \\(").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS dvo_report \\(").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	err = cleaner.InitDatabaseSchema(connection, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	err = cleaner.InitDatabaseSchema(connection, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+
+	// unknown DB schema
+	var schemaErr *cleaner.ErrInvalidSchema
+	err = cleaner.InitDatabaseSchema(connection, "unknown")
+	assert.ErrorAs(t, err, &schemaErr)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestInitDatabaseSchemaOnError checks that initialization stops on the
+// first failure
+func TestInitDatabaseSchemaOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS report \\(").
+		WillReturnError(errors.New("create error"))
+	mock.ExpectClose()
+
+	status, err := cleaner.PrepareDatabase(connection, cleaner.CliFlags{FillInDatabase: true},
+		cleaner.DBSchemaOCPRecommendations)
+	var queryErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "report", queryErr.Table)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// no connection
+	err = cleaner.InitDatabaseSchema(nil, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestInMemoryDatabase checks the whole flow against SQLite in-memory
+// database: schema initialization, fill-in by test data, listing, and
+// cleanup
+func TestInMemoryDatabase(t *testing.T) {
+	enableSQLiteDialect(t)
+
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			Schema:           cleaner.DBSchemaOCPRecommendations,
+		},
+	}
+	connection, err := cleaner.InitDatabaseConnection(&configuration.Storage)
+	assert.NoError(t, err)
+
+	// schema is initialized and filled-in before the selected operation
+	cliFlags := cleaner.CliFlags{
+		InitSchema:      true,
+		FillInDatabase:  true,
+		FillInClusters:  10,
+		FillInBatchSize: 4,
+		MaxAge:          "5 days",
+		CountOnly:       true,
+	}
+	status, err := cleaner.DoSelectedOperation(&configuration, connection, cliFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// synthetic clusters are 0 to 9 days old
+	counts, err := cleaner.CountOldRecords(connection, "5 days", cleaner.DBSchemaOCPRecommendations,
+		cleaner.ListingFilter{})
+	assert.NoError(t, err)
+	assert.Contains(t, counts, cleaner.OldRecordsCount{Category: "Old OCP reports", Table: "report", Count: 4})
+
+	// schema initialization does not change existing tables
+	assert.NoError(t, cleaner.InitDatabaseSchema(connection, cleaner.DBSchemaOCPRecommendations))
+
+	// records are just counted in dry run mode
+	deletions, err := cleaner.PerformCleanupAllInDB(connection, "5 days", cleaner.DBSchemaOCPRecommendations, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, deletions["report"])
+	assert.Equal(t, 4, deletions["rule_hit"])
+
+	deletions, err = cleaner.PerformCleanupAllInDB(connection, "5 days", cleaner.DBSchemaOCPRecommendations, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, deletions["report"])
+	assert.Equal(t, 4, deletions["rule_hit"])
+
+	counts, err = cleaner.CountOldRecords(connection, "5 days", cleaner.DBSchemaOCPRecommendations,
+		cleaner.ListingFilter{})
+	assert.NoError(t, err)
+	assert.Contains(t, counts, cleaner.OldRecordsCount{Category: "Old OCP reports", Table: "report", Count: 0})
+
+	checkConnectionClose(t, connection)
+}
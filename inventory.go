@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/inventory.html
+
+// This source file contains implementation of cluster list read from
+// inventory API (AMS subscriptions API). Subscriptions of clusters that have
+// been deprovisioned, archived, or disconnected more than configured time ago
+// are read page by page and their external cluster IDs are converted into
+// list of clusters to cleanup. So the list does not need to be handed over
+// manually.
+//
+// Inventory API is configured in [inventory] section of the configuration
+// file, cluster list is read from the API when -clusters-from-inventory
+// command line option is specified.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Default values used to query inventory API
+const (
+	defaultRequestTimeout    = 30 * time.Second
+	defaultInventoryPageSize = 100
+)
+
+// inventorySubscriptionsPath is path to subscriptions endpoint of inventory
+// API
+const inventorySubscriptionsPath = "/api/accounts_mgmt/v1/subscriptions"
+
+// defaultInventoryStatuses contains statuses of subscriptions of clusters
+// that are no longer active
+var defaultInventoryStatuses = []string{"Deprovisioned", "Archived", "Disconnected"}
+
+// inventorySubscription represents one subscription returned by inventory
+// API, only attributes used by the cleaner are read
+type inventorySubscription struct {
+	ExternalClusterID string `json:"external_cluster_id"`
+	Status            string `json:"status"`
+	UpdatedAt         string `json:"updated_at"`
+}
+
+// inventorySubscriptionsPage represents one page of subscriptions returned by
+// inventory API
+type inventorySubscriptionsPage struct {
+	Items []inventorySubscription `json:"items"`
+	Page  int                     `json:"page"`
+	Size  int                     `json:"size"`
+	Total int                     `json:"total"`
+}
+
+// inventorySearch function constructs search query that selects
+// subscriptions with given statuses updated before the cutoff time
+func inventorySearch(statuses []string, cutoff time.Time) string {
+	quoted := make([]string, len(statuses))
+	for i, status := range statuses {
+		quoted[i] = "'" + strings.ReplaceAll(status, "'", "''") + "'"
+	}
+	return fmt.Sprintf("status IN (%s) AND updated_at < '%s'",
+		strings.Join(quoted, ", "), cutoff.UTC().Format(time.RFC3339))
+}
+
+// inventoryPageURL function constructs URL of one page of subscriptions
+func inventoryPageURL(baseURL, search string, page, size int) string {
+	query := url.Values{}
+	query.Set("search", search)
+	query.Set("fields", "external_cluster_id,status,updated_at")
+	query.Set("page", strconv.Itoa(page))
+	query.Set("size", strconv.Itoa(size))
+	return strings.TrimSuffix(baseURL, "/") + inventorySubscriptionsPath + "?" + query.Encode()
+}
+
+// readInventoryPage function reads one page of subscriptions from inventory
+// API
+func readInventoryPage(client *http.Client, pageURL, token string) (inventorySubscriptionsPage, error) {
+	var page inventorySubscriptionsPage
+
+	request, err := http.NewRequest(http.MethodGet, pageURL, http.NoBody)
+	if err != nil {
+		return page, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	err = getJSON(client, request, &page)
+	return page, err
+}
+
+// getJSON function sends prepared HTTP request and decodes JSON response into
+// given target
+func getJSON(client *http.Client, request *http.Request, target interface{}) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := response.Body.Close(); closeErr != nil {
+			log.Error().Err(closeErr).Str("URL", request.URL.Redacted()).Msg("Unable to close HTTP response")
+		}
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", request.URL.Redacted(), response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// requestTimeout function parses timeout of HTTP requests, default timeout is
+// returned when no timeout is specified
+func requestTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return defaultRequestTimeout, nil
+	}
+	return time.ParseDuration(timeout)
+}
+
+// readClusterListFromInventory function reads list of clusters that have
+// been inactive for more than configured time from inventory API
+func readClusterListFromInventory(inventoryConfig InventoryConfiguration, now time.Time) (ClusterList, int, error) {
+	log.Debug().Msg("Cluster list read from inventory API")
+
+	improperClusterCounter := 0
+	var clusterList = make([]ClusterName, 0)
+
+	if inventoryConfig.URL == "" {
+		return clusterList, improperClusterCounter, errors.New("inventory API URL is not specified in configuration")
+	}
+
+	// all values have been checked together with the whole configuration
+	minAge, err := parseAge(inventoryConfig.MinAge)
+	if err != nil {
+		return clusterList, improperClusterCounter, err
+	}
+
+	timeout, err := requestTimeout(inventoryConfig.Timeout)
+	if err != nil {
+		return clusterList, improperClusterCounter, err
+	}
+
+	pageSize := inventoryConfig.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultInventoryPageSize
+	}
+
+	statuses := inventoryConfig.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultInventoryStatuses
+	}
+
+	client := &http.Client{Timeout: timeout}
+	search := inventorySearch(statuses, now.Add(-minAge))
+
+	// the same cluster can have more subscriptions
+	seen := make(map[ClusterName]struct{})
+	read := 0
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := readInventoryPage(client, inventoryPageURL(inventoryConfig.URL, search, pageNumber, pageSize),
+			inventoryConfig.Token)
+		if err != nil {
+			log.Error().Err(err).Int("page", pageNumber).Msg("Unable to read inventory API page")
+			return clusterList, improperClusterCounter, err
+		}
+
+		for _, subscription := range page.Items {
+			cluster := strings.TrimSpace(subscription.ExternalClusterID)
+			if !IsValidUUID(cluster) {
+				log.Error().Str(inputWithClusterID, cluster).Msg(notProperClusterID)
+				improperClusterCounter++
+				continue
+			}
+			if _, found := seen[ClusterName(cluster)]; found {
+				continue
+			}
+			seen[ClusterName(cluster)] = struct{}{}
+			clusterList = append(clusterList, ClusterName(cluster))
+			log.Info().
+				Str(inputWithClusterID, cluster).
+				Str("status", subscription.Status).
+				Str("updated at", subscription.UpdatedAt).
+				Msg(properClusterID)
+		}
+
+		read += len(page.Items)
+		if len(page.Items) < pageSize || (page.Total > 0 && read >= page.Total) {
+			break
+		}
+	}
+	log.Info().Int(numberOfClustersToDelete, len(clusterList)).Msg(clusterListFinished)
+	log.Info().Int(improperClusterEntries, improperClusterCounter).Msg(clusterListFinished)
+
+	return clusterList, improperClusterCounter, nil
+}
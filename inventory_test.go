@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/inventory_test.html
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// inventoryTestTime is time used as "now" by inventory tests
+var inventoryTestTime = time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+// TestInventorySearch checks construction of inventory API search query
+func TestInventorySearch(t *testing.T) {
+	search := cleaner.InventorySearch([]string{"Deprovisioned", "Archived"},
+		time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, "status IN ('Deprovisioned', 'Archived') AND updated_at < '2024-05-31T12:00:00Z'", search)
+
+	// quotes in statuses are escaped
+	search = cleaner.InventorySearch([]string{"a'b"}, inventoryTestTime)
+	assert.Contains(t, search, "('a''b')")
+}
+
+// TestReadClusterListFromInventory checks that clusters are read from all
+// pages returned by inventory API
+func TestReadClusterListFromInventory(t *testing.T) {
+	pages := map[string]string{
+		"1": fmt.Sprintf(`{"items": [
+			{"external_cluster_id": "%s", "status": "Deprovisioned"},
+			{"external_cluster_id": "not-a-cluster", "status": "Archived"}
+		], "page": 1, "size": 2, "total": 3}`, cluster1ID),
+		"2": fmt.Sprintf(`{"items": [
+			{"external_cluster_id": "%s", "status": "Disconnected"}
+		], "page": 2, "size": 2, "total": 3}`, cluster2ID),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/accounts_mgmt/v1/subscriptions", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "2", r.URL.Query().Get("size"))
+		assert.Contains(t, r.URL.Query().Get("search"), "updated_at < '2024-05-31T12:00:00Z'")
+
+		page, found := pages[r.URL.Query().Get("page")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(page))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	inventoryConfig := cleaner.InventoryConfiguration{
+		URL:      server.URL,
+		Token:    "secret",
+		MinAge:   "30 days",
+		PageSize: 2,
+	}
+
+	clusterList, improper, err := cleaner.ReadClusterListFromInventory(inventoryConfig, inventoryTestTime)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, improper)
+}
+
+// TestReadClusterListFromInventoryOnError checks that errors returned by
+// inventory API are reported
+func TestReadClusterListFromInventoryOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	// URL is not configured
+	_, _, err := cleaner.ReadClusterListFromInventory(cleaner.InventoryConfiguration{}, inventoryTestTime)
+	assert.Error(t, err)
+
+	// improper response
+	_, _, err = cleaner.ReadClusterListFromInventory(cleaner.InventoryConfiguration{
+		URL:    server.URL,
+		MinAge: "30 days",
+	}, inventoryTestTime)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+// TestCheckInventoryConfiguration checks validation of inventory API
+// configuration
+func TestCheckInventoryConfiguration(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+	}
+
+	// inventory API is not used
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.Inventory = cleaner.InventoryConfiguration{URL: "http://localhost"}
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "min age is missing")
+
+	configuration.Inventory.MinAge = "thirty days"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "min age is incorrect")
+
+	configuration.Inventory.MinAge = "30 days"
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	configuration.Inventory.Timeout = "soon"
+	assert.Error(t, cleaner.CheckConfiguration(&configuration), "timeout is incorrect")
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/kafka.html
+
+// This source file contains implementation of cluster list read from Kafka
+// topic. All partitions of the topic are read from the oldest available
+// message up to the high water mark, i.e. the cleaner does not wait for new
+// messages. Each message is expected to contain either plain cluster ID or
+// JSON object with cluster ID stored in "cluster_id" attribute.
+//
+// The topic is configured in [cluster_list.kafka] section of the
+// configuration file, cluster list is read from Kafka when provider "kafka" is
+// selected in [cluster_list] section.
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultKafkaIdleTimeout is time to wait for next message from partition
+// when no timeout is specified in configuration
+const defaultKafkaIdleTimeout = 10 * time.Second
+
+// kafkaClusterMessage represents message with cluster ID stored as JSON
+// object
+type kafkaClusterMessage struct {
+	ClusterID string `json:"cluster_id"`
+}
+
+// newKafkaConsumer is a function used to construct Kafka consumer, it can be
+// replaced in unit tests
+var newKafkaConsumer = func(brokers []string) (sarama.Consumer, error) {
+	return sarama.NewConsumer(brokers, sarama.NewConfig())
+}
+
+// clusterFromKafkaMessage function retrieves cluster ID from message value
+func clusterFromKafkaMessage(value []byte) string {
+	content := strings.TrimSpace(string(value))
+	if !strings.HasPrefix(content, "{") {
+		return content
+	}
+
+	var message kafkaClusterMessage
+	if err := json.Unmarshal([]byte(content), &message); err != nil {
+		log.Error().Err(err).Msg("Unable to decode message with cluster ID")
+		return content
+	}
+	return strings.TrimSpace(message.ClusterID)
+}
+
+// readKafkaPartition function reads all messages from one partition until
+// high water mark is reached or no message arrives in given timeout
+func readKafkaPartition(partitionConsumer sarama.PartitionConsumer, timeout time.Duration) ([][]byte, error) {
+	var values [][]byte
+
+	for {
+		select {
+		case message, ok := <-partitionConsumer.Messages():
+			if !ok {
+				return values, nil
+			}
+			values = append(values, message.Value)
+			if message.Offset+1 >= partitionConsumer.HighWaterMarkOffset() {
+				return values, nil
+			}
+		case consumerErr, ok := <-partitionConsumer.Errors():
+			if ok {
+				return values, consumerErr
+			}
+		case <-time.After(timeout):
+			return values, nil
+		}
+	}
+}
+
+// readClusterListFromKafka function reads list of clusters from all
+// partitions of configured Kafka topic
+func readClusterListFromKafka(kafkaConfig KafkaConfiguration) (ClusterList, int, error) {
+	log.Debug().Msg("Cluster list read from Kafka topic")
+
+	improperClusterCounter := 0
+	var clusterList = make([]ClusterName, 0)
+
+	if len(kafkaConfig.Brokers) == 0 || kafkaConfig.Topic == "" {
+		return clusterList, improperClusterCounter, errors.New("Kafka brokers or topic are not specified in configuration")
+	}
+
+	// timeout has been checked together with the whole configuration
+	timeout := defaultKafkaIdleTimeout
+	if kafkaConfig.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(kafkaConfig.Timeout)
+		if err != nil {
+			return clusterList, improperClusterCounter, err
+		}
+	}
+
+	consumer, err := newKafkaConsumer(kafkaConfig.Brokers)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to connect to Kafka")
+		return clusterList, improperClusterCounter, err
+	}
+	defer func() {
+		if closeErr := consumer.Close(); closeErr != nil {
+			log.Error().Err(closeErr).Msg("Unable to close Kafka consumer")
+		}
+	}()
+
+	partitions, err := consumer.Partitions(kafkaConfig.Topic)
+	if err != nil {
+		log.Error().Err(err).Str("topic", kafkaConfig.Topic).Msg("Unable to read partitions")
+		return clusterList, improperClusterCounter, err
+	}
+
+	for _, partition := range partitions {
+		partitionConsumer, err := consumer.ConsumePartition(kafkaConfig.Topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return clusterList, improperClusterCounter, err
+		}
+
+		values, err := readKafkaPartition(partitionConsumer, timeout)
+		if closeErr := partitionConsumer.Close(); closeErr != nil {
+			log.Error().Err(closeErr).Int32("partition", partition).Msg("Unable to close partition consumer")
+		}
+		if err != nil {
+			log.Error().Err(err).Int32("partition", partition).Msg("Unable to read partition")
+			return clusterList, improperClusterCounter, err
+		}
+
+		for _, value := range values {
+			cluster := clusterFromKafkaMessage(value)
+			// check if message contains proper cluster ID (as UUID)
+			if IsValidUUID(cluster) {
+				clusterList = append(clusterList, ClusterName(cluster))
+				log.Info().Str(inputWithClusterID, cluster).Msg(properClusterID)
+			} else {
+				log.Error().Str(inputWithClusterID, cluster).Msg(notProperClusterID)
+				improperClusterCounter++
+			}
+		}
+	}
+	log.Info().Int(numberOfClustersToDelete, len(clusterList)).Msg(clusterListFinished)
+	log.Info().Int(improperClusterEntries, improperClusterCounter).Msg(clusterListFinished)
+
+	return clusterList, improperClusterCounter, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/kafka_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// topic used by Kafka tests
+const testTopic = "ccx.cleaner.clusters"
+
+// mockKafkaConsumer function replaces Kafka consumer by mocked one
+func mockKafkaConsumer(t *testing.T) *mocks.Consumer {
+	consumer := mocks.NewConsumer(t, nil)
+
+	original := *cleaner.NewKafkaConsumer
+	t.Cleanup(func() {
+		*cleaner.NewKafkaConsumer = original
+	})
+
+	*cleaner.NewKafkaConsumer = func(brokers []string) (sarama.Consumer, error) {
+		return consumer, nil
+	}
+	return consumer
+}
+
+// TestClusterFromKafkaMessage checks retrieving cluster ID from message
+func TestClusterFromKafkaMessage(t *testing.T) {
+	assert.Equal(t, cluster1ID, cleaner.ClusterFromKafkaMessage([]byte(" "+cluster1ID+"\n")))
+	assert.Equal(t, cluster1ID, cleaner.ClusterFromKafkaMessage([]byte(`{"cluster_id": "`+cluster1ID+`"}`)))
+	assert.Equal(t, "{foobar", cleaner.ClusterFromKafkaMessage([]byte("{foobar")))
+}
+
+// TestReadClusterListFromKafka checks that clusters are read from all
+// partitions of Kafka topic
+func TestReadClusterListFromKafka(t *testing.T) {
+	consumer := mockKafkaConsumer(t)
+	consumer.SetTopicMetadata(map[string][]int32{testTopic: {0, 1}})
+
+	partition0 := consumer.ExpectConsumePartition(testTopic, 0, sarama.OffsetOldest)
+	partition0.YieldMessage(&sarama.ConsumerMessage{Value: []byte(cluster1ID)})
+	partition0.YieldMessage(&sarama.ConsumerMessage{Value: []byte("foobar")})
+
+	partition1 := consumer.ExpectConsumePartition(testTopic, 1, sarama.OffsetOldest)
+	partition1.YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"cluster_id": "` + cluster2ID + `"}`)})
+
+	clusterList, improper, err := cleaner.ReadClusterListFromKafka(cleaner.KafkaConfiguration{
+		Brokers: []string{"localhost:9092"},
+		Topic:   testTopic,
+		Timeout: "100ms",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+	assert.Equal(t, 1, improper)
+}
+
+// TestReadClusterListFromKafkaOnError checks that errors are reported when
+// cluster list can not be read from Kafka topic
+func TestReadClusterListFromKafkaOnError(t *testing.T) {
+	// brokers and topic are not configured
+	_, _, err := cleaner.ReadClusterListFromKafka(cleaner.KafkaConfiguration{})
+	assert.Error(t, err)
+
+	kafkaConfig := cleaner.KafkaConfiguration{
+		Brokers: []string{"localhost:9092"},
+		Topic:   testTopic,
+		Timeout: "100ms",
+	}
+
+	// error reported by partition consumer
+	consumer := mockKafkaConsumer(t)
+	consumer.SetTopicMetadata(map[string][]int32{testTopic: {0}})
+	partition := consumer.ExpectConsumePartition(testTopic, 0, sarama.OffsetOldest)
+	partition.YieldError(errors.New("broker error"))
+
+	_, _, err = cleaner.ReadClusterListFromKafka(kafkaConfig)
+	assert.Error(t, err)
+
+	// unable to connect
+	*cleaner.NewKafkaConsumer = func(brokers []string) (sarama.Consumer, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, _, err = cleaner.ReadClusterListFromKafka(kafkaConfig)
+	assert.Error(t, err)
+}
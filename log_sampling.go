@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/log_sampling.html
+
+// This source file contains implementation of sampling of row-level log
+// events, ie. events logged for each old record found in database. Verbose
+// listings of big databases can produce millions of such events, so it is
+// possible to log just one of every N events of the same kind. Events of each
+// kind (ie. with the same message) are sampled independently, so each kind is
+// still represented in logs. All other events are always logged.
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// rowEventsSampling contains N when just one of every N row-level events is
+// to be logged, all events are logged when it is zero or one
+var rowEventsSampling uint32
+
+// rowEventsSamplers contains sampler for each kind of row-level events
+var rowEventsSamplers = map[string]zerolog.Sampler{}
+
+// rowEventsMutex guards samplers of row-level events, because records can be
+// displayed for more storage targets at the same time
+var rowEventsMutex sync.Mutex
+
+// registerLogSampling function registers sampling of row-level log events.
+// Samplers are reset, so the first event of each kind is always logged.
+func registerLogSampling(every int) {
+	rowEventsMutex.Lock()
+	defer rowEventsMutex.Unlock()
+
+	// value has been checked together with the whole configuration
+	if every < 0 {
+		every = 0
+	}
+	rowEventsSampling = uint32(every)
+	rowEventsSamplers = map[string]zerolog.Sampler{}
+}
+
+// rowEvent function starts new row-level log event with info level. The event
+// is disabled (ie. it is not logged) when it is not selected by sampler
+// registered for events with given message.
+func rowEvent(message string) *zerolog.Event {
+	if rowEventsSampling <= 1 {
+		return log.Info()
+	}
+
+	rowEventsMutex.Lock()
+	sampler, found := rowEventsSamplers[message]
+	if !found {
+		sampler = &zerolog.BasicSampler{N: rowEventsSampling}
+		rowEventsSamplers[message] = sampler
+	}
+	rowEventsMutex.Unlock()
+
+	logger := log.Logger.Sample(sampler)
+	return logger.Info()
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/log_sampling_test.html
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestRowEventsSampling checks that just one of every N row-level events of
+// each kind is logged while records are still written into output sink
+func TestRowEventsSampling(t *testing.T) {
+	restoreLogger(t)
+	t.Cleanup(func() {
+		cleaner.RegisterLogSampling(0)
+	})
+
+	var buffer bytes.Buffer
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	log.Logger = zerolog.New(&buffer)
+
+	cleaner.RegisterLogSampling(3)
+	outputConfig := cleaner.OutputConfiguration{}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		for i := 0; i < 7; i++ {
+			cleaner.DisplayOldOCPReport(cleaner.OldOCPReport{ClusterName: cluster1ID}, sink, outputConfig)
+		}
+	})
+	cleaner.DisplayOldRating(cleaner.OldRating{RuleFQDN: "rule.test"}, cleaner.DiscardSink, outputConfig)
+
+	// the first, fourth, and seventh report, and the first rating
+	assert.Equal(t, 3, strings.Count(buffer.String(), "Old OCP report"))
+	assert.Equal(t, 1, strings.Count(buffer.String(), "Old Advisor rating"))
+	assert.Equal(t, 7, strings.Count(output, cluster1ID))
+
+	// all events are logged without sampling
+	buffer.Reset()
+	cleaner.RegisterLogSampling(0)
+	for i := 0; i < 7; i++ {
+		cleaner.DisplayOldRating(cleaner.OldRating{RuleFQDN: "rule.test"}, cleaner.DiscardSink, outputConfig)
+	}
+	assert.Equal(t, 7, strings.Count(buffer.String(), "Old Advisor rating"))
+}
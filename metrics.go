@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/metrics.html
+
+// This source file contains definition of metrics collected during one run
+// of the cleaner. Metrics can be written into a file in Prometheus text
+// format that is compatible with node-exporter textfile collector. It is
+// useful for clusters where Pushgateway is not available.
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics namespace and labels
+const (
+	metricsNamespace = "insights_results_aggregator_cleaner"
+	tableLabel       = "table"
+	operationLabel   = "operation"
+)
+
+// metricsRegistry is a registry with all metrics exported by the cleaner.
+// Default Prometheus registry is not used on purpose, because Go runtime
+// metrics are not interesting for textfile collector.
+var metricsRegistry = prometheus.NewRegistry()
+
+// Metrics collected during one cleaner run
+var (
+	// deletedRowsMetric contains number of rows deleted from each table
+	deletedRowsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "deleted_rows",
+		Help:      "Number of rows deleted from table during the last run",
+	}, []string{tableLabel})
+
+	// oldRecordsMetric contains number of old records found in each table
+	oldRecordsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "old_records",
+		Help:      "Number of old records found in table during the last run",
+	}, []string{tableLabel})
+
+	// runDurationMetric contains duration of the last run
+	runDurationMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the last run in seconds",
+	})
+
+	// lastRunTimestampMetric contains time when the last run finished
+	lastRunTimestampMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix time when the last run finished",
+	})
+
+	// transactionRetriesMetric contains number of transactions retried
+	// because of serialization failure or deadlock
+	transactionRetriesMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "transaction_retries",
+		Help:      "Number of transactions retried because of serialization failure or deadlock during the last run",
+	})
+
+	// slowStatementsMetric contains number of slow SQL statements issued
+	// against each table
+	slowStatementsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "slow_statements",
+		Help:      "Number of SQL statements that exceeded slow statement threshold during the last run",
+	}, []string{tableLabel})
+
+	// statementDurationMetric contains durations of SQL statements issued
+	// against each table, labeled by kind of operation performed by the
+	// statement. Buckets start at 1ms and end at about 4 minutes, so slow
+	// deletions from large tables are still distinguishable.
+	statementDurationMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "statement_duration_seconds",
+		Help:      "Duration of SQL statements issued against table during the last run",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{tableLabel, operationLabel})
+
+	// exitStatusMetric contains exit status of the last run
+	exitStatusMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "exit_status",
+		Help:      "Exit status of the last run",
+	})
+)
+
+// deletedRowsTotal contains number of rows deleted from all tables during
+// the run. Operations can be performed against more targets concurrently, so
+// the counter needs to be updated atomically.
+var deletedRowsTotal atomic.Int64
+
+func init() {
+	metricsRegistry.MustRegister(
+		deletedRowsMetric,
+		oldRecordsMetric,
+		runDurationMetric,
+		lastRunTimestampMetric,
+		transactionRetriesMetric,
+		slowStatementsMetric,
+		statementDurationMetric,
+		exitStatusMetric,
+	)
+}
+
+// recordDeletedRows function stores number of deleted rows for all tables
+// into metrics
+func recordDeletedRows(deletionsForTable map[string]int) {
+	for table, deletions := range deletionsForTable {
+		deletedRowsMetric.WithLabelValues(table).Set(float64(deletions))
+		deletedRowsTotal.Add(int64(deletions))
+	}
+}
+
+// recordOldRecords function stores number of old records found in given
+// table into metrics
+func recordOldRecords(table string, count int) {
+	oldRecordsMetric.WithLabelValues(table).Set(float64(count))
+}
+
+// recordTransactionRetry function increments number of retried transactions
+func recordTransactionRetry() {
+	transactionRetriesMetric.Inc()
+}
+
+// recordSlowStatement function increments number of slow statements issued
+// against given table
+func recordSlowStatement(table string) {
+	slowStatementsMetric.WithLabelValues(table).Inc()
+}
+
+// recordStatementDuration function stores duration of SQL statement that
+// performed given operation on given table into metrics
+func recordStatementDuration(table, operation string, duration time.Duration) {
+	statementDurationMetric.WithLabelValues(table, operation).Observe(duration.Seconds())
+}
+
+// recordRunFinished function stores duration and exit status of the run
+// into metrics
+func recordRunFinished(started time.Time, exitStatus ExitStatus) {
+	finished := time.Now()
+	runDurationMetric.Set(finished.Sub(started).Seconds())
+	lastRunTimestampMetric.Set(float64(finished.Unix()))
+	exitStatusMetric.Set(float64(exitStatus))
+}
+
+// writeMetricsTextfile function writes all metrics into given file in
+// Prometheus text format. File is written atomically so node-exporter never
+// reads partially written file. Nothing is written when filename is not
+// specified.
+func writeMetricsTextfile(filename string) error {
+	if filename == "" {
+		return nil
+	}
+
+	err := prometheus.WriteToTextfile(filename, metricsRegistry)
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, filename).Msg("Write metrics")
+		return err
+	}
+
+	log.Debug().Str(filenameAttribute, filename).Msg("Metrics written")
+	return nil
+}
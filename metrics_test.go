@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/metrics_test.html
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestWriteMetricsTextfileNoFilename checks that nothing is written when
+// metrics file is not configured
+func TestWriteMetricsTextfileNoFilename(t *testing.T) {
+	err := cleaner.WriteMetricsTextfile("")
+	assert.NoError(t, err)
+}
+
+// TestWriteMetricsTextfile checks that all recorded metrics are written into
+// textfile in Prometheus text format
+func TestWriteMetricsTextfile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cleaner.prom")
+
+	cleaner.RecordDeletedRows(map[string]int{
+		"report":   42,
+		"rule_hit": 100,
+	})
+	cleaner.RecordOldRecords("consumer_error", 7)
+	cleaner.RecordRunFinished(time.Now().Add(-time.Minute), cleaner.ExitStatusOK)
+
+	err := cleaner.WriteMetricsTextfile(filename)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	text := string(content)
+	assert.Contains(t, text, `insights_results_aggregator_cleaner_deleted_rows{table="report"} 42`)
+	assert.Contains(t, text, `insights_results_aggregator_cleaner_deleted_rows{table="rule_hit"} 100`)
+	assert.Contains(t, text, `insights_results_aggregator_cleaner_old_records{table="consumer_error"} 7`)
+	assert.Contains(t, text, "insights_results_aggregator_cleaner_run_duration_seconds")
+	assert.Contains(t, text, "insights_results_aggregator_cleaner_last_run_timestamp_seconds")
+	assert.Contains(t, text, "insights_results_aggregator_cleaner_exit_status 0")
+}
+
+// TestWriteMetricsTextfileError checks error handling when metrics file can
+// not be written
+func TestWriteMetricsTextfileError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "non-existing-directory", "cleaner.prom")
+
+	err := cleaner.WriteMetricsTextfile(filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/migration_check.html
+
+// This source file contains implementation of check of aggregator migration
+// version. Delete statements used by the cleaner are written for particular
+// layout of aggregator tables. When database has been migrated by newer
+// version of aggregator, the layout could be changed, so records would be
+// deleted based on outdated assumptions. Version of database schema is read
+// from migration_info table before any operation is started and it is
+// compared with the newest version the cleaner has been built against.
+//
+// The check is enabled by migration_check option in [storage] section (or in
+// each [[targets]] section). When it is set to "warn", newer version is just
+// logged. When it is set to "refuse", no operation is performed.
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Values of migration_check option
+const (
+	MigrationCheckWarn   = "warn"
+	MigrationCheckRefuse = "refuse"
+)
+
+// selectMigrationInfoVersion reads version of database schema. The table
+// contains just one row, but the maximum is used to be on the safe side.
+const selectMigrationInfoVersion = "SELECT COALESCE(MAX(version), 0) FROM %s"
+
+// supportedMigrationVersions contains the newest versions of aggregator
+// database schemas the cleaner has been built against
+var supportedMigrationVersions = map[string]int{
+	DBSchemaOCPRecommendations: 33,
+	DBSchemaDVORecommendations: 5,
+}
+
+// migrationInfoTables contains names of tables with version of aggregator
+// database schemas. References to OCP table are qualified by name set in
+// ocp_table_schema option automatically.
+var migrationInfoTables = map[string]string{
+	DBSchemaOCPRecommendations: "migration_info",
+	DBSchemaDVORecommendations: "dvo.migration_info",
+}
+
+// checkMigrationCheckMode function checks value of migration_check option
+func checkMigrationCheckMode(mode string) error {
+	switch mode {
+	case "", MigrationCheckWarn, MigrationCheckRefuse:
+		return nil
+	default:
+		return fmt.Errorf("Incorrect migration check mode found in configuration: %s", mode)
+	}
+}
+
+// readMigrationVersion function reads version of aggregator database schema
+func readMigrationVersion(connection *sql.DB, table string) (int, error) {
+	var version int
+	err := queryRowStatement(connection, fmt.Sprintf(selectMigrationInfoVersion, table)).Scan(&version)
+	if err != nil {
+		return 0, queryFailed(table, err)
+	}
+	return version, nil
+}
+
+// checkMigrationVersion function checks that database has not been migrated
+// by newer version of aggregator than the cleaner has been built against.
+// Nothing is checked when migration_check option is not set or for plug-in
+// schemas.
+func checkMigrationVersion(connection *sql.DB, configuration *StorageConfiguration) error {
+	if configuration.MigrationCheck == "" {
+		return nil
+	}
+
+	supported, found := supportedMigrationVersions[configuration.Schema]
+	if !found {
+		log.Debug().
+			Str("schema", configuration.Schema).
+			Msg("Migration version is not checked for plug-in schema")
+		return nil
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	version, err := readMigrationVersion(connection, migrationInfoTables[configuration.Schema])
+	if err != nil {
+		log.Err(err).Msg("Read migration version")
+		return err
+	}
+
+	if version > supported {
+		if configuration.MigrationCheck == MigrationCheckWarn {
+			log.Warn().
+				Str("schema", configuration.Schema).
+				Int("version", version).
+				Int("supported", supported).
+				Msg("Database has been migrated by newer version of aggregator")
+			return nil
+		}
+		err := &ErrUnsupportedMigration{
+			Schema:    configuration.Schema,
+			Version:   version,
+			Supported: supported,
+		}
+		log.Error().
+			Str("schema", configuration.Schema).
+			Int("version", version).
+			Int("supported", supported).
+			Msg("Unsupported migration version")
+		return err
+	}
+
+	log.Debug().
+		Str("schema", configuration.Schema).
+		Int("version", version).
+		Msg("Migration version verified")
+	return nil
+}
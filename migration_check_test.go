@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/migration_check_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// Expected queries reading aggregator migration version
+const (
+	selectOCPMigrationVersion = "SELECT COALESCE\\(MAX\\(version\\), 0\\) FROM migration_info"
+	selectDVOMigrationVersion = "SELECT COALESCE\\(MAX\\(version\\), 0\\) FROM dvo.migration_info"
+)
+
+// expectMigrationVersion function registers expected query reading migration
+// version
+func expectMigrationVersion(mock sqlmock.Sqlmock, query string, version int) {
+	mock.ExpectQuery(query).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+// TestCheckMigrationVersion checks that supported migration versions are
+// accepted
+func TestCheckMigrationVersion(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectMigrationVersion(mock, selectOCPMigrationVersion, 1)
+	expectMigrationVersion(mock, selectDVOMigrationVersion, 1)
+	mock.ExpectClose()
+
+	for _, schema := range []string{cleaner.DBSchemaOCPRecommendations, cleaner.DBSchemaDVORecommendations} {
+		err = cleaner.CheckMigrationVersion(connection, &cleaner.StorageConfiguration{
+			Schema:         schema,
+			MigrationCheck: cleaner.MigrationCheckRefuse,
+		})
+		assert.NoError(t, err)
+	}
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckMigrationVersionNotChecked checks that migration version is not
+// read when the check is disabled or for plug-in schemas
+func TestCheckMigrationVersionNotChecked(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	mock.ExpectClose()
+
+	err = cleaner.CheckMigrationVersion(connection, &cleaner.StorageConfiguration{
+		Schema: cleaner.DBSchemaOCPRecommendations,
+	})
+	assert.NoError(t, err)
+
+	err = cleaner.CheckMigrationVersion(connection, &cleaner.StorageConfiguration{
+		Schema:         "notifications",
+		MigrationCheck: cleaner.MigrationCheckRefuse,
+	})
+	assert.NoError(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckMigrationVersionNewer checks that newer migration version is
+// refused or just logged depending on configuration
+func TestCheckMigrationVersionNewer(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectMigrationVersion(mock, selectOCPMigrationVersion, 1000)
+	expectMigrationVersion(mock, selectOCPMigrationVersion, 1000)
+	mock.ExpectClose()
+
+	configuration := cleaner.StorageConfiguration{
+		Schema:         cleaner.DBSchemaOCPRecommendations,
+		MigrationCheck: cleaner.MigrationCheckRefuse,
+	}
+	err = cleaner.CheckMigrationVersion(connection, &configuration)
+	var migrationErr *cleaner.ErrUnsupportedMigration
+	assert.ErrorAs(t, err, &migrationErr)
+	assert.Equal(t, 1000, migrationErr.Version)
+
+	configuration.MigrationCheck = cleaner.MigrationCheckWarn
+	err = cleaner.CheckMigrationVersion(connection, &configuration)
+	assert.NoError(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckMigrationVersionOnError checks that error is returned when
+// migration version can not be read
+func TestCheckMigrationVersionOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+	mock.ExpectQuery(selectOCPMigrationVersion).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	configuration := cleaner.StorageConfiguration{
+		Schema:         cleaner.DBSchemaOCPRecommendations,
+		MigrationCheck: cleaner.MigrationCheckWarn,
+	}
+	err = cleaner.CheckMigrationVersion(connection, &configuration)
+	assert.ErrorIs(t, err, mockedError)
+
+	// connection needs to be established
+	err = cleaner.CheckMigrationVersion(nil, &configuration)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
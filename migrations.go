@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/migrations.html
+
+// This source file contains implementation of migrations of tables owned by
+// the cleaner itself (currently the run history table, see history.go).
+// Migrations are SQL files embedded into the binary from migrations
+// directory. Each file is named NNNN_description.sql, where NNNN is version
+// of the migration. Versions need to start from 1 and they need to be
+// contiguous. Name of history table is inserted into the statement instead
+// of {{history_table}} placeholder.
+//
+// Applied versions are recorded in cleaner_migration_info table separately
+// for each history table. Migrations are applied automatically before the
+// selected operation is started when history table is specified in
+// configuration. Each migration is applied in its own transaction together
+// with record of its version.
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// migrationFiles contains all migrations of tables owned by the cleaner
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Constants used by migrations
+const (
+	migrationsDirectory        = "migrations"
+	migrationInfoTable         = "cleaner_migration_info"
+	historyTablePlaceholder    = "{{history_table}}"
+	migrationVersionAttribute  = "version"
+	migrationAttribute         = "migration"
+	historyTableAttribute      = "history table"
+	migrateCleanerTablesFailed = "Migrate cleaner tables"
+)
+
+// SQL statements used to record applied migrations
+const (
+	createMigrationInfoStatement = `
+	    CREATE TABLE IF NOT EXISTS cleaner_migration_info (
+	        history_table VARCHAR NOT NULL,
+	        version       INTEGER NOT NULL,
+	        name          VARCHAR NOT NULL,
+	        applied_at    TIMESTAMP NOT NULL,
+	        PRIMARY KEY(history_table, version)
+	    )`
+
+	selectMigrationVersionStatement = `
+	    SELECT COALESCE(MAX(version), 0)
+	      FROM cleaner_migration_info
+	     WHERE history_table = $1`
+
+	insertMigrationVersionStatement = `
+	    INSERT INTO cleaner_migration_info (history_table, version, name, applied_at)
+	    VALUES ($1, $2, $3, $4)`
+)
+
+// migrationFileName matches name of migration file with its version and
+// description
+var migrationFileName = regexp.MustCompile(`^(\d{4})_(\w+)\.sql$`)
+
+// migration represents one migration of tables owned by the cleaner
+type migration struct {
+	version   int
+	name      string
+	statement string
+}
+
+// readMigrations function reads all migrations from given file system and
+// checks that their versions are contiguous
+func readMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, migrationsDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("improper name of migration file: %s", entry.Name())
+		}
+		// the regular expression accepts digits only
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, migrationsDirectory+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{
+			version:   version,
+			name:      match[2],
+			statement: string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, migration := range migrations {
+		if migration.version != i+1 {
+			return nil, fmt.Errorf("migration version %d found where version %d is expected",
+				migration.version, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// migrationStatement function inserts quoted name of history table into
+// statement of given migration
+func migrationStatement(migration migration, historyTable string) string {
+	return strings.ReplaceAll(migration.statement, historyTablePlaceholder, pq.QuoteIdentifier(historyTable))
+}
+
+// applyMigration function applies one migration and records its version in
+// one transaction
+func applyMigration(connection *sql.DB, migration migration, historyTable string) error {
+	tx, err := connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = execStatement(tx, migrationStatement(migration, historyTable))
+	if err == nil {
+		_, err = execStatement(tx, insertMigrationVersionStatement,
+			historyTable, migration.version, migration.name, time.Now().UTC())
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+		}
+		return fmt.Errorf("migration %d (%s) failed: %w", migration.version, migration.name, err)
+	}
+	return tx.Commit()
+}
+
+// migrateCleanerTables function applies all migrations of tables owned by
+// the cleaner that have not been applied yet. Nothing is done when history
+// table is not specified in configuration.
+func migrateCleanerTables(connection *sql.DB, historyTable string) error {
+	if historyTable == "" {
+		return nil
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	migrations, err := readMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	_, err = execStatement(connection, createMigrationInfoStatement)
+	if err != nil {
+		return queryFailed(migrationInfoTable, err)
+	}
+
+	var version int
+	err = queryRowStatement(connection, selectMigrationVersionStatement, historyTable).Scan(&version)
+	if err != nil {
+		return queryFailed(migrationInfoTable, err)
+	}
+
+	// tables migrated by newer version of the cleaner are left untouched
+	if version > len(migrations) {
+		log.Warn().
+			Str(historyTableAttribute, historyTable).
+			Int(migrationVersionAttribute, version).
+			Int("supported version", len(migrations)).
+			Msg("Cleaner tables have been migrated by newer version of the cleaner")
+		return nil
+	}
+
+	var errs []error
+	for _, migration := range migrations[version:] {
+		err = applyMigration(connection, migration, historyTable)
+		if err != nil {
+			errs = append(errs, queryFailed(historyTable, err))
+			break
+		}
+		log.Info().
+			Str(historyTableAttribute, historyTable).
+			Int(migrationVersionAttribute, migration.version).
+			Str(migrationAttribute, migration.name).
+			Msg("Migration applied")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,11 @@
+-- run history table, see [history] section of configuration file
+CREATE TABLE IF NOT EXISTS {{history_table}} (
+    run_id       VARCHAR NOT NULL,
+    operation    VARCHAR NOT NULL,
+    target       VARCHAR NOT NULL,
+    started_at   TIMESTAMP NOT NULL,
+    finished_at  TIMESTAMP NOT NULL,
+    exit_status  INTEGER NOT NULL,
+    deletions    VARCHAR NOT NULL,
+    org_clusters VARCHAR NOT NULL
+)
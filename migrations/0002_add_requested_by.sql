@@ -0,0 +1,2 @@
+-- identity of operator or service that triggered the run
+ALTER TABLE {{history_table}} ADD COLUMN requested_by VARCHAR NOT NULL DEFAULT ''
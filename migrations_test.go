@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/migrations_test.html
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// Expected statements performed by migrations
+const (
+	createMigrationInfo    = "CREATE TABLE IF NOT EXISTS cleaner_migration_info"
+	selectMigrationVersion = "SELECT COALESCE\\(MAX\\(version\\), 0\\)"
+	insertMigrationVersion = "INSERT INTO cleaner_migration_info"
+	createRunHistory       = `CREATE TABLE IF NOT EXISTS "run_history"`
+	addRequestedBy         = `ALTER TABLE "run_history" ADD COLUMN requested_by`
+)
+
+// TestReadEmbeddedMigrations checks that migrations embedded into the
+// binary are correct
+func TestReadEmbeddedMigrations(t *testing.T) {
+	migrations, err := cleaner.ReadMigrations(cleaner.MigrationFiles)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+}
+
+// TestReadMigrations checks ordering and validation of migration files
+func TestReadMigrations(t *testing.T) {
+	migrations, err := cleaner.ReadMigrations(fstest.MapFS{
+		"migrations/0002_second.sql": {Data: []byte("ALTER TABLE x")},
+		"migrations/0001_first.sql":  {Data: []byte("CREATE TABLE x")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+
+	for _, files := range []fstest.MapFS{
+		// versions are not contiguous
+		{
+			"migrations/0001_first.sql": {Data: []byte("CREATE TABLE x")},
+			"migrations/0003_third.sql": {Data: []byte("ALTER TABLE x")},
+		},
+		// versions need to start from 1
+		{
+			"migrations/0002_second.sql": {Data: []byte("ALTER TABLE x")},
+		},
+		// duplicated version
+		{
+			"migrations/0001_first.sql":  {Data: []byte("CREATE TABLE x")},
+			"migrations/0001_second.sql": {Data: []byte("ALTER TABLE x")},
+		},
+		// improper name
+		{
+			"migrations/first.sql": {Data: []byte("CREATE TABLE x")},
+		},
+		// no migrations directory
+		{},
+	} {
+		_, err = cleaner.ReadMigrations(files)
+		assert.Error(t, err)
+	}
+}
+
+// TestMigrateCleanerTables checks that pending migrations are applied and
+// recorded
+func TestMigrateCleanerTables(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec(createMigrationInfo).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectMigrationVersion).
+		WithArgs("run_history").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(createRunHistory).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertMigrationVersion).
+		WithArgs("run_history", 1, "create_run_history", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(addRequestedBy).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertMigrationVersion).
+		WithArgs("run_history", 2, "add_requested_by", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	err = cleaner.MigrateCleanerTables(connection, "run_history")
+	assert.NoError(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestMigrateCleanerTablesUpToDate checks that nothing is applied when all
+// migrations have been applied already or by newer version of the cleaner
+func TestMigrateCleanerTablesUpToDate(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	migrations, err := cleaner.ReadMigrations(cleaner.MigrationFiles)
+	assert.NoError(t, err)
+
+	for _, version := range []int{len(migrations), len(migrations) + 1} {
+		mock.ExpectExec(createMigrationInfo).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(selectMigrationVersion).
+			WithArgs("run_history").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+	mock.ExpectClose()
+
+	for range []int{0, 1} {
+		err = cleaner.MigrateCleanerTables(connection, "run_history")
+		assert.NoError(t, err)
+	}
+
+	// nothing is done when history table is not configured
+	err = cleaner.MigrateCleanerTables(connection, "")
+	assert.NoError(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestMigrateCleanerTablesOnError checks that failed migration is rolled
+// back and not recorded
+func TestMigrateCleanerTablesOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mockedError := errors.New("mocked error")
+
+	mock.ExpectExec(createMigrationInfo).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectMigrationVersion).
+		WithArgs("run_history").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(createRunHistory).
+		WillReturnError(mockedError)
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	err = cleaner.MigrateCleanerTables(connection, "run_history")
+	assert.ErrorIs(t, err, mockedError)
+
+	var queryErr *cleaner.ErrQueryFailed
+	assert.True(t, errors.As(err, &queryErr))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestMigrateCleanerTablesNoConnection checks that migrations need
+// established connection
+func TestMigrateCleanerTablesNoConnection(t *testing.T) {
+	err := cleaner.MigrateCleanerTables(nil, "run_history")
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+}
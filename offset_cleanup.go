@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/offset_cleanup.html
+
+// This source file contains implementation of cleanup keyed by Kafka offsets.
+// It is used for incident recovery when bad producer has sent reports that
+// need to be removed: reports stored from messages with offsets in selected
+// range (inclusive) are deleted together with their rule hits. Records to be
+// deleted are counted first and nothing is deleted in dry run mode. Records
+// are really deleted only when -force is specified too.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// kafkaOffsetRangeSeparator separates the first and the last offset in
+// offset range specification
+const kafkaOffsetRangeSeparator = "-"
+
+// ErrForceRequired is returned when cleanup by Kafka offsets is not run in
+// dry run mode and -force is not specified
+var ErrForceRequired = errors.New("cleanup by Kafka offsets needs to be confirmed by -force")
+
+// KafkaOffsetRange represents inclusive range of Kafka offsets
+type KafkaOffsetRange struct {
+	From int64
+	To   int64
+}
+
+// tableAndCondition represents table together with condition that selects
+// records to be deleted from it
+type tableAndCondition struct {
+	table     string
+	condition string
+}
+
+// tablesWithKafkaOffsets contains tables with records selected by offset
+// range. Rule hits need to be deleted first, because they are selected by
+// reports.
+var tablesWithKafkaOffsets = []tableAndCondition{
+	{
+		table: "rule_hit",
+		condition: ` WHERE EXISTS (
+			SELECT 1
+			  FROM report
+			 WHERE report.cluster = rule_hit.cluster_id
+			   AND report.org_id = rule_hit.org_id
+			   AND report.kafka_offset BETWEEN $1 AND $2)`,
+	},
+	{
+		table:     "report",
+		condition: " WHERE kafka_offset BETWEEN $1 AND $2",
+	},
+}
+
+// parseKafkaOffsetRange function parses offset range specified as FROM-TO
+func parseKafkaOffsetRange(input string) (KafkaOffsetRange, error) {
+	var offsetRange KafkaOffsetRange
+
+	parts := strings.Split(strings.TrimSpace(input), kafkaOffsetRangeSeparator)
+	if len(parts) != 2 {
+		return offsetRange, fmt.Errorf("Kafka offset range '%s' needs to be specified as FROM-TO", input)
+	}
+
+	from, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return offsetRange, fmt.Errorf("Incorrect first offset in range '%s': %w", input, err)
+	}
+	to, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil {
+		return offsetRange, fmt.Errorf("Incorrect last offset in range '%s': %w", input, err)
+	}
+	if from > to {
+		return offsetRange, fmt.Errorf("First offset is greater than last offset in range '%s'", input)
+	}
+
+	offsetRange.From = from
+	offsetRange.To = to
+	return offsetRange, nil
+}
+
+// performKafkaOffsetsCleanupInDB function deletes reports stored from
+// messages with Kafka offsets in given range together with their rule hits.
+// Records to be deleted are counted first and nothing is deleted in dry run
+// mode.
+func performKafkaOffsetsCleanupInDB(connection *sql.DB, offsetRange KafkaOffsetRange, schema string, dryRun bool) (
+	map[string]int, error) {
+	deletionsForTable := make(map[string]int)
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return deletionsForTable, ErrNoConnection
+	}
+
+	// Kafka offsets are stored in OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		return deletionsForTable, invalidSchema(schema)
+	}
+
+	log.Info().
+		Int64("from", offsetRange.From).
+		Int64("to", offsetRange.To).
+		Bool("Dry run", dryRun).
+		Msg("Cleanup by Kafka offsets started")
+
+	args := []interface{}{offsetRange.From, offsetRange.To}
+
+	// count records to be deleted first
+	for _, tableAndCondition := range tablesWithKafkaOffsets {
+		count, err := countRecordsInTable(connection, tableAndCondition.table, tableAndCondition.condition, args)
+		if err != nil {
+			return deletionsForTable, err
+		}
+		log.Info().
+			Int("count", count).
+			Str(tableName, tableAndCondition.table).
+			Msg("Records to be deleted")
+		deletionsForTable[tableAndCondition.table] = count
+	}
+
+	if dryRun {
+		log.Info().Msg("Dry run, no records deleted")
+		return deletionsForTable, nil
+	}
+
+	// and then delete them; reports are not deleted when their rule hits
+	// can not be deleted, so the cleanup can be repeated
+	for _, tableAndCondition := range tablesWithKafkaOffsets {
+		affected, err := deleteRecordsFromTable(connection, tableAndCondition.table, tableAndCondition.condition, args)
+		if err != nil {
+			return deletionsForTable, err
+		}
+		log.Info().
+			Int(affectedMsg, affected).
+			Str(tableName, tableAndCondition.table).
+			Msg("Records deleted")
+		deletionsForTable[tableAndCondition.table] = affected
+	}
+
+	log.Info().Msg("Cleanup by Kafka offsets finished")
+	return deletionsForTable, nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/offset_cleanup_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// Expected statements performed by cleanup by Kafka offsets
+const (
+	countRuleHitsByOffset  = "SELECT COUNT\\(\\*\\) FROM rule_hit WHERE EXISTS .* report.kafka_offset BETWEEN \\$1 AND \\$2\\);"
+	countReportsByOffset   = "SELECT COUNT\\(\\*\\) FROM report WHERE kafka_offset BETWEEN \\$1 AND \\$2;"
+	deleteRuleHitsByOffset = "DELETE FROM rule_hit WHERE EXISTS .* report.kafka_offset BETWEEN \\$1 AND \\$2\\);"
+	deleteReportsByOffset  = "DELETE FROM report WHERE kafka_offset BETWEEN \\$1 AND \\$2;"
+)
+
+// TestParseKafkaOffsetRange checks parsing of proper and improper offset
+// ranges
+func TestParseKafkaOffsetRange(t *testing.T) {
+	offsetRange, err := cleaner.ParseKafkaOffsetRange(" 100 - 200 ")
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.KafkaOffsetRange{From: 100, To: 200}, offsetRange)
+
+	offsetRange, err = cleaner.ParseKafkaOffsetRange("100-100")
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.KafkaOffsetRange{From: 100, To: 100}, offsetRange)
+
+	for _, input := range []string{"", "100", "100-", "x-200", "100-y", "200-100", "1-2-3"} {
+		_, err = cleaner.ParseKafkaOffsetRange(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// expectKafkaOffsetsCount function registers expected counting of records
+// selected by offset range
+func expectKafkaOffsetsCount(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(countRuleHitsByOffset).
+		WithArgs(100, 200).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(countReportsByOffset).
+		WithArgs(100, 200).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+}
+
+// TestPerformKafkaOffsetsCleanupInDB checks that rule hits are deleted
+// before reports
+func TestPerformKafkaOffsetsCleanupInDB(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectKafkaOffsetsCount(mock)
+	mock.ExpectExec(deleteRuleHitsByOffset).
+		WithArgs(100, 200).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectExec(deleteReportsByOffset).
+		WithArgs(100, 200).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectClose()
+
+	deletedRows, err := cleaner.PerformKafkaOffsetsCleanupInDB(connection,
+		cleaner.KafkaOffsetRange{From: 100, To: 200}, cleaner.DBSchemaOCPRecommendations, false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"rule_hit": 7, "report": 3}, deletedRows)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformKafkaOffsetsCleanupInDBDryRun checks that nothing is deleted in
+// dry run mode
+func TestPerformKafkaOffsetsCleanupInDBDryRun(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectKafkaOffsetsCount(mock)
+	mock.ExpectClose()
+
+	deletedRows, err := cleaner.PerformKafkaOffsetsCleanupInDB(connection,
+		cleaner.KafkaOffsetRange{From: 100, To: 200}, cleaner.DBSchemaOCPRecommendations, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"rule_hit": 7, "report": 3}, deletedRows)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformKafkaOffsetsCleanupInDBOnError checks that reports are not
+// deleted when rule hits can not be deleted
+func TestPerformKafkaOffsetsCleanupInDBOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectKafkaOffsetsCount(mock)
+	mock.ExpectExec(deleteRuleHitsByOffset).
+		WithArgs(100, 200).
+		WillReturnError(errors.New("delete error"))
+	mock.ExpectClose()
+
+	_, err = cleaner.PerformKafkaOffsetsCleanupInDB(connection,
+		cleaner.KafkaOffsetRange{From: 100, To: 200}, cleaner.DBSchemaOCPRecommendations, false)
+	assert.Error(t, err)
+
+	// DVO database is not supported
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.PerformKafkaOffsetsCleanupInDB(connection,
+		cleaner.KafkaOffsetRange{From: 100, To: 200}, cleaner.DBSchemaDVORecommendations, true)
+	assert.True(t, errors.As(err, &schemaErr))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupKafkaOffsetsNotForced checks that records are not deleted
+// without -force
+func TestCleanupKafkaOffsetsNotForced(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	cliFlags := cleaner.CliFlags{
+		CleanupKafkaOffsets: "100-200",
+		DryRun:              false,
+	}
+	status, err := cleaner.CleanupKafkaOffsets(&cleaner.ConfigStruct{}, connection, cliFlags,
+		cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrForceRequired)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupKafkaOffsetsForced checks that records are deleted when -force
+// is specified
+func TestCleanupKafkaOffsetsForced(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectKafkaOffsetsCount(mock)
+	mock.ExpectExec(deleteRuleHitsByOffset).
+		WithArgs(100, 200).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectExec(deleteReportsByOffset).
+		WithArgs(100, 200).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectClose()
+
+	cliFlags := cleaner.CliFlags{
+		CleanupKafkaOffsets: "100-200",
+		DryRun:              false,
+		Force:               true,
+	}
+	status, err := cleaner.CleanupKafkaOffsets(&cleaner.ConfigStruct{}, connection, cliFlags,
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/org_batch.html
+
+// This source file contains implementation of organization batch erasure
+// used to process backlog of account-closure requests. Organization IDs are
+// read from input file (one ID per line) and erased sequentially: all
+// clusters that belong to the organization are cleaned up and, for OCP
+// recommendations schema, Advisor ratings of the organization are deleted.
+//
+// Each organization that is erased successfully is appended into checkpoint
+// file (input file name with .checkpoint suffix), so interrupted batch can be
+// restarted and already erased organizations are skipped. Summary is logged
+// for each organization and consolidated report is displayed when the whole
+// batch is finished.
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointSuffix is appended to org batch file name to get name of
+// checkpoint file
+const checkpointSuffix = ".checkpoint"
+
+// Statuses of organizations in org batch report
+const (
+	orgErasureErased  = "erased"
+	orgErasureSkipped = "skipped"
+	orgErasureFailed  = "failed"
+)
+
+// Messages and attributes used in logs
+const (
+	orgBatchFileAttribute = "org batch file"
+	improperOrgIDMsg      = "Improper organization ID"
+)
+
+// Statements used to find all clusters that belong to organization
+const (
+	// clusters might have rule hits without report
+	selectOCPClustersForOrg = `
+	    SELECT cluster FROM report WHERE org_id = $1
+	     UNION
+	    SELECT cluster_id FROM rule_hit WHERE org_id = $1
+	     ORDER BY 1`
+
+	selectDVOClustersForOrg = `
+	    SELECT DISTINCT cluster_id FROM dvo_report WHERE org_id = $1
+	     ORDER BY cluster_id`
+)
+
+// OrgErasure represents result of erasure of one organization
+type OrgErasure struct {
+	OrgID             int
+	Status            string
+	Clusters          int
+	FailedClusters    int
+	DeletionsForTable map[string]int
+	Err               error
+}
+
+// DeletedRows method returns number of rows deleted for organization from
+// all tables
+func (erasure OrgErasure) DeletedRows() int {
+	total := 0
+	for _, deletions := range erasure.DeletionsForTable {
+		total += deletions
+	}
+	return total
+}
+
+// OrgBatchReport represents consolidated report about the whole org batch
+type OrgBatchReport struct {
+	ImproperEntries int
+	Erasures        []OrgErasure
+}
+
+// Count method returns number of organizations with given status
+func (report OrgBatchReport) Count(status string) int {
+	count := 0
+	for _, erasure := range report.Erasures {
+		if erasure.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// DeletionsForTable method returns number of rows deleted from each table
+// for all organizations
+func (report OrgBatchReport) DeletionsForTable() map[string]int {
+	deletionsForTable := make(map[string]int)
+	for _, erasure := range report.Erasures {
+		for tableName, deletions := range erasure.DeletionsForTable {
+			deletionsForTable[tableName] += deletions
+		}
+	}
+	return deletionsForTable
+}
+
+// ClustersForOrg method returns number of cleaned up clusters for each
+// erased organization
+func (report OrgBatchReport) ClustersForOrg() map[int]int {
+	clustersForOrg := make(map[int]int)
+	for _, erasure := range report.Erasures {
+		if erasure.Status == orgErasureErased {
+			clustersForOrg[erasure.OrgID] = erasure.Clusters
+		}
+	}
+	return clustersForOrg
+}
+
+// readOrgIDsFromReader function reads organization IDs from provided reader,
+// one ID per line. Empty lines are ignored, duplicate IDs are read just once.
+func readOrgIDsFromReader(input io.Reader) ([]int, int, error) {
+	var orgIDs []int
+	improperEntries := 0
+	seen := make(map[int]struct{})
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		orgID, err := strconv.Atoi(line)
+		if err != nil || orgID <= 0 {
+			log.Error().Str("input", line).Msg(improperOrgIDMsg)
+			improperEntries++
+			continue
+		}
+		if _, found := seen[orgID]; found {
+			continue
+		}
+		seen[orgID] = struct{}{}
+		orgIDs = append(orgIDs, orgID)
+	}
+	return orgIDs, improperEntries, scanner.Err()
+}
+
+// readOrgIDsFromFile function reads organization IDs from provided text file
+func readOrgIDsFromFile(filename string) ([]int, int, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(filename) // #nosec G304
+	if err != nil {
+		return nil, 0, err
+	}
+
+	orgIDs, improperEntries, readErr := readOrgIDsFromReader(file)
+
+	// close file and catch any I/O error
+	err = file.Close()
+	if err != nil {
+		log.Err(err).Msg("File close failed")
+	}
+	return orgIDs, improperEntries, errors.Join(readErr, err)
+}
+
+// readOrgCheckpoint function reads organizations that have been erased
+// already. Missing checkpoint file means that no organization has been erased.
+func readOrgCheckpoint(filename string) (map[int]struct{}, error) {
+	erased := make(map[int]struct{})
+
+	orgIDs, _, err := readOrgIDsFromFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return erased, nil
+	}
+	if err != nil {
+		return erased, err
+	}
+
+	for _, orgID := range orgIDs {
+		erased[orgID] = struct{}{}
+	}
+	return erased, nil
+}
+
+// appendOrgCheckpoint function appends erased organization into checkpoint
+// file
+func appendOrgCheckpoint(filename string, orgID int) error {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := fmt.Fprintln(file, orgID)
+	return errors.Join(writeErr, file.Close())
+}
+
+// readClustersForOrg function reads all clusters that belong to given
+// organization
+func readClustersForOrg(connection *sql.DB, schema string, orgID int) (ClusterList, error) {
+	clusterList := ClusterList{}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return clusterList, ErrNoConnection
+	}
+
+	var query string
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		query = selectOCPClustersForOrg
+	case DBSchemaDVORecommendations:
+		query = selectDVOClustersForOrg
+	default:
+		return clusterList, invalidSchema(schema)
+	}
+
+	err := queryRows(connection, query, []interface{}{orgID}, func(rows *sql.Rows) error {
+		var cluster string
+		if err := rows.Scan(&cluster); err != nil {
+			return err
+		}
+		clusterList = append(clusterList, ClusterName(cluster))
+		return nil
+	})
+	return clusterList, err
+}
+
+// eraseOrg function deletes all records of given organization
+func eraseOrg(configuration *ConfigStruct, connection *sql.DB, schema string,
+	orgID int, transactional bool) OrgErasure {
+	erasure := OrgErasure{
+		OrgID:             orgID,
+		Status:            orgErasureFailed,
+		DeletionsForTable: map[string]int{},
+	}
+
+	clusterList, err := readClustersForOrg(connection, schema, orgID)
+	if err != nil {
+		erasure.Err = err
+		return erasure
+	}
+	clusterList, _ = excludeProtectedClusters(clusterList, configuration.Cleaner.ProtectedClusters)
+	erasure.Clusters = len(clusterList)
+
+	deletionsForTable, _, failedClusters, err := performClustersCleanupInDB(connection,
+		clusterList, schema, orgID, configuration.Cleaner.ClusterRetries, transactional)
+	erasure.DeletionsForTable = deletionsForTable
+	erasure.FailedClusters = len(failedClusters)
+	if err != nil {
+		erasure.Err = err
+		return erasure
+	}
+
+	// Advisor ratings are not related to clusters
+	if schema == DBSchemaOCPRecommendations {
+		ratingsDeletions, err := performRatingsCleanupInDB(connection, orgID, "", "", schema, false)
+		for tableName, deletions := range ratingsDeletions {
+			erasure.DeletionsForTable[tableName] += deletions
+		}
+		if err != nil {
+			erasure.Err = err
+			return erasure
+		}
+	}
+
+	erasure.Status = orgErasureErased
+	return erasure
+}
+
+// logOrgErasure function logs summary about erasure of one organization
+func logOrgErasure(erasure OrgErasure) {
+	event := log.Info()
+	if erasure.Err != nil {
+		event = log.Error().Err(erasure.Err)
+	}
+	event.
+		Int(orgIDMsg, erasure.OrgID).
+		Str("status", erasure.Status).
+		Int("clusters", erasure.Clusters).
+		Int("failed clusters", erasure.FailedClusters).
+		Int("deleted rows", erasure.DeletedRows()).
+		Msg("Organization erasure")
+}
+
+// orgCheckpointFile function returns name of checkpoint file for given org
+// batch file. Each storage target has its own checkpoint file, so
+// organizations erased from one target are not skipped in others.
+func orgCheckpointFile(configuration *ConfigStruct, filename string) string {
+	checkpointFile := filename + checkpointSuffix
+	if len(GetTargetsConfiguration(configuration)) > 0 {
+		return targetFileName(checkpointFile, configuration.Storage.Name)
+	}
+	return checkpointFile
+}
+
+// performOrgBatchErasure function erases all organizations listed in org
+// batch file that are not listed in checkpoint file yet
+func performOrgBatchErasure(configuration *ConfigStruct, connection *sql.DB, schema string,
+	filename string, transactional bool) (OrgBatchReport, error) {
+	var report OrgBatchReport
+
+	orgIDs, improperEntries, err := readOrgIDsFromFile(filename)
+	if err != nil {
+		return report, err
+	}
+	report.ImproperEntries = improperEntries
+
+	checkpointFile := orgCheckpointFile(configuration, filename)
+	erased, err := readOrgCheckpoint(checkpointFile)
+	if err != nil {
+		return report, err
+	}
+
+	log.Info().
+		Str(orgBatchFileAttribute, filename).
+		Int("organizations", len(orgIDs)).
+		Int("already erased", len(erased)).
+		Msg("Org batch erasure started")
+
+	var errs []error
+	for _, orgID := range orgIDs {
+		if _, found := erased[orgID]; found {
+			erasure := OrgErasure{OrgID: orgID, Status: orgErasureSkipped}
+			logOrgErasure(erasure)
+			report.Erasures = append(report.Erasures, erasure)
+			continue
+		}
+
+		erasure := eraseOrg(configuration, connection, schema, orgID, transactional)
+		if erasure.Err == nil {
+			erasure.Err = appendOrgCheckpoint(checkpointFile, orgID)
+		}
+		logOrgErasure(erasure)
+		report.Erasures = append(report.Erasures, erasure)
+
+		if erasure.Err != nil {
+			errs = append(errs, fmt.Errorf("organization %d: %w", orgID, erasure.Err))
+			// missing connection or wrong schema would fail for all
+			// other organizations too
+			if exitStatusForError(erasure.Err, ExitStatusPerformCleanupError) == ExitStatusStorageError {
+				break
+			}
+		}
+	}
+
+	log.Info().
+		Str(orgBatchFileAttribute, filename).
+		Int(orgErasureErased, report.Count(orgErasureErased)).
+		Int(orgErasureSkipped, report.Count(orgErasureSkipped)).
+		Int(orgErasureFailed, report.Count(orgErasureFailed)).
+		Msg("Org batch erasure finished")
+
+	return report, errors.Join(errs...)
+}
+
+// PrintOrgBatchReport function displays consolidated report about org batch
+// erasure
+func PrintOrgBatchReport(report OrgBatchReport) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Organization", "Status", "Clusters", "Deleted rows"})
+
+	totalClusters := 0
+	totalDeletions := 0
+	for _, erasure := range report.Erasures {
+		totalClusters += erasure.Clusters
+		totalDeletions += erasure.DeletedRows()
+		table.Append([]string{strconv.Itoa(erasure.OrgID), erasure.Status,
+			strconv.Itoa(erasure.Clusters), strconv.Itoa(erasure.DeletedRows())})
+	}
+
+	// table footer
+	table.SetFooter([]string{"Total", strconv.Itoa(len(report.Erasures)) + " organizations",
+		strconv.Itoa(totalClusters), strconv.Itoa(totalDeletions)})
+
+	// display the whole table
+	table.Render()
+
+	// deletions for each table, sorted by table name
+	deletionsForTable := report.DeletionsForTable()
+	tableNames := make([]string, 0, len(deletionsForTable))
+	for tableName := range deletionsForTable {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		fmt.Printf("Deletions from table '%s': %d\n", tableName, deletionsForTable[tableName])
+	}
+
+	fmt.Printf("Erased organizations: %d, skipped: %d, failed: %d, improper entries: %d\n",
+		report.Count(orgErasureErased), report.Count(orgErasureSkipped),
+		report.Count(orgErasureFailed), report.ImproperEntries)
+}
+
+// orgBatch function starts erasure of organizations listed in org batch file
+func orgBatch(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (ExitStatus, error) {
+	started := time.Now()
+	report, err := performOrgBatchErasure(configuration, connection, schema,
+		cliFlags.OrgBatch, cliFlags.Transactional)
+
+	exitStatus := ExitStatusOK
+	if err != nil {
+		log.Err(err).Msg("Performing org batch erasure")
+		exitStatus = exitStatusForError(err, ExitStatusPerformCleanupError)
+	}
+
+	deletionsForTable := report.DeletionsForTable()
+	recordDeletedRows(deletionsForTable)
+	recordRunHistoryOrWarn(configuration, connection,
+		newRunHistoryEntry(configuration, cliFlags, "org-batch", started, exitStatus,
+			deletionsForTable, report.ClustersForOrg()))
+
+	// nothing to report when org batch file can not be read
+	if len(report.Erasures) > 0 {
+		PrintOrgBatchReport(report)
+	}
+	return exitStatus, err
+}
@@ -0,0 +1,208 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/org_batch_test.html
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// writeOrgBatchFile function writes org batch file with given content into
+// temporary directory
+func writeOrgBatchFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "orgs.txt")
+	err := os.WriteFile(filename, []byte(content), 0o600)
+	assert.NoError(t, err)
+	return filename
+}
+
+// TestReadOrgIDsFromReader checks that improper, empty, and duplicate lines
+// are not part of org batch
+func TestReadOrgIDsFromReader(t *testing.T) {
+	input := "1\n\n  2 \nfoo\n-3\n1\r\n4"
+
+	orgIDs, improper, err := cleaner.ReadOrgIDsFromReader(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 4}, orgIDs)
+	assert.Equal(t, 2, improper)
+}
+
+// TestOrgCheckpoint checks that erased organizations are appended into
+// checkpoint file and read back
+func TestOrgCheckpoint(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "orgs.txt.checkpoint")
+
+	// missing checkpoint file
+	erased, err := cleaner.ReadOrgCheckpoint(filename)
+	assert.NoError(t, err)
+	assert.Empty(t, erased)
+
+	assert.NoError(t, cleaner.AppendOrgCheckpoint(filename, 1))
+	assert.NoError(t, cleaner.AppendOrgCheckpoint(filename, 2))
+
+	erased, err = cleaner.ReadOrgCheckpoint(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, erased)
+}
+
+// TestReadClustersForOrg checks that clusters of organization are read from
+// DVO database
+func TestReadClustersForOrg(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT DISTINCT cluster_id FROM dvo_report WHERE org_id = \\$1").
+		WithArgs(defaultOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"cluster_id"}).AddRow(cluster1ID).AddRow(cluster2ID))
+	mock.ExpectClose()
+
+	clusterList, err := cleaner.ReadClustersForOrg(connection, cleaner.DBSchemaDVORecommendations, defaultOrgID)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, clusterList)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadClustersForOrgWrongSchema checks that clusters can not be read
+// from unknown DB schema or without connection
+func TestReadClustersForOrgWrongSchema(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.ReadClustersForOrg(connection, "unknown", defaultOrgID)
+	assert.True(t, errors.As(err, &schemaErr))
+
+	_, err = cleaner.ReadClustersForOrg(nil, cleaner.DBSchemaDVORecommendations, defaultOrgID)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformOrgBatchErasure checks that organizations listed in checkpoint
+// file are skipped and erased organizations are appended into checkpoint file
+func TestPerformOrgBatchErasure(t *testing.T) {
+	filename := writeOrgBatchFile(t, "1\n2\nfoo\n")
+	assert.NoError(t, cleaner.AppendOrgCheckpoint(filename+".checkpoint", 1))
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT DISTINCT cluster_id FROM dvo_report WHERE org_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"cluster_id"}).AddRow(cluster1ID))
+	mock.ExpectExec("DELETE FROM dvo_report WHERE cluster_id = \\$1 AND org_id = \\$2").
+		WithArgs(cluster1ID, 2).
+		WillReturnResult(sqlmock.NewResult(1, 3))
+	mock.ExpectClose()
+
+	report, err := cleaner.PerformOrgBatchErasure(&cleaner.ConfigStruct{}, connection,
+		cleaner.DBSchemaDVORecommendations, filename, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.ImproperEntries)
+	assert.Equal(t, 1, report.Count("skipped"))
+	assert.Equal(t, 1, report.Count("erased"))
+	assert.Equal(t, map[string]int{"dvo_report": 3}, report.DeletionsForTable())
+	assert.Equal(t, map[int]int{2: 1}, report.ClustersForOrg())
+
+	erased, err := cleaner.ReadOrgCheckpoint(filename + ".checkpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, erased)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestOrgCheckpointFile checks that each storage target has its own
+// checkpoint file
+func TestOrgCheckpointFile(t *testing.T) {
+	configuration := cleaner.ConfigStruct{}
+	assert.Equal(t, "orgs.txt.checkpoint", cleaner.OrgCheckpointFile(&configuration, "orgs.txt"))
+
+	configuration.Targets = []cleaner.StorageConfiguration{{Name: "first"}, {Name: "second"}}
+	configuration.Storage = configuration.Targets[1]
+	assert.Equal(t, "orgs.txt-second.checkpoint", cleaner.OrgCheckpointFile(&configuration, "orgs.txt"))
+}
+
+// TestPerformOrgBatchErasureOnError checks that failed organization is not
+// appended into checkpoint file and other organizations are still erased
+func TestPerformOrgBatchErasureOnError(t *testing.T) {
+	filename := writeOrgBatchFile(t, "1\n2\n")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT DISTINCT cluster_id FROM dvo_report WHERE org_id = \\$1").
+		WithArgs(1).
+		WillReturnError(errors.New("query error"))
+	mock.ExpectQuery("SELECT DISTINCT cluster_id FROM dvo_report WHERE org_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"cluster_id"}))
+	mock.ExpectClose()
+
+	report, err := cleaner.PerformOrgBatchErasure(&cleaner.ConfigStruct{}, connection,
+		cleaner.DBSchemaDVORecommendations, filename, false)
+	assert.Error(t, err)
+	assert.Equal(t, 1, report.Count("failed"))
+	assert.Equal(t, 1, report.Count("erased"))
+
+	erased, err := cleaner.ReadOrgCheckpoint(filename + ".checkpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{2: {}}, erased)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestOrgBatchMissingFile checks exit status when org batch file does not
+// exist
+func TestOrgBatchMissingFile(t *testing.T) {
+	cliFlags := cleaner.CliFlags{
+		OrgBatch: filepath.Join(t.TempDir(), "missing.txt"),
+	}
+
+	status, err := cleaner.OrgBatch(&cleaner.ConfigStruct{}, nil, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+}
@@ -0,0 +1,536 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/output.html
+
+// This source file contains implementation of output files used to export
+// listings of old records. Listings are written into a temporary file first
+// and that file is renamed to its final name only when the listing has been
+// finished successfully. Partially written exports from crashed runs are
+// therefore never mistaken for complete audit artifacts. Optionally a file
+// with SHA-256 checksum (in format compatible with sha256sum tool) is written
+// next to the exported file.
+//
+// Timestamps in listings are formatted by layout specified in configuration
+// (RFC3339 is used by default) and can be optionally normalized to UTC. Age
+// of records is expressed in days by default, but it is possible to express
+// it in hours or as an exact duration string as well.
+//
+// Special output name "-" means that records are streamed to standard output
+// so the listing can be used in Unix pipelines. Logs are forced to standard
+// error output in this case.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Messages used by output file handling
+const (
+	renameFileMsg    = "Rename temporary file"
+	removeFileMsg    = "Remove temporary file"
+	writeChecksumMsg = "Write checksum file"
+	checksumSuffix   = ".sha256"
+)
+
+// Messages of row-level events logged for each old record, they can be
+// sampled (see log_sampling.go)
+const (
+	oldOCPReportMsg      = "Old OCP report"
+	oldDVOReportMsg      = "Old DVO report"
+	oldRatingMsg         = "Old Advisor rating"
+	oldConsumerErrorMsg  = "Old consumer error"
+	oldRecommendationMsg = "Old recommendation"
+	oldReportInfoMsg     = "Old report info"
+)
+
+// defaultTimeFormat is a layout used to format timestamps when no layout is
+// specified in configuration
+const defaultTimeFormat = time.RFC3339
+
+// namedTimeFormats contains layouts that can be specified by their names in
+// configuration. Any other value is used as Go time layout directly.
+var namedTimeFormats = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"DateTime":    "2006-01-02 15:04:05",
+	"DateOnly":    "2006-01-02",
+}
+
+// Units that can be used to express age of records
+const (
+	AgeUnitDays     = "days"
+	AgeUnitHours    = "hours"
+	AgeUnitDuration = "duration"
+)
+
+// standardOutputName is a special output name that means that records are
+// written to standard output instead of a file
+const standardOutputName = "-"
+
+// outputFile represents an export of listing into a file. All data are
+// written into a temporary file that is renamed to the requested name in
+// Close method.
+type outputFile struct {
+	filename string
+	tempFile *os.File
+	writer   *bufio.Writer
+	hash     hash.Hash
+}
+
+// createOutputFile function creates a temporary file in the same directory
+// as the requested output file. It returns nil when no output file has been
+// requested and error when the temporary file can not be created.
+func createOutputFile(output string, checksum bool) (*outputFile, error) {
+	if output == "" {
+		return nil, nil
+	}
+
+	out := outputFile{
+		filename: output,
+	}
+
+	// records are streamed to standard output, there is no file to be
+	// renamed and checksummed
+	if isStandardOutput(output) {
+		if checksum {
+			log.Warn().Msg("Checksum can not be computed for standard output")
+		}
+		out.writer = bufio.NewWriter(os.Stdout)
+		return &out, nil
+	}
+
+	// temporary file needs to be created in the same directory as the
+	// final file, otherwise the rename operation would not be atomic
+	directory, basename := filepath.Split(output)
+	if directory == "" {
+		directory = "."
+	}
+
+	fout, err := os.CreateTemp(directory, "."+basename+".*.tmp")
+	if err != nil {
+		log.Error().Err(err).Msg(fileOpenMsg)
+		return nil, err
+	}
+	out.tempFile = fout
+
+	var target io.Writer = fout
+	if checksum {
+		out.hash = sha256.New()
+		target = io.MultiWriter(fout, out.hash)
+	}
+
+	// an object used to write to file
+	out.writer = bufio.NewWriter(target)
+	return &out, nil
+}
+
+// Writer method returns writer to be used to export data. Nil is returned
+// when no output file has been requested.
+func (out *outputFile) Writer() *bufio.Writer {
+	if out == nil {
+		return nil
+	}
+	return out.writer
+}
+
+// Close method flushes and closes the temporary file. When the listing
+// finished successfully, the temporary file is renamed to its final name
+// and the checksum file is written (if enabled). Otherwise the temporary
+// file is removed. All problems are logged, the first one is returned.
+func (out *outputFile) Close(success bool) (closeErr error) {
+	if out == nil {
+		return nil
+	}
+
+	// output needs to be flushed at the end
+	if err := out.writer.Flush(); err != nil {
+		log.Error().Err(err).Msg(flushWriterMsg)
+		closeErr = err
+		success = false
+	}
+
+	// standard output or file that could not be created
+	if out.tempFile == nil {
+		return closeErr
+	}
+
+	// file needs to be closed at the end
+	if err := out.tempFile.Close(); err != nil {
+		log.Error().Err(err).Msg(fileCloseMsg)
+		if closeErr == nil {
+			closeErr = err
+		}
+		success = false
+	}
+
+	tempName := out.tempFile.Name()
+
+	// temporary files are created with 0600 permissions, but the export
+	// should be readable the same way as files created by os.Create
+	// disable G302 (CWE-276): Expect file permissions to be 0600 or less
+	if err := os.Chmod(tempName, 0644); err != nil { // #nosec G302
+		log.Error().Err(err).Msg(fileOpenMsg)
+	}
+
+	if !success {
+		if err := os.Remove(tempName); err != nil {
+			log.Error().Err(err).Msg(removeFileMsg)
+		}
+		return closeErr
+	}
+
+	if err := os.Rename(tempName, out.filename); err != nil {
+		log.Error().Err(err).Str(filenameAttribute, out.filename).Msg(renameFileMsg)
+		if err := os.Remove(tempName); err != nil {
+			log.Error().Err(err).Msg(removeFileMsg)
+		}
+		return err
+	}
+
+	if out.hash != nil {
+		if err := writeChecksumFile(out.filename, out.hash.Sum(nil)); err != nil {
+			log.Error().Err(err).Str(filenameAttribute, out.filename).Msg(writeChecksumMsg)
+			return err
+		}
+	}
+	return nil
+}
+
+// formatTimestamp function formats given timestamp according to output
+// configuration
+func formatTimestamp(timestamp time.Time, outputConfig OutputConfiguration) string {
+	if outputConfig.UTC {
+		timestamp = timestamp.UTC()
+	}
+
+	return timestamp.Format(timeLayout(outputConfig.TimeFormat))
+}
+
+// timeLayout function returns Go time layout for time format specified in
+// configuration. Named formats are translated to their layouts.
+func timeLayout(timeFormat string) string {
+	if timeFormat == "" {
+		return defaultTimeFormat
+	}
+	if named, found := namedTimeFormats[timeFormat]; found {
+		return named
+	}
+	return timeFormat
+}
+
+// checkTimeFormat function checks that time format specified in
+// configuration is usable. Known time is formatted and parsed back, the
+// result needs to be the same text with the same date, otherwise the layout
+// contains a typo or it does not contain date at all.
+func checkTimeFormat(timeFormat string) error {
+	layout := timeLayout(timeFormat)
+	known := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	formatted := known.Format(layout)
+
+	parsed, err := time.Parse(layout, formatted)
+	if err != nil || parsed.Format(layout) != formatted ||
+		parsed.Year() != known.Year() || parsed.YearDay() != known.YearDay() {
+		return fmt.Errorf("Incorrect time format: %s", timeFormat)
+	}
+	return nil
+}
+
+// ageInUnits function computes age of record rounded up to whole days or
+// hours
+func ageInUnits(age time.Duration, unit string) int {
+	if unit == AgeUnitHours {
+		return int(math.Ceil(age.Hours()))
+	}
+	return int(math.Ceil(age.Hours() / 24)) // in days
+}
+
+// formatAge function formats age of record in units specified in output
+// configuration
+func formatAge(age time.Duration, outputConfig OutputConfiguration) string {
+	if outputConfig.AgeUnit == AgeUnitDuration {
+		return age.Round(time.Second).String()
+	}
+	return strconv.Itoa(ageInUnits(age, outputConfig.AgeUnit))
+}
+
+// logAge function adds age of record into log event. Age is logged as
+// integer when expressed in days or hours and as string when exact duration
+// is requested.
+func logAge(event *zerolog.Event, key string, age time.Duration, outputConfig OutputConfiguration) *zerolog.Event {
+	if outputConfig.AgeUnit == AgeUnitDuration {
+		return event.Str(key, formatAge(age, outputConfig))
+	}
+	return event.Int(key, ageInUnits(age, outputConfig.AgeUnit))
+}
+
+// sizeSnapshotHeader is a header written into new file with size snapshots
+const sizeSnapshotHeader = "timestamp,run_id,table,size_bytes,rows\n"
+
+// displayOldOCPReport function displays one old OCP report and writes it
+// into output sink
+func displayOldOCPReport(report OldOCPReport, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	reportedF := formatTimestamp(report.Reported, outputConfig)
+	lastCheckedF := formatTimestamp(report.LastChecked, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldOCPReportMsg).Str(clusterNameMsg, report.ClusterName).
+		Str(reportedMsg, reportedF).
+		Str(lastCheckedMsg, lastCheckedF)
+	logAge(event, ageMsg, report.Age, outputConfig).
+		Msg(oldOCPReportMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"cluster", report.ClusterName},
+		{"reported_at", reportedF},
+		{"last_checked_at", lastCheckedF},
+		{"age", formatAge(report.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// displayOldDVOReport function displays one old DVO report and writes it
+// into output sink
+func displayOldDVOReport(report OldDVOReport, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	reportedF := formatTimestamp(report.Reported, outputConfig)
+	lastCheckedF := formatTimestamp(report.LastChecked, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldDVOReportMsg).Str(clusterNameMsg, report.ClusterName).
+		Str(namespaceIDMsg, report.NamespaceID).
+		Str(namespaceNameMsg, report.NamespaceName).
+		Int(recommendationsMsg, report.Recommendations).
+		Int(objectsMsg, report.Objects).
+		Str(reportedMsg, reportedF).
+		Str(lastCheckedMsg, lastCheckedF)
+	logAge(event, ageMsg, report.Age, outputConfig).
+		Msg(oldDVOReportMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"org_id", report.OrgID},
+		{"cluster", report.ClusterName},
+		{"namespace_id", report.NamespaceID},
+		{"namespace_name", report.NamespaceName},
+		{"recommendations", report.Recommendations},
+		{"objects", report.Objects},
+		{"reported_at", reportedF},
+		{"last_checked_at", lastCheckedF},
+		{"age", formatAge(report.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// displayOldRating function displays one old Advisor rating and writes it
+// into output sink
+func displayOldRating(rating OldRating, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	lastUpdatedAtF := formatTimestamp(rating.LastUpdatedAt, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldRatingMsg).
+		Str("organization", rating.OrgID).
+		Str("rule FQDN", rating.RuleFQDN).
+		Str("error key", rating.ErrorKey).
+		Int("rating", rating.Rating).
+		Str("updated at", lastUpdatedAtF)
+	logAge(event, "rating age", rating.Age, outputConfig).
+		Msg(oldRatingMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"org_id", rating.OrgID},
+		{"rule_fqdn", rating.RuleFQDN},
+		{"error_key", rating.ErrorKey},
+		{"rule_id", rating.RuleID},
+		{"rating", rating.Rating},
+		{"last_updated_at", lastUpdatedAtF},
+		{"age", formatAge(rating.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// displayOldConsumerError function displays one old consumer error and
+// writes it into output sink
+func displayOldConsumerError(consumerError OldConsumerError, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	consumedF := formatTimestamp(consumerError.ConsumedAt, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldConsumerErrorMsg).
+		Str("topic", consumerError.Topic).
+		Int("partition", consumerError.Partition).
+		Int("offset", consumerError.Offset).
+		Str("key", consumerError.Key).
+		Str("message", consumerError.Message).
+		Str("consumed", consumedF)
+	logAge(event, "error age", consumerError.Age, outputConfig).
+		Msg(oldConsumerErrorMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"topic", consumerError.Topic},
+		{"partition", consumerError.Partition},
+		{"topic_offset", consumerError.Offset},
+		{"key", consumerError.Key},
+		{"consumed_at", consumedF},
+		{"message", consumerError.Message},
+		{"age", formatAge(consumerError.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// displayOldRecommendation function displays one old recommendation and
+// writes it into output sink
+func displayOldRecommendation(recommendation OldRecommendation, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	createdAtF := formatTimestamp(recommendation.CreatedAt, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldRecommendationMsg).
+		Int(orgIDMsg, recommendation.OrgID).
+		Str(clusterNameMsg, recommendation.ClusterName).
+		Str(ruleFQDNMsg, recommendation.RuleFQDN).
+		Str(errorKeyMsg, recommendation.ErrorKey).
+		Str("created at", createdAtF)
+	logAge(event, "recommendation age", recommendation.Age, outputConfig).
+		Msg(oldRecommendationMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"org_id", recommendation.OrgID},
+		{"cluster", recommendation.ClusterName},
+		{"rule_fqdn", recommendation.RuleFQDN},
+		{"error_key", recommendation.ErrorKey},
+		{"rule_id", recommendation.RuleID},
+		{"created_at", createdAtF},
+		{"age", formatAge(recommendation.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// displayOldReportInfo function displays one record from report_info table
+// that belongs to old report and writes it into output sink
+func displayOldReportInfo(reportInfo OldReportInfo, sink OutputSink, outputConfig OutputConfiguration) {
+	// prepare for the report
+	reportedF := formatTimestamp(reportInfo.Reported, outputConfig)
+
+	// just print the report
+	event := rowEvent(oldReportInfoMsg).
+		Int(orgIDMsg, reportInfo.OrgID).
+		Str(clusterNameMsg, reportInfo.ClusterName).
+		Str("version info", reportInfo.VersionInfo).
+		Str(reportedMsg, reportedF)
+	logAge(event, ageMsg, reportInfo.Age, outputConfig).
+		Msg(oldReportInfoMsg)
+
+	err := sink.WriteRecord(OutputRecord{
+		{"org_id", reportInfo.OrgID},
+		{"cluster", reportInfo.ClusterName},
+		{"version_info", reportInfo.VersionInfo},
+		{"reported_at", reportedF},
+		{"age", formatAge(reportInfo.Age, outputConfig)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(writeToFileMsg)
+	}
+}
+
+// appendSizeSnapshot function appends sizes of all tables into CSV file. One
+// row is written for each table, all rows written in one run share the same
+// timestamp. Header is written when the file is created.
+func appendSizeSnapshot(filename string, timestamp time.Time, sizes []TableSize) error {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	// disable G302 (CWE-276): Expect file permissions to be 0600 or less
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // #nosec G304 G302
+	if err != nil {
+		log.Error().Err(err).Msg(fileOpenMsg)
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+
+	// header is written into empty file only
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = writer.WriteString(sizeSnapshotHeader)
+	}
+
+	// timestamps are always in UTC to keep the trend consistent
+	timestampF := timestamp.UTC().Format(time.RFC3339)
+	for _, size := range sizes {
+		if err != nil {
+			break
+		}
+		_, err = fmt.Fprintf(writer, "%s,%s,%s,%d,%d\n",
+			timestampF, runID, size.TableName, size.SizeBytes, size.RowCount)
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, filename).Msg(writeToFileMsg)
+	}
+
+	if closeErr := file.Close(); closeErr != nil {
+		log.Error().Err(closeErr).Msg(fileCloseMsg)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// isStandardOutput function checks if the given output name represents
+// standard output
+func isStandardOutput(output string) bool {
+	return output == standardOutputName
+}
+
+// writeChecksumFile function writes checksum of given file into a file with
+// .sha256 suffix. Format of the file is compatible with sha256sum tool.
+func writeChecksumFile(filename string, checksum []byte) error {
+	content := fmt.Sprintf("%s  %s\n", hex.EncodeToString(checksum), filepath.Base(filename))
+
+	// disable G306 (CWE-276): Expect WriteFile permissions to be 0600 or less
+	return os.WriteFile(filename+checksumSuffix, []byte(content), 0644) // #nosec G306
+}
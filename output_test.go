@@ -0,0 +1,301 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/output_test.html
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestCreateOutputFileNoOutput checks that no output file is created when
+// filename is not specified
+func TestCreateOutputFileNoOutput(t *testing.T) {
+	out, err := cleaner.CreateOutputFile("", false)
+	assert.NoError(t, err)
+	assert.Nil(t, out.Writer())
+
+	// must not panic
+	out.Close(true)
+}
+
+// TestCreateOutputFileSuccess checks that output file appears under its
+// final name only after the listing finished successfully
+func TestCreateOutputFileSuccess(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "listing.csv")
+
+	out, err := cleaner.CreateOutputFile(filename, false)
+	assert.NoError(t, err)
+	_, err = out.Writer().WriteString("foo,bar\n")
+	assert.NoError(t, err)
+
+	// final file must not exist before Close is called
+	assert.NoFileExists(t, filename)
+
+	out.Close(true)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo,bar\n", string(content))
+
+	// no temporary file and no checksum should be left behind
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// TestCreateOutputFileFailure checks that temporary file is removed and
+// final file is not created when the listing failed
+func TestCreateOutputFileFailure(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "listing.csv")
+
+	out, err := cleaner.CreateOutputFile(filename, true)
+	assert.NoError(t, err)
+	_, err = out.Writer().WriteString("foo,bar\n")
+	assert.NoError(t, err)
+
+	out.Close(false)
+
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// TestCreateOutputFileCreateError checks that error is returned when
+// temporary file can not be created
+func TestCreateOutputFileCreateError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "nonexistent", "listing.csv")
+
+	out, err := cleaner.CreateOutputFile(filename, false)
+	assert.Error(t, err)
+	assert.Nil(t, out)
+}
+
+// TestCreateOutputFileChecksum checks that checksum file is written next to
+// the exported file
+func TestCreateOutputFileChecksum(t *testing.T) {
+	const content = "foo,bar\n"
+
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "listing.csv")
+
+	out, err := cleaner.CreateOutputFile(filename, true)
+	assert.NoError(t, err)
+	_, err = out.Writer().WriteString(content)
+	assert.NoError(t, err)
+	out.Close(true)
+
+	checksum, err := os.ReadFile(filename + ".sha256")
+	assert.NoError(t, err)
+
+	expected := sha256.Sum256([]byte(content))
+	assert.Equal(t, hex.EncodeToString(expected[:])+"  listing.csv\n", string(checksum))
+}
+
+// TestCreateOutputFileStandardOutput checks that records are written to
+// standard output when "-" is used as output name
+func TestCreateOutputFileStandardOutput(t *testing.T) {
+	output, err := capture.StandardOutput(func() {
+		out, err := cleaner.CreateOutputFile("-", true)
+		assert.NoError(t, err)
+		_, err = out.Writer().WriteString("foo,bar\n")
+		assert.NoError(t, err)
+		out.Close(true)
+	})
+	checkCapture(t, err)
+
+	assert.Equal(t, "foo,bar\n", output)
+
+	// no file named "-" nor checksum file should be created
+	assert.NoFileExists(t, "-")
+	assert.NoFileExists(t, "-.sha256")
+}
+
+// TestFormatTimestamp checks formatting of timestamps according to output
+// configuration
+func TestFormatTimestamp(t *testing.T) {
+	zone := time.FixedZone("CET", 3600)
+	timestamp := time.Date(2024, 2, 3, 4, 5, 6, 0, zone)
+
+	type testCase struct {
+		name     string
+		config   cleaner.OutputConfiguration
+		expected string
+	}
+
+	testCases := []testCase{
+		{
+			name:     "default format",
+			config:   cleaner.OutputConfiguration{},
+			expected: "2024-02-03T04:05:06+01:00",
+		},
+		{
+			name:     "default format in UTC",
+			config:   cleaner.OutputConfiguration{UTC: true},
+			expected: "2024-02-03T03:05:06Z",
+		},
+		{
+			name:     "named format",
+			config:   cleaner.OutputConfiguration{TimeFormat: "DateTime", UTC: true},
+			expected: "2024-02-03 03:05:06",
+		},
+		{
+			name:     "Go layout",
+			config:   cleaner.OutputConfiguration{TimeFormat: "02.01.2006 15:04"},
+			expected: "03.02.2024 04:05",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, cleaner.FormatTimestamp(timestamp, tc.config))
+		})
+	}
+}
+
+// TestCheckTimeFormat checks that time formats without date or with a typo
+// are refused
+func TestCheckTimeFormat(t *testing.T) {
+	for _, timeFormat := range []string{"", "RFC3339", "RFC3339Nano", "RFC1123", "RFC1123Z",
+		"DateTime", "DateOnly", "02.01.2006 15:04"} {
+		assert.NoError(t, cleaner.CheckTimeFormat(timeFormat), timeFormat)
+	}
+
+	for _, timeFormat := range []string{"RFC3339x", "rfc3339", "15:04:05", "YYYY-MM-DD", "2006-01-32"} {
+		assert.Error(t, cleaner.CheckTimeFormat(timeFormat), timeFormat)
+	}
+}
+
+// TestFormatAge checks formatting of record age in all supported units
+func TestFormatAge(t *testing.T) {
+	age := 49*time.Hour + 30*time.Minute + 500*time.Millisecond
+
+	assert.Equal(t, "3", cleaner.FormatAge(age, cleaner.OutputConfiguration{}))
+	assert.Equal(t, "3", cleaner.FormatAge(age, cleaner.OutputConfiguration{AgeUnit: cleaner.AgeUnitDays}))
+	assert.Equal(t, "50", cleaner.FormatAge(age, cleaner.OutputConfiguration{AgeUnit: cleaner.AgeUnitHours}))
+	assert.Equal(t, "49h30m1s", cleaner.FormatAge(age, cleaner.OutputConfiguration{AgeUnit: cleaner.AgeUnitDuration}))
+}
+
+// TestDisplayOldOCPReport checks that old OCP report is written into
+// listing in expected format
+func TestDisplayOldOCPReport(t *testing.T) {
+	reported := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	report := cleaner.OldOCPReport{
+		ClusterName: cluster1ID,
+		Reported:    reported,
+		LastChecked: reported.Add(time.Hour),
+		Age:         49 * time.Hour,
+	}
+
+	output := exportThroughSink(t, cleaner.OutputConfiguration{}, func(sink cleaner.OutputSink) {
+		cleaner.DisplayOldOCPReport(report, sink, cleaner.OutputConfiguration{})
+	})
+
+	assert.Equal(t, cluster1ID+",2024-01-02T03:04:05Z,2024-01-02T04:04:05Z,3\n", output)
+}
+
+// TestDisplayOldDVOReport checks that old DVO report is written into
+// listing in expected format
+func TestDisplayOldDVOReport(t *testing.T) {
+	reported := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	report := cleaner.OldDVOReport{
+		OrgID:           defaultOrgID,
+		ClusterName:     cluster1ID,
+		NamespaceID:     namespaceID,
+		NamespaceName:   namespaceName,
+		Recommendations: 3,
+		Objects:         10,
+		Reported:        reported,
+		LastChecked:     reported,
+		Age:             24 * time.Hour,
+	}
+
+	outputConfig := cleaner.OutputConfiguration{AgeUnit: "duration"}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		cleaner.DisplayOldDVOReport(report, sink, outputConfig)
+	})
+
+	assert.Equal(t, "42,"+cluster1ID+","+namespaceID+","+namespaceName+
+		",3,10,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z,24h0m0s\n", output)
+}
+
+// TestDisplayOldRecordsWithoutOutput checks that old records are just
+// logged when listing is not enabled
+func TestDisplayOldRecordsWithoutOutput(t *testing.T) {
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+		cleaner.DisplayOldOCPReport(cleaner.OldOCPReport{ClusterName: cluster1ID}, cleaner.DiscardSink, cleaner.OutputConfiguration{})
+		cleaner.DisplayOldRating(cleaner.OldRating{RuleFQDN: "rule.test"}, cleaner.DiscardSink, cleaner.OutputConfiguration{})
+		cleaner.DisplayOldConsumerError(cleaner.OldConsumerError{Topic: "topic"}, cleaner.DiscardSink, cleaner.OutputConfiguration{})
+	})
+	assert.NoError(t, err)
+
+	assert.Contains(t, output, "Old OCP report")
+	assert.Contains(t, output, "Old Advisor rating")
+	assert.Contains(t, output, "Old consumer error")
+}
+
+// TestAppendSizeSnapshot checks that size snapshots are appended into CSV
+// file and that the header is written just once
+func TestAppendSizeSnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sizes.csv")
+	timestamp := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	sizes := []cleaner.TableSize{
+		{TableName: "public.report", SizeBytes: 8192, RowCount: 10},
+		{TableName: "public.rule_hit", SizeBytes: 16384, RowCount: 20},
+	}
+
+	// two runs
+	err := cleaner.AppendSizeSnapshot(filename, timestamp, sizes)
+	assert.NoError(t, err)
+	err = cleaner.AppendSizeSnapshot(filename, timestamp.Add(time.Hour), sizes[:1])
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	expected := "timestamp,run_id,table,size_bytes,rows\n" +
+		"2024-02-03T04:05:06Z," + *cleaner.RunID + ",public.report,8192,10\n" +
+		"2024-02-03T04:05:06Z," + *cleaner.RunID + ",public.rule_hit,16384,20\n" +
+		"2024-02-03T05:05:06Z," + *cleaner.RunID + ",public.report,8192,10\n"
+	assert.Equal(t, expected, string(content))
+}
+
+// TestAppendSizeSnapshotError checks error handling when the file can not be
+// opened
+func TestAppendSizeSnapshotError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "missing", "sizes.csv")
+
+	err := cleaner.AppendSizeSnapshot(filename, time.Now(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/payload_validation.html
+
+// This source file contains implementation of payload validation. Payloads
+// stored in report.report and rule_hit.template_data columns of records older
+// than max age are scanned and rows with empty or invalid JSON payload are
+// reported. Such rows break downstream consumers and they are never
+// refreshed, because the cluster does not send new reports. Rows with invalid
+// payload are deleted when dry run mode is disabled.
+//
+// Payloads compacted by -compact-payloads (ie. empty JSON object) are valid.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// Reasons why payload is considered invalid
+const (
+	invalidPayloadEmpty   = "empty"
+	invalidPayloadNotJSON = "invalid JSON"
+)
+
+// Statements used to scan payloads of old records and to delete records with
+// invalid payload
+const (
+	selectOldReportPayloads = `
+	    SELECT org_id, cluster, '', '', report
+	      FROM report
+	     WHERE reported_at < NOW() - $1::INTERVAL
+	     ORDER BY reported_at`
+
+	selectOldRuleHitPayloads = `
+	    SELECT rule_hit.org_id, rule_hit.cluster_id, rule_hit.rule_fqdn,
+	           rule_hit.error_key, rule_hit.template_data
+	      FROM rule_hit
+	      JOIN report ON report.cluster = rule_hit.cluster_id
+	                 AND report.org_id = rule_hit.org_id
+	     WHERE report.reported_at < NOW() - $1::INTERVAL
+	     ORDER BY report.reported_at`
+
+	deleteReportWithPayload = `
+	    DELETE FROM report
+	     WHERE org_id = $1 AND cluster = $2`
+
+	deleteRuleHitWithPayload = `
+	    DELETE FROM rule_hit
+	     WHERE org_id = $1 AND cluster_id = $2 AND rule_fqdn = $3 AND error_key = $4`
+)
+
+// InvalidPayload represents one record with empty or invalid JSON payload
+type InvalidPayload struct {
+	Table     string
+	OrgID     int
+	ClusterID ClusterName
+	RuleFQDN  string
+	ErrorKey  string
+	Reason    string
+}
+
+// payloadTable describes table with payload to be validated
+type payloadTable struct {
+	table           string
+	selectStatement string
+}
+
+// payloadTablesOCP contains tables with payloads in OCP database. Rule hits
+// are scanned first, because they are deleted together with report by
+// cleanup.
+var payloadTablesOCP = []payloadTable{
+	{"rule_hit", selectOldRuleHitPayloads},
+	{"report", selectOldReportPayloads},
+}
+
+// payloadValidity function checks if payload contains proper JSON. Empty
+// string is returned for valid payload, otherwise reason why the payload is
+// invalid is returned.
+func payloadValidity(payload string) string {
+	trimmed := strings.TrimSpace(payload)
+	if trimmed == "" || trimmed == "null" {
+		return invalidPayloadEmpty
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return invalidPayloadNotJSON
+	}
+	return ""
+}
+
+// readInvalidPayloads function scans payloads of records older than max age
+// and returns records with empty or invalid payload
+func readInvalidPayloads(connection *sql.DB, maxAge, schema string) ([]InvalidPayload, error) {
+	invalidPayloads := []InvalidPayload{}
+
+	if maxAge == "" {
+		return invalidPayloads, errors.New(maxAgeMissing)
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return invalidPayloads, ErrNoConnection
+	}
+
+	// payloads are validated in OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		return invalidPayloads, invalidSchema(schema)
+	}
+
+	for _, payloadTable := range payloadTablesOCP {
+		scanned := 0
+		err := queryRows(connection, payloadTable.selectStatement, []interface{}{maxAge}, func(rows *sql.Rows) error {
+			var (
+				record  InvalidPayload
+				payload string
+			)
+			if err := rows.Scan(&record.OrgID, &record.ClusterID, &record.RuleFQDN,
+				&record.ErrorKey, &payload); err != nil {
+				return err
+			}
+			scanned++
+
+			record.Reason = payloadValidity(payload)
+			if record.Reason == "" {
+				return nil
+			}
+			record.Table = payloadTable.table
+			log.Warn().
+				Str(tableName, record.Table).
+				Int(orgIDMsg, record.OrgID).
+				Str(clusterNameMsg, string(record.ClusterID)).
+				Str(ruleFQDNMsg, record.RuleFQDN).
+				Str(errorKeyMsg, record.ErrorKey).
+				Str("reason", record.Reason).
+				Msg("Invalid payload")
+			invalidPayloads = append(invalidPayloads, record)
+			return nil
+		})
+		if err != nil {
+			return invalidPayloads, queryFailed(payloadTable.table, err)
+		}
+		log.Info().
+			Str(tableName, payloadTable.table).
+			Int("scanned", scanned).
+			Msg("Payloads validated")
+	}
+	return invalidPayloads, nil
+}
+
+// deleteInvalidPayloads function deletes records with invalid payload.
+// Deletion continues with the next record when any record can not be
+// deleted, all failures are returned as aggregated error.
+func deleteInvalidPayloads(connection *sql.DB, invalidPayloads []InvalidPayload) (map[string]int, error) {
+	deletionsForTable := make(map[string]int)
+	for _, payloadTable := range payloadTablesOCP {
+		deletionsForTable[payloadTable.table] = 0
+	}
+
+	var errs []error
+	for _, record := range invalidPayloads {
+		var (
+			result sql.Result
+			err    error
+		)
+		switch record.Table {
+		case "report":
+			result, err = execStatement(connection, deleteReportWithPayload,
+				record.OrgID, record.ClusterID)
+		default:
+			result, err = execStatement(connection, deleteRuleHitWithPayload,
+				record.OrgID, record.ClusterID, record.RuleFQDN, record.ErrorKey)
+		}
+		if err != nil {
+			errs = append(errs, queryFailed(record.Table, err))
+			continue
+		}
+
+		// read number of affected (deleted) rows
+		affected, err := result.RowsAffected()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deletionsForTable[record.Table] += int(affected)
+	}
+	return deletionsForTable, errors.Join(errs...)
+}
+
+// PrintInvalidPayloads function displays a table with records that have
+// empty or invalid payload
+func PrintInvalidPayloads(invalidPayloads []InvalidPayload) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", "Organization", "Cluster", "Rule", "Reason"})
+
+	for _, record := range invalidPayloads {
+		rule := record.RuleFQDN
+		if record.ErrorKey != "" {
+			rule += "|" + record.ErrorKey
+		}
+		table.Append([]string{record.Table, strconv.Itoa(record.OrgID),
+			string(record.ClusterID), rule, record.Reason})
+	}
+
+	// table footer
+	table.SetFooter([]string{"Total", "", "", "", strconv.Itoa(len(invalidPayloads))})
+
+	// display the whole table
+	table.Render()
+}
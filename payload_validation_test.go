@@ -0,0 +1,167 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/payload_validation_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// payloadColumns contains columns returned by statements that scan payloads
+var payloadColumns = []string{"org_id", "cluster", "rule_fqdn", "error_key", "payload"}
+
+// TestPayloadValidity checks which payloads are considered invalid
+func TestPayloadValidity(t *testing.T) {
+	assert.Equal(t, "", cleaner.PayloadValidity(`{"reports": []}`))
+	assert.Equal(t, "", cleaner.PayloadValidity(`{}`))
+	assert.Equal(t, "empty", cleaner.PayloadValidity(""))
+	assert.Equal(t, "empty", cleaner.PayloadValidity("  "))
+	assert.Equal(t, "empty", cleaner.PayloadValidity("null"))
+	assert.Equal(t, "invalid JSON", cleaner.PayloadValidity(`{"reports": [`))
+}
+
+// expectPayloadScan function registers expected scans of rule_hit and report
+// tables
+func expectPayloadScan(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT rule_hit.org_id, rule_hit.cluster_id").
+		WithArgs("90 days").
+		WillReturnRows(sqlmock.NewRows(payloadColumns).
+			AddRow(defaultOrgID, cluster1ID, "rule.test", "KEY", `{"key": "value"}`).
+			AddRow(defaultOrgID, cluster1ID, "rule.test", "OTHER_KEY", `{"key":`))
+	mock.ExpectQuery("SELECT org_id, cluster, '', '', report").
+		WithArgs("90 days").
+		WillReturnRows(sqlmock.NewRows(payloadColumns).
+			AddRow(defaultOrgID, cluster1ID, "", "", `{}`).
+			AddRow(defaultOrgID, cluster2ID, "", "", ""))
+}
+
+// TestReadInvalidPayloads checks that only records with invalid payload are
+// returned
+func TestReadInvalidPayloads(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPayloadScan(mock)
+	mock.ExpectClose()
+
+	invalidPayloads, err := cleaner.ReadInvalidPayloads(connection, "90 days", cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.InvalidPayload{
+		{Table: "rule_hit", OrgID: defaultOrgID, ClusterID: cluster1ID,
+			RuleFQDN: "rule.test", ErrorKey: "OTHER_KEY", Reason: "invalid JSON"},
+		{Table: "report", OrgID: defaultOrgID, ClusterID: cluster2ID, Reason: "empty"},
+	}, invalidPayloads)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadInvalidPayloadsOnError checks that errors are reported properly
+func TestReadInvalidPayloadsOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectQuery("SELECT rule_hit.org_id, rule_hit.cluster_id").
+		WillReturnError(errors.New("query error"))
+
+	_, err = cleaner.ReadInvalidPayloads(connection, "90 days", cleaner.DBSchemaOCPRecommendations)
+	assert.Error(t, err)
+
+	// max age is required
+	_, err = cleaner.ReadInvalidPayloads(connection, "", cleaner.DBSchemaOCPRecommendations)
+	assert.EqualError(t, err, cleaner.MaxAgeMissing)
+
+	// DVO database is not supported
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.ReadInvalidPayloads(connection, "90 days", cleaner.DBSchemaDVORecommendations)
+	assert.True(t, errors.As(err, &schemaErr))
+
+	// no connection
+	_, err = cleaner.ReadInvalidPayloads(nil, "90 days", cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestValidatePayloads checks that records with invalid payload are deleted
+// when dry run mode is disabled
+func TestValidatePayloads(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPayloadScan(mock)
+	mock.ExpectExec("DELETE FROM rule_hit").
+		WithArgs(defaultOrgID, cluster1ID, "rule.test", "OTHER_KEY").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM report").
+		WithArgs(defaultOrgID, cluster2ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{MaxAge: "90 days"},
+	}
+	status, err := cleaner.ValidatePayloads(&configuration, connection,
+		cleaner.CliFlags{ValidatePayloads: true, DryRun: false}, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestValidatePayloadsDryRun checks that nothing is deleted in dry run mode
+func TestValidatePayloadsDryRun(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPayloadScan(mock)
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{MaxAge: "90 days"},
+	}
+	status, err := cleaner.ValidatePayloads(&configuration, connection,
+		cleaner.CliFlags{ValidatePayloads: true, DryRun: true}, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,319 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/privileges.html
+
+// This source file contains implementation of privilege preflight check.
+// Before destructive operation is started, it is checked that the database
+// user has all privileges needed to modify tables touched by the operation
+// (DELETE for cleanup operations, UPDATE for payload compaction, and
+// ownership of tables rewritten by VACUUM FULL). The operation fails fast
+// with list of missing grants instead of failing halfway through.
+//
+// Tables that do not exist are not reported by the check, because they are
+// reported by the operation itself.
+//
+// Deletes and updates can silently affect no rows when row-level security
+// (RLS) is enabled for table and the database user does not bypass it. Such
+// tables are detected by the preflight check too. A warning is logged and
+// the tables are included in run summary event, or the operation is refused
+// when bypass of row-level security is required by configuration.
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// Privileges checked by privilege preflight check. VACUUM privilege is
+// checked as ownership of the table, because only owners are allowed to
+// vacuum tables.
+const (
+	deletePrivilege = "DELETE"
+	updatePrivilege = "UPDATE"
+	vacuumPrivilege = "VACUUM"
+)
+
+// selectMissingPrivileges is used to find out which of required privileges
+// are not granted to the current user. Names of tables and privileges are
+// passed as two arrays of the same length.
+const selectMissingPrivileges = `
+	SELECT required.table_name, required.privilege
+	  FROM unnest($1::VARCHAR[], $2::VARCHAR[]) AS required(table_name, privilege)
+	  JOIN pg_class c ON c.oid = to_regclass(required.table_name)
+	 WHERE NOT CASE required.privilege
+	           WHEN 'VACUUM' THEN pg_has_role(c.relowner, 'USAGE')
+	           ELSE has_table_privilege(c.oid, required.privilege)
+	           END
+	 ORDER BY required.table_name, required.privilege`
+
+// selectRowSecurityTables is used to find out which of given tables have
+// row-level security enabled that applies to the current user. Superusers
+// and roles with BYPASSRLS attribute bypass row-level security, owners of
+// table bypass it unless it is forced for the table.
+const selectRowSecurityTables = `
+	SELECT required.table_name
+	  FROM unnest($1::VARCHAR[]) AS required(table_name)
+	  JOIN pg_class c ON c.oid = to_regclass(required.table_name)
+	 WHERE c.relrowsecurity
+	   AND NOT EXISTS (
+	       SELECT 1
+	         FROM pg_roles r
+	        WHERE r.rolname = current_user
+	          AND (r.rolsuper OR r.rolbypassrls))
+	   AND (c.relforcerowsecurity OR NOT pg_has_role(c.relowner, 'USAGE'))
+	 ORDER BY required.table_name`
+
+// rowSecurityTables contains tables with row-level security found by
+// preflight checks performed during the run. They are reported in run
+// summary event.
+var rowSecurityTables = make(StringSet)
+
+// rowSecurityMutex guards tables with row-level security, because more
+// storage targets can be checked at the same time
+var rowSecurityMutex sync.Mutex
+
+// TablePrivilege represents privilege needed for one table
+type TablePrivilege struct {
+	Table     string
+	Privilege string
+}
+
+// String method returns privilege in human readable form
+func (p TablePrivilege) String() string {
+	return p.Privilege + " on " + p.Table
+}
+
+// ErrMissingPrivileges is returned when database user does not have all
+// privileges needed by destructive operation
+type ErrMissingPrivileges struct {
+	Missing []TablePrivilege
+}
+
+// Error method returns error message with list of missing grants
+func (e *ErrMissingPrivileges) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, privilege := range e.Missing {
+		missing[i] = privilege.String()
+	}
+	return fmt.Sprintf("database user does not have privileges needed by the operation: %s",
+		strings.Join(missing, ", "))
+}
+
+// requiredPrivileges function returns privileges needed by selected
+// destructive operation. Table names are qualified by name of PostgreSQL
+// schema where tables for given DB schema are stored.
+func requiredPrivileges(cliFlags CliFlags, schema string) ([]TablePrivilege, error) {
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	privilege := deletePrivilege
+
+	switch {
+	case cliFlags.VacuumFull != "":
+		tables = parseTableList(cliFlags.VacuumFull)
+		privilege = vacuumPrivilege
+	case cliFlags.CompactPayloads:
+		tablesToCompact := tablesToCompactOCP
+		if schema == DBSchemaDVORecommendations {
+			tablesToCompact = tablesToCompactDVO
+		}
+		for _, table := range tablesToCompact {
+			tables = append(tables, table.TableName)
+		}
+		privilege = updatePrivilege
+	case cliFlags.CleanupRatings:
+		tables = []string{advisorRatingsTable}
+	case cliFlags.ConsumerErrorOffsets || cliFlags.ExportConsumerErrors:
+		tables = []string{consumerErrorTable}
+	default:
+		tables, err = managedTables(schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	privileges := make([]TablePrivilege, len(tables))
+	for i, table := range tables {
+		privileges[i] = TablePrivilege{Table: databaseSchema + "." + table, Privilege: privilege}
+	}
+	return privileges, nil
+}
+
+// readMissingPrivileges function returns privileges that are not granted to
+// the current database user
+func readMissingPrivileges(connection *sql.DB, required []TablePrivilege) ([]TablePrivilege, error) {
+	missing := []TablePrivilege{}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return missing, ErrNoConnection
+	}
+
+	tables := make([]string, len(required))
+	privileges := make([]string, len(required))
+	for i, privilege := range required {
+		tables[i] = privilege.Table
+		privileges[i] = privilege.Privilege
+	}
+
+	args := []interface{}{pq.Array(tables), pq.Array(privileges)}
+	err := queryRows(connection, selectMissingPrivileges, args, func(rows *sql.Rows) error {
+		var privilege TablePrivilege
+		if err := rows.Scan(&privilege.Table, &privilege.Privilege); err != nil {
+			return err
+		}
+		missing = append(missing, privilege)
+		return nil
+	})
+	if err != nil {
+		return missing, queryFailed("pg_class", err)
+	}
+	return missing, nil
+}
+
+// ErrRowLevelSecurity is returned when row-level security applies to tables
+// modified by destructive operation and bypass of row-level security is
+// required by configuration
+type ErrRowLevelSecurity struct {
+	Tables []string
+}
+
+// Error method returns error message with list of tables
+func (e *ErrRowLevelSecurity) Error() string {
+	return fmt.Sprintf("row-level security applies to database user for tables: %s",
+		strings.Join(e.Tables, ", "))
+}
+
+// readRowSecurityTables function returns tables where row-level security
+// applies to the current database user
+func readRowSecurityTables(connection *sql.DB, tables []string) ([]string, error) {
+	found := []string{}
+
+	args := []interface{}{pq.Array(tables)}
+	err := queryRows(connection, selectRowSecurityTables, args, func(rows *sql.Rows) error {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		found = append(found, table)
+		return nil
+	})
+	if err != nil {
+		return found, queryFailed("pg_class", err)
+	}
+	return found, nil
+}
+
+// recordRowSecurityTables function remembers tables with row-level security,
+// so they can be reported in run summary event
+func recordRowSecurityTables(tables []string) {
+	rowSecurityMutex.Lock()
+	defer rowSecurityMutex.Unlock()
+
+	for _, table := range tables {
+		rowSecurityTables[table] = struct{}{}
+	}
+}
+
+// rowSecurityTablesFound function returns sorted names of all tables with
+// row-level security found during the run
+func rowSecurityTablesFound() []string {
+	rowSecurityMutex.Lock()
+	defer rowSecurityMutex.Unlock()
+
+	tables := make([]string, 0, len(rowSecurityTables))
+	for table := range rowSecurityTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// checkRowSecurity function checks if row-level security applies to tables
+// modified by selected operation. Just a warning is logged unless bypass of
+// row-level security is required.
+func checkRowSecurity(connection *sql.DB, required []TablePrivilege, requireBypass bool) error {
+	var tables []string
+	for _, privilege := range required {
+		// VACUUM is not affected by row-level security
+		if privilege.Privilege != vacuumPrivilege {
+			tables = append(tables, privilege.Table)
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	found, err := readRowSecurityTables(connection, tables)
+	if err != nil || len(found) == 0 {
+		return err
+	}
+
+	recordRowSecurityTables(found)
+	if requireBypass {
+		return &ErrRowLevelSecurity{Tables: found}
+	}
+	log.Warn().
+		Strs("tables", found).
+		Msg("Row-level security applies to database user, records hidden by policies will not be deleted")
+	return nil
+}
+
+// checkPrivileges function checks if database user has all privileges
+// needed by selected destructive operation and if row-level security applies
+// to modified tables. Privileges are not checked for non-destructive
+// operations, for administrative operations that do not delete records by
+// themselves, and for SQLite databases.
+func checkPrivileges(connection *sql.DB, storageCfg *StorageConfiguration, cliFlags CliFlags,
+	requireRLSBypass bool) error {
+	if !isDestructiveOperation(cliFlags) || operationCategory(cliFlags) == OperationCategoryAdmin ||
+		storageCfg.Driver == "sqlite3" {
+		return nil
+	}
+
+	required, err := requiredPrivileges(cliFlags, storageCfg.Schema)
+	if err != nil {
+		return err
+	}
+
+	missing, err := readMissingPrivileges(connection, required)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &ErrMissingPrivileges{Missing: missing}
+	}
+
+	err = checkRowSecurity(connection, required, requireRLSBypass)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Int("privileges", len(required)).Msg("Privileges checked")
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/privileges_test.html
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// privilegesStorage is PostgreSQL storage configuration used by privilege
+// preflight tests
+var privilegesStorage = cleaner.StorageConfiguration{
+	Driver: "postgres",
+	Schema: cleaner.DBSchemaOCPRecommendations,
+}
+
+// TestRequiredPrivileges checks privileges needed by destructive operations
+func TestRequiredPrivileges(t *testing.T) {
+	// cleanup needs to delete records from all managed tables
+	privileges, err := cleaner.RequiredPrivileges(cleaner.CliFlags{PerformCleanup: true},
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Contains(t, privileges, cleaner.TablePrivilege{Table: "public.report", Privilege: "DELETE"})
+	assert.Contains(t, privileges, cleaner.TablePrivilege{Table: "public.rule_hit", Privilege: "DELETE"})
+
+	privileges, err = cleaner.RequiredPrivileges(cleaner.CliFlags{PerformCleanupAll: true},
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TablePrivilege{{Table: "dvo.dvo_report", Privilege: "DELETE"}}, privileges)
+
+	// payloads are compacted by UPDATE
+	privileges, err = cleaner.RequiredPrivileges(cleaner.CliFlags{CompactPayloads: true},
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TablePrivilege{
+		{Table: "public.report", Privilege: "UPDATE"},
+		{Table: "public.rule_hit", Privilege: "UPDATE"},
+	}, privileges)
+
+	// only owners can rewrite tables by VACUUM FULL
+	privileges, err = cleaner.RequiredPrivileges(cleaner.CliFlags{VacuumFull: "report, rule_hit"},
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TablePrivilege{
+		{Table: "public.report", Privilege: "VACUUM"},
+		{Table: "public.rule_hit", Privilege: "VACUUM"},
+	}, privileges)
+
+	privileges, err = cleaner.RequiredPrivileges(cleaner.CliFlags{CleanupRatings: true},
+		cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TablePrivilege{{Table: "public.advisor_ratings", Privilege: "DELETE"}}, privileges)
+
+	// unknown DB schema
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.RequiredPrivileges(cleaner.CliFlags{PerformCleanup: true}, "unknown")
+	assert.ErrorAs(t, err, &schemaErr)
+}
+
+// TestCheckPrivileges checks that missing grants are reported
+func TestCheckPrivileges(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	cliFlags := cleaner.CliFlags{CleanupRatings: true, DryRun: false}
+
+	rows := sqlmock.NewRows([]string{"table_name", "privilege"})
+	rows.AddRow("public.advisor_ratings", "DELETE")
+	mock.ExpectQuery(`SELECT required.table_name, required.privilege`).
+		WithArgs(`{"public.advisor_ratings"}`, `{"DELETE"}`).
+		WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT required.table_name, required.privilege`).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "privilege"}))
+	mock.ExpectQuery(`SELECT required.table_name\s+FROM unnest`).
+		WithArgs(`{"public.advisor_ratings"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}))
+	mock.ExpectClose()
+
+	err = cleaner.CheckPrivileges(connection, &privilegesStorage, cliFlags, false)
+	var privilegesErr *cleaner.ErrMissingPrivileges
+	assert.ErrorAs(t, err, &privilegesErr)
+	assert.Equal(t, "database user does not have privileges needed by the operation: "+
+		"DELETE on public.advisor_ratings", err.Error())
+
+	// all privileges are granted
+	assert.NoError(t, cleaner.CheckPrivileges(connection, &privilegesStorage, cliFlags, false))
+
+	// privileges are not checked for non-destructive operations and for
+	// SQLite databases
+	assert.NoError(t, cleaner.CheckPrivileges(connection, &privilegesStorage,
+		cleaner.CliFlags{CleanupRatings: true, DryRun: true}, false))
+	assert.NoError(t, cleaner.CheckPrivileges(connection,
+		&cleaner.StorageConfiguration{Driver: "sqlite3", Schema: cleaner.DBSchemaOCPRecommendations}, cliFlags, false))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckPrivilegesRowSecurity checks that tables with row-level security
+// are reported and that the operation is refused when bypass of row-level
+// security is required
+func TestCheckPrivilegesRowSecurity(t *testing.T) {
+	t.Cleanup(func() {
+		*cleaner.RowSecurityTables = cleaner.StringSet{}
+	})
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	cliFlags := cleaner.CliFlags{CleanupRatings: true, DryRun: false}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT required.table_name, required.privilege`).
+			WillReturnRows(sqlmock.NewRows([]string{"table_name", "privilege"}))
+		rows := sqlmock.NewRows([]string{"table_name"})
+		rows.AddRow("public.advisor_ratings")
+		mock.ExpectQuery(`SELECT required.table_name\s+FROM unnest`).WillReturnRows(rows)
+	}
+	mock.ExpectClose()
+
+	// just a warning is logged by default
+	assert.NoError(t, cleaner.CheckPrivileges(connection, &privilegesStorage, cliFlags, false))
+	assert.Contains(t, *cleaner.RowSecurityTables, "public.advisor_ratings")
+
+	err = cleaner.CheckPrivileges(connection, &privilegesStorage, cliFlags, true)
+	var rowSecurityErr *cleaner.ErrRowLevelSecurity
+	assert.ErrorAs(t, err, &rowSecurityErr)
+	assert.Equal(t, []string{"public.advisor_ratings"}, rowSecurityErr.Tables)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestDoSelectedOperationMissingPrivileges checks that destructive operation
+// is not started when privileges are missing
+func TestDoSelectedOperationMissingPrivileges(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"table_name", "privilege"})
+	rows.AddRow("public.report", "DELETE")
+	mock.ExpectQuery(`SELECT required.table_name, required.privilege`).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{Storage: privilegesStorage}
+	status, err := cleaner.DoSelectedOperation(&configuration, connection, cleaner.CliFlags{
+		PerformCleanupAll: true,
+		MaxAge:            "90 days",
+		ConfirmMaxAge:     "90 days",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusInsufficientPrivilege, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/queries.html
+
+// This source file contains registry of all SQL statements used by the
+// cleaner. Statements are keyed by DB schema and name. Besides static
+// statements the registry contains statements generated for tables declared
+// for given DB schema (deletion of records for selected cluster and deletion
+// of old records), so plug-in schemas are covered as well.
+//
+// All registered statements can be displayed by -list-queries command line
+// option. References to OCP tables are qualified in the same way as when the
+// statements are executed.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Prefixes of names of generated statements
+const (
+	deleteClusterQueryPrefix    = "delete_cluster_"
+	deleteOldRecordsQueryPrefix = "delete_old_"
+)
+
+// NamedQuery represents one SQL statement registered in query registry
+type NamedQuery struct {
+	Schema    string
+	Name      string
+	Statement string
+}
+
+// queryRegistry contains static SQL statements keyed by DB schema and
+// statement name
+var queryRegistry = map[string]map[string]string{
+	DBSchemaOCPRecommendations: {
+		"select_old_reports":                     selectOldOCPReports,
+		"select_old_advisor_ratings":             selectOldAdvisorRatings,
+		"select_old_consumer_errors":             selectOldConsumerErrors,
+		"select_multiple_rule_toggles":           selectMultipleRuleToggles,
+		"select_multiple_rule_disable_feedbacks": selectMultipleRuleDisableFeedbacks,
+		"select_org_id_for_cluster":              selectOrgIDForCluster,
+		"select_consumer_error_offsets":          selectConsumerErrorOffsets,
+		"count_consumer_errors_before_offset":    countConsumerErrorsBeforeOffset,
+		"delete_consumer_errors_before_offset":   deleteConsumerErrorsBeforeOffset,
+		"select_consumer_errors_for_replay":      selectConsumerErrorsForReplay,
+		"delete_consumer_error":                  deleteConsumerError,
+		"select_table_sizes":                     selectTableSizes,
+		"select_dead_tuples":                     selectDeadTuples,
+		"select_pgstattuple_installed":           selectPgstattupleInstalled,
+		"select_index_leaf_density":              selectIndexLeafDensity,
+		"select_pg_repack_installed":             selectPgRepackInstalled,
+		"select_pg_cron_installed":               selectPgCronInstalled,
+		"schedule_cron_job":                      scheduleCronJob,
+		"unschedule_cron_jobs":                   unscheduleCronJobs,
+		"select_cron_jobs":                       selectCronJobs,
+		"select_clusters_for_org":                selectOCPClustersForOrg,
+		"select_old_report_payloads":             selectOldReportPayloads,
+		"select_old_rule_hit_payloads":           selectOldRuleHitPayloads,
+		"delete_report_with_payload":             deleteReportWithPayload,
+		"delete_rule_hit_with_payload":           deleteRuleHitWithPayload,
+		"select_timestamp_anomalies":             selectOCPTimestampAnomalies,
+	},
+	DBSchemaDVORecommendations: {
+		"select_old_reports":           selectOldDVOReports,
+		"select_namespace_statistics":  selectDVONamespaceStatistics,
+		"select_table_sizes":           selectTableSizes,
+		"select_dead_tuples":           selectDeadTuples,
+		"select_pgstattuple_installed": selectPgstattupleInstalled,
+		"select_index_leaf_density":    selectIndexLeafDensity,
+		"select_pg_repack_installed":   selectPgRepackInstalled,
+		"select_pg_cron_installed":     selectPgCronInstalled,
+		"schedule_cron_job":            scheduleCronJob,
+		"unschedule_cron_jobs":         unscheduleCronJobs,
+		"select_cron_jobs":             selectCronJobs,
+		"select_clusters_for_org":      selectDVOClustersForOrg,
+		"select_timestamp_anomalies":   selectDVOTimestampAnomalies,
+	},
+}
+
+// caseInsensitiveMatch is set when cluster IDs stored in database should be
+// compared with requested cluster IDs regardless of letter case
+var caseInsensitiveMatch = false
+
+// clusterKeyCondition function constructs condition that selects records
+// for cluster specified by first statement parameter. When case insensitive
+// matching is enabled, both sides are converted to lowercase (so index on
+// the key can not be used).
+func clusterKeyCondition(key string) string {
+	if caseInsensitiveMatch {
+		return "lower(" + key + ") = lower($1)"
+	}
+	return key + " = $1"
+}
+
+// deleteByKeyStatement function constructs statement that deletes records
+// for selected cluster from given table
+func deleteByKeyStatement(table, key string) string {
+	// it is not possible to use parameter for table name or a key
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	return "DELETE FROM " + table + " WHERE " + clusterKeyCondition(key) + ";"
+}
+
+// deleteByKeyAndOrgStatement function constructs statement that deletes
+// records for selected cluster and organization from given table
+func deleteByKeyAndOrgStatement(table, key, orgKey string) string {
+	// it is not possible to use parameter for table name or a key
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	return "DELETE FROM " + table + " WHERE " + clusterKeyCondition(key) + " AND " + orgKey + " = $2;"
+}
+
+// registeredQueries function returns all SQL statements registered for given
+// DB schema, sorted by their names
+func registeredQueries(schema string) ([]NamedQuery, error) {
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []NamedQuery{}
+	for name, statement := range queryRegistry[schema] {
+		queries = append(queries, NamedQuery{schema, name, statement})
+	}
+
+	// statements generated for tables declared for DB schema
+	for _, tableAndKey := range tablesAndKeys {
+		statement := deleteByKeyStatement(tableAndKey.TableName, tableAndKey.KeyName)
+		if tableAndKey.OrgKeyName != "" {
+			statement = deleteByKeyAndOrgStatement(tableAndKey.TableName,
+				tableAndKey.KeyName, tableAndKey.OrgKeyName)
+		}
+		queries = append(queries, NamedQuery{schema, deleteClusterQueryPrefix + tableAndKey.TableName, statement})
+	}
+	for _, tableToDelete := range tablesToDelete {
+		queries = append(queries, NamedQuery{schema, deleteOldRecordsQueryPrefix + tableToDelete.TableName,
+			tableToDelete.DeleteStatement})
+	}
+
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Name < queries[j].Name
+	})
+	return queries, nil
+}
+
+// lookupQuery function returns SQL statement registered for given DB schema
+// under given name
+func lookupQuery(schema, name string) (string, error) {
+	queries, err := registeredQueries(schema)
+	if err != nil {
+		return "", err
+	}
+
+	for _, query := range queries {
+		if query.Name == name {
+			return query.Statement, nil
+		}
+	}
+	return "", fmt.Errorf("Query %s is not registered for DB schema %s", name, schema)
+}
+
+// writeQueries function writes all registered statements for given DB schema
+// in a form that can be used as SQL script
+func writeQueries(writer io.Writer, schema string) error {
+	queries, err := registeredQueries(schema)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range queries {
+		statement := strings.TrimSuffix(strings.TrimSpace(qualifyTableNames(query.Statement)), ";")
+		_, err := fmt.Fprintf(writer, "-- %s: %s\n%s;\n\n", query.Schema, query.Name, statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listQueries function displays all SQL statements registered for selected
+// DB schema
+func listQueries(schema string) (ExitStatus, error) {
+	err := writeQueries(os.Stdout, schema)
+	if err != nil {
+		log.Err(err).Msg("List queries")
+		return ExitStatusStorageError, err
+	}
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/queries_test.html
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestRegisteredQueriesForBuiltInSchemas checks that all statements are
+// registered for built-in schemas
+func TestRegisteredQueriesForBuiltInSchemas(t *testing.T) {
+	for _, schema := range []string{cleaner.DBSchemaOCPRecommendations, cleaner.DBSchemaDVORecommendations} {
+		queries, err := cleaner.RegisteredQueries(schema)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, queries)
+
+		names := []string{}
+		for _, query := range queries {
+			assert.Equal(t, schema, query.Schema)
+			assert.NotEmpty(t, query.Statement, query.Name)
+			names = append(names, query.Name)
+		}
+		assert.True(t, sort.StringsAreSorted(names), "queries should be sorted by name")
+	}
+
+	statement, err := cleaner.LookupQuery(cleaner.DBSchemaOCPRecommendations, "select_org_id_for_cluster")
+	assert.NoError(t, err)
+	assert.Equal(t, "select org_id from report where cluster = $1", statement)
+
+	statement, err = cleaner.LookupQuery(cleaner.DBSchemaOCPRecommendations, "delete_cluster_report")
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM report WHERE cluster = $1;", statement)
+
+	statement, err = cleaner.LookupQuery(cleaner.DBSchemaDVORecommendations, "delete_cluster_dvo_report")
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM dvo_report WHERE cluster_id = $1 AND org_id = $2;", statement)
+
+	_, err = cleaner.LookupQuery(cleaner.DBSchemaDVORecommendations, "select_org_id_for_cluster")
+	assert.Error(t, err)
+}
+
+// TestRegisteredQueriesForPluginSchema checks that statements are generated
+// for tables declared in plug-in schema
+func TestRegisteredQueriesForPluginSchema(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	statement, err := cleaner.LookupQuery(pluginSchemaName, "delete_old_reported")
+	assert.NoError(t, err)
+	assert.Contains(t, statement, "DELETE FROM reported")
+
+	_, err = cleaner.LookupQuery(pluginSchemaName, "delete_cluster_reported")
+	assert.NoError(t, err)
+
+	_, err = cleaner.RegisteredQueries("unknown")
+	assert.Error(t, err)
+}
+
+// TestWriteQueries checks that registered statements are written as SQL
+// script with qualified table names
+func TestWriteQueries(t *testing.T) {
+	registerOCPTableSchema(t, "aggregator")
+
+	var buffer bytes.Buffer
+	err := cleaner.WriteQueries(&buffer, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+
+	output := buffer.String()
+	assert.Contains(t, output, "-- ocp_recommendations: delete_cluster_report\nDELETE FROM aggregator.report WHERE cluster = $1;\n")
+	assert.Contains(t, output, "-- ocp_recommendations: select_org_id_for_cluster\nselect org_id from aggregator.report where cluster = $1;\n")
+	assert.NotContains(t, output, ";;")
+
+	_, err = cleaner.ListQueries("unknown")
+	assert.Error(t, err)
+}
+
+// TestDeleteByKeyStatement checks statements that delete records for
+// selected cluster with exact and case insensitive matching
+func TestDeleteByKeyStatement(t *testing.T) {
+	assert.Equal(t, "DELETE FROM report WHERE cluster = $1;",
+		cleaner.DeleteByKeyStatement("report", "cluster"))
+	assert.Equal(t, "DELETE FROM rule_hit WHERE cluster_id = $1 AND org_id = $2;",
+		cleaner.DeleteByKeyAndOrgStatement("rule_hit", "cluster_id", "org_id"))
+
+	*cleaner.CaseInsensitiveMatch = true
+	defer func() {
+		*cleaner.CaseInsensitiveMatch = false
+	}()
+
+	assert.Equal(t, "DELETE FROM report WHERE lower(cluster) = lower($1);",
+		cleaner.DeleteByKeyStatement("report", "cluster"))
+	assert.Equal(t, "DELETE FROM rule_hit WHERE lower(cluster_id) = lower($1) AND org_id = $2;",
+		cleaner.DeleteByKeyAndOrgStatement("rule_hit", "cluster_id", "org_id"))
+}
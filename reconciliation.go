@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/reconciliation.html
+
+// This source file contains implementation of requested-versus-deleted
+// reconciliation. After cleanup, each requested cluster is put into exactly
+// one category:
+//
+// deleted         at least one row has been deleted for the cluster
+// already absent  the cluster has been cleaned up, but no rows were found
+//                 (a warning is logged for such clusters, because it usually
+//                 means that wrong database or DB schema was selected)
+// failed          the cluster could not be cleaned up even after retries
+// protected       the cluster is listed in protected_clusters configuration
+//                 option and it has not been cleaned up at all
+//
+// The reconciliation is displayed in the summary, written into deletion
+// evidence, and logged as a single event (so it is sent to Kafka when Kafka
+// logging is configured).
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of reconciliation categories
+const (
+	reconciliationDeleted       = "Deleted clusters"
+	reconciliationAlreadyAbsent = "Already absent clusters"
+	reconciliationFailed        = "Failed clusters"
+	reconciliationProtected     = "Protected clusters"
+)
+
+// Reconciliation represents comparison of requested cluster list with
+// clusters that actually had rows deleted
+type Reconciliation struct {
+	Deleted       ClusterList `json:"deleted"`
+	AlreadyAbsent ClusterList `json:"already_absent"`
+	Failed        ClusterList `json:"failed"`
+	Protected     ClusterList `json:"protected"`
+}
+
+// Requested method returns number of all clusters in reconciliation
+func (reconciliation Reconciliation) Requested() int {
+	return len(reconciliation.Deleted) + len(reconciliation.AlreadyAbsent) +
+		len(reconciliation.Failed) + len(reconciliation.Protected)
+}
+
+// excludeProtectedClusters function splits cluster list into clusters to be
+// cleaned up and clusters that are protected by configuration
+func excludeProtectedClusters(clusterList ClusterList, protectedClusters []string) (
+	ClusterList, ClusterList) {
+	protected := make(map[string]struct{}, len(protectedClusters))
+	for _, cluster := range protectedClusters {
+		protected[strings.TrimSpace(cluster)] = struct{}{}
+	}
+
+	var toCleanup, skipped ClusterList
+	for _, cluster := range clusterList {
+		if _, found := protected[string(cluster)]; found {
+			log.Warn().Str(clusterNameMsg, string(cluster)).Msg("Cluster is protected, skipping cleanup")
+			skipped = append(skipped, cluster)
+			continue
+		}
+		toCleanup = append(toCleanup, cluster)
+	}
+	return toCleanup, skipped
+}
+
+// reconcileCleanup function puts each requested cluster into one
+// reconciliation category
+func reconcileCleanup(clusterList ClusterList, deletionsForCluster DeletionMatrix,
+	failedClusters, protectedClusters ClusterList) Reconciliation {
+	reconciliation := Reconciliation{
+		Deleted:       ClusterList{},
+		AlreadyAbsent: ClusterList{},
+		Failed:        ClusterList{},
+		Protected:     ClusterList{},
+	}
+
+	failed := make(map[ClusterName]struct{}, len(failedClusters))
+	for _, cluster := range failedClusters {
+		failed[cluster] = struct{}{}
+	}
+
+	for _, cluster := range clusterList {
+		if _, found := failed[cluster]; found {
+			reconciliation.Failed = append(reconciliation.Failed, cluster)
+			continue
+		}
+		if deletionsForCluster.Deleted(cluster) > 0 {
+			reconciliation.Deleted = append(reconciliation.Deleted, cluster)
+		} else {
+			reconciliation.AlreadyAbsent = append(reconciliation.AlreadyAbsent, cluster)
+		}
+	}
+	reconciliation.Protected = append(reconciliation.Protected, protectedClusters...)
+
+	return reconciliation
+}
+
+// clusterNames function converts cluster list into slice of strings
+func clusterNames(clusterList ClusterList) []string {
+	names := make([]string, len(clusterList))
+	for i, cluster := range clusterList {
+		names[i] = string(cluster)
+	}
+	return names
+}
+
+// logReconciliation function logs reconciliation as one event
+func logReconciliation(reconciliation Reconciliation) {
+	log.Info().
+		Int("requested", reconciliation.Requested()).
+		Strs("deleted", clusterNames(reconciliation.Deleted)).
+		Strs("already absent", clusterNames(reconciliation.AlreadyAbsent)).
+		Strs("failed", clusterNames(reconciliation.Failed)).
+		Strs("protected", clusterNames(reconciliation.Protected)).
+		Msg("Cleanup reconciliation")
+}
+
+// warnAboutAbsentClusters function logs distinct warning for each cluster
+// that did not have any rows deleted. It usually means that wrong database or
+// DB schema has been selected.
+func warnAboutAbsentClusters(reconciliation Reconciliation, target, schema string) {
+	if len(reconciliation.AlreadyAbsent) == 0 {
+		return
+	}
+	for _, cluster := range reconciliation.AlreadyAbsent {
+		log.Warn().
+			Str(clusterNameMsg, string(cluster)).
+			Msg("Zero rows deleted for cluster")
+	}
+	log.Warn().
+		Int("clusters", len(reconciliation.AlreadyAbsent)).
+		Int("requested", reconciliation.Requested()).
+		Str(targetAttribute, target).
+		Str("schema", schema).
+		Msg("Zero rows deleted for some clusters, check that the right database and schema are used")
+}
+
+// PrintReconciliation function displays a table with number of clusters in
+// each reconciliation category followed by clusters that were not deleted
+func PrintReconciliation(reconciliation Reconciliation) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Reconciliation", "Clusters"})
+
+	table.Append([]string{reconciliationDeleted, strconv.Itoa(len(reconciliation.Deleted))})
+	table.Append([]string{reconciliationAlreadyAbsent, strconv.Itoa(len(reconciliation.AlreadyAbsent))})
+	table.Append([]string{reconciliationFailed, strconv.Itoa(len(reconciliation.Failed))})
+	table.Append([]string{reconciliationProtected, strconv.Itoa(len(reconciliation.Protected))})
+
+	// table footer
+	table.SetFooter([]string{"Requested clusters", strconv.Itoa(reconciliation.Requested())})
+
+	// display the whole table
+	table.Render()
+
+	// clusters that were not deleted are listed explicitly
+	printClusters(reconciliationAlreadyAbsent, reconciliation.AlreadyAbsent)
+	printClusters(reconciliationFailed, reconciliation.Failed)
+	printClusters(reconciliationProtected, reconciliation.Protected)
+}
+
+// printClusters function displays list of clusters with given title when the
+// list is not empty
+func printClusters(title string, clusterList ClusterList) {
+	if len(clusterList) == 0 {
+		return
+	}
+	fmt.Println(title + ": " + strings.Join(clusterNames(clusterList), ", "))
+}
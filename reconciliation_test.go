@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/reconciliation_test.html
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// third cluster used by reconciliation tests
+const cluster3ID = "00000000-0000-0000-0000-000000000000"
+
+// TestExcludeProtectedClusters checks that protected clusters are removed
+// from cluster list
+func TestExcludeProtectedClusters(t *testing.T) {
+	clusterList := cleaner.ClusterList{cluster1ID, cluster2ID, cluster3ID}
+
+	toCleanup, protected := cleaner.ExcludeProtectedClusters(clusterList, []string{" " + cluster2ID + " "})
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster3ID}, toCleanup)
+	assert.Equal(t, cleaner.ClusterList{cluster2ID}, protected)
+
+	// no protected clusters
+	toCleanup, protected = cleaner.ExcludeProtectedClusters(clusterList, nil)
+	assert.Equal(t, clusterList, toCleanup)
+	assert.Empty(t, protected)
+}
+
+// TestReconcileCleanup checks that each cluster is put into exactly one
+// reconciliation category
+func TestReconcileCleanup(t *testing.T) {
+	reconciliation := cleaner.ReconcileCleanup(
+		cleaner.ClusterList{cluster1ID, cluster2ID, cluster3ID},
+		cleaner.DeletionMatrix{cluster1ID: {"report": 5}, cluster2ID: {"report": 0}, cluster3ID: {"report": 1}},
+		cleaner.ClusterList{cluster3ID},
+		cleaner.ClusterList{"11111111-1111-1111-1111-111111111111"})
+
+	assert.Equal(t, cleaner.ClusterList{cluster1ID}, reconciliation.Deleted)
+	assert.Equal(t, cleaner.ClusterList{cluster2ID}, reconciliation.AlreadyAbsent)
+	assert.Equal(t, cleaner.ClusterList{cluster3ID}, reconciliation.Failed)
+	assert.Equal(t, cleaner.ClusterList{"11111111-1111-1111-1111-111111111111"}, reconciliation.Protected)
+	assert.Equal(t, 4, reconciliation.Requested())
+}
+
+// TestPrintReconciliation checks the reconciliation table
+func TestPrintReconciliation(t *testing.T) {
+	reconciliation := cleaner.Reconciliation{
+		Deleted:       cleaner.ClusterList{cluster1ID},
+		AlreadyAbsent: cleaner.ClusterList{},
+		Failed:        cleaner.ClusterList{cluster2ID},
+		Protected:     cleaner.ClusterList{},
+	}
+
+	output, err := capture.StandardOutput(func() {
+		cleaner.PrintReconciliation(reconciliation)
+	})
+	assert.NoError(t, err)
+
+	assert.Contains(t, output, "RECONCILIATION")
+	assert.Contains(t, output, "Deleted clusters")
+	assert.Contains(t, output, "Already absent clusters")
+	assert.Contains(t, output, "Protected clusters")
+	assert.Contains(t, output, "Failed clusters: "+cluster2ID)
+	assert.NotContains(t, output, "Already absent clusters: ")
+	assert.NotContains(t, output, "Protected clusters: ")
+}
+
+// TestPerformClustersCleanupInDB checks that number of deleted rows is
+// returned for each cluster
+func TestPerformClustersCleanupInDB(t *testing.T) {
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	clusterNames := cleaner.ClusterList{cluster1ID, cluster2ID}
+
+	for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+		expectedExec := fmt.Sprintf("DELETE FROM %v WHERE %v = \\$", tableAndKey.TableName, tableAndKey.KeyName)
+		mock.ExpectExec(expectedExec).WithArgs(cluster1ID).WillReturnResult(sqlmock.NewResult(1, 2))
+	}
+	for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+		expectedExec := fmt.Sprintf("DELETE FROM %v WHERE %v = \\$", tableAndKey.TableName, tableAndKey.KeyName)
+		mock.ExpectExec(expectedExec).WithArgs(cluster2ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectClose()
+
+	_, deletionsForCluster, failed, err := cleaner.PerformClustersCleanupInDB(connection, clusterNames,
+		cleaner.DBSchemaDVORecommendations, 0, 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, failed)
+	assert.Equal(t, 2*len(cleaner.TablesAndKeysInDVODatabase), deletionsForCluster.Deleted(cluster1ID))
+	assert.Equal(t, 0, deletionsForCluster.Deleted(cluster2ID))
+	for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+		assert.Equal(t, 2, deletionsForCluster[cluster1ID][tableAndKey.TableName])
+		assert.Equal(t, 0, deletionsForCluster[cluster2ID][tableAndKey.TableName])
+	}
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWarnAboutAbsentClusters checks that warning is logged for each cluster
+// without deleted rows
+func TestWarnAboutAbsentClusters(t *testing.T) {
+	restoreLogger(t)
+
+	reconciliation := cleaner.Reconciliation{
+		Deleted:       cleaner.ClusterList{cluster1ID},
+		AlreadyAbsent: cleaner.ClusterList{cluster2ID, cluster3ID},
+	}
+
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		cleaner.WarnAboutAbsentClusters(reconciliation, "ocp", cleaner.DBSchemaOCPRecommendations)
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, strings.Count(output, "Zero rows deleted for cluster"))
+	assert.Contains(t, output, cluster2ID)
+	assert.Contains(t, output, cluster3ID)
+	assert.Contains(t, output, "check that the right database and schema are used")
+
+	// nothing is logged when rows were deleted for all clusters
+	output, err = capture.ErrorOutput(func() {
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		cleaner.WarnAboutAbsentClusters(cleaner.Reconciliation{
+			Deleted: cleaner.ClusterList{cluster1ID},
+		}, "ocp", cleaner.DBSchemaOCPRecommendations)
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, output)
+}
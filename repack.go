@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/repack.html
+
+// This source file contains integration with pg_repack tool. VACUUM FULL
+// holds an exclusive lock on the table during the whole rewrite, so the
+// aggregator can not read or write the table. pg_repack rewrites the table
+// online and holds the exclusive lock only for a short time at the end. When
+// pg_repack is enabled in [repack] section of the configuration file, tables
+// selected by -vacuum-full command line option are rewritten by pg_repack
+// instead of VACUUM FULL. The pg_repack extension needs to be installed in
+// the database and the pg_repack command needs to be available.
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SQL statement used to check if pg_repack extension is installed
+const selectPgRepackInstalled = `
+    SELECT COUNT(*)
+      FROM pg_extension
+     WHERE extname = 'pg_repack'`
+
+// defaultRepackCommand is the pg_repack command used when no command is
+// specified in configuration
+const defaultRepackCommand = "pg_repack"
+
+// Space reclamation methods displayed in the run report
+const (
+	reclaimedByVacuumFull = "VACUUM FULL"
+	reclaimedByRepack     = "pg_repack"
+)
+
+// runCommand function executes external command and returns its combined
+// standard and error output. It is a variable so it can be replaced in unit
+// tests.
+var runCommand = func(command *exec.Cmd) ([]byte, error) {
+	return command.CombinedOutput()
+}
+
+// repackCommand function returns the pg_repack command specified in
+// configuration or the default one
+func repackCommand(repackConfiguration RepackConfiguration) string {
+	if repackConfiguration.Command == "" {
+		return defaultRepackCommand
+	}
+	return repackConfiguration.Command
+}
+
+// repackCommandArguments function constructs command line arguments for
+// pg_repack that rewrites one table. Password is not passed via arguments,
+// they can be read by other users of the system.
+func repackCommandArguments(storageConfiguration *StorageConfiguration,
+	repackConfiguration RepackConfiguration, databaseSchema, table string) []string {
+	args := []string{
+		"--host", storageConfiguration.PGHost,
+		"--port", strconv.Itoa(storageConfiguration.PGPort),
+		"--username", storageConfiguration.PGUsername,
+		"--dbname", storageConfiguration.PGDBName,
+		"--table", databaseSchema + "." + table,
+		"--no-order",
+	}
+	if repackConfiguration.WaitTimeout > 0 {
+		args = append(args, "--wait-timeout", strconv.Itoa(repackConfiguration.WaitTimeout))
+	}
+	return args
+}
+
+// checkPgRepackInstalled function checks if pg_repack extension is installed
+// in the database
+func checkPgRepackInstalled(connection *sql.DB) error {
+	var installed int
+	err := queryRowStatement(connection, selectPgRepackInstalled).Scan(&installed)
+	if err != nil {
+		return queryFailed("pg_extension", err)
+	}
+	if installed == 0 {
+		return errors.New("extension pg_repack is not installed in the database")
+	}
+	return nil
+}
+
+// performRepackInDB function rewrites selected tables by pg_repack, so space
+// occupied by deleted records is returned to operating system without
+// locking the tables for the whole rewrite. Only tables managed by the
+// cleaner in the selected DB schema can be repacked. Repacking continues with
+// the next table when any table fails, names of repacked tables are returned.
+func performRepackInDB(connection *sql.DB, storageConfiguration *StorageConfiguration,
+	repackConfiguration RepackConfiguration, tables []string, schema string) ([]string, error) {
+	var repacked []string
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return repacked, ErrNoConnection
+	}
+
+	if len(tables) == 0 {
+		return repacked, errors.New("no table selected for pg_repack")
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return repacked, err
+	}
+
+	err = checkManagedTables(tables, schema)
+	if err != nil {
+		return repacked, err
+	}
+
+	err = checkPgRepackInstalled(connection)
+	if err != nil {
+		return repacked, err
+	}
+
+	command := repackCommand(repackConfiguration)
+	log.Info().
+		Str("command", command).
+		Strs("tables", tables).
+		Msg("Repacking started")
+	var errs []error
+	for _, table := range tables {
+		args := repackCommandArguments(storageConfiguration, repackConfiguration, databaseSchema, table)
+		// #nosec G204
+		cmd := exec.Command(command, args...)
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+storageConfiguration.PGPassword)
+
+		started := time.Now()
+		output, err := runCommand(cmd)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, table).
+				Str("output", strings.TrimSpace(string(output))).
+				Msg("Unable to repack table")
+			errs = append(errs, queryFailed(table, err))
+			continue
+		}
+		log.Info().
+			Str(tableName, table).
+			Dur("duration", time.Since(started)).
+			Msg("Table repacked")
+		repacked = append(repacked, table)
+	}
+	log.Info().Msg("Repacking finished")
+	return repacked, errors.Join(errs...)
+}
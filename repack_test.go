@@ -0,0 +1,237 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/repack_test.html
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// query expected by pg_repack tests
+const expectedPgRepackQuery = "SELECT COUNT\\(\\*\\) FROM pg_extension WHERE extname = 'pg_repack'"
+
+// storage configuration used by pg_repack tests
+var repackStorageConfiguration = cleaner.StorageConfiguration{
+	PGUsername: "user",
+	PGPassword: "password",
+	PGHost:     "localhost",
+	PGPort:     5432,
+	PGDBName:   "aggregator",
+}
+
+// mockRunCommand function replaces execution of external commands by
+// function that records executed commands
+func mockRunCommand(t *testing.T, err error) *[]*exec.Cmd {
+	var commands []*exec.Cmd
+
+	original := *cleaner.RunCommand
+	t.Cleanup(func() {
+		*cleaner.RunCommand = original
+	})
+
+	*cleaner.RunCommand = func(command *exec.Cmd) ([]byte, error) {
+		commands = append(commands, command)
+		return []byte("output"), err
+	}
+	return &commands
+}
+
+// TestRepackCommandArguments checks construction of pg_repack arguments
+func TestRepackCommandArguments(t *testing.T) {
+	args := cleaner.RepackCommandArguments(&repackStorageConfiguration,
+		cleaner.RepackConfiguration{}, "public", "report")
+	assert.Equal(t, []string{
+		"--host", "localhost",
+		"--port", "5432",
+		"--username", "user",
+		"--dbname", "aggregator",
+		"--table", "public.report",
+		"--no-order",
+	}, args)
+	assert.NotContains(t, args, "password")
+
+	args = cleaner.RepackCommandArguments(&repackStorageConfiguration,
+		cleaner.RepackConfiguration{WaitTimeout: 30}, "dvo", "dvo_report")
+	assert.Contains(t, args, "dvo.dvo_report")
+	assert.Equal(t, []string{"--wait-timeout", "30"}, args[len(args)-2:])
+}
+
+// TestPerformRepackInDB checks that selected tables are repacked by
+// pg_repack command
+func TestPerformRepackInDB(t *testing.T) {
+	commands := mockRunCommand(t, nil)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(expectedPgRepackQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	repacked, err := cleaner.PerformRepackInDB(connection, &repackStorageConfiguration,
+		cleaner.RepackConfiguration{Enabled: true, Command: "/usr/bin/pg_repack"},
+		[]string{"report", "rule_hit"}, cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "rule_hit"}, repacked)
+
+	assert.Len(t, *commands, 2)
+	for _, command := range *commands {
+		assert.Equal(t, "/usr/bin/pg_repack", command.Path)
+		assert.Contains(t, command.Env, "PGPASSWORD=password")
+	}
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformRepackInDBCommandError checks that repacking continues when
+// pg_repack fails for any table
+func TestPerformRepackInDBCommandError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+	commands := mockRunCommand(t, mockedError)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(expectedPgRepackQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	repacked, err := cleaner.PerformRepackInDB(connection, &repackStorageConfiguration,
+		cleaner.RepackConfiguration{Enabled: true},
+		[]string{"report", "rule_hit"}, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, mockedError)
+	assert.Empty(t, repacked)
+	assert.Len(t, *commands, 2)
+
+	var queryErr *cleaner.ErrQueryFailed
+	assert.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "report", queryErr.Table)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformRepackInDBExtensionNotInstalled checks that no table is
+// repacked when pg_repack extension is not installed
+func TestPerformRepackInDBExtensionNotInstalled(t *testing.T) {
+	commands := mockRunCommand(t, nil)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(expectedPgRepackQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	_, err = cleaner.PerformRepackInDB(connection, &repackStorageConfiguration,
+		cleaner.RepackConfiguration{Enabled: true},
+		[]string{"report"}, cleaner.DBSchemaOCPRecommendations)
+	assert.Error(t, err)
+	assert.Empty(t, *commands)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformRepackInDBWrongTables checks that tables not managed by the
+// cleaner are never repacked
+func TestPerformRepackInDBWrongTables(t *testing.T) {
+	commands := mockRunCommand(t, nil)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	mock.ExpectClose()
+
+	repackConfiguration := cleaner.RepackConfiguration{Enabled: true}
+
+	_, err = cleaner.PerformRepackInDB(nil, &repackStorageConfiguration, repackConfiguration,
+		[]string{"report"}, cleaner.DBSchemaOCPRecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	_, err = cleaner.PerformRepackInDB(connection, &repackStorageConfiguration, repackConfiguration,
+		nil, cleaner.DBSchemaOCPRecommendations)
+	assert.Error(t, err)
+
+	_, err = cleaner.PerformRepackInDB(connection, &repackStorageConfiguration, repackConfiguration,
+		[]string{"report", "dvo_report"}, cleaner.DBSchemaOCPRecommendations)
+	assert.Error(t, err)
+	assert.Empty(t, *commands)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestVacuumFullByRepack checks that tables selected by -vacuum-full are
+// repacked when pg_repack is enabled in configuration
+func TestVacuumFullByRepack(t *testing.T) {
+	commands := mockRunCommand(t, nil)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(expectedPgRepackQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Storage: repackStorageConfiguration,
+		Repack:  cleaner.RepackConfiguration{Enabled: true},
+	}
+	cliFlags := cleaner.CliFlags{VacuumFull: "dvo_report", ConfirmVacuumFull: "dvo_report"}
+
+	status, err := cleaner.VacuumFull(&configuration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.Len(t, *commands, 1)
+	assert.Contains(t, (*commands)[0].Args, "dvo.dvo_report")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/run_summary.html
+
+// This source file contains implementation of run summary event. The event is
+// logged at the end of every run and it contains selected operation, total
+// number of deleted rows, number of errors, duration of the run, and its exit
+// status. It allows to build simple log-based alerts without parsing tables
+// printed by the cleaner. Tables where row-level security applies to the
+// database user are included when they are found by preflight check.
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runSummaryMessage is message of event logged at the end of every run
+const runSummaryMessage = "run_summary"
+
+// Attributes of run summary event
+const (
+	operationAttribute  = "operation"
+	deletedAttribute    = "deleted"
+	errorsAttribute     = "errors"
+	exitStatusAttribute = "exit_status"
+	rlsTablesAttribute  = "rls_tables"
+)
+
+// operationName function returns name of selected operation. Names are the
+// same as command line options used to select the operation; listing of old
+// records is selected when no other operation is specified.
+func operationName(cliFlags CliFlags) string {
+	switch {
+	case cliFlags.ShowVersion:
+		return "version"
+	case cliFlags.ShowAuthors:
+		return "authors"
+	case cliFlags.ShowConfiguration:
+		return "show-configuration"
+	case cliFlags.ListQueries:
+		return "list-queries"
+	case cliFlags.ListExitCodes:
+		return "list-exit-codes"
+	case cliFlags.VacuumFull != "":
+		return "vacuum-full"
+	case cliFlags.InstallDBSchedule != "":
+		return "install-db-schedule"
+	case cliFlags.UninstallDBSchedule:
+		return "uninstall-db-schedule"
+	case cliFlags.ShowDBSchedule:
+		return "show-db-schedule"
+	case cliFlags.VacuumDatabase:
+		return "vacuum"
+	case cliFlags.PerformCleanupAll:
+		return "cleanup-all"
+	case cliFlags.OrgBatch != "":
+		return "org-batch"
+	case cliFlags.Cluster != "":
+		return "cluster"
+	case cliFlags.Watch != "":
+		return "watch"
+	case cliFlags.PerformCleanup:
+		return "cleanup"
+	case cliFlags.CleanupRule != "":
+		return "cleanup-rule"
+	case cliFlags.CleanupKafkaOffsets != "":
+		return "cleanup-kafka-offsets"
+	case cliFlags.CleanupRatings:
+		return "cleanup-ratings"
+	case cliFlags.CompactPayloads:
+		return "compact-payloads"
+	case cliFlags.ValidatePayloads:
+		return "validate-payloads"
+	case cliFlags.DetectMultipleRuleDisable:
+		return "multiple-rule-disable"
+	case cliFlags.SizeSnapshot != "":
+		return "size-snapshot"
+	case cliFlags.TimestampAnomalies != "":
+		return "timestamp-anomalies"
+	case cliFlags.DVONamespaceStatistics:
+		return "dvo-namespace-stats"
+	case cliFlags.BloatReport:
+		return "bloat-report"
+	case cliFlags.Coverage:
+		return "coverage"
+	case cliFlags.DiscoverTables != "":
+		return "discover-tables"
+	case cliFlags.ConsumerErrorOffsets:
+		return "consumer-error-offsets"
+	case cliFlags.ExportConsumerErrors:
+		return "export-consumer-errors"
+	case cliFlags.FillInDatabase:
+		return "fill-in-db"
+	case cliFlags.Digest:
+		return "digest"
+	case cliFlags.Status:
+		return "status"
+	case cliFlags.ExportState != "":
+		return "export-state"
+	case cliFlags.ImportState != "":
+		return "import-state"
+	case cliFlags.CompareMaxAge != "":
+		return "compare-max-age"
+	case cliFlags.Estimate:
+		return "estimate"
+	case cliFlags.CountOnly:
+		return "count-only"
+	default:
+		return "list"
+	}
+}
+
+// errorCount function returns number of errors joined together by
+// errors.Join. Zero is returned for nil error.
+func errorCount(err error) int {
+	if err == nil {
+		return 0
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return 1
+	}
+	count := 0
+	for _, e := range joined.Unwrap() {
+		count += errorCount(e)
+	}
+	return count
+}
+
+// logRunSummary function logs one event summarizing the whole run. Number of
+// deleted rows is read from metrics, so it is the sum over all storage
+// targets.
+func logRunSummary(cliFlags CliFlags, started time.Time, exitStatus ExitStatus, err error) {
+	event := log.Info().
+		Str(operationAttribute, operationName(cliFlags)).
+		Bool("dry_run", cliFlags.DryRun).
+		Int64(deletedAttribute, deletedRowsTotal.Load()).
+		Int(errorsAttribute, errorCount(err)).
+		Dur(durationAttribute, time.Since(started)).
+		Int(exitStatusAttribute, int(exitStatus))
+	if tables := rowSecurityTablesFound(); len(tables) > 0 {
+		event = event.Strs(rlsTablesAttribute, tables)
+	}
+	event.Msg(runSummaryMessage)
+}
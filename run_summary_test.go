@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/run_summary_test.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestOperationName checks names of selected operations
+func TestOperationName(t *testing.T) {
+	assert.Equal(t, "list", cleaner.OperationName(cleaner.CliFlags{}))
+	assert.Equal(t, "cleanup-all", cleaner.OperationName(cleaner.CliFlags{PerformCleanupAll: true}))
+	assert.Equal(t, "cleanup", cleaner.OperationName(cleaner.CliFlags{PerformCleanup: true}))
+	assert.Equal(t, "org-batch", cleaner.OperationName(cleaner.CliFlags{OrgBatch: "orgs.txt"}))
+	assert.Equal(t, "export-state", cleaner.OperationName(cleaner.CliFlags{ExportState: "-"}))
+	assert.Equal(t, "count-only", cleaner.OperationName(cleaner.CliFlags{CountOnly: true}))
+	assert.Equal(t, "status", cleaner.OperationName(cleaner.CliFlags{Status: true}))
+	assert.Equal(t, "cluster", cleaner.OperationName(cleaner.CliFlags{Cluster: "5d5892d3-1f74-4ccf-91af-548dfc9767aa"}))
+	assert.Equal(t, "watch", cleaner.OperationName(cleaner.CliFlags{Watch: "requests"}))
+
+	// the same priority as in doSelectedOperation
+	assert.Equal(t, "version", cleaner.OperationName(cleaner.CliFlags{ShowVersion: true, PerformCleanup: true}))
+	assert.Equal(t, "cleanup-all", cleaner.OperationName(cleaner.CliFlags{PerformCleanupAll: true, PerformCleanup: true}))
+}
+
+// TestErrorCount checks that all joined errors are counted
+func TestErrorCount(t *testing.T) {
+	first := errors.New("first")
+	second := errors.New("second")
+
+	assert.Equal(t, 0, cleaner.ErrorCount(nil))
+	assert.Equal(t, 1, cleaner.ErrorCount(first))
+	assert.Equal(t, 1, cleaner.ErrorCount(fmt.Errorf("wrapped: %w", first)))
+	assert.Equal(t, 2, cleaner.ErrorCount(errors.Join(first, second)))
+	assert.Equal(t, 3, cleaner.ErrorCount(errors.Join(first, errors.Join(second, first))))
+}
+
+// TestLogRunSummary checks that run summary is logged as one event with all
+// attributes
+func TestLogRunSummary(t *testing.T) {
+	restoreLogger(t)
+
+	var buffer bytes.Buffer
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	log.Logger = zerolog.New(&buffer)
+
+	cliFlags := cleaner.CliFlags{PerformCleanupAll: true, DryRun: false}
+	cleaner.LogRunSummary(cliFlags, time.Now().Add(-time.Second),
+		cleaner.ExitStatusPerformCleanupError, errors.Join(errors.New("first"), errors.New("second")))
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &event))
+	assert.Equal(t, "run_summary", event["message"])
+	assert.Equal(t, "cleanup-all", event["operation"])
+	assert.Equal(t, false, event["dry_run"])
+	assert.Contains(t, event, "deleted")
+	assert.Equal(t, float64(2), event["errors"])
+	assert.GreaterOrEqual(t, event["duration"], float64(1000))
+	assert.Equal(t, float64(cleaner.ExitStatusPerformCleanupError), event["exit_status"])
+}
+
+// TestLogRunSummaryRowSecurity checks that tables with row-level security
+// found by preflight check are included in run summary
+func TestLogRunSummaryRowSecurity(t *testing.T) {
+	restoreLogger(t)
+	*cleaner.RowSecurityTables = cleaner.StringSet{"public.report": {}, "public.advisor_ratings": {}}
+	t.Cleanup(func() {
+		*cleaner.RowSecurityTables = cleaner.StringSet{}
+	})
+
+	var buffer bytes.Buffer
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	log.Logger = zerolog.New(&buffer)
+
+	cleaner.LogRunSummary(cleaner.CliFlags{PerformCleanup: true}, time.Now(), cleaner.ExitStatusOK, nil)
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &event))
+	assert.Equal(t, []interface{}{"public.advisor_ratings", "public.report"}, event["rls_tables"])
+}
@@ -0,0 +1,262 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/schedule.html
+
+// This source file contains implementation of in-database scheduling of
+// cleanup. Age-based delete statements used by cleanup-all are installed as
+// pg_cron jobs, so they are run periodically by the database itself instead
+// of by CronJob that starts the cleaner. One job is installed for each DB
+// schema and it runs the delete statements in the same order as cleanup-all,
+// so tables referenced by foreign keys are cleaned last. Jobs are identified
+// by common prefix of their names, so they can be displayed and uninstalled
+// later.
+//
+// Jobs are installed by -install-db-schedule, uninstalled by
+// -uninstall-db-schedule, and displayed by -show-db-schedule command line
+// options.
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// SQL statements used to manage pg_cron jobs
+const (
+	selectPgCronInstalled = `
+	    SELECT COUNT(*)
+	      FROM pg_extension
+	     WHERE extname = 'pg_cron'`
+
+	scheduleCronJob = `
+	    SELECT cron.schedule($1, $2, $3)`
+
+	unscheduleCronJobs = `
+	    SELECT cron.unschedule(jobid)
+	      FROM cron.job
+	     WHERE jobname LIKE $1 ESCAPE '\'`
+
+	selectCronJobs = `
+	    SELECT jobid, jobname, schedule, command, active
+	      FROM cron.job
+	     WHERE jobname LIKE $1 ESCAPE '\'
+	     ORDER BY jobname`
+)
+
+// cronJobPrefix is prefix of names of all pg_cron jobs installed by the
+// cleaner
+const cronJobPrefix = "insights-results-aggregator-cleaner"
+
+// intervalParameter is the parameter used by age-based delete statements
+const intervalParameter = "$1::INTERVAL"
+
+// cronJobNamePrefix function returns prefix of names of pg_cron jobs
+// installed for given DB schema
+func cronJobNamePrefix(schema string) string {
+	return cronJobPrefix + "-" + schema + "-"
+}
+
+// cronJobName function returns name of pg_cron job installed for given DB
+// schema
+func cronJobName(schema string) string {
+	return cronJobNamePrefix(schema) + "cleanup-all"
+}
+
+// likePatternEscaper escapes characters that have special meaning in LIKE
+// patterns, ie. '_' and '%' (backslash is used as escape character)
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, "_", `\_`, "%", `\%`)
+
+// cronJobNamePattern function returns LIKE pattern matching names of all
+// pg_cron jobs installed for given DB schema
+func cronJobNamePattern(schema string) string {
+	return likePatternEscaper.Replace(cronJobNamePrefix(schema)) + "%"
+}
+
+// cronJobCommand function constructs SQL command run by pg_cron job. Max age
+// is embedded into delete statements as a literal, because pg_cron jobs can
+// not be parametrized. Search path is set explicitly, because jobs are run in
+// new sessions. Statements are run in the given order.
+func cronJobCommand(deleteStatements []string, maxAge, databaseSchema string) string {
+	command := "SET search_path TO " + pq.QuoteIdentifier(databaseSchema) + ";"
+	for _, deleteStatement := range deleteStatements {
+		statement := qualifyTableNames(deleteStatement)
+		statement = strings.Replace(statement, intervalParameter, pq.QuoteLiteral(maxAge)+"::INTERVAL", -1)
+		command += " " + strings.Join(strings.Fields(statement), " ") + ";"
+	}
+	return command
+}
+
+// checkPgCronInstalled function checks if pg_cron extension is installed in
+// the database
+func checkPgCronInstalled(connection *sql.DB) error {
+	var installed int
+	err := queryRowStatement(connection, selectPgCronInstalled).Scan(&installed)
+	if err != nil {
+		return queryFailed("pg_extension", err)
+	}
+	if installed == 0 {
+		return errors.New("extension pg_cron is not installed in the database")
+	}
+	return nil
+}
+
+// installCronJobsInDB function installs pg_cron job that runs delete
+// statements used by cleanup-all in the same order as cleanup-all does.
+// Job that already exists is updated. Name of installed job is returned.
+func installCronJobsInDB(connection *sql.DB, cronSchedule, maxAge, schema string) (string, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return "", ErrNoConnection
+	}
+
+	if maxAge == "" {
+		return "", errors.New(maxAgeMissing)
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return "", err
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkPgCronInstalled(connection)
+	if err != nil {
+		return "", err
+	}
+
+	// one job is used, so the statements are not run concurrently and
+	// tables referenced by foreign keys are cleaned last
+	deleteStatements := make([]string, 0, len(tablesToDelete))
+	for _, tableAndDeleteStatement := range tablesToDelete {
+		deleteStatements = append(deleteStatements, tableAndDeleteStatement.DeleteStatement)
+	}
+	jobName := cronJobName(schema)
+	command := cronJobCommand(deleteStatements, maxAge, databaseSchema)
+
+	var jobID int64
+	err = queryRowStatement(connection, scheduleCronJob, jobName, cronSchedule, command).Scan(&jobID)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("job name", jobName).
+			Msg("Unable to install pg_cron job")
+		return "", queryFailed("cron.job", err)
+	}
+	log.Info().
+		Int64("job ID", jobID).
+		Str("job name", jobName).
+		Str("schedule", cronSchedule).
+		Msg("pg_cron job installed")
+	return jobName, nil
+}
+
+// uninstallCronJobsInDB function uninstalls all pg_cron jobs installed by
+// the cleaner for given DB schema. Number of uninstalled jobs is returned.
+func uninstallCronJobsInDB(connection *sql.DB, schema string) (int, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return 0, ErrNoConnection
+	}
+
+	err := checkPgCronInstalled(connection)
+	if err != nil {
+		return 0, err
+	}
+
+	uninstalled := 0
+	err = queryRows(connection, unscheduleCronJobs, []interface{}{cronJobNamePattern(schema)},
+		func(rows *sql.Rows) error {
+			var unscheduled bool
+			if err := rows.Scan(&unscheduled); err != nil {
+				return err
+			}
+			if unscheduled {
+				uninstalled++
+			}
+			return nil
+		})
+	if err != nil {
+		return uninstalled, queryFailed("cron.job", err)
+	}
+
+	log.Info().Int("jobs", uninstalled).Msg("pg_cron jobs uninstalled")
+	return uninstalled, nil
+}
+
+// readCronJobs function reads all pg_cron jobs installed by the cleaner for
+// given DB schema
+func readCronJobs(connection *sql.DB, schema string) ([]CronJob, error) {
+	var jobs []CronJob
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return jobs, ErrNoConnection
+	}
+
+	err := checkPgCronInstalled(connection)
+	if err != nil {
+		return jobs, err
+	}
+
+	err = queryRows(connection, selectCronJobs, []interface{}{cronJobNamePattern(schema)},
+		func(rows *sql.Rows) error {
+			var job CronJob
+			if err := rows.Scan(&job.JobID, &job.JobName, &job.Schedule, &job.Command, &job.Active); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	if err != nil {
+		return jobs, queryFailed("cron.job", err)
+	}
+	return jobs, nil
+}
+
+// PrintCronJobs function displays a table with pg_cron jobs installed by the
+// cleaner
+func PrintCronJobs(jobs []CronJob) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Job ID", "Job name", "Schedule", "Active", "Command"})
+
+	for _, job := range jobs {
+		table.Append([]string{strconv.FormatInt(job.JobID, 10),
+			job.JobName,
+			job.Schedule,
+			strconv.FormatBool(job.Active),
+			job.Command})
+	}
+
+	// display the whole table
+	table.Render()
+}
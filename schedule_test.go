@@ -0,0 +1,233 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/schedule_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// queries expected by DB schedule tests
+const (
+	expectedPgCronQuery      = "SELECT COUNT\\(\\*\\) FROM pg_extension WHERE extname = 'pg_cron'"
+	expectedScheduleQuery    = "SELECT cron.schedule"
+	expectedUnscheduleQuery  = "SELECT cron.unschedule\\(jobid\\) FROM cron.job"
+	expectedSelectCronQuery  = "SELECT jobid, jobname, schedule, command, active FROM cron.job"
+	expectedDVOJobName       = "insights-results-aggregator-cleaner-dvo_recommendations-cleanup-all"
+	expectedDVOJobNamePrefix = `insights-results-aggregator-cleaner-dvo\_recommendations-%`
+)
+
+// expectPgCronInstalled function sets expectation for check of pg_cron
+// extension
+func expectPgCronInstalled(mock sqlmock.Sqlmock, installed int) {
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(installed)
+	mock.ExpectQuery(expectedPgCronQuery).WillReturnRows(rows)
+}
+
+// TestCronJobCommand checks construction of SQL command run by pg_cron job
+func TestCronJobCommand(t *testing.T) {
+	command := cleaner.CronJobCommand([]string{`
+		DELETE FROM dvo_report
+		 WHERE last_checked_at < NOW() - $1::INTERVAL`}, "90 days", "dvo")
+	assert.Equal(t, `SET search_path TO "dvo"; DELETE FROM dvo_report WHERE last_checked_at < NOW() - '90 days'::INTERVAL;`,
+		command)
+
+	// statements are run in given order
+	command = cleaner.CronJobCommand([]string{
+		"DELETE FROM rule_hit WHERE updated_at < NOW() - $1::INTERVAL",
+		"DELETE FROM report WHERE reported_at < NOW() - $1::INTERVAL"}, "90 days", "public")
+	assert.Equal(t, `SET search_path TO "public"; `+
+		`DELETE FROM rule_hit WHERE updated_at < NOW() - '90 days'::INTERVAL; `+
+		`DELETE FROM report WHERE reported_at < NOW() - '90 days'::INTERVAL;`,
+		command)
+
+	// max age is quoted properly
+	command = cleaner.CronJobCommand([]string{"DELETE FROM report WHERE reported_at < NOW() - $1::INTERVAL"},
+		"1 day'; DROP TABLE report; --", "public")
+	assert.Contains(t, command, `'1 day''; DROP TABLE report; --'::INTERVAL`)
+}
+
+// TestCronJobNamePattern checks that characters with special meaning in LIKE
+// patterns are escaped in prefix of pg_cron job names
+func TestCronJobNamePattern(t *testing.T) {
+	assert.Equal(t, expectedDVOJobNamePrefix,
+		cleaner.CronJobNamePattern(cleaner.DBSchemaDVORecommendations))
+	assert.Equal(t, `insights-results-aggregator-cleaner-a\\b\%c\_d-%`,
+		cleaner.CronJobNamePattern(`a\b%c_d`))
+}
+
+// TestInstallCronJobsInDB checks that one pg_cron job running delete
+// statements in cleanup-all order is installed
+func TestInstallCronJobsInDB(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPgCronInstalled(mock, 1)
+	mock.ExpectQuery(expectedScheduleQuery).
+		WithArgs(expectedDVOJobName, "0 3 * * *", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"schedule"}).AddRow(42))
+	mock.ExpectClose()
+
+	job, err := cleaner.InstallCronJobsInDB(connection, "0 3 * * *", "90 days",
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDVOJobName, job)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestInstallCronJobsInDBOnError checks that errors during installation of
+// pg_cron jobs are reported
+func TestInstallCronJobsInDBOnError(t *testing.T) {
+	mockedError := errors.New("mocked error")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPgCronInstalled(mock, 0)
+	expectPgCronInstalled(mock, 1)
+	mock.ExpectQuery(expectedScheduleQuery).WillReturnError(mockedError)
+	mock.ExpectClose()
+
+	_, err = cleaner.InstallCronJobsInDB(nil, "0 3 * * *", "90 days",
+		cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	_, err = cleaner.InstallCronJobsInDB(connection, "0 3 * * *", "",
+		cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+
+	_, err = cleaner.InstallCronJobsInDB(connection, "0 3 * * *", "90 days", "unknown")
+	var invalidSchemaErr *cleaner.ErrInvalidSchema
+	assert.ErrorAs(t, err, &invalidSchemaErr)
+
+	// extension is not installed
+	_, err = cleaner.InstallCronJobsInDB(connection, "0 3 * * *", "90 days",
+		cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+
+	// job can not be installed
+	job, err := cleaner.InstallCronJobsInDB(connection, "0 3 * * *", "90 days",
+		cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, mockedError)
+	assert.Empty(t, job)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestUninstallCronJobsInDB checks that all pg_cron jobs installed by the
+// cleaner are uninstalled
+func TestUninstallCronJobsInDB(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPgCronInstalled(mock, 1)
+	mock.ExpectQuery(expectedUnscheduleQuery).
+		WithArgs(expectedDVOJobNamePrefix).
+		WillReturnRows(sqlmock.NewRows([]string{"unschedule"}).AddRow(true).AddRow(true))
+	mock.ExpectClose()
+
+	uninstalled, err := cleaner.UninstallCronJobsInDB(connection, cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, uninstalled)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestShowDBSchedule checks that pg_cron jobs installed by the cleaner are
+// displayed
+func TestShowDBSchedule(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectPgCronInstalled(mock, 1)
+	rows := sqlmock.NewRows([]string{"jobid", "jobname", "schedule", "command", "active"}).
+		AddRow(42, expectedDVOJobName, "0 3 * * *", "DELETE FROM dvo_report", true)
+	mock.ExpectQuery(expectedSelectCronQuery).
+		WithArgs(expectedDVOJobNamePrefix).
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	output, err := capture.StandardOutput(func() {
+		status, err := cleaner.ShowDBSchedule(connection, cleaner.DBSchemaDVORecommendations)
+		assert.NoError(t, err)
+		assert.Equal(t, cleaner.ExitStatusOK, status)
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, output, expectedDVOJobName)
+	assert.Contains(t, output, "0 3 * * *")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestInstallDBScheduleMaxAgeNotConfirmed checks that pg_cron jobs are not
+// installed when max age is not confirmed
+func TestInstallDBScheduleMaxAgeNotConfirmed(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{}
+	cliFlags := cleaner.CliFlags{
+		InstallDBSchedule: "0 3 * * *",
+		MaxAge:            "90 days",
+		ConfirmMaxAge:     "9 days",
+	}
+
+	status, err := cleaner.InstallDBSchedule(&configuration, connection, cliFlags,
+		cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
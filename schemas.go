@@ -0,0 +1,271 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/schemas.html
+
+// This source file contains support for plug-in DB schemas. Besides built-in
+// ocp_recommendations and dvo_recommendations schemas it is possible to
+// declare new logical schema in configuration file, so other databases from
+// the aggregator family (for example notifications writer) can be cleaned by
+// the same binary:
+//
+// [[schemas]]
+// name = "notifications"
+// database_schema = "public"
+//
+// [[schemas.tables]]
+// table = "reported"
+// key = "cluster"
+// org_key = "org_id"
+// delete_statement = "DELETE FROM reported WHERE updated_at < NOW() - $1::INTERVAL"
+//
+// Tables with key are used by -cleanup operation, tables with delete statement
+// are used by -cleanup-all operation. Tables are processed in the same order
+// as they are declared, so tables referenced by foreign keys must be declared
+// last.
+//
+// OCP tables are expected to be stored in the default schema. When they are
+// stored in another schema, its name can be set by ocp_table_schema option in
+// [cleaner] section. All references to OCP tables in SQL statements are
+// qualified by this name before the statements are executed.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Constants used by plug-in schemas
+const (
+	defaultDatabaseSchema   = "public"
+	deleteStatementPrefix   = "DELETE"
+	deleteStatementArgument = "$1"
+)
+
+// pluginSchemas contains all plug-in schemas declared in configuration file
+var pluginSchemas = map[string]SchemaConfiguration{}
+
+// ocpTables contains names of all tables stored in OCP database
+var ocpTables = []string{
+	"advisor_ratings",
+	"cluster_rule_toggle",
+	"cluster_rule_user_feedback",
+	"cluster_user_rule_disable_feedback",
+	"consumer_error",
+	"migration_info",
+	"recommendation",
+	"report",
+	"report_info",
+	"rule_hit",
+}
+
+// ocpTableReference matches references to OCP tables in SQL statements
+var ocpTableReference = regexp.MustCompile(
+	`(?i)\b(FROM|JOIN|INTO|UPDATE)(\s+)(` + strings.Join(ocpTables, "|") + `)\b`)
+
+// schemaNamePattern is used to check name of schema set in configuration
+var schemaNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ocpTableSchema contains name of schema where OCP tables are stored. Table
+// names are not qualified when it is empty.
+var ocpTableSchema = ""
+
+// checkPluginSchemas function checks if plug-in schemas declared in
+// configuration file are correct
+func checkPluginSchemas(schemas []SchemaConfiguration) error {
+	names := make(StringSet)
+
+	for _, schema := range schemas {
+		if schema.Name == "" {
+			return fmt.Errorf("Plug-in schema name is not specified in configuration")
+		}
+
+		if schema.Name == DBSchemaOCPRecommendations || schema.Name == DBSchemaDVORecommendations {
+			return fmt.Errorf("Plug-in schema can not override built-in schema: %s", schema.Name)
+		}
+
+		if _, found := names[schema.Name]; found {
+			return fmt.Errorf("Plug-in schema declared more than once: %s", schema.Name)
+		}
+		names[schema.Name] = struct{}{}
+
+		if len(schema.Tables) == 0 {
+			return fmt.Errorf("No tables declared for plug-in schema: %s", schema.Name)
+		}
+
+		for _, table := range schema.Tables {
+			err := checkPluginSchemaTable(schema.Name, table)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPluginSchemaTable function checks one table declared for plug-in
+// schema
+func checkPluginSchemaTable(schema string, table SchemaTableConfiguration) error {
+	if table.TableName == "" {
+		return fmt.Errorf("Table name is not specified for plug-in schema: %s", schema)
+	}
+
+	if table.KeyName == "" && table.DeleteStatement == "" {
+		return fmt.Errorf("Neither key nor delete statement is specified for table %s in plug-in schema %s",
+			table.TableName, schema)
+	}
+
+	if table.OrgKeyName != "" && table.KeyName == "" {
+		return fmt.Errorf("Organization key can not be used without key for table %s in plug-in schema %s",
+			table.TableName, schema)
+	}
+
+	if table.DeleteStatement != "" {
+		// delete statement is converted into SELECT in dry-run mode and
+		// max age is passed as its only parameter
+		statement := strings.TrimSpace(table.DeleteStatement)
+		if !strings.HasPrefix(strings.ToUpper(statement), deleteStatementPrefix) ||
+			!strings.Contains(statement, deleteStatementArgument) {
+			return fmt.Errorf("Delete statement for table %s in plug-in schema %s must be DELETE with $1 parameter",
+				table.TableName, schema)
+		}
+	}
+
+	return nil
+}
+
+// registerPluginSchemas function registers all plug-in schemas declared in
+// configuration file, so they can be selected by name in the same way as
+// built-in schemas
+func registerPluginSchemas(schemas []SchemaConfiguration) {
+	pluginSchemas = make(map[string]SchemaConfiguration, len(schemas))
+	for _, schema := range schemas {
+		pluginSchemas[schema.Name] = schema
+	}
+}
+
+// checkOCPTableSchema function checks name of schema where OCP tables are
+// stored. The name is used to construct SQL statements, so just plain
+// identifiers are accepted.
+func checkOCPTableSchema(schema string) error {
+	if schema != "" && !schemaNamePattern.MatchString(schema) {
+		return fmt.Errorf("Incorrect OCP table schema found in configuration: %s", schema)
+	}
+	return nil
+}
+
+// registerOCPTableSchema function registers name of schema where OCP tables
+// are stored
+func registerOCPTableSchema(schema string) {
+	ocpTableSchema = schema
+}
+
+// qualifyTableNames function qualifies all references to OCP tables in given
+// SQL statement by name of schema where OCP tables are stored. The statement
+// is returned unchanged when the schema is not set.
+func qualifyTableNames(statement string) string {
+	if ocpTableSchema == "" {
+		return statement
+	}
+	return ocpTableReference.ReplaceAllString(statement, "${1}${2}"+ocpTableSchema+".${3}")
+}
+
+// tablesAndKeysForSchema function returns list of tables together with keys
+// used to cleanup selected clusters in given DB schema
+func tablesAndKeysForSchema(schema string) ([]TableAndKey, error) {
+	// this is actually shorter than using map + map selector + test for key existence
+	// and it allow us to do fine tuning for (any) DB schema in future
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		return tablesAndKeysInOCPDatabase, nil
+	case DBSchemaDVORecommendations:
+		return tablesAndKeysInDVODatabase, nil
+	}
+
+	pluginSchema, found := pluginSchemas[schema]
+	if !found {
+		return nil, invalidSchema(schema)
+	}
+
+	tablesAndKeys := []TableAndKey{}
+	for _, table := range pluginSchema.Tables {
+		if table.KeyName == "" {
+			continue
+		}
+		tablesAndKeys = append(tablesAndKeys, TableAndKey{
+			TableName:  table.TableName,
+			KeyName:    table.KeyName,
+			OrgKeyName: table.OrgKeyName,
+		})
+	}
+	return tablesAndKeys, nil
+}
+
+// tablesToDeleteForSchema function returns list of tables together with
+// statements used to cleanup old records in given DB schema
+func tablesToDeleteForSchema(schema string) ([]TableAndDeleteStatement, error) {
+	// OCP and DVO tables are stored in different databases
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		return tablesToDeleteOCP, nil
+	case DBSchemaDVORecommendations:
+		return tablesToDeleteDVO, nil
+	}
+
+	pluginSchema, found := pluginSchemas[schema]
+	if !found {
+		return nil, invalidSchema(schema)
+	}
+
+	tablesToDelete := []TableAndDeleteStatement{}
+	for _, table := range pluginSchema.Tables {
+		if table.DeleteStatement == "" {
+			continue
+		}
+		tablesToDelete = append(tablesToDelete, TableAndDeleteStatement{
+			TableName:       table.TableName,
+			DeleteStatement: table.DeleteStatement,
+		})
+	}
+	return tablesToDelete, nil
+}
+
+// databaseSchemaForSchema function returns name of PostgreSQL schema where
+// tables for given DB schema are stored
+func databaseSchemaForSchema(schema string) (string, error) {
+	if schema == DBSchemaOCPRecommendations && ocpTableSchema != "" {
+		return ocpTableSchema, nil
+	}
+
+	databaseSchema, found := databaseSchemaNames[schema]
+	if found {
+		return databaseSchema, nil
+	}
+
+	pluginSchema, found := pluginSchemas[schema]
+	if !found {
+		return "", invalidSchema(schema)
+	}
+
+	if pluginSchema.DatabaseSchema == "" {
+		return defaultDatabaseSchema, nil
+	}
+	return pluginSchema.DatabaseSchema, nil
+}
@@ -0,0 +1,311 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/schemas_test.html
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+const pluginSchemaName = "notifications"
+
+// notificationsSchema returns plug-in schema used by tests
+func notificationsSchema() cleaner.SchemaConfiguration {
+	return cleaner.SchemaConfiguration{
+		Name: pluginSchemaName,
+		Tables: []cleaner.SchemaTableConfiguration{
+			{
+				TableName:       "reported",
+				KeyName:         "cluster",
+				OrgKeyName:      "org_id",
+				DeleteStatement: "DELETE FROM reported WHERE updated_at < NOW() - $1::INTERVAL",
+			},
+			{
+				TableName:       "read_errors",
+				DeleteStatement: "DELETE FROM read_errors WHERE created_at < NOW() - $1::INTERVAL",
+			},
+		},
+	}
+}
+
+// registerNotificationsSchema registers plug-in schema used by tests and
+// unregisters it when the test finishes
+func registerNotificationsSchema(t *testing.T) {
+	cleaner.RegisterPluginSchemas([]cleaner.SchemaConfiguration{notificationsSchema()})
+	t.Cleanup(func() {
+		cleaner.RegisterPluginSchemas(nil)
+	})
+}
+
+// TestCheckPluginSchemas checks that correct plug-in schemas are accepted
+func TestCheckPluginSchemas(t *testing.T) {
+	assert.NoError(t, cleaner.CheckPluginSchemas(nil))
+	assert.NoError(t, cleaner.CheckPluginSchemas([]cleaner.SchemaConfiguration{notificationsSchema()}))
+}
+
+// TestCheckPluginSchemasNegativeTestCases checks that incorrect plug-in
+// schemas are rejected
+func TestCheckPluginSchemasNegativeTestCases(t *testing.T) {
+	table := cleaner.SchemaTableConfiguration{
+		TableName: "reported",
+		KeyName:   "cluster",
+	}
+
+	testCases := map[string][]cleaner.SchemaConfiguration{
+		"missing name": {
+			{Tables: []cleaner.SchemaTableConfiguration{table}},
+		},
+		"built-in schema": {
+			{Name: cleaner.DBSchemaOCPRecommendations, Tables: []cleaner.SchemaTableConfiguration{table}},
+		},
+		"duplicate schema": {
+			notificationsSchema(),
+			notificationsSchema(),
+		},
+		"no tables": {
+			{Name: pluginSchemaName},
+		},
+		"missing table name": {
+			{Name: pluginSchemaName, Tables: []cleaner.SchemaTableConfiguration{
+				{KeyName: "cluster"},
+			}},
+		},
+		"missing key and statement": {
+			{Name: pluginSchemaName, Tables: []cleaner.SchemaTableConfiguration{
+				{TableName: "reported"},
+			}},
+		},
+		"org key without key": {
+			{Name: pluginSchemaName, Tables: []cleaner.SchemaTableConfiguration{
+				{TableName: "reported", OrgKeyName: "org_id", DeleteStatement: "DELETE FROM reported WHERE x < $1"},
+			}},
+		},
+		"not a delete statement": {
+			{Name: pluginSchemaName, Tables: []cleaner.SchemaTableConfiguration{
+				{TableName: "reported", DeleteStatement: "TRUNCATE reported"},
+			}},
+		},
+		"delete statement without parameter": {
+			{Name: pluginSchemaName, Tables: []cleaner.SchemaTableConfiguration{
+				{TableName: "reported", DeleteStatement: "DELETE FROM reported"},
+			}},
+		},
+	}
+
+	for name, schemas := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, cleaner.CheckPluginSchemas(schemas))
+		})
+	}
+}
+
+// TestTablesForBuiltInSchemas checks that built-in schemas are not affected
+// by plug-in schemas
+func TestTablesForBuiltInSchemas(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	tablesAndKeys, err := cleaner.TablesAndKeysForSchema(cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.TablesAndKeysInOCPDatabase, tablesAndKeys)
+
+	tablesToDelete, err := cleaner.TablesToDeleteForSchema(cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.TablesToDeleteDVO, tablesToDelete)
+
+	databaseSchema, err := cleaner.DatabaseSchemaForSchema(cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, "dvo", databaseSchema)
+}
+
+// TestTablesForPluginSchema checks that tables are taken from registered
+// plug-in schema
+func TestTablesForPluginSchema(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	tablesAndKeys, err := cleaner.TablesAndKeysForSchema(pluginSchemaName)
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.TableAndKey{
+		{TableName: "reported", KeyName: "cluster", OrgKeyName: "org_id"},
+	}, tablesAndKeys)
+
+	tablesToDelete, err := cleaner.TablesToDeleteForSchema(pluginSchemaName)
+	assert.NoError(t, err)
+	assert.Len(t, tablesToDelete, 2)
+	assert.Equal(t, "reported", tablesToDelete[0].TableName)
+	assert.Equal(t, "read_errors", tablesToDelete[1].TableName)
+
+	databaseSchema, err := cleaner.DatabaseSchemaForSchema(pluginSchemaName)
+	assert.NoError(t, err)
+	assert.Equal(t, "public", databaseSchema)
+}
+
+// TestTablesForUnknownSchema checks that unknown schema is rejected
+func TestTablesForUnknownSchema(t *testing.T) {
+	_, err := cleaner.TablesAndKeysForSchema(pluginSchemaName)
+	assert.Error(t, err)
+
+	_, err = cleaner.TablesToDeleteForSchema(pluginSchemaName)
+	assert.Error(t, err)
+
+	_, err = cleaner.DatabaseSchemaForSchema(pluginSchemaName)
+	assert.Error(t, err)
+}
+
+// TestPerformCleanupAllInDBForPluginSchema checks that old records are
+// deleted from tables declared in plug-in schema
+func TestPerformCleanupAllInDBForPluginSchema(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM reported").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM read_errors").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectClose()
+
+	deletions, err := cleaner.PerformCleanupAllInDB(connection, "90 days", pluginSchemaName, false)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, map[string]int{"reported": 3, "read_errors": 5}, deletions)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformCleanupInDBForPluginSchema checks that records for selected
+// clusters are deleted from tables declared in plug-in schema
+func TestPerformCleanupInDBForPluginSchema(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM reported WHERE cluster = \\$1").
+		WithArgs(cluster1ID).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectClose()
+
+	deletions, _, err := cleaner.PerformCleanupInDB(connection,
+		cleaner.ClusterList{cluster1ID}, pluginSchemaName, 0, 0, false)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, map[string]int{"reported": 2}, deletions)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// registerOCPTableSchema registers schema where OCP tables are stored and
+// unregisters it when the test finishes
+func registerOCPTableSchema(t *testing.T, schema string) {
+	cleaner.RegisterOCPTableSchema(schema)
+	t.Cleanup(func() {
+		cleaner.RegisterOCPTableSchema("")
+	})
+}
+
+// TestCheckOCPTableSchema checks validation of schema where OCP tables are
+// stored
+func TestCheckOCPTableSchema(t *testing.T) {
+	assert.NoError(t, cleaner.CheckOCPTableSchema(""))
+	assert.NoError(t, cleaner.CheckOCPTableSchema("aggregator"))
+	assert.NoError(t, cleaner.CheckOCPTableSchema("_stage_2"))
+
+	assert.Error(t, cleaner.CheckOCPTableSchema("2stage"))
+	assert.Error(t, cleaner.CheckOCPTableSchema("aggregator.public"))
+	assert.Error(t, cleaner.CheckOCPTableSchema("public; DROP TABLE report"))
+}
+
+// TestQualifyTableNames checks that only references to OCP tables are
+// qualified by schema name
+func TestQualifyTableNames(t *testing.T) {
+	const statement = "SELECT report.cluster FROM report LEFT JOIN rule_hit ON rule_hit.cluster_id = report.cluster"
+
+	// table names are not changed by default
+	assert.Equal(t, statement, cleaner.QualifyTableNames(statement))
+
+	registerOCPTableSchema(t, "aggregator")
+
+	assert.Equal(t,
+		"SELECT report.cluster FROM aggregator.report LEFT JOIN aggregator.rule_hit ON rule_hit.cluster_id = report.cluster",
+		cleaner.QualifyTableNames(statement))
+	assert.Equal(t,
+		"select org_id from aggregator.report where cluster = $1",
+		cleaner.QualifyTableNames("select org_id from report where cluster = $1"))
+	assert.Equal(t,
+		"UPDATE aggregator.report_info SET x = 1",
+		cleaner.QualifyTableNames("UPDATE report_info SET x = 1"))
+	assert.Equal(t,
+		"INSERT INTO aggregator.consumer_error (topic) VALUES ($1)",
+		cleaner.QualifyTableNames("INSERT INTO consumer_error (topic) VALUES ($1)"))
+
+	// other tables are not qualified
+	for _, other := range []string{
+		"DELETE FROM dvo_report WHERE cluster_id = $1",
+		"DELETE FROM reported WHERE cluster = $1",
+		"SELECT relname FROM pg_stat_user_tables",
+	} {
+		assert.Equal(t, other, cleaner.QualifyTableNames(other))
+	}
+
+	// size snapshot is read from the same schema
+	databaseSchema, err := cleaner.DatabaseSchemaForSchema(cleaner.DBSchemaOCPRecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, "aggregator", databaseSchema)
+
+	databaseSchema, err = cleaner.DatabaseSchemaForSchema(cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, "dvo", databaseSchema)
+}
+
+// TestPerformCleanupInDBWithOCPTableSchema checks that records are deleted
+// from OCP tables stored in non-default schema
+func TestPerformCleanupInDBWithOCPTableSchema(t *testing.T) {
+	registerOCPTableSchema(t, "aggregator")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM aggregator\\.rule_hit").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM aggregator\\.report").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM aggregator\\.consumer_error").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM aggregator\\.recommendation").WithArgs("90 days").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectClose()
+
+	deletions, err := cleaner.PerformCleanupAllInDB(connection, "90 days", cleaner.DBSchemaOCPRecommendations, false)
+	assert.NoError(t, err, "error not expected while calling tested function")
+	assert.Equal(t, map[string]int{"rule_hit": 1, "report": 2, "consumer_error": 3, "recommendation": 4}, deletions)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/session_settings.html
+
+// This source file contains functions that handle PostgreSQL session
+// settings (GUCs) configured in session_settings section of configuration
+// file. Settings from the default table are applied for all operations and
+// they can be overridden by settings configured for the selected operation,
+// so for example VACUUM can use more maintenance memory than tiny lookups.
+// Settings are passed as run-time parameters of the connection, in the same
+// way as PGOPTIONS environment variable does it for libpq clients, so they
+// are set for every connection opened by the connection pool.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sessionSettingNamePattern is a regular expression used to check names of
+// session settings, custom settings containing dot are allowed too
+var sessionSettingNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// reservedSessionSettings contains session settings that are set by the
+// cleaner itself and that can not be overridden by session_settings
+var reservedSessionSettings = StringSet{
+	"application_name":              {},
+	"search_path":                   {},
+	"default_transaction_read_only": {},
+}
+
+// sessionSettings contains session settings applied to connections opened
+// for the selected operation
+var sessionSettings map[string]string
+
+// checkSessionSettings function checks names and values of session
+// settings read from given section of configuration file
+func checkSessionSettings(section string, settings map[string]string) error {
+	for name, value := range settings {
+		if !sessionSettingNamePattern.MatchString(name) {
+			return invalidConfiguration(section,
+				fmt.Errorf("Incorrect session setting name found in configuration: %s", name))
+		}
+		if _, reserved := reservedSessionSettings[name]; reserved {
+			return invalidConfiguration(section,
+				fmt.Errorf("Session setting %s is set by the cleaner and can not be configured", name))
+		}
+		if value == "" {
+			return invalidConfiguration(section,
+				fmt.Errorf("Empty value of session setting %s found in configuration", name))
+		}
+	}
+	return nil
+}
+
+// checkSessionSettingsConfiguration function checks all session settings
+// configured for all operations
+func checkSessionSettingsConfiguration(sessionSettingsCfg SessionSettingsConfiguration) error {
+	err := checkSessionSettings("session_settings.default", sessionSettingsCfg.Default)
+	if err != nil {
+		return err
+	}
+	for operation, settings := range sessionSettingsCfg.Operations {
+		err := checkSessionSettings("session_settings.operations."+operation, settings)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionSettingsForOperation function returns session settings that need
+// to be applied for given operation. Settings configured for the operation
+// take precedence over default ones.
+func sessionSettingsForOperation(sessionSettingsCfg SessionSettingsConfiguration, operation string) map[string]string {
+	settings := make(map[string]string)
+	for name, value := range sessionSettingsCfg.Default {
+		settings[name] = value
+	}
+	for name, value := range sessionSettingsCfg.Operations[operation] {
+		settings[name] = value
+	}
+	return settings
+}
+
+// registerSessionSettings function registers session settings that are
+// applied to all connections opened for given operation
+func registerSessionSettings(sessionSettingsCfg SessionSettingsConfiguration, operation string) {
+	sessionSettings = sessionSettingsForOperation(sessionSettingsCfg, operation)
+	if len(sessionSettings) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(sessionSettings))
+	for name := range sessionSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// values are not secret, so they are logged together with names
+	applied := make([]string, len(names))
+	for i, name := range names {
+		applied[i] = name + "=" + sessionSettings[name]
+	}
+	log.Debug().
+		Str(operationAttribute, operation).
+		Strs("settings", applied).
+		Msg("Session settings")
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/session_settings_test.html
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// registerSessionSettings function registers session settings for given
+// operation and unregisters them when the test finishes
+func registerSessionSettings(t *testing.T, sessionSettingsCfg cleaner.SessionSettingsConfiguration, operation string) {
+	cleaner.RegisterSessionSettings(sessionSettingsCfg, operation)
+	t.Cleanup(func() {
+		cleaner.RegisterSessionSettings(cleaner.SessionSettingsConfiguration{}, operation)
+	})
+}
+
+// sessionSettingsConfiguration function returns session settings used by
+// tests
+func sessionSettingsConfiguration() cleaner.SessionSettingsConfiguration {
+	return cleaner.SessionSettingsConfiguration{
+		Default: map[string]string{
+			"work_mem":        "4MB",
+			"temp_file_limit": "1GB",
+		},
+		Operations: map[string]map[string]string{
+			"vacuum": {
+				"maintenance_work_mem": "1GB",
+			},
+			"cleanup-all": {
+				"work_mem": "64MB",
+			},
+		},
+	}
+}
+
+// TestSessionSettingsForOperation checks that settings configured for
+// operation take precedence over default ones
+func TestSessionSettingsForOperation(t *testing.T) {
+	sessionSettingsCfg := sessionSettingsConfiguration()
+
+	assert.Equal(t, map[string]string{
+		"work_mem":             "4MB",
+		"temp_file_limit":      "1GB",
+		"maintenance_work_mem": "1GB",
+	}, cleaner.SessionSettingsForOperation(sessionSettingsCfg, "vacuum"))
+
+	assert.Equal(t, map[string]string{
+		"work_mem":        "64MB",
+		"temp_file_limit": "1GB",
+	}, cleaner.SessionSettingsForOperation(sessionSettingsCfg, "cleanup-all"))
+
+	// just default settings are applied for other operations
+	assert.Equal(t, map[string]string{
+		"work_mem":        "4MB",
+		"temp_file_limit": "1GB",
+	}, cleaner.SessionSettingsForOperation(sessionSettingsCfg, "list"))
+
+	// nothing is applied when session settings are not configured
+	assert.Empty(t, cleaner.SessionSettingsForOperation(cleaner.SessionSettingsConfiguration{}, "vacuum"))
+}
+
+// TestCheckConfigurationSessionSettings checks validation of session
+// settings
+func TestCheckConfigurationSessionSettings(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Session: sessionSettingsConfiguration(),
+	}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	// custom settings are allowed
+	configuration.Session.Default["pg_stat_statements.track"] = "none"
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	// improper name
+	configuration.Session.Operations["vacuum"]["work_mem; DROP TABLE report"] = "1MB"
+	err := cleaner.CheckConfiguration(&configuration)
+	assert.ErrorContains(t, err, "session_settings.operations.vacuum")
+	delete(configuration.Session.Operations["vacuum"], "work_mem; DROP TABLE report")
+
+	// settings set by the cleaner itself can not be configured
+	configuration.Session.Default["search_path"] = "public"
+	err = cleaner.CheckConfiguration(&configuration)
+	assert.ErrorContains(t, err, "session_settings.default")
+	delete(configuration.Session.Default, "search_path")
+
+	// empty value
+	configuration.Session.Default["work_mem"] = ""
+	err = cleaner.CheckConfiguration(&configuration)
+	assert.ErrorContains(t, err, "session_settings.default")
+}
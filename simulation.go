@@ -0,0 +1,296 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/simulation.html
+
+// This source file contains implementation of cleanup-all simulation against
+// snapshot stored in scratch schema. It is intended for the first cleanup of
+// quite old databases, where it is not clear how many records will be deleted
+// and whether foreign keys will allow to delete them.
+//
+// Records selected by delete statements (ie. candidate rows) are copied into
+// tables with the same names created in scratch schema by CREATE TABLE ... AS
+// SELECT. Primary keys, unique constraints, and foreign keys between cleaned
+// tables are copied too. Then the same delete statements are performed
+// against the scratch tables and it is verified that all candidate rows have
+// been deleted. Everything is done in one transaction that is always rolled
+// back, so the scratch schema is never left in database.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// selectTableConstraints is used to read primary keys, unique constraints,
+// and foreign keys between cleaned tables. Keys are read first, because
+// foreign keys can not be created without them.
+const selectTableConstraints = `
+	SELECT src.relname, c.conname, pg_get_constraintdef(c.oid)
+	  FROM pg_constraint c
+	  JOIN pg_class src ON src.oid = c.conrelid
+	  LEFT JOIN pg_class dst ON dst.oid = c.confrelid
+	 WHERE c.contype IN ('p', 'u', 'f')
+	   AND src.relnamespace = to_regnamespace(COALESCE(NULLIF($1, ''), current_schema()))
+	   AND src.relname = ANY($2)
+	   AND (c.contype <> 'f' OR (dst.relnamespace = src.relnamespace AND dst.relname = ANY($2)))
+	 ORDER BY c.contype = 'f', src.relname, c.conname`
+
+// ErrSimulationFailed is returned when cleanup simulated in scratch schema
+// did not delete all candidate rows
+var ErrSimulationFailed = errors.New("cleanup simulation did not delete all candidate rows")
+
+// deleteFromTable matches the beginning of delete statement, it is used to
+// convert delete statement into query selecting candidate rows
+var deleteFromTable = regexp.MustCompile(`(?i)\bDELETE\s+FROM\s+([\w.]+)`)
+
+// SimulationResult represents result of cleanup simulated for one table
+type SimulationResult struct {
+	Table     string
+	Copied    int
+	Deleted   int
+	Remaining int
+}
+
+// Verified method checks if all candidate rows have been deleted from the
+// table. Rows can be deleted by foreign keys with ON DELETE CASCADE as well,
+// so number of deleted rows can be lower than number of copied rows.
+func (result SimulationResult) Verified() bool {
+	return result.Remaining == 0
+}
+
+// tableConstraint represents constraint copied into scratch table
+type tableConstraint struct {
+	table      string
+	name       string
+	definition string
+}
+
+// scratchTableReferences function returns regular expression matching
+// references to given tables in SQL statements
+func scratchTableReferences(tables []string) *regexp.Regexp {
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = regexp.QuoteMeta(table)
+	}
+	alternatives := strings.Join(quoted, "|")
+	return regexp.MustCompile(`(?i)\b(FROM|JOIN|INTO|UPDATE|REFERENCES)(\s+)(?:\w+\.)?(` + alternatives + `)\b`)
+}
+
+// scratchStatement function qualifies references to given tables by name of
+// scratch schema
+func scratchStatement(statement, scratchSchema string, references *regexp.Regexp) string {
+	return references.ReplaceAllString(statement, "${1}${2}"+scratchSchema+".${3}")
+}
+
+// candidateRowsQuery function converts delete statement into query that
+// selects all rows that would be deleted by the statement
+func candidateRowsQuery(deleteStatement string) string {
+	return deleteFromTable.ReplaceAllString(deleteStatement, "SELECT ${1}.* FROM ${1}")
+}
+
+// liveTableSchema function returns name of schema where tables from given DB
+// schema are stored, empty string means the current schema
+func liveTableSchema(schema string) string {
+	if schema == DBSchemaOCPRecommendations {
+		return ocpTableSchema
+	}
+	if pluginSchema, found := pluginSchemas[schema]; found {
+		return pluginSchema.DatabaseSchema
+	}
+	return ""
+}
+
+// readTableConstraints function reads keys and foreign keys between given
+// tables
+func readTableConstraints(connection *sql.DB, liveSchema string, tables []string) ([]tableConstraint, error) {
+	constraints := []tableConstraint{}
+
+	args := []interface{}{liveSchema, pq.Array(tables)}
+	err := queryRows(connection, selectTableConstraints, args, func(rows *sql.Rows) error {
+		var constraint tableConstraint
+		if err := rows.Scan(&constraint.table, &constraint.name, &constraint.definition); err != nil {
+			return err
+		}
+		constraints = append(constraints, constraint)
+		return nil
+	})
+	if err != nil {
+		return constraints, queryFailed("pg_constraint", err)
+	}
+	return constraints, nil
+}
+
+// simulateCleanupAllInDB function copies candidate rows of cleanup-all into
+// scratch schema, performs cleanup-all against them, and checks that all
+// candidate rows have been deleted. Nothing is changed in database, because
+// the simulation is always rolled back.
+func simulateCleanupAllInDB(connection *sql.DB, maxAge, schema, scratchSchema string) (
+	[]SimulationResult, error) {
+	results := []SimulationResult{}
+
+	if maxAge == "" {
+		return results, errors.New(maxAgeMissing)
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return results, ErrNoConnection
+	}
+
+	// scratch schema name is used to construct SQL statements
+	liveSchema := liveTableSchema(schema)
+	if !schemaNamePattern.MatchString(scratchSchema) || scratchSchema == liveSchema {
+		return results, fmt.Errorf("Incorrect scratch schema: %s", scratchSchema)
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return results, err
+	}
+	tables := make([]string, len(tablesToDelete))
+	for i, tableAndDeleteStatement := range tablesToDelete {
+		tables[i] = tableAndDeleteStatement.TableName
+	}
+	references := scratchTableReferences(tables)
+
+	constraints, err := readTableConstraints(connection, liveSchema, tables)
+	if err != nil {
+		return results, err
+	}
+
+	log.Info().
+		Str("scratch schema", scratchSchema).
+		Str("Max age", maxAge).
+		Msg("Cleanup simulation started")
+
+	tx, err := connection.Begin()
+	if err != nil {
+		return results, err
+	}
+	// the simulation never leaves scratch schema in database
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+		}
+	}()
+
+	_, err = execStatement(tx, "CREATE SCHEMA "+scratchSchema)
+	if err != nil {
+		return results, err
+	}
+
+	// copy candidate rows into scratch tables
+	for _, tableAndDeleteStatement := range tablesToDelete {
+		table := tableAndDeleteStatement.TableName
+		_, err = execStatement(tx,
+			"CREATE TABLE "+scratchSchema+"."+table+" AS SELECT * FROM "+table+" WITH NO DATA")
+		if err != nil {
+			return results, queryFailed(table, err)
+		}
+
+		copyStatement := "INSERT INTO " + scratchSchema + "." + table + " " +
+			candidateRowsQuery(tableAndDeleteStatement.DeleteStatement)
+		result, err := execStatement(tx, copyStatement, maxAge)
+		if err != nil {
+			return results, queryFailed(table, err)
+		}
+		copied, err := result.RowsAffected()
+		if err != nil {
+			return results, err
+		}
+		results = append(results, SimulationResult{Table: table, Copied: int(copied)})
+	}
+
+	// keys and foreign keys are needed to verify behaviour of constraints
+	for _, constraint := range constraints {
+		statement := fmt.Sprintf("ALTER TABLE %s.%s ADD CONSTRAINT %s %s", scratchSchema,
+			constraint.table, pq.QuoteIdentifier(constraint.name),
+			scratchStatement(constraint.definition, scratchSchema, references))
+		_, err = execStatement(tx, statement)
+		if err != nil {
+			return results, queryFailed(constraint.table, err)
+		}
+	}
+
+	// perform cleanup-all against scratch tables
+	for i, tableAndDeleteStatement := range tablesToDelete {
+		statement := scratchStatement(tableAndDeleteStatement.DeleteStatement, scratchSchema, references)
+		result, err := execStatement(tx, statement, maxAge)
+		if err != nil {
+			return results, queryFailed(tableAndDeleteStatement.TableName, err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return results, err
+		}
+		results[i].Deleted = int(deleted)
+	}
+
+	// and check that all candidate rows have been deleted
+	var errs []error
+	for i := range results {
+		row := queryRowStatement(tx, "SELECT COUNT(*) FROM "+scratchSchema+"."+results[i].Table)
+		if err := row.Scan(&results[i].Remaining); err != nil {
+			return results, queryFailed(results[i].Table, err)
+		}
+		log.Info().
+			Str(tableName, results[i].Table).
+			Int("copied", results[i].Copied).
+			Int("deleted", results[i].Deleted).
+			Int("remaining", results[i].Remaining).
+			Msg("Cleanup simulated")
+		if !results[i].Verified() {
+			errs = append(errs, fmt.Errorf("%w: %d rows remained in table %s",
+				ErrSimulationFailed, results[i].Remaining, results[i].Table))
+		}
+	}
+
+	log.Info().Msg("Cleanup simulation finished")
+	return results, errors.Join(errs...)
+}
+
+// PrintSimulationResults function displays a table with number of rows
+// copied into scratch schema, deleted from it, and remaining there
+func PrintSimulationResults(results []SimulationResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Table", "Copied", "Deleted", "Remaining", "Verified"})
+
+	for _, result := range results {
+		table.Append([]string{result.Table,
+			strconv.Itoa(result.Copied),
+			strconv.Itoa(result.Deleted),
+			strconv.Itoa(result.Remaining),
+			strconv.FormatBool(result.Verified())})
+	}
+
+	// display the whole table
+	table.Render()
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/simulation_test.html
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// constraintColumns contains columns returned by query reading constraints
+var constraintColumns = []string{"relname", "conname", "pg_get_constraintdef"}
+
+// TestCandidateRowsQuery checks conversion of delete statement into query
+// selecting candidate rows
+func TestCandidateRowsQuery(t *testing.T) {
+	assert.Equal(t,
+		"SELECT dvo_report.* FROM dvo_report WHERE last_checked_at < NOW() - $1::INTERVAL",
+		cleaner.CandidateRowsQuery("DELETE FROM dvo_report WHERE last_checked_at < NOW() - $1::INTERVAL"))
+	assert.Equal(t,
+		"WITH x AS (SELECT 1) SELECT rule_hit.* FROM rule_hit WHERE EXISTS (SELECT 1 FROM x)",
+		cleaner.CandidateRowsQuery("WITH x AS (SELECT 1) delete from rule_hit WHERE EXISTS (SELECT 1 FROM x)"))
+}
+
+// TestScratchStatement checks that references to cleaned tables are
+// qualified by name of scratch schema
+func TestScratchStatement(t *testing.T) {
+	references := cleaner.ScratchTableReferences([]string{"rule_hit", "report"})
+
+	assert.Equal(t,
+		"DELETE FROM scratch.rule_hit LEFT JOIN scratch.report ON rule_hit.x = report.y, report_info",
+		cleaner.ScratchStatement("DELETE FROM rule_hit LEFT JOIN report ON rule_hit.x = report.y, report_info",
+			"scratch", references))
+
+	// references in constraint definitions can be qualified by live schema
+	assert.Equal(t,
+		"FOREIGN KEY (cluster_id) REFERENCES scratch.report(cluster) ON DELETE CASCADE",
+		cleaner.ScratchStatement("FOREIGN KEY (cluster_id) REFERENCES ocp.report(cluster) ON DELETE CASCADE",
+			"scratch", references))
+}
+
+// expectSimulationCopy function registers expected statements that copy
+// candidate DVO reports into scratch schema
+func expectSimulationCopy(mock sqlmock.Sqlmock, copied int64) {
+	mock.ExpectQuery("SELECT src.relname, c.conname, pg_get_constraintdef").
+		WillReturnRows(sqlmock.NewRows(constraintColumns).
+			AddRow("dvo_report", "dvo_report_pkey", "PRIMARY KEY (org_id, cluster_id, namespace_id)"))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE SCHEMA scratch").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE scratch.dvo_report AS SELECT \\* FROM dvo_report WITH NO DATA").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO scratch.dvo_report\\s+SELECT dvo_report.\\* FROM dvo_report").
+		WithArgs("90 days").
+		WillReturnResult(sqlmock.NewResult(0, copied))
+	mock.ExpectExec(`ALTER TABLE scratch.dvo_report ADD CONSTRAINT "dvo_report_pkey" PRIMARY KEY`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// TestSimulateCleanupAllInDB checks that cleanup is simulated against copies
+// of candidate rows and that the simulation is rolled back
+func TestSimulateCleanupAllInDB(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectSimulationCopy(mock, 5)
+	mock.ExpectExec("DELETE FROM scratch.dvo_report").
+		WithArgs("90 days").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scratch.dvo_report").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	results, err := cleaner.SimulateCleanupAllInDB(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, "scratch")
+	assert.NoError(t, err)
+	assert.Equal(t, []cleaner.SimulationResult{
+		{Table: "dvo_report", Copied: 5, Deleted: 5, Remaining: 0},
+	}, results)
+	assert.True(t, results[0].Verified())
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestSimulateCleanupAllInDBNotVerified checks that simulation fails when
+// some candidate rows were not deleted
+func TestSimulateCleanupAllInDBNotVerified(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectSimulationCopy(mock, 5)
+	mock.ExpectExec("DELETE FROM scratch.dvo_report").
+		WithArgs("90 days").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scratch.dvo_report").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	results, err := cleaner.SimulateCleanupAllInDB(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, "scratch")
+	assert.ErrorIs(t, err, cleaner.ErrSimulationFailed)
+	assert.False(t, results[0].Verified())
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestSimulateCleanupAllInDBOnError checks that simulation is rolled back
+// when deletion from scratch table fails
+func TestSimulateCleanupAllInDBOnError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectSimulationCopy(mock, 5)
+	mock.ExpectExec("DELETE FROM scratch.dvo_report").
+		WithArgs("90 days").
+		WillReturnError(errors.New("foreign key violation"))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	_, err = cleaner.SimulateCleanupAllInDB(connection, "90 days",
+		cleaner.DBSchemaDVORecommendations, "scratch")
+	var queryErr *cleaner.ErrQueryFailed
+	assert.True(t, errors.As(err, &queryErr))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestSimulateCleanupAllInDBImproperInput checks that nothing is performed
+// for improper scratch schema, schema, or max age
+func TestSimulateCleanupAllInDBImproperInput(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	for _, scratchSchema := range []string{"", "scratch; DROP TABLE report", "1scratch"} {
+		_, err = cleaner.SimulateCleanupAllInDB(connection, "90 days",
+			cleaner.DBSchemaDVORecommendations, scratchSchema)
+		assert.Error(t, err, scratchSchema)
+	}
+
+	_, err = cleaner.SimulateCleanupAllInDB(connection, "",
+		cleaner.DBSchemaDVORecommendations, "scratch")
+	assert.Error(t, err)
+
+	var schemaErr *cleaner.ErrInvalidSchema
+	_, err = cleaner.SimulateCleanupAllInDB(connection, "90 days", "unknown", "scratch")
+	assert.True(t, errors.As(err, &schemaErr))
+
+	_, err = cleaner.SimulateCleanupAllInDB(nil, "90 days",
+		cleaner.DBSchemaDVORecommendations, "scratch")
+	assert.ErrorIs(t, err, cleaner.ErrNoConnection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupAllSimulationFailed checks that live tables are not cleaned up
+// when the simulation fails
+func TestCleanupAllSimulationFailed(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectSimulationCopy(mock, 5)
+	mock.ExpectExec("DELETE FROM scratch.dvo_report").
+		WithArgs("90 days").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scratch.dvo_report").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{MaxAge: "90 days"},
+	}
+	cliFlags := cleaner.CliFlags{
+		PerformCleanupAll: true,
+		SimulateInSchema:  "scratch",
+		DryRun:            false,
+		MaxAge:            "90 days",
+		ConfirmMaxAge:     "90 days",
+	}
+	status, err := cleaner.CleanupAll(&configuration, connection, cliFlags, cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrSimulationFailed)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
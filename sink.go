@@ -0,0 +1,527 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/sink.html
+
+// This source file contains implementation of output sinks. Records exported
+// by listing operations are written through OutputSink interface, so the
+// listing itself does not need to know where and in which format the records
+// are exported. The following sinks are available:
+//
+// CSV sink writes records into file (or standard output) in CSV format
+// JSON sink writes records into file (or standard output) as JSON lines
+// log sink writes each record into log as one structured event
+// S3 sink writes records in CSV or JSON format into S3 bucket
+//
+// Records are exported into S3 bucket when output name starts with s3://
+// prefix, for example s3://bucket/path/old_reports.csv
+//
+// Exported fields can be selected and reordered by list of columns, for
+// example cluster,org_id,age. The selection is applied to all sinks.
+//
+// Cluster IDs can be replaced by their salted SHA-256 hashes, so the exported
+// listings can be shared outside the team. Raw cluster IDs are still written
+// into logs.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/rs/zerolog/log"
+)
+
+// Output formats that can be selected in configuration or from command line
+const (
+	OutputFormatCSV  = "csv"
+	OutputFormatJSON = "json"
+	OutputFormatLog  = "log"
+)
+
+// Constants used by output sinks
+const (
+	s3OutputPrefix     = "s3://"
+	exportedRecordMsg  = "Exported record"
+	uploadToS3Msg      = "Upload to S3"
+	closeOutputSinkMsg = "Close output sink"
+	bucketAttribute    = "bucket"
+	keyAttribute       = "key"
+	clusterField       = "cluster"
+)
+
+// OutputField represents one named field of exported record
+type OutputField struct {
+	Name  string
+	Value interface{}
+}
+
+// OutputRecord represents one exported record. Fields are exported in the
+// same order as they are stored in the record.
+type OutputRecord []OutputField
+
+// OutputSink is an interface implemented by all destinations where records
+// exported by listing operations can be written to. Close method commits the
+// export when success is true, otherwise the partially written export is
+// thrown away.
+type OutputSink interface {
+	WriteRecord(record OutputRecord) error
+	Flush() error
+	Close(success bool) error
+}
+
+// discardSink is used when records should not be exported
+type discardSink struct{}
+
+// WriteRecord method does nothing
+func (discardSink) WriteRecord(OutputRecord) error {
+	return nil
+}
+
+// Flush method does nothing
+func (discardSink) Flush() error {
+	return nil
+}
+
+// Close method does nothing
+func (discardSink) Close(bool) error {
+	return nil
+}
+
+// lockedSink serializes writes into the underlying sink, so it can be shared
+// by listings performed concurrently
+type lockedSink struct {
+	OutputSink
+	mutex sync.Mutex
+}
+
+// WriteRecord method writes one record into the underlying sink
+func (sink *lockedSink) WriteRecord(record OutputRecord) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.OutputSink.WriteRecord(record)
+}
+
+// Flush method flushes the underlying sink
+func (sink *lockedSink) Flush() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.OutputSink.Flush()
+}
+
+// csvSink writes records into output file in CSV format
+type csvSink struct {
+	out    *outputFile
+	writer *csv.Writer
+}
+
+// WriteRecord method writes one record as one CSV line
+func (sink *csvSink) WriteRecord(record OutputRecord) error {
+	values := make([]string, len(record))
+	for i, field := range record {
+		values[i] = fmt.Sprint(field.Value)
+	}
+	return sink.writer.Write(values)
+}
+
+// Flush method flushes all buffered records into output file
+func (sink *csvSink) Flush() error {
+	sink.writer.Flush()
+	return sink.writer.Error()
+}
+
+// Close method flushes all buffered records and closes output file
+func (sink *csvSink) Close(success bool) error {
+	if err := sink.Flush(); err != nil {
+		log.Error().Err(err).Msg(flushWriterMsg)
+		success = false
+	}
+	return sink.out.Close(success)
+}
+
+// jsonSink writes records into output file as JSON lines, ie. one JSON
+// object per line
+type jsonSink struct {
+	out *outputFile
+}
+
+// WriteRecord method writes one record as one JSON object. Order of fields
+// is preserved.
+func (sink *jsonSink) WriteRecord(record OutputRecord) error {
+	var buffer bytes.Buffer
+
+	buffer.WriteByte('{')
+	for i, field := range record {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return err
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteString("}\n")
+
+	_, err := sink.out.Writer().Write(buffer.Bytes())
+	return err
+}
+
+// Flush method flushes all buffered records into output file
+func (sink *jsonSink) Flush() error {
+	return sink.out.Writer().Flush()
+}
+
+// Close method flushes all buffered records and closes output file
+func (sink *jsonSink) Close(success bool) error {
+	return sink.out.Close(success)
+}
+
+// logSink writes each record into log as one structured event
+type logSink struct{}
+
+// WriteRecord method writes one record into log
+func (logSink) WriteRecord(record OutputRecord) error {
+	event := log.Info()
+	for _, field := range record {
+		event = event.Interface(field.Name, field.Value)
+	}
+	event.Msg(exportedRecordMsg)
+	return nil
+}
+
+// Flush method does nothing, log events are not buffered
+func (logSink) Flush() error {
+	return nil
+}
+
+// Close method does nothing
+func (logSink) Close(bool) error {
+	return nil
+}
+
+// columnsSink selects and reorders fields of exported records before they
+// are written into the underlying sink
+type columnsSink struct {
+	OutputSink
+	columns []string
+}
+
+// WriteRecord method writes only selected fields of the record, in the same
+// order as columns were specified
+func (sink *columnsSink) WriteRecord(record OutputRecord) error {
+	selected := make(OutputRecord, len(sink.columns))
+	for i, column := range sink.columns {
+		field, found := findOutputField(record, column)
+		if !found {
+			return fmt.Errorf("Column %s is not available in exported record", column)
+		}
+		selected[i] = field
+	}
+	return sink.OutputSink.WriteRecord(selected)
+}
+
+// findOutputField function finds field with given name in exported record
+func findOutputField(record OutputRecord, name string) (OutputField, bool) {
+	for _, field := range record {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return OutputField{}, false
+}
+
+// parseOutputColumns function parses comma separated list of columns
+func parseOutputColumns(columns string) []string {
+	parsed := []string{}
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if column != "" {
+			parsed = append(parsed, column)
+		}
+	}
+	return parsed
+}
+
+// hashingSink replaces cluster IDs in exported records by their salted
+// SHA-256 hashes before the records are written into the underlying sink
+type hashingSink struct {
+	OutputSink
+	salt string
+}
+
+// WriteRecord method writes record with hashed cluster ID
+func (sink *hashingSink) WriteRecord(record OutputRecord) error {
+	hashed := make(OutputRecord, len(record))
+	for i, field := range record {
+		if field.Name == clusterField {
+			field.Value = hashClusterID(sink.salt, fmt.Sprint(field.Value))
+		}
+		hashed[i] = field
+	}
+	return sink.OutputSink.WriteRecord(hashed)
+}
+
+// hashClusterID function computes salted SHA-256 hash of cluster ID
+func hashClusterID(salt, clusterID string) string {
+	hash := sha256.Sum256([]byte(salt + clusterID))
+	return hex.EncodeToString(hash[:])
+}
+
+// s3Sink writes records into local temporary file first. The file is
+// uploaded into S3 bucket when the export has been finished successfully.
+type s3Sink struct {
+	OutputSink
+	directory string
+	localFile string
+	bucket    string
+	key       string
+	s3Config  S3Configuration
+}
+
+// Close method closes local file and uploads it into S3 bucket
+func (sink *s3Sink) Close(success bool) error {
+	// local file is not needed after upload
+	defer func() {
+		if err := os.RemoveAll(sink.directory); err != nil {
+			log.Error().Err(err).Msg(removeFileMsg)
+		}
+	}()
+
+	err := sink.OutputSink.Close(success)
+	if err != nil || !success {
+		return err
+	}
+
+	err = uploadToS3(sink.s3Config, sink.localFile, sink.bucket, sink.key)
+	if err != nil {
+		log.Error().Err(err).
+			Str(bucketAttribute, sink.bucket).
+			Str(keyAttribute, sink.key).
+			Msg(uploadToS3Msg)
+		return err
+	}
+
+	log.Info().
+		Str(bucketAttribute, sink.bucket).
+		Str(keyAttribute, sink.key).
+		Msg("Records uploaded to S3")
+	return nil
+}
+
+// isS3Output function checks if records should be exported into S3 bucket
+func isS3Output(output string) bool {
+	return strings.HasPrefix(output, s3OutputPrefix)
+}
+
+// parseS3Output function splits output name like s3://bucket/path/file.csv
+// into bucket name and object key
+func parseS3Output(output string) (string, string, error) {
+	location := strings.TrimPrefix(output, s3OutputPrefix)
+	bucket, key, found := strings.Cut(location, "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("S3 output must be specified as s3://bucket/key: %s", output)
+	}
+	return bucket, key, nil
+}
+
+// checkOutputFormat function checks if given output format is supported.
+// Empty format means CSV.
+func checkOutputFormat(format string) error {
+	switch format {
+	case "", OutputFormatCSV, OutputFormatJSON, OutputFormatLog:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported output format: %s", format)
+	}
+}
+
+// createOutputSink function creates sink for given output name and format
+// specified in output configuration. Records are not exported when no output
+// has been requested, except for log format that does not need any output
+// file. Cluster IDs are hashed and exported fields are selected by columns
+// when requested in output configuration.
+func createOutputSink(output string, outputConfig OutputConfiguration) (OutputSink, error) {
+	err := checkOutputFormat(outputConfig.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink OutputSink
+	switch {
+	case outputConfig.Format == OutputFormatLog:
+		sink = logSink{}
+	case output == "":
+		return discardSink{}, nil
+	case isS3Output(output):
+		sink, err = createS3Sink(output, outputConfig)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		sink, err = createFileSink(output, outputConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if outputConfig.HashClusterIDs {
+		sink = &hashingSink{
+			OutputSink: sink,
+			salt:       outputConfig.ClusterIDSalt,
+		}
+	}
+
+	columns := parseOutputColumns(outputConfig.Columns)
+	if len(columns) == 0 {
+		return sink, nil
+	}
+	return &columnsSink{
+		OutputSink: sink,
+		columns:    columns,
+	}, nil
+}
+
+// createFileSink function creates sink that writes records into output file
+// (or standard output) in format selected in output configuration
+func createFileSink(output string, outputConfig OutputConfiguration) (OutputSink, error) {
+	out, err := createOutputFile(output, outputConfig.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if outputConfig.Format == OutputFormatJSON {
+		return &jsonSink{out: out}, nil
+	}
+	return &csvSink{
+		out:    out,
+		writer: csv.NewWriter(out.Writer()),
+	}, nil
+}
+
+// createS3Sink function creates sink that uploads exported records into S3
+// bucket
+func createS3Sink(output string, outputConfig OutputConfiguration) (OutputSink, error) {
+	bucket, key, err := parseS3Output(output)
+	if err != nil {
+		return nil, err
+	}
+
+	// records are written into local file first
+	directory, err := os.MkdirTemp("", "cleaner-export-")
+	if err != nil {
+		return nil, err
+	}
+	localFile := filepath.Join(directory, path.Base(key))
+
+	// checksum file is not uploaded
+	fileConfig := outputConfig
+	fileConfig.Checksum = false
+
+	fileSink, err := createFileSink(localFile, fileConfig)
+	if err != nil {
+		if err := os.RemoveAll(directory); err != nil {
+			log.Error().Err(err).Msg(removeFileMsg)
+		}
+		return nil, err
+	}
+
+	return &s3Sink{
+		OutputSink: fileSink,
+		directory:  directory,
+		localFile:  localFile,
+		bucket:     bucket,
+		key:        key,
+		s3Config:   outputConfig.S3,
+	}, nil
+}
+
+// newS3Session function creates AWS session used to access S3 bucket
+func newS3Session(s3Config S3Configuration) (*session.Session, error) {
+	awsConfig := aws.NewConfig()
+	if s3Config.Region != "" {
+		awsConfig = awsConfig.WithRegion(s3Config.Region)
+	}
+	// S3 compatible storages (like MinIO) use path-style addressing
+	if s3Config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(s3Config.Endpoint).WithS3ForcePathStyle(true)
+	}
+	// default credential chain is used when no keys are configured
+	if s3Config.AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(
+			s3Config.AccessKeyID, s3Config.SecretAccessKey, ""))
+	}
+
+	return session.NewSession(awsConfig)
+}
+
+// uploadToS3 function uploads given local file into S3 bucket
+func uploadToS3(s3Config S3Configuration, localFile, bucket, key string) error {
+	awsSession, err := newS3Session(s3Config)
+	if err != nil {
+		return err
+	}
+
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(localFile) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msg(fileCloseMsg)
+		}
+	}()
+
+	uploader := s3manager.NewUploader(awsSession)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	})
+	return err
+}
+
+// closeOutputSink function closes given sink. Problems with output are
+// logged and returned, because the listing is not complete without its
+// output.
+func closeOutputSink(sink OutputSink, success bool) error {
+	err := sink.Close(success)
+	if err != nil {
+		log.Error().Err(err).Msg(closeOutputSinkMsg)
+	}
+	return err
+}
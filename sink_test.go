@@ -0,0 +1,294 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/sink_test.html
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// exportThroughSink function creates file sink in temporary directory, calls
+// given function to write records into it, and returns content of the
+// exported file
+func exportThroughSink(t *testing.T, outputConfig cleaner.OutputConfiguration,
+	export func(sink cleaner.OutputSink)) string {
+	filename := filepath.Join(t.TempDir(), "export.txt")
+
+	sink, err := cleaner.CreateOutputSink(filename, outputConfig)
+	assert.NoError(t, err)
+
+	export(sink)
+	assert.NoError(t, sink.Close(true))
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+// testRecord is record used by sink tests
+var testRecord = cleaner.OutputRecord{
+	{Name: "cluster", Value: cluster1ID},
+	{Name: "namespace_name", Value: "name, with comma"},
+	{Name: "objects", Value: 10},
+}
+
+// TestCSVSink checks that records are written in CSV format by default
+func TestCSVSink(t *testing.T) {
+	output := exportThroughSink(t, cleaner.OutputConfiguration{}, func(sink cleaner.OutputSink) {
+		assert.NoError(t, sink.WriteRecord(testRecord))
+		assert.NoError(t, sink.Flush())
+		assert.NoError(t, sink.WriteRecord(testRecord))
+	})
+
+	expected := cluster1ID + ",\"name, with comma\",10\n"
+	assert.Equal(t, expected+expected, output)
+}
+
+// TestJSONSink checks that records are written as JSON lines with fields in
+// the same order as in the record
+func TestJSONSink(t *testing.T) {
+	outputConfig := cleaner.OutputConfiguration{Format: cleaner.OutputFormatJSON}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		assert.NoError(t, sink.WriteRecord(testRecord))
+		assert.NoError(t, sink.WriteRecord(testRecord[:1]))
+	})
+
+	assert.Equal(t,
+		`{"cluster":"`+cluster1ID+`","namespace_name":"name, with comma","objects":10}`+"\n"+
+			`{"cluster":"`+cluster1ID+`"}`+"\n", output)
+}
+
+// TestSinkNotCommitted checks that output file is not created when the
+// export was not finished successfully
+func TestSinkNotCommitted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.csv")
+
+	sink, err := cleaner.CreateOutputSink(filename, cleaner.OutputConfiguration{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.WriteRecord(testRecord))
+	assert.NoError(t, sink.Close(false))
+
+	assert.NoFileExists(t, filename)
+}
+
+// TestLogSink checks that records are written into log when log format is
+// selected, even when no output file is specified
+func TestLogSink(t *testing.T) {
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		sink, err := cleaner.CreateOutputSink("", cleaner.OutputConfiguration{Format: cleaner.OutputFormatLog})
+		assert.NoError(t, err)
+		assert.NoError(t, sink.WriteRecord(testRecord))
+		assert.NoError(t, sink.Close(true))
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Exported record")
+	assert.Contains(t, output, cluster1ID)
+	assert.Contains(t, output, "name, with comma")
+}
+
+// TestCreateOutputSinkNoOutput checks that records are not exported when no
+// output is specified
+func TestCreateOutputSinkNoOutput(t *testing.T) {
+	sink, err := cleaner.CreateOutputSink("", cleaner.OutputConfiguration{})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.DiscardSink, sink)
+
+	assert.NoError(t, sink.WriteRecord(testRecord))
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Close(true))
+}
+
+// TestCreateOutputSinkUnsupportedFormat checks that unsupported output
+// format is refused
+func TestCreateOutputSinkUnsupportedFormat(t *testing.T) {
+	_, err := cleaner.CreateOutputSink("export.xml", cleaner.OutputConfiguration{Format: "xml"})
+	assert.Error(t, err)
+	assert.NoFileExists(t, "export.xml")
+}
+
+// TestParseS3Output checks parsing of S3 output names
+func TestParseS3Output(t *testing.T) {
+	bucket, key, err := cleaner.ParseS3Output("s3://bucket/path/old_reports.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "path/old_reports.csv", key)
+
+	for _, output := range []string{"s3://", "s3://bucket", "s3://bucket/", "s3:///key"} {
+		_, _, err := cleaner.ParseS3Output(output)
+		assert.Error(t, err, output)
+	}
+}
+
+// TestS3Sink checks that exported records are uploaded into S3 bucket
+func TestS3Sink(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		path     string
+		uploaded string
+	)
+
+	// mocked S3 compatible storage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		path = r.URL.Path
+		uploaded = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outputConfig := cleaner.OutputConfiguration{
+		S3: cleaner.S3Configuration{
+			Endpoint:        server.URL,
+			Region:          "us-east-1",
+			AccessKeyID:     "access-key",
+			SecretAccessKey: "secret-key",
+		},
+	}
+
+	sink, err := cleaner.CreateOutputSink("s3://bucket/exports/old_reports.csv", outputConfig)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteRecord(testRecord))
+	assert.NoError(t, sink.Close(true))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, "/bucket/exports/old_reports.csv", path)
+	assert.Equal(t, cluster1ID+",\"name, with comma\",10\n", uploaded)
+}
+
+// TestS3SinkUploadError checks that error is returned when exported records
+// can not be uploaded
+func TestS3SinkUploadError(t *testing.T) {
+	// mocked S3 compatible storage that refuses all requests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	outputConfig := cleaner.OutputConfiguration{
+		S3: cleaner.S3Configuration{
+			Endpoint:        server.URL,
+			Region:          "us-east-1",
+			AccessKeyID:     "access-key",
+			SecretAccessKey: "secret-key",
+		},
+	}
+
+	sink, err := cleaner.CreateOutputSink("s3://bucket/old_reports.csv", outputConfig)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteRecord(testRecord))
+	assert.Error(t, sink.Close(true))
+}
+
+// TestColumnsSink checks that only selected columns are exported in the
+// same order as they were specified
+func TestColumnsSink(t *testing.T) {
+	outputConfig := cleaner.OutputConfiguration{Columns: " objects, cluster,,"}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		assert.NoError(t, sink.WriteRecord(testRecord))
+	})
+
+	assert.Equal(t, "10,"+cluster1ID+"\n", output)
+}
+
+// TestColumnsSinkJSON checks that only selected columns are exported in
+// JSON format
+func TestColumnsSinkJSON(t *testing.T) {
+	outputConfig := cleaner.OutputConfiguration{
+		Format:  cleaner.OutputFormatJSON,
+		Columns: "namespace_name",
+	}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		assert.NoError(t, sink.WriteRecord(testRecord))
+	})
+
+	assert.Equal(t, `{"namespace_name":"name, with comma"}`+"\n", output)
+}
+
+// TestColumnsSinkUnknownColumn checks that error is returned when selected
+// column is not available in exported record
+func TestColumnsSinkUnknownColumn(t *testing.T) {
+	outputConfig := cleaner.OutputConfiguration{Columns: "cluster,org_id"}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		assert.Error(t, sink.WriteRecord(testRecord))
+	})
+
+	assert.Empty(t, output)
+}
+
+// TestHashingSink checks that cluster IDs are replaced by salted SHA-256
+// hashes when requested
+func TestHashingSink(t *testing.T) {
+	hash := sha256.Sum256([]byte("salt" + cluster1ID))
+	expected := hex.EncodeToString(hash[:])
+
+	outputConfig := cleaner.OutputConfiguration{
+		HashClusterIDs: true,
+		ClusterIDSalt:  "salt",
+	}
+	output := exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+		assert.NoError(t, sink.WriteRecord(testRecord))
+	})
+
+	assert.Equal(t, expected+",\"name, with comma\",10\n", output)
+	// original record must not be changed
+	assert.Equal(t, cluster1ID, testRecord[0].Value)
+}
+
+// TestHashingSinkDifferentSalt checks that hashes depend on salt
+func TestHashingSinkDifferentSalt(t *testing.T) {
+	export := func(salt string) string {
+		outputConfig := cleaner.OutputConfiguration{
+			HashClusterIDs: true,
+			ClusterIDSalt:  salt,
+			Columns:        "cluster",
+		}
+		return exportThroughSink(t, outputConfig, func(sink cleaner.OutputSink) {
+			assert.NoError(t, sink.WriteRecord(testRecord))
+		})
+	}
+
+	assert.NotEqual(t, export("salt1"), export("salt2"))
+	assert.Equal(t, export("salt1"), export("salt1"))
+	assert.NotContains(t, export("salt1"), cluster1ID)
+}
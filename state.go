@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/state.html
+
+// This source file contains implementation of export and import of cleaner
+// state, so environment rebuilds do not lose the deletion trail. The state
+// is stored in database tables owned by the cleaner itself. Currently it is
+// the run history table (see history.go); deletion evidence and org batch
+// checkpoints are stored in files and they do not need to be exported.
+//
+// The state is exported as one JSON document. Import is idempotent: runs that
+// are already recorded in history table (with the same run ID, operation,
+// target, and start time) are skipped.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stateVersion is version of exported state document
+const stateVersion = 1
+
+// historyNotConfigured is used when state is exported or imported without
+// history table specified in configuration
+const historyNotConfigured = "history table is not specified in configuration"
+
+// insertMissingHistoryStatement inserts run into history table only when it
+// is not recorded there yet. Name of history table is inserted into the
+// statement as quoted identifier.
+const insertMissingHistoryStatement = `
+	    INSERT INTO %[1]s (run_id, operation, target, started_at, finished_at,
+	                       exit_status, deletions, org_clusters, requested_by)
+	    SELECT $1::VARCHAR, $2::VARCHAR, $3::VARCHAR, $4::TIMESTAMP, $5::TIMESTAMP,
+	           $6::INTEGER, $7::VARCHAR, $8::VARCHAR, $9::VARCHAR
+	     WHERE NOT EXISTS (
+	           SELECT 1
+	             FROM %[1]s
+	            WHERE run_id = $1 AND operation = $2 AND target = $3 AND started_at = $4)`
+
+// CleanerState represents state of the cleaner exported from database
+type CleanerState struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Source     string            `json:"source"`
+	RunHistory []RunHistoryEntry `json:"run_history"`
+}
+
+// readCleanerState function reads state of the cleaner from database
+func readCleanerState(connection *sql.DB, historyTable, source string, now time.Time) (CleanerState, error) {
+	state := CleanerState{
+		Version:    stateVersion,
+		ExportedAt: now.UTC(),
+		Source:     source,
+		RunHistory: []RunHistoryEntry{},
+	}
+
+	if historyTable == "" {
+		return state, errors.New(historyNotConfigured)
+	}
+
+	// all recorded runs are exported
+	entries, err := readRunHistory(connection, historyTable, time.Time{})
+	if err != nil {
+		return state, err
+	}
+	state.RunHistory = append(state.RunHistory, entries...)
+	return state, nil
+}
+
+// writeCleanerState function writes state of the cleaner as JSON document
+func writeCleanerState(writer io.Writer, state CleanerState) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+// readCleanerStateDocument function reads state of the cleaner from JSON
+// document
+func readCleanerStateDocument(reader io.Reader) (CleanerState, error) {
+	var state CleanerState
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&state); err != nil {
+		return state, fmt.Errorf("improper state document: %w", err)
+	}
+	if state.Version != stateVersion {
+		return state, fmt.Errorf("unsupported version of state document: %d", state.Version)
+	}
+	return state, nil
+}
+
+// importRunHistory function inserts runs into history table. Runs that are
+// already recorded are skipped. Number of inserted runs is returned.
+func importRunHistory(connection *sql.DB, historyTable string, entries []RunHistoryEntry) (int, error) {
+	if historyTable == "" {
+		return 0, errors.New(historyNotConfigured)
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return 0, ErrNoConnection
+	}
+
+	// import needs to be refused even when there are no runs to insert
+	if readOnlyMode {
+		return 0, ErrReadOnly
+	}
+
+	inserted := 0
+	statement := historyTableStatement(insertMissingHistoryStatement, historyTable)
+	for _, entry := range entries {
+		deletions, err := json.Marshal(entry.DeletionsForTable)
+		if err != nil {
+			return inserted, err
+		}
+		orgClusters, err := json.Marshal(entry.ClustersForOrg)
+		if err != nil {
+			return inserted, err
+		}
+
+		result, err := execStatement(connection, statement,
+			entry.RunID, entry.Operation, entry.Target, entry.Started.UTC(), entry.Finished.UTC(),
+			entry.ExitStatus, string(deletions), string(orgClusters), entry.RequestedBy)
+		if err != nil {
+			return inserted, queryFailed(historyTable, err)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return inserted, err
+		}
+		inserted += int(affected)
+	}
+	return inserted, nil
+}
+
+// exportState function exports state of the cleaner into JSON document
+func exportState(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	state, err := readCleanerState(connection, GetHistoryConfiguration(configuration).Table,
+		configuration.Storage.Name, time.Now())
+	if err != nil {
+		log.Err(err).Msg("Read cleaner state")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	out, err := createOutputFile(cliFlags.ExportState, cliFlags.Checksum)
+	if err == nil {
+		err = writeCleanerState(out.Writer(), state)
+		err = errors.Join(err, out.Close(err == nil))
+	}
+	if err != nil {
+		log.Err(err).Msg("Write cleaner state")
+		return ExitStatusStorageError, err
+	}
+
+	log.Info().
+		Str(filenameAttribute, cliFlags.ExportState).
+		Int("runs", len(state.RunHistory)).
+		Msg("Cleaner state exported")
+	return ExitStatusOK, nil
+}
+
+// importState function imports state of the cleaner from JSON document
+func importState(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(cliFlags.ImportState) // #nosec G304
+	if err != nil {
+		log.Err(err).Msg("Open cleaner state")
+		return ExitStatusStorageError, err
+	}
+	state, err := readCleanerStateDocument(file)
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		log.Err(err).Msg("Read cleaner state")
+		return ExitStatusStorageError, err
+	}
+
+	inserted, err := importRunHistory(connection, GetHistoryConfiguration(configuration).Table, state.RunHistory)
+	if err != nil {
+		log.Err(err).Msg("Import cleaner state")
+		return exitStatusForError(err, ExitStatusStorageError), err
+	}
+
+	log.Info().
+		Str(filenameAttribute, cliFlags.ImportState).
+		Str("source", state.Source).
+		Int("runs", len(state.RunHistory)).
+		Int("inserted", inserted).
+		Msg("Cleaner state imported")
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/state_test.html
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// stateConfiguration is configuration with history table used by state tests
+var stateConfiguration = cleaner.ConfigStruct{
+	Storage: cleaner.StorageConfiguration{Name: "primary"},
+	History: cleaner.HistoryConfiguration{Table: "run_history"},
+}
+
+// TestCleanerStateRoundTrip checks that exported state document can be read
+// back
+func TestCleanerStateRoundTrip(t *testing.T) {
+	started := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	state := cleaner.CleanerState{
+		Version:    1,
+		ExportedAt: started.Add(time.Hour),
+		Source:     "primary",
+		RunHistory: []cleaner.RunHistoryEntry{
+			{
+				RunID:             "run-1",
+				Operation:         "cleanup",
+				Target:            "primary",
+				Started:           started,
+				Finished:          started.Add(time.Minute),
+				DeletionsForTable: map[string]int{"report": 10},
+				ClustersForOrg:    map[int]int{1: 2},
+			},
+		},
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, cleaner.WriteCleanerState(&buffer, state))
+	assert.Contains(t, buffer.String(), `"run_id": "run-1"`)
+
+	read, err := cleaner.ReadCleanerStateDocument(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, state, read)
+}
+
+// TestReadCleanerStateDocumentImproper checks that improper documents are
+// refused
+func TestReadCleanerStateDocumentImproper(t *testing.T) {
+	for _, document := range []string{
+		"",
+		"{",
+		`{"version": 2, "run_history": []}`,
+		`{"version": 1, "unknown": true}`,
+	} {
+		_, err := cleaner.ReadCleanerStateDocument(strings.NewReader(document))
+		assert.Error(t, err, document)
+	}
+}
+
+// TestReadCleanerState checks that all recorded runs are exported
+func TestReadCleanerState(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(historyColumns)
+	rows.AddRow("run-1", "cleanup", "primary", since, since, 0, `{"report":10}`, `{"1":2}`, "operator")
+	mock.ExpectQuery(`SELECT run_id, operation, target, started_at, finished_at`).
+		WithArgs(time.Time{}).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	state, err := cleaner.ReadCleanerState(connection, "run_history", "primary", since)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, state.Version)
+	assert.Equal(t, "primary", state.Source)
+	assert.Len(t, state.RunHistory, 1)
+
+	// history table needs to be configured
+	_, err = cleaner.ReadCleanerState(connection, "", "primary", since)
+	assert.Error(t, err)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestImportRunHistory checks that runs already recorded are not counted as
+// inserted
+func TestImportRunHistory(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	started := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(`INSERT INTO "run_history" .* WHERE NOT EXISTS`).
+		WithArgs("run-1", "cleanup", "primary", started, started, cleaner.ExitStatusOK,
+			`{"report":10}`, `{"1":2}`, "operator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "run_history" .* WHERE NOT EXISTS`).
+		WithArgs("run-2", "cleanup", "primary", started, started, cleaner.ExitStatusOK,
+			`{}`, `{}`, "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	inserted, err := cleaner.ImportRunHistory(connection, "run_history", []cleaner.RunHistoryEntry{
+		{
+			RunID: "run-1", Operation: "cleanup", Target: "primary",
+			RequestedBy: "operator",
+			Started:     started, Finished: started,
+			DeletionsForTable: map[string]int{"report": 10},
+			ClustersForOrg:    map[int]int{1: 2},
+		},
+		{
+			RunID: "run-2", Operation: "cleanup", Target: "primary",
+			Started: started, Finished: started,
+			DeletionsForTable: map[string]int{},
+			ClustersForOrg:    map[int]int{},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inserted)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestImportRunHistoryReadOnly checks that state can not be imported in
+// read-only mode
+func TestImportRunHistoryReadOnly(t *testing.T) {
+	enableReadOnlyMode(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	_, err = cleaner.ImportRunHistory(connection, "run_history", nil)
+	assert.ErrorIs(t, err, cleaner.ErrReadOnly)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestExportAndImportState checks export of state into file and its import
+// into another environment
+func TestExportAndImportState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// prepare new mocked connection to source database
+	source, sourceMock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	rows := sqlmock.NewRows(historyColumns)
+	rows.AddRow("run-1", "cleanup", "primary", since, since, 0, `{"report":10}`, `{"1":2}`, "operator")
+	sourceMock.ExpectQuery(`SELECT run_id, operation, target, started_at, finished_at`).
+		WillReturnRows(rows)
+	sourceMock.ExpectClose()
+
+	status, err := cleaner.ExportState(&stateConfiguration, source, cleaner.CliFlags{ExportState: filename})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// prepare new mocked connection to target database
+	target, targetMock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	targetMock.ExpectExec(`INSERT INTO "run_history" .* WHERE NOT EXISTS`).
+		WithArgs("run-1", "cleanup", "primary", since, since, cleaner.ExitStatusOK,
+			`{"report":10}`, `{"1":2}`, "operator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	targetMock.ExpectClose()
+
+	status, err = cleaner.ImportState(&stateConfiguration, target, cleaner.CliFlags{ImportState: filename})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, source)
+	checkConnectionClose(t, target)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, sourceMock)
+	checkAllExpectations(t, targetMock)
+}
+
+// TestImportStateMissingFile checks exit status when state file does not
+// exist
+func TestImportStateMissingFile(t *testing.T) {
+	status, err := cleaner.ImportState(&stateConfiguration, nil,
+		cleaner.CliFlags{ImportState: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+}
@@ -0,0 +1,322 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/statements.html
+
+// This source file contains functions that execute SQL statements issued by
+// the cleaner. References to OCP tables are qualified by schema name before
+// the statement is executed. When statement logging is enabled by -log-sql
+// command line option, each executed statement is logged together with its
+// parameters, duration, and number of affected rows. Parameters containing
+// payloads (JSON documents or long texts) are redacted, so reports and
+// template data never appear in logs.
+//
+// Statements that take longer than threshold set by slow_statement_threshold
+// configuration option are always logged as warnings (together with table and
+// number of affected rows) and counted in metrics, so regressions in DB
+// indexes are noticed from the cleaner's own telemetry. Durations of all
+// successful statements that list, delete, or vacuum records are recorded in
+// histogram metric labeled by table and operation.
+//
+// Statements executed against SQLite database are translated into SQLite
+// dialect: timestamps computed from max age and type casts used by
+// PostgreSQL are replaced, so listing and cleanup can be tried against
+// SQLite database (including in-memory one) without any external dependency.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Messages and attributes used by statement logging
+const (
+	executedStatementMsg = "Executed SQL statement"
+	slowStatementMsg     = "Slow SQL statement"
+	statementAttribute   = "statement"
+	parametersAttribute  = "parameters"
+	durationAttribute    = "duration"
+	rowsAttribute        = "rows"
+)
+
+// Operations performed by SQL statements, used as labels of statement
+// duration metric
+const (
+	listStatementOperation   = "list"
+	deleteStatementOperation = "delete"
+	vacuumStatementOperation = "vacuum"
+)
+
+// maxLoggedParameterLength is the max length of text parameter that is
+// logged as is, longer parameters are redacted
+const maxLoggedParameterLength = 64
+
+// unknownTable is used as table name for statements that do not reference
+// any table
+const unknownTable = "unknown"
+
+// logStatements is set when all executed SQL statements should be logged
+var logStatements = false
+
+// readOnlyMode is set when no statement modifying data can be executed
+var readOnlyMode = false
+
+// slowStatementThreshold is duration of statement execution that is
+// considered slow, slow statements are not detected when it is zero
+var slowStatementThreshold time.Duration
+
+// sqliteDialect is set when statements are executed against SQLite database
+var sqliteDialect = false
+
+// Regular expressions used to translate statements into SQLite dialect
+var (
+	sqliteIntervalBeforeNow = regexp.MustCompile(`(?i)\bNOW\(\)\s*-\s*(\$\d+)::INTERVAL\b`)
+	sqliteNow               = regexp.MustCompile(`(?i)\bNOW\(\)`)
+	sqliteTypeCast          = regexp.MustCompile(`::[A-Za-z]+\b`)
+)
+
+// statementTableReference is a regular expression used to find the first
+// table referenced by SQL statement
+var statementTableReference = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+([\w.]+)`)
+
+// vacuumTableReference is a regular expression used to find table vacuumed
+// by VACUUM statement with options, table name might be quoted
+var vacuumTableReference = regexp.MustCompile(`(?i)^\s*VACUUM\s*\([^)]*\)\s*([\w."]+)`)
+
+// sqlQuerier is an interface implemented by both sql.DB and sql.Tx, so the
+// same functions can be used to read records with and without transaction
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// registerStatementDialect function selects SQL dialect of executed
+// statements by name of database driver
+func registerStatementDialect(driver string) {
+	sqliteDialect = driver == "sqlite3"
+}
+
+// dialectStatement function translates PostgreSQL-specific expressions used
+// by the cleaner into SQLite dialect. The statement is returned unchanged
+// when it is not executed against SQLite database.
+func dialectStatement(statement string) string {
+	if !sqliteDialect {
+		return statement
+	}
+	// max age is passed as parameter, for example '90 days'
+	statement = sqliteIntervalBeforeNow.ReplaceAllString(statement, "datetime('now', '-' || ${1})")
+	statement = sqliteNow.ReplaceAllString(statement, "datetime('now')")
+	return sqliteTypeCast.ReplaceAllString(statement, "")
+}
+
+// execStatement function executes given SQL statement that does not return
+// rows (DELETE, UPDATE, INSERT etc.)
+func execStatement(connection sqlExecutor, statement string, args ...interface{}) (sql.Result, error) {
+	statement = dialectStatement(qualifyTableNames(statement))
+
+	// hard stop for any code path that would modify data
+	if readOnlyMode {
+		log.Error().
+			Str(statementAttribute, statement).
+			Msg("Statement modifying data refused in read-only mode")
+		return nil, ErrReadOnly
+	}
+
+	started := time.Now()
+	result, err := connection.Exec(statement, args...)
+	duration := time.Since(started)
+
+	rows := int64(-1)
+	if err == nil && (logStatements || isSlowStatement(duration)) {
+		// number of affected rows is not available for all statements
+		if affected, rowsErr := result.RowsAffected(); rowsErr == nil {
+			rows = affected
+		}
+	}
+	statementExecuted(statement, args, duration, rows, err)
+	return result, err
+}
+
+// queryStatement function executes given SQL query that returns rows
+func queryStatement(connection sqlQuerier, statement string, args ...interface{}) (*sql.Rows, error) {
+	return queryStatementContext(context.Background(), connection, statement, args...)
+}
+
+// queryStatementContext function executes given SQL query the same way as
+// queryStatement, the query is canceled when given context is done
+func queryStatementContext(ctx context.Context, connection sqlQuerier, statement string,
+	args ...interface{}) (*sql.Rows, error) {
+	statement = dialectStatement(qualifyTableNames(statement))
+
+	started := time.Now()
+	rows, err := connection.QueryContext(ctx, statement, args...)
+	statementExecuted(statement, args, time.Since(started), -1, err)
+	return rows, err
+}
+
+// queryRowStatement function executes given SQL query that is expected to
+// return at most one row
+func queryRowStatement(connection sqlQuerier, statement string, args ...interface{}) *sql.Row {
+	statement = dialectStatement(qualifyTableNames(statement))
+
+	started := time.Now()
+	row := connection.QueryRow(statement, args...)
+	statementExecuted(statement, args, time.Since(started), -1, row.Err())
+	return row
+}
+
+// statementExecuted function logs executed SQL statement when statement
+// logging is enabled and reports the statement when it is slow. Negative
+// number of rows means that the number is unknown.
+func statementExecuted(statement string, args []interface{}, duration time.Duration, rows int64, err error) {
+	if logStatements {
+		logStatement(statement, args, duration, rows, err)
+	}
+	if isSlowStatement(duration) {
+		reportSlowStatement(statement, duration, rows)
+	}
+	if err == nil {
+		if operation := statementOperation(statement); operation != "" {
+			recordStatementDuration(statementTable(statement), operation, duration)
+		}
+	}
+}
+
+// statementOperation function returns operation performed by given SQL
+// statement. Empty string is returned for statements that do not list,
+// delete, or vacuum records.
+func statementOperation(statement string) string {
+	words := strings.Fields(statement)
+	if len(words) == 0 {
+		return ""
+	}
+	switch strings.ToUpper(words[0]) {
+	case "SELECT":
+		return listStatementOperation
+	case "DELETE":
+		return deleteStatementOperation
+	case "VACUUM":
+		return vacuumStatementOperation
+	}
+	return ""
+}
+
+// isSlowStatement function checks if statement execution took longer than
+// configured threshold
+func isSlowStatement(duration time.Duration) bool {
+	return slowStatementThreshold > 0 && duration >= slowStatementThreshold
+}
+
+// statementTable function returns name of the first table referenced by
+// given SQL statement
+func statementTable(statement string) string {
+	if match := vacuumTableReference.FindStringSubmatch(statement); match != nil {
+		return strings.ReplaceAll(match[1], `"`, "")
+	}
+	match := statementTableReference.FindStringSubmatch(statement)
+	if match == nil {
+		return unknownTable
+	}
+	return match[1]
+}
+
+// reportSlowStatement function logs slow SQL statement and records it into
+// metrics. Parameters are not logged, they might contain payloads.
+func reportSlowStatement(statement string, duration time.Duration, rows int64) {
+	table := statementTable(statement)
+	event := log.Warn().
+		Str(tableName, table).
+		Str(statementAttribute, strings.Join(strings.Fields(statement), " ")).
+		Dur(durationAttribute, duration).
+		Dur("threshold", slowStatementThreshold)
+	if rows >= 0 {
+		event = event.Int64(rowsAttribute, rows)
+	}
+	event.Msg(slowStatementMsg)
+	recordSlowStatement(table)
+}
+
+// registerSlowStatementThreshold function registers duration of statement
+// execution that is considered slow. Slow statements are not detected when
+// the threshold is not specified.
+func registerSlowStatementThreshold(threshold string) {
+	// threshold has been checked together with the whole configuration
+	duration, err := time.ParseDuration(threshold)
+	if err != nil {
+		duration = 0
+	}
+	slowStatementThreshold = duration
+}
+
+// logStatement function logs executed SQL statement with its redacted
+// parameters. Negative number of rows means that the number is unknown.
+func logStatement(statement string, args []interface{}, duration time.Duration, rows int64, err error) {
+	event := log.Info()
+	if err != nil {
+		event = log.Error().Err(err)
+	}
+	event = event.
+		Str(statementAttribute, strings.Join(strings.Fields(statement), " ")).
+		Strs(parametersAttribute, redactParameters(args)).
+		Dur(durationAttribute, duration)
+	if rows >= 0 {
+		event = event.Int64(rowsAttribute, rows)
+	}
+	event.Msg(executedStatementMsg)
+}
+
+// redactParameters function converts parameters of SQL statement into
+// textual form that can be logged. Payloads are replaced by their length.
+func redactParameters(args []interface{}) []string {
+	parameters := make([]string, len(args))
+	for i, arg := range args {
+		parameters[i] = redactParameter(arg)
+	}
+	return parameters
+}
+
+// redactParameter function converts one parameter of SQL statement into
+// textual form that can be logged
+func redactParameter(arg interface{}) string {
+	var text string
+	switch value := arg.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		text = string(value)
+	case string:
+		text = value
+	case ClusterName:
+		return string(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) > maxLoggedParameterLength ||
+		strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return fmt.Sprintf("<redacted %d bytes>", len(text))
+	}
+	return text
+}
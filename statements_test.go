@@ -0,0 +1,301 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/statements_test.html
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// enableStatementLogging enables logging of SQL statements and disables it
+// when the test finishes
+func enableStatementLogging(t *testing.T) {
+	*cleaner.LogStatements = true
+	t.Cleanup(func() {
+		*cleaner.LogStatements = false
+	})
+}
+
+// restoreLogger function restores global logger when the test finishes, so
+// other tests do not write into closed standard error output
+func restoreLogger(t *testing.T) {
+	logger := log.Logger
+	t.Cleanup(func() {
+		log.Logger = logger
+	})
+}
+
+// TestRedactParameters checks that payloads are never logged
+func TestRedactParameters(t *testing.T) {
+	longText := strings.Repeat("x", 100)
+	parameters := cleaner.RedactParameters([]interface{}{
+		42, "90 days", cleaner.ClusterName(cluster1ID), nil,
+		`{"reports": []}`, []byte(" [1, 2, 3]"), longText,
+	})
+	assert.Equal(t, []string{
+		"42", "90 days", cluster1ID, "NULL",
+		"<redacted 15 bytes>", "<redacted 10 bytes>", "<redacted 100 bytes>",
+	}, parameters)
+}
+
+// TestExecStatementLogging checks that executed statement is logged with its
+// parameters, duration, and number of affected rows
+func TestExecStatementLogging(t *testing.T) {
+	enableStatementLogging(t)
+	restoreLogger(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM report WHERE cluster = \\$1").
+		WithArgs(cluster1ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE report SET report").
+		WillReturnError(errors.New("mocked error"))
+	mock.ExpectClose()
+
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		_, err := cleaner.ExecStatement(connection, "DELETE FROM report WHERE cluster = $1", cluster1ID)
+		assert.NoError(t, err)
+
+		_, err = cleaner.ExecStatement(connection, "UPDATE report SET report = $1", `{"big": "payload"}`)
+		assert.Error(t, err)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Executed SQL statement")
+	assert.Contains(t, output, "DELETE FROM report WHERE cluster = $1")
+	assert.Contains(t, output, cluster1ID)
+	assert.Contains(t, output, "rows")
+	assert.Contains(t, output, "duration")
+	assert.Contains(t, output, "<redacted 18 bytes>")
+	assert.Contains(t, output, "mocked error")
+	assert.NotContains(t, output, "payload")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestExecStatementWithoutLogging checks that statements are not logged by
+// default
+func TestExecStatementWithoutLogging(t *testing.T) {
+	restoreLogger(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM report").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		_, err := cleaner.ExecStatement(connection, "DELETE FROM report WHERE cluster = $1", cluster1ID)
+		assert.NoError(t, err)
+	})
+	checkCapture(t, err)
+	assert.NotContains(t, output, "Executed SQL statement")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// enableReadOnlyMode enables read-only mode and disables it when the test
+// finishes
+func enableReadOnlyMode(t *testing.T) {
+	*cleaner.ReadOnlyMode = true
+	t.Cleanup(func() {
+		*cleaner.ReadOnlyMode = false
+	})
+}
+
+// TestExecStatementReadOnly checks that no statement is executed in
+// read-only mode
+func TestExecStatementReadOnly(t *testing.T) {
+	enableReadOnlyMode(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no statement is expected
+	mock.ExpectClose()
+
+	_, err = cleaner.ExecStatement(connection, "DELETE FROM report WHERE cluster = $1", cluster1ID)
+	assert.ErrorIs(t, err, cleaner.ErrReadOnly)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestStatementTable checks that table referenced by SQL statement is found
+func TestStatementTable(t *testing.T) {
+	assert.Equal(t, "report", cleaner.StatementTable("DELETE FROM report WHERE cluster = $1"))
+	assert.Equal(t, "aggregator.rule_hit", cleaner.StatementTable("select count(*) from aggregator.rule_hit"))
+	assert.Equal(t, "report", cleaner.StatementTable("UPDATE report SET report = '{}'"))
+	assert.Equal(t, "unknown", cleaner.StatementTable("VACUUM VERBOSE;"))
+	assert.Equal(t, "public.report", cleaner.StatementTable(`VACUUM (FULL, VERBOSE) "public"."report";`))
+}
+
+// TestStatementOperation checks that operation performed by SQL statement
+// is recognized
+func TestStatementOperation(t *testing.T) {
+	assert.Equal(t, "list", cleaner.StatementOperation("SELECT cluster FROM report"))
+	assert.Equal(t, "list", cleaner.StatementOperation("\n\tselect count(*) from rule_hit"))
+	assert.Equal(t, "delete", cleaner.StatementOperation("DELETE FROM report WHERE cluster = $1"))
+	assert.Equal(t, "vacuum", cleaner.StatementOperation("VACUUM VERBOSE;"))
+	assert.Equal(t, "", cleaner.StatementOperation("UPDATE report SET report = '{}'"))
+	assert.Equal(t, "", cleaner.StatementOperation(""))
+}
+
+// TestStatementDurationMetric checks that durations of executed statements
+// are exported in histogram metric labeled by table and operation
+func TestStatementDurationMetric(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM recommendation").
+		WithArgs(cluster1ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE unmeasured_table").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	_, err = cleaner.ExecStatement(connection, "DELETE FROM recommendation WHERE cluster_id = $1", cluster1ID)
+	assert.NoError(t, err)
+	_, err = cleaner.ExecStatement(connection, "UPDATE unmeasured_table SET version = 0")
+	assert.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "cleaner.prom")
+	assert.NoError(t, cleaner.WriteMetricsTextfile(filename))
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	text := string(content)
+	assert.Contains(t, text, `insights_results_aggregator_cleaner_statement_duration_seconds_count{operation="delete",table="recommendation"}`)
+	// only statements listing, deleting, or vacuuming records are measured
+	assert.NotContains(t, text, `table="unmeasured_table"`)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestSlowStatement checks that statements exceeding the threshold are
+// logged as warnings and counted in metrics
+func TestSlowStatement(t *testing.T) {
+	restoreLogger(t)
+
+	// every statement is slow
+	cleaner.RegisterSlowStatementThreshold("1ns")
+	t.Cleanup(func() {
+		cleaner.RegisterSlowStatementThreshold("")
+	})
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectExec("DELETE FROM cluster_rule_toggle").
+		WithArgs(cluster1ID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectClose()
+
+	output, err := capture.ErrorOutput(func() {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		log.Logger = log.Output(zerolog.New(os.Stderr))
+
+		_, err := cleaner.ExecStatement(connection, "DELETE FROM cluster_rule_toggle WHERE cluster_id = $1", cluster1ID)
+		assert.NoError(t, err)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Slow SQL statement")
+	assert.Contains(t, output, "cluster_rule_toggle")
+	assert.Contains(t, output, "rows")
+	// parameters are not part of the warning
+	assert.NotContains(t, output, cluster1ID)
+
+	// slow statements are exported in metrics
+	filename := filepath.Join(t.TempDir(), "cleaner.prom")
+	assert.NoError(t, cleaner.WriteMetricsTextfile(filename))
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `insights_results_aggregator_cleaner_slow_statements{table="cluster_rule_toggle"}`)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// enableSQLiteDialect function translates statements into SQLite dialect
+// for the rest of the test
+func enableSQLiteDialect(t *testing.T) {
+	cleaner.RegisterStatementDialect("sqlite3")
+	t.Cleanup(func() {
+		cleaner.RegisterStatementDialect("postgres")
+	})
+}
+
+// TestDialectStatement checks translation of statements into SQLite dialect
+func TestDialectStatement(t *testing.T) {
+	statement := "DELETE FROM report WHERE reported_at < NOW() - $1::INTERVAL AND reported_at <= NOW()"
+
+	// statements are not changed for PostgreSQL
+	assert.Equal(t, statement, cleaner.DialectStatement(statement))
+
+	enableSQLiteDialect(t)
+	assert.Equal(t,
+		"DELETE FROM report WHERE reported_at < datetime('now', '-' || $1) AND reported_at <= datetime('now')",
+		cleaner.DialectStatement(statement))
+	assert.Equal(t, "SELECT $1, $2 FROM history",
+		cleaner.DialectStatement("SELECT $1::VARCHAR, $2::TIMESTAMP FROM history"))
+}
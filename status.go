@@ -0,0 +1,185 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/status.html
+
+// This source file contains implementation of single-shot health output
+// selected by -status command line option. It is designed for probes and
+// external schedulers (for example Helm hooks or Kubernetes CronJobs): one
+// compact JSON document is written to standard output (or into file
+// specified by -output) even when database is not reachable. The document
+// contains:
+//
+// - whether database is reachable
+// - whether tables of configured DB schema have been detected, together with
+//   aggregator migration version
+// - timestamp and exit status of the last run recorded in history table
+// - estimate of number of old records pending in each table, computed from
+//   planner statistics (see estimate.go)
+//
+// Problems found are listed in the document and the tool exits with storage
+// error status in such case. Nothing is modified in database.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// selectLastRunStatement reads the last run recorded in history table. Name
+// of history table is inserted into the statement as quoted identifier.
+const selectLastRunStatement = `
+	    SELECT finished_at, exit_status
+	      FROM %s
+	     ORDER BY finished_at DESC
+	     LIMIT 1`
+
+// CleanerStatus represents health of the cleaner and its database
+type CleanerStatus struct {
+	Target            string           `json:"target"`
+	DatabaseReachable bool             `json:"database_reachable"`
+	Schema            string           `json:"schema"`
+	SchemaDetected    bool             `json:"schema_detected"`
+	MigrationVersion  *int             `json:"migration_version,omitempty"`
+	LastRun           *time.Time       `json:"last_run,omitempty"`
+	LastRunExitStatus *ExitStatus      `json:"last_run_exit_status,omitempty"`
+	PendingOldRecords map[string]int64 `json:"pending_old_records,omitempty"`
+	Errors            []string         `json:"errors,omitempty"`
+}
+
+// Healthy method checks if no problem has been found
+func (status CleanerStatus) Healthy() bool {
+	return len(status.Errors) == 0
+}
+
+// addError method records problem found while status is read
+func (status *CleanerStatus) addError(err error) {
+	status.Errors = append(status.Errors, err.Error())
+}
+
+// readLastRun function reads finish time and exit status of the last run
+// recorded in history table. Nil is returned when no run is recorded.
+func readLastRun(connection *sql.DB, historyTable string) (*time.Time, *ExitStatus, error) {
+	var (
+		finished   time.Time
+		exitStatus ExitStatus
+	)
+	err := queryRowStatement(connection, historyTableStatement(selectLastRunStatement, historyTable)).
+		Scan(&finished, &exitStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, queryFailed(historyTable, err)
+	}
+	finished = finished.UTC()
+	return &finished, &exitStatus, nil
+}
+
+// readCleanerStatus function reads health of the cleaner and its database.
+// All problems are recorded into the status, so the status is always
+// returned.
+func readCleanerStatus(configuration *ConfigStruct, connection *sql.DB) CleanerStatus {
+	storageCfg := &configuration.Storage
+	status := CleanerStatus{
+		Target: storageCfg.Name,
+		Schema: storageCfg.Schema,
+	}
+
+	if err := pingDatabase(connection, storageCfg); err != nil {
+		status.addError(err)
+		return status
+	}
+	status.DatabaseReachable = true
+
+	// tables of plug-in schemas are not detected
+	if table, found := migrationInfoTables[storageCfg.Schema]; found {
+		version, err := readMigrationVersion(connection, table)
+		if err != nil {
+			status.addError(fmt.Errorf("schema %s not detected: %w", storageCfg.Schema, err))
+		} else {
+			status.SchemaDetected = true
+			status.MigrationVersion = &version
+		}
+	}
+
+	if historyTable := GetHistoryConfiguration(configuration).Table; historyTable != "" {
+		lastRun, exitStatus, err := readLastRun(connection, historyTable)
+		if err != nil {
+			status.addError(err)
+		}
+		status.LastRun = lastRun
+		status.LastRunExitStatus = exitStatus
+	}
+
+	// planner statistics are available in PostgreSQL only
+	if status.SchemaDetected && configuration.Cleaner.MaxAge != "" && !sqliteDialect {
+		estimates, err := estimateOldRecords(connection, configuration.Cleaner.MaxAge, storageCfg.Schema,
+			ListingFilter{}, false)
+		if err != nil {
+			status.addError(err)
+		}
+		for _, estimate := range estimates {
+			// tables that have not been analyzed yet are skipped
+			if estimate.Estimate.Valid {
+				if status.PendingOldRecords == nil {
+					status.PendingOldRecords = make(map[string]int64)
+				}
+				status.PendingOldRecords[estimate.Table] = estimate.Estimate.Int64
+			}
+		}
+	}
+
+	return status
+}
+
+// writeCleanerStatus function writes status as compact JSON document on one
+// line
+func writeCleanerStatus(writer io.Writer, status CleanerStatus) error {
+	return json.NewEncoder(writer).Encode(status)
+}
+
+// showStatus function writes health of the cleaner and its database
+func showStatus(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags) (ExitStatus, error) {
+	status := readCleanerStatus(configuration, connection)
+
+	output := cliFlags.Output
+	if output == "" {
+		output = "-"
+	}
+	out, err := createOutputFile(output, false)
+	if err == nil {
+		err = writeCleanerStatus(out.Writer(), status)
+		err = errors.Join(err, out.Close(err == nil))
+	}
+	if err != nil {
+		log.Err(err).Msg("Write status")
+		return ExitStatusStorageError, err
+	}
+
+	if !status.Healthy() {
+		return ExitStatusStorageError, fmt.Errorf("status: %d problem(s) found", len(status.Errors))
+	}
+	return ExitStatusOK, nil
+}
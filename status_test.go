@@ -0,0 +1,166 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/status_test.html
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// expectedLastRunQuery is query reading the last recorded run
+const expectedLastRunQuery = `SELECT finished_at, exit_status\s+FROM "run_history"`
+
+// statusConfiguration is configuration used by status tests
+var statusConfiguration = cleaner.ConfigStruct{
+	Storage: cleaner.StorageConfiguration{
+		Name:   "primary",
+		Schema: cleaner.DBSchemaDVORecommendations,
+	},
+	Cleaner: cleaner.CleanerConfiguration{MaxAge: "90 days"},
+	History: cleaner.HistoryConfiguration{Table: "run_history"},
+}
+
+// readStatusFile function reads status written into given file
+func readStatusFile(t *testing.T, filename string) cleaner.CleanerStatus {
+	var status cleaner.CleanerStatus
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(content, &status))
+	return status
+}
+
+// TestShowStatus checks that status is written as compact JSON document
+func TestShowStatus(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "status.json")
+	finished := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// cleaner tables are not migrated by status
+	mock.ExpectPing()
+	expectMigrationVersion(mock, selectDVOMigrationVersion, 3)
+	mock.ExpectQuery(expectedLastRunQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"finished_at", "exit_status"}).
+			AddRow(finished, cleaner.ExitStatusOK))
+	mock.ExpectQuery(expectedEstimateQuery).
+		WithArgs("dvo", "dvo_report", "reported_at", "90 days").
+		WillReturnRows(sqlmock.NewRows(statisticsColumns).AddRow(1000, 0, 50, 101))
+	mock.ExpectClose()
+
+	status, err := cleaner.DoSelectedOperation(&statusConfiguration, connection,
+		cleaner.CliFlags{Status: true, Output: filename})
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"), "status needs to be written on one line")
+
+	written := readStatusFile(t, filename)
+	assert.Equal(t, "primary", written.Target)
+	assert.True(t, written.DatabaseReachable)
+	assert.True(t, written.SchemaDetected)
+	assert.Equal(t, 3, *written.MigrationVersion)
+	assert.Equal(t, finished, *written.LastRun)
+	assert.Equal(t, cleaner.ExitStatusOK, *written.LastRunExitStatus)
+	assert.Equal(t, map[string]int64{"dvo_report": 495}, written.PendingOldRecords)
+	assert.Empty(t, written.Errors)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestShowStatusDatabaseUnreachable checks that status is written even when
+// database is not reachable
+func TestShowStatusDatabaseUnreachable(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "status.json")
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// no other statement is expected
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectClose()
+
+	status, err := cleaner.DoSelectedOperation(&statusConfiguration, connection,
+		cleaner.CliFlags{Status: true, Output: filename})
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	written := readStatusFile(t, filename)
+	assert.False(t, written.DatabaseReachable)
+	assert.False(t, written.SchemaDetected)
+	assert.Nil(t, written.LastRun)
+	assert.Len(t, written.Errors, 1)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestReadCleanerStatusSchemaNotDetected checks status of database without
+// aggregator tables and without recorded runs
+func TestReadCleanerStatusSchemaNotDetected(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectPing()
+	mock.ExpectQuery(selectDVOMigrationVersion).
+		WillReturnError(errors.New(`relation "dvo.migration_info" does not exist`))
+	mock.ExpectQuery(expectedLastRunQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"finished_at", "exit_status"}))
+	mock.ExpectClose()
+
+	// old records are not estimated when schema is not detected
+	status := cleaner.ReadCleanerStatus(&statusConfiguration, connection)
+	assert.True(t, status.DatabaseReachable)
+	assert.False(t, status.SchemaDetected)
+	assert.Nil(t, status.MigrationVersion)
+	assert.Nil(t, status.LastRun)
+	assert.Nil(t, status.PendingOldRecords)
+	assert.Len(t, status.Errors, 1)
+	assert.False(t, status.Healthy())
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
@@ -33,21 +33,22 @@ package main
 // https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-cleaner
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"os"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"database/sql"
 
-	_ "github.com/lib/pq"           // PostgreSQL database driver
+	"github.com/lib/pq"             // PostgreSQL database driver
 	_ "github.com/mattn/go-sqlite3" // SQLite database driver
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 )
 
 // Error messages
@@ -55,23 +56,33 @@ const (
 	canNotConnectToDataStorageMessage = "Can not connect to data storage"
 	unableToCloseDBRowsHandle         = "Unable to close the DB rows handle"
 	connectionNotEstablished          = "Connection to database was not established"
+	databaseUnreachable               = "Database is not reachable"
 	reportedMsg                       = "reported"
 	lastCheckedMsg                    = "lastChecked"
 	ageMsg                            = "age"
+	namespaceIDMsg                    = "namespace ID"
+	namespaceNameMsg                  = "namespace name"
+	recommendationsMsg                = "recommendations"
+	objectsMsg                        = "objects"
 	reportsCountMsg                   = "reports count"
 	maxAgeMissing                     = "max-age parameter is missing"
-	invalidSchemaMsg                  = "Invalid DB schema to be cleaned up: '%s'"
+	invalidSchemaMsg                  = "Invalid DB schema: '%s'"
 	affectedMsg                       = "Affected"
+	ratingsCriteriaMissing            = "organization ID or rule needs to be specified to cleanup Advisor ratings"
 )
 
 // Other messages
 const (
 	tableName      = "table"
 	clusterNameMsg = "cluster"
+	orgIDMsg       = "organization"
+	ruleFQDNMsg    = "rule FQDN"
+	errorKeyMsg    = "error key"
 	fileOpenMsg    = "File open"
 	fileCloseMsg   = "File close"
 	flushWriterMsg = "Flush writer"
 	writeToFileMsg = "Write to file"
+	countMsg       = "count"
 )
 
 // SQL commands
@@ -94,19 +105,75 @@ const (
 	     WHERE consumed_at < NOW() - $1::INTERVAL
 	     ORDER BY consumed_at`
 
+	selectOldRecommendations = `
+	    SELECT org_id, cluster_id, rule_fqdn, error_key, rule_id, created_at
+	      FROM recommendation
+	     WHERE created_at < NOW() - $1::INTERVAL
+	     ORDER BY created_at`
+
+	// report_info does not contain any timestamp, so its records are
+	// selected by age of reports they belong to
+	selectOldReportInfo = `
+	    SELECT report_info.org_id, report_info.cluster_id, report_info.version_info, report.reported_at
+	      FROM report_info
+	      JOIN report
+	        ON report.cluster = report_info.cluster_id
+	       AND report.org_id = report_info.org_id
+	     WHERE report.reported_at < NOW() - $1::INTERVAL
+	     ORDER BY report.reported_at`
+
 	selectOldDVOReports = `
-	    SELECT org_id, cluster_id, reported_at, last_checked_at
-	      FROM dvo.dvo_report
+	    SELECT org_id, cluster_id, namespace_id, namespace_name,
+	           recommendations, objects, reported_at, last_checked_at
+	      FROM dvo_report
 	     WHERE reported_at < NOW() - $1::INTERVAL
 	     ORDER BY reported_at`
 
+	selectDVONamespaceStatistics = `
+	    SELECT namespace_id, namespace_name, COUNT(*),
+	           MIN(reported_at), MAX(last_checked_at),
+	           COALESCE(SUM(objects), 0), COALESCE(SUM(recommendations), 0)
+	      FROM dvo_report
+	     GROUP BY namespace_id, namespace_name
+	     ORDER BY MAX(last_checked_at)`
+
+	// clusters where the same rule has been toggled more than once
+	selectMultipleRuleToggles = `
+                select cluster_id, rule_id, count(*) as cnt
+                  from cluster_rule_toggle
+                 group by cluster_id, rule_id
+                having count(*)>1
+                 order by cnt desc;
+`
+
+	// clusters where the same rule has been disabled by different users
+	selectMultipleRuleDisableFeedbacks = `
+                select cluster_id, rule_id, count(*) as cnt
+                  from cluster_user_rule_disable_feedback
+                 group by cluster_id, rule_id
+                having count(*)>1
+                 order by cnt desc;
+`
+
+	selectOrgIDForCluster = "select org_id from report where cluster = $1"
+
+	selectTableSizes = `
+	    SELECT schemaname || '.' || relname, pg_total_relation_size(relid), n_live_tup
+	      FROM pg_stat_user_tables
+	     WHERE schemaname = $1
+	     ORDER BY schemaname, relname`
+
+	// future-dated records (clock skew) are never deleted, even when
+	// max age is negative
 	deleteOldOCPReports = `
 		DELETE FROM report
-		 WHERE reported_at < NOW() - $1::INTERVAL`
+		 WHERE reported_at < NOW() - $1::INTERVAL
+		   AND reported_at <= NOW()`
 
 	deleteOldConsumerErrors = `
 		DELETE FROM consumer_error
-		 WHERE consumed_at < NOW() - $1::INTERVAL`
+		 WHERE consumed_at < NOW() - $1::INTERVAL
+		   AND consumed_at <= NOW()`
 
 	deleteOldOCPRuleHits = `
 		WITH to_delete AS (
@@ -115,7 +182,8 @@ const (
 			LEFT JOIN report
 				ON rule_hit.cluster_id = report.cluster
 				AND rule_hit.org_id = report.org_id
-				WHERE report.reported_at < NOW() - $1::INTERVAL OR report.cluster IS NULL
+				WHERE (report.reported_at < NOW() - $1::INTERVAL AND report.reported_at <= NOW())
+				   OR report.cluster IS NULL
 		)
 		DELETE FROM rule_hit
 		WHERE EXISTS (
@@ -127,11 +195,39 @@ const (
 
 	deleteOldOCPRecommendation = `
 		DELETE FROM recommendation
-		 WHERE created_at < NOW() - $1::INTERVAL`
+		 WHERE created_at < NOW() - $1::INTERVAL
+		   AND created_at <= NOW()`
 
 	deleteOldDVOReports = `
-		DELETE FROM dvo.dvo_report
-		 WHERE last_checked_at < NOW() - $1::INTERVAL`
+		DELETE FROM dvo_report
+		 WHERE last_checked_at < NOW() - $1::INTERVAL
+		   AND last_checked_at <= NOW()`
+)
+
+// Default values used to check if database is reachable
+const (
+	defaultPingBackoff = time.Second
+	pingTimeout        = 10 * time.Second
+)
+
+// sqliteInMemory is SQLite data source that refers to in-memory database
+const sqliteInMemory = ":memory:"
+
+// defaultFingerprintQuery is used to read database fingerprint when no other
+// query is specified in configuration
+const defaultFingerprintQuery = "SELECT current_database()"
+
+// defaultApplicationName is application_name reported to PostgreSQL when
+// it is not set in configuration
+const defaultApplicationName = "insights-results-aggregator-cleaner"
+
+// Tables referenced by more operations
+const (
+	advisorRatingsTable = "advisor_ratings"
+	recommendationTable = "recommendation"
+	reportInfoTable     = "report_info"
+	dvoReportTable      = "dvo_report"
+	statisticsTable     = "pg_stat_user_tables"
 )
 
 // DB schemas
@@ -142,6 +238,58 @@ const (
 
 var emptyJSON = json.RawMessage(`{}`)
 
+// oldRecordsTable describes table with old records that are counted by
+// count-only listing. Records with timestamp in the future are counted in
+// separate category. Tables without timestamp are joined with table that
+// contains it.
+type oldRecordsTable struct {
+	category        string
+	futureCategory  string
+	table           string
+	timestampColumn string
+	join            string
+}
+
+// source method returns FROM clause used to select records from the table
+func (table oldRecordsTable) source() string {
+	if table.join == "" {
+		return table.table
+	}
+	return table.table + " " + table.join
+}
+
+// statistics method returns table and column with planner statistics used to
+// estimate number of old records. Statistics of joined table are used for
+// tables without timestamp.
+func (table oldRecordsTable) statistics() (string, string) {
+	if relation, column, found := strings.Cut(table.timestampColumn, "."); found {
+		return relation, column
+	}
+	return table.table, table.timestampColumn
+}
+
+// oldRecordsTablesOCP contains tables with old records in OCP database, in
+// the same order as they are listed
+var oldRecordsTablesOCP = []oldRecordsTable{
+	{"Old OCP reports", "Future-dated OCP reports", "report", "reported_at", ""},
+	{"Old Advisor ratings", "Future-dated Advisor ratings", advisorRatingsTable, "last_updated_at", ""},
+	{"Old consumer errors", "Future-dated consumer errors", "consumer_error", "consumed_at", ""},
+	{"Old recommendations", "Future-dated recommendations", recommendationTable, "created_at", ""},
+	// report_info does not contain any timestamp, so its records are
+	// counted by age of reports they belong to
+	{"Old report info", "Future-dated report info", reportInfoTable, "report.reported_at",
+		"JOIN report ON report.cluster = report_info.cluster_id AND report.org_id = report_info.org_id"},
+}
+
+// oldRecordsTablesDVO contains tables with old records in DVO database
+var oldRecordsTablesDVO = []oldRecordsTable{
+	{"Old DVO reports", "Future-dated DVO reports", dvoReportTable, "reported_at", ""},
+}
+
+// errListingLimitReached is used to stop reading records when requested
+// number of records has been listed
+var errListingLimitReached = errors.New("listing limit reached")
+
 // initDatabaseConnection initializes driver, checks if it's supported and
 // initializes connection to the storage.
 func initDatabaseConnection(configuration *StorageConfiguration) (*sql.DB, error) {
@@ -163,15 +311,7 @@ func initDatabaseConnection(configuration *StorageConfiguration) (*sql.DB, error
 	case "sqlite3":
 		dataSource = configuration.SQLiteDataSource
 	case "postgres":
-		dataSource = fmt.Sprintf(
-			"postgresql://%v:%v@%v:%v/%v?%v",
-			configuration.PGUsername,
-			configuration.PGPassword,
-			configuration.PGHost,
-			configuration.PGPort,
-			configuration.PGDBName,
-			configuration.PGParams,
-		)
+		dataSource = postgresDataSource(configuration)
 	default:
 		err := fmt.Errorf("driver %v is not supported", driverName)
 		log.Err(err).Msg(canNotConnectToDataStorageMessage)
@@ -187,65 +327,238 @@ func initDatabaseConnection(configuration *StorageConfiguration) (*sql.DB, error
 		return nil, err
 	}
 
+	// each connection to in-memory SQLite database opens new empty
+	// database, so just one connection is used and it is never closed
+	if driverName == "sqlite3" && isSQLiteInMemory(dataSource) {
+		connection.SetMaxOpenConns(1)
+		connection.SetMaxIdleConns(1)
+		connection.SetConnMaxLifetime(0)
+		connection.SetConnMaxIdleTime(0)
+	}
+
 	return connection, nil
 }
 
-// displayMultipleRuleDisable function read and displays clusters where
-// multiple users have disabled some rules.
-func displayMultipleRuleDisable(connection *sql.DB, output string) error {
-	var fout *os.File
-	var writer *bufio.Writer
-
-	if output != "" {
-		// create output file
-		// disable G304 (CWE-22): Potential file inclusion via variable (Confidence: HIGH, Severity: MEDIUM)
-		fout, err := os.Create(output) // #nosec G304
-		if err != nil {
-			log.Error().Err(err).Msg(fileOpenMsg)
+// isSQLiteInMemory function checks if given SQLite data source refers to
+// in-memory database
+func isSQLiteInMemory(dataSource string) bool {
+	return dataSource == sqliteInMemory ||
+		strings.HasPrefix(dataSource, "file:"+sqliteInMemory) ||
+		strings.Contains(dataSource, "mode=memory")
+}
+
+// searchPathForStorage function returns search_path to be set for each
+// connection. Configured value takes precedence, otherwise the PostgreSQL
+// schema where tables for selected DB schema are stored is used.
+func searchPathForStorage(configuration *StorageConfiguration) string {
+	if configuration.SearchPath != "" {
+		return configuration.SearchPath
+	}
+	return databaseSchemaNames[configuration.Schema]
+}
+
+// postgresDataSource function constructs data source for PostgreSQL driver.
+// application_name, search_path, and configured session settings are passed
+// as run-time parameters, so they are set for every connection opened by the
+// connection pool.
+func postgresDataSource(configuration *StorageConfiguration) string {
+	applicationName := configuration.ApplicationName
+	if applicationName == "" {
+		applicationName = defaultApplicationName
+	}
+
+	runtimeParams := url.Values{}
+	// session settings configured for the selected operation
+	for name, value := range sessionSettings {
+		runtimeParams.Set(name, value)
+	}
+	runtimeParams.Set("application_name", applicationName)
+	if searchPath := searchPathForStorage(configuration); searchPath != "" {
+		runtimeParams.Set("search_path", searchPath)
+	}
+	// database refuses to modify data in read-only mode as well
+	if readOnlyMode {
+		runtimeParams.Set("default_transaction_read_only", "on")
+	}
+
+	params := runtimeParams.Encode()
+	if configuration.PGParams != "" {
+		params = configuration.PGParams + "&" + params
+	}
+
+	return fmt.Sprintf(
+		"postgresql://%v:%v@%v:%v/%v?%v",
+		configuration.PGUsername,
+		configuration.PGPassword,
+		configuration.PGHost,
+		configuration.PGPort,
+		configuration.PGDBName,
+		params,
+	)
+}
+
+// closeDatabaseConnection function closes connection to database, if it has
+// been established. Sessions that are not closed explicitly are kept open by
+// connection poolers (like PgBouncer) until they time out.
+func closeDatabaseConnection(connection *sql.DB) {
+	if connection == nil {
+		return
+	}
+
+	err := connection.Close()
+	if err != nil {
+		log.Err(err).Msg("Unable to close connection to database")
+		return
+	}
+	log.Debug().Msg("Connection to database closed")
+}
+
+// pingBackoff function returns delay before the first retry of ping
+func pingBackoff(configuration *StorageConfiguration) time.Duration {
+	// backoff has been checked together with the whole configuration
+	backoff, err := time.ParseDuration(configuration.PingBackoff)
+	if err != nil {
+		return defaultPingBackoff
+	}
+	return backoff
+}
+
+// pingDatabase function checks if database is reachable. sql.Open function
+// does not connect to database at all, so the connection needs to be checked
+// before any operation is started. Ping is retried with exponential backoff
+// when database is not reachable.
+func pingDatabase(connection *sql.DB, configuration *StorageConfiguration) error {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	backoff := pingBackoff(configuration)
+	var err error
+
+	for attempt := 0; attempt <= configuration.PingRetries; attempt++ {
+		if attempt > 0 {
+			log.Warn().
+				Err(err).
+				Int("retry", attempt).
+				Dur("delay", backoff).
+				Msg("Database is not reachable, ping will be retried")
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = connection.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
 		}
-		// an object used to write to file
-		writer = bufio.NewWriter(fout)
 	}
 
-	defer func() {
-		// output needs to be flushed at the end
-		if writer != nil {
-			err := writer.Flush()
-			if err != nil {
-				log.Error().Err(err).Msg(flushWriterMsg)
-			}
+	log.Err(err).Int("attempts", configuration.PingRetries+1).Msg(databaseUnreachable)
+	return fmt.Errorf("%w: %w", ErrDatabaseUnreachable, err)
+}
+
+// verifyDatabaseFingerprint function checks that the connection leads to
+// database specified by fingerprint in configuration. It prevents running
+// operations against wrong database when connection parameters are injected
+// from outside (by Clowder for example). Nothing is checked when fingerprint
+// is not specified.
+func verifyDatabaseFingerprint(connection *sql.DB, configuration *StorageConfiguration) error {
+	if configuration.Fingerprint == "" {
+		return nil
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return ErrNoConnection
+	}
+
+	query := configuration.FingerprintQuery
+	if strings.TrimSpace(query) == "" {
+		query = defaultFingerprintQuery
+	}
+
+	var fingerprint string
+	err := queryRowStatement(connection, query).Scan(&fingerprint)
+	if err != nil {
+		log.Err(err).Msg("Read database fingerprint")
+		return err
+	}
+
+	if fingerprint != configuration.Fingerprint {
+		err := &ErrFingerprintMismatch{
+			Expected: configuration.Fingerprint,
+			Actual:   fingerprint,
 		}
-	}()
+		log.Error().
+			Str("expected", configuration.Fingerprint).
+			Str("actual", fingerprint).
+			Msg("Database fingerprint mismatch")
+		return err
+	}
+
+	log.Debug().Str("fingerprint", fingerprint).Msg("Database fingerprint verified")
+	return nil
+}
 
+// queryRows function performs given query and calls the callback function
+// for each row returned by the query. The result set is always closed and
+// error reported by the result set after iteration is returned as well.
+func queryRows(connection *sql.DB, query string, args []interface{},
+	callback func(rows *sql.Rows) error) error {
+	return queryRowsContext(context.Background(), connection, query, args, callback)
+}
+
+// queryRowsContext function performs query the same way as queryRows, the
+// query is canceled when given context is done
+func queryRowsContext(ctx context.Context, connection *sql.DB, query string, args []interface{},
+	callback func(rows *sql.Rows) error) error {
+	rows, err := queryStatementContext(ctx, connection, query, args...)
+	if err != nil {
+		return err
+	}
+	return iterateRows(rows, callback)
+}
+
+// iterateRows function calls the callback function for each row from given
+// result set. Iteration stops on the first error returned by the callback.
+// The result set is closed in all cases.
+func iterateRows(rows *sql.Rows, callback func(rows *sql.Rows) error) (err error) {
 	defer func() {
-		// file needs to be closed at the end
-		if fout != nil {
-			err := fout.Close()
-			if err != nil {
-				log.Error().Err(err).Msg(fileCloseMsg)
+		closeErr := rows.Close()
+		if closeErr != nil {
+			log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
+			if err == nil {
+				err = closeErr
 			}
 		}
 	}()
 
-	// first query to be performed
-	query1 := `
-                select cluster_id, rule_id, count(*) as cnt
-                  from cluster_rule_toggle
-                 group by cluster_id, rule_id
-                having count(*)>1
-                 order by cnt desc;
-`
-	// second query to be performed
-	query2 := `
-                select cluster_id, rule_id, count(*) as cnt
-                  from cluster_user_rule_disable_feedback
-                 group by cluster_id, rule_id
-                having count(*)>1
-                 order by cnt desc;
-`
+	for rows.Next() {
+		err = callback(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// displayMultipleRuleDisable function read and displays clusters where
+// multiple users have disabled some rules.
+func displayMultipleRuleDisable(connection *sql.DB, output string, outputConfig OutputConfiguration) (err error) {
+	sink, err := createOutputSink(output, outputConfig)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// output is committed only when all records have been exported
+		err = errors.Join(err, closeOutputSink(sink, err == nil))
+	}()
 
 	// perform the first query and display results
-	err := performDisplayMultipleRuleDisable(connection, writer, query1,
+	err = performDisplayMultipleRuleDisable(connection, sink, selectMultipleRuleToggles,
 		"cluster_rule_toggle")
 	// the first query+display function might throw some error
 	if err != nil {
@@ -253,7 +566,7 @@ func displayMultipleRuleDisable(connection *sql.DB, output string) error {
 	}
 
 	// perform second query and display results
-	err = performDisplayMultipleRuleDisable(connection, writer, query2,
+	err = performDisplayMultipleRuleDisable(connection, sink, selectMultipleRuleDisableFeedbacks,
 		"cluster_user_rule_disable_feedback")
 	// second query+display function might throw some error
 	return err
@@ -262,15 +575,10 @@ func displayMultipleRuleDisable(connection *sql.DB, output string) error {
 // performDisplayMultipleRuleDisable function displays cluster names and org
 // ids where multiple users disabled any rule
 func performDisplayMultipleRuleDisable(connection *sql.DB,
-	writer *bufio.Writer, query string, tableName string) error {
-	// perform given query to database
-	rows, err := connection.Query(query)
-	if err != nil {
-		return err
-	}
-
-	// iterate over all records that has been found
-	for rows.Next() {
+	sink OutputSink, query string, tableName string) error {
+	// perform given query to database and iterate over all records that
+	// has been found
+	err := queryRows(connection, query, nil, func(rows *sql.Rows) error {
 		var (
 			clusterName string
 			ruleID      string
@@ -279,10 +587,6 @@ func performDisplayMultipleRuleDisable(connection *sql.DB,
 
 		// read one report
 		if err := rows.Scan(&clusterName, &ruleID, &count); err != nil {
-			// close the result set in case of any error
-			if closeErr := rows.Close(); closeErr != nil {
-				log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-			}
 			return err
 		}
 
@@ -301,384 +605,727 @@ func performDisplayMultipleRuleDisable(connection *sql.DB,
 			Int("count", count).
 			Msg("Multiple rule disable")
 
-		// export to file (if enabled)
-		if writer != nil {
-			_, err := fmt.Fprintf(writer, "%d,%s,%s,%d\n", orgID, clusterName, ruleID, count)
-			if err != nil {
-				log.Error().Err(err).Msg(writeToFileMsg)
-			}
+		// export to output (if enabled)
+		err = sink.WriteRecord(OutputRecord{
+			{"org_id", orgID},
+			{"cluster", clusterName},
+			{"rule_id", ruleID},
+			{"count", count},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg(writeToFileMsg)
 		}
+		return nil
+	})
+	if err != nil {
+		return queryFailed(tableName, err)
 	}
 	return nil
 }
 
-// readOrgID function tries to read organization ID for given cluster name
-func readOrgID(connection *sql.DB, clusterName string) (int, error) {
-	query := "select org_id from report where cluster = $1"
-
-	// perform the query
-	rows, err := connection.Query(query, clusterName)
+// displayDVONamespaceStatistics function reads and displays statistics about
+// DVO reports grouped by namespaces. Namespaces where the reports are not
+// refreshed for a long time are displayed first.
+func displayDVONamespaceStatistics(connection *sql.DB, output string, outputConfig OutputConfiguration) (err error) {
+	sink, err := createOutputSink(output, outputConfig)
 	if err != nil {
-		log.Debug().Msg("query")
-		return -1, err
+		return err
 	}
 
-	// and check the result (if any)
-	if rows.Next() {
-		var orgID int
+	defer func() {
+		// output is committed only when all records have been exported
+		err = errors.Join(err, closeOutputSink(sink, err == nil))
+	}()
+
+	return performDisplayDVONamespaceStatistics(connection, sink, outputConfig)
+}
 
-		// read one organization ID returned in query result
-		if err := rows.Scan(&orgID); err != nil {
-			// proper error logging will be performed elsewhere
-			log.Debug().Str(clusterNameMsg, clusterName).Msg("scan")
+// performDisplayDVONamespaceStatistics function performs the query to
+// dvo_report table and displays statistics for each namespace
+func performDisplayDVONamespaceStatistics(connection *sql.DB,
+	sink OutputSink, outputConfig OutputConfiguration) error {
+	// used to compute age of the last refresh
+	now := time.Now()
 
-			// close the result set in case of any error
-			if closeErr := rows.Close(); closeErr != nil {
-				log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-			}
-			return -1, err
+	// namespaces count
+	count := 0
+
+	// perform given query to database and iterate over all records that
+	// has been found
+	err := queryRows(connection, selectDVONamespaceStatistics, nil, func(rows *sql.Rows) error {
+		var (
+			namespaceID     string
+			namespaceName   sql.NullString
+			reports         int
+			oldestReported  sql.NullTime
+			newestChecked   sql.NullTime
+			objects         int
+			recommendations int
+		)
+
+		// read statistics for one namespace
+		if err := rows.Scan(&namespaceID, &namespaceName, &reports,
+			&oldestReported, &newestChecked, &objects, &recommendations); err != nil {
+			return err
+		}
+
+		// prepare for the report
+		oldestReportedF := ""
+		if oldestReported.Valid {
+			oldestReportedF = formatTimestamp(oldestReported.Time, outputConfig)
+		}
+		newestCheckedF := ""
+		age := ""
+		if newestChecked.Valid {
+			newestCheckedF = formatTimestamp(newestChecked.Time, outputConfig)
+			age = formatAge(now.Sub(newestChecked.Time), outputConfig)
 		}
 
-		return orgID, nil
+		// just print the statistics
+		log.Info().
+			Str(namespaceIDMsg, namespaceID).
+			Str(namespaceNameMsg, namespaceName.String).
+			Int(reportsCountMsg, reports).
+			Str("oldest reported", oldestReportedF).
+			Str("newest last checked", newestCheckedF).
+			Str(ageMsg, age).
+			Int(objectsMsg, objects).
+			Int(recommendationsMsg, recommendations).
+			Msg("DVO namespace statistics")
+
+		// export to output (if enabled)
+		err := sink.WriteRecord(OutputRecord{
+			{"namespace_id", namespaceID},
+			{"namespace_name", namespaceName.String},
+			{"reports", reports},
+			{"oldest_reported_at", oldestReportedF},
+			{"newest_last_checked_at", newestCheckedF},
+			{"age", age},
+			{"objects", objects},
+			{"recommendations", recommendations},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg(writeToFileMsg)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return queryFailed(dvoReportTable, err)
 	}
 
-	// no result?
-	log.Debug().Str(clusterNameMsg, clusterName).Msg("no org_id for cluster")
-	return -1, nil
+	log.Info().Int("namespaces count", count).Msg("DVO namespace statistics")
+	return nil
 }
 
-func createOutputFile(output string) (*os.File, *bufio.Writer) {
-	var fout *os.File
-	var writer *bufio.Writer
+// readOrgID function tries to read organization ID for given cluster name
+func readOrgID(connection *sql.DB, clusterName string) (int, error) {
+	// perform the query and read organization ID returned in query result
+	// (if any)
+	var orgID int
+	err := queryRowStatement(connection, selectOrgIDForCluster, clusterName).Scan(&orgID)
+
+	// no result?
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Debug().Str(clusterNameMsg, clusterName).Msg("no org_id for cluster")
+		return -1, nil
+	}
 
-	if output != "" {
-		// create output file
-		// disable G304 (CWE-22): Potential file inclusion via variable (Confidence: HIGH, Severity: MEDIUM)
-		fout, err := os.Create(output) // #nosec G304
-		if err != nil {
-			log.Error().Err(err).Msg(fileOpenMsg)
-		}
-		// an object used to write to file
-		writer = bufio.NewWriter(fout)
+	if err != nil {
+		// proper error logging will be performed elsewhere
+		log.Debug().Str(clusterNameMsg, clusterName).Msg("query")
+		return -1, err
 	}
-	return fout, writer
+
+	return orgID, nil
 }
 
 // displayAllOldRecords function read all old records, ie. records that are
 // older than the specified time duration. Those records are simply displayed.
-func displayAllOldRecords(connection *sql.DB, maxAge, output string, schema string) error {
+func displayAllOldRecords(connection *sql.DB, maxAge, output string, schema string, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listAllOldRecords(connection, maxAge, output, schema, outputConfig, filter)
+	return err
+}
+
+// listAllOldRecords function displays all old records the same way as
+// displayAllOldRecords and returns total number of listed records
+func listAllOldRecords(connection *sql.DB, maxAge, output string, schema string, outputConfig OutputConfiguration, filter ListingFilter) (total int, err error) {
 	// check if connection has been initialized
 	if connection == nil {
 		log.Error().Msg(connectionNotEstablished)
-		return errors.New(connectionNotEstablished)
+		return 0, ErrNoConnection
 	}
 
-	fout, writer := createOutputFile(output)
-
-	defer func() {
-		// output needs to be flushed at the end
-		if writer != nil {
-			err := writer.Flush()
-			if err != nil {
-				log.Error().Err(err).Msg(flushWriterMsg)
-			}
-		}
-	}()
+	sink, err := createOutputSink(output, outputConfig)
+	if err != nil {
+		return 0, err
+	}
 
 	defer func() {
-		// file needs to be closed at the end
-		if fout != nil {
-			err := fout.Close()
-			if err != nil {
-				log.Error().Err(err).Msg(fileCloseMsg)
-			}
-		}
+		// output is committed only when all records have been exported
+		err = errors.Join(err, closeOutputSink(sink, err == nil))
 	}()
 
+	var listings []func(ctx context.Context, sink OutputSink) (int, error)
 	switch schema {
 	case DBSchemaOCPRecommendations:
-		// main function of this tool is ability to delete old reports
-		err := performListOfOldOCPReports(connection, maxAge, writer)
-		// skip next operation on first error
-		if err != nil {
-			return err
+		listings = []func(ctx context.Context, sink OutputSink) (int, error){
+			// main function of this tool is ability to delete old reports
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldOCPReports(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
+			// but we might be interested in other tables as well, especially advisor ratings
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldRatings(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
+			// also but we might be interested in other consumer errors
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldConsumerErrors(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
+			// recommendations are deleted by cleanup-all as well
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldRecommendations(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
+			// and report info is deleted together with old reports
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldReportInfo(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
 		}
-
-		// but we might be interested in other tables as well, especially advisor ratings
-		err = performListOfOldRatings(connection, maxAge)
-		// skip next operation on first error
-		if err != nil {
-			return err
+	case DBSchemaDVORecommendations:
+		listings = []func(ctx context.Context, sink OutputSink) (int, error){
+			// main function of this tool is ability to delete old reports
+			func(ctx context.Context, sink OutputSink) (int, error) {
+				return listOldDVOReports(ctx, connection, maxAge, sink, outputConfig, filter)
+			},
 		}
+	default:
+		return 0, invalidSchema(schema)
+	}
 
-		// also but we might be interested in other consumer errors
-		err = performListOfOldConsumerErrors(connection, maxAge)
-		// skip next operation on first error
-		if err != nil {
+	// listings are independent on each other, so they are performed
+	// concurrently, each of them on its own connection from the pool. All
+	// listings write into the same sink, so writes are serialized. The
+	// remaining listings are canceled when one of them fails.
+	shared := &lockedSink{OutputSink: sink}
+	counts := make([]int, len(listings))
+	group, ctx := errgroup.WithContext(context.Background())
+	for i, listing := range listings {
+		i, listing := i, listing
+		group.Go(func() error {
+			count, err := listing(ctx, shared)
+			counts[i] = count
 			return err
-		}
+		})
+	}
+	// the first error is returned when all listings finish
+	err = group.Wait()
+	for _, count := range counts {
+		total += count
+	}
+	if err != nil {
+		return total, err
+	}
+
+	// records with timestamp in the future are not listed as old records,
+	// they are reported separately
+	_, err = countFutureDatedRecords(connection, schema)
+	return total, err
+}
+
+// oldRecordsTablesForSchema function returns list of tables with old records
+// in given DB schema
+func oldRecordsTablesForSchema(schema string) ([]oldRecordsTable, error) {
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		return oldRecordsTablesOCP, nil
 	case DBSchemaDVORecommendations:
-		// main function of this tool is ability to delete old reports
-		err := performListOfOldDVOReports(connection, maxAge, writer)
-		// skip next operation on first error
-		if err != nil {
-			return err
-		}
+		return oldRecordsTablesDVO, nil
 	default:
-		return fmt.Errorf("Invalid database schema to be investigated: '%s'", schema)
+		return nil, invalidSchema(schema)
 	}
-
-	return nil
 }
 
-func listOldDatabaseRecords(connection *sql.DB, maxAge string,
-	writer *bufio.Writer, query string,
-	logEntry string, countLogEntry string,
-	callback func(rows *sql.Rows, writer *bufio.Writer) (int, error)) error {
-	log.Info().Msg(logEntry + " begin")
-	rows, err := connection.Query(query, maxAge)
-	if err != nil {
-		return err
+// countOldRecords function counts old records in all tables from given DB
+// schema. Only COUNT(*) queries are performed, so it is much faster than
+// listing all old records.
+func countOldRecords(connection *sql.DB, maxAge string, schema string, filter ListingFilter) ([]OldRecordsCount, error) {
+	// check if connection has been initialized
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return nil, ErrNoConnection
 	}
 
-	count, err := callback(rows, writer)
+	tables, err := oldRecordsTablesForSchema(schema)
 	if err != nil {
-		log.Error().Err(err).Msg("Query error")
-		return err
+		return nil, err
 	}
 
-	log.Info().Int(countLogEntry, count).Msg(logEntry + " end")
-	return nil
-}
-
-// performListOfOldOCPReports read and displays old records read from reported_at
-// table
-func performListOfOldOCPReports(connection *sql.DB, maxAge string, writer *bufio.Writer) error {
-	return listOldDatabaseRecords(connection, maxAge, writer, selectOldOCPReports, "List of old OCP reports", reportsCountMsg,
-		func(rows *sql.Rows, writer *bufio.Writer) (int, error) {
-			// used to compute a real record age
-			now := time.Now()
-
-			// reports count
-			count := 0
-
-			// iterate over all old records
-			for rows.Next() {
-				var (
-					clusterName string
-					reported    time.Time
-					lastChecked time.Time
-				)
-
-				// read one old record from the report table
-				if err := rows.Scan(&clusterName, &reported, &lastChecked); err != nil {
-					// close the result set in case of any error
-					if closeErr := rows.Close(); closeErr != nil {
-						log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-					}
-					return count, err
-				}
-
-				// compute the real record age
-				age := int(math.Ceil(now.Sub(reported).Hours() / 24)) // in days
-
-				// prepare for the report
-				reportedF := reported.Format(time.RFC3339)
-				lastCheckedF := lastChecked.Format(time.RFC3339)
-
-				// just print the report
-				log.Info().Str(clusterNameMsg, clusterName).
-					Str(reportedMsg, reportedF).
-					Str(lastCheckedMsg, lastCheckedF).
-					Int(ageMsg, age).
-					Msg("Old OCP report")
-
-				if writer != nil {
-					_, err := fmt.Fprintf(writer, "%s,%s,%s,%d\n", clusterName, reportedF, lastCheckedF, age)
-					if err != nil {
-						log.Error().Err(err).Msg(writeToFileMsg)
-					}
-				}
-				count++
-			}
-			return count, nil
-		})
-}
+	counts := make([]OldRecordsCount, 0, len(tables))
+	for _, table := range tables {
+		query, args := countOldRecordsQuery(table, maxAge, filter)
 
-// performListOfOldDVOReports read and displays old records read from dvo.dvo_report
-// table
-func performListOfOldDVOReports(connection *sql.DB, maxAge string, writer *bufio.Writer) error {
-	return listOldDatabaseRecords(connection, maxAge, writer, selectOldDVOReports, "List of old DVO reports", reportsCountMsg,
-		func(rows *sql.Rows, writer *bufio.Writer) (int, error) {
-			// used to compute a real record age
-			now := time.Now()
-
-			// reports count
-			count := 0
-
-			// iterate over all old records
-			for rows.Next() {
-				var (
-					orgID       int
-					clusterName string
-					reported    time.Time
-					lastChecked time.Time
-				)
-
-				// read one old record from the report table
-				if err := rows.Scan(&orgID, &clusterName, &reported, &lastChecked); err != nil {
-					// close the result set in case of any error
-					if closeErr := rows.Close(); closeErr != nil {
-						log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-					}
-					return count, err
-				}
-
-				// compute the real record age
-				age := int(math.Ceil(now.Sub(reported).Hours() / 24)) // in days
-
-				// prepare for the report
-				reportedF := reported.Format(time.RFC3339)
-				lastCheckedF := lastChecked.Format(time.RFC3339)
-
-				// just print the report
-				log.Info().Str(clusterNameMsg, clusterName).
-					Str(reportedMsg, reportedF).
-					Str(lastCheckedMsg, lastCheckedF).
-					Int(ageMsg, age).
-					Msg("Old DVO report")
-
-				if writer != nil {
-					_, err := fmt.Fprintf(writer, "%d,%s,%s,%s,%d\n", orgID, clusterName, reportedF, lastCheckedF, age)
-					if err != nil {
-						log.Error().Err(err).Msg(writeToFileMsg)
-					}
-				}
-				count++
-			}
-			return count, nil
-		})
-}
+		var count int
+		err := queryRowStatement(connection, query, args...).Scan(&count)
+		if err != nil {
+			log.Error().Err(err).Str(tableName, table.table).Msg("Count old records")
+			return nil, queryFailed(table.table, err)
+		}
 
-// performListOfOldRatings read and displays old Advisor ratings read from
-// advisor_ratings table
-func performListOfOldRatings(connection *sql.DB, maxAge string) error {
-	return listOldDatabaseRecords(connection, maxAge, nil, selectOldAdvisorRatings, "List of old Advisor ratings", "ratings count",
-		func(rows *sql.Rows, _ *bufio.Writer) (int, error) {
-			// used to compute a real record age
-			now := time.Now()
-
-			// reports count
-			count := 0
-
-			// iterate over all old records
-			for rows.Next() {
-				var (
-					orgID         string
-					ruleFQDN      string
-					errorKey      string
-					ruleID        string
-					rating        int
-					lastUpdatedAt time.Time
-				)
-
-				// read one old record from the report table
-				if err := rows.Scan(&orgID, &ruleFQDN, &errorKey, &ruleID, &rating, &lastUpdatedAt); err != nil {
-					// close the result set in case of any error
-					if closeErr := rows.Close(); closeErr != nil {
-						log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-					}
-					return count, err
-				}
-
-				// compute the real error age
-				age := int(math.Ceil(now.Sub(lastUpdatedAt).Hours() / 24)) // in days
-
-				// prepare for the report
-				lastUpdatedAtF := lastUpdatedAt.Format(time.RFC3339)
-
-				// just print the report
-				log.Info().
-					Str("organization", orgID).
-					Str("rule FQDN", ruleFQDN).
-					Str("error key", errorKey).
-					Int("rating", rating).
-					Str("updated at", lastUpdatedAtF).
-					Int("rating age", age).
-					Msg("Old Advisor rating")
-				count++
-			}
-			return count, nil
+		log.Info().
+			Str(tableName, table.table).
+			Int(countMsg, count).
+			Msg(table.category)
+		recordOldRecords(table.table, count)
+
+		counts = append(counts, OldRecordsCount{
+			Category: table.category,
+			Table:    table.table,
+			Count:    count,
 		})
+	}
+	return counts, nil
 }
 
-// performListOfOldConsumerErrors read and displays consumer errors stored in
-// consumer_errors table
-func performListOfOldConsumerErrors(connection *sql.DB, maxAge string) error {
-	return listOldDatabaseRecords(connection, maxAge, nil, selectOldConsumerErrors, "List of old consumer errors", "errors count",
-		func(rows *sql.Rows, _ *bufio.Writer) (int, error) {
-			// used to compute a real record age
-			now := time.Now()
-
-			// reports count
-			count := 0
-
-			// iterate over all old records
-			for rows.Next() {
-				var (
-					topic      string
-					partition  int
-					offset     int
-					key        string
-					consumedAt time.Time
-					message    string
-				)
-
-				// read one old record from the report table
-				if err := rows.Scan(&topic, &partition, &offset, &key, &consumedAt, &message); err != nil {
-					// close the result set in case of any error
-					if closeErr := rows.Close(); closeErr != nil {
-						log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
-					}
-					return count, err
-				}
-
-				// compute the real error age
-				age := int(math.Ceil(now.Sub(consumedAt).Hours() / 24)) // in days
-
-				// prepare for the report
-				consumedF := consumedAt.Format(time.RFC3339)
-
-				// just print the report
-				log.Info().
-					Str("topic", topic).
-					Int("partition", partition).
-					Int("offset", offset).
-					Str("key", key).
-					Str("message", message).
-					Str("consumed", consumedF).
-					Int("error age", age).
-					Msg("Old consumer error")
-				count++
+// countFutureDatedRecords function counts records with timestamp in the
+// future in all tables from given DB schema. Such records are caused by
+// clock skew, their age would be negative. They are never deleted by
+// age-based cleanup.
+func countFutureDatedRecords(connection *sql.DB, schema string) ([]OldRecordsCount, error) {
+	// check if connection has been initialized
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return nil, ErrNoConnection
+	}
+
+	tables, err := oldRecordsTablesForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]OldRecordsCount, 0, len(tables))
+	for _, table := range tables {
+		// it is not possible to use parameter for table name or a column
+		// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+		// #nosec G202
+		query := "SELECT COUNT(*) FROM " + table.source() +
+			" WHERE " + table.timestampColumn + " > NOW()"
+
+		var count int
+		err := queryRowStatement(connection, query).Scan(&count)
+		if err != nil {
+			log.Error().Err(err).Str(tableName, table.table).Msg("Count future-dated records")
+			return nil, queryFailed(table.table, err)
+		}
+
+		if count > 0 {
+			log.Warn().
+				Str(tableName, table.table).
+				Int(countMsg, count).
+				Msg(table.futureCategory)
+		}
+
+		counts = append(counts, OldRecordsCount{
+			Category: table.futureCategory,
+			Table:    table.table,
+			Count:    count,
+		})
+	}
+	return counts, nil
+}
+
+// countOldRecordsQuery function constructs query that counts old records in
+// given table. Age limits from listing filter are applied by the query
+// itself.
+func countOldRecordsQuery(table oldRecordsTable, maxAge string, filter ListingFilter) (string, []interface{}) {
+	condition, args := oldRecordsCondition(table.timestampColumn, nil, maxAge, filter)
+
+	// it is not possible to use parameter for table name or a column
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	query := "SELECT COUNT(*) FROM " + table.source() + " WHERE " + condition
+	return query, args
+}
+
+// oldRecordsCondition function constructs condition that selects old records
+// by given timestamp column. Parameters of the condition are appended to
+// given query parameters, so they are numbered accordingly.
+func oldRecordsCondition(column string, args []interface{}, maxAge string, filter ListingFilter) (string, []interface{}) {
+	args = append(args, maxAge)
+	condition := fmt.Sprintf("%s < NOW() - $%d::INTERVAL", column, len(args))
+
+	if filter.OlderThan > 0 {
+		args = append(args, intervalSeconds(filter.OlderThan))
+		condition += fmt.Sprintf(" AND %s < NOW() - $%d::INTERVAL", column, len(args))
+	}
+	if filter.NewerThan > 0 {
+		args = append(args, intervalSeconds(filter.NewerThan))
+		condition += fmt.Sprintf(" AND %s > NOW() - $%d::INTERVAL", column, len(args))
+	}
+	return condition, args
+}
+
+// intervalSeconds function converts duration into PostgreSQL interval
+func intervalSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(duration/time.Second))
+}
+
+// listOldDatabaseRecords function performs query to select old records and
+// calls the callback function for each record found. The callback function
+// returns true when the record has been listed (ie. it passed the listing
+// filter). Number of listed records is returned.
+func listOldDatabaseRecords(ctx context.Context, connection *sql.DB, maxAge string,
+	sink OutputSink, query string, table string,
+	logEntry string, countLogEntry string, filter ListingFilter,
+	callback func(rows *sql.Rows, sink OutputSink) (bool, error)) (int, error) {
+	log.Info().Msg(logEntry + " begin")
+
+	// records count
+	count := 0
+
+	args := []interface{}{maxAge}
+	// records are ordered by their age, so the limit can be applied by
+	// database when no other filter is used
+	if filter.Limit > 0 && !filter.filtersByAge() {
+		query += " LIMIT $2"
+		args = append(args, filter.Limit)
+	}
+
+	err := queryRowsContext(ctx, connection, query, args, func(rows *sql.Rows) error {
+		// enough of the oldest records have already been listed
+		if filter.Limit > 0 && count >= filter.Limit {
+			return errListingLimitReached
+		}
+		listed, err := callback(rows, sink)
+		if listed {
+			count++
+		}
+		return err
+	})
+	if errors.Is(err, errListingLimitReached) {
+		err = nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Query error")
+		return count, queryFailed(table, err)
+	}
+
+	log.Info().Int(countLogEntry, count).Msg(logEntry + " end")
+	recordOldRecords(table, count)
+	return count, nil
+}
+
+// scanOldOCPReport function reads one old record from the report table
+func scanOldOCPReport(rows *sql.Rows, now time.Time) (OldOCPReport, error) {
+	var report OldOCPReport
+
+	err := rows.Scan(&report.ClusterName, &report.Reported, &report.LastChecked)
+	if err != nil {
+		return report, err
+	}
+
+	// compute the real record age
+	report.Age = now.Sub(report.Reported)
+	return report, nil
+}
+
+// scanOldDVOReport function reads one old record from the dvo_report
+// table
+func scanOldDVOReport(rows *sql.Rows, now time.Time) (OldDVOReport, error) {
+	var (
+		report        OldDVOReport
+		namespaceName sql.NullString
+	)
+
+	err := rows.Scan(&report.OrgID, &report.ClusterName, &report.NamespaceID, &namespaceName,
+		&report.Recommendations, &report.Objects, &report.Reported, &report.LastChecked)
+	if err != nil {
+		return report, err
+	}
+
+	// namespace name is optional
+	report.NamespaceName = namespaceName.String
+
+	// compute the real record age
+	report.Age = now.Sub(report.Reported)
+	return report, nil
+}
+
+// scanOldRating function reads one old record from the advisor_ratings table
+func scanOldRating(rows *sql.Rows, now time.Time) (OldRating, error) {
+	var rating OldRating
+
+	err := rows.Scan(&rating.OrgID, &rating.RuleFQDN, &rating.ErrorKey, &rating.RuleID,
+		&rating.Rating, &rating.LastUpdatedAt)
+	if err != nil {
+		return rating, err
+	}
+
+	// compute the real rating age
+	rating.Age = now.Sub(rating.LastUpdatedAt)
+	return rating, nil
+}
+
+// scanOldConsumerError function reads one old record from the
+// consumer_error table
+func scanOldConsumerError(rows *sql.Rows, now time.Time) (OldConsumerError, error) {
+	var consumerError OldConsumerError
+
+	err := rows.Scan(&consumerError.Topic, &consumerError.Partition, &consumerError.Offset,
+		&consumerError.Key, &consumerError.ConsumedAt, &consumerError.Message)
+	if err != nil {
+		return consumerError, err
+	}
+
+	// compute the real error age
+	consumerError.Age = now.Sub(consumerError.ConsumedAt)
+	return consumerError, nil
+}
+
+// scanOldRecommendation function reads one old record from the
+// recommendation table
+func scanOldRecommendation(rows *sql.Rows, now time.Time) (OldRecommendation, error) {
+	var recommendation OldRecommendation
+
+	err := rows.Scan(&recommendation.OrgID, &recommendation.ClusterName, &recommendation.RuleFQDN,
+		&recommendation.ErrorKey, &recommendation.RuleID, &recommendation.CreatedAt)
+	if err != nil {
+		return recommendation, err
+	}
+
+	// compute the real recommendation age
+	recommendation.Age = now.Sub(recommendation.CreatedAt)
+	return recommendation, nil
+}
+
+// scanOldReportInfo function reads one record from the report_info table
+// together with timestamp of report it belongs to
+func scanOldReportInfo(rows *sql.Rows, now time.Time) (OldReportInfo, error) {
+	var reportInfo OldReportInfo
+
+	err := rows.Scan(&reportInfo.OrgID, &reportInfo.ClusterName, &reportInfo.VersionInfo,
+		&reportInfo.Reported)
+	if err != nil {
+		return reportInfo, err
+	}
+
+	// compute the real report age
+	reportInfo.Age = now.Sub(reportInfo.Reported)
+	return reportInfo, nil
+}
+
+// performListOfOldOCPReports read and displays old records read from reported_at
+// table
+func performListOfOldOCPReports(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldOCPReports(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldOCPReports function lists old records the same way as
+// performListOfOldOCPReports and returns number of listed records
+func listOldOCPReports(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldOCPReports, "report", "List of old OCP reports", reportsCountMsg, filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			report, err := scanOldOCPReport(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(report.Age) {
+				return false, nil
+			}
+
+			displayOldOCPReport(report, sink, outputConfig)
+			return true, nil
+		})
+}
+
+// performListOfOldDVOReports read and displays old records read from dvo_report
+// table. Namespace and number of recommendations and objects are displayed
+// for each record, so it is possible to find namespaces that dominate the
+// storage.
+func performListOfOldDVOReports(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldDVOReports(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldDVOReports function lists old records the same way as
+// performListOfOldDVOReports and returns number of listed records
+func listOldDVOReports(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldDVOReports, "dvo_report", "List of old DVO reports", reportsCountMsg, filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			report, err := scanOldDVOReport(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(report.Age) {
+				return false, nil
+			}
+
+			displayOldDVOReport(report, sink, outputConfig)
+			return true, nil
+		})
+}
+
+// performListOfOldRatings read and displays old Advisor ratings read from
+// advisor_ratings table
+func performListOfOldRatings(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldRatings(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldRatings function lists old records the same way as
+// performListOfOldRatings and returns number of listed records
+func listOldRatings(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldAdvisorRatings, advisorRatingsTable, "List of old Advisor ratings", "ratings count", filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			rating, err := scanOldRating(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(rating.Age) {
+				return false, nil
 			}
-			return count, nil
+
+			displayOldRating(rating, sink, outputConfig)
+			return true, nil
+		})
+}
+
+// performListOfOldConsumerErrors read and displays consumer errors stored in
+// consumer_errors table
+func performListOfOldConsumerErrors(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldConsumerErrors(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldConsumerErrors function lists old records the same way as
+// performListOfOldConsumerErrors and returns number of listed records
+func listOldConsumerErrors(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldConsumerErrors, "consumer_error", "List of old consumer errors", "errors count", filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			consumerError, err := scanOldConsumerError(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(consumerError.Age) {
+				return false, nil
+			}
+
+			displayOldConsumerError(consumerError, sink, outputConfig)
+			return true, nil
+		})
+}
+
+// performListOfOldRecommendations read and displays old recommendations read
+// from recommendation table
+func performListOfOldRecommendations(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldRecommendations(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldRecommendations function lists old records the same way as
+// performListOfOldRecommendations and returns number of listed records
+func listOldRecommendations(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldRecommendations, recommendationTable, "List of old recommendations", "recommendations count", filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			recommendation, err := scanOldRecommendation(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(recommendation.Age) {
+				return false, nil
+			}
+
+			displayOldRecommendation(recommendation, sink, outputConfig)
+			return true, nil
+		})
+}
+
+// performListOfOldReportInfo read and displays records read from
+// report_info table that belong to old reports
+func performListOfOldReportInfo(connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) error {
+	_, err := listOldReportInfo(context.Background(), connection, maxAge, sink, outputConfig, filter)
+	return err
+}
+
+// listOldReportInfo function lists old records the same way as
+// performListOfOldReportInfo and returns number of listed records
+func listOldReportInfo(ctx context.Context, connection *sql.DB, maxAge string, sink OutputSink, outputConfig OutputConfiguration, filter ListingFilter) (int, error) {
+	// used to compute a real record age
+	now := time.Now()
+
+	return listOldDatabaseRecords(ctx, connection, maxAge, sink, selectOldReportInfo, reportInfoTable, "List of old report info", "report info count", filter,
+		func(rows *sql.Rows, sink OutputSink) (bool, error) {
+			reportInfo, err := scanOldReportInfo(rows, now)
+			if err != nil {
+				return false, err
+			}
+
+			// skip records that do not pass the listing filter
+			if !filter.Matches(reportInfo.Age) {
+				return false, nil
+			}
+
+			displayOldReportInfo(reportInfo, sink, outputConfig)
+			return true, nil
 		})
 }
 
 // deleteRecordFromTable function deletes selected records (identified by
 // cluster name) from database
-func deleteRecordFromTable(connection *sql.DB, table, key string, clusterName ClusterName) (int, error) {
-	// it is not possible to use parameter for table name or a key
-	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
-	// #nosec G202
-	sqlStatement := "DELETE FROM " + table + " WHERE " + key + " = $1;"
+func deleteRecordFromTable(connection sqlExecutor, table, key string, clusterName ClusterName) (int, error) {
+	sqlStatement := deleteByKeyStatement(table, key)
 
 	// perform the SQL statement
 	// #nosec G202
-	result, err := connection.Exec(sqlStatement, clusterName)
+	result, err := execStatement(connection, sqlStatement, clusterName)
+	if err != nil {
+		return 0, queryFailed(table, err)
+	}
+
+	// read number of affected (deleted) rows
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return 0, err
 	}
+	return int(affected), nil
+}
+
+// deleteRecordFromTableForOrg function deletes selected records (identified
+// by cluster name and organization ID) from database
+func deleteRecordFromTableForOrg(connection sqlExecutor, table, key, orgKey string, clusterName ClusterName, orgID int) (int, error) {
+	sqlStatement := deleteByKeyAndOrgStatement(table, key, orgKey)
+
+	// perform the SQL statement
+	// #nosec G202
+	result, err := execStatement(connection, sqlStatement, clusterName, orgID)
+	if err != nil {
+		return 0, queryFailed(table, err)
+	}
 
 	// read number of affected (deleted) rows
 	affected, err := result.RowsAffected()
@@ -710,21 +1357,29 @@ var (
 
 	tablesToDeleteDVO = []TableAndDeleteStatement{
 		{
-			TableName:       "dvo.dvo_report",
+			TableName:       "dvo_report",
 			DeleteStatement: deleteOldDVOReports,
 		},
 	}
-	allTablesToDelete = append(tablesToDeleteOCP, tablesToDeleteDVO...)
 )
 
+// deleteStatementPrefixPattern is a regular expression used to turn delete
+// statement into query that counts records to be deleted
+var deleteStatementPrefixPattern = regexp.MustCompile(`(?i)\bDELETE\s+FROM\b`)
+
 // deleteOldRecordsFromTable function deletes old records from database
 // each delete query must have just one parameter that will be populated with
 // the maxAge value
 func deleteOldRecordsFromTable(connection *sql.DB, sqlStatement, maxAge string, dryRun bool) (int, error) {
+	// records are just counted in dry run mode; query is used, so it is
+	// allowed in read-only mode too
 	if dryRun {
-		sqlStatement = strings.Replace(sqlStatement, "DELETE", "SELECT", -1)
+		var count int
+		sqlStatement = deleteStatementPrefixPattern.ReplaceAllString(sqlStatement, "SELECT COUNT(*) FROM")
+		err := queryRowStatement(connection, sqlStatement, maxAge).Scan(&count)
+		return count, err
 	}
-	result, err := connection.Exec(sqlStatement, maxAge)
+	result, err := execStatement(connection, sqlStatement, maxAge)
 	if err != nil {
 		return 0, err
 	}
@@ -773,8 +1428,9 @@ var tablesAndKeysInOCPDatabase = []TableAndKey{
 
 var tablesAndKeysInDVODatabase = []TableAndKey{
 	{
-		TableName: "dvo_report",
-		KeyName:   "cluster_id",
+		TableName:  "dvo_report",
+		KeyName:    "cluster_id",
+		OrgKeyName: "org_id",
 	},
 }
 
@@ -784,7 +1440,7 @@ func performVacuumDB(connection *sql.DB) error {
 	sqlStatement := "VACUUM VERBOSE;"
 
 	// perform the SQL statement
-	_, err := connection.Exec(sqlStatement)
+	_, err := execStatement(connection, sqlStatement)
 	if err != nil {
 		return err
 	}
@@ -792,28 +1448,117 @@ func performVacuumDB(connection *sql.DB) error {
 	return nil
 }
 
-// performCleanupInDB function cleans up all data for selected cluster names
+// checkManagedTables function checks if all given tables are managed by the
+// cleaner in selected DB schema
+func checkManagedTables(tables []string, schema string) error {
+	managed, err := managedTables(schema)
+	if err != nil {
+		return err
+	}
+	managedSet := make(StringSet)
+	for _, table := range managed {
+		managedSet[table] = struct{}{}
+	}
+	for _, table := range tables {
+		if _, found := managedSet[table]; !found {
+			return fmt.Errorf("table %s is not managed by the cleaner in DB schema %s", table, schema)
+		}
+	}
+	return nil
+}
+
+// performVacuumFullInDB function rewrites selected tables by VACUUM FULL, so
+// space occupied by deleted records is returned to operating system. Only
+// tables managed by the cleaner in the selected DB schema can be vacuumed.
+// Tables are vacuumed one by one and vacuuming continues with the next table
+// when any table fails, names of vacuumed tables are returned.
+func performVacuumFullInDB(connection *sql.DB, tables []string, schema string) ([]string, error) {
+	var vacuumed []string
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return vacuumed, ErrNoConnection
+	}
+
+	if len(tables) == 0 {
+		return vacuumed, errors.New("no table selected for VACUUM FULL")
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return vacuumed, err
+	}
+
+	// VACUUM FULL locks the whole table, so only known tables are allowed
+	err = checkManagedTables(tables, schema)
+	if err != nil {
+		return vacuumed, err
+	}
+
+	log.Info().Strs("tables", tables).Msg("Vacuuming full started")
+	var errs []error
+	for _, table := range tables {
+		sqlStatement := "VACUUM (FULL, VERBOSE) " +
+			pq.QuoteIdentifier(databaseSchema) + "." + pq.QuoteIdentifier(table) + ";"
+
+		started := time.Now()
+		_, err := execStatement(connection, sqlStatement)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, table).
+				Msg("Unable to vacuum table")
+			errs = append(errs, queryFailed(table, err))
+			continue
+		}
+		log.Info().
+			Str(tableName, table).
+			Dur("duration", time.Since(started)).
+			Msg("Table vacuumed")
+		vacuumed = append(vacuumed, table)
+	}
+	log.Info().Msg("Vacuuming full finished")
+	return vacuumed, errors.Join(errs...)
+}
+
+// performCleanupInDB function cleans up all data for selected cluster names.
+// When organization ID is specified (ie. it is not zero), rows from tables
+// with composite key are deleted only for the selected organization. Clusters
+// that can not be cleaned up (because of deadlock, timeout etc.) are requeued
+// and cleanup is retried at the end of the run up to given number of retries.
+// Clusters that still can not be cleaned up are returned as failed together
+// with aggregated error containing the last failure of each such cluster. In
+// transactional mode records for each cluster are deleted in one transaction.
 func performCleanupInDB(connection *sql.DB,
-	clusterList ClusterList, schema string) (map[string]int, error) {
-	// return value
+	clusterList ClusterList, schema string, orgID int, retries int, transactional bool) (
+	map[string]int, ClusterList, error) {
+	deletionsForTable, _, failedClusters, err := performClustersCleanupInDB(connection,
+		clusterList, schema, orgID, retries, transactional)
+	return deletionsForTable, failedClusters, err
+}
+
+// performClustersCleanupInDB function cleans up all data for selected cluster
+// names the same way as performCleanupInDB. Number of rows deleted for each
+// cluster from each table is returned as well, so it is possible to find
+// clusters that did not have any rows in database.
+func performClustersCleanupInDB(connection *sql.DB,
+	clusterList ClusterList, schema string, orgID int, retries int, transactional bool) (
+	map[string]int, DeletionMatrix, ClusterList, error) {
+	// return values
 	deletionsForTable := make(map[string]int)
+	deletionsForCluster := make(DeletionMatrix)
+	var failedClusters ClusterList
 
 	// check if connection has been initialized
 	if connection == nil {
 		log.Error().Msg(connectionNotEstablished)
-		return deletionsForTable, errors.New(connectionNotEstablished)
+		return deletionsForTable, deletionsForCluster, failedClusters, ErrNoConnection
 	}
 
-	// this is actually shorter than using map + map selector + test for key existence
-	// and it allow us to do fine tuning for (any) DB schema in future
-	var tablesAndKeys []TableAndKey
-	switch schema {
-	case DBSchemaOCPRecommendations:
-		tablesAndKeys = tablesAndKeysInOCPDatabase
-	case DBSchemaDVORecommendations:
-		tablesAndKeys = tablesAndKeysInDVODatabase
-	default:
-		return deletionsForTable, fmt.Errorf(invalidSchemaMsg, schema)
+	// built-in and plug-in schemas have different sets of tables
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return deletionsForTable, deletionsForCluster, failedClusters, err
 	}
 
 	// initialize counters
@@ -823,34 +1568,110 @@ func performCleanupInDB(connection *sql.DB,
 
 	// perform cleanup for selected cluster names
 	log.Info().Msg("Cleanup started")
-	for _, clusterName := range clusterList {
-		for _, tableAndKey := range tablesAndKeys {
-			// try to delete record from selected table
-			affected, err := deleteRecordFromTable(connection,
-				tableAndKey.TableName,
-				tableAndKey.KeyName,
-				clusterName)
-			if err != nil {
-				log.Error().
-					Err(err).
-					Str(tableName, tableAndKey.TableName).
-					Msg("Unable to delete record")
+	pending := clusterList
+	clusterErrors := make(map[ClusterName]error)
+	for attempt := 0; attempt <= retries && len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			log.Warn().
+				Int("attempt", attempt).
+				Int("clusters", len(pending)).
+				Msg("Retrying cleanup of requeued clusters")
+		}
+
+		// clusters that failed are requeued for next attempt
+		var requeued ClusterList
+		for _, clusterName := range pending {
+			// rows deleted for this cluster only
+			deletedFromTables := make(map[string]int)
+			var err error
+			if transactional {
+				err = cleanupClusterInTransaction(connection, tablesAndKeys, clusterName, orgID, deletedFromTables)
 			} else {
-				log.Info().
-					Int(affectedMsg, affected).
-					Str(tableName, tableAndKey.TableName).
-					Str(clusterNameMsg, string(clusterName)).
-					Msg("Delete record")
-				deletionsForTable[tableAndKey.TableName] += affected
+				err = cleanupCluster(connection, tablesAndKeys, clusterName, orgID, deletedFromTables)
+			}
+			deletionsForCluster.add(clusterName, deletedFromTables)
+			for table, deleted := range deletedFromTables {
+				deletionsForTable[table] += deleted
+			}
+			if err != nil {
+				clusterErrors[clusterName] = err
+				requeued = append(requeued, clusterName)
 			}
 		}
+		pending = requeued
+	}
+
+	// clusters that were not cleaned up even after all retries
+	var errs []error
+	for _, clusterName := range pending {
+		log.Error().
+			Str(clusterNameMsg, string(clusterName)).
+			Int(orgIDMsg, orgID).
+			Msg("Cleanup failed for cluster")
+		failedClusters = append(failedClusters, clusterName)
+		errs = append(errs, fmt.Errorf("cluster %s: %w", clusterName, clusterErrors[clusterName]))
 	}
 	log.Info().Msg("Cleanup finished")
-	return deletionsForTable, nil
+	return deletionsForTable, deletionsForCluster, failedClusters, errors.Join(errs...)
+}
+
+// deletedRowsCount function returns number of rows deleted from all tables
+func deletedRowsCount(deletionsForTable map[string]int) int {
+	total := 0
+	for _, deletions := range deletionsForTable {
+		total += deletions
+	}
+	return total
 }
 
-// performCleanupAllInDB function cleans up all data for all cluster names
-func performCleanupAllInDB(connection *sql.DB, maxAge string, dryRun bool) (
+// cleanupCluster function deletes records for selected cluster from all
+// given tables. Deleting continues with the next table when any deletion
+// fails, all errors are returned aggregated in this case.
+func cleanupCluster(connection *sql.DB, tablesAndKeys []TableAndKey,
+	clusterName ClusterName, orgID int, deletionsForTable map[string]int) error {
+	var errs []error
+
+	for _, tableAndKey := range tablesAndKeys {
+		// try to delete record from selected table
+		var affected int
+		var err error
+		if orgID != 0 && tableAndKey.OrgKeyName != "" {
+			affected, err = deleteRecordFromTableForOrg(connection,
+				tableAndKey.TableName,
+				tableAndKey.KeyName,
+				tableAndKey.OrgKeyName,
+				clusterName, orgID)
+		} else {
+			affected, err = deleteRecordFromTable(connection,
+				tableAndKey.TableName,
+				tableAndKey.KeyName,
+				clusterName)
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, tableAndKey.TableName).
+				Str(clusterNameMsg, string(clusterName)).
+				Msg("Unable to delete record")
+			errs = append(errs, err)
+		} else {
+			log.Info().
+				Int(affectedMsg, affected).
+				Str(tableName, tableAndKey.TableName).
+				Str(clusterNameMsg, string(clusterName)).
+				Int(orgIDMsg, orgID).
+				Msg("Delete record")
+			deletionsForTable[tableAndKey.TableName] += affected
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// performCleanupAllInDB function cleans up all data for all cluster names.
+// Only tables from the selected DB schema are cleaned up. Cleanup continues
+// with the next table when deletion from any table fails, all failures are
+// returned as aggregated error.
+func performCleanupAllInDB(connection *sql.DB, maxAge, schema string, dryRun bool) (
 	map[string]int, error) {
 	deletionsForTable := make(map[string]int)
 	if maxAge == "" {
@@ -860,12 +1681,19 @@ func performCleanupAllInDB(connection *sql.DB, maxAge string, dryRun bool) (
 
 	if connection == nil {
 		log.Error().Msg(connectionNotEstablished)
-		return deletionsForTable, errors.New(connectionNotEstablished)
+		return deletionsForTable, ErrNoConnection
+	}
+
+	// OCP, DVO, and plug-in tables are stored in different databases
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return deletionsForTable, err
 	}
 
 	// perform cleanup for selected cluster names
 	log.Info().Msg("Cleanup-all started")
-	for _, tableAndDeleteStatement := range allTablesToDelete {
+	var errs []error
+	for _, tableAndDeleteStatement := range tablesToDelete {
 		// try to delete record from selected table
 		affected, err := deleteOldRecordsFromTable(connection,
 			tableAndDeleteStatement.DeleteStatement,
@@ -875,7 +1703,8 @@ func performCleanupAllInDB(connection *sql.DB, maxAge string, dryRun bool) (
 				Err(err).
 				Str(tableName, tableAndDeleteStatement.TableName).
 				Msg("Unable to delete records")
-			return deletionsForTable, err
+			errs = append(errs, queryFailed(tableAndDeleteStatement.TableName, err))
+			continue
 		}
 		log.Info().
 			Int(affectedMsg, affected).
@@ -885,9 +1714,430 @@ func performCleanupAllInDB(connection *sql.DB, maxAge string, dryRun bool) (
 		deletionsForTable[tableAndDeleteStatement.TableName] = affected
 	}
 	log.Info().Msg("Cleanup-all finished")
+	return deletionsForTable, errors.Join(errs...)
+}
+
+// performMaxAgeComparisonInDB function computes number of records that
+// would be deleted by cleanup-all for two different max age values. Records
+// are just counted, nothing is deleted.
+func performMaxAgeComparisonInDB(connection *sql.DB, maxAge, otherMaxAge, schema string) (
+	[]MaxAgeComparison, error) {
+	if maxAge == "" || otherMaxAge == "" {
+		return nil, errors.New(maxAgeMissing)
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return nil, ErrNoConnection
+	}
+
+	tablesToDelete, err := tablesToDeleteForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons := make([]MaxAgeComparison, 0, len(tablesToDelete))
+	for _, tableAndDeleteStatement := range tablesToDelete {
+		comparison := MaxAgeComparison{
+			Table: tableAndDeleteStatement.TableName,
+		}
+
+		// both values are evaluated in dry-run mode
+		comparison.Deletions, err = deleteOldRecordsFromTable(connection,
+			tableAndDeleteStatement.DeleteStatement, maxAge, true)
+		if err == nil {
+			comparison.OtherDeletions, err = deleteOldRecordsFromTable(connection,
+				tableAndDeleteStatement.DeleteStatement, otherMaxAge, true)
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, tableAndDeleteStatement.TableName).
+				Msg("Unable to count records")
+			return nil, queryFailed(tableAndDeleteStatement.TableName, err)
+		}
+
+		log.Info().
+			Str(tableName, comparison.Table).
+			Str("max age", maxAge).
+			Int("deletions", comparison.Deletions).
+			Str("other max age", otherMaxAge).
+			Int("other deletions", comparison.OtherDeletions).
+			Int("delta", comparison.Delta()).
+			Msg("Compare max age")
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+// tablesWithRuleReferences contains list of all tables in OCP database that
+// contain references to rules (by rule FQDN and error key)
+var tablesWithRuleReferences = []string{
+	"rule_hit",
+	"recommendation",
+	advisorRatingsTable,
+}
+
+// recordsCondition function returns WHERE condition and its arguments used
+// to select records belonging to given organization and/or referencing given
+// rule. Criteria with zero value are not used. All error keys are selected
+// when error key is not specified.
+func recordsCondition(orgID int, ruleFQDN, errorKey string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if orgID != 0 {
+		addCondition("org_id", orgID)
+	}
+	if ruleFQDN != "" {
+		addCondition("rule_fqdn", ruleFQDN)
+	}
+	if errorKey != "" {
+		addCondition("error_key", errorKey)
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// countRecordsInTable function counts records selected by given condition
+// in selected table
+func countRecordsInTable(connection *sql.DB, table, condition string, args []interface{}) (int, error) {
+	// it is not possible to use parameter for table name
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	query := "SELECT COUNT(*) FROM " + table + condition + ";"
+
+	var count int
+	err := queryRowStatement(connection, query, args...).Scan(&count)
+	if err != nil {
+		return 0, queryFailed(table, err)
+	}
+	return count, nil
+}
+
+// deleteRecordsFromTable function deletes records selected by given
+// condition from selected table
+func deleteRecordsFromTable(connection *sql.DB, table, condition string, args []interface{}) (int, error) {
+	// it is not possible to use parameter for table name
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	sqlStatement := "DELETE FROM " + table + condition + ";"
+
+	result, err := execStatement(connection, sqlStatement, args...)
+	if err != nil {
+		return 0, queryFailed(table, err)
+	}
+
+	// read number of affected (deleted) rows
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// countAndDeleteRecords function counts records selected by given condition
+// in all given tables and then deletes them. Nothing is deleted in dry run
+// mode, only the counts are returned. Deletion continues with the next table
+// when deletion from any table fails, all failures are returned as aggregated
+// error.
+func countAndDeleteRecords(connection *sql.DB, tables []string, condition string,
+	args []interface{}, dryRun bool) (map[string]int, error) {
+	deletionsForTable := make(map[string]int)
+
+	// count records to be deleted first
+	for _, table := range tables {
+		count, err := countRecordsInTable(connection, table, condition, args)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, table).
+				Msg("Unable to count records")
+			return deletionsForTable, err
+		}
+		log.Info().
+			Int("count", count).
+			Str(tableName, table).
+			Msg("Records to be deleted")
+		deletionsForTable[table] = count
+	}
+
+	if dryRun {
+		log.Info().Msg("Dry run, no records deleted")
+		return deletionsForTable, nil
+	}
+
+	// and then delete them
+	var errs []error
+	for _, table := range tables {
+		affected, err := deleteRecordsFromTable(connection, table, condition, args)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, table).
+				Msg("Unable to delete records")
+			errs = append(errs, err)
+			continue
+		}
+		log.Info().
+			Int(affectedMsg, affected).
+			Str(tableName, table).
+			Msg("Delete records")
+		deletionsForTable[table] = affected
+	}
+	return deletionsForTable, errors.Join(errs...)
+}
+
+// performRuleCleanupInDB function deletes all records referencing retired
+// rule (and optionally its error key). Records to be deleted are counted
+// first and nothing is deleted in dry run mode.
+func performRuleCleanupInDB(connection *sql.DB, ruleFQDN, errorKey, schema string, dryRun bool) (
+	map[string]int, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return map[string]int{}, ErrNoConnection
+	}
+
+	// rules are referenced from OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		return map[string]int{}, invalidSchema(schema)
+	}
+
+	log.Info().
+		Str(ruleFQDNMsg, ruleFQDN).
+		Str(errorKeyMsg, errorKey).
+		Msg("Rule cleanup started")
+
+	condition, args := recordsCondition(0, ruleFQDN, errorKey)
+	deletionsForTable, err := countAndDeleteRecords(connection, tablesWithRuleReferences, condition, args, dryRun)
+	if err != nil {
+		return deletionsForTable, err
+	}
+
+	log.Info().Msg("Rule cleanup finished")
 	return deletionsForTable, nil
 }
 
+// performRatingsCleanupInDB function deletes Advisor ratings given by
+// selected organization and/or ratings of selected rule. At least one of
+// these criteria needs to be specified. Records to be deleted are counted
+// first and nothing is deleted in dry run mode.
+func performRatingsCleanupInDB(connection *sql.DB, orgID int, ruleFQDN, errorKey, schema string, dryRun bool) (
+	map[string]int, error) {
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return map[string]int{}, ErrNoConnection
+	}
+
+	// ratings are stored in OCP database only
+	if schema != DBSchemaOCPRecommendations {
+		return map[string]int{}, invalidSchema(schema)
+	}
+
+	// never delete all ratings by accident
+	if orgID == 0 && ruleFQDN == "" {
+		return map[string]int{}, errors.New(ratingsCriteriaMissing)
+	}
+
+	log.Info().
+		Int(orgIDMsg, orgID).
+		Str(ruleFQDNMsg, ruleFQDN).
+		Str(errorKeyMsg, errorKey).
+		Msg("Advisor ratings cleanup started")
+
+	condition, args := recordsCondition(orgID, ruleFQDN, errorKey)
+	deletionsForTable, err := countAndDeleteRecords(connection, []string{advisorRatingsTable}, condition, args, dryRun)
+	if err != nil {
+		return deletionsForTable, err
+	}
+
+	log.Info().Msg("Advisor ratings cleanup finished")
+	return deletionsForTable, nil
+}
+
+// compactedPayload is a value used instead of the original payload
+const compactedPayload = "{}"
+
+var (
+	tablesToCompactOCP = []TableAndPayload{
+		{
+			TableName:     "report",
+			PayloadColumn: "report",
+			Condition:     "reported_at < NOW() - $1::INTERVAL",
+		},
+		{
+			TableName:     "rule_hit",
+			PayloadColumn: "template_data",
+			Condition: `EXISTS (
+				SELECT 1
+				  FROM report
+				 WHERE report.cluster = rule_hit.cluster_id
+				   AND report.org_id = rule_hit.org_id
+				   AND report.reported_at < NOW() - $1::INTERVAL)`,
+		},
+	}
+
+	tablesToCompactDVO = []TableAndPayload{
+		{
+			TableName:     "dvo_report",
+			PayloadColumn: "report",
+			Condition:     "reported_at < NOW() - $1::INTERVAL",
+		},
+	}
+)
+
+// compactionCondition function returns WHERE condition and its arguments
+// used to select old records with payload that has not been compacted yet.
+// Only records of selected organization are selected when organization ID
+// is specified (ie. it is not zero).
+func compactionCondition(tableAndPayload TableAndPayload, maxAge string, orgID int) (string, []interface{}) {
+	condition := " WHERE " + tableAndPayload.Condition +
+		" AND " + tableAndPayload.PayloadColumn + " <> '" + compactedPayload + "'"
+	args := []interface{}{maxAge}
+
+	if orgID != 0 {
+		condition += " AND org_id = $2"
+		args = append(args, orgID)
+	}
+	return condition, args
+}
+
+// compactPayloadInTable function replaces payload of old records in given
+// table by empty JSON. Timestamps and other columns are kept untouched.
+func compactPayloadInTable(connection *sql.DB, tableAndPayload TableAndPayload, condition string, args []interface{}) (int, error) {
+	// it is not possible to use parameter for table name or a column
+	// disable "G202 (CWE-89): SQL string concatenation (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G202
+	sqlStatement := "UPDATE " + tableAndPayload.TableName +
+		" SET " + tableAndPayload.PayloadColumn + " = '" + compactedPayload + "'" +
+		condition + ";"
+
+	result, err := execStatement(connection, sqlStatement, args...)
+	if err != nil {
+		return 0, queryFailed(tableAndPayload.TableName, err)
+	}
+
+	// read number of affected (updated) rows
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// performPayloadCompactionInDB function drops heavy payload from old records
+// that need to be retained. Records to be compacted are counted first and
+// nothing is changed in dry run mode. Compaction continues with the next
+// table when any table can not be compacted, all failures are returned as
+// aggregated error.
+func performPayloadCompactionInDB(connection *sql.DB, maxAge, schema string, orgID int, dryRun bool) (
+	map[string]int, error) {
+	compactionsForTable := make(map[string]int)
+	if maxAge == "" {
+		return compactionsForTable, errors.New(maxAgeMissing)
+	}
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return compactionsForTable, ErrNoConnection
+	}
+
+	var tablesToCompact []TableAndPayload
+	switch schema {
+	case DBSchemaOCPRecommendations:
+		tablesToCompact = tablesToCompactOCP
+	case DBSchemaDVORecommendations:
+		tablesToCompact = tablesToCompactDVO
+	default:
+		return compactionsForTable, invalidSchema(schema)
+	}
+
+	log.Info().
+		Str("Max age", maxAge).
+		Int(orgIDMsg, orgID).
+		Bool("Dry run", dryRun).
+		Msg("Payload compaction started")
+	var errs []error
+	for _, tableAndPayload := range tablesToCompact {
+		condition, args := compactionCondition(tableAndPayload, maxAge, orgID)
+
+		var affected int
+		var err error
+		if dryRun {
+			affected, err = countRecordsInTable(connection, tableAndPayload.TableName, condition, args)
+		} else {
+			affected, err = compactPayloadInTable(connection, tableAndPayload, condition, args)
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str(tableName, tableAndPayload.TableName).
+				Msg("Unable to compact payload")
+			errs = append(errs, err)
+			continue
+		}
+		log.Info().
+			Int(affectedMsg, affected).
+			Str(tableName, tableAndPayload.TableName).
+			Str("column", tableAndPayload.PayloadColumn).
+			Bool("Dry run", dryRun).
+			Msg("Compact payload")
+		compactionsForTable[tableAndPayload.TableName] = affected
+	}
+	log.Info().Msg("Payload compaction finished")
+	return compactionsForTable, errors.Join(errs...)
+}
+
+// databaseSchemaNames contains name of PostgreSQL schema where tables for
+// given DB schema are stored
+var databaseSchemaNames = map[string]string{
+	DBSchemaOCPRecommendations: "public",
+	DBSchemaDVORecommendations: "dvo",
+}
+
+// readTableSizes function reads size and estimated number of rows for all
+// tables in selected DB schema
+func readTableSizes(connection *sql.DB, schema string) ([]TableSize, error) {
+	var sizes []TableSize
+
+	if connection == nil {
+		log.Error().Msg(connectionNotEstablished)
+		return sizes, ErrNoConnection
+	}
+
+	databaseSchema, err := databaseSchemaForSchema(schema)
+	if err != nil {
+		return sizes, err
+	}
+
+	// iterate over all tables
+	err = queryRows(connection, selectTableSizes, []interface{}{databaseSchema}, func(rows *sql.Rows) error {
+		var size TableSize
+
+		if err := rows.Scan(&size.TableName, &size.SizeBytes, &size.RowCount); err != nil {
+			return err
+		}
+
+		log.Info().
+			Str(tableName, size.TableName).
+			Int64("size", size.SizeBytes).
+			Int64("rows", size.RowCount).
+			Msg("Table size")
+		sizes = append(sizes, size)
+		return nil
+	})
+	if err != nil {
+		return sizes, queryFailed(statisticsTable, err)
+	}
+
+	return sizes, nil
+}
+
 // fillInDatabaseByTestData function fill-in database by test data (not to be
 // used against production database)
 func fillInDatabaseByTestData(connection *sql.DB, schema string) error {
@@ -899,57 +2149,82 @@ func fillInDatabaseByTestData(connection *sql.DB, schema string) error {
 	case DBSchemaDVORecommendations:
 		return fillInDVODatabaseByTestData(connection)
 	default:
-		return fmt.Errorf("Invalid DB schema '%s'", schema)
+		return invalidSchema(schema)
 	}
 }
 
 // fillInOCPDatabaseByTestData function fills-in OCP database by test data
-// (not to be used against production database)
+// (not to be used against production database). Records for each cluster
+// are inserted in one transaction that is rolled back when any insert fails.
+// All failures are returned as one aggregated error.
 func fillInOCPDatabaseByTestData(connection *sql.DB) error {
-	var lastError error
+	var errs []error
 
 	clusterNames := [...]string{
 		"00000000-0000-0000-0000-000000000000",
 		"11111111-1111-1111-1111-111111111111",
 		"5d5892d4-1f74-4ccf-91af-548dfc9767aa"}
 
-	sqlStatements := [...]string{
-		"INSERT INTO report (org_id, cluster, report, reported_at, last_checked_at, kafka_offset) values(1, $1, '', '2021-01-01', '2021-01-01', 10)",
-		"INSERT INTO cluster_rule_toggle (cluster_id, rule_id, user_id, disabled, disabled_at, enabled_at, updated_at) values($1, 1, 1, 0, '2021-01-01', '2021-01-01', '2021-01-01')",
-		"INSERT INTO cluster_rule_user_feedback (cluster_id, rule_id, user_id, message, user_vote, added_at, updated_at) values($1, 1, 1, 'foobar', 1, '2021-01-01', '2021-01-01')",
-		"INSERT INTO cluster_user_rule_disable_feedback (cluster_id, user_id, rule_id, message, added_at, updated_at) values($1, 1, 1, 'foobar', '2021-01-01', '2021-01-01')",
-		"INSERT INTO rule_hit (org_id, cluster_id, rule_fqdn, error_key, template_data) values(1, $1, 'foo', 'bar', '')",
-	}
-
 	for _, clusterName := range clusterNames {
 		log.Info().
 			Str("cluster name", clusterName).
 			Msg("data for new cluster")
 
-		for _, sqlStatement := range sqlStatements {
-			log.Info().
-				Str("SQL statement", sqlStatement).
-				Msg("inserting into OCP database")
-			// perform the SQL statement
-			_, err := connection.Exec(sqlStatement, clusterName)
-			if err != nil {
-				// failure is usually ok - it might mean that
-				// the record with given cluster name already
-				// exists
-				log.Err(err).Msg("Insert error (OCP)")
-				lastError = err
-			}
+		err := fillInOCPCluster(connection, clusterName)
+		if err != nil {
+			// failure is usually ok - it might mean that
+			// the record with given cluster name already
+			// exists
+			log.Err(err).Msg("Insert error (OCP)")
+			errs = append(errs, fmt.Errorf("cluster %s: %w", clusterName, err))
 		}
 	}
 	log.Info().Msg("Fill-in OCP database finished")
-	return lastError
+	return errors.Join(errs...)
+}
+
+// fillInOCPCluster function inserts test data for one cluster into OCP
+// database in one transaction
+func fillInOCPCluster(connection *sql.DB, clusterName string) error {
+	inserts := [...]struct {
+		table     string
+		statement string
+	}{
+		{"report", "INSERT INTO report (org_id, cluster, report, reported_at, last_checked_at, kafka_offset) values(1, $1, '', '2021-01-01', '2021-01-01', 10)"},
+		{"cluster_rule_toggle", "INSERT INTO cluster_rule_toggle (cluster_id, rule_id, user_id, disabled, disabled_at, enabled_at, updated_at) values($1, 1, 1, 0, '2021-01-01', '2021-01-01', '2021-01-01')"},
+		{"cluster_rule_user_feedback", "INSERT INTO cluster_rule_user_feedback (cluster_id, rule_id, user_id, message, user_vote, added_at, updated_at) values($1, 1, 1, 'foobar', 1, '2021-01-01', '2021-01-01')"},
+		{"cluster_user_rule_disable_feedback", "INSERT INTO cluster_user_rule_disable_feedback (cluster_id, user_id, rule_id, message, added_at, updated_at) values($1, 1, 1, 'foobar', '2021-01-01', '2021-01-01')"},
+		{"rule_hit", "INSERT INTO rule_hit (org_id, cluster_id, rule_fqdn, error_key, template_data) values(1, $1, 'foo', 'bar', '')"},
+	}
+
+	tx, err := connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, insert := range inserts {
+		log.Info().
+			Str("SQL statement", insert.statement).
+			Msg("inserting into OCP database")
+		// perform the SQL statement
+		_, err := execStatement(tx, insert.statement, clusterName)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+			}
+			return queryFailed(insert.table, err)
+		}
+	}
+
+	return tx.Commit()
 }
 
 // fillInDVODatabaseByTestData function fills-in DVO database by test data
-// (not to be used against production database)
+// (not to be used against production database). All failures are returned
+// as one aggregated error.
 func fillInDVODatabaseByTestData(connection *sql.DB) error {
 	/* Table that needs to be filled-in has the following schema:
-	    CREATE TABLE dvo.dvo_report (
+	    CREATE TABLE dvo_report (
 	    org_id          INTEGER NOT NULL,
 	    cluster_id      VARCHAR NOT NULL,
 	    namespace_id    VARCHAR NOT NULL,
@@ -965,7 +2240,7 @@ func fillInDVODatabaseByTestData(connection *sql.DB) error {
 	*/
 
 	const insertStatement = `
-	    INSERT INTO dvo.dvo_report
+	    INSERT INTO dvo_report
 	           (org_id, cluster_id, namespace_id, namespace_name, report, recommendations, objects, reported_at, last_checked_at, rule_hits_count)
 		   values
 		   ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`
@@ -1062,14 +2337,15 @@ func fillInDVODatabaseByTestData(connection *sql.DB) error {
 		},
 	}
 
-	var lastError error
+	var errs []error
 
+	// each record is inserted by one statement, so transaction is not needed
 	for _, record := range records {
 		log.Info().
 			Str("Insert statement", insertStatement).
 			Msg("inserting into DVO database")
 		// perform the SQL statement
-		_, err := connection.Exec(insertStatement,
+		_, err := execStatement(connection, insertStatement,
 			record.OrgID, record.ClusterID, record.NamespaceID,
 			record.NamespaceName, record.Report, record.Recommendations,
 			record.Objects, record.ReportedAt, record.LastCheckedAt,
@@ -1079,9 +2355,10 @@ func fillInDVODatabaseByTestData(connection *sql.DB) error {
 			// the record with given org_id + cluster name already
 			// exists
 			log.Err(err).Msg("Insert error (DVO)")
-			lastError = err
+			errs = append(errs, fmt.Errorf("cluster %s in organization %d: %w",
+				record.ClusterID, record.OrgID, queryFailed(dvoReportTable, err)))
 		}
 	}
 	log.Info().Msg("Fill-in DVO database finished")
-	return lastError
+	return errors.Join(errs...)
 }
@@ -0,0 +1,292 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/targets.html
+
+// This source file contains implementation of multi-database fan-out. When
+// more storage targets are declared in configuration file, the selected
+// operation is performed against each target (sequentially or with limited
+// concurrency) and one combined summary table with one section per target is
+// displayed at the end:
+//
+// [[targets]]
+// name = "ocp"
+// db_driver = "postgres"
+// pg_db_name = "aggregator"
+// schema = "ocp_recommendations"
+//
+// [[targets]]
+// name = "dvo"
+// db_driver = "postgres"
+// pg_db_name = "aggregator_dvo"
+// schema = "dvo_recommendations"
+//
+// Files written by the operation (listings, size snapshots, evidence) get the
+// target name as suffix, so targets do not overwrite each other's files.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// Constants used by multi-database fan-out
+const (
+	targetAttribute   = "target"
+	targetStatusOK    = "OK"
+	targetStatusError = "failed: "
+)
+
+// TargetResult represents result of operation performed against one storage
+// target
+type TargetResult struct {
+	Target     string
+	ExitStatus ExitStatus
+	Err        error
+	Summary    Summary
+}
+
+// summaryCollector collects summaries of operations performed against more
+// storage targets, so they can be displayed in one combined table
+type summaryCollector struct {
+	mutex     sync.Mutex
+	summaries map[string]Summary
+}
+
+// collectedSummaries is set when the operation is performed against more
+// storage targets. Summaries are printed directly when it is nil.
+var collectedSummaries *summaryCollector
+
+// add method stores summary for target specified in the summary
+func (collector *summaryCollector) add(summary Summary) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.summaries[summary.Target] = summary
+}
+
+// get method returns summary stored for given target
+func (collector *summaryCollector) get(target string) (Summary, bool) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	summary, found := collector.summaries[target]
+	return summary, found
+}
+
+// reportSummary function displays summary table or, when operation is
+// performed against more storage targets, stores it into combined summary
+func reportSummary(summary Summary) {
+	if collectedSummaries != nil {
+		collectedSummaries.add(summary)
+		return
+	}
+	PrintSummaryTable(summary)
+}
+
+// targetFileName function adds target name as suffix to given file name, for
+// example "old_reports.csv" is changed to "old_reports-ocp.csv". Empty file
+// name and standard output are not changed.
+func targetFileName(filename, target string) string {
+	if filename == "" || isStandardOutput(filename) {
+		return filename
+	}
+	extension := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, extension) + "-" + target + extension
+}
+
+// targetConfiguration function prepares configuration and command line flags
+// used to perform operation against given storage target
+func targetConfiguration(configuration *ConfigStruct, cliFlags CliFlags, target StorageConfiguration) (
+	ConfigStruct, CliFlags) {
+	targetConfig := *configuration
+	targetConfig.Storage = target
+	targetConfig.Evidence.File = targetFileName(configuration.Evidence.File, target.Name)
+
+	targetFlags := cliFlags
+	targetFlags.Output = targetFileName(cliFlags.Output, target.Name)
+	targetFlags.SizeSnapshot = targetFileName(cliFlags.SizeSnapshot, target.Name)
+	targetFlags.DeletionMatrix = targetFileName(cliFlags.DeletionMatrix, target.Name)
+
+	return targetConfig, targetFlags
+}
+
+// runForTarget function performs selected operation against one storage
+// target
+func runForTarget(configuration *ConfigStruct, cliFlags CliFlags, target StorageConfiguration) TargetResult {
+	result := TargetResult{
+		Target: target.Name,
+	}
+
+	targetConfig, targetFlags := targetConfiguration(configuration, cliFlags, target)
+
+	log.Info().Str(targetAttribute, target.Name).Msg("Operation started for target")
+
+	// each target has its own connection
+	connection, err := initDatabaseConnection(&targetConfig.Storage)
+	if err != nil {
+		log.Err(err).Str(targetAttribute, target.Name).Msg("Connection to database not established")
+	}
+	defer closeDatabaseConnection(connection)
+
+	result.ExitStatus, result.Err = doSelectedOperation(&targetConfig, connection, targetFlags)
+
+	if result.Err != nil {
+		log.Err(result.Err).Str(targetAttribute, target.Name).Msg("Operation failed for target")
+	} else {
+		log.Info().Str(targetAttribute, target.Name).Msg("Operation finished for target")
+	}
+	return result
+}
+
+// runForAllTargets function performs selected operation against all storage
+// targets declared in configuration file. At most targets_concurrency
+// targets are processed at the same time (targets are processed sequentially
+// by default). Exit status of the first failed target is returned together
+// with errors from all failed targets.
+func runForAllTargets(configuration *ConfigStruct, cliFlags CliFlags) (ExitStatus, error) {
+	targets := configuration.Targets
+
+	concurrency := configuration.Cleaner.TargetsConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// summaries are displayed in one combined table
+	collectedSummaries = &summaryCollector{
+		summaries: make(map[string]Summary),
+	}
+	defer func() {
+		collectedSummaries = nil
+	}()
+
+	results := make([]TargetResult, len(targets))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, target StorageConfiguration) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = runForTarget(configuration, cliFlags, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	// summaries are available only for operations that produce them
+	for i := range results {
+		summary, found := collectedSummaries.get(results[i].Target)
+		if found {
+			results[i].Summary = summary
+		}
+	}
+
+	if cliFlags.PrintSummaryTable {
+		PrintTargetSummaries(results, cliFlags.RequestedBy)
+	}
+
+	exitStatus := ExitStatusOK
+	var errs []error
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		if exitStatus == ExitStatusOK {
+			exitStatus = result.ExitStatus
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", result.Target, result.Err))
+	}
+	return exitStatus, errors.Join(errs...)
+}
+
+// PrintTargetSummaries function displays one combined table with summary
+// information about operation performed against more storage targets.
+func PrintTargetSummaries(results []TargetResult, requestedBy string) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+	table.SetAutoMergeCellsByColumnIndex([]int{0})
+	table.SetRowLine(true)
+
+	// table header
+	table.SetHeader([]string{"Target", "Summary", "Count"})
+
+	totalDeletions := 0
+
+	for _, result := range results {
+		status := targetStatusOK
+		if result.Err != nil {
+			status = targetStatusError + result.Err.Error()
+		}
+		table.Append([]string{result.Target, "Status", status})
+
+		summary := result.Summary
+		table.Append([]string{result.Target, "Proper cluster entries",
+			strconv.Itoa(summary.ProperClusterEntries)})
+		table.Append([]string{result.Target, "Improper cluster entries",
+			strconv.Itoa(summary.ImproperClusterEntries)})
+		if summary.NormalizedClusterEntries > 0 {
+			table.Append([]string{result.Target, "Normalized cluster entries",
+				strconv.Itoa(summary.NormalizedClusterEntries)})
+		}
+		if summary.FailedClusterEntries > 0 {
+			table.Append([]string{result.Target, "Failed cluster entries",
+				strconv.Itoa(summary.FailedClusterEntries)})
+		}
+		if reconciliation := summary.Reconciliation; reconciliation != nil {
+			table.Append([]string{result.Target, reconciliationDeleted,
+				strconv.Itoa(len(reconciliation.Deleted))})
+			table.Append([]string{result.Target, reconciliationAlreadyAbsent,
+				strconv.Itoa(len(reconciliation.AlreadyAbsent))})
+			table.Append([]string{result.Target, reconciliationFailed,
+				strconv.Itoa(len(reconciliation.Failed))})
+			table.Append([]string{result.Target, reconciliationProtected,
+				strconv.Itoa(len(reconciliation.Protected))})
+		}
+
+		// tables are sorted so the output is stable
+		for _, tableName := range summaryTableNames(summary.DeletionsForTable, summary.SortByDeletions) {
+			deletions := summary.DeletionsForTable[tableName]
+			totalDeletions += deletions
+			table.Append([]string{result.Target, "Deletions from table '" + tableName + "'",
+				strconv.Itoa(deletions)})
+		}
+	}
+
+	// table footer
+	table.SetFooter([]string{"", "Total deletions",
+		strconv.Itoa(totalDeletions)})
+
+	// display the whole table
+	table.Render()
+
+	// correlation ID and identity are the same for all targets
+	if runID != "" {
+		fmt.Println("Run ID: " + runID)
+	}
+	if requestedBy != "" {
+		fmt.Println("Requested by: " + requestedBy)
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/targets_test.html
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// prepareSQLiteTarget function prepares SQLite database with table used by
+// plug-in schema and returns storage target that uses it
+func prepareSQLiteTarget(t *testing.T, name string, oldRecords int) cleaner.StorageConfiguration {
+	filename := filepath.Join(t.TempDir(), name+".db")
+
+	connection, err := sql.Open("sqlite3", filename)
+	assert.NoError(t, err)
+
+	_, err = connection.Exec("CREATE TABLE events (id INTEGER, age INTEGER)")
+	assert.NoError(t, err)
+
+	for i := 0; i < oldRecords; i++ {
+		_, err = connection.Exec("INSERT INTO events VALUES ($1, 100)", i)
+		assert.NoError(t, err)
+	}
+	_, err = connection.Exec("INSERT INTO events VALUES (1000, 1)")
+	assert.NoError(t, err)
+
+	assert.NoError(t, connection.Close())
+
+	return cleaner.StorageConfiguration{
+		Name:             name,
+		Driver:           "sqlite3",
+		SQLiteDataSource: filename,
+		Schema:           "events",
+	}
+}
+
+// eventsConfiguration function prepares configuration with plug-in schema
+// and given storage targets
+func eventsConfiguration(t *testing.T, targets ...cleaner.StorageConfiguration) cleaner.ConfigStruct {
+	schemas := []cleaner.SchemaConfiguration{
+		{
+			Name: "events",
+			Tables: []cleaner.SchemaTableConfiguration{
+				{
+					TableName:       "events",
+					DeleteStatement: "DELETE FROM events WHERE age > CAST($1 AS INTEGER)",
+				},
+			},
+		},
+	}
+	cleaner.RegisterPluginSchemas(schemas)
+	t.Cleanup(func() {
+		cleaner.RegisterPluginSchemas(nil)
+	})
+
+	return cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{
+			MaxAge:             "10",
+			TargetsConcurrency: 2,
+		},
+		Schemas: schemas,
+		Targets: targets,
+	}
+}
+
+// TestTargetFileName checks how target name is added into file names
+func TestTargetFileName(t *testing.T) {
+	assert.Equal(t, "", cleaner.TargetFileName("", "ocp"))
+	assert.Equal(t, "-", cleaner.TargetFileName("-", "ocp"))
+	assert.Equal(t, "reports-ocp.csv", cleaner.TargetFileName("reports.csv", "ocp"))
+	assert.Equal(t, "/tmp/reports-ocp", cleaner.TargetFileName("/tmp/reports", "ocp"))
+}
+
+// TestTargetConfiguration checks that storage and files are changed for
+// given target
+func TestTargetConfiguration(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Evidence: cleaner.EvidenceConfiguration{
+			File: "evidence.json",
+		},
+	}
+	cliFlags := cleaner.CliFlags{
+		Output:         "reports.csv",
+		SizeSnapshot:   "sizes.csv",
+		DeletionMatrix: "matrix.csv",
+	}
+	target := cleaner.StorageConfiguration{
+		Name:   "dvo",
+		Schema: cleaner.DBSchemaDVORecommendations,
+	}
+
+	targetConfig, targetFlags := cleaner.TargetConfiguration(&configuration, cliFlags, target)
+
+	assert.Equal(t, target, targetConfig.Storage)
+	assert.Equal(t, "evidence-dvo.json", targetConfig.Evidence.File)
+	assert.Equal(t, "reports-dvo.csv", targetFlags.Output)
+	assert.Equal(t, "sizes-dvo.csv", targetFlags.SizeSnapshot)
+	assert.Equal(t, "matrix-dvo.csv", targetFlags.DeletionMatrix)
+
+	// original configuration is not changed
+	assert.Equal(t, "evidence.json", configuration.Evidence.File)
+}
+
+// TestRunForAllTargets checks that operation is performed against all
+// storage targets and that combined summary is displayed
+func TestRunForAllTargets(t *testing.T) {
+	configuration := eventsConfiguration(t,
+		prepareSQLiteTarget(t, "first", 2),
+		prepareSQLiteTarget(t, "second", 3))
+
+	cliFlags := cleaner.CliFlags{
+		PerformCleanupAll: true,
+		PrintSummaryTable: true,
+		MaxAge:            "10",
+		ConfirmMaxAge:     "10",
+		RequestedBy:       "tester",
+	}
+
+	var status cleaner.ExitStatus
+	var runErr error
+	output, err := capture.StandardOutput(func() {
+		status, runErr = cleaner.RunForAllTargets(&configuration, cliFlags)
+	})
+	checkCapture(t, err)
+
+	assert.NoError(t, runErr)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.Contains(t, output, "TARGET")
+	assert.Contains(t, output, "first")
+	assert.Contains(t, output, "second")
+	assert.Regexp(t, `Deletions from table 'events'\s+\|\s+2`, output)
+	assert.Regexp(t, `Deletions from table 'events'\s+\|\s+3`, output)
+	assert.Regexp(t, `TOTAL DELETIONS\s+\|\s+5`, output)
+	assert.Contains(t, output, "Requested by: tester")
+}
+
+// TestRunForAllTargetsFailure checks that failure of one target does not
+// stop processing of other targets
+func TestRunForAllTargetsFailure(t *testing.T) {
+	broken := cleaner.StorageConfiguration{
+		Name:             "broken",
+		Driver:           "sqlite3",
+		SQLiteDataSource: filepath.Join(t.TempDir(), "broken.db"),
+		Schema:           "events",
+	}
+	configuration := eventsConfiguration(t,
+		broken,
+		prepareSQLiteTarget(t, "working", 4))
+
+	cliFlags := cleaner.CliFlags{
+		PerformCleanupAll: true,
+		PrintSummaryTable: true,
+		MaxAge:            "10",
+		ConfirmMaxAge:     "10",
+	}
+
+	var status cleaner.ExitStatus
+	var runErr error
+	output, err := capture.StandardOutput(func() {
+		status, runErr = cleaner.RunForAllTargets(&configuration, cliFlags)
+	})
+	checkCapture(t, err)
+
+	assert.Error(t, runErr)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+	assert.Contains(t, output, "failed: ")
+	assert.Regexp(t, `Deletions from table 'events'\s+\|\s+4`, output)
+
+	// error from storage layer is preserved
+	_, err = cleaner.RunForAllTargets(&configuration, cleaner.CliFlags{PerformCleanupAll: true, MaxAge: "10", ConfirmMaxAge: "10"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken: ")
+	assert.Contains(t, err.Error(), "no such table")
+}
+
+// TestRunForAllTargetsDeletionMatrix checks that each storage target writes
+// deletion matrix into its own file
+func TestRunForAllTargetsDeletionMatrix(t *testing.T) {
+	const cluster = "5d5892d4-1f74-4ccf-91af-548dfc9767aa"
+
+	directory := t.TempDir()
+	targets := []cleaner.StorageConfiguration{}
+	for _, name := range []string{"first", "second"} {
+		filename := filepath.Join(directory, name+".db")
+
+		connection, err := sql.Open("sqlite3", filename)
+		assert.NoError(t, err)
+		_, err = connection.Exec("CREATE TABLE reports (cluster VARCHAR)")
+		assert.NoError(t, err)
+		_, err = connection.Exec("INSERT INTO reports VALUES ($1)", cluster)
+		assert.NoError(t, err)
+		assert.NoError(t, connection.Close())
+
+		targets = append(targets, cleaner.StorageConfiguration{
+			Name:             name,
+			Driver:           "sqlite3",
+			SQLiteDataSource: filename,
+			Schema:           "reports",
+		})
+	}
+
+	schemas := []cleaner.SchemaConfiguration{
+		{
+			Name: "reports",
+			Tables: []cleaner.SchemaTableConfiguration{
+				{
+					TableName: "reports",
+					KeyName:   "cluster",
+				},
+			},
+		},
+	}
+	cleaner.RegisterPluginSchemas(schemas)
+	t.Cleanup(func() {
+		cleaner.RegisterPluginSchemas(nil)
+	})
+
+	configuration := cleaner.ConfigStruct{
+		Cleaner: cleaner.CleanerConfiguration{
+			TargetsConcurrency: 2,
+		},
+		Schemas: schemas,
+		Targets: targets,
+	}
+	cliFlags := cleaner.CliFlags{
+		PerformCleanup: true,
+		Clusters:       cluster,
+		DeletionMatrix: filepath.Join(directory, "matrix.csv"),
+	}
+
+	var status cleaner.ExitStatus
+	var runErr error
+	_, err := capture.StandardOutput(func() {
+		status, runErr = cleaner.RunForAllTargets(&configuration, cliFlags)
+	})
+	checkCapture(t, err)
+
+	assert.NoError(t, runErr)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	for _, name := range []string{"first", "second"} {
+		content, err := os.ReadFile(filepath.Join(directory, "matrix-"+name+".csv"))
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), cluster+",1,1")
+	}
+	assert.NoFileExists(t, filepath.Join(directory, "matrix.csv"))
+}
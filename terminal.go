@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/terminal.html
+
+// This source file contains support for summary table displayed on terminal.
+// When standard output is a terminal, failed clusters are highlighted in red,
+// tables without deletions are dimmed, and width of the summary table is
+// adjusted to width of the terminal. Colors are never used when the output is
+// redirected into file or pipe, when -no-color is specified, or when NO_COLOR
+// environment variable is set.
+
+import (
+	"os"
+
+	"github.com/mattn/go-isatty"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Width of columns in summary table
+const (
+	defaultSummaryColWidth = 60
+	minSummaryColWidth     = 20
+	// summaryCountsWidth is width reserved for count columns and borders
+	summaryCountsWidth = 40
+)
+
+// noColorEnvVariableName is name of environment variable used to disable
+// colors in all tools that support it
+const noColorEnvVariableName = "NO_COLOR"
+
+// Colors used in summary table
+var (
+	errorColors  = tablewriter.Colors{tablewriter.FgRedColor}
+	dimmedColors = tablewriter.Colors{tablewriter.FgHiBlackColor}
+	normalColors = tablewriter.Colors{}
+)
+
+// summaryColors is set when summary table is displayed with colors
+var summaryColors = false
+
+// summaryColWidth is max width of column in summary table, longer texts are
+// wrapped
+var summaryColWidth = defaultSummaryColWidth
+
+// colWidthForTerminal function computes max width of column in summary table
+// for terminal with given width. Default width is used when terminal width is
+// not known.
+func colWidthForTerminal(terminalWidth int) int {
+	if terminalWidth <= 0 {
+		return defaultSummaryColWidth
+	}
+	width := terminalWidth - summaryCountsWidth
+	if width < minSummaryColWidth {
+		return minSummaryColWidth
+	}
+	return width
+}
+
+// configureTerminalOutput function enables colors and adjusts width of
+// summary table when standard output is a terminal
+func configureTerminalOutput(noColor bool) {
+	fd := os.Stdout.Fd()
+	if !isatty.IsTerminal(fd) {
+		return
+	}
+
+	_, noColorSet := os.LookupEnv(noColorEnvVariableName)
+	summaryColors = !noColor && !noColorSet
+
+	width, found := terminalWidth(fd)
+	if found {
+		summaryColWidth = colWidthForTerminal(width)
+	}
+}
+
+// rowColors function returns colors for row in summary table with given
+// number of columns
+func rowColors(colors tablewriter.Colors, columns int) []tablewriter.Colors {
+	result := make([]tablewriter.Colors, columns)
+	for i := range result {
+		result[i] = colors
+	}
+	return result
+}
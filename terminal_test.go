@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/terminal_test.html
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// ANSI escape sequences used in summary table
+const (
+	redColorSequence    = "\033[31m"
+	dimmedColorSequence = "\033[90m"
+)
+
+// enableSummaryColors function enables colors in summary table for the
+// duration of the test
+func enableSummaryColors(t *testing.T) {
+	*cleaner.SummaryColors = true
+	t.Cleanup(func() {
+		*cleaner.SummaryColors = false
+	})
+}
+
+// TestColWidthForTerminal checks width of summary table columns computed
+// for different terminals
+func TestColWidthForTerminal(t *testing.T) {
+	// width of terminal is not known
+	assert.Equal(t, 60, cleaner.ColWidthForTerminal(0))
+	// wide terminal
+	assert.Equal(t, 160, cleaner.ColWidthForTerminal(200))
+	// narrow terminal
+	assert.Equal(t, 40, cleaner.ColWidthForTerminal(80))
+	assert.Equal(t, 20, cleaner.ColWidthForTerminal(30))
+}
+
+// TestConfigureTerminalOutputNotTerminal checks that colors are not enabled
+// when standard output is not a terminal
+func TestConfigureTerminalOutputNotTerminal(t *testing.T) {
+	_, err := capture.StandardOutput(func() {
+		cleaner.ConfigureTerminalOutput(false)
+	})
+	checkCapture(t, err)
+
+	assert.False(t, *cleaner.SummaryColors)
+	assert.Equal(t, 60, *cleaner.SummaryColWidth)
+}
+
+// TestPrintSummaryTableColors checks that failed clusters are highlighted
+// and tables without deletions are dimmed
+func TestPrintSummaryTableColors(t *testing.T) {
+	enableSummaryColors(t)
+
+	output, err := capture.StandardOutput(func() {
+		cleaner.PrintSummaryTable(cleaner.Summary{
+			ProperClusterEntries: 3,
+			FailedClusterEntries: 1,
+			DeletionsForTable:    map[string]int{"report": 2, "rule_hit": 0},
+		})
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, redColorSequence+"Failed cluster entries")
+	assert.Contains(t, output, dimmedColorSequence+"Deletions from table 'rule_hit'")
+	assert.NotContains(t, output, dimmedColorSequence+"Deletions from table 'report'")
+}
+
+// TestPrintSummaryTableNoColors checks that no escape sequences are
+// written when colors are disabled
+func TestPrintSummaryTableNoColors(t *testing.T) {
+	output, err := capture.StandardOutput(func() {
+		cleaner.PrintSummaryTable(cleaner.Summary{
+			FailedClusterEntries: 1,
+			DeletionsForTable:    map[string]int{"rule_hit": 0},
+		})
+	})
+	checkCapture(t, err)
+
+	assert.NotContains(t, output, "\033[")
+}
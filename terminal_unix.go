@@ -0,0 +1,36 @@
+//go:build unix
+
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/terminal_unix.html
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// terminalWidth function reads width of terminal connected to given file
+// descriptor
+func terminalWidth(fd uintptr) (int, bool) {
+	winsize, err := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+	if err != nil || winsize.Col == 0 {
+		return 0, false
+	}
+	return int(winsize.Col), true
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/transactions.html
+
+// This source file contains implementation of transactional cleanup mode. In
+// this mode records for each cluster are deleted from all tables in one
+// transaction, so the database never contains partially deleted cluster.
+// When the transaction fails because of serialization failure (SQLSTATE
+// 40001) or deadlock (SQLSTATE 40P01), it is rolled back and retried with
+// jittered exponential backoff.
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// SQLSTATE codes of errors that are resolved by retrying the transaction
+const (
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+// Retry policy for transactions
+const (
+	maxTransactionRetries = 5
+)
+
+// transactionRetryBackoff is base delay before the first retry of failed
+// transaction. The delay is doubled for each next retry.
+var transactionRetryBackoff = 100 * time.Millisecond
+
+// sqlExecutor is an interface implemented by both sql.DB and sql.Tx, so the
+// same functions can be used to delete records with and without transaction
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// isRetryableTransactionError function checks if given error is caused by
+// serialization failure or deadlock, ie. if it makes sense to retry the
+// transaction
+func isRetryableTransactionError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == serializationFailureCode || pqErr.Code == deadlockDetectedCode
+}
+
+// transactionRetryDelay function returns delay before given retry. The delay
+// grows exponentially and random jitter is added, so concurrent transactions
+// do not collide again.
+func transactionRetryDelay(retry int) time.Duration {
+	delay := transactionRetryBackoff << retry
+	if delay <= 0 {
+		return 0
+	}
+	// disable "G404 (CWE-338): Use of weak random number generator"
+	jitter := time.Duration(rand.Int63n(int64(delay))) // #nosec G404
+	return delay/2 + jitter/2
+}
+
+// cleanupClusterInTransaction function deletes records for selected cluster
+// from all given tables in one transaction. The transaction is retried when
+// it fails because of serialization failure or deadlock.
+func cleanupClusterInTransaction(connection *sql.DB, tablesAndKeys []TableAndKey,
+	clusterName ClusterName, orgID int, deletionsForTable map[string]int) error {
+	for retry := 0; ; retry++ {
+		// deletions are counted only when transaction is committed
+		deletions := make(map[string]int)
+
+		err := deleteClusterInTransaction(connection, tablesAndKeys, clusterName, orgID, deletions)
+		if err == nil {
+			for table, affected := range deletions {
+				deletionsForTable[table] += affected
+			}
+			return nil
+		}
+
+		if !isRetryableTransactionError(err) || retry >= maxTransactionRetries {
+			log.Error().
+				Err(err).
+				Str(clusterNameMsg, string(clusterName)).
+				Msg("Transaction failed")
+			return err
+		}
+
+		delay := transactionRetryDelay(retry)
+		log.Warn().
+			Err(err).
+			Str(clusterNameMsg, string(clusterName)).
+			Int("retry", retry+1).
+			Dur("delay", delay).
+			Msg("Transaction will be retried")
+		recordTransactionRetry()
+		time.Sleep(delay)
+	}
+}
+
+// deleteClusterInTransaction function performs one attempt to delete records
+// for selected cluster in one transaction
+func deleteClusterInTransaction(connection *sql.DB, tablesAndKeys []TableAndKey,
+	clusterName ClusterName, orgID int, deletions map[string]int) error {
+	tx, err := connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, tableAndKey := range tablesAndKeys {
+		var affected int
+		if orgID != 0 && tableAndKey.OrgKeyName != "" {
+			affected, err = deleteRecordFromTableForOrg(tx,
+				tableAndKey.TableName,
+				tableAndKey.KeyName,
+				tableAndKey.OrgKeyName,
+				clusterName, orgID)
+		} else {
+			affected, err = deleteRecordFromTable(tx,
+				tableAndKey.TableName,
+				tableAndKey.KeyName,
+				clusterName)
+		}
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msg("Unable to rollback transaction")
+			}
+			return err
+		}
+		log.Info().
+			Int(affectedMsg, affected).
+			Str(tableName, tableAndKey.TableName).
+			Str(clusterNameMsg, string(clusterName)).
+			Int(orgIDMsg, orgID).
+			Msg("Delete record in transaction")
+		deletions[tableAndKey.TableName] += affected
+	}
+
+	return tx.Commit()
+}
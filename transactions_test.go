@@ -0,0 +1,191 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/transactions_test.html
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+const expectedDeleteFromReported = "DELETE FROM reported WHERE cluster = \\$1"
+
+// disableTransactionRetryBackoff sets zero delay between retries for the
+// duration of the test
+func disableTransactionRetryBackoff(t *testing.T) {
+	original := *cleaner.TransactionRetryBackoff
+	*cleaner.TransactionRetryBackoff = 0
+	t.Cleanup(func() {
+		*cleaner.TransactionRetryBackoff = original
+	})
+}
+
+// TestIsRetryableTransactionError checks which errors cause the transaction
+// to be retried
+func TestIsRetryableTransactionError(t *testing.T) {
+	assert.True(t, cleaner.IsRetryableTransactionError(&pq.Error{Code: "40001"}))
+	assert.True(t, cleaner.IsRetryableTransactionError(&pq.Error{Code: "40P01"}))
+	assert.True(t, cleaner.IsRetryableTransactionError(fmt.Errorf("wrapped: %w", &pq.Error{Code: "40P01"})))
+
+	assert.False(t, cleaner.IsRetryableTransactionError(&pq.Error{Code: "23505"}))
+	assert.False(t, cleaner.IsRetryableTransactionError(errors.New("deadlock detected")))
+	assert.False(t, cleaner.IsRetryableTransactionError(nil))
+}
+
+// TestTransactionRetryDelay checks that delay grows with each retry and that
+// jitter stays in expected range
+func TestTransactionRetryDelay(t *testing.T) {
+	base := *cleaner.TransactionRetryBackoff
+
+	for retry := 0; retry < 4; retry++ {
+		maxDelay := base << retry
+		for i := 0; i < 20; i++ {
+			delay := cleaner.TransactionRetryDelay(retry)
+			assert.GreaterOrEqual(t, delay, maxDelay/2)
+			assert.Less(t, delay, maxDelay)
+		}
+	}
+
+	disableTransactionRetryBackoff(t)
+	assert.Equal(t, time.Duration(0), cleaner.TransactionRetryDelay(1))
+}
+
+// TestPerformCleanupInDBTransactional checks that records for each cluster
+// are deleted in one transaction
+func TestPerformCleanupInDBTransactional(t *testing.T) {
+	registerNotificationsSchema(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	for _, clusterName := range []string{cluster1ID, cluster2ID} {
+		mock.ExpectBegin()
+		mock.ExpectExec(expectedDeleteFromReported).WithArgs(clusterName).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectClose()
+
+	deletedRows, failedClusters, err := cleaner.PerformCleanupInDB(connection,
+		cleaner.ClusterList{cluster1ID, cluster2ID}, pluginSchemaName, 0, 0, true)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	assert.Equal(t, map[string]int{"reported": 2}, deletedRows)
+	assert.Empty(t, failedClusters)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformCleanupInDBTransactionalDeadlock checks that transaction that
+// failed because of deadlock is retried and that retries are counted in
+// metrics
+func TestPerformCleanupInDBTransactionalDeadlock(t *testing.T) {
+	registerNotificationsSchema(t)
+	disableTransactionRetryBackoff(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	// deadlock in the first attempt
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteFromReported).WithArgs(cluster1ID).WillReturnError(&pq.Error{Code: "40P01"})
+	mock.ExpectRollback()
+
+	// serialization failure in the second attempt
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteFromReported).WithArgs(cluster1ID).WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+
+	// the third attempt succeeds
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteFromReported).WithArgs(cluster1ID).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	deletedRows, failedClusters, err := cleaner.PerformCleanupInDB(connection,
+		cleaner.ClusterList{cluster1ID}, pluginSchemaName, 0, 0, true)
+	assert.NoError(t, err, "error not expected while calling tested function")
+
+	// rows deleted in rolled back transactions are not counted
+	assert.Equal(t, map[string]int{"reported": 3}, deletedRows)
+	assert.Empty(t, failedClusters)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+
+	// retries are exported in metrics
+	filename := filepath.Join(t.TempDir(), "cleaner.prom")
+	assert.NoError(t, cleaner.WriteMetricsTextfile(filename))
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "insights_results_aggregator_cleaner_transaction_retries")
+}
+
+// TestPerformCleanupInDBTransactionalError checks that transaction that
+// failed because of other error is not retried and cluster is marked as
+// failed
+func TestPerformCleanupInDBTransactionalError(t *testing.T) {
+	registerNotificationsSchema(t)
+	disableTransactionRetryBackoff(t)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(expectedDeleteFromReported).WithArgs(cluster1ID).WillReturnError(errors.New("mocked error"))
+	mock.ExpectRollback()
+
+	// begin might fail as well
+	mock.ExpectBegin().WillReturnError(errors.New("mocked error"))
+	mock.ExpectClose()
+
+	deletedRows, failedClusters, err := cleaner.PerformCleanupInDB(connection,
+		cleaner.ClusterList{cluster1ID, cluster2ID}, pluginSchemaName, 0, 0, true)
+	assert.Error(t, err, "error is expected while calling tested function")
+	assert.Contains(t, err.Error(), string(cluster1ID))
+	assert.Contains(t, err.Error(), string(cluster2ID))
+
+	assert.Equal(t, map[string]int{"reported": 0}, deletedRows)
+	assert.Equal(t, cleaner.ClusterList{cluster1ID, cluster2ID}, failedClusters)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
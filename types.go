@@ -16,6 +16,11 @@ limitations under the License.
 
 package main
 
+import (
+	"database/sql"
+	"time"
+)
+
 // Documentation in literate-programming-style is available at:
 // https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/types.html
 
@@ -30,10 +35,13 @@ type ClusterName string
 type ClusterList []ClusterName
 
 // TableAndKey represents a key for given table used by cleanup process. Each
-// row is deleted by specifying table name and a key
+// row is deleted by specifying table name and a key. Tables with composite
+// primary key containing organization ID have OrgKeyName set as well, so it
+// is possible to delete rows belonging to selected organization only.
 type TableAndKey struct {
-	TableName string
-	KeyName   string
+	TableName  string
+	KeyName    string
+	OrgKeyName string
 }
 
 // TableAndDeleteStatement represents a delete statement for the given table.
@@ -44,27 +52,307 @@ type TableAndDeleteStatement struct {
 	DeleteStatement string
 }
 
+// TableAndPayload represents a column with heavy payload in given table that
+// can be compacted for old records. Condition selects old records, it must
+// have just one parameter that will be populated with the max age value.
+type TableAndPayload struct {
+	TableName     string
+	PayloadColumn string
+	Condition     string
+}
+
+// TableSize represents size of one table (including indexes and TOAST data)
+// and estimated number of rows in it
+type TableSize struct {
+	TableName string
+	SizeBytes int64
+	RowCount  int64
+}
+
+// OldOCPReport represents one old record read from report table
+type OldOCPReport struct {
+	ClusterName string
+	Reported    time.Time
+	LastChecked time.Time
+	Age         time.Duration
+}
+
+// OldDVOReport represents one old record read from dvo_report table
+type OldDVOReport struct {
+	OrgID           int
+	ClusterName     string
+	NamespaceID     string
+	NamespaceName   string
+	Recommendations int
+	Objects         int
+	Reported        time.Time
+	LastChecked     time.Time
+	Age             time.Duration
+}
+
+// OldRating represents one old record read from advisor_ratings table
+type OldRating struct {
+	OrgID         string
+	RuleFQDN      string
+	ErrorKey      string
+	RuleID        string
+	Rating        int
+	LastUpdatedAt time.Time
+	Age           time.Duration
+}
+
+// OldConsumerError represents one old record read from consumer_error table
+type OldConsumerError struct {
+	Topic      string
+	Partition  int
+	Offset     int
+	Key        string
+	ConsumedAt time.Time
+	Message    string
+	Age        time.Duration
+}
+
+// OldRecommendation represents one old record read from recommendation table
+type OldRecommendation struct {
+	OrgID       int
+	ClusterName string
+	RuleFQDN    string
+	ErrorKey    string
+	RuleID      string
+	CreatedAt   time.Time
+	Age         time.Duration
+}
+
+// OldReportInfo represents one record read from report_info table that
+// belongs to old report
+type OldReportInfo struct {
+	OrgID       int
+	ClusterName string
+	VersionInfo string
+	Reported    time.Time
+	Age         time.Duration
+}
+
+// OldRecordsCount represents number of old records of one category, as
+// displayed by count-only listing
+type OldRecordsCount struct {
+	Category string
+	Table    string
+	Count    int
+}
+
+// OldRecordsEstimate represents estimated number of old records of one
+// category computed from planner statistics together with exact number of
+// old records (they are not valid when they are not known)
+type OldRecordsEstimate struct {
+	Category string
+	Table    string
+	Estimate sql.NullInt64
+	Count    sql.NullInt64
+}
+
+// MaxAgeComparison represents number of records that would be deleted from
+// one table for two different max age values
+type MaxAgeComparison struct {
+	Table          string
+	Deletions      int
+	OtherDeletions int
+}
+
+// Delta method returns difference between number of records deleted for
+// the other max age and for the original one
+func (comparison MaxAgeComparison) Delta() int {
+	return comparison.OtherDeletions - comparison.Deletions
+}
+
+// CronJob represents one pg_cron job installed by the cleaner
+type CronJob struct {
+	JobID    int64
+	JobName  string
+	Schedule string
+	Command  string
+	Active   bool
+}
+
+// TableBloat represents number of live and dead tuples in one table together
+// with the lowest density of leaf pages of its B-tree indexes (it is not
+// valid when the density can not be measured)
+type TableBloat struct {
+	TableName        string
+	LiveTuples       int64
+	DeadTuples       int64
+	IndexLeafDensity sql.NullFloat64
+}
+
+// DeadTupleRatio method returns ratio of dead tuples in table
+func (bloat TableBloat) DeadTupleRatio() float64 {
+	total := bloat.LiveTuples + bloat.DeadTuples
+	if total == 0 {
+		return 0
+	}
+	return float64(bloat.DeadTuples) / float64(total)
+}
+
+// Recommendation method returns maintenance operation recommended for table.
+// Only one operation is recommended, VACUUM FULL rebuilds indexes as well.
+func (bloat TableBloat) Recommendation() string {
+	ratio := bloat.DeadTupleRatio()
+	switch {
+	case ratio >= vacuumFullDeadTupleRatio:
+		return recommendVacuumFull
+	case bloat.IndexLeafDensity.Valid && bloat.IndexLeafDensity.Float64 < reindexLeafDensity:
+		return recommendReindex
+	case ratio >= vacuumDeadTupleRatio:
+		return recommendVacuum
+	default:
+		return recommendNothing
+	}
+}
+
 // Summary represents summary info to be displayed in a table after cleanup
 // part
 type Summary struct {
+	Target                 string
+	RunID                  string
+	RequestedBy            string
 	ProperClusterEntries   int
 	ImproperClusterEntries int
-	DeletionsForTable      map[string]int
+	// NormalizedClusterEntries is number of cluster IDs converted into
+	// lowercase canonical form
+	NormalizedClusterEntries int
+	FailedClusterEntries     int
+	DeletionsForTable        map[string]int
+	// RowsBeforeCleanup contains estimated number of rows in each table
+	// before the cleanup, it is not displayed when it is nil
+	RowsBeforeCleanup map[string]int64
+	// SortByDeletions is set when tables are displayed ordered by number
+	// of deletions instead of their names
+	SortByDeletions bool
+	AnalyzedTables  []string
+	// Reconciliation is set only by cleanup of selected clusters
+	Reconciliation *Reconciliation
+	// Verification is set only when records of cleaned up clusters are
+	// counted after cleanup
+	Verification *DeletionVerification
+}
+
+// ListingFilter represents filter applied to records displayed by listing
+// operations. Zero values mean that the records are not filtered by given
+// criteria.
+type ListingFilter struct {
+	// OlderThan selects only records older than given age
+	OlderThan time.Duration
+	// NewerThan selects only records newer than given age
+	NewerThan time.Duration
+	// Limit is max number of records listed from each table, all
+	// records are listed when it is zero
+	Limit int
+}
+
+// Matches method checks if record with given age passes the filter.
+// Records with negative age (ie. with timestamp in the future) never pass
+// the filter, they are reported separately.
+func (filter ListingFilter) Matches(age time.Duration) bool {
+	if age < 0 {
+		return false
+	}
+	if filter.OlderThan > 0 && age <= filter.OlderThan {
+		return false
+	}
+	if filter.NewerThan > 0 && age >= filter.NewerThan {
+		return false
+	}
+	return true
+}
+
+// filtersByAge method checks if the filter selects records by their age
+func (filter ListingFilter) filtersByAge() bool {
+	return filter.OlderThan > 0 || filter.NewerThan > 0
 }
 
 // CliFlags represents structure holding all command line arguments and flags.
 type CliFlags struct {
+	ConfigFile                string
 	ShowVersion               bool
 	ShowAuthors               bool
 	ShowConfiguration         bool
+	ListQueries               bool
+	ListExitCodes             bool
 	PrintSummaryTable         bool
+	SummaryByDeletions        bool
+	NoColor                   bool
 	Output                    string
+	Checksum                  bool
+	OutputFormat              string
+	Columns                   string
+	HashClusterIDs            bool
 	PerformCleanup            bool
 	PerformCleanupAll         bool
+	SimulateInSchema          string
+	CleanupRule               string
+	CleanupKafkaOffsets       string
+	CleanupRatings            bool
+	Rule                      string
+	CompactPayloads           bool
+	ValidatePayloads          bool
 	DryRun                    bool
+	ReadOnly                  bool
+	SafeMode                  bool
+	Database                  string
+	Schema                    string
+	Force                     bool
 	DetectMultipleRuleDisable bool
+	DVONamespaceStatistics    bool
+	TimestampAnomalies        string
+	SizeSnapshot              string
 	FillInDatabase            bool
+	FillInClusters            int
+	FillInBatchSize           int
+	InitSchema                bool
 	VacuumDatabase            bool
+	VacuumFull                string
+	ConfirmVacuumFull         string
+	InstallDBSchedule         string
+	UninstallDBSchedule       bool
+	ShowDBSchedule            bool
 	MaxAge                    string
+	ConfirmMaxAge             string
+	CompareMaxAge             string
+	OlderThan                 string
+	NewerThan                 string
+	Limit                     int
+	CountOnly                 bool
+	Estimate                  bool
+	ExactCount                bool
+	FailIfMoreThan            string
+	FailIfNone                bool
+	ConsumerErrorOffsets      bool
+	BloatReport               bool
+	Coverage                  bool
+	DiscoverTables            string
+	KafkaLowWatermarks        string
+	ExportConsumerErrors      bool
+	DeleteExported            bool
+	Digest                    bool
+	DigestFormat              string
+	ExportState               string
+	ImportState               string
+	Status                    bool
 	Clusters                  string
+	Cluster                   string
+	Watch                     string
+	ClustersFromInventory     bool
+	ClustersFromAggregator    bool
+	OrgBatch                  string
+	OrgID                     int
+	Transactional             bool
+	SkipCanary                bool
+	VerifyDeletion            bool
+	RedeleteStragglers        bool
+	DeletionMatrix            string
+	LogSQL                    bool
+	RequestedBy               string
+	// ServiceAccount is identity read from service account token mounted
+	// into pod, it is used by authorization instead of RequestedBy
+	ServiceAccount string
 }
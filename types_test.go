@@ -18,3 +18,43 @@ package main_test
 
 // Documentation in literate-programming-style is available at:
 // https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/types_test.html
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// TestListingFilterMatches checks the method ListingFilter.Matches
+func TestListingFilterMatches(t *testing.T) {
+	const day = 24 * time.Hour
+
+	// empty filter matches everything
+	filter := main.ListingFilter{}
+	assert.True(t, filter.Matches(0))
+	assert.True(t, filter.Matches(1000*day))
+
+	// future-dated records never match
+	assert.False(t, filter.Matches(-day))
+
+	// only older records
+	filter = main.ListingFilter{OlderThan: 180 * day}
+	assert.False(t, filter.Matches(100*day))
+	assert.False(t, filter.Matches(180*day))
+	assert.True(t, filter.Matches(181*day))
+
+	// only newer records
+	filter = main.ListingFilter{NewerThan: 365 * day}
+	assert.True(t, filter.Matches(100*day))
+	assert.False(t, filter.Matches(365*day))
+	assert.False(t, filter.Matches(400*day))
+
+	// age bucket
+	filter = main.ListingFilter{OlderThan: 180 * day, NewerThan: 365 * day}
+	assert.False(t, filter.Matches(100*day))
+	assert.True(t, filter.Matches(200*day))
+	assert.False(t, filter.Matches(400*day))
+}
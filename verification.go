@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/verification.html
+
+// This source file contains implementation of deletion verification pass.
+// When -verify-deletion command line option is used, records of each cleaned
+// up cluster are counted again in all tables after cleanup. Rows found by this
+// pass (stragglers) have been inserted during the run, for example by the
+// pipeline processing new reports. Stragglers are logged and displayed below
+// the summary table. When -redelete-stragglers is used, clusters with
+// stragglers are cleaned up in second pass and they are verified again.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrStragglersFound is returned when rows of cleaned up clusters remained
+// in database after cleanup and optional second pass
+var ErrStragglersFound = errors.New("rows of cleaned up clusters remained in database")
+
+// StragglerRows represents rows of one cluster found in one table after the
+// cluster has been cleaned up
+type StragglerRows struct {
+	Cluster ClusterName `json:"cluster"`
+	Table   string      `json:"table"`
+	Count   int         `json:"count"`
+}
+
+// DeletionVerification represents result of deletion verification pass
+type DeletionVerification struct {
+	// VerifiedClusters is number of clusters counted after cleanup
+	VerifiedClusters int
+	// Stragglers contains rows found by the first verification pass
+	Stragglers []StragglerRows
+	// SecondPass is set when clusters with stragglers were cleaned up
+	// again, RedeletedRows contains number of rows deleted by this pass
+	SecondPass    bool
+	RedeletedRows int
+	// Remaining contains rows that remained in database at the end
+	Remaining []StragglerRows
+}
+
+// totalStragglerRows function returns number of rows in all given stragglers
+func totalStragglerRows(stragglers []StragglerRows) int {
+	total := 0
+	for _, straggler := range stragglers {
+		total += straggler.Count
+	}
+	return total
+}
+
+// stragglerClusters function returns clusters that have some stragglers,
+// each cluster is returned just once
+func stragglerClusters(stragglers []StragglerRows) ClusterList {
+	var clusters ClusterList
+	seen := make(map[ClusterName]struct{})
+	for _, straggler := range stragglers {
+		if _, found := seen[straggler.Cluster]; found {
+			continue
+		}
+		seen[straggler.Cluster] = struct{}{}
+		clusters = append(clusters, straggler.Cluster)
+	}
+	return clusters
+}
+
+// findStragglers function counts records of given clusters in all tables
+// and returns tables where some records remained
+func findStragglers(connection *sql.DB, schema string, clusters ClusterList, orgID int) (
+	[]StragglerRows, error) {
+	tablesAndKeys, err := tablesAndKeysForSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var stragglers []StragglerRows
+	for _, cluster := range clusters {
+		counts, err := inspectCluster(connection, tablesAndKeys, cluster, orgID)
+		if err != nil {
+			return stragglers, err
+		}
+		for _, count := range counts {
+			if count.Count == 0 {
+				continue
+			}
+			log.Warn().
+				Str(clusterNameMsg, string(cluster)).
+				Str(tableName, count.Table).
+				Int("rows", count.Count).
+				Msg("Rows remained after cleanup")
+			stragglers = append(stragglers, StragglerRows{
+				Cluster: cluster,
+				Table:   count.Table,
+				Count:   count.Count,
+			})
+		}
+	}
+	return stragglers, nil
+}
+
+// verifyDeletion method counts records of all clusters that have been
+// cleaned up and cleans up clusters with stragglers in second pass when
+// requested. Rows deleted by the second pass are accumulated into results of
+// the cleanup. Error is returned when some rows remained in database or when
+// they can not be counted.
+func (cleanup *clusterListCleanup) verifyDeletion(configuration *ConfigStruct, connection *sql.DB,
+	cliFlags CliFlags, schema string) (DeletionVerification, error) {
+	var verification DeletionVerification
+
+	// clusters that failed are known to have some rows
+	failed := make(map[ClusterName]struct{})
+	for _, cluster := range cleanup.failedClusters {
+		failed[cluster] = struct{}{}
+	}
+	var clusters ClusterList
+	for _, cluster := range cleanup.clusterList {
+		if _, found := failed[cluster]; !found {
+			clusters = append(clusters, cluster)
+		}
+	}
+	verification.VerifiedClusters = len(clusters)
+
+	stragglers, err := findStragglers(connection, schema, clusters, cliFlags.OrgID)
+	if err != nil {
+		log.Err(err).Msg("Deletion verification")
+		return verification, err
+	}
+	verification.Stragglers = stragglers
+	verification.Remaining = stragglers
+
+	if len(stragglers) > 0 && cliFlags.RedeleteStragglers {
+		verification.SecondPass = true
+		redeleted := stragglerClusters(stragglers)
+		deletionsForTable, deletionsForCluster, _, err := performClustersCleanupInDB(
+			connection, redeleted, schema,
+			cliFlags.OrgID, configuration.Cleaner.ClusterRetries, cliFlags.Transactional)
+		if err != nil {
+			// remaining rows are found by the next verification
+			log.Err(err).Msg("Second pass of cleanup")
+		}
+		for table, deleted := range deletionsForTable {
+			cleanup.deletionsForTable[table] += deleted
+		}
+		for cluster, deletions := range deletionsForCluster {
+			cleanup.deletionsForCluster.add(cluster, deletions)
+		}
+		verification.RedeletedRows = deletedRowsCount(deletionsForTable)
+
+		verification.Remaining, err = findStragglers(connection, schema, redeleted, cliFlags.OrgID)
+		if err != nil {
+			log.Err(err).Msg("Deletion verification")
+			return verification, err
+		}
+	}
+
+	log.Info().
+		Int("clusters", verification.VerifiedClusters).
+		Int("stragglers", totalStragglerRows(verification.Stragglers)).
+		Int("redeleted", verification.RedeletedRows).
+		Int("remaining", totalStragglerRows(verification.Remaining)).
+		Msg("Deletion verification finished")
+
+	if len(verification.Remaining) > 0 {
+		return verification, fmt.Errorf("%w: %d rows of %d clusters", ErrStragglersFound,
+			totalStragglerRows(verification.Remaining), len(stragglerClusters(verification.Remaining)))
+	}
+	return verification, nil
+}
+
+// PrintDeletionVerification function displays a table with result of
+// deletion verification pass followed by rows that remained in database
+func PrintDeletionVerification(verification DeletionVerification) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetColWidth(60)
+
+	// table header
+	table.SetHeader([]string{"Verification", "Count"})
+
+	table.Append([]string{"Verified clusters", strconv.Itoa(verification.VerifiedClusters)})
+	table.Append([]string{"Straggler rows", strconv.Itoa(totalStragglerRows(verification.Stragglers))})
+	if verification.SecondPass {
+		table.Append([]string{"Re-deleted rows", strconv.Itoa(verification.RedeletedRows)})
+	}
+
+	// table footer
+	table.SetFooter([]string{"Remaining rows", strconv.Itoa(totalStragglerRows(verification.Remaining))})
+
+	// display the whole table
+	table.Render()
+
+	// rows that remained are listed explicitly
+	for _, straggler := range verification.Remaining {
+		fmt.Printf("Remaining rows of cluster %s in table '%s': %d\n",
+			straggler.Cluster, straggler.Table, straggler.Count)
+	}
+}
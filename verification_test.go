@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/verification_test.html
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// countDVORecords is statement expected by deletion verification
+const countDVORecords = "SELECT COUNT\\(\\*\\) FROM dvo_report WHERE cluster_id = \\$1;"
+
+// expectDVORecordsCount function registers expected counting of records of
+// given cluster
+func expectDVORecordsCount(mock sqlmock.Sqlmock, cluster string, count int) {
+	mock.ExpectQuery(countDVORecords).
+		WithArgs(cluster).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+// verificationCliFlags function returns command line flags used to clean up
+// and verify two clusters
+func verificationCliFlags() cleaner.CliFlags {
+	return cleaner.CliFlags{
+		PerformCleanup: true,
+		Clusters:       cluster1ID + "," + cluster2ID,
+		SkipCanary:     true,
+		VerifyDeletion: true,
+	}
+}
+
+// TestCleanupVerifyDeletion checks that nothing is reported when no rows
+// remained after cleanup
+func TestCleanupVerifyDeletion(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, nil)
+	expectDVOClusterDeletion(mock, cluster2ID, nil)
+	expectDVORecordsCount(mock, cluster1ID, 0)
+	expectDVORecordsCount(mock, cluster2ID, 0)
+	mock.ExpectClose()
+
+	status, err := cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, verificationCliFlags(),
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupVerifyDeletionStragglers checks that rows remaining after
+// cleanup are reported
+func TestCleanupVerifyDeletionStragglers(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, nil)
+	expectDVOClusterDeletion(mock, cluster2ID, nil)
+	expectDVORecordsCount(mock, cluster1ID, 0)
+	// rows inserted during the run
+	expectDVORecordsCount(mock, cluster2ID, 2)
+	mock.ExpectClose()
+
+	cliFlags := verificationCliFlags()
+	cliFlags.PrintSummaryTable = true
+
+	var status cleaner.ExitStatus
+	var cleanupErr error
+	output, err := capture.StandardOutput(func() {
+		status, cleanupErr = cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, cliFlags,
+			cleaner.DBSchemaDVORecommendations)
+	})
+	checkCapture(t, err)
+
+	assert.ErrorIs(t, cleanupErr, cleaner.ErrStragglersFound)
+	assert.Equal(t, cleaner.ExitStatusStragglersFound, status)
+	assert.Contains(t, output, "Straggler rows")
+	assert.Contains(t, output, "Remaining rows of cluster "+cluster2ID+" in table 'dvo_report': 2")
+	assert.NotContains(t, output, "Re-deleted rows")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestCleanupRedeleteStragglers checks that clusters with rows remaining
+// after cleanup are cleaned up in second pass and verified again
+func TestCleanupRedeleteStragglers(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, nil)
+	expectDVOClusterDeletion(mock, cluster2ID, nil)
+	expectDVORecordsCount(mock, cluster1ID, 0)
+	expectDVORecordsCount(mock, cluster2ID, 1)
+	// second pass for the cluster with stragglers only
+	expectDVOClusterDeletion(mock, cluster2ID, nil)
+	expectDVORecordsCount(mock, cluster2ID, 0)
+	mock.ExpectClose()
+
+	cliFlags := verificationCliFlags()
+	cliFlags.VerifyDeletion = false
+	cliFlags.RedeleteStragglers = true
+	cliFlags.PrintSummaryTable = true
+
+	var status cleaner.ExitStatus
+	var cleanupErr error
+	output, err := capture.StandardOutput(func() {
+		status, cleanupErr = cleaner.Cleanup(&cleaner.ConfigStruct{}, connection, cliFlags,
+			cleaner.DBSchemaDVORecommendations)
+	})
+	checkCapture(t, err)
+
+	assert.NoError(t, cleanupErr)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.Contains(t, output, "Re-deleted rows")
+	assert.NotContains(t, output, "Remaining rows of cluster")
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
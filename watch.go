@@ -0,0 +1,439 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/watch.html
+
+// This source file contains implementation of watch mode. Directory (or
+// prefix in S3 bucket) specified by -watch command line option is used as a
+// lightweight queue of deletion requests: each request ticket drops its own
+// cluster list file there. Each file matching the configured pattern is
+// cleaned up the same way as cluster list file, then it is moved into done or
+// failed folder together with JSON document describing the result. Cleanup
+// itself is recorded in run history and deletion evidence as usual, so the
+// results are part of the audit trail.
+//
+// Pending requests are processed once when poll_interval in [watch] section
+// is not set (this is useful when the cleaner is started periodically).
+// Otherwise the cleaner polls for new requests until it is interrupted.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of folders where processed requests are moved, and default pattern
+// of request file names
+const (
+	watchDoneFolder     = "done"
+	watchFailedFolder   = "failed"
+	defaultWatchPattern = "*.txt"
+	watchResultSuffix   = ".result.json"
+	requestAttribute    = "request"
+)
+
+// ErrWatchWithTargets is returned when watch mode is started with more
+// storage targets, because each request would be moved by the first target
+var ErrWatchWithTargets = errors.New("watch mode can not be used together with storage targets")
+
+// WatchRequestResult represents result of one processed deletion request,
+// it is stored next to the request in done or failed folder
+type WatchRequestResult struct {
+	Request    string     `json:"request"`
+	RunID      string     `json:"run_id"`
+	Target     string     `json:"target"`
+	Started    time.Time  `json:"started"`
+	Finished   time.Time  `json:"finished"`
+	ExitStatus ExitStatus `json:"exit_status"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Succeeded method checks if the request has been processed successfully
+func (result WatchRequestResult) Succeeded() bool {
+	return result.ExitStatus == ExitStatusOK
+}
+
+// watchSource represents location watched for new deletion requests
+type watchSource interface {
+	// pendingRequests method returns sorted names of requests matching
+	// given pattern that have not been processed yet
+	pendingRequests(pattern string) ([]string, error)
+	// fetchRequest method returns name of local file with given request
+	fetchRequest(name string) (string, error)
+	// finishRequest method moves processed request into given folder
+	// together with document describing its result
+	finishRequest(name, folder string, result []byte) error
+}
+
+// directoryWatchSource watches local directory
+type directoryWatchSource struct {
+	directory string
+}
+
+// pendingRequests method returns regular files from watched directory
+func (source directoryWatchSource) pendingRequests(pattern string) ([]string, error) {
+	filenames, err := filepath.Glob(filepath.Join(source.directory, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			names = append(names, filepath.Base(filename))
+		}
+	}
+	return names, nil
+}
+
+// fetchRequest method returns name of request file in watched directory
+func (source directoryWatchSource) fetchRequest(name string) (string, error) {
+	return filepath.Join(source.directory, name), nil
+}
+
+// finishRequest method moves request file into given subdirectory of
+// watched directory
+func (source directoryWatchSource) finishRequest(name, folder string, result []byte) error {
+	target := filepath.Join(source.directory, folder)
+	if err := os.MkdirAll(target, 0o750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(target, name+watchResultSuffix), result, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(source.directory, name), filepath.Join(target, name))
+}
+
+// s3WatchSource watches prefix in S3 bucket. Requests are downloaded into
+// local directory before they are processed.
+type s3WatchSource struct {
+	session   *session.Session
+	bucket    string
+	prefix    string
+	directory string
+}
+
+// key method returns key of object with given name in given folder
+func (source *s3WatchSource) key(folder, name string) string {
+	return strings.TrimPrefix(path.Join(source.prefix, folder, name), "/")
+}
+
+// pendingRequests method returns objects stored directly under watched
+// prefix, objects in done and failed folders are not listed
+func (source *s3WatchSource) pendingRequests(pattern string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(source.bucket),
+		Delimiter: aws.String("/"),
+	}
+	if source.prefix != "" {
+		input.Prefix = aws.String(source.prefix + "/")
+	}
+
+	var names []string
+	var matchErr error
+	err := s3.New(source.session).ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			name := path.Base(aws.StringValue(object.Key))
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				matchErr = err
+				return false
+			}
+			if matched {
+				names = append(names, name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, matchErr
+}
+
+// fetchRequest method downloads request into local directory
+func (source *s3WatchSource) fetchRequest(name string) (string, error) {
+	localFile := filepath.Join(source.directory, name)
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Create(localFile) // #nosec G304
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s3manager.NewDownloader(source.session).Download(file, &s3.GetObjectInput{
+		Bucket: aws.String(source.bucket),
+		Key:    aws.String(source.key("", name)),
+	})
+	return localFile, errors.Join(err, file.Close())
+}
+
+// finishRequest method copies request into given folder under watched
+// prefix, stores its result there, and deletes the original request
+func (source *s3WatchSource) finishRequest(name, folder string, result []byte) error {
+	client := s3.New(source.session)
+	key := source.key("", name)
+
+	_, err := s3manager.NewUploader(source.session).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(source.bucket),
+		Key:    aws.String(source.key(folder, name+watchResultSuffix)),
+		Body:   strings.NewReader(string(result)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(source.bucket),
+		CopySource: aws.String(url.PathEscape(source.bucket + "/" + key)),
+		Key:        aws.String(source.key(folder, name)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(source.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	// local copy is not needed anymore (it does not exist when the
+	// request could not be downloaded)
+	err = os.Remove(filepath.Join(source.directory, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// parseS3Location function splits location like s3://bucket/prefix into
+// bucket name and prefix (that can be empty)
+func parseS3Location(location string) (string, string, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, s3OutputPrefix), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("S3 location must be specified as s3://bucket/prefix: %s", location)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// newWatchSource function creates source of deletion requests for given
+// directory or S3 location
+func newWatchSource(location string, s3Config S3Configuration) (watchSource, error) {
+	if !isS3Output(location) {
+		info, err := os.Stat(location)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("watched location %s is not a directory", location)
+		}
+		return directoryWatchSource{directory: location}, nil
+	}
+
+	bucket, prefix, err := parseS3Location(location)
+	if err != nil {
+		return nil, err
+	}
+	awsSession, err := newS3Session(s3Config)
+	if err != nil {
+		return nil, err
+	}
+	directory, err := os.MkdirTemp("", "cleaner-watch-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3WatchSource{
+		session:   awsSession,
+		bucket:    bucket,
+		prefix:    prefix,
+		directory: directory,
+	}, nil
+}
+
+// processRequest function cleans up clusters listed in one deletion request
+// and moves the request into done or failed folder
+func processRequest(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string,
+	source watchSource, name string) (WatchRequestResult, error) {
+	result := WatchRequestResult{
+		Request: name,
+		RunID:   runID,
+		Target:  configuration.Storage.Name,
+		Started: time.Now().UTC(),
+	}
+
+	localFile, err := source.fetchRequest(name)
+	if err == nil {
+		// only clusters from the request are cleaned up
+		requestConfiguration := *configuration
+		requestConfiguration.Cleaner.ClusterListFile = localFile
+		requestConfiguration.ClusterList.Provider = ClusterListProviderFile
+		requestFlags := cliFlags
+		requestFlags.Clusters = ""
+		requestFlags.ClustersFromInventory = false
+		requestFlags.ClustersFromAggregator = false
+		result.ExitStatus, err = cleanup(&requestConfiguration, connection, requestFlags, schema)
+	} else {
+		result.ExitStatus = ExitStatusStorageError
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Finished = time.Now().UTC()
+
+	folder := watchDoneFolder
+	if !result.Succeeded() {
+		folder = watchFailedFolder
+	}
+	document, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := source.finishRequest(name, folder, document); err != nil {
+		return result, err
+	}
+
+	log.Info().
+		Str(requestAttribute, name).
+		Str("folder", folder).
+		Int("exit status", int(result.ExitStatus)).
+		Str("error", result.Error).
+		Msg("Deletion request processed")
+	return result, nil
+}
+
+// processPendingRequests function processes all pending deletion requests
+// and returns number of requests that failed. Processing stops when
+// maintenance window ends, remaining requests stay pending.
+func processPendingRequests(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags,
+	schema string, source watchSource) (int, error) {
+	pattern := GetWatchConfiguration(configuration).Pattern
+	if pattern == "" {
+		pattern = defaultWatchPattern
+	}
+
+	names, err := source.pendingRequests(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, name := range names {
+		err := checkMaintenanceWindow(GetMaintenanceWindowConfiguration(configuration), cliFlags, time.Now())
+		if err != nil {
+			log.Warn().Err(err).Int("pending", len(names)).Msg("Processing of deletion requests postponed")
+			return failed, nil
+		}
+
+		result, err := processRequest(configuration, connection, cliFlags, schema, source, name)
+		if err != nil {
+			log.Err(err).Str(requestAttribute, name).Msg("Finish deletion request")
+			return failed, err
+		}
+		if !result.Succeeded() {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// watchRequests function processes deletion requests dropped into watched
+// directory or S3 prefix
+func watchRequests(configuration *ConfigStruct, connection *sql.DB, cliFlags CliFlags, schema string) (
+	ExitStatus, error) {
+	if len(GetTargetsConfiguration(configuration)) > 0 {
+		return ExitStatusConfigError, ErrWatchWithTargets
+	}
+
+	source, err := newWatchSource(cliFlags.Watch, GetOutputConfiguration(configuration).S3)
+	if err != nil {
+		log.Err(err).Str("location", cliFlags.Watch).Msg("Watch deletion requests")
+		return ExitStatusStorageError, err
+	}
+	if s3Source, ok := source.(*s3WatchSource); ok {
+		defer func() {
+			if err := os.RemoveAll(s3Source.directory); err != nil {
+				log.Error().Err(err).Msg(removeFileMsg)
+			}
+		}()
+	}
+
+	// poll interval is checked during configuration validation
+	var interval time.Duration
+	if pollInterval := GetWatchConfiguration(configuration).PollInterval; pollInterval != "" {
+		interval, err = time.ParseDuration(pollInterval)
+		if err != nil {
+			return ExitStatusConfigError, invalidConfiguration("watch.poll_interval", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().
+		Str("location", cliFlags.Watch).
+		Dur("poll interval", interval).
+		Msg("Watching deletion requests")
+	for {
+		failed, err := processPendingRequests(configuration, connection, cliFlags, schema, source)
+
+		// pending requests are processed just once
+		if interval == 0 {
+			if err != nil {
+				return ExitStatusStorageError, err
+			}
+			if failed > 0 {
+				return ExitStatusPerformCleanupError, fmt.Errorf("%d deletion requests failed", failed)
+			}
+			return ExitStatusOK, nil
+		}
+
+		if err != nil {
+			log.Err(err).Msg("Process deletion requests")
+		}
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Watching deletion requests stopped")
+			return ExitStatusOK, nil
+		case <-time.After(interval):
+		}
+	}
+}
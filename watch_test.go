@@ -0,0 +1,210 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/watch_test.html
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// writeRequest function writes deletion request into watched directory
+func writeRequest(t *testing.T, directory, name string, clusters ...string) {
+	content := ""
+	for _, cluster := range clusters {
+		content += cluster + "\n"
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600))
+}
+
+// readRequestResult function reads result of processed deletion request
+func readRequestResult(t *testing.T, filename string) cleaner.WatchRequestResult {
+	var result cleaner.WatchRequestResult
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(content, &result))
+	return result
+}
+
+// expectDVOClusterDeletion function registers expected deletion of DVO
+// records of given cluster
+func expectDVOClusterDeletion(mock sqlmock.Sqlmock, cluster string, err error) {
+	for _, tableAndKey := range cleaner.TablesAndKeysInDVODatabase {
+		expectedExec := mock.ExpectExec(fmt.Sprintf("DELETE FROM %v WHERE %v = \\$",
+			tableAndKey.TableName, tableAndKey.KeyName)).WithArgs(cluster)
+		if err != nil {
+			expectedExec.WillReturnError(err)
+		} else {
+			expectedExec.WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+	}
+}
+
+// TestWatchRequests checks that pending requests are processed once and
+// moved into done and failed folders
+func TestWatchRequests(t *testing.T) {
+	directory := t.TempDir()
+	writeRequest(t, directory, "ticket-1.txt", cluster1ID)
+	writeRequest(t, directory, "ticket-2.txt", cluster2ID)
+	// file not matching the pattern
+	writeRequest(t, directory, "notes.md", cluster3ID)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster1ID, nil)
+	expectDVOClusterDeletion(mock, cluster2ID, errors.New("delete error"))
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{Name: "primary"},
+	}
+	status, err := cleaner.WatchRequests(&configuration, connection, cleaner.CliFlags{Watch: directory},
+		cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusPerformCleanupError, status)
+
+	assert.FileExists(t, filepath.Join(directory, "done", "ticket-1.txt"))
+	result := readRequestResult(t, filepath.Join(directory, "done", "ticket-1.txt.result.json"))
+	assert.Equal(t, "ticket-1.txt", result.Request)
+	assert.Equal(t, "primary", result.Target)
+	assert.Equal(t, cleaner.ExitStatusOK, result.ExitStatus)
+	assert.Empty(t, result.Error)
+
+	assert.FileExists(t, filepath.Join(directory, "failed", "ticket-2.txt"))
+	result = readRequestResult(t, filepath.Join(directory, "failed", "ticket-2.txt.result.json"))
+	assert.Equal(t, cleaner.ExitStatusCanaryFailed, result.ExitStatus)
+	assert.Contains(t, result.Error, "delete error")
+
+	// only processed requests are moved
+	assert.NoFileExists(t, filepath.Join(directory, "ticket-1.txt"))
+	assert.NoFileExists(t, filepath.Join(directory, "ticket-2.txt"))
+	assert.FileExists(t, filepath.Join(directory, "notes.md"))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestWatchRequestsPattern checks that requests are selected by pattern
+// from configuration
+func TestWatchRequestsPattern(t *testing.T) {
+	directory := t.TempDir()
+	writeRequest(t, directory, "ticket-1.txt", cluster1ID)
+	writeRequest(t, directory, "ticket-2.list", cluster2ID)
+
+	// prepare new mocked connection to database
+	connection, mock, err := sqlmock.New()
+	assert.NoError(t, err, "error creating SQL mock")
+
+	expectDVOClusterDeletion(mock, cluster2ID, nil)
+	mock.ExpectClose()
+
+	configuration := cleaner.ConfigStruct{
+		Watch: cleaner.WatchConfiguration{Pattern: "*.list"},
+	}
+	status, err := cleaner.WatchRequests(&configuration, connection, cleaner.CliFlags{Watch: directory},
+		cleaner.DBSchemaDVORecommendations)
+	assert.NoError(t, err)
+	assert.Equal(t, cleaner.ExitStatusOK, status)
+	assert.FileExists(t, filepath.Join(directory, "done", "ticket-2.list"))
+	assert.FileExists(t, filepath.Join(directory, "ticket-1.txt"))
+
+	// check if DB can be closed successfully
+	checkConnectionClose(t, connection)
+
+	// check all DB expectactions happened correctly
+	checkAllExpectations(t, mock)
+}
+
+// TestWatchRequestsRefused checks that watch mode is refused for missing
+// directory and for more storage targets
+func TestWatchRequestsRefused(t *testing.T) {
+	configuration := cleaner.ConfigStruct{}
+	status, err := cleaner.WatchRequests(&configuration, nil,
+		cleaner.CliFlags{Watch: filepath.Join(t.TempDir(), "missing")}, cleaner.DBSchemaDVORecommendations)
+	assert.Error(t, err)
+	assert.Equal(t, cleaner.ExitStatusStorageError, status)
+
+	configuration.Targets = []cleaner.StorageConfiguration{{Name: "first"}, {Name: "second"}}
+	status, err = cleaner.WatchRequests(&configuration, nil,
+		cleaner.CliFlags{Watch: t.TempDir()}, cleaner.DBSchemaDVORecommendations)
+	assert.ErrorIs(t, err, cleaner.ErrWatchWithTargets)
+	assert.Equal(t, cleaner.ExitStatusConfigError, status)
+}
+
+// TestParseS3Location checks parsing of watched S3 location
+func TestParseS3Location(t *testing.T) {
+	testCases := []struct {
+		location string
+		bucket   string
+		prefix   string
+	}{
+		{"s3://requests", "requests", ""},
+		{"s3://requests/", "requests", ""},
+		{"s3://requests/cleaner/pending/", "requests", "cleaner/pending"},
+	}
+
+	for _, tc := range testCases {
+		bucket, prefix, err := cleaner.ParseS3Location(tc.location)
+		assert.NoError(t, err, tc.location)
+		assert.Equal(t, tc.bucket, bucket, tc.location)
+		assert.Equal(t, tc.prefix, prefix, tc.location)
+	}
+
+	_, _, err := cleaner.ParseS3Location("s3:///requests")
+	assert.Error(t, err)
+}
+
+// TestCheckWatchConfiguration checks validation of watch configuration
+func TestCheckWatchConfiguration(t *testing.T) {
+	configuration := cleaner.ConfigStruct{
+		Storage: cleaner.StorageConfiguration{
+			Driver: "sqlite3",
+			Schema: "ocp_recommendations",
+		},
+		Watch: cleaner.WatchConfiguration{PollInterval: "1m", Pattern: "*.txt"},
+	}
+	assert.NoError(t, cleaner.CheckConfiguration(&configuration))
+
+	for _, pollInterval := range []string{"soon", "0s", "-1m"} {
+		configuration.Watch.PollInterval = pollInterval
+		var configErr *cleaner.ErrInvalidConfiguration
+		assert.ErrorAs(t, cleaner.CheckConfiguration(&configuration), &configErr, pollInterval)
+		assert.Equal(t, "watch.poll_interval", configErr.Key)
+	}
+
+	configuration.Watch = cleaner.WatchConfiguration{Pattern: "[.txt"}
+	var configErr *cleaner.ErrInvalidConfiguration
+	assert.ErrorAs(t, cleaner.CheckConfiguration(&configuration), &configErr)
+	assert.Equal(t, "watch.pattern", configErr.Key)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/window.html
+
+// This source file contains implementation of maintenance window
+// enforcement. Destructive operations (cleanup, org batch erasure,
+// cleanup-all, cleanup-rule, cleanup by Kafka offsets, cleanup-ratings,
+// payload compaction and validation, and deletion of consumer errors not run
+// in dry-run mode, and VACUUM FULL) can be allowed only in time window
+// specified in [maintenance_window] section of configuration file, for
+// example from 01:00 to 05:00 UTC on weekdays. Outside the window such
+// operations are not started and the tool exits with ExitStatusOutsideWindow
+// unless -force command line option is used.
+//
+// Window that ends before it starts (for example from 22:00 to 02:00) spans
+// midnight, days are related to the start of the window in such case.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// clockLayout is layout used to specify start and end of maintenance window
+const clockLayout = "15:04"
+
+// ErrOutsideWindow is returned when destructive operation is started outside
+// configured maintenance window
+var ErrOutsideWindow = errors.New("destructive operation is not allowed outside maintenance window, use -force to override")
+
+// Names of days and groups of days that can be used in maintenance window
+// configuration
+var windowDays = map[string][]time.Weekday{
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"sun":      {time.Sunday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+// maintenanceWindow represents parsed maintenance window configuration
+type maintenanceWindow struct {
+	// start and end are durations since midnight
+	start    time.Duration
+	end      time.Duration
+	days     map[time.Weekday]bool
+	location *time.Location
+}
+
+// parseClock function parses time of day specified as HH:MM into duration
+// since midnight
+func parseClock(clock string) (time.Duration, error) {
+	parsed, err := time.Parse(clockLayout, strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("time of day '%s' needs to be specified as HH:MM", clock)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// parseMaintenanceWindow function parses maintenance window configuration.
+// Nil is returned when maintenance window is not configured.
+func parseMaintenanceWindow(windowCfg MaintenanceWindowConfiguration) (*maintenanceWindow, error) {
+	if windowCfg.Start == "" && windowCfg.End == "" {
+		return nil, nil
+	}
+
+	start, err := parseClock(windowCfg.Start)
+	if err != nil {
+		return nil, fmt.Errorf("Incorrect start of maintenance window: %w", err)
+	}
+	end, err := parseClock(windowCfg.End)
+	if err != nil {
+		return nil, fmt.Errorf("Incorrect end of maintenance window: %w", err)
+	}
+	if start == end {
+		return nil, errors.New("Maintenance window needs to have non-zero length")
+	}
+
+	location := time.UTC
+	if windowCfg.Timezone != "" {
+		location, err = time.LoadLocation(windowCfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Incorrect time zone of maintenance window: %w", err)
+		}
+	}
+
+	// all days are allowed when no day is specified
+	days := make(map[time.Weekday]bool)
+	for _, day := range windowCfg.Days {
+		weekdays, found := windowDays[strings.ToLower(strings.TrimSpace(day))]
+		if !found {
+			return nil, fmt.Errorf("Incorrect day of maintenance window: %s", day)
+		}
+		for _, weekday := range weekdays {
+			days[weekday] = true
+		}
+	}
+
+	return &maintenanceWindow{
+		start:    start,
+		end:      end,
+		days:     days,
+		location: location,
+	}, nil
+}
+
+// Contains method checks if given time is inside maintenance window
+func (window maintenanceWindow) Contains(t time.Time) bool {
+	t = t.In(window.location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+
+	if window.start < window.end {
+		return sinceMidnight >= window.start && sinceMidnight < window.end && window.dayAllowed(day)
+	}
+
+	// window spans midnight: days are related to the start of the window
+	if sinceMidnight >= window.start {
+		return window.dayAllowed(day)
+	}
+	if sinceMidnight < window.end {
+		return window.dayAllowed((day + 6) % 7)
+	}
+	return false
+}
+
+// dayAllowed method checks if maintenance window is open on given day
+func (window maintenanceWindow) dayAllowed(day time.Weekday) bool {
+	return len(window.days) == 0 || window.days[day]
+}
+
+// isDestructiveOperation function checks if selected operation deletes or
+// rewrites data
+func isDestructiveOperation(cliFlags CliFlags) bool {
+	return classifyOperation(cliFlags).destructive
+}
+
+// checkMaintenanceWindow function checks if destructive operation can be
+// started at given time
+func checkMaintenanceWindow(windowCfg MaintenanceWindowConfiguration, cliFlags CliFlags, now time.Time) error {
+	if !isDestructiveOperation(cliFlags) {
+		return nil
+	}
+
+	window, err := parseMaintenanceWindow(windowCfg)
+	if err != nil {
+		return err
+	}
+	// maintenance window is not configured
+	if window == nil || window.Contains(now) {
+		return nil
+	}
+
+	if cliFlags.Force {
+		log.Warn().
+			Str("start", windowCfg.Start).
+			Str("end", windowCfg.End).
+			Msg("Destructive operation forced outside maintenance window")
+		return nil
+	}
+	return ErrOutsideWindow
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-cleaner/packages/window_test.html
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cleaner "github.com/RedHatInsights/insights-results-aggregator-cleaner"
+)
+
+// weekdaysWindow is maintenance window used by tests
+var weekdaysWindow = cleaner.MaintenanceWindowConfiguration{
+	Start: "01:00",
+	End:   "05:00",
+	Days:  []string{"weekdays"},
+}
+
+// TestParseMaintenanceWindowNotConfigured checks that nil window is returned
+// when maintenance window is not configured
+func TestParseMaintenanceWindowNotConfigured(t *testing.T) {
+	window, err := cleaner.ParseMaintenanceWindow(cleaner.MaintenanceWindowConfiguration{})
+	assert.NoError(t, err)
+	assert.Nil(t, window)
+}
+
+// TestParseMaintenanceWindowImproperConfiguration checks that improper
+// maintenance window configuration is reported
+func TestParseMaintenanceWindowImproperConfiguration(t *testing.T) {
+	configurations := []cleaner.MaintenanceWindowConfiguration{
+		{Start: "01:00"},
+		{Start: "1am", End: "05:00"},
+		{Start: "01:00", End: "25:00"},
+		{Start: "01:00", End: "01:00"},
+		{Start: "01:00", End: "05:00", Days: []string{"Funday"}},
+		{Start: "01:00", End: "05:00", Timezone: "Mars/Olympus_Mons"},
+	}
+
+	for _, configuration := range configurations {
+		_, err := cleaner.ParseMaintenanceWindow(configuration)
+		assert.Error(t, err, configuration)
+	}
+}
+
+// TestMaintenanceWindowContains checks if times are inside maintenance window
+func TestMaintenanceWindowContains(t *testing.T) {
+	window, err := cleaner.ParseMaintenanceWindow(weekdaysWindow)
+	assert.NoError(t, err)
+
+	// 2024-01-01 is Monday
+	assert.True(t, window.Contains(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)))
+	assert.True(t, window.Contains(time.Date(2024, 1, 1, 4, 59, 59, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2024, 1, 1, 0, 59, 0, 0, time.UTC)))
+	// Saturday
+	assert.False(t, window.Contains(time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)))
+	// time in other time zone is converted into UTC
+	assert.True(t, window.Contains(time.Date(2024, 1, 1, 3, 0, 0, 0, time.FixedZone("CET", 3600))))
+}
+
+// TestMaintenanceWindowSpanningMidnight checks window that ends on the next
+// day
+func TestMaintenanceWindowSpanningMidnight(t *testing.T) {
+	window, err := cleaner.ParseMaintenanceWindow(cleaner.MaintenanceWindowConfiguration{
+		Start: "22:00",
+		End:   "02:00",
+		Days:  []string{"Fri"},
+	})
+	assert.NoError(t, err)
+
+	// 2024-01-05 is Friday
+	assert.True(t, window.Contains(time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, window.Contains(time.Date(2024, 1, 6, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2024, 1, 5, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)))
+}
+
+// TestIsDestructiveOperation checks which operations are destructive
+func TestIsDestructiveOperation(t *testing.T) {
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{PerformCleanup: true, DryRun: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{VacuumFull: "report"}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{OrgBatch: "orgs.txt", DryRun: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{PerformCleanupAll: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ExportConsumerErrors: true, DeleteExported: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ValidatePayloads: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{Watch: "requests", DryRun: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{InstallDBSchedule: "0 3 * * *"}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{UninstallDBSchedule: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ImportState: "state.json"}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{FillInDatabase: true}))
+	assert.True(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{InitSchema: true, CountOnly: true}))
+
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{PerformCleanupAll: true, DryRun: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ExportConsumerErrors: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ValidatePayloads: true, DryRun: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{CountOnly: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{VacuumDatabase: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ShowDBSchedule: true}))
+	assert.False(t, cleaner.IsDestructiveOperation(cleaner.CliFlags{ShowVersion: true, PerformCleanup: true}))
+}
+
+// TestCheckMaintenanceWindow checks that destructive operations are refused
+// outside maintenance window unless forced
+func TestCheckMaintenanceWindow(t *testing.T) {
+	inside := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	outside := time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)
+	cleanupFlags := cleaner.CliFlags{PerformCleanup: true}
+
+	assert.NoError(t, cleaner.CheckMaintenanceWindow(weekdaysWindow, cleanupFlags, inside))
+	assert.ErrorIs(t, cleaner.CheckMaintenanceWindow(weekdaysWindow, cleanupFlags, outside),
+		cleaner.ErrOutsideWindow)
+
+	// forced operation
+	assert.NoError(t, cleaner.CheckMaintenanceWindow(weekdaysWindow,
+		cleaner.CliFlags{PerformCleanup: true, Force: true}, outside))
+
+	// installed pg_cron jobs delete data, so they are installed in the
+	// window only, the same applies to other administrative operations
+	assert.NoError(t, cleaner.CheckMaintenanceWindow(weekdaysWindow,
+		cleaner.CliFlags{InstallDBSchedule: "0 3 * * *"}, inside))
+	assert.ErrorIs(t, cleaner.CheckMaintenanceWindow(weekdaysWindow,
+		cleaner.CliFlags{InstallDBSchedule: "0 3 * * *"}, outside), cleaner.ErrOutsideWindow)
+	assert.ErrorIs(t, cleaner.CheckMaintenanceWindow(weekdaysWindow,
+		cleaner.CliFlags{ImportState: "state.json"}, outside), cleaner.ErrOutsideWindow)
+
+	// listing is allowed anytime
+	assert.NoError(t, cleaner.CheckMaintenanceWindow(weekdaysWindow, cleaner.CliFlags{}, outside))
+
+	// window is not configured
+	assert.NoError(t, cleaner.CheckMaintenanceWindow(cleaner.MaintenanceWindowConfiguration{},
+		cleanupFlags, outside))
+}
+
+// TestDoSelectedOperationOutsideWindow checks that cleanup is not started
+// outside maintenance window
+func TestDoSelectedOperationOutsideWindow(t *testing.T) {
+	// window that is surely closed now
+	now := time.Now().UTC()
+	configuration := cleaner.ConfigStruct{
+		Window: cleaner.MaintenanceWindowConfiguration{
+			Start: now.Add(2 * time.Hour).Format("15:04"),
+			End:   now.Add(3 * time.Hour).Format("15:04"),
+		},
+	}
+
+	status, err := cleaner.DoSelectedOperation(&configuration, nil, cleaner.CliFlags{PerformCleanup: true})
+	assert.ErrorIs(t, err, cleaner.ErrOutsideWindow)
+	assert.Equal(t, cleaner.ExitStatusOutsideWindow, status)
+}